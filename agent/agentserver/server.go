@@ -29,6 +29,7 @@ import (
 	"github.com/uber/kraken/lib/middleware"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/torrent/scheduler"
+	"github.com/uber/kraken/lib/torrent/scheduler/conn"
 	"github.com/uber/kraken/utils/handler"
 	"github.com/uber/kraken/utils/httputil"
 
@@ -80,14 +81,24 @@ func (s *Server) Handler() http.Handler {
 
 	r.Delete("/blobs/{digest}", handler.Wrap(s.deleteBlobHandler))
 
+	r.Post("/blobs/{digest}/pause", handler.Wrap(s.pauseBlobHandler))
+	r.Post("/blobs/{digest}/resume", handler.Wrap(s.resumeBlobHandler))
+
 	// Preheat/preload endpoints.
 	r.Get("/preload/tags/{tag}", handler.Wrap(s.preloadTagHandler))
 
 	// Dangerous endpoint for running experiments.
 	r.Patch("/x/config/scheduler", handler.Wrap(s.patchSchedulerConfigHandler))
 
+	r.Get("/x/config/bandwidth", handler.Wrap(s.getBandwidthHandler))
+	r.Patch("/x/config/bandwidth", handler.Wrap(s.patchBandwidthHandler))
+
 	r.Get("/x/blacklist", handler.Wrap(s.getBlacklistHandler))
 
+	r.Get("/x/cleanup/report", handler.Wrap(s.getCleanupReportHandler))
+
+	r.Get("/x/usage", handler.Wrap(s.getUsageHandler))
+
 	// Serves /debug/pprof endpoints.
 	r.Mount("/", http.DefaultServeMux)
 
@@ -121,10 +132,15 @@ func (s *Server) downloadBlobHandler(w http.ResponseWriter, r *http.Request) err
 	if err != nil {
 		return err
 	}
+	priority, err := scheduler.ParsePriority(
+		httputil.GetQueryArg(r, "priority", scheduler.PriorityInteractive.String()))
+	if err != nil {
+		return handler.Errorf("parse priority: %s", err).Status(http.StatusBadRequest)
+	}
 	f, err := s.cads.Cache().GetFileReader(d.Hex())
 	if err != nil {
 		if os.IsNotExist(err) || s.cads.InDownloadError(err) {
-			if err := s.sched.Download(namespace, d); err != nil {
+			if err := s.sched.DownloadWithPriority(namespace, d, priority); err != nil {
 				if err == scheduler.ErrTorrentNotFound {
 					return handler.ErrorStatus(http.StatusNotFound)
 				}
@@ -155,6 +171,36 @@ func (s *Server) deleteBlobHandler(w http.ResponseWriter, r *http.Request) error
 	return nil
 }
 
+// pauseBlobHandler pauses the download / seed of a blob, preserving progress.
+func (s *Server) pauseBlobHandler(w http.ResponseWriter, r *http.Request) error {
+	d, err := parseDigest(r)
+	if err != nil {
+		return err
+	}
+	if err := s.sched.PauseTorrent(d); err != nil {
+		if err == scheduler.ErrTorrentNotFound {
+			return handler.ErrorStatus(http.StatusNotFound)
+		}
+		return handler.Errorf("pause torrent: %s", err)
+	}
+	return nil
+}
+
+// resumeBlobHandler resumes a blob paused by pauseBlobHandler.
+func (s *Server) resumeBlobHandler(w http.ResponseWriter, r *http.Request) error {
+	d, err := parseDigest(r)
+	if err != nil {
+		return err
+	}
+	if err := s.sched.ResumeTorrent(d); err != nil {
+		if err == scheduler.ErrTorrentNotFound {
+			return handler.ErrorStatus(http.StatusNotFound)
+		}
+		return handler.Errorf("resume torrent: %s", err)
+	}
+	return nil
+}
+
 // preloadTagHandler triggers docker daemon to download specified docker image.
 func (s *Server) preloadTagHandler(w http.ResponseWriter, r *http.Request) error {
 	tag, err := httputil.ParseParam(r, "tag")
@@ -194,6 +240,29 @@ func (s *Server) patchSchedulerConfigHandler(w http.ResponseWriter, r *http.Requ
 	return nil
 }
 
+// getBandwidthHandler returns the bandwidth limits currently in effect.
+func (s *Server) getBandwidthHandler(w http.ResponseWriter, r *http.Request) error {
+	limits := s.sched.BandwidthLimits()
+	if err := json.NewEncoder(w).Encode(&limits); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
+// patchBandwidthHandler replaces the global and per-namespace bandwidth limits
+// with the limits in request body, without restarting the scheduler.
+func (s *Server) patchBandwidthHandler(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	var limits conn.BandwidthLimits
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		return handler.Errorf("json decode: %s", err).Status(http.StatusBadRequest)
+	}
+	if err := s.sched.SetBandwidthLimits(limits); err != nil {
+		return handler.Errorf("set bandwidth limits: %s", err).Status(http.StatusBadRequest)
+	}
+	return nil
+}
+
 func (s *Server) getBlacklistHandler(w http.ResponseWriter, r *http.Request) error {
 	blacklist, err := s.sched.BlacklistSnapshot()
 	if err != nil {
@@ -205,6 +274,30 @@ func (s *Server) getBlacklistHandler(w http.ResponseWriter, r *http.Request) err
 	return nil
 }
 
+// getCleanupReportHandler returns what store cleanup would delete if it ran
+// now, without deleting anything.
+func (s *Server) getCleanupReportHandler(w http.ResponseWriter, r *http.Request) error {
+	reports, err := s.cads.CleanupReport()
+	if err != nil {
+		return handler.Errorf("cleanup report: %s", err)
+	}
+	if err := json.NewEncoder(w).Encode(&reports); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
+func (s *Server) getUsageHandler(w http.ResponseWriter, r *http.Request) error {
+	usage, err := s.cads.Usage()
+	if err != nil {
+		return handler.Errorf("usage: %s", err)
+	}
+	if err := json.NewEncoder(w).Encode(usage); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
 func parseDigest(r *http.Request) (core.Digest, error) {
 	raw, err := httputil.ParseParam(r, "digest")
 	if err != nil {
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"testing"
 	"time"
@@ -29,11 +30,13 @@ import (
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/torrent/scheduler"
+	"github.com/uber/kraken/lib/torrent/scheduler/conn"
 	"github.com/uber/kraken/lib/torrent/scheduler/connstate"
 	mocktagclient "github.com/uber/kraken/mocks/build-index/tagclient"
 	mockdockerdaemon "github.com/uber/kraken/mocks/lib/dockerdaemon"
 	mockscheduler "github.com/uber/kraken/mocks/lib/torrent/scheduler"
 	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/memsize"
 	"github.com/uber/kraken/utils/testutil"
 
 	"github.com/golang/mock/gomock"
@@ -118,8 +121,9 @@ func TestDownload(t *testing.T) {
 	namespace := core.TagFixture()
 	blob := core.NewBlobFixture()
 
-	mocks.sched.EXPECT().Download(namespace, blob.Digest).DoAndReturn(
-		func(namespace string, d core.Digest) error {
+	mocks.sched.EXPECT().DownloadWithPriority(
+		namespace, blob.Digest, scheduler.PriorityInteractive).DoAndReturn(
+		func(namespace string, d core.Digest, priority scheduler.Priority) error {
 			return store.RunDownload(mocks.cads, d, blob.Content)
 		})
 
@@ -142,7 +146,8 @@ func TestDownloadNotFound(t *testing.T) {
 	namespace := core.TagFixture()
 	blob := core.NewBlobFixture()
 
-	mocks.sched.EXPECT().Download(namespace, blob.Digest).Return(scheduler.ErrTorrentNotFound)
+	mocks.sched.EXPECT().DownloadWithPriority(
+		namespace, blob.Digest, scheduler.PriorityInteractive).Return(scheduler.ErrTorrentNotFound)
 
 	addr := mocks.startServer()
 	c := agentclient.New(addr)
@@ -161,7 +166,8 @@ func TestDownloadUnknownError(t *testing.T) {
 	namespace := core.TagFixture()
 	blob := core.NewBlobFixture()
 
-	mocks.sched.EXPECT().Download(namespace, blob.Digest).Return(fmt.Errorf("test error"))
+	mocks.sched.EXPECT().DownloadWithPriority(
+		namespace, blob.Digest, scheduler.PriorityInteractive).Return(fmt.Errorf("test error"))
 
 	addr := mocks.startServer()
 	c := agentclient.New(addr)
@@ -171,6 +177,51 @@ func TestDownloadUnknownError(t *testing.T) {
 	require.True(httputil.IsStatus(err, 500))
 }
 
+func TestDownloadBackgroundPriority(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.NewBlobFixture()
+
+	mocks.sched.EXPECT().DownloadWithPriority(
+		namespace, blob.Digest, scheduler.PriorityBackground).DoAndReturn(
+		func(namespace string, d core.Digest, priority scheduler.Priority) error {
+			return store.RunDownload(mocks.cads, d, blob.Content)
+		})
+
+	addr := mocks.startServer()
+
+	resp, err := httputil.Get(fmt.Sprintf(
+		"http://%s/namespace/%s/blobs/%s?priority=background",
+		addr, url.PathEscape(namespace), blob.Digest))
+	require.NoError(err)
+	defer resp.Body.Close()
+	result, err := ioutil.ReadAll(resp.Body)
+	require.NoError(err)
+	require.Equal(string(blob.Content), string(result))
+}
+
+func TestDownloadInvalidPriority(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.NewBlobFixture()
+
+	addr := mocks.startServer()
+
+	_, err := httputil.Get(fmt.Sprintf(
+		"http://%s/namespace/%s/blobs/%s?priority=urgent",
+		addr, url.PathEscape(namespace), blob.Digest))
+	require.Error(err)
+	require.True(httputil.IsStatus(err, http.StatusBadRequest))
+}
+
 func TestHealthHandler(t *testing.T) {
 	tests := []struct {
 		desc     string
@@ -222,6 +273,76 @@ func TestPatchSchedulerConfigHandler(t *testing.T) {
 	require.NoError(err)
 }
 
+func TestGetBandwidthHandler(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	limits := conn.BandwidthLimits{
+		EgressBitsPerSec:  100 * 8 * memsize.Mbit,
+		IngressBitsPerSec: 200 * 8 * memsize.Mbit,
+		Namespaces: []conn.NamespaceBandwidthConfig{{
+			Namespace:         "foo/.*",
+			EgressBitsPerSec:  10 * 8 * memsize.Mbit,
+			IngressBitsPerSec: 20 * 8 * memsize.Mbit,
+		}},
+	}
+	mocks.sched.EXPECT().BandwidthLimits().Return(limits)
+
+	addr := mocks.startServer()
+
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/x/config/bandwidth", addr))
+	require.NoError(err)
+
+	var result conn.BandwidthLimits
+	require.NoError(json.NewDecoder(resp.Body).Decode(&result))
+	require.Equal(limits, result)
+}
+
+func TestPatchBandwidthHandler(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	addr := mocks.startServer()
+
+	limits := conn.BandwidthLimits{
+		EgressBitsPerSec:  100 * 8 * memsize.Mbit,
+		IngressBitsPerSec: 200 * 8 * memsize.Mbit,
+	}
+	b, err := json.Marshal(limits)
+	require.NoError(err)
+
+	mocks.sched.EXPECT().SetBandwidthLimits(limits).Return(nil)
+
+	_, err = httputil.Patch(
+		fmt.Sprintf("http://%s/x/config/bandwidth", addr),
+		httputil.SendBody(bytes.NewReader(b)))
+	require.NoError(err)
+}
+
+func TestPatchBandwidthHandlerInvalidLimits(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	addr := mocks.startServer()
+
+	limits := conn.BandwidthLimits{EgressBitsPerSec: 1}
+	b, err := json.Marshal(limits)
+	require.NoError(err)
+
+	mocks.sched.EXPECT().SetBandwidthLimits(limits).Return(errors.New("some error"))
+
+	_, err = httputil.Patch(
+		fmt.Sprintf("http://%s/x/config/bandwidth", addr),
+		httputil.SendBody(bytes.NewReader(b)))
+	require.True(httputil.IsStatus(err, http.StatusBadRequest))
+}
+
 func TestGetBlacklistHandler(t *testing.T) {
 	require := require.New(t)
 
@@ -245,6 +366,50 @@ func TestGetBlacklistHandler(t *testing.T) {
 	require.Equal(blacklist, result)
 }
 
+func TestGetCleanupReportHandler(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	name := core.DigestFixture().Hex()
+	require.NoError(mocks.cads.CreateDownloadFile(name, 5))
+
+	addr := mocks.startServer()
+
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/x/cleanup/report", addr))
+	require.NoError(err)
+
+	var result []store.CleanupReport
+	require.NoError(json.NewDecoder(resp.Body).Decode(&result))
+	require.Len(result, 2)
+	require.Equal("cache", result[0].Job)
+	require.Equal("download", result[1].Job)
+	require.Equal(int64(5), result[1].Usage)
+	require.Empty(result[1].Entries)
+}
+
+func TestGetUsageHandler(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	name := core.DigestFixture().Hex()
+	require.NoError(mocks.cads.CreateDownloadFile(name, 5))
+
+	addr := mocks.startServer()
+
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/x/usage", addr))
+	require.NoError(err)
+
+	var result store.Usage
+	require.NoError(json.NewDecoder(resp.Body).Decode(&result))
+	require.Equal(store.UsageStat{Files: 1, Bytes: 5}, result.States["download"])
+	require.Equal(store.UsageStat{}, result.States["cache"])
+	require.Equal(store.UsageStat{Files: 1, Bytes: 5}, result.Namespaces[""])
+}
+
 func TestDeleteBlobHandler(t *testing.T) {
 	require := require.New(t)
 
@@ -261,6 +426,45 @@ func TestDeleteBlobHandler(t *testing.T) {
 	require.NoError(err)
 }
 
+func TestPauseAndResumeBlobHandlers(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	d := core.DigestFixture()
+
+	addr := mocks.startServer()
+
+	gomock.InOrder(
+		mocks.sched.EXPECT().PauseTorrent(d).Return(nil),
+		mocks.sched.EXPECT().ResumeTorrent(d).Return(nil),
+	)
+
+	_, err := httputil.Post(fmt.Sprintf("http://%s/blobs/%s/pause", addr, d))
+	require.NoError(err)
+
+	_, err = httputil.Post(fmt.Sprintf("http://%s/blobs/%s/resume", addr, d))
+	require.NoError(err)
+}
+
+func TestPauseBlobHandlerNotFound(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	d := core.DigestFixture()
+
+	addr := mocks.startServer()
+
+	mocks.sched.EXPECT().PauseTorrent(d).Return(scheduler.ErrTorrentNotFound)
+
+	_, err := httputil.Post(fmt.Sprintf("http://%s/blobs/%s/pause", addr, d))
+	require.Error(err)
+	require.True(httputil.IsNotFound(err))
+}
+
 func TestPreloadHandler(t *testing.T) {
 	require := require.New(t)
 
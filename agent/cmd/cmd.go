@@ -25,11 +25,14 @@ import (
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/dockerdaemon"
 	"github.com/uber/kraken/lib/dockerregistry/transfer"
+	"github.com/uber/kraken/lib/healthcheck"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler"
+	"github.com/uber/kraken/lib/upstream"
 	"github.com/uber/kraken/metrics"
 	"github.com/uber/kraken/nginx"
+	"github.com/uber/kraken/origin/blobclient"
 	"github.com/uber/kraken/utils/configutil"
 	"github.com/uber/kraken/utils/log"
 	"github.com/uber/kraken/utils/netutil"
@@ -46,6 +49,7 @@ type Flags struct {
 	AgentRegistryPort int
 	ConfigFile        string
 	Zone              string
+	Rack              string
 	KrakenCluster     string
 	SecretsFile       string
 }
@@ -65,6 +69,8 @@ func ParseFlags() *Flags {
 		&flags.ConfigFile, "config", "", "configuration file path")
 	flag.StringVar(
 		&flags.Zone, "zone", "", "zone/datacenter name")
+	flag.StringVar(
+		&flags.Rack, "rack", "", "rack name, used to prefer nearby peers")
 	flag.StringVar(
 		&flags.KrakenCluster, "cluster", "", "cluster name (e.g. prod01-zone1)")
 	flag.StringVar(
@@ -161,6 +167,7 @@ func Run(flags *Flags, opts ...Option) {
 	if err != nil {
 		log.Fatalf("Failed to create peer context: %s", err)
 	}
+	pctx.Rack = flags.Rack
 
 	cads, err := store.NewCADownloadStore(config.CADownloadStore, stats)
 	if err != nil {
@@ -183,8 +190,30 @@ func Run(flags *Flags, opts ...Option) {
 		log.Fatalf("Error building client tls config: %s", err)
 	}
 
+	// Origins are only required for webseeding and SLO fallback.
+	var webseed blobclient.ClusterClient
+	if config.Scheduler.Dispatch.Webseed.Enabled || config.Scheduler.Dispatch.SLO.Enabled {
+		origins, err := config.Origin.Build(upstream.WithHealthCheck(healthcheck.Default(tls)))
+		if err != nil {
+			log.Fatalf("Error building origin host list: %s", err)
+		}
+		webseed = blobclient.NewClusterClient(
+			blobclient.NewClientResolver(blobclient.NewProvider(blobclient.WithTLS(tls)), origins))
+	}
+
 	sched, err := scheduler.NewAgentScheduler(
-		config.Scheduler, stats, pctx, cads, netevents, trackers, tls)
+		config.Scheduler,
+		config.TorrentArchiveBackend,
+		config.TorrentArchive,
+		config.MetaInfoClient,
+		stats,
+		pctx,
+		cads,
+		netevents,
+		trackers,
+		tls,
+		config.TrackerToken,
+		webseed)
 	if err != nil {
 		log.Fatalf("Error creating scheduler: %s", err)
 	}
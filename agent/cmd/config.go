@@ -24,6 +24,7 @@ import (
 	"github.com/uber/kraken/lib/upstream"
 	"github.com/uber/kraken/metrics"
 	"github.com/uber/kraken/nginx"
+	"github.com/uber/kraken/tracker/metainfoclient"
 	"github.com/uber/kraken/utils/httputil"
 
 	"go.uber.org/zap"
@@ -31,19 +32,24 @@ import (
 
 // Config defines agent configuration.
 type Config struct {
-	ZapLogging      zap.Config                     `yaml:"zap"`
-	Metrics         metrics.Config                 `yaml:"metrics"`
-	CADownloadStore store.CADownloadStoreConfig    `yaml:"store"`
-	Registry        dockerregistry.Config          `yaml:"registry"`
-	Scheduler       scheduler.Config               `yaml:"scheduler"`
-	PeerIDFactory   core.PeerIDFactory             `yaml:"peer_id_factory"`
-	NetworkEvent    networkevent.Config            `yaml:"network_event"`
-	Tracker         upstream.PassiveHashRingConfig `yaml:"tracker"`
-	BuildIndex      upstream.PassiveConfig         `yaml:"build_index"`
-	AgentServer     agentserver.Config             `yaml:"agentserver"`
-	RegistryBackup  string                         `yaml:"registry_backup"`
-	Nginx           nginx.Config                   `yaml:"nginx"`
-	TLS             httputil.TLSConfig             `yaml:"tls"`
-	AllowedCidrs    []string                       `yaml:"allowed_cidrs"`
-	DockerDaemon    dockerdaemon.Config            `yaml:docker_daemon`
+	ZapLogging            zap.Config                     `yaml:"zap"`
+	Metrics               metrics.Config                 `yaml:"metrics"`
+	CADownloadStore       store.CADownloadStoreConfig    `yaml:"store"`
+	Registry              dockerregistry.Config          `yaml:"registry"`
+	Scheduler             scheduler.Config               `yaml:"scheduler"`
+	TorrentArchiveBackend string                         `yaml:"torrent_archive_backend"`
+	TorrentArchive        interface{}                    `yaml:"torrent_archive"`
+	MetaInfoClient        metainfoclient.Config          `yaml:"metainfo_client"`
+	PeerIDFactory         core.PeerIDFactory             `yaml:"peer_id_factory"`
+	NetworkEvent          networkevent.Config            `yaml:"network_event"`
+	Tracker               upstream.PassiveHashRingConfig `yaml:"tracker"`
+	TrackerToken          string                         `yaml:"tracker_token"`
+	Origin                upstream.ActiveConfig          `yaml:"origin"`
+	BuildIndex            upstream.PassiveConfig         `yaml:"build_index"`
+	AgentServer           agentserver.Config             `yaml:"agentserver"`
+	RegistryBackup        string                         `yaml:"registry_backup"`
+	Nginx                 nginx.Config                   `yaml:"nginx"`
+	TLS                   httputil.TLSConfig             `yaml:"tls"`
+	AllowedCidrs          []string                       `yaml:"allowed_cidrs"`
+	DockerDaemon          dockerdaemon.Config            `yaml:docker_daemon`
 }
@@ -373,11 +373,13 @@ func (s *Server) getOriginHandler(w http.ResponseWriter, r *http.Request) error
 }
 
 func (s *Server) putTag(tag string, d core.Digest, deps core.DigestList) error {
+	found, err := s.localOriginClient.StatBatch(tag, deps)
+	if err != nil {
+		return handler.Errorf("check blobs: %s", err)
+	}
 	for _, dep := range deps {
-		if _, err := s.localOriginClient.Stat(tag, dep); err == blobclient.ErrBlobNotFound {
+		if _, ok := found[dep]; !ok {
 			return handler.Errorf("cannot upload tag, missing dependency %s", dep)
-		} else if err != nil {
-			return handler.Errorf("check blob: %s", err)
 		}
 	}
 
@@ -165,7 +165,8 @@ func TestPut(t *testing.T) {
 	neighborClient := mocktagclient.NewMockClient(mocks.ctrl)
 
 	mocks.depResolver.EXPECT().Resolve(tag, digest).Return(core.DigestList{digest}, nil)
-	mocks.originClient.EXPECT().Stat(tag, digest).Return(core.NewBlobInfo(256), nil)
+	mocks.originClient.EXPECT().StatBatch(tag, []core.Digest{digest}).Return(
+		map[core.Digest]*core.BlobInfo{digest: core.NewBlobInfo(256)}, nil)
 	mocks.store.EXPECT().Put(tag, digest, time.Duration(0)).Return(nil)
 	mocks.provider.EXPECT().Provide(_testNeighbor).Return(neighborClient)
 	neighborClient.EXPECT().DuplicatePut(
@@ -475,7 +476,8 @@ func TestPutAndReplicate(t *testing.T) {
 
 	gomock.InOrder(
 		mocks.depResolver.EXPECT().Resolve(tag, digest).Return(core.DigestList{digest}, nil),
-		mocks.originClient.EXPECT().Stat(tag, digest).Return(core.NewBlobInfo(256), nil),
+		mocks.originClient.EXPECT().StatBatch(tag, []core.Digest{digest}).Return(
+			map[core.Digest]*core.BlobInfo{digest: core.NewBlobInfo(256)}, nil),
 		mocks.store.EXPECT().Put(tag, digest, time.Duration(0)).Return(nil),
 		mocks.provider.EXPECT().Provide(_testNeighbor).Return(neighborClient),
 		neighborClient.EXPECT().DuplicatePut(
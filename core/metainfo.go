@@ -43,6 +43,14 @@ func (info *info) Hash() (InfoHash, error) {
 	return NewInfoHashFromBytes(b.Bytes()), nil
 }
 
+// ErrMetaInfoZeroLengthWithPieces occurs when a MetaInfo declares zero length
+// but lists piece sums.
+var ErrMetaInfoZeroLengthWithPieces = errors.New("metainfo has zero length but non-zero pieces")
+
+// ErrMetaInfoLengthWithoutPieces occurs when a MetaInfo declares non-zero length
+// but lists no piece sums.
+var ErrMetaInfoLengthWithoutPieces = errors.New("metainfo has non-zero length but zero pieces")
+
 // MetaInfo contains torrent metadata.
 type MetaInfo struct {
 	info     info
@@ -99,29 +107,51 @@ func (mi *MetaInfo) NumPieces() int {
 }
 
 // PieceLength returns the piece length used to break up the original blob. Note,
-// the final piece may be shorter than this. Use GetPieceLength for the true
+// the final piece may be shorter than this. Use PieceSize for the true
 // lengths of each piece.
 func (mi *MetaInfo) PieceLength() int64 {
 	return mi.info.PieceLength
 }
 
-// GetPieceLength returns the length of piece i.
-func (mi *MetaInfo) GetPieceLength(i int) int64 {
-	if i < 0 || i >= len(mi.info.PieceSums) {
+// PieceSize returns the length of piece i. Every piece is PieceLength long
+// except the final piece, which holds the remainder of the blob and may be
+// shorter. Returns 0 if i is out of bounds. All piece size math should go
+// through PieceSize.
+func (mi *MetaInfo) PieceSize(i int) int64 {
+	n := len(mi.info.PieceSums)
+	if i < 0 || i >= n {
 		return 0
 	}
-	if i == len(mi.info.PieceSums)-1 {
-		// Last piece.
+	if i == n-1 {
+		// Final piece.
 		return mi.info.Length - mi.info.PieceLength*int64(i)
 	}
 	return mi.info.PieceLength
 }
 
+// PieceOffset returns the offset of piece i within the blob. Does not check
+// bounds.
+func (mi *MetaInfo) PieceOffset(i int) int64 {
+	return mi.info.PieceLength * int64(i)
+}
+
 // GetPieceSum returns the checksum of piece i. Does not check bounds.
 func (mi *MetaInfo) GetPieceSum(i int) uint32 {
 	return mi.info.PieceSums[i]
 }
 
+// Validate checks that the length and pieces of mi are consistent. Inconsistent
+// metainfo describes a torrent which can never complete.
+func (mi *MetaInfo) Validate() error {
+	if mi.info.Length == 0 && len(mi.info.PieceSums) > 0 {
+		return ErrMetaInfoZeroLengthWithPieces
+	}
+	if mi.info.Length > 0 && len(mi.info.PieceSums) == 0 {
+		return ErrMetaInfoLengthWithoutPieces
+	}
+	return nil
+}
+
 // metaInfoJSON is used for serializing / deserializing MetaInfo.
 type metaInfoJSON struct {
 	// Only serialize info for backwards compatibility.
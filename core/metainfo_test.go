@@ -22,29 +22,75 @@ import (
 	"github.com/uber/kraken/utils/memsize"
 )
 
-func TestMetaInfoGetPieceLength(t *testing.T) {
+func TestMetaInfoPieceSize(t *testing.T) {
 	tests := []struct {
 		desc        string
 		size        uint64
 		pieceLength uint64
-		i           int
-		expected    int64
+		expected    []int64
 	}{
-		{"first piece", 10, 3, 0, 3},
-		{"smaller last piece", 10, 3, 3, 1},
-		{"same size last piece", 8, 2, 3, 2},
-		{"middle piece", 10, 3, 1, 3},
-		{"outside bounds", 10, 3, 4, 0},
-		{"negative", 10, 3, -1, 0},
+		{"exact multiple", 8, 2, []int64{2, 2, 2, 2}},
+		{"one byte over multiple", 9, 2, []int64{2, 2, 2, 2, 1}},
+		{"one byte under multiple", 7, 2, []int64{2, 2, 2, 1}},
+		{"smaller final piece", 10, 3, []int64{3, 3, 3, 1}},
+		{"single piece", 3, 4, []int64{3}},
+		{"single exact piece", 4, 4, []int64{4}},
+		{"single byte", 1, 4, []int64{1}},
+		{"zero length", 0, 4, nil},
 	}
 	for _, test := range tests {
 		t.Run(test.desc, func(t *testing.T) {
-			blob := SizedBlobFixture(test.size, test.pieceLength)
-			require.Equal(t, test.expected, blob.MetaInfo.GetPieceLength(test.i))
+			require := require.New(t)
+
+			mi := SizedBlobFixture(test.size, test.pieceLength).MetaInfo
+			require.Equal(len(test.expected), mi.NumPieces())
+
+			var total int64
+			for i, expected := range test.expected {
+				require.Equal(expected, mi.PieceSize(i), "piece %d", i)
+				require.Equal(total, mi.PieceOffset(i), "piece %d", i)
+				total += mi.PieceSize(i)
+			}
+			require.Equal(mi.Length(), total)
+
+			// Out of bounds.
+			require.Equal(int64(0), mi.PieceSize(-1))
+			require.Equal(int64(0), mi.PieceSize(mi.NumPieces()))
+		})
+	}
+}
+
+func TestMetaInfoValidate(t *testing.T) {
+	tests := []struct {
+		desc      string
+		length    int64
+		pieceSums []uint32
+		expected  error
+	}{
+		{"empty", 0, nil, nil},
+		{"consistent", 10, []uint32{1, 2}, nil},
+		{"zero length with pieces", 0, []uint32{1}, ErrMetaInfoZeroLengthWithPieces},
+		{"length without pieces", 10, nil, ErrMetaInfoLengthWithoutPieces},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			mi := MetaInfo{
+				info: info{
+					PieceLength: 5,
+					PieceSums:   test.pieceSums,
+					Length:      test.length,
+				},
+			}
+			require.Equal(t, test.expected, mi.Validate())
 		})
 	}
 }
 
+func TestMetaInfoValidateFixture(t *testing.T) {
+	require.NoError(t, SizedBlobFixture(0, 4).MetaInfo.Validate())
+	require.NoError(t, NewBlobFixture().MetaInfo.Validate())
+}
+
 func TestMetaInfoSerialization(t *testing.T) {
 	require := require.New(t)
 
@@ -32,6 +32,9 @@ type PeerContext struct {
 	// Zone is the zone the peer is running within.
 	Zone string `json:"zone"`
 
+	// Rack is the rack the peer is running within. Optional.
+	Rack string `json:"rack"`
+
 	// Cluster is the Kraken cluster the peer is running within.
 	Cluster string `json:"cluster"`
 
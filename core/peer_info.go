@@ -13,7 +13,20 @@
 // limitations under the License.
 package core
 
-import "sort"
+import (
+	"net"
+	"sort"
+	"strconv"
+)
+
+// AddressFamily is the IP address family of a peer.
+type AddressFamily string
+
+// Address families.
+const (
+	IPv4 AddressFamily = "ipv4"
+	IPv6 AddressFamily = "ipv6"
+)
 
 // PeerInfo defines peer metadata scoped to a torrent.
 type PeerInfo struct {
@@ -22,6 +35,10 @@ type PeerInfo struct {
 	Port     int    `json:"port"`
 	Origin   bool   `json:"origin"`
 	Complete bool   `json:"complete"`
+
+	// Zone and Rack are optional topology labels of the peer.
+	Zone string `json:"zone,omitempty"`
+	Rack string `json:"rack,omitempty"`
 }
 
 // NewPeerInfo creates a new PeerInfo.
@@ -41,9 +58,27 @@ func NewPeerInfo(
 	}
 }
 
+// Addr returns the "ip:port" address of p, with IPv6 addresses in brackets.
+func (p *PeerInfo) Addr() string {
+	return net.JoinHostPort(p.IP, strconv.Itoa(p.Port))
+}
+
+// Family returns the address family of p's IP. Defaults to IPv4 if the IP
+// cannot be parsed, e.g. if it is a hostname.
+func (p *PeerInfo) Family() AddressFamily {
+	ip := net.ParseIP(p.IP)
+	if ip != nil && ip.To4() == nil {
+		return IPv6
+	}
+	return IPv4
+}
+
 // PeerInfoFromContext derives PeerInfo from a PeerContext.
 func PeerInfoFromContext(pctx PeerContext, complete bool) *PeerInfo {
-	return NewPeerInfo(pctx.PeerID, pctx.IP, pctx.Port, pctx.Origin, complete)
+	p := NewPeerInfo(pctx.PeerID, pctx.IP, pctx.Port, pctx.Origin, complete)
+	p.Zone = pctx.Zone
+	p.Rack = pctx.Rack
+	return p
 }
 
 // PeerInfos groups PeerInfo structs for sorting.
@@ -30,3 +30,23 @@ func TestSortedByPeerID(t *testing.T) {
 	require.True(sorted[0].PeerID.LessThan(sorted[1].PeerID))
 	require.True(sorted[1].PeerID.LessThan(sorted[2].PeerID))
 }
+
+func TestPeerInfoAddr(t *testing.T) {
+	tests := []struct {
+		ip     string
+		addr   string
+		family AddressFamily
+	}{
+		{"10.0.0.1", "10.0.0.1:8080", IPv4},
+		{"2001:db8::1", "[2001:db8::1]:8080", IPv6},
+		{"::ffff:10.0.0.1", "[::ffff:10.0.0.1]:8080", IPv4},
+		{"localhost", "localhost:8080", IPv4},
+	}
+	for _, test := range tests {
+		t.Run(test.ip, func(t *testing.T) {
+			p := NewPeerInfo(PeerIDFixture(), test.ip, 8080, false, false)
+			require.Equal(t, test.addr, p.Addr())
+			require.Equal(t, test.family, p.Family())
+		})
+	}
+}
@@ -30,6 +30,8 @@ It has these top-level messages:
 	ErrorMessage
 	CompleteMessage
 	Message
+	GossipPeer
+	PexMessage
 */
 package p2p
 
@@ -76,6 +78,7 @@ const (
 	Message_CANCEL_PIECE  Message_Type = 4
 	Message_ERROR         Message_Type = 5
 	Message_COMPLETE      Message_Type = 6
+	Message_PEX           Message_Type = 7
 )
 
 var Message_Type_name = map[int32]string{
@@ -86,6 +89,7 @@ var Message_Type_name = map[int32]string{
 	4: "CANCEL_PIECE",
 	5: "ERROR",
 	6: "COMPLETE",
+	7: "PEX",
 }
 var Message_Type_value = map[string]int32{
 	"BITFIELD":      0,
@@ -95,6 +99,7 @@ var Message_Type_value = map[string]int32{
 	"CANCEL_PIECE":  4,
 	"ERROR":         5,
 	"COMPLETE":      6,
+	"PEX":           7,
 }
 
 func (x Message_Type) String() string {
@@ -122,6 +127,24 @@ type BitfieldMessage struct {
 	// remoteBitfieldBytes contains the binary sets of pieces downloaded of
 	// all peers that the sender is currently connected to.
 	RemoteBitfieldBytes map[string][]byte `protobuf:"bytes,7,rep,name=remoteBitfieldBytes" json:"remoteBitfieldBytes,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// encryption negotiates encryption of the connection. The opener sends its
+	// encryption mode, and the acceptor replies "tls" if the connection will be
+	// upgraded to TLS after the handshake.
+	Encryption string `protobuf:"bytes,8,opt,name=encryption" json:"encryption,omitempty"`
+	// gossipPeers contains peers which the sender knows to have the torrent,
+	// allowing peers to be discovered without the tracker.
+	GossipPeers []*GossipPeer `protobuf:"bytes,9,rep,name=gossipPeers" json:"gossipPeers,omitempty"`
+	// haveAll and haveNone replace bitfieldBytes when the sender has all or
+	// none of the numPieces pieces of the torrent.
+	HaveAll   bool  `protobuf:"varint,10,opt,name=haveAll" json:"haveAll,omitempty"`
+	HaveNone  bool  `protobuf:"varint,11,opt,name=haveNone" json:"haveNone,omitempty"`
+	NumPieces int32 `protobuf:"varint,12,opt,name=numPieces" json:"numPieces,omitempty"`
+	// compactBitfields is set by peers which understand haveAll and haveNone.
+	CompactBitfields bool `protobuf:"varint,13,opt,name=compactBitfields" json:"compactBitfields,omitempty"`
+	// protocolVersion and capabilities allow new features to be rolled out
+	// across peers of mixed versions. Peers which predate them send neither.
+	ProtocolVersion int32  `protobuf:"varint,14,opt,name=protocolVersion" json:"protocolVersion,omitempty"`
+	Capabilities    uint64 `protobuf:"varint,15,opt,name=capabilities" json:"capabilities,omitempty"`
 }
 
 func (m *BitfieldMessage) Reset()                    { *m = BitfieldMessage{} }
@@ -136,6 +159,13 @@ func (m *BitfieldMessage) GetRemoteBitfieldBytes() map[string][]byte {
 	return nil
 }
 
+func (m *BitfieldMessage) GetGossipPeers() []*GossipPeer {
+	if m != nil {
+		return m.GossipPeers
+	}
+	return nil
+}
+
 // Requests a piece of the given index. Note: offset and length are unused fields
 // and if set, will be rejected.
 type PieceRequestMessage struct {
@@ -216,6 +246,7 @@ type Message struct {
 	CancelPiece   *CancelPieceMessage   `protobuf:"bytes,7,opt,name=cancelPiece" json:"cancelPiece,omitempty"`
 	Error         *ErrorMessage         `protobuf:"bytes,8,opt,name=error" json:"error,omitempty"`
 	Complete      *CompleteMessage      `protobuf:"bytes,9,opt,name=complete" json:"complete,omitempty"`
+	Pex           *PexMessage           `protobuf:"bytes,10,opt,name=pex" json:"pex,omitempty"`
 }
 
 func (m *Message) Reset()                    { *m = Message{} }
@@ -272,6 +303,45 @@ func (m *Message) GetComplete() *CompleteMessage {
 	return nil
 }
 
+func (m *Message) GetPex() *PexMessage {
+	if m != nil {
+		return m.Pex
+	}
+	return nil
+}
+
+// Address of a peer learned via gossip.
+type GossipPeer struct {
+	PeerID string `protobuf:"bytes,1,opt,name=peerID" json:"peerID,omitempty"`
+	Ip     string `protobuf:"bytes,2,opt,name=ip" json:"ip,omitempty"`
+	Port   int32  `protobuf:"varint,3,opt,name=port" json:"port,omitempty"`
+	Origin bool   `protobuf:"varint,4,opt,name=origin" json:"origin,omitempty"`
+	Zone   string `protobuf:"bytes,5,opt,name=zone" json:"zone,omitempty"`
+	Rack   string `protobuf:"bytes,6,opt,name=rack" json:"rack,omitempty"`
+}
+
+func (m *GossipPeer) Reset()                    { *m = GossipPeer{} }
+func (m *GossipPeer) String() string            { return proto.CompactTextString(m) }
+func (*GossipPeer) ProtoMessage()               {}
+func (*GossipPeer) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+// Shares peers which the sender is connected to for the torrent.
+type PexMessage struct {
+	Peers []*GossipPeer `protobuf:"bytes,1,rep,name=peers" json:"peers,omitempty"`
+}
+
+func (m *PexMessage) Reset()                    { *m = PexMessage{} }
+func (m *PexMessage) String() string            { return proto.CompactTextString(m) }
+func (*PexMessage) ProtoMessage()               {}
+func (*PexMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *PexMessage) GetPeers() []*GossipPeer {
+	if m != nil {
+		return m.Peers
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*BitfieldMessage)(nil), "p2p.BitfieldMessage")
 	proto.RegisterType((*PieceRequestMessage)(nil), "p2p.PieceRequestMessage")
@@ -281,6 +351,8 @@ func init() {
 	proto.RegisterType((*ErrorMessage)(nil), "p2p.ErrorMessage")
 	proto.RegisterType((*CompleteMessage)(nil), "p2p.CompleteMessage")
 	proto.RegisterType((*Message)(nil), "p2p.Message")
+	proto.RegisterType((*GossipPeer)(nil), "p2p.GossipPeer")
+	proto.RegisterType((*PexMessage)(nil), "p2p.PexMessage")
 	proto.RegisterEnum("p2p.ErrorMessage_ErrorCode", ErrorMessage_ErrorCode_name, ErrorMessage_ErrorCode_value)
 	proto.RegisterEnum("p2p.Message_Type", Message_Type_name, Message_Type_value)
 }
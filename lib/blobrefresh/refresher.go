@@ -23,6 +23,7 @@ import (
 	"github.com/uber/kraken/lib/backend/backenderrors"
 	"github.com/uber/kraken/lib/metainfogen"
 	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/store/metadata"
 	"github.com/uber/kraken/utils/dedup"
 	"github.com/uber/kraken/utils/log"
 
@@ -136,7 +137,13 @@ func (r *Refresher) Refresh(namespace string, d core.Digest, hooks ...PostHook)
 
 func (r *Refresher) download(client backend.Client, namespace string, d core.Digest) error {
 	name := d.Hex()
-	return r.cas.WriteCacheFile(name, func(w store.FileReadWriter) error {
+	if err := r.cas.WriteCacheFile(name, func(w store.FileReadWriter) error {
 		return client.Download(namespace, name, w)
-	})
+	}); err != nil {
+		return err
+	}
+	if _, err := r.cas.SetCacheFileMetadata(name, metadata.NewNamespace(namespace)); err != nil {
+		return fmt.Errorf("set namespace metadata: %s", err)
+	}
+	return nil
 }
@@ -98,6 +98,10 @@ func TestRefresh(t *testing.T) {
 	var tm metadata.TorrentMeta
 	require.NoError(mocks.cas.GetCacheFileMetadata(blob.Digest.Hex(), &tm))
 	require.Equal(blob.MetaInfo, tm.MetaInfo)
+
+	var ns metadata.Namespace
+	require.NoError(mocks.cas.GetCacheFileMetadata(blob.Digest.Hex(), &ns))
+	require.Equal(namespace, ns.Value)
 }
 
 func TestRefreshSizeLimitError(t *testing.T) {
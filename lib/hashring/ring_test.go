@@ -140,6 +140,27 @@ func TestRingLocationsReturnsNextHealthyHostWhenReplicaSetUnhealthy(t *testing.T
 	}
 }
 
+func TestRingReplicaLocations(t *testing.T) {
+	require := require.New(t)
+
+	r := New(
+		Config{MaxReplica: 3},
+		hostlist.Fixture(addrsFixture(10)...),
+		healthcheck.IdentityFilter{})
+
+	d := core.DigestFixture()
+
+	locs := r.Locations(d)
+	require.Len(locs, 3)
+
+	// Replica sets of any size share the same ordering.
+	require.Equal(locs[:1], r.ReplicaLocations(d, 1))
+	require.Equal(locs, r.ReplicaLocations(d, 3))
+	five := r.ReplicaLocations(d, 5)
+	require.Len(five, 5)
+	require.Equal(locs, five[:3])
+}
+
 func TestRingLocationsReturnsFirstHostWhenAllHostsUnhealthy(t *testing.T) {
 	require := require.New(t)
 
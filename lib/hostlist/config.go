@@ -114,5 +114,5 @@ func (r *dnsResolver) resolve() (stringset.Set, error) {
 }
 
 func (r *dnsResolver) String() string {
-	return fmt.Sprintf("%s:%d", r.dns, r.port)
+	return net.JoinHostPort(r.dns, strconv.Itoa(r.port))
 }
@@ -15,6 +15,7 @@ package metainfogen
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/store"
@@ -28,7 +29,7 @@ type Generator struct {
 	cas               *store.CAStore
 }
 
-// New creates a new Generator.
+// New creates a new Generator. cas may be nil if only FromReader is used.
 func New(config Config, cas *store.CAStore) (*Generator, error) {
 	plConfig, err := newPieceLengthConfig(config.PieceLengths)
 	if err != nil {
@@ -57,3 +58,20 @@ func (g *Generator) Generate(d core.Digest) error {
 	}
 	return nil
 }
+
+// FromReader generates metainfo for the blob of d, of the given size, from r.
+// Returns an error if r does not match d.
+func (g *Generator) FromReader(d core.Digest, r io.Reader, size int64) (*core.MetaInfo, error) {
+	digester := core.NewDigester()
+	mi, err := core.NewMetaInfo(d, digester.Tee(r), g.pieceLengthConfig.get(size))
+	if err != nil {
+		return nil, fmt.Errorf("create metainfo: %s", err)
+	}
+	if actual := digester.Digest(); actual != d {
+		return nil, fmt.Errorf("digest mismatch: expected %s, got %s", d, actual)
+	}
+	if mi.Length() != size {
+		return nil, fmt.Errorf("size mismatch: expected %d, got %d", size, mi.Length())
+	}
+	return mi, nil
+}
@@ -50,3 +50,31 @@ func TestGenerate(t *testing.T) {
 	require.NoError(cas.GetCacheFileMetadata(blob.Digest.Hex(), &tm))
 	require.Equal(blob.MetaInfo, tm.MetaInfo)
 }
+
+func TestFromReader(t *testing.T) {
+	require := require.New(t)
+
+	pieceLength := 10
+
+	generator := Fixture(nil, pieceLength)
+
+	blob := core.SizedBlobFixture(100, uint64(pieceLength))
+
+	mi, err := generator.FromReader(blob.Digest, bytes.NewReader(blob.Content), int64(len(blob.Content)))
+	require.NoError(err)
+	require.Equal(blob.MetaInfo, mi)
+}
+
+func TestFromReaderDigestMismatch(t *testing.T) {
+	require := require.New(t)
+
+	pieceLength := 10
+
+	generator := Fixture(nil, pieceLength)
+
+	blob := core.SizedBlobFixture(100, uint64(pieceLength))
+	other := core.SizedBlobFixture(100, uint64(pieceLength))
+
+	_, err := generator.FromReader(blob.Digest, bytes.NewReader(other.Content), int64(len(other.Content)))
+	require.Error(err)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobreplication
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/origin/blobclient"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/uber-go/tally"
+)
+
+// FileStore defines store operations required for blob replication.
+type FileStore interface {
+	GetCacheFileReader(name string) (store.FileReader, error)
+}
+
+// Executor executes blob replication tasks.
+type Executor struct {
+	stats           tally.Scope
+	fs              FileStore
+	clusterProvider blobclient.ClusterProvider
+}
+
+// NewExecutor creates a new Executor.
+func NewExecutor(
+	stats tally.Scope,
+	fs FileStore,
+	clusterProvider blobclient.ClusterProvider) *Executor {
+
+	stats = stats.Tagged(map[string]string{
+		"module": "blobreplicationexecutor",
+	})
+
+	return &Executor{stats, fs, clusterProvider}
+}
+
+// Name returns the executor name.
+func (e *Executor) Name() string {
+	return "blobreplication"
+}
+
+// Exec uploads the cache file of r's digest to r's remote origin cluster.
+func (e *Executor) Exec(r persistedretry.Task) error {
+	t := r.(*Task)
+	start := time.Now()
+
+	f, err := e.fs.GetCacheFileReader(t.Digest.Hex())
+	if err != nil {
+		if os.IsNotExist(err) {
+			// The blob was already cleaned up, so the remote cluster must
+			// fetch it from its storage backend instead.
+			e.stats.Counter("missing_files").Inc(1)
+			log.With(
+				"namespace", t.Namespace,
+				"digest", t.Digest.Hex(),
+				"dest", t.Destination).Info("Dropping blob replication for missing cache file")
+			return nil
+		}
+		return fmt.Errorf("get file: %s", err)
+	}
+	defer f.Close()
+
+	remote, err := e.clusterProvider.Provide(t.Destination)
+	if err != nil {
+		return fmt.Errorf("remote cluster provider: %s", err)
+	}
+	if err := remote.UploadBlob(t.Namespace, t.Digest, f); err != nil {
+		return fmt.Errorf("upload blob: %s", err)
+	}
+
+	// We don't want to time errors.
+	e.stats.Timer("replicate").Record(time.Since(start))
+	e.stats.Timer("lifetime").Record(time.Since(t.CreatedAt))
+
+	return nil
+}
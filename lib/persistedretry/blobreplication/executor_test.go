@@ -0,0 +1,98 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobreplication
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/mocks/origin/blobclient"
+	"github.com/uber/kraken/utils/mockutil"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+type executorMocks struct {
+	ctrl            *gomock.Controller
+	cas             *store.CAStore
+	clusterProvider *mockblobclient.MockClusterProvider
+}
+
+func newExecutorMocks(t *testing.T) (*executorMocks, func()) {
+	ctrl := gomock.NewController(t)
+	cas, cleanup := store.CAStoreFixture()
+	return &executorMocks{
+		ctrl:            ctrl,
+		cas:             cas,
+		clusterProvider: mockblobclient.NewMockClusterProvider(ctrl),
+	}, func() { ctrl.Finish(); cleanup() }
+}
+
+func (m *executorMocks) new() *Executor {
+	return NewExecutor(tally.NoopScope, m.cas, m.clusterProvider)
+}
+
+func TestExecutorUploadsBlobToRemote(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newExecutorMocks(t)
+	defer cleanup()
+
+	blob := core.NewBlobFixture()
+	require.NoError(mocks.cas.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+
+	task := NewTask(core.TagFixture(), blob.Digest, "remote-origin", 0)
+
+	remote := mockblobclient.NewMockClusterClient(mocks.ctrl)
+	mocks.clusterProvider.EXPECT().Provide(task.Destination).Return(remote, nil)
+	remote.EXPECT().UploadBlob(
+		task.Namespace, blob.Digest, mockutil.MatchReader(blob.Content)).Return(nil)
+
+	require.NoError(mocks.new().Exec(task))
+}
+
+func TestExecutorUploadError(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newExecutorMocks(t)
+	defer cleanup()
+
+	blob := core.NewBlobFixture()
+	require.NoError(mocks.cas.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+
+	task := NewTask(core.TagFixture(), blob.Digest, "remote-origin", 0)
+
+	remote := mockblobclient.NewMockClusterClient(mocks.ctrl)
+	mocks.clusterProvider.EXPECT().Provide(task.Destination).Return(remote, nil)
+	remote.EXPECT().UploadBlob(
+		task.Namespace, blob.Digest, mockutil.MatchReader(blob.Content)).Return(errors.New("some error"))
+
+	require.Error(mocks.new().Exec(task))
+}
+
+func TestExecutorDropsTaskForMissingBlob(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newExecutorMocks(t)
+	defer cleanup()
+
+	task := TaskFixture()
+
+	require.NoError(mocks.new().Exec(task))
+}
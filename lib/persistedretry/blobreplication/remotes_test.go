@@ -0,0 +1,42 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobreplication
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemotesMatch(t *testing.T) {
+	require := require.New(t)
+
+	remotes, err := RemotesConfig{
+		"origin-zone1": []string{"foo/.*"},
+		"origin-zone2": []string{"foo/.*", "bar/.*"},
+	}.Build()
+	require.NoError(err)
+
+	require.ElementsMatch([]string{"origin-zone1", "origin-zone2"}, remotes.Match("foo/x"))
+	require.Equal([]string{"origin-zone2"}, remotes.Match("bar/x"))
+	require.Empty(remotes.Match("baz/x"))
+
+	require.True(remotes.Valid("bar/x", "origin-zone2"))
+	require.False(remotes.Valid("bar/x", "origin-zone1"))
+}
+
+func TestRemotesConfigInvalidRegexp(t *testing.T) {
+	_, err := RemotesConfig{"origin-zone1": []string{"("}}.Build()
+	require.Error(t, err)
+}
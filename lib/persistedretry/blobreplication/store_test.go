@@ -0,0 +1,150 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobreplication
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/localdb"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+type validatorFunc func(namespace, addr string) bool
+
+func (f validatorFunc) Valid(namespace, addr string) bool { return f(namespace, addr) }
+
+func allValid(namespace, addr string) bool { return true }
+
+func newTestStore(db *sqlx.DB, rv RemoteValidator) *Store {
+	s, err := NewStore(db, rv)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func checkTasks(t *testing.T, expected []*Task, result []persistedretry.Task) {
+	t.Helper()
+
+	require.Equal(t, len(expected), len(result))
+
+	for i := 0; i < len(expected); i++ {
+		require.True(t, MatchTask(expected[i]).Matches(result[i]), "task %d: %+v", i, result[i])
+	}
+}
+
+func TestStoreAddPending(t *testing.T) {
+	require := require.New(t)
+
+	db, cleanup := localdb.Fixture()
+	defer cleanup()
+
+	store := newTestStore(db, validatorFunc(allValid))
+
+	task := TaskFixture()
+
+	require.NoError(store.AddPending(task))
+	require.Equal(persistedretry.ErrTaskExists, store.AddPending(task))
+
+	result, err := store.GetPending()
+	require.NoError(err)
+	checkTasks(t, []*Task{task}, result)
+}
+
+func TestStoreMarkFailedAndPending(t *testing.T) {
+	require := require.New(t)
+
+	db, cleanup := localdb.Fixture()
+	defer cleanup()
+
+	store := newTestStore(db, validatorFunc(allValid))
+
+	task := TaskFixture()
+
+	require.NoError(store.AddPending(task))
+	require.NoError(store.MarkFailed(task))
+	require.Equal(1, task.Failures)
+	require.WithinDuration(time.Now(), task.LastAttempt, 5*time.Second)
+
+	result, err := store.GetFailed()
+	require.NoError(err)
+	checkTasks(t, []*Task{task}, result)
+
+	require.NoError(store.MarkPending(task))
+
+	result, err = store.GetPending()
+	require.NoError(err)
+	checkTasks(t, []*Task{task}, result)
+}
+
+func TestStoreMarkNonExistentTask(t *testing.T) {
+	require := require.New(t)
+
+	db, cleanup := localdb.Fixture()
+	defer cleanup()
+
+	store := newTestStore(db, validatorFunc(allValid))
+
+	require.Equal(persistedretry.ErrTaskNotFound, store.MarkPending(TaskFixture()))
+	require.Equal(persistedretry.ErrTaskNotFound, store.MarkFailed(TaskFixture()))
+}
+
+func TestStoreRemove(t *testing.T) {
+	require := require.New(t)
+
+	db, cleanup := localdb.Fixture()
+	defer cleanup()
+
+	store := newTestStore(db, validatorFunc(allValid))
+
+	task := TaskFixture()
+
+	require.NoError(store.AddPending(task))
+	require.NoError(store.Remove(task))
+
+	result, err := store.GetPending()
+	require.NoError(err)
+	require.Empty(result)
+}
+
+func TestStoreDeletesInvalidTasks(t *testing.T) {
+	require := require.New(t)
+
+	db, cleanup := localdb.Fixture()
+	defer cleanup()
+
+	store := newTestStore(db, validatorFunc(allValid))
+
+	valid := TaskFixture()
+	invalid := TaskFixture()
+
+	require.NoError(store.AddPending(valid))
+	require.NoError(store.AddFailed(invalid))
+
+	store = newTestStore(db, validatorFunc(func(namespace, addr string) bool {
+		return addr == valid.Destination
+	}))
+
+	result, err := store.GetPending()
+	require.NoError(err)
+	checkTasks(t, []*Task{valid}, result)
+
+	result, err = store.GetFailed()
+	require.NoError(err)
+	require.Empty(result)
+}
@@ -32,6 +32,11 @@ type Config struct {
 	// Interval at which retries should be polled from storage.
 	PollRetriesInterval time.Duration `yaml:"poll_retries_interval"`
 
+	// Number of failures after which a task is moved to the dead letter queue
+	// and no longer retried. If 0, tasks are retried forever. Requires a Store
+	// which implements DeadLetterStore.
+	MaxFailures int `yaml:"max_failures"`
+
 	// Flags that zero-value channel sizes should not have defaults applied.
 	Testing bool
 }
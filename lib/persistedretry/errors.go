@@ -20,3 +20,7 @@ var (
 	ErrTaskExists   = errors.New("task already exists in store")
 	ErrTaskNotFound = errors.New("task not found")
 )
+
+// ErrDeadLettersUnsupported is returned when dead letters are used with a
+// Store which does not implement DeadLetterStore.
+var ErrDeadLettersUnsupported = errors.New("store does not support dead letters")
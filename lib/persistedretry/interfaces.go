@@ -55,6 +55,22 @@ type Store interface {
 	Find(query interface{}) ([]Task, error)
 }
 
+// DeadLetterStore is implemented by Stores which retain tasks that exhausted
+// their retries, such that they can be inspected and retried or discarded
+// manually.
+type DeadLetterStore interface {
+	Store
+
+	// MarkDead marks an existing task as dead. Dead tasks are never retried.
+	MarkDead(Task) error
+
+	// GetDead returns all dead Tasks.
+	GetDead() ([]Task, error)
+
+	// Revive marks an existing task as pending and resets its failures.
+	Revive(Task) error
+}
+
 // Executor executes tasks.
 type Executor interface {
 	Exec(Task) error
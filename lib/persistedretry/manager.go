@@ -34,6 +34,16 @@ type Manager interface {
 	SyncExec(Task) error
 	Close()
 	Find(query interface{}) ([]Task, error)
+
+	// GetDead returns all tasks which exhausted their retries.
+	GetDead() ([]Task, error)
+
+	// RetryDead moves a dead task back into the retry loop with its failures
+	// reset.
+	RetryDead(Task) error
+
+	// DiscardDead removes a dead task, giving up on it.
+	DiscardDead(Task) error
 }
 
 type queue struct {
@@ -51,6 +61,9 @@ type manager struct {
 	store    Store
 	executor Executor
 
+	// Set if the store supports dead letters.
+	deadLetters DeadLetterStore
+
 	wg sync.WaitGroup
 
 	incoming *queue
@@ -79,6 +92,11 @@ func NewManager(
 		retries:  newQueue(config.RetryBuffer, stats.Counter("retries")),
 		done:     make(chan struct{}),
 	}
+	if dls, ok := store.(DeadLetterStore); ok {
+		m.deadLetters = dls
+	} else if config.MaxFailures > 0 {
+		return nil, ErrDeadLettersUnsupported
+	}
 	if err := m.markPendingTasksAsFailed(); err != nil {
 		return nil, fmt.Errorf("mark pending tasks as failed: %s", err)
 	}
@@ -171,6 +189,44 @@ func (m *manager) Find(query interface{}) ([]Task, error) {
 	return m.store.Find(query)
 }
 
+// GetDead returns all tasks which exhausted their retries.
+func (m *manager) GetDead() ([]Task, error) {
+	if m.deadLetters == nil {
+		return nil, ErrDeadLettersUnsupported
+	}
+	return m.deadLetters.GetDead()
+}
+
+// RetryDead moves a dead task back into the retry loop with its failures
+// reset.
+func (m *manager) RetryDead(t Task) error {
+	if m.closed.Load() {
+		return ErrManagerClosed
+	}
+	if m.deadLetters == nil {
+		return ErrDeadLettersUnsupported
+	}
+	if err := m.deadLetters.Revive(t); err != nil {
+		return fmt.Errorf("revive: %s", err)
+	}
+	if err := m.enqueue(t, m.retries); err != nil {
+		return fmt.Errorf("enqueue: %s", err)
+	}
+	return nil
+}
+
+// DiscardDead removes a dead task, giving up on it.
+func (m *manager) DiscardDead(t Task) error {
+	if m.deadLetters == nil {
+		return ErrDeadLettersUnsupported
+	}
+	if err := m.store.Remove(t); err != nil {
+		return fmt.Errorf("remove: %s", err)
+	}
+	m.stats.Tagged(t.Tags()).Counter("dead_letters_discarded").Inc(1)
+	return nil
+}
+
 func (m *manager) enqueue(t Task, q *queue) error {
 	select {
 	case q.tasks <- t:
@@ -234,6 +290,7 @@ func (m *manager) pollRetries() {
 		log.Errorf("Error getting failed tasks: %s", err)
 		return
 	}
+	m.updateDeadGauge()
 	for _, t := range tasks {
 		if t.Ready() && time.Since(t.GetLastAttempt()) > m.config.RetryInterval {
 			if err := m.retry(t); err != nil {
@@ -243,6 +300,20 @@ func (m *manager) pollRetries() {
 	}
 }
 
+// updateDeadGauge reports the number of dead tasks, which is non-zero while
+// tasks await manual action.
+func (m *manager) updateDeadGauge() {
+	if m.deadLetters == nil {
+		return
+	}
+	tasks, err := m.deadLetters.GetDead()
+	if err != nil {
+		log.Errorf("Error getting dead tasks: %s", err)
+		return
+	}
+	m.stats.Gauge("dead_letters").Update(float64(len(tasks)))
+}
+
 func (m *manager) exec(t Task) error {
 	if err := m.executor.Exec(t); err != nil {
 		if err := m.store.MarkFailed(t); err != nil {
@@ -252,6 +323,14 @@ func (m *manager) exec(t Task) error {
 			"task", t,
 			"failures", t.GetFailures()).Errorf("Task failed: %s", err)
 		m.stats.Tagged(t.Tags()).Counter("task_failures").Inc(1)
+		if m.config.MaxFailures > 0 && t.GetFailures() >= m.config.MaxFailures {
+			if err := m.deadLetters.MarkDead(t); err != nil {
+				return fmt.Errorf("mark task as dead: %s", err)
+			}
+			log.With("task", t).Errorf(
+				"Task exhausted %d retries, moved to dead letters", m.config.MaxFailures)
+			m.stats.Tagged(t.Tags()).Counter("dead_lettered").Inc(1)
+		}
 		return nil
 	}
 	if err := m.store.Remove(t); err != nil {
@@ -24,6 +24,8 @@ import (
 	"github.com/uber-go/tally"
 
 	. "github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/writeback"
+	"github.com/uber/kraken/localdb"
 	"github.com/uber/kraken/mocks/lib/persistedretry"
 )
 
@@ -379,3 +381,90 @@ func TestManagerExec(t *testing.T) {
 
 	require.NoError(m.SyncExec(task))
 }
+
+func TestNewManagerMaxFailuresRequiresDeadLetterStore(t *testing.T) {
+	mocks, cleanup := newManagerMocks(t)
+	defer cleanup()
+
+	mocks.config.MaxFailures = 3
+
+	_, err := mocks.new()
+	require.Equal(t, ErrDeadLettersUnsupported, err)
+}
+
+func TestManagerDeadLetters(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newManagerMocks(t)
+	defer cleanup()
+
+	db, dbCleanup := localdb.Fixture()
+	defer dbCleanup()
+
+	store := writeback.NewStore(db)
+
+	mocks.config.MaxFailures = 2
+	mocks.config.RetryInterval = 5 * time.Millisecond
+	mocks.executor.EXPECT().Name().Return("mock executor")
+	m, err := NewManager(mocks.config, tally.NoopScope, store, mocks.executor)
+	require.NoError(err)
+	defer m.Close()
+
+	task := writeback.TaskFixture()
+
+	mocks.executor.EXPECT().Exec(gomock.Any()).Return(errors.New("some error")).Times(2)
+
+	require.NoError(m.Add(task))
+
+	time.Sleep(100 * time.Millisecond)
+
+	dead, err := m.GetDead()
+	require.NoError(err)
+	require.Len(dead, 1)
+	require.Equal(2, dead[0].GetFailures())
+
+	failed, err := store.GetFailed()
+	require.NoError(err)
+	require.Empty(failed)
+
+	mocks.executor.EXPECT().Exec(gomock.Any()).Return(nil)
+
+	require.NoError(m.RetryDead(dead[0]))
+
+	time.Sleep(50 * time.Millisecond)
+
+	dead, err = m.GetDead()
+	require.NoError(err)
+	require.Empty(dead)
+
+	tasks, err := m.Find(writeback.NewNameQuery(task.Name))
+	require.NoError(err)
+	require.Empty(tasks)
+}
+
+func TestManagerDiscardDead(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newManagerMocks(t)
+	defer cleanup()
+
+	db, dbCleanup := localdb.Fixture()
+	defer dbCleanup()
+
+	store := writeback.NewStore(db)
+
+	mocks.executor.EXPECT().Name().Return("mock executor")
+	m, err := NewManager(mocks.config, tally.NoopScope, store, mocks.executor)
+	require.NoError(err)
+	defer m.Close()
+
+	task := writeback.TaskFixture()
+	require.NoError(store.AddFailed(task))
+	require.NoError(store.MarkDead(task))
+
+	require.NoError(m.DiscardDead(task))
+
+	tasks, err := m.Find(writeback.NewNameQuery(task.Name))
+	require.NoError(err)
+	require.Empty(tasks)
+}
@@ -29,7 +29,7 @@ import (
 // FileStore defines store operations required for write-back.
 type FileStore interface {
 	DeleteCacheFileMetadata(name string, md metadata.Metadata) error
-	GetCacheFileReader(name string) (store.FileReader, error)
+	GetCacheFileSequentialReader(name string) (store.FileReader, error)
 }
 
 // Executor executes write back tasks.
@@ -90,7 +90,7 @@ func (e *Executor) upload(t *Task) error {
 		return nil
 	}
 
-	f, err := e.fs.GetCacheFileReader(t.Name)
+	f, err := e.fs.GetCacheFileSequentialReader(t.Name)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// Nothing we can do about this but make noise and drop the task.
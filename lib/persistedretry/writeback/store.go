@@ -44,6 +44,11 @@ func (s *Store) GetFailed() ([]persistedretry.Task, error) {
 	return s.selectStatus("failed")
 }
 
+// GetDead returns all dead tasks.
+func (s *Store) GetDead() ([]persistedretry.Task, error) {
+	return s.selectStatus("dead")
+}
+
 // AddPending adds r as pending.
 func (s *Store) AddPending(r persistedretry.Task) error {
 	return s.addWithStatus(r, "pending")
@@ -95,6 +100,45 @@ func (s *Store) MarkFailed(r persistedretry.Task) error {
 	return nil
 }
 
+// MarkDead marks r as dead.
+func (s *Store) MarkDead(r persistedretry.Task) error {
+	res, err := s.db.NamedExec(`
+		UPDATE writeback_task
+		SET status = "dead"
+		WHERE namespace=:namespace AND name=:name
+	`, r.(*Task))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		panic("driver does not support RowsAffected")
+	} else if n == 0 {
+		return persistedretry.ErrTaskNotFound
+	}
+	return nil
+}
+
+// Revive marks r as pending and resets its failures.
+func (s *Store) Revive(r persistedretry.Task) error {
+	t := r.(*Task)
+	res, err := s.db.NamedExec(`
+		UPDATE writeback_task
+		SET failures = 0,
+			status = "pending"
+		WHERE namespace=:namespace AND name=:name
+	`, t)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		panic("driver does not support RowsAffected")
+	} else if n == 0 {
+		return persistedretry.ErrTaskNotFound
+	}
+	t.Failures = 0
+	return nil
+}
+
 // Remove removes r.
 func (s *Store) Remove(r persistedretry.Task) error {
 	_, err := s.db.NamedExec(`
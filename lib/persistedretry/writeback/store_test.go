@@ -260,3 +260,60 @@ func TestFindEmpty(t *testing.T) {
 	require.NoError(err)
 	require.Empty(result)
 }
+
+func checkDead(t *testing.T, store *Store, expected ...*Task) {
+	t.Helper()
+
+	result, err := store.GetDead()
+	require.NoError(t, err)
+	checkTasks(t, expected, result)
+}
+
+func TestMarkDead(t *testing.T) {
+	require := require.New(t)
+
+	db, cleanup := localdb.Fixture()
+	defer cleanup()
+
+	store := NewStore(db)
+
+	task := TaskFixture()
+
+	require.NoError(store.AddPending(task))
+	require.NoError(store.MarkFailed(task))
+	require.NoError(store.MarkDead(task))
+
+	checkPending(t, store)
+	checkFailed(t, store)
+	checkDead(t, store, task)
+}
+
+func TestMarkDeadTaskNotFound(t *testing.T) {
+	db, cleanup := localdb.Fixture()
+	defer cleanup()
+
+	store := NewStore(db)
+
+	require.Equal(t, persistedretry.ErrTaskNotFound, store.MarkDead(TaskFixture()))
+}
+
+func TestReviveResetsFailures(t *testing.T) {
+	require := require.New(t)
+
+	db, cleanup := localdb.Fixture()
+	defer cleanup()
+
+	store := NewStore(db)
+
+	task := TaskFixture()
+
+	require.NoError(store.AddPending(task))
+	require.NoError(store.MarkFailed(task))
+	require.NoError(store.MarkFailed(task))
+	require.NoError(store.MarkDead(task))
+	require.NoError(store.Revive(task))
+	require.Equal(0, task.Failures)
+
+	checkDead(t, store)
+	checkPending(t, store, task)
+}
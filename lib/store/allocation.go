@@ -0,0 +1,65 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// AllocationMode defines how disk space is reserved for newly created
+// download files.
+type AllocationMode string
+
+const (
+	// AllocationSparse sizes download files without reserving blocks, so
+	// disk space is consumed only as pieces are written. Blobs which are
+	// partially downloaded and then evicted never cost their full length.
+	AllocationSparse AllocationMode = "sparse"
+
+	// AllocationPreallocate reserves blocks for the full length of the blob
+	// up front, using fallocate where the platform supports it. This fails
+	// fast when the disk cannot hold the blob and reduces fragmentation, at
+	// the cost of burning disk for blobs which may never complete. Platforms
+	// without fallocate fall back to sparse files.
+	AllocationPreallocate AllocationMode = "preallocate"
+)
+
+func (m AllocationMode) validate() error {
+	switch m {
+	case AllocationSparse, AllocationPreallocate:
+		return nil
+	}
+	return fmt.Errorf("invalid allocation mode %q", m)
+}
+
+// errAllocateUnsupported is returned by allocate when the platform or
+// filesystem cannot reserve blocks.
+var errAllocateUnsupported = errors.New("allocation not supported")
+
+// preallocate reserves length bytes of disk for the file at path. Files
+// stay sparse if the platform or filesystem does not support reservation.
+func preallocate(path string, length int64) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := allocate(f, length); err != nil && err != errAllocateUnsupported {
+		return err
+	}
+	return f.Close()
+}
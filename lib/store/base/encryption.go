@@ -0,0 +1,416 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package base
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sync"
+)
+
+// Encrypted data files are split into chunks of encryptionChunkSize plaintext
+// bytes, each sealed independently with AES-GCM so pieces can be read and
+// written at arbitrary offsets. Every chunk is stored on disk as a record:
+//
+//	| nonce (12) | ciphertext (<= encryptionChunkSize) | tag (16) |
+//
+// Each write of a chunk uses a fresh random nonce, and the file name and chunk
+// index are bound to the record as additional data, so records cannot be
+// swapped between positions or files. A record consisting solely of zeros has
+// never been written and reads as zeros, which keeps files initialized by
+// Create sparse.
+const (
+	encryptionChunkSize   = 64 * 1024
+	encryptionNonceSize   = 12
+	encryptionTagSize     = 16
+	encryptionOverhead    = encryptionNonceSize + encryptionTagSize
+	encryptionRecordSize  = encryptionChunkSize + encryptionOverhead
+	encryptionLockStripes = 256
+)
+
+// ErrLinkEncrypted is returned when linking an encrypted file to an unmanaged
+// path, since readers of the link would see ciphertext.
+var ErrLinkEncrypted = errors.New("cannot link encrypted file")
+
+// KeyProvider supplies the key data files are encrypted with.
+type KeyProvider interface {
+	// Key returns a 16, 24 or 32 byte key, selecting AES-128, AES-192 or
+	// AES-256 respectively.
+	Key() ([]byte, error)
+}
+
+type staticKeyProvider struct {
+	key []byte
+}
+
+// NewStaticKeyProvider returns a KeyProvider which always returns key.
+func NewStaticKeyProvider(key []byte) KeyProvider {
+	return staticKeyProvider{key}
+}
+
+func (p staticKeyProvider) Key() ([]byte, error) {
+	return p.key, nil
+}
+
+// FileCipher encrypts data files at rest.
+type FileCipher struct {
+	aead cipher.AEAD
+
+	// Serializes read-modify-write cycles on chunks shared by concurrent
+	// readers and writers. Stripes are keyed by file name and chunk index.
+	locks [encryptionLockStripes]sync.RWMutex
+}
+
+// NewFileCipher creates a new FileCipher using the key supplied by p.
+func NewFileCipher(p KeyProvider) (*FileCipher, error) {
+	key, err := p.Key()
+	if err != nil {
+		return nil, fmt.Errorf("get key: %s", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("new aes cipher: %s", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("new gcm: %s", err)
+	}
+	return &FileCipher{aead: aead}, nil
+}
+
+// EncryptedSize returns the on-disk size of an encrypted file holding n bytes
+// of plaintext.
+func EncryptedSize(n int64) int64 {
+	size := (n / encryptionChunkSize) * encryptionRecordSize
+	if rem := n % encryptionChunkSize; rem > 0 {
+		size += rem + encryptionOverhead
+	}
+	return size
+}
+
+// plaintextSize is the inverse of EncryptedSize.
+func plaintextSize(n int64) int64 {
+	size := (n / encryptionRecordSize) * encryptionChunkSize
+	if rem := n % encryptionRecordSize; rem > encryptionOverhead {
+		size += rem - encryptionOverhead
+	}
+	return size
+}
+
+func (c *FileCipher) lock(name string, chunk int64) *sync.RWMutex {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return &c.locks[(uint64(h.Sum32())+uint64(chunk))%encryptionLockStripes]
+}
+
+func chunkAdditionalData(name string, chunk int64) []byte {
+	b := make([]byte, len(name)+8)
+	copy(b, name)
+	binary.BigEndian.PutUint64(b[len(name):], uint64(chunk))
+	return b
+}
+
+// readChunk returns the plaintext of chunk in f, where size is the plaintext
+// size of f.
+func (c *FileCipher) readChunk(f *os.File, name string, chunk, size int64) ([]byte, error) {
+	length := size - chunk*encryptionChunkSize
+	if length <= 0 {
+		return nil, nil
+	}
+	if length > encryptionChunkSize {
+		length = encryptionChunkSize
+	}
+	record := make([]byte, length+encryptionOverhead)
+	if _, err := f.ReadAt(record, chunk*encryptionRecordSize); err != nil {
+		return nil, fmt.Errorf("read chunk %d: %s", chunk, err)
+	}
+	if isZero(record) {
+		return make([]byte, length), nil
+	}
+	nonce, sealed := record[:encryptionNonceSize], record[encryptionNonceSize:]
+	plaintext, err := c.aead.Open(sealed[:0], nonce, sealed, chunkAdditionalData(name, chunk))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt chunk %d: %s", chunk, err)
+	}
+	return plaintext, nil
+}
+
+// writeChunk seals plaintext under a fresh nonce and writes it as chunk of f.
+func (c *FileCipher) writeChunk(f *os.File, name string, chunk int64, plaintext []byte) error {
+	record := make([]byte, encryptionNonceSize, len(plaintext)+encryptionOverhead)
+	nonce := record[:encryptionNonceSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("generate nonce: %s", err)
+	}
+	record = c.aead.Seal(record, nonce, plaintext, chunkAdditionalData(name, chunk))
+	if _, err := f.WriteAt(record, chunk*encryptionRecordSize); err != nil {
+		return fmt.Errorf("write chunk %d: %s", chunk, err)
+	}
+	return nil
+}
+
+// encryptFile writes the encrypted contents of the plaintext file at
+// sourcePath to targetPath.
+func (c *FileCipher) encryptFile(name, sourcePath, targetPath string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0775)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	buf := make([]byte, encryptionChunkSize)
+	for chunk := int64(0); ; chunk++ {
+		n, err := io.ReadFull(source, buf)
+		if n > 0 {
+			if err := c.writeChunk(target, name, chunk, buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read source: %s", err)
+		}
+	}
+	return target.Close()
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// encryptedFileInfo reports the plaintext size of an encrypted file.
+type encryptedFileInfo struct {
+	os.FileInfo
+}
+
+func (i encryptedFileInfo) Size() int64 {
+	return plaintextSize(i.FileInfo.Size())
+}
+
+// encryptedFileReadWriter implements FileReadWriter, transparently encrypting
+// writes to and decrypting reads from a local file.
+type encryptedFileReadWriter struct {
+	entry      *localFileEntry
+	cipher     *FileCipher
+	descriptor *os.File
+
+	mu     sync.Mutex
+	offset int64
+}
+
+func (rw *encryptedFileReadWriter) size() (int64, error) {
+	info, err := rw.descriptor.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return plaintextSize(info.Size()), nil
+}
+
+// Close closes underlying OS.File object.
+func (rw *encryptedFileReadWriter) Close() error {
+	return rw.descriptor.Close()
+}
+
+// ReadAt reads len(p) bytes of plaintext starting at offset.
+func (rw *encryptedFileReadWriter) ReadAt(p []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	size, err := rw.size()
+	if err != nil {
+		return 0, err
+	}
+	name := rw.entry.GetName()
+	var n int
+	for n < len(p) && offset+int64(n) < size {
+		pos := offset + int64(n)
+		chunk := pos / encryptionChunkSize
+		l := rw.cipher.lock(name, chunk)
+		l.RLock()
+		plaintext, err := rw.cipher.readChunk(rw.descriptor, name, chunk, size)
+		l.RUnlock()
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], plaintext[pos-chunk*encryptionChunkSize:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes len(p) bytes of plaintext starting at offset. Writes which
+// extend the file must not race with other writes to the same file.
+func (rw *encryptedFileReadWriter) WriteAt(p []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	size, err := rw.size()
+	if err != nil {
+		return 0, err
+	}
+	name := rw.entry.GetName()
+	end := offset + int64(len(p))
+	newSize := size
+	if end > newSize {
+		newSize = end
+	}
+	first := offset / encryptionChunkSize
+	last := (end - 1) / encryptionChunkSize
+
+	// A partial trailing chunk followed by new data must be padded out to a
+	// full chunk, otherwise the records after it would be misaligned.
+	if trailing := size / encryptionChunkSize; size%encryptionChunkSize != 0 && trailing < first {
+		if err := rw.rewriteChunk(name, trailing, size, newSize, nil, 0); err != nil {
+			return 0, err
+		}
+	}
+	for chunk := first; chunk <= last; chunk++ {
+		if err := rw.rewriteChunk(name, chunk, size, newSize, p, offset); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// rewriteChunk overlays the bytes of p, which starts at offset, onto chunk and
+// reseals it with the length it has in a file of newSize plaintext bytes.
+func (rw *encryptedFileReadWriter) rewriteChunk(
+	name string, chunk, size, newSize int64, p []byte, offset int64) error {
+
+	l := rw.cipher.lock(name, chunk)
+	l.Lock()
+	defer l.Unlock()
+
+	start := chunk * encryptionChunkSize
+	length := newSize - start
+	if length > encryptionChunkSize {
+		length = encryptionChunkSize
+	}
+	buf := make([]byte, length)
+	existing, err := rw.cipher.readChunk(rw.descriptor, name, chunk, size)
+	if err != nil {
+		return err
+	}
+	copy(buf, existing)
+	if len(p) > 0 {
+		lo, hi := start, start+length
+		if offset > lo {
+			lo = offset
+		}
+		if end := offset + int64(len(p)); end < hi {
+			hi = end
+		}
+		copy(buf[lo-start:hi-start], p[lo-offset:hi-offset])
+	}
+	return rw.cipher.writeChunk(rw.descriptor, name, chunk, buf)
+}
+
+// Read reads up to len(p) bytes of plaintext.
+func (rw *encryptedFileReadWriter) Read(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	n, err := rw.ReadAt(p, rw.offset)
+	rw.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Write writes len(p) bytes of plaintext.
+func (rw *encryptedFileReadWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	n, err := rw.WriteAt(p, rw.offset)
+	rw.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the plaintext offset for the next Read or Write, interpreted
+// according to whence.
+func (rw *encryptedFileReadWriter) Seek(offset int64, whence int) (int64, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rw.offset
+	case io.SeekEnd:
+		size, err := rw.size()
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	rw.offset = offset
+	return offset, nil
+}
+
+// Sync commits the current contents of the file to stable storage.
+func (rw *encryptedFileReadWriter) Sync() error {
+	return rw.descriptor.Sync()
+}
+
+// Size returns the plaintext size of the file.
+func (rw *encryptedFileReadWriter) Size() int64 {
+	// Use file entry instead of descriptor, because descriptor could have been closed.
+	fileInfo, err := rw.entry.GetStat()
+	if err != nil {
+		return 0
+	}
+	return fileInfo.Size()
+}
+
+// Cancel closes the file. See localFileReadWriter.Cancel.
+func (rw *encryptedFileReadWriter) Cancel() error {
+	return rw.Close()
+}
+
+// Commit closes the file. Every write is sealed and written immediately.
+func (rw *encryptedFileReadWriter) Commit() error {
+	return rw.Close()
+}
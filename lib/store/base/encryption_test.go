@@ -0,0 +1,208 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package base
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/randutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func encryptedFileStoreFixture(t *testing.T) (FileStore, FileState, func()) {
+	state, _, _, cleanup := fileStatesFixture()
+	c, err := NewFileCipher(NewStaticKeyProvider(randutil.Blob(32)))
+	require.NoError(t, err)
+	return NewCASFileStore(clock.New(), WithEncryption(c)), state, cleanup
+}
+
+func TestEncryptedSize(t *testing.T) {
+	for _, n := range []int64{
+		0, 1, encryptionChunkSize - 1, encryptionChunkSize, encryptionChunkSize + 1, 5*encryptionChunkSize + 17,
+	} {
+		require.Equal(t, n, plaintextSize(EncryptedSize(n)), "size %d", n)
+	}
+}
+
+func TestEncryptedFileRandomAccess(t *testing.T) {
+	require := require.New(t)
+
+	store, state, cleanup := encryptedFileStoreFixture(t)
+	defer cleanup()
+
+	name := core.DigestFixture().Hex()
+	blob := randutil.Blob(3*encryptionChunkSize + 100)
+	require.NoError(store.NewFileOp().CreateFile(name, state, int64(len(blob))))
+
+	op := store.NewFileOp().AcceptState(state)
+	info, err := op.GetFileStat(name)
+	require.NoError(err)
+	require.Equal(int64(len(blob)), info.Size())
+
+	rw, err := op.GetFileReadWriter(name)
+	require.NoError(err)
+	defer rw.Close()
+
+	// Write unaligned pieces out of order, crossing chunk boundaries.
+	pieceLength := 10000
+	for i := len(blob) / pieceLength; i >= 0; i-- {
+		start := i * pieceLength
+		end := start + pieceLength
+		if end > len(blob) {
+			end = len(blob)
+		}
+		_, err := rw.WriteAt(blob[start:end], int64(start))
+		require.NoError(err)
+	}
+
+	result := make([]byte, len(blob))
+	_, err = rw.ReadAt(result, 0)
+	require.NoError(err)
+	require.Equal(blob, result)
+
+	piece := make([]byte, 5000)
+	_, err = rw.ReadAt(piece, encryptionChunkSize-2500)
+	require.NoError(err)
+	require.Equal(blob[encryptionChunkSize-2500:encryptionChunkSize+2500], piece)
+
+	// Plaintext never reaches disk.
+	path, err := op.GetFilePath(name)
+	require.NoError(err)
+	raw, err := ioutil.ReadFile(path)
+	require.NoError(err)
+	require.False(bytes.Contains(raw, blob[:64]))
+}
+
+func TestEncryptedFileUnwrittenChunksReadAsZeros(t *testing.T) {
+	require := require.New(t)
+
+	store, state, cleanup := encryptedFileStoreFixture(t)
+	defer cleanup()
+
+	name := core.DigestFixture().Hex()
+	require.NoError(store.NewFileOp().CreateFile(name, state, 2*encryptionChunkSize))
+
+	r, err := store.NewFileOp().AcceptState(state).GetFileReader(name)
+	require.NoError(err)
+	defer r.Close()
+
+	result, err := ioutil.ReadAll(r)
+	require.NoError(err)
+	require.Equal(make([]byte, 2*encryptionChunkSize), result)
+}
+
+func TestEncryptedFileAppend(t *testing.T) {
+	require := require.New(t)
+
+	store, state, cleanup := encryptedFileStoreFixture(t)
+	defer cleanup()
+
+	name := core.DigestFixture().Hex()
+	require.NoError(store.NewFileOp().CreateFile(name, state, 0))
+
+	op := store.NewFileOp().AcceptState(state)
+	w, err := op.GetFileReadWriter(name)
+	require.NoError(err)
+
+	blob := randutil.Blob(2*encryptionChunkSize + 3)
+	for i := 0; i < len(blob); i += 999 {
+		end := i + 999
+		if end > len(blob) {
+			end = len(blob)
+		}
+		_, err := w.Write(blob[i:end])
+		require.NoError(err)
+	}
+	require.Equal(int64(len(blob)), w.Size())
+	require.NoError(w.Commit())
+
+	r, err := op.GetFileReader(name)
+	require.NoError(err)
+	defer r.Close()
+
+	_, err = r.Seek(10, io.SeekStart)
+	require.NoError(err)
+	result, err := ioutil.ReadAll(r)
+	require.NoError(err)
+	require.Equal(blob[10:], result)
+}
+
+func TestEncryptedFileDetectsTampering(t *testing.T) {
+	require := require.New(t)
+
+	store, state, cleanup := encryptedFileStoreFixture(t)
+	defer cleanup()
+
+	name := core.DigestFixture().Hex()
+	blob := randutil.Blob(100)
+	require.NoError(store.NewFileOp().CreateFile(name, state, int64(len(blob))))
+
+	op := store.NewFileOp().AcceptState(state)
+	rw, err := op.GetFileReadWriter(name)
+	require.NoError(err)
+	_, err = rw.WriteAt(blob, 0)
+	require.NoError(err)
+	require.NoError(rw.Close())
+
+	path, err := op.GetFilePath(name)
+	require.NoError(err)
+	f, err := os.OpenFile(path, os.O_RDWR, 0775)
+	require.NoError(err)
+	_, err = f.WriteAt([]byte{0xff}, encryptionNonceSize+1)
+	require.NoError(err)
+	require.NoError(f.Close())
+
+	r, err := op.GetFileReader(name)
+	require.NoError(err)
+	defer r.Close()
+
+	_, err = r.ReadAt(make([]byte, len(blob)), 0)
+	require.Error(err)
+}
+
+func TestEncryptedFileMoveFromEncryptsSource(t *testing.T) {
+	require := require.New(t)
+
+	store, state, cleanup := encryptedFileStoreFixture(t)
+	defer cleanup()
+
+	blob := randutil.Blob(encryptionChunkSize + 10)
+	source := filepath.Join(filepath.Dir(state.GetDirectory()), "source")
+	require.NoError(ioutil.WriteFile(source, blob, 0775))
+
+	name := core.DigestFixture().Hex()
+	op := store.NewFileOp()
+	require.NoError(op.MoveFileFrom(name, state, source))
+
+	_, err := os.Stat(source)
+	require.True(os.IsNotExist(err))
+
+	r, err := op.AcceptState(state).GetFileReader(name)
+	require.NoError(err)
+	defer r.Close()
+
+	result, err := ioutil.ReadAll(r)
+	require.NoError(err)
+	require.Equal(blob, result)
+
+	require.Equal(ErrLinkEncrypted, op.AcceptState(state).LinkFileTo(name, source))
+}
@@ -98,11 +98,14 @@ var _ FileEntryFactory = (*casFileEntryFactory)(nil)
 var _ FileEntry = (*localFileEntry)(nil)
 
 // localFileEntryFactory initializes localFileEntry obj.
-type localFileEntryFactory struct{}
+type localFileEntryFactory struct {
+	metadata MetadataBackend
+	cipher   *FileCipher
+}
 
 // NewLocalFileEntryFactory is the constructor for localFileEntryFactory.
 func NewLocalFileEntryFactory() FileEntryFactory {
-	return &localFileEntryFactory{}
+	return &localFileEntryFactory{NewFileMetadataBackend(), nil}
 }
 
 // Create initializes and returns a FileEntry object.
@@ -113,7 +116,7 @@ func (f *localFileEntryFactory) Create(name string, state FileState) (FileEntry,
 	if strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") || strings.HasPrefix(name, "../") {
 		return nil, ErrInvalidName
 	}
-	return newLocalFileEntry(state, name, f.GetRelativePath(name)), nil
+	return newLocalFileEntry(state, name, f.GetRelativePath(name), f.metadata, f.cipher), nil
 }
 
 // GetRelativePath returns name because file entries are stored flat under state directory.
@@ -160,33 +163,27 @@ func (f *localFileEntryFactory) ListNames(state FileState) ([]string, error) {
 // casFileEntryFactory initializes localFileEntry obj.
 // It uses the first few bytes of file digest (which is also used as file name) as shard ID.
 // For every byte, one more level of directories will be created.
-type casFileEntryFactory struct{}
+type casFileEntryFactory struct {
+	metadata MetadataBackend
+	cipher   *FileCipher
+	sharding Sharding
+}
 
 // NewCASFileEntryFactory is the constructor for casFileEntryFactory.
 func NewCASFileEntryFactory() FileEntryFactory {
-	return &casFileEntryFactory{}
+	return &casFileEntryFactory{NewFileMetadataBackend(), nil, DefaultSharding}
 }
 
 // Create initializes and returns a FileEntry object.
 // TODO: verify name.
 func (f *casFileEntryFactory) Create(name string, state FileState) (FileEntry, error) {
-	return newLocalFileEntry(state, name, f.GetRelativePath(name)), nil
+	return newLocalFileEntry(state, name, f.GetRelativePath(name), f.metadata, f.cipher), nil
 }
 
 // GetRelativePath returns content-addressable file path under state directory.
-// Example:
-// name = 07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc
-// shardIDLength = 2
-// relative path = 07/12/07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc
+// See Sharding.RelativePath.
 func (f *casFileEntryFactory) GetRelativePath(name string) string {
-	filePath := ""
-	for i := 0; i < int(DefaultShardIDLength) && i < len(name)/2; i++ {
-		// (1 byte = 2 char of file name assumming file name is in HEX)
-		dirName := name[i*2 : i*2+2]
-		filePath = filepath.Join(filePath, dirName)
-	}
-
-	return filepath.Join(filePath, name, DefaultDataFileName)
+	return f.sharding.RelativePath(name)
 }
 
 // ListNames returns the names of all entries within the shards of state.
@@ -203,10 +200,17 @@ func (f *casFileEntryFactory) ListNames(state FileState) ([]string, error) {
 			if depth == 0 {
 				names = append(names, info.Name())
 			} else {
-				if !info.IsDir() {
+				p := filepath.Join(dir, info.Name())
+				isDir := info.IsDir()
+				if info.Mode()&os.ModeSymlink != 0 {
+					// Shards may be symlinked to volumes.
+					target, err := os.Stat(p)
+					isDir = err == nil && target.IsDir()
+				}
+				if !isDir {
 					continue
 				}
-				if err := readNames(filepath.Join(dir, info.Name()), depth-1); err != nil {
+				if err := readNames(p, depth-1); err != nil {
 					return err
 				}
 			}
@@ -214,7 +218,7 @@ func (f *casFileEntryFactory) ListNames(state FileState) ([]string, error) {
 		return nil
 	}
 
-	err := readNames(state.GetDirectory(), DefaultShardIDLength)
+	err := readNames(state.GetDirectory(), f.sharding.Depth)
 
 	return names, err
 }
@@ -225,20 +229,26 @@ type localFileEntry struct {
 
 	state            FileState
 	name             string
-	relativeDataPath string        // Relative path to data file.
-	metadata         stringset.Set // Metadata is identified by suffix.
+	relativeDataPath string          // Relative path to data file.
+	metadata         stringset.Set   // Metadata is identified by suffix.
+	backend          MetadataBackend // Persists metadata content.
+	cipher           *FileCipher     // Encrypts data at rest. Nil if disabled.
 }
 
 func newLocalFileEntry(
 	state FileState,
 	name string,
 	relativeDataPath string,
+	backend MetadataBackend,
+	cipher *FileCipher,
 ) *localFileEntry {
 	return &localFileEntry{
 		state:            state,
 		name:             name,
 		relativeDataPath: relativeDataPath,
 		metadata:         make(stringset.Set),
+		backend:          backend,
+		cipher:           cipher,
 	}
 }
 
@@ -258,8 +268,13 @@ func (entry *localFileEntry) GetPath() string {
 }
 
 // GetStat returns a FileInfo describing the named file.
+// If encryption is enabled, the reported size is that of the plaintext.
 func (entry *localFileEntry) GetStat() (os.FileInfo, error) {
-	return os.Stat(entry.GetPath())
+	info, err := os.Stat(entry.GetPath())
+	if err != nil || entry.cipher == nil {
+		return info, err
+	}
+	return encryptedFileInfo{info}, nil
 }
 
 // Create creates a file on disk.
@@ -292,6 +307,9 @@ func (entry *localFileEntry) Create(targetState FileState, size int64) error {
 	defer f.Close()
 
 	// Change size.
+	if entry.cipher != nil {
+		size = EncryptedSize(size)
+	}
 	err = f.Truncate(size)
 	if err != nil {
 		// Try to delete file.
@@ -311,19 +329,17 @@ func (entry *localFileEntry) Reload() error {
 	}
 
 	// Load metadata.
-	files, err := ioutil.ReadDir(filepath.Dir(entry.GetPath()))
+	suffixes, err := entry.backend.List(entry.metadataDir())
 	if err != nil {
 		return err
 	}
-	for _, currFile := range files {
-		// Glob could return the data file itself, and directories.
+	for _, suffix := range suffixes {
+		// Listing could return directories and other files.
 		// Verify it's actually a metadata file.
-		if currFile.Name() != DefaultDataFileName {
-			md := metadata.CreateFromSuffix(currFile.Name())
-			if md != nil {
-				// Add metadata
-				entry.AddMetadata(md)
-			}
+		md := metadata.CreateFromSuffix(suffix)
+		if md != nil {
+			// Add metadata
+			entry.AddMetadata(md)
 		}
 	}
 	return nil
@@ -357,7 +373,15 @@ func (entry *localFileEntry) MoveFrom(targetState FileState, sourcePath string)
 		return err
 	}
 
-	// Move data.
+	// Move data. Encrypted files cannot be renamed in, since the source is
+	// plaintext.
+	if entry.cipher != nil {
+		if err := entry.cipher.encryptFile(entry.name, sourcePath, targetPath); err != nil {
+			os.Remove(targetPath)
+			return err
+		}
+		return os.Remove(sourcePath)
+	}
 	return os.Rename(sourcePath, targetPath)
 }
 
@@ -378,15 +402,15 @@ func (entry *localFileEntry) Move(targetState FileState) error {
 	}
 
 	// Copy metadata first.
+	sourceMetadataDir := entry.metadataDir()
+	targetMetadataDir := filepath.Dir(targetPath)
 	performCopy := func(md metadata.Metadata) error {
 		if md.Movable() {
-			sourceMetadataPath := entry.getMetadataPath(md)
-			targetMetadataPath := filepath.Join(filepath.Dir(targetPath), md.GetSuffix())
-			bytes, err := ioutil.ReadFile(sourceMetadataPath)
+			bytes, err := entry.backend.Get(sourceMetadataDir, md.GetSuffix())
 			if err != nil {
 				return err
 			}
-			if _, err := compareAndWriteFile(targetMetadataPath, bytes); err != nil {
+			if _, err := entry.backend.Set(targetMetadataDir, md.GetSuffix(), bytes); err != nil {
 				return err
 			}
 		}
@@ -405,11 +429,19 @@ func (entry *localFileEntry) Move(targetState FileState) error {
 	entry.state = targetState
 
 	// Delete source dir.
-	return os.RemoveAll(filepath.Dir(sourcePath))
+	if err := os.RemoveAll(filepath.Dir(sourcePath)); err != nil {
+		return err
+	}
+	return entry.backend.DeleteAll(sourceMetadataDir)
 }
 
 // LinkTo creates a hardlink to an unmanaged path.
+// Encrypted files cannot be linked.
 func (entry *localFileEntry) LinkTo(targetPath string) error {
+	if entry.cipher != nil {
+		return ErrLinkEncrypted
+	}
+
 	// Create dir.
 	if err := os.MkdirAll(filepath.Dir(targetPath), DefaultDirPermission); err != nil {
 		return err
@@ -434,7 +466,10 @@ func (entry *localFileEntry) Delete() error {
 	}
 
 	// Remove files.
-	return os.RemoveAll(filepath.Dir(entry.GetPath()))
+	if err := os.RemoveAll(filepath.Dir(entry.GetPath())); err != nil {
+		return err
+	}
+	return entry.backend.DeleteAll(entry.metadataDir())
 }
 
 // GetReader returns a FileReader object for read operations.
@@ -444,6 +479,9 @@ func (entry *localFileEntry) GetReader() (FileReader, error) {
 		return nil, err
 	}
 
+	if entry.cipher != nil {
+		return &encryptedFileReadWriter{entry: entry, cipher: entry.cipher, descriptor: f}, nil
+	}
 	reader := &localFileReadWriter{
 		entry:      entry,
 		descriptor: f,
@@ -458,6 +496,9 @@ func (entry *localFileEntry) GetReadWriter() (FileReadWriter, error) {
 		return nil, err
 	}
 
+	if entry.cipher != nil {
+		return &encryptedFileReadWriter{entry: entry, cipher: entry.cipher, descriptor: f}, nil
+	}
 	readWriter := &localFileReadWriter{
 		entry:      entry,
 		descriptor: f,
@@ -465,16 +506,15 @@ func (entry *localFileEntry) GetReadWriter() (FileReadWriter, error) {
 	return readWriter, nil
 }
 
-func (entry *localFileEntry) getMetadataPath(md metadata.Metadata) string {
-	return filepath.Join(filepath.Dir(entry.GetPath()), md.GetSuffix())
+// metadataDir returns the directory metadata of entry is keyed by.
+func (entry *localFileEntry) metadataDir() string {
+	return filepath.Dir(entry.GetPath())
 }
 
 // AddMetadata adds a new metadata type to metadata. This is primirily used during reload.
 func (entry *localFileEntry) AddMetadata(md metadata.Metadata) error {
-	filePath := entry.getMetadataPath(md)
-
 	// Check existence.
-	if _, err := os.Stat(filePath); err != nil {
+	if _, err := entry.backend.Get(entry.metadataDir(), md.GetSuffix()); err != nil {
 		return err
 	}
 	entry.metadata.Add(md.GetSuffix())
@@ -483,8 +523,7 @@ func (entry *localFileEntry) AddMetadata(md metadata.Metadata) error {
 
 // GetMetadata reads and unmarshals metadata into md.
 func (entry *localFileEntry) GetMetadata(md metadata.Metadata) error {
-	filePath := entry.getMetadataPath(md)
-	b, err := ioutil.ReadFile(filePath)
+	b, err := entry.backend.Get(entry.metadataDir(), md.GetSuffix())
 	if err != nil {
 		return err
 	}
@@ -494,12 +533,11 @@ func (entry *localFileEntry) GetMetadata(md metadata.Metadata) error {
 // SetMetadata updates metadata and returns true only if the file is updated correctly.
 // It returns false if error happened or file already contains desired content.
 func (entry *localFileEntry) SetMetadata(md metadata.Metadata) (bool, error) {
-	filePath := entry.getMetadataPath(md)
 	b, err := md.Serialize()
 	if err != nil {
 		return false, fmt.Errorf("marshal metadata: %s", err)
 	}
-	updated, err := compareAndWriteFile(filePath, b)
+	updated, err := entry.backend.Set(entry.metadataDir(), md.GetSuffix(), b)
 	if err == nil {
 		entry.metadata.Add(md.GetSuffix())
 	}
@@ -511,24 +549,7 @@ func (entry *localFileEntry) SetMetadata(md metadata.Metadata) (bool, error) {
 func (entry *localFileEntry) SetMetadataAt(
 	md metadata.Metadata, b []byte, offset int64) (updated bool, err error) {
 
-	filePath := entry.getMetadataPath(md)
-	f, err := os.OpenFile(filePath, os.O_RDWR, 0775)
-	if err != nil {
-		return false, err
-	}
-	defer f.Close()
-
-	prev := make([]byte, len(b))
-	if _, err := f.ReadAt(prev, offset); err != nil {
-		return false, err
-	}
-	if bytes.Compare(prev, b) == 0 {
-		return false, nil
-	}
-	if _, err := f.WriteAt(b, offset); err != nil {
-		return false, err
-	}
-	return true, nil
+	return entry.backend.SetAt(entry.metadataDir(), md.GetSuffix(), b, offset)
 }
 
 // GetOrSetMetadata writes b under metadata md if md has not been initialized yet.
@@ -541,8 +562,7 @@ func (entry *localFileEntry) GetOrSetMetadata(md metadata.Metadata) error {
 	if err != nil {
 		return fmt.Errorf("marshal metadata: %s", err)
 	}
-	filePath := filepath.Join(filepath.Dir(entry.GetPath()), md.GetSuffix())
-	if _, err := compareAndWriteFile(filePath, b); err != nil {
+	if _, err := entry.backend.Set(entry.metadataDir(), md.GetSuffix(), b); err != nil {
 		return err
 	}
 	entry.metadata.Add(md.GetSuffix())
@@ -551,12 +571,10 @@ func (entry *localFileEntry) GetOrSetMetadata(md metadata.Metadata) error {
 
 // DeleteMetadata deletes metadata of the specified type.
 func (entry *localFileEntry) DeleteMetadata(md metadata.Metadata) error {
-	filePath := entry.getMetadataPath(md)
-
-	// Remove from map no matter if the actual metadata file is removed from disk.
+	// Remove from map no matter if the actual metadata is removed.
 	defer entry.metadata.Remove(md.GetSuffix())
 
-	return os.RemoveAll(filePath)
+	return entry.backend.Delete(entry.metadataDir(), md.GetSuffix())
 }
 
 // RangeMetadata loops through all metadata and applies function f, until an error happens.
@@ -44,6 +44,18 @@ type fileEntryWithAccessTime struct {
 
 	// The last time that LoadForWrite/LoadForRead is called on the entry.
 	lastAccessTime time.Time
+
+	// The number of times lastAccessTime was updated.
+	accessCount int64
+}
+
+// touch updates the last access time and access count of e to t. Assumes e is
+// locked.
+func (e *fileEntryWithAccessTime) touch(t time.Time) {
+	e.lastAccessTime = t
+	e.fe.SetMetadata(metadata.NewLastAccessTime(t))
+	e.accessCount++
+	e.fe.SetMetadata(metadata.NewAccessCount(e.accessCount))
 }
 
 // lruFileMap implements FileMap interface, with an optional max capacity, and
@@ -119,8 +131,7 @@ func (fm *lruFileMap) syncGetAndTouch(name string) (*fileEntryWithAccessTime, bo
 	if t.Sub(e.lastAccessTime) >= fm.timeResolution {
 		// Only update if new timestamp is <timeResolution> newer than previous
 		// value.
-		e.lastAccessTime = t
-		e.fe.SetMetadata(metadata.NewLastAccessTime(t))
+		e.touch(t)
 	}
 
 	return e, true
@@ -229,8 +240,7 @@ func (fm *lruFileMap) TryStore(name string, entry FileEntry, f func(string, File
 		if t.Sub(e.lastAccessTime) >= fm.timeResolution {
 			// Only update if new timestamp is <timeResolution> newer than
 			// previous value.
-			e.lastAccessTime = t
-			e.fe.SetMetadata(metadata.NewLastAccessTime(t))
+			e.touch(t)
 		}
 
 		return false
@@ -251,6 +261,12 @@ func (fm *lruFileMap) TryStore(name string, entry FileEntry, f func(string, File
 	}
 	e.lastAccessTime = lat.Time
 
+	var count metadata.AccessCount
+	if err := e.fe.GetMetadata(&count); err != nil && !os.IsNotExist(err) {
+		log.With("name", e.fe.GetName()).Errorf("Error reading access count: %s", err)
+	}
+	e.accessCount = count.Count
+
 	fm.Unlock()
 
 	if !f(name, e.fe) {
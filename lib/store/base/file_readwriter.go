@@ -63,6 +63,11 @@ func (readWriter localFileReadWriter) WriteAt(p []byte, offset int64) (int, erro
 	return readWriter.descriptor.WriteAt(p, offset)
 }
 
+// Sync commits the current contents of the file to stable storage.
+func (readWriter localFileReadWriter) Sync() error {
+	return readWriter.descriptor.Sync()
+}
+
 // Read reads up to len(b) bytes from the File.
 func (readWriter localFileReadWriter) Read(p []byte) (int, error) {
 	return readWriter.descriptor.Read(p)
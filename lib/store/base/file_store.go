@@ -28,11 +28,50 @@ type localFileStore struct {
 	fileMap          FileMap
 }
 
+// FileStoreOption defines an optional FileStore parameter.
+type FileStoreOption func(*fileStoreOptions)
+
+type fileStoreOptions struct {
+	metadata MetadataBackend
+	cipher   *FileCipher
+	sharding Sharding
+}
+
+// WithMetadataBackend configures a FileStore to persist file metadata in b.
+// Defaults to metadata files stored next to each data file.
+func WithMetadataBackend(b MetadataBackend) FileStoreOption {
+	return func(o *fileStoreOptions) { o.metadata = b }
+}
+
+// WithEncryption configures a FileStore to encrypt file data at rest with c.
+// Metadata is not encrypted.
+func WithEncryption(c *FileCipher) FileStoreOption {
+	return func(o *fileStoreOptions) { o.cipher = c }
+}
+
+// WithSharding configures a content-addressable FileStore to lay out files
+// according to s. Defaults to DefaultSharding.
+func WithSharding(s Sharding) FileStoreOption {
+	return func(o *fileStoreOptions) { o.sharding = s }
+}
+
+func applyFileStoreOptions(opts []FileStoreOption) fileStoreOptions {
+	o := fileStoreOptions{
+		metadata: NewFileMetadataBackend(),
+		sharding: DefaultSharding,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
 // NewLocalFileStore initializes and returns a new FileStore.
-func NewLocalFileStore(clk clock.Clock) FileStore {
+func NewLocalFileStore(clk clock.Clock, opts ...FileStoreOption) FileStore {
+	o := applyFileStoreOptions(opts)
 	m := NewLATFileMap(clk)
 	return &localFileStore{
-		fileEntryFactory: NewLocalFileEntryFactory(),
+		fileEntryFactory: &localFileEntryFactory{o.metadata, o.cipher},
 		fileMap:          m,
 	}
 }
@@ -41,20 +80,22 @@ func NewLocalFileStore(clk clock.Clock) FileStore {
 // It uses the first few bytes of file digest (which is also used as file name)
 // as shard ID.
 // For every byte, one more level of directories will be created.
-func NewCASFileStore(clk clock.Clock) FileStore {
+func NewCASFileStore(clk clock.Clock, opts ...FileStoreOption) FileStore {
+	o := applyFileStoreOptions(opts)
 	m := NewLATFileMap(clk)
 	return &localFileStore{
-		fileEntryFactory: NewCASFileEntryFactory(),
+		fileEntryFactory: &casFileEntryFactory{o.metadata, o.cipher, o.sharding},
 		fileMap:          m,
 	}
 }
 
 // NewLRUFileStore initializes and returns a new LRU FileStore.
 // When size exceeds limit, the least recently accessed entry will be removed.
-func NewLRUFileStore(size int, clk clock.Clock) FileStore {
+func NewLRUFileStore(size int, clk clock.Clock, opts ...FileStoreOption) FileStore {
+	o := applyFileStoreOptions(opts)
 	m := NewLRUFileMap(size, clk)
 	return &localFileStore{
-		fileEntryFactory: NewLocalFileEntryFactory(),
+		fileEntryFactory: &localFileEntryFactory{o.metadata, o.cipher},
 		fileMap:          m,
 	}
 }
@@ -65,10 +106,11 @@ func NewLRUFileStore(size int, clk clock.Clock) FileStore {
 // For every byte, one more level of directories will be created. It also stores
 // objects in a LRU FileStore.
 // When size exceeds limit, the least recently accessed entry will be removed.
-func NewCASFileStoreWithLRUMap(size int, clk clock.Clock) FileStore {
+func NewCASFileStoreWithLRUMap(size int, clk clock.Clock, opts ...FileStoreOption) FileStore {
+	o := applyFileStoreOptions(opts)
 	m := NewLRUFileMap(size, clk)
 	return &localFileStore{
-		fileEntryFactory: NewCASFileEntryFactory(),
+		fileEntryFactory: &casFileEntryFactory{o.metadata, o.cipher, o.sharding},
 		fileMap:          m,
 	}
 }
@@ -0,0 +1,233 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package base
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MetadataBackend persists file metadata. Metadata is keyed by the directory
+// of the file entry it belongs to, which is unique per file and state, and by
+// the suffix of the metadata type.
+type MetadataBackend interface {
+	// Get returns the content of suffix for dir. Returns os.ErrNotExist if
+	// not set.
+	Get(dir, suffix string) ([]byte, error)
+
+	// Set writes b as the content of suffix for dir. Returns false if the
+	// content was already b.
+	Set(dir, suffix string, b []byte) (updated bool, err error)
+
+	// SetAt overwrites the content of suffix for dir with b starting at
+	// offset. Returns false if the overwritten bytes were already b.
+	SetAt(dir, suffix string, b []byte, offset int64) (updated bool, err error)
+
+	// Delete deletes suffix for dir.
+	Delete(dir, suffix string) error
+
+	// List returns the suffixes set for dir. May include names which are not
+	// metadata suffixes.
+	List(dir string) ([]string, error)
+
+	// DeleteAll deletes all metadata of dir.
+	DeleteAll(dir string) error
+}
+
+// fileMetadataBackend stores each metadata in a file named after its suffix,
+// next to the data file.
+type fileMetadataBackend struct{}
+
+// NewFileMetadataBackend returns a MetadataBackend which stores metadata in
+// files.
+func NewFileMetadataBackend() MetadataBackend {
+	return fileMetadataBackend{}
+}
+
+func (fileMetadataBackend) Get(dir, suffix string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(dir, suffix))
+}
+
+func (fileMetadataBackend) Set(dir, suffix string, b []byte) (bool, error) {
+	return compareAndWriteFile(filepath.Join(dir, suffix), b)
+}
+
+func (fileMetadataBackend) SetAt(dir, suffix string, b []byte, offset int64) (bool, error) {
+	f, err := os.OpenFile(filepath.Join(dir, suffix), os.O_RDWR, 0775)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	prev := make([]byte, len(b))
+	if _, err := f.ReadAt(prev, offset); err != nil {
+		return false, err
+	}
+	if bytes.Compare(prev, b) == 0 {
+		return false, nil
+	}
+	if _, err := f.WriteAt(b, offset); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (fileMetadataBackend) Delete(dir, suffix string) error {
+	return os.RemoveAll(filepath.Join(dir, suffix))
+}
+
+func (fileMetadataBackend) List(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if info.Name() != DefaultDataFileName {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+// DeleteAll noops, since metadata files are removed along with the directory
+// of the file entry.
+func (fileMetadataBackend) DeleteAll(dir string) error {
+	return nil
+}
+
+const _metadataSchema = `
+CREATE TABLE IF NOT EXISTS file_metadata (
+	dir    TEXT NOT NULL,
+	suffix TEXT NOT NULL,
+	value  BLOB NOT NULL,
+	PRIMARY KEY (dir, suffix)
+)`
+
+// sqlMetadataBackend stores metadata in a table of an embedded database,
+// which saves an inode per metadata and makes listing metadata a single
+// query instead of a directory scan.
+type sqlMetadataBackend struct {
+	db *sqlx.DB
+}
+
+// NewSQLMetadataBackend returns a MetadataBackend which stores metadata in db,
+// creating its table if it does not exist.
+func NewSQLMetadataBackend(db *sqlx.DB) (MetadataBackend, error) {
+	if _, err := db.Exec(_metadataSchema); err != nil {
+		return nil, fmt.Errorf("create table: %s", err)
+	}
+	return &sqlMetadataBackend{db}, nil
+}
+
+func (s *sqlMetadataBackend) Get(dir, suffix string) ([]byte, error) {
+	return getMetadataValue(s.db, dir, suffix)
+}
+
+func getMetadataValue(q sqlx.Queryer, dir, suffix string) ([]byte, error) {
+	var b []byte
+	err := sqlx.Get(q, &b, `
+		SELECT value FROM file_metadata WHERE dir=? AND suffix=?
+	`, dir, suffix)
+	if err == sql.ErrNoRows {
+		return nil, os.ErrNotExist
+	}
+	return b, err
+}
+
+func (s *sqlMetadataBackend) Set(dir, suffix string, b []byte) (updated bool, err error) {
+	err = s.transact(func(tx *sqlx.Tx) error {
+		prev, err := getMetadataValue(tx, dir, suffix)
+		if err == nil && bytes.Equal(prev, b) {
+			return nil
+		} else if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if _, err := tx.Exec(`
+			INSERT OR REPLACE INTO file_metadata (dir, suffix, value) VALUES (?, ?, ?)
+		`, dir, suffix, b); err != nil {
+			return err
+		}
+		updated = true
+		return nil
+	})
+	return updated, err
+}
+
+func (s *sqlMetadataBackend) SetAt(
+	dir, suffix string, b []byte, offset int64) (updated bool, err error) {
+
+	err = s.transact(func(tx *sqlx.Tx) error {
+		value, err := getMetadataValue(tx, dir, suffix)
+		if err != nil {
+			return err
+		}
+		if offset < 0 || offset+int64(len(b)) > int64(len(value)) {
+			return io.EOF
+		}
+		if bytes.Equal(value[offset:offset+int64(len(b))], b) {
+			return nil
+		}
+		copy(value[offset:], b)
+		if _, err := tx.Exec(`
+			UPDATE file_metadata SET value=? WHERE dir=? AND suffix=?
+		`, value, dir, suffix); err != nil {
+			return err
+		}
+		updated = true
+		return nil
+	})
+	return updated, err
+}
+
+func (s *sqlMetadataBackend) Delete(dir, suffix string) error {
+	_, err := s.db.Exec(`
+		DELETE FROM file_metadata WHERE dir=? AND suffix=?
+	`, dir, suffix)
+	return err
+}
+
+func (s *sqlMetadataBackend) List(dir string) ([]string, error) {
+	var suffixes []string
+	err := s.db.Select(&suffixes, `
+		SELECT suffix FROM file_metadata WHERE dir=?
+	`, dir)
+	return suffixes, err
+}
+
+func (s *sqlMetadataBackend) DeleteAll(dir string) error {
+	_, err := s.db.Exec(`
+		DELETE FROM file_metadata WHERE dir=?
+	`, dir)
+	return err
+}
+
+func (s *sqlMetadataBackend) transact(f func(tx *sqlx.Tx) error) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("begin: %s", err)
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package base
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3" // SQL driver.
+	"github.com/stretchr/testify/require"
+)
+
+func fileMetadataBackendFixture() (MetadataBackend, string, func()) {
+	cleanup := &testutil.Cleanup{}
+	defer cleanup.Recover()
+
+	dir, err := ioutil.TempDir("/tmp", "metadata_backend_test")
+	if err != nil {
+		panic(err)
+	}
+	cleanup.Add(func() { os.RemoveAll(dir) })
+
+	return NewFileMetadataBackend(), dir, cleanup.Run
+}
+
+func sqlMetadataBackendFixture() (MetadataBackend, string, func()) {
+	cleanup := &testutil.Cleanup{}
+	defer cleanup.Recover()
+
+	dir, err := ioutil.TempDir("/tmp", "metadata_backend_test")
+	if err != nil {
+		panic(err)
+	}
+	cleanup.Add(func() { os.RemoveAll(dir) })
+
+	db, err := sqlx.Open("sqlite3", filepath.Join(dir, "metadata.db"))
+	if err != nil {
+		panic(err)
+	}
+	db.SetMaxOpenConns(1)
+	cleanup.Add(func() { db.Close() })
+
+	backend, err := NewSQLMetadataBackend(db)
+	if err != nil {
+		panic(err)
+	}
+	return backend, dir, cleanup.Run
+}
+
+func TestMetadataBackend(t *testing.T) {
+	backends := []struct {
+		name    string
+		fixture func() (MetadataBackend, string, func())
+	}{
+		{"file", fileMetadataBackendFixture},
+		{"sql", sqlMetadataBackendFixture},
+	}
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			t.Run("GetNotExist", func(t *testing.T) {
+				b, dir, cleanup := backend.fixture()
+				defer cleanup()
+
+				_, err := b.Get(dir, "_foo")
+				require.True(t, os.IsNotExist(err))
+			})
+
+			t.Run("SetAndGet", func(t *testing.T) {
+				require := require.New(t)
+
+				b, dir, cleanup := backend.fixture()
+				defer cleanup()
+
+				updated, err := b.Set(dir, "_foo", []byte("abc"))
+				require.NoError(err)
+				require.True(updated)
+
+				updated, err = b.Set(dir, "_foo", []byte("abc"))
+				require.NoError(err)
+				require.False(updated)
+
+				updated, err = b.Set(dir, "_foo", []byte("de"))
+				require.NoError(err)
+				require.True(updated)
+
+				v, err := b.Get(dir, "_foo")
+				require.NoError(err)
+				require.Equal([]byte("de"), v)
+			})
+
+			t.Run("SetAt", func(t *testing.T) {
+				require := require.New(t)
+
+				b, dir, cleanup := backend.fixture()
+				defer cleanup()
+
+				_, err := b.SetAt(dir, "_foo", []byte("x"), 0)
+				require.True(os.IsNotExist(err))
+
+				_, err = b.Set(dir, "_foo", []byte("abc"))
+				require.NoError(err)
+
+				updated, err := b.SetAt(dir, "_foo", []byte("x"), 1)
+				require.NoError(err)
+				require.True(updated)
+
+				updated, err = b.SetAt(dir, "_foo", []byte("x"), 1)
+				require.NoError(err)
+				require.False(updated)
+
+				_, err = b.SetAt(dir, "_foo", []byte("xy"), 2)
+				require.Error(err)
+
+				v, err := b.Get(dir, "_foo")
+				require.NoError(err)
+				require.Equal([]byte("axc"), v)
+			})
+
+			t.Run("ListAndDelete", func(t *testing.T) {
+				require := require.New(t)
+
+				b, dir, cleanup := backend.fixture()
+				defer cleanup()
+
+				_, err := b.Set(dir, "_foo", []byte("a"))
+				require.NoError(err)
+				_, err = b.Set(dir, "_bar", []byte("b"))
+				require.NoError(err)
+
+				suffixes, err := b.List(dir)
+				require.NoError(err)
+				require.ElementsMatch([]string{"_foo", "_bar"}, suffixes)
+
+				require.NoError(b.Delete(dir, "_foo"))
+				suffixes, err = b.List(dir)
+				require.NoError(err)
+				require.Equal([]string{"_bar"}, suffixes)
+			})
+		})
+	}
+}
+
+func TestSQLMetadataBackendDeleteAll(t *testing.T) {
+	require := require.New(t)
+
+	b, dir, cleanup := sqlMetadataBackendFixture()
+	defer cleanup()
+
+	other := filepath.Join(dir, "other")
+
+	_, err := b.Set(dir, "_foo", []byte("a"))
+	require.NoError(err)
+	_, err = b.Set(other, "_foo", []byte("b"))
+	require.NoError(err)
+
+	require.NoError(b.DeleteAll(dir))
+
+	suffixes, err := b.List(dir)
+	require.NoError(err)
+	require.Empty(suffixes)
+
+	v, err := b.Get(other, "_foo")
+	require.NoError(err)
+	require.Equal([]byte("b"), v)
+}
+
+func TestFileStoreWithSQLMetadataBackend(t *testing.T) {
+	require := require.New(t)
+
+	backend, _, cleanup := sqlMetadataBackendFixture()
+	defer cleanup()
+
+	state1, state2, _, cleanupStates := fileStatesFixture()
+	defer cleanupStates()
+
+	store := NewCASFileStore(clock.New(), WithMetadataBackend(backend))
+
+	name := core.DigestFixture().Hex()
+	op := store.NewFileOp().AcceptState(state1)
+	require.NoError(op.CreateFile(name, state1, 5))
+
+	movable := getMockMetadataMovable()
+	movable.content = []byte("moved")
+	_, err := op.SetFileMetadata(name, movable)
+	require.NoError(err)
+
+	other := getMockMetadataOne()
+	other.content = []byte("dropped")
+	_, err = op.SetFileMetadata(name, other)
+	require.NoError(err)
+
+	require.NoError(op.MoveFile(name, state2))
+
+	// No metadata files are written next to the data file.
+	path, err := store.NewFileOp().AcceptState(state2).GetFilePath(name)
+	require.NoError(err)
+	infos, err := ioutil.ReadDir(filepath.Dir(path))
+	require.NoError(err)
+	require.Len(infos, 1)
+
+	// A new store reloads metadata from the backend.
+	store = NewCASFileStore(clock.New(), WithMetadataBackend(backend))
+	op = store.NewFileOp().AcceptState(state2)
+
+	result := getMockMetadataMovable()
+	require.NoError(op.GetFileMetadata(name, result))
+	require.Equal([]byte("moved"), result.content)
+	require.True(os.IsNotExist(op.GetFileMetadata(name, getMockMetadataOne())))
+
+	require.NoError(op.DeleteFile(name))
+	suffixes, err := backend.List(filepath.Dir(path))
+	require.NoError(err)
+	require.Empty(suffixes)
+}
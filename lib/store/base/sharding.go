@@ -0,0 +1,55 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package base
+
+import (
+	"errors"
+	"path/filepath"
+)
+
+// Sharding defines how content-addressable files are spread across nested
+// shard directories. Each of Depth levels is named by the next Width
+// characters of the file name, which is assumed to be hex.
+type Sharding struct {
+	Depth int
+	Width int
+}
+
+// DefaultSharding uses DefaultShardIDLength levels of one byte each.
+var DefaultSharding = Sharding{Depth: DefaultShardIDLength, Width: 2}
+
+// Validate returns an error if s is not a supported layout.
+func (s Sharding) Validate() error {
+	if s.Depth < 1 || s.Depth > 4 {
+		return errors.New("shard depth must be between 1 and 4")
+	}
+	if s.Width < 1 || s.Width > 4 {
+		return errors.New("shard width must be between 1 and 4")
+	}
+	return nil
+}
+
+// RelativePath returns the path of the data file of name under its state
+// directory.
+// Example:
+// name = 07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc
+// Depth = 2, Width = 2
+// relative path = 07/12/07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc/data
+func (s Sharding) RelativePath(name string) string {
+	filePath := ""
+	for i := 0; i < s.Depth && (i+1)*s.Width <= len(name); i++ {
+		filePath = filepath.Join(filePath, name[i*s.Width:(i+1)*s.Width])
+	}
+	return filepath.Join(filePath, name, DefaultDataFileName)
+}
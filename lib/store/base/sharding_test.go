@@ -0,0 +1,41 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package base
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardingRelativePath(t *testing.T) {
+	name := "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"
+	tests := []struct {
+		sharding Sharding
+		expected string
+	}{
+		{DefaultSharding, "07/12/" + name + "/data"},
+		{Sharding{Depth: 1, Width: 1}, "0/" + name + "/data"},
+		{Sharding{Depth: 3, Width: 3}, "071/23e/1f4/" + name + "/data"},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.expected, test.sharding.RelativePath(name))
+	}
+}
+
+func TestShardingValidate(t *testing.T) {
+	require.NoError(t, DefaultSharding.Validate())
+	require.Error(t, Sharding{Depth: 0, Width: 2}.Validate())
+	require.Error(t, Sharding{Depth: 2, Width: 5}.Validate())
+}
@@ -30,10 +30,24 @@ type CADownloadStore struct {
 	downloadState base.FileState
 	cacheState    base.FileState
 	cleanup       *cleanupManager
+	allocation    AllocationMode
+	volumes       []Volume
+	encrypted     bool
+	fsync         *fsyncer
+	closeMetadata func()
 }
 
 // NewCADownloadStore creates a new CADownloadStore.
 func NewCADownloadStore(config CADownloadStoreConfig, stats tally.Scope) (*CADownloadStore, error) {
+	config = config.applyDefaults()
+	if err := config.Allocation.validate(); err != nil {
+		return nil, err
+	}
+	sharding := config.Sharding.sharding()
+	if err := sharding.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid sharding: %s", err)
+	}
+
 	stats = stats.Tagged(map[string]string{
 		"module": "cadownloadstore",
 	})
@@ -44,39 +58,124 @@ func NewCADownloadStore(config CADownloadStoreConfig, stats tally.Scope) (*CADow
 		}
 	}
 
-	backend := base.NewCASFileStore(clock.New())
+	if err := initVolumes(
+		[]string{config.DownloadDir, config.CacheDir}, config.Volumes, sharding.Width); err != nil {
+		return nil, fmt.Errorf("init volumes: %s", err)
+	}
+
+	cipher, err := newFileCipher(config.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("new file cipher: %s", err)
+	}
+	opts := []base.FileStoreOption{}
+	if cipher != nil {
+		opts = append(opts, base.WithEncryption(cipher))
+	}
+
+	metadataBackend, closeMetadata, err := newMetadataBackend(config.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("new metadata backend: %s", err)
+	}
+	for _, dir := range []string{config.DownloadDir, config.CacheDir} {
+		if _, err := migrateSharding(dir, sharding, metadataBackend); err != nil {
+			closeMetadata()
+			return nil, fmt.Errorf("migrate sharding of %s: %s", dir, err)
+		}
+	}
+	opts = append(opts, base.WithMetadataBackend(metadataBackend), base.WithSharding(sharding))
+
+	backend := base.NewCASFileStore(clock.New(), opts...)
 	downloadState := base.NewFileState(config.DownloadDir)
 	cacheState := base.NewFileState(config.CacheDir)
 
 	cleanup, err := newCleanupManager(clock.New(), stats)
 	if err != nil {
+		closeMetadata()
 		return nil, fmt.Errorf("new cleanup manager: %s", err)
 	}
-	cleanup.addJob(
+	if err := cleanup.addJob(
 		"download",
 		config.DownloadCleanup,
-		backend.NewFileOp().AcceptState(downloadState))
-	cleanup.addJob(
+		backend.NewFileOp().AcceptState(downloadState)); err != nil {
+		closeMetadata()
+		return nil, err
+	}
+	if err := cleanup.addJob(
 		"cache",
 		config.CacheCleanup,
-		backend.NewFileOp().AcceptState(cacheState))
+		backend.NewFileOp().AcceptState(cacheState)); err != nil {
+		cleanup.stop()
+		closeMetadata()
+		return nil, err
+	}
+	cleanup.addVolumeJob(config.Volumes)
 
-	return &CADownloadStore{
+	s := &CADownloadStore{
 		backend:       backend,
 		downloadState: downloadState,
 		cacheState:    cacheState,
 		cleanup:       cleanup,
-	}, nil
+		allocation:    config.Allocation,
+		volumes:       config.Volumes,
+		encrypted:     cipher != nil,
+		closeMetadata: closeMetadata,
+	}
+	s.fsync, err = newFsyncer(config.Fsync, clock.New(), stats, s.GetDownloadFileReadWriter)
+	if err != nil {
+		cleanup.stop()
+		closeMetadata()
+		return nil, fmt.Errorf("invalid fsync config: %s", err)
+	}
+	return s, nil
 }
 
-// Close terminates all goroutines started by s.
+// Close terminates all goroutines started by s and releases its metadata
+// backend.
 func (s *CADownloadStore) Close() {
 	s.cleanup.stop()
+	s.fsync.stop()
+	s.closeMetadata()
+}
+
+// CleanupReport returns what each cleanup job of s would delete if it ran now,
+// without deleting anything.
+func (s *CADownloadStore) CleanupReport() ([]CleanupReport, error) {
+	return s.cleanup.reports()
+}
+
+// VolumeStats returns the capacity of each configured volume.
+func (s *CADownloadStore) VolumeStats() ([]VolumeStat, error) {
+	return statVolumes(s.volumes)
+}
+
+// Encrypted returns true if blob data is encrypted at rest, in which case files
+// must be accessed through readers rather than their paths.
+func (s *CADownloadStore) Encrypted() bool {
+	return s.encrypted
 }
 
 // CreateDownloadFile creates an empty download file initialized with length.
+// Disk space for the file is reserved according to the configured
+// AllocationMode.
 func (s *CADownloadStore) CreateDownloadFile(name string, length int64) error {
-	return s.backend.NewFileOp().CreateFile(name, s.downloadState, length)
+	op := s.backend.NewFileOp()
+	if err := op.CreateFile(name, s.downloadState, length); err != nil {
+		return err
+	}
+	if s.allocation != AllocationPreallocate || length <= 0 {
+		return nil
+	}
+	path, err := op.AcceptState(s.downloadState).GetFilePath(name)
+	if err != nil {
+		return fmt.Errorf("get path: %s", err)
+	}
+	if s.encrypted {
+		length = base.EncryptedSize(length)
+	}
+	if err := preallocate(path, length); err != nil {
+		return fmt.Errorf("preallocate: %s", err)
+	}
+	return nil
 }
 
 // GetDownloadFileReadWriter returns a FileReadWriter for name.
@@ -84,8 +183,18 @@ func (s *CADownloadStore) GetDownloadFileReadWriter(name string) (FileReadWriter
 	return s.backend.NewFileOp().AcceptState(s.downloadState).GetFileReadWriter(name)
 }
 
-// MoveDownloadFileToCache moves a download file to the cache.
+// DownloadFileWritten records a completed write to download file name through
+// w, flushing w to disk if the configured FsyncPolicy requires it.
+func (s *CADownloadStore) DownloadFileWritten(name string, w FileReadWriter) error {
+	return s.fsync.written(name, w)
+}
+
+// MoveDownloadFileToCache moves a download file to the cache. The file is
+// flushed to disk first unless the FsyncPolicy is FsyncNever.
 func (s *CADownloadStore) MoveDownloadFileToCache(name string) error {
+	if err := s.fsync.complete(name); err != nil {
+		return fmt.Errorf("sync: %s", err)
+	}
 	return s.backend.NewFileOp().AcceptState(s.downloadState).MoveFile(name, s.cacheState)
 }
 
@@ -165,6 +274,16 @@ func (a *CADownloadStoreScope) GetFileStat(name string) (os.FileInfo, error) {
 	return a.op.GetFileStat(name)
 }
 
+// GetFilePath returns the path of name on disk.
+func (a *CADownloadStoreScope) GetFilePath(name string) (string, error) {
+	return a.op.GetFilePath(name)
+}
+
+// ListNames returns the names of all files in the scoped states.
+func (a *CADownloadStoreScope) ListNames() ([]string, error) {
+	return a.op.ListNames()
+}
+
 // DeleteFile deletes name.
 func (a *CADownloadStoreScope) DeleteFile(name string) error {
 	return a.op.DeleteFile(name)
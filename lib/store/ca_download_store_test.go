@@ -15,12 +15,16 @@ package store
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 
 	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/testutil"
 
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 )
 
 func TestCADownloadStoreDownloadAndDeleteFiles(t *testing.T) {
@@ -49,3 +53,144 @@ func TestCADownloadStoreDownloadAndDeleteFiles(t *testing.T) {
 		require.True(os.IsNotExist(err))
 	}
 }
+
+func TestCADownloadStoreAllocationModes(t *testing.T) {
+	for _, mode := range []AllocationMode{AllocationSparse, AllocationPreallocate} {
+		t.Run(string(mode), func(t *testing.T) {
+			require := require.New(t)
+
+			cleanup := &testutil.Cleanup{}
+			defer cleanup.Run()
+
+			config := CADownloadStoreConfig{
+				DownloadDir: tempdir(cleanup, "download"),
+				CacheDir:    tempdir(cleanup, "cache"),
+				Allocation:  mode,
+			}
+			s, err := NewCADownloadStore(config, tally.NoopScope)
+			require.NoError(err)
+			defer s.Close()
+
+			name := core.DigestFixture().Hex()
+			require.NoError(s.CreateDownloadFile(name, 1024))
+
+			info, err := s.Download().GetFileStat(name)
+			require.NoError(err)
+			require.Equal(int64(1024), info.Size())
+		})
+	}
+}
+
+func TestNewCADownloadStoreInvalidAllocation(t *testing.T) {
+	cleanup := &testutil.Cleanup{}
+	defer cleanup.Run()
+
+	config := CADownloadStoreConfig{
+		DownloadDir: tempdir(cleanup, "download"),
+		CacheDir:    tempdir(cleanup, "cache"),
+		Allocation:  "bogus",
+	}
+	_, err := NewCADownloadStore(config, tally.NoopScope)
+	require.Error(t, err)
+}
+
+func TestCADownloadStoreVolumes(t *testing.T) {
+	require := require.New(t)
+
+	cleanup := &testutil.Cleanup{}
+	defer cleanup.Run()
+
+	volume1 := tempdir(cleanup, "volume")
+	volume2 := tempdir(cleanup, "volume")
+
+	config := CADownloadStoreConfig{
+		DownloadDir: tempdir(cleanup, "download"),
+		CacheDir:    tempdir(cleanup, "cache"),
+		Volumes: []Volume{
+			{Location: volume1, Weight: 100},
+			{Location: volume2, Weight: 100},
+		},
+	}
+	s, err := NewCADownloadStore(config, tally.NoopScope)
+	require.NoError(err)
+	defer s.Close()
+
+	onVolume := func(p string) bool {
+		resolved, err := filepath.EvalSymlinks(p)
+		require.NoError(err)
+		return strings.HasPrefix(resolved, volume1) || strings.HasPrefix(resolved, volume2)
+	}
+
+	for i := 0; i < 10; i++ {
+		name := core.DigestFixture().Hex()
+		require.NoError(s.CreateDownloadFile(name, 1))
+
+		p, err := s.Download().GetFilePath(name)
+		require.NoError(err)
+		require.True(onVolume(p))
+
+		require.NoError(s.MoveDownloadFileToCache(name))
+
+		p, err = s.Cache().GetFilePath(name)
+		require.NoError(err)
+		require.True(onVolume(p))
+	}
+
+	stats, err := s.VolumeStats()
+	require.NoError(err)
+	require.Len(stats, 2)
+	for _, stat := range stats {
+		require.True(stat.Size > 0)
+		require.True(stat.Free <= stat.Size)
+	}
+}
+
+func TestNewCADownloadStoreVolumesKeepsExistingShards(t *testing.T) {
+	require := require.New(t)
+
+	cleanup := &testutil.Cleanup{}
+	defer cleanup.Run()
+
+	download := tempdir(cleanup, "download")
+	cache := tempdir(cleanup, "cache")
+
+	// Initialize a file before volumes are configured.
+	s, err := NewCADownloadStore(
+		CADownloadStoreConfig{DownloadDir: download, CacheDir: cache}, tally.NoopScope)
+	require.NoError(err)
+	name := core.DigestFixture().Hex()
+	require.NoError(s.CreateDownloadFile(name, 1))
+	s.Close()
+
+	config := CADownloadStoreConfig{
+		DownloadDir: download,
+		CacheDir:    cache,
+		Volumes:     []Volume{{Location: tempdir(cleanup, "volume"), Weight: 100}},
+	}
+	s, err = NewCADownloadStore(config, tally.NoopScope)
+	require.NoError(err)
+	defer s.Close()
+
+	_, err = s.Download().GetFileStat(name)
+	require.NoError(err)
+}
+
+func TestCADownloadStoreKeepsSingleCopyOfDigest(t *testing.T) {
+	require := require.New(t)
+
+	s, cleanup := CADownloadStoreFixture()
+	defer cleanup()
+
+	name := core.DigestFixture().Hex()
+	require.NoError(s.CreateDownloadFile(name, 1))
+	require.NoError(s.MoveDownloadFileToCache(name))
+
+	// A digest lives in exactly one state, so cached blobs are never duplicated
+	// by a second download.
+	err := s.CreateDownloadFile(name, 1)
+	require.Error(err)
+	require.True(s.InCacheError(err))
+
+	_, err = s.Download().GetFileStat(name)
+	require.Error(err)
+}
@@ -15,18 +15,15 @@ package store
 
 import (
 	"fmt"
-	"hash"
 	"io"
 	"os"
-	"path"
 
 	"github.com/andres-erbsen/clock"
 	"github.com/docker/distribution/uuid"
-	"github.com/spaolacci/murmur3"
 	"github.com/uber-go/tally"
 	"github.com/uber/kraken/core"
-	"github.com/uber/kraken/lib/hrw"
 	"github.com/uber/kraken/lib/store/base"
+	"go.uber.org/atomic"
 )
 
 // CAStore allows uploading / caching content-addressable files.
@@ -35,7 +32,10 @@ type CAStore struct {
 
 	*uploadStore
 	*cacheStore
-	cleanup *cleanupManager
+	cleanup  *cleanupManager
+	scrub    *scrubber
+	readOnly *atomic.Bool
+	directIO *directIO
 }
 
 // NewCAStore creates a new CAStore.
@@ -46,51 +46,96 @@ func NewCAStore(config CAStoreConfig, stats tally.Scope) (*CAStore, error) {
 		"module": "castore",
 	})
 
-	uploadStore, err := newUploadStore(config.UploadDir)
+	uploadStore, err := newUploadStore(config.UploadDir, config.ResumableUploads)
 	if err != nil {
 		return nil, fmt.Errorf("new upload store: %s", err)
 	}
 
-	cacheBackend := base.NewCASFileStoreWithLRUMap(config.Capacity, clock.New())
+	sharding := config.Sharding.sharding()
+	if err := sharding.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid sharding: %s", err)
+	}
+
+	cacheBackend := base.NewCASFileStoreWithLRUMap(
+		config.Capacity, clock.New(), base.WithSharding(sharding))
 	cacheStore, err := newCacheStore(config.CacheDir, cacheBackend)
 	if err != nil {
 		return nil, fmt.Errorf("new cache store: %s", err)
 	}
 
-	if err := initCASVolumes(config.CacheDir, config.Volumes); err != nil {
+	if err := initVolumes([]string{config.CacheDir}, config.Volumes, sharding.Width); err != nil {
 		return nil, fmt.Errorf("init cas volumes: %s", err)
 	}
 
+	if _, err := migrateSharding(
+		config.CacheDir, sharding, base.NewFileMetadataBackend()); err != nil {
+		return nil, fmt.Errorf("migrate sharding: %s", err)
+	}
+
 	cleanup, err := newCleanupManager(clock.New(), stats)
 	if err != nil {
 		return nil, fmt.Errorf("new cleanup manager: %s", err)
 	}
-	cleanup.addJob("upload", config.UploadCleanup, uploadStore.newFileOp())
-	cleanup.addJob("cache", config.CacheCleanup, cacheStore.newFileOp())
+	if err := cleanup.addJob("upload", config.UploadCleanup, uploadStore.newFileOp()); err != nil {
+		return nil, err
+	}
+	if err := cleanup.addJob("cache", config.CacheCleanup, cacheStore.newFileOp()); err != nil {
+		cleanup.stop()
+		return nil, err
+	}
+	cleanup.addVolumeJob(config.Volumes)
+
+	readOnly := atomic.NewBool(false)
+
+	var scrub *scrubber
+	if config.Scrub.Enabled {
+		scrub, err = newScrubber(
+			config.Scrub, clock.New(), stats, cacheStore.newFileOp(), readOnly)
+		if err != nil {
+			cleanup.stop()
+			return nil, fmt.Errorf("new scrubber: %s", err)
+		}
+		scrub.start()
+	}
 
-	return &CAStore{config, uploadStore, cacheStore, cleanup}, nil
+	s := &CAStore{
+		config, uploadStore, cacheStore, cleanup, scrub, readOnly, newDirectIO(config.DirectIO)}
+	s.SetReadOnly(config.ReadOnly)
+	return s, nil
 }
 
 // Close terminates any goroutines started by s.
 func (s *CAStore) Close() {
 	s.cleanup.stop()
+	if s.scrub != nil {
+		s.scrub.stop()
+	}
+}
+
+// CleanupReport returns what each cleanup job of s would delete if it ran now,
+// without deleting anything.
+func (s *CAStore) CleanupReport() ([]CleanupReport, error) {
+	return s.cleanup.reports()
+}
+
+// VolumeStats returns the capacity of each configured volume.
+func (s *CAStore) VolumeStats() ([]VolumeStat, error) {
+	return statVolumes(s.config.Volumes)
 }
 
 // MoveUploadFileToCache commits uploadName as cacheName. Clients are expected
 // to validate the content of the upload file matches the cacheName digest.
 func (s *CAStore) MoveUploadFileToCache(uploadName, cacheName string) error {
+	if s.ReadOnly() {
+		return ErrReadOnly
+	}
 	uploadPath, err := s.uploadStore.newFileOp().GetFilePath(uploadName)
 	if err != nil {
 		return err
 	}
 
-	f, err := s.uploadStore.newFileOp().GetFileReader(uploadName)
-	if err != nil {
-		return fmt.Errorf("get file reader %s: %s", uploadName, err)
-	}
-	defer f.Close()
-	if err := s.verify(f, cacheName); err != nil {
-		return fmt.Errorf("verify digest: %s", err)
+	if err := s.verifyUpload(uploadName, cacheName); err != nil {
+		return err
 	}
 
 	defer s.DeleteUploadFile(uploadName)
@@ -137,6 +182,35 @@ func (s *CAStore) WriteCacheFile(name string, write func(w FileReadWriter) error
 	return nil
 }
 
+// verifyUpload verifies the content of upload file uploadName matches the
+// cacheName digest. Uploads written with AppendUploadFile are verified from
+// their session without reading them again.
+func (s *CAStore) verifyUpload(uploadName, cacheName string) error {
+	if !s.config.SkipHashVerification {
+		computed, ok, err := s.sessionDigest(uploadName)
+		if err != nil {
+			return fmt.Errorf("session digest: %s", err)
+		}
+		if ok {
+			if computed.Hex() != cacheName {
+				return fmt.Errorf(
+					"verify digest: computed digest %s doesn't match expected value %s",
+					computed.Hex(), cacheName)
+			}
+			return nil
+		}
+	}
+	f, err := s.sequentialReader(s.uploadStore.newFileOp(), uploadName)
+	if err != nil {
+		return fmt.Errorf("get file reader %s: %s", uploadName, err)
+	}
+	defer f.Close()
+	if err := s.verify(f, cacheName); err != nil {
+		return fmt.Errorf("verify digest: %s", err)
+	}
+	return nil
+}
+
 // verify verifies that name is a valid SHA256 digest, and checks if the given
 // blob content matches the digset unless explicitly skipped.
 func (s *CAStore) verify(r io.Reader, name string) error {
@@ -158,39 +232,3 @@ func (s *CAStore) verify(r io.Reader, name string) error {
 	}
 	return nil
 }
-
-func initCASVolumes(dir string, volumes []Volume) error {
-	if len(volumes) == 0 {
-		return nil
-	}
-
-	rendezvousHash := hrw.NewRendezvousHash(
-		func() hash.Hash { return murmur3.New64() },
-		hrw.UInt64ToFloat64)
-
-	for _, v := range volumes {
-		if _, err := os.Stat(v.Location); err != nil {
-			return fmt.Errorf("verify volume: %s", err)
-		}
-		rendezvousHash.AddNode(v.Location, v.Weight)
-	}
-
-	// Create 256 symlinks under dir.
-	for subdirIndex := 0; subdirIndex < 256; subdirIndex++ {
-		subdirName := fmt.Sprintf("%02X", subdirIndex)
-		nodes := rendezvousHash.GetOrderedNodes(subdirName, 1)
-		if len(nodes) != 1 {
-			return fmt.Errorf("calculate volume for subdir: %s", subdirName)
-		}
-		sourcePath := path.Join(nodes[0].Label, path.Base(dir), subdirName)
-		if err := os.MkdirAll(sourcePath, 0775); err != nil {
-			return fmt.Errorf("volume source path: %s", err)
-		}
-		targetPath := path.Join(dir, subdirName)
-		if err := createOrUpdateSymlink(sourcePath, targetPath); err != nil {
-			return fmt.Errorf("symlink to volume: %s", err)
-		}
-	}
-
-	return nil
-}
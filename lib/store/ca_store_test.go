@@ -14,6 +14,7 @@
 package store
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"path"
@@ -184,3 +185,30 @@ func TestCAStoreCreateCacheFile(t *testing.T) {
 	b2, err := ioutil.ReadAll(r2)
 	require.Equal(s1, string(b2))
 }
+
+func TestCAStoreReadOnly(t *testing.T) {
+	require := require.New(t)
+
+	s, cleanup := CAStoreFixture()
+	defer cleanup()
+
+	blob := core.NewBlobFixture()
+	require.NoError(s.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+
+	s.SetReadOnly(true)
+	require.True(s.ReadOnly())
+
+	r, err := s.GetCacheFileReader(blob.Digest.Hex())
+	require.NoError(err)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(err)
+	require.Equal(blob.Content, b)
+
+	other := core.NewBlobFixture()
+	require.Equal(ErrReadOnly, s.CreateUploadFile("upload", 0))
+	require.Error(s.CreateCacheFile(other.Digest.Hex(), bytes.NewReader(other.Content)))
+	require.Equal(ErrReadOnly, s.DeleteCacheFile(blob.Digest.Hex()))
+
+	s.SetReadOnly(false)
+	require.NoError(s.DeleteCacheFile(blob.Digest.Hex()))
+}
@@ -25,6 +25,7 @@ import (
 
 	"github.com/andres-erbsen/clock"
 	"github.com/uber-go/tally"
+	"go.uber.org/atomic"
 )
 
 // CleanupConfig defines configuration for periodically cleaning up idle files.
@@ -33,6 +34,13 @@ type CleanupConfig struct {
 	Interval time.Duration `yaml:"interval"` // How often cleanup runs.
 	TTI      time.Duration `yaml:"tti"`      // Time to idle based on last access time.
 	TTL      time.Duration `yaml:"ttl"`      // Time to live regardless of access. If 0, disables TTL.
+
+	// Eviction deletes files by policy once disk usage exceeds a watermark.
+	Eviction EvictionConfig `yaml:"eviction"`
+
+	// DryRun logs what cleanup would delete instead of deleting it. Useful
+	// for validating settings via the cleanup report before enabling them.
+	DryRun bool `yaml:"dry_run"`
 }
 
 func (c CleanupConfig) applyDefaults() CleanupConfig {
@@ -42,6 +50,7 @@ func (c CleanupConfig) applyDefaults() CleanupConfig {
 	if c.TTI == 0 {
 		c.TTI = 6 * time.Hour
 	}
+	c.Eviction = c.Eviction.applyDefaults()
 	return c
 }
 
@@ -50,6 +59,19 @@ type cleanupManager struct {
 	stats    tally.Scope
 	stopOnce sync.Once
 	stopc    chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]cleanupJob
+
+	// Cleanup is paused while the store is read-only.
+	readOnly *atomic.Bool
+}
+
+// cleanupJob holds the settings of a job added to a cleanupManager.
+type cleanupJob struct {
+	op     base.FileOp
+	config CleanupConfig
+	policy EvictionPolicy
 }
 
 func newCleanupManager(clk clock.Clock, stats tally.Scope) (*cleanupManager, error) {
@@ -62,38 +84,76 @@ func newCleanupManager(clk clock.Clock, stats tally.Scope) (*cleanupManager, err
 		"hostname": hostname,
 	})
 	return &cleanupManager{
-		clk:   clk,
-		stats: stats,
-		stopc: make(chan struct{}),
+		clk:      clk,
+		stats:    stats,
+		stopc:    make(chan struct{}),
+		jobs:     make(map[string]cleanupJob),
+		readOnly: atomic.NewBool(false),
 	}, nil
 }
 
 // addJob starts a background cleanup task which removes idle files from op based
 // on the settings in config. op must set the desired states to clean before addJob
-// is called.
-func (m *cleanupManager) addJob(tag string, config CleanupConfig, op base.FileOp) {
+// is called. Returns an error if config is invalid.
+func (m *cleanupManager) addJob(tag string, config CleanupConfig, op base.FileOp) error {
 	config = config.applyDefaults()
 	if config.Disabled {
 		log.Warnf("Cleanup disabled for %s", op)
-		return
+		return nil
+	}
+	if err := config.Eviction.validate(); err != nil {
+		return fmt.Errorf("invalid eviction config for %s: %s", tag, err)
+	}
+	var policy EvictionPolicy
+	if config.Eviction.enabled() {
+		// Validated above.
+		policy, _ = getEvictionPolicy(config.Eviction.Policy)
 	}
 	if config.TTL == 0 {
 		log.Warnf("TTL disabled for %s", op)
 	}
 
+	m.mu.Lock()
+	m.jobs[tag] = cleanupJob{op, config, policy}
+	m.mu.Unlock()
+
 	ticker := m.clk.Ticker(config.Interval)
 
-	usageGauge := m.stats.Tagged(map[string]string{"job": tag}).Gauge("disk_usage")
+	stats := m.stats.Tagged(map[string]string{"job": tag})
+	usageGauge := stats.Gauge("disk_usage")
 
 	go func() {
 		for {
 			select {
 			case <-ticker.C:
+				if m.readOnly.Load() {
+					log.Debugf("Skipping cleanup of read-only %s", op)
+					continue
+				}
+				if config.DryRun {
+					report, err := m.plan(tag, op, config, policy)
+					if err != nil {
+						log.Errorf("Error planning cleanup of %s: %s", op, err)
+					}
+					log.Infof("Dry run cleanup of %s would delete %d files (%d bytes)",
+						op, len(report.Entries), report.Usage-report.UsageAfter)
+					stats.Gauge("dry_run_deletable_files").Update(float64(len(report.Entries)))
+					stats.Gauge("dry_run_deletable_bytes").Update(
+						float64(report.Usage - report.UsageAfter))
+					usageGauge.Update(float64(report.Usage))
+					continue
+				}
 				log.Debugf("Performing cleanup of %s", op)
 				usage, err := m.scan(op, config.TTI, config.TTL)
 				if err != nil {
 					log.Errorf("Error scanning %s: %s", op, err)
 				}
+				if policy != nil {
+					usage, err = m.evict(op, config.Eviction, policy)
+					if err != nil {
+						log.Errorf("Error evicting %s: %s", op, err)
+					}
+				}
 				usageGauge.Update(float64(usage))
 			case <-m.stopc:
 				ticker.Stop()
@@ -101,6 +161,12 @@ func (m *cleanupManager) addJob(tag string, config CleanupConfig, op base.FileOp
 			}
 		}
 	}()
+	return nil
+}
+
+// setReadOnly pauses or resumes all jobs of m.
+func (m *cleanupManager) setReadOnly(readOnly bool) {
+	m.readOnly.Store(readOnly)
 }
 
 func (m *cleanupManager) stop() {
@@ -141,15 +207,31 @@ func (m *cleanupManager) readyForDeletion(
 	tti time.Duration,
 	ttl time.Duration) (bool, error) {
 
+	reason, err := m.expiryReason(op, name, info, tti, ttl)
+	return reason != "", err
+}
+
+// expiryReason returns why name is ready for deletion, or an empty string if
+// it is not.
+func (m *cleanupManager) expiryReason(
+	op base.FileOp,
+	name string,
+	info os.FileInfo,
+	tti time.Duration,
+	ttl time.Duration) (string, error) {
+
 	if ttl > 0 && m.clk.Now().Sub(info.ModTime()) > ttl {
-		return true, nil
+		return CleanupReasonTTL, nil
 	}
 
 	var lat metadata.LastAccessTime
 	if err := op.GetFileMetadata(name, &lat); os.IsNotExist(err) {
-		return false, nil
+		return "", nil
 	} else if err != nil {
-		return false, fmt.Errorf("get file lat: %s", err)
+		return "", fmt.Errorf("get file lat: %s", err)
+	}
+	if m.clk.Now().Sub(lat.Time) > tti {
+		return CleanupReasonTTI, nil
 	}
-	return m.clk.Now().Sub(lat.Time) > tti, nil
+	return "", nil
 }
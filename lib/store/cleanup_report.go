@@ -0,0 +1,147 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/uber/kraken/lib/store/base"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/log"
+)
+
+// Reasons a file is deleted by cleanup.
+const (
+	CleanupReasonTTL      = "ttl"
+	CleanupReasonTTI      = "tti"
+	CleanupReasonEviction = "eviction"
+)
+
+// CleanupReportEntry describes a file which cleanup would delete.
+type CleanupReportEntry struct {
+	Name           string    `json:"name"`
+	Size           int64     `json:"size"`
+	LastAccessTime time.Time `json:"last_access_time"`
+	Reason         string    `json:"reason"`
+}
+
+// CleanupReport describes what a cleanup job would delete if it ran now.
+type CleanupReport struct {
+	Job        string               `json:"job"`
+	DryRun     bool                 `json:"dry_run"`
+	Usage      int64                `json:"usage"`       // Disk usage before cleanup.
+	UsageAfter int64                `json:"usage_after"` // Disk usage after cleanup.
+	Entries    []CleanupReportEntry `json:"entries"`
+}
+
+// reports plans every job without deleting anything. Reports are sorted by job.
+func (m *cleanupManager) reports() ([]CleanupReport, error) {
+	m.mu.Lock()
+	jobs := make(map[string]cleanupJob, len(m.jobs))
+	for tag, job := range m.jobs {
+		jobs[tag] = job
+	}
+	m.mu.Unlock()
+
+	var reports []CleanupReport
+	for tag, job := range jobs {
+		report, err := m.plan(tag, job.op, job.config, job.policy)
+		if err != nil {
+			return nil, fmt.Errorf("plan %s: %s", tag, err)
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Job < reports[j].Job })
+	return reports, nil
+}
+
+// plan mirrors scan followed by evict, returning the files they would delete
+// from op instead of deleting them. Persisted files are never included.
+func (m *cleanupManager) plan(
+	tag string, op base.FileOp, config CleanupConfig, policy EvictionPolicy) (CleanupReport, error) {
+
+	report := CleanupReport{
+		Job:     tag,
+		DryRun:  config.DryRun,
+		Entries: []CleanupReportEntry{},
+	}
+	names, err := op.ListNames()
+	if err != nil {
+		return report, fmt.Errorf("list names: %s", err)
+	}
+	var remaining []EvictionCandidate
+	persisted := make(map[string]bool)
+	for _, name := range names {
+		info, err := op.GetFileStat(name)
+		if err != nil {
+			log.With("name", name).Errorf("Error getting file stat: %s", err)
+			continue
+		}
+		c, err := m.newEvictionCandidate(op, name)
+		if err != nil {
+			log.With("name", name).Errorf("Error getting eviction candidate: %s", err)
+			continue
+		}
+		report.Usage += c.Size
+
+		var persist metadata.Persist
+		if err := op.GetFileMetadata(name, &persist); err == nil && persist.Value {
+			persisted[name] = true
+		} else if err != nil && !os.IsNotExist(err) {
+			log.With("name", name).Errorf("Error getting persist metadata: %s", err)
+			continue
+		}
+
+		reason, err := m.expiryReason(op, name, info, config.TTI, config.TTL)
+		if err != nil {
+			log.With("name", name).Errorf("Error checking if file expired: %s", err)
+		} else if reason != "" && !persisted[name] {
+			report.Entries = append(report.Entries, newCleanupReportEntry(c, reason))
+			continue
+		}
+		remaining = append(remaining, c)
+	}
+
+	usage := report.Usage
+	for _, e := range report.Entries {
+		usage -= e.Size
+	}
+	if policy != nil && usage > int64(config.Eviction.HighWatermark) {
+		policy.Sort(remaining)
+		for _, c := range remaining {
+			if usage <= int64(config.Eviction.LowWatermark) {
+				break
+			}
+			if persisted[c.Name] {
+				continue
+			}
+			report.Entries = append(report.Entries, newCleanupReportEntry(c, CleanupReasonEviction))
+			usage -= c.Size
+		}
+	}
+	report.UsageAfter = usage
+	return report, nil
+}
+
+func newCleanupReportEntry(c EvictionCandidate, reason string) CleanupReportEntry {
+	return CleanupReportEntry{
+		Name:           c.Name,
+		Size:           c.Size,
+		LastAccessTime: c.LastAccessTime,
+		Reason:         reason,
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestCleanupManagerPlanReportsIdleFilesWithoutDeleting(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+	config := CleanupConfig{TTI: 6 * time.Hour, DryRun: true}
+
+	m, err := newCleanupManager(clk, tally.NoopScope)
+	require.NoError(err)
+	defer m.stop()
+
+	state, op, cleanup := fileOpFixture(clk)
+	defer cleanup()
+
+	idle := core.DigestFixture().Hex()
+	require.NoError(op.CreateFile(idle, state, 5))
+
+	persisted := core.DigestFixture().Hex()
+	require.NoError(op.CreateFile(persisted, state, 5))
+	_, err = op.SetFileMetadata(persisted, metadata.NewPersist(true))
+	require.NoError(err)
+
+	clk.Add(config.TTI + 1)
+
+	active := core.DigestFixture().Hex()
+	require.NoError(op.CreateFile(active, state, 5))
+
+	report, err := m.plan("test", op, config, nil)
+	require.NoError(err)
+	require.Equal("test", report.Job)
+	require.True(report.DryRun)
+	require.Equal(int64(15), report.Usage)
+	require.Equal(int64(10), report.UsageAfter)
+	require.Len(report.Entries, 1)
+	require.Equal(idle, report.Entries[0].Name)
+	require.Equal(int64(5), report.Entries[0].Size)
+	require.Equal(CleanupReasonTTI, report.Entries[0].Reason)
+
+	for _, name := range []string{idle, persisted, active} {
+		_, err := op.GetFileStat(name)
+		require.NoError(err)
+	}
+}
+
+func TestCleanupManagerPlanReportsEvictions(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+	config := CleanupConfig{
+		TTI:      24 * time.Hour,
+		Eviction: EvictionConfig{Policy: "lru", HighWatermark: 15, LowWatermark: 10},
+	}
+
+	m, err := newCleanupManager(clk, tally.NoopScope)
+	require.NoError(err)
+	defer m.stop()
+
+	state, op, cleanup := fileOpFixture(clk)
+	defer cleanup()
+
+	var names []string
+	for i := 0; i < 4; i++ {
+		name := core.DigestFixture().Hex()
+		names = append(names, name)
+		require.NoError(op.CreateFile(name, state, 5))
+		_, err := op.SetFileMetadata(
+			name, metadata.NewLastAccessTime(clk.Now().Add(time.Duration(i)*time.Hour)))
+		require.NoError(err)
+	}
+
+	report, err := m.plan("test", op, config, LRUPolicy{})
+	require.NoError(err)
+	require.Equal(int64(20), report.Usage)
+	require.Equal(int64(10), report.UsageAfter)
+
+	var evicted []string
+	for _, e := range report.Entries {
+		require.Equal(CleanupReasonEviction, e.Reason)
+		evicted = append(evicted, e.Name)
+	}
+	require.Equal(names[:2], evicted)
+
+	listed, err := op.ListNames()
+	require.NoError(err)
+	require.Len(listed, 4)
+}
+
+func TestCleanupManagerReports(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+
+	m, err := newCleanupManager(clk, tally.NoopScope)
+	require.NoError(err)
+	defer m.stop()
+
+	_, op1, cleanup1 := fileOpFixture(clk)
+	defer cleanup1()
+	_, op2, cleanup2 := fileOpFixture(clk)
+	defer cleanup2()
+
+	require.NoError(m.addJob("b", CleanupConfig{}, op1))
+	require.NoError(m.addJob("a", CleanupConfig{}, op2))
+	require.NoError(m.addJob("disabled", CleanupConfig{Disabled: true}, op2))
+
+	reports, err := m.reports()
+	require.NoError(err)
+	require.Len(reports, 2)
+	require.Equal("a", reports[0].Job)
+	require.Equal("b", reports[1].Job)
+}
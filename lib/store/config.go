@@ -30,6 +30,23 @@ type CAStoreConfig struct {
 	UploadCleanup CleanupConfig `yaml:"upload_cleanup"`
 	CacheCleanup  CleanupConfig `yaml:"cache_cleanup"`
 
+	// Sharding controls the directory layout of cache files.
+	Sharding ShardingConfig `yaml:"sharding"`
+
+	// ReadOnly starts the store in read-only mode. See CAStore.SetReadOnly.
+	ReadOnly bool `yaml:"read_only"`
+
+	// Scrub periodically verifies cache files against their digests.
+	Scrub ScrubConfig `yaml:"scrub"`
+
+	// DirectIO bypasses the page cache for large sequential reads and writes.
+	DirectIO DirectIOConfig `yaml:"direct_io"`
+
+	// ResumableUploads keeps upload files across restarts, such that uploads
+	// written with AppendUploadFile can be resumed by name. Abandoned uploads
+	// are removed by UploadCleanup.
+	ResumableUploads bool `yaml:"resumable_uploads"`
+
 	SkipHashVerification bool `yaml:"skip_hash_verification"`
 }
 
@@ -37,6 +54,7 @@ func (c CAStoreConfig) applyDefaults() CAStoreConfig {
 	if c.Capacity == 0 {
 		c.Capacity = 1 << 20 // 1 million
 	}
+	c.Scrub = c.Scrub.applyDefaults(c.CacheDir)
 	return c
 }
 
@@ -55,4 +73,31 @@ type CADownloadStoreConfig struct {
 	CacheDir        string        `yaml:"cache_dir"`
 	DownloadCleanup CleanupConfig `yaml:"download_cleanup"`
 	CacheCleanup    CleanupConfig `yaml:"cache_cleanup"`
+
+	// Volumes spreads download and cache files across disks. Each blob is
+	// placed on the same volume in both directories.
+	Volumes []Volume `yaml:"volumes"`
+
+	// Metadata controls how file metadata is stored.
+	Metadata MetadataConfig `yaml:"metadata"`
+
+	// Sharding controls the directory layout of download and cache files.
+	Sharding ShardingConfig `yaml:"sharding"`
+
+	// Allocation controls how disk space is reserved for new download files.
+	// Defaults to AllocationSparse.
+	Allocation AllocationMode `yaml:"allocation"`
+
+	// Encryption enables encryption of blob data at rest.
+	Encryption EncryptionConfig `yaml:"encryption"`
+
+	// Fsync controls when download file writes are flushed to disk.
+	Fsync FsyncConfig `yaml:"fsync"`
+}
+
+func (c CADownloadStoreConfig) applyDefaults() CADownloadStoreConfig {
+	if c.Allocation == "" {
+		c.Allocation = AllocationSparse
+	}
+	return c
 }
@@ -0,0 +1,456 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+
+	"github.com/uber/kraken/lib/store/base"
+	"github.com/uber/kraken/utils/log"
+	"github.com/uber/kraken/utils/memsize"
+)
+
+// _directIOAlignment is the alignment of buffers, offsets and lengths required
+// for direct I/O.
+const _directIOAlignment = 4096
+
+var errDirectIOUnsupported = errors.New("direct i/o not supported")
+
+// DirectIOConfig defines configuration for bypassing the page cache on large
+// sequential I/O, such as writing blobs downloaded from the backend and
+// reading blobs for write-back. Piece reads for seeding always use buffered
+// I/O, so they keep benefiting from the page cache.
+type DirectIOConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// BufferSize is the size of the aligned buffers used for direct I/O.
+	// Rounded up to a multiple of 4KB.
+	BufferSize int `yaml:"buffer_size"`
+
+	// MinReadSize is the size below which files are read with buffered I/O.
+	MinReadSize int64 `yaml:"min_read_size"`
+}
+
+func (c DirectIOConfig) applyDefaults() DirectIOConfig {
+	if c.BufferSize == 0 {
+		c.BufferSize = int(memsize.MB)
+	}
+	c.BufferSize = int(alignUp(int64(c.BufferSize)))
+	if c.MinReadSize == 0 {
+		c.MinReadSize = int64(4 * memsize.MB)
+	}
+	return c
+}
+
+// directIO opens files for direct I/O and pools their aligned buffers.
+type directIO struct {
+	config  DirectIOConfig
+	buffers sync.Pool
+}
+
+// newDirectIO returns nil if direct I/O is disabled.
+func newDirectIO(config DirectIOConfig) *directIO {
+	if !config.Enabled {
+		return nil
+	}
+	config = config.applyDefaults()
+	d := &directIO{config: config}
+	d.buffers.New = func() interface{} { return alignedBuffer(config.BufferSize) }
+	return d
+}
+
+func (d *directIO) getBuffer() []byte {
+	return d.buffers.Get().([]byte)
+}
+
+func (d *directIO) putBuffer(b []byte) {
+	d.buffers.Put(b)
+}
+
+// openReader opens path for reading with direct I/O. Returns
+// errDirectIOUnsupported if the filesystem of path does not support it.
+func (d *directIO) openReader(path string) (FileReader, error) {
+	f, err := openDirect(path, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &directFileReader{d: d, f: f, size: info.Size()}, nil
+}
+
+// openWriter opens path for writing with direct I/O. Returns
+// errDirectIOUnsupported if the filesystem of path does not support it.
+func (d *directIO) openWriter(path string) (FileReadWriter, error) {
+	direct, err := openDirect(path, os.O_RDWR)
+	if err != nil {
+		return nil, err
+	}
+	buffered, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		direct.Close()
+		return nil, err
+	}
+	info, err := buffered.Stat()
+	if err != nil {
+		direct.Close()
+		buffered.Close()
+		return nil, err
+	}
+	w := &directFileWriter{
+		d:          d,
+		direct:     direct,
+		buffered:   buffered,
+		buf:        d.getBuffer(),
+		off:        alignDown(info.Size()),
+		sequential: true,
+	}
+	// Load the unaligned tail of the file, so appends can rewrite its block.
+	w.n = int(info.Size() - w.off)
+	if w.n > 0 {
+		if _, err := buffered.ReadAt(w.buf[:w.n], w.off); err != nil {
+			w.Close()
+			return nil, fmt.Errorf("read tail: %s", err)
+		}
+	}
+	return w, nil
+}
+
+// readAt reads len(p) bytes of f at off through aligned buffers.
+func (d *directIO) readAt(f *os.File, p []byte, off int64, size int64) (int, error) {
+	if off >= size {
+		return 0, io.EOF
+	}
+	buf := d.getBuffer()
+	defer d.putBuffer(buf)
+
+	var total int
+	for len(p) > 0 && off < size {
+		start := alignDown(off)
+		n, err := f.ReadAt(buf, start)
+		if err != nil && err != io.EOF {
+			return total, err
+		}
+		skip := int(off - start)
+		if n <= skip {
+			return total, io.ErrUnexpectedEOF
+		}
+		c := copy(p, buf[skip:n])
+		total += c
+		p = p[c:]
+		off += int64(c)
+	}
+	if len(p) > 0 {
+		return total, io.EOF
+	}
+	return total, nil
+}
+
+// directFileReader reads a file with direct I/O.
+type directFileReader struct {
+	d    *directIO
+	f    *os.File
+	size int64
+	pos  int64
+}
+
+func (r *directFileReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *directFileReader) ReadAt(p []byte, off int64) (int, error) {
+	return r.d.readAt(r.f, p, off, r.size)
+}
+
+func (r *directFileReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := seekPosition(r.pos, r.size, offset, whence)
+	if err != nil {
+		return r.pos, err
+	}
+	r.pos = pos
+	return pos, nil
+}
+
+func (r *directFileReader) Size() int64 {
+	return r.size
+}
+
+func (r *directFileReader) Close() error {
+	return r.f.Close()
+}
+
+// directFileWriter appends to a file with direct I/O. Appended data is
+// collected in an aligned buffer and written in whole blocks; the last,
+// partial block is padded and the file truncated to its real size. Writes
+// anywhere but the end of the file switch the writer to buffered I/O.
+type directFileWriter struct {
+	d        *directIO
+	direct   *os.File
+	buffered *os.File
+
+	buf []byte // Unwritten tail of the file, starting at off.
+	off int64  // Aligned file offset of buf.
+	n   int    // Number of bytes in buf.
+	pos int64  // File offset of the next Read or Write.
+
+	// sequential is true while all writes have been appends.
+	sequential bool
+	dirty      bool // buf has unflushed data.
+	closed     bool
+}
+
+func (w *directFileWriter) end() int64 {
+	return w.off + int64(w.n)
+}
+
+// flush writes buf to disk, keeping the unaligned tail in buf.
+func (w *directFileWriter) flush() error {
+	if !w.dirty {
+		return nil
+	}
+	padded := int(alignUp(int64(w.n)))
+	for i := w.n; i < padded; i++ {
+		w.buf[i] = 0
+	}
+	if _, err := w.direct.WriteAt(w.buf[:padded], w.off); err != nil {
+		return err
+	}
+	if padded != w.n {
+		if err := w.buffered.Truncate(w.end()); err != nil {
+			return err
+		}
+	}
+	aligned := int(alignDown(int64(w.n)))
+	copy(w.buf, w.buf[aligned:w.n])
+	w.off += int64(aligned)
+	w.n -= aligned
+	w.dirty = false
+	return nil
+}
+
+// toBuffered flushes buf and stops using direct I/O for writes.
+func (w *directFileWriter) toBuffered() error {
+	if !w.sequential {
+		return nil
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	w.n = 0
+	w.sequential = false
+	return nil
+}
+
+func (w *directFileWriter) size() (int64, error) {
+	if w.sequential {
+		return w.end(), nil
+	}
+	info, err := w.buffered.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (w *directFileWriter) Write(p []byte) (int, error) {
+	if !w.sequential || w.pos != w.end() {
+		n, err := w.WriteAt(p, w.pos)
+		w.pos += int64(n)
+		return n, err
+	}
+	var total int
+	for len(p) > 0 {
+		c := copy(w.buf[w.n:], p)
+		w.n += c
+		w.dirty = true
+		total += c
+		p = p[c:]
+		if w.n == len(w.buf) {
+			if err := w.flush(); err != nil {
+				return total, err
+			}
+		}
+	}
+	w.pos += int64(total)
+	return total, nil
+}
+
+func (w *directFileWriter) WriteAt(p []byte, off int64) (int, error) {
+	if err := w.toBuffered(); err != nil {
+		return 0, err
+	}
+	return w.buffered.WriteAt(p, off)
+}
+
+func (w *directFileWriter) Read(p []byte) (int, error) {
+	n, err := w.ReadAt(p, w.pos)
+	w.pos += int64(n)
+	return n, err
+}
+
+func (w *directFileWriter) ReadAt(p []byte, off int64) (int, error) {
+	if err := w.flush(); err != nil {
+		return 0, err
+	}
+	size, err := w.size()
+	if err != nil {
+		return 0, err
+	}
+	return w.d.readAt(w.direct, p, off, size)
+}
+
+func (w *directFileWriter) Seek(offset int64, whence int) (int64, error) {
+	size, err := w.size()
+	if err != nil {
+		return w.pos, err
+	}
+	pos, err := seekPosition(w.pos, size, offset, whence)
+	if err != nil {
+		return w.pos, err
+	}
+	w.pos = pos
+	return pos, nil
+}
+
+func (w *directFileWriter) Size() int64 {
+	size, err := w.size()
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+func (w *directFileWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.flush()
+	w.d.putBuffer(w.buf)
+	w.direct.Close()
+	if cerr := w.buffered.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Sync flushes w to disk.
+func (w *directFileWriter) Sync() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.buffered.Sync()
+}
+
+// Cancel closes w.
+func (w *directFileWriter) Cancel() error {
+	return w.Close()
+}
+
+// Commit closes w.
+func (w *directFileWriter) Commit() error {
+	return w.Close()
+}
+
+// sequentialReader returns a reader of name in op for reading it once from
+// start to end, which uses direct I/O for large files if enabled.
+func (s *CAStore) sequentialReader(op base.FileOp, name string) (FileReader, error) {
+	if s.directIO == nil {
+		return op.GetFileReader(name)
+	}
+	info, err := op.GetFileStat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < s.directIO.config.MinReadSize {
+		return op.GetFileReader(name)
+	}
+	path, err := op.GetFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.directIO.openReader(path)
+	if err == errDirectIOUnsupported {
+		return op.GetFileReader(name)
+	}
+	return r, err
+}
+
+// GetCacheFileSequentialReader returns a reader of cache file name intended
+// for reading it once from start to end, e.g. for write-back. Unlike
+// GetCacheFileReader, it bypasses the page cache if direct I/O is enabled.
+func (s *CAStore) GetCacheFileSequentialReader(name string) (FileReader, error) {
+	return s.sequentialReader(s.cacheStore.newFileOp(), name)
+}
+
+// uploadFileReadWriter returns a read writer of upload file name, which uses
+// direct I/O for appends if enabled.
+func (s *CAStore) uploadFileReadWriter(name string) (FileReadWriter, error) {
+	if s.directIO == nil {
+		return s.uploadStore.GetUploadFileReadWriter(name)
+	}
+	path, err := s.uploadStore.newFileOp().GetFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+	w, err := s.directIO.openWriter(path)
+	if err == errDirectIOUnsupported {
+		log.Debugf("Direct i/o unsupported for %s, using buffered i/o", path)
+		return s.uploadStore.GetUploadFileReadWriter(name)
+	}
+	return w, err
+}
+
+func seekPosition(pos, size, offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += pos
+	case io.SeekEnd:
+		offset += size
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	return offset, nil
+}
+
+func alignedBuffer(size int) []byte {
+	b := make([]byte, size+_directIOAlignment)
+	skip := int(uintptr(unsafe.Pointer(&b[0])) & (_directIOAlignment - 1))
+	if skip != 0 {
+		skip = _directIOAlignment - skip
+	}
+	return b[skip : skip+size : skip+size]
+}
+
+func alignDown(n int64) int64 {
+	return n &^ (_directIOAlignment - 1)
+}
+
+func alignUp(n int64) int64 {
+	return alignDown(n + _directIOAlignment - 1)
+}
@@ -0,0 +1,31 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"os"
+	"syscall"
+)
+
+func openDirect(path string, flag int) (*os.File, error) {
+	f, err := os.OpenFile(path, flag|syscall.O_DIRECT, 0)
+	if err != nil {
+		if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EINVAL {
+			// Filesystem does not support O_DIRECT, e.g. tmpfs.
+			return nil, errDirectIOUnsupported
+		}
+		return nil, err
+	}
+	return f, nil
+}
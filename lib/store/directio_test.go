@@ -0,0 +1,128 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/randutil"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func directIOCAStoreFixture(t *testing.T) (*CAStore, func()) {
+	config, cleanup := CAStoreConfigFixture()
+	config.DirectIO = DirectIOConfig{
+		Enabled:     true,
+		BufferSize:  _directIOAlignment,
+		MinReadSize: 1,
+	}
+	s, err := NewCAStore(config, tally.NoopScope)
+	require.NoError(t, err)
+	return s, func() {
+		s.Close()
+		cleanup()
+	}
+}
+
+func TestDirectIOWriteCacheFile(t *testing.T) {
+	for _, size := range []uint64{1, _directIOAlignment, 3*_directIOAlignment + 17} {
+		require := require.New(t)
+
+		s, cleanup := directIOCAStoreFixture(t)
+		defer cleanup()
+
+		blob := core.SizedBlobFixture(size, 1024)
+		require.NoError(s.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+
+		r, err := s.GetCacheFileSequentialReader(blob.Digest.Hex())
+		require.NoError(err)
+		defer r.Close()
+		require.Equal(int64(size), r.Size())
+		b, err := ioutil.ReadAll(r)
+		require.NoError(err)
+		require.Equal(blob.Content, b)
+	}
+}
+
+func TestDirectIOUploadFileAppendsAcrossWriters(t *testing.T) {
+	require := require.New(t)
+
+	s, cleanup := directIOCAStoreFixture(t)
+	defer cleanup()
+
+	content := randutil.Blob(2*_directIOAlignment + 100)
+	require.NoError(s.CreateUploadFile("upload", 0))
+
+	// Append in unaligned chunks, reopening the file for each chunk like
+	// chunked uploads do.
+	for start := 0; start < len(content); start += 1000 {
+		end := start + 1000
+		if end > len(content) {
+			end = len(content)
+		}
+		w, err := s.GetUploadFileReadWriter("upload")
+		require.NoError(err)
+		_, err = w.Seek(int64(start), io.SeekStart)
+		require.NoError(err)
+		_, err = w.Write(content[start:end])
+		require.NoError(err)
+		require.NoError(w.Close())
+	}
+
+	r, err := s.GetUploadFileReader("upload")
+	require.NoError(err)
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	require.NoError(err)
+	require.Equal(content, b)
+}
+
+func TestDirectIOUploadFileRandomWrites(t *testing.T) {
+	require := require.New(t)
+
+	s, cleanup := directIOCAStoreFixture(t)
+	defer cleanup()
+
+	content := randutil.Blob(_directIOAlignment + 10)
+	require.NoError(s.CreateUploadFile("upload", 0))
+
+	w, err := s.GetUploadFileReadWriter("upload")
+	require.NoError(err)
+	defer w.Close()
+
+	_, err = w.Write(content[:100])
+	require.NoError(err)
+	_, err = w.WriteAt(content[100:], 100)
+	require.NoError(err)
+	_, err = w.WriteAt(content[:10], 0)
+	require.NoError(err)
+	require.Equal(int64(len(content)), w.Size())
+
+	b := make([]byte, 20)
+	_, err = w.ReadAt(b, _directIOAlignment-10)
+	require.NoError(err)
+	require.Equal(content[_directIOAlignment-10:_directIOAlignment+10], b)
+
+	_, err = w.Seek(0, io.SeekStart)
+	require.NoError(err)
+	all, err := ioutil.ReadAll(w)
+	require.NoError(err)
+	require.Equal(content, all)
+}
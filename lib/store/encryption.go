@@ -0,0 +1,71 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/uber/kraken/lib/store/base"
+)
+
+// EncryptionConfig defines encryption of blob data at rest. When enabled, data
+// is encrypted with AES-GCM before reaching disk, so hosts never persist
+// plaintext blob contents. Toggling encryption requires clearing existing
+// download and cache directories.
+type EncryptionConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// KeyFile is the path of a file containing a hex-encoded 16, 24 or 32 byte
+	// AES key.
+	KeyFile string `yaml:"key_file"`
+}
+
+// fileKeyProvider reads a hex-encoded key from a file.
+type fileKeyProvider struct {
+	path string
+}
+
+// NewFileKeyProvider returns a KeyProvider which reads a hex-encoded key from
+// path.
+func NewFileKeyProvider(path string) base.KeyProvider {
+	return fileKeyProvider{path}
+}
+
+func (p fileKeyProvider) Key() ([]byte, error) {
+	b, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %s", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("decode key: %s", err)
+	}
+	return key, nil
+}
+
+// newFileCipher returns the cipher for config, or nil if encryption is
+// disabled.
+func newFileCipher(config EncryptionConfig) (*base.FileCipher, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.KeyFile == "" {
+		return nil, errors.New("encryption requires key_file")
+	}
+	return base.NewFileCipher(NewFileKeyProvider(config.KeyFile))
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/lib/store/base"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/c2h5oh/datasize"
+)
+
+// EvictionConfig defines configuration for evicting files once the disk usage
+// of a cleanup job exceeds a high watermark. Eviction runs after idle and
+// expired files have been deleted, and deletes files in the order chosen by
+// Policy until disk usage drops to the low watermark.
+type EvictionConfig struct {
+	// Policy is the name of a registered EvictionPolicy. If empty, eviction
+	// is disabled.
+	Policy string `yaml:"policy"`
+
+	// HighWatermark is the disk usage which triggers eviction.
+	HighWatermark datasize.ByteSize `yaml:"high_watermark"`
+
+	// LowWatermark is the disk usage which eviction stops at. Defaults to 80%
+	// of HighWatermark.
+	LowWatermark datasize.ByteSize `yaml:"low_watermark"`
+}
+
+func (c EvictionConfig) applyDefaults() EvictionConfig {
+	if c.LowWatermark == 0 {
+		c.LowWatermark = c.HighWatermark * 8 / 10
+	}
+	return c
+}
+
+func (c EvictionConfig) enabled() bool {
+	return c.Policy != ""
+}
+
+func (c EvictionConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	if _, err := getEvictionPolicy(c.Policy); err != nil {
+		return err
+	}
+	if c.HighWatermark == 0 {
+		return errors.New("high_watermark required")
+	}
+	if c.LowWatermark > c.HighWatermark {
+		return errors.New("low_watermark must not exceed high_watermark")
+	}
+	return nil
+}
+
+// EvictionCandidate describes a file which may be evicted.
+type EvictionCandidate struct {
+	Name           string
+	Size           int64
+	ModTime        time.Time
+	LastAccessTime time.Time
+	AccessCount    int64
+}
+
+// EvictionPolicy decides which files are evicted first.
+type EvictionPolicy interface {
+	// Sort sorts candidates in the order they should be evicted.
+	Sort(candidates []EvictionCandidate)
+}
+
+var (
+	_evictionPoliciesMu sync.RWMutex
+	_evictionPolicies   = map[string]EvictionPolicy{
+		"lru": LRUPolicy{},
+		"lfu": LFUPolicy{},
+	}
+)
+
+// RegisterEvictionPolicy registers policy under name, such that it may be
+// selected in EvictionConfig. Replaces any policy already registered under
+// name.
+func RegisterEvictionPolicy(name string, policy EvictionPolicy) {
+	_evictionPoliciesMu.Lock()
+	defer _evictionPoliciesMu.Unlock()
+
+	_evictionPolicies[name] = policy
+}
+
+func getEvictionPolicy(name string) (EvictionPolicy, error) {
+	_evictionPoliciesMu.RLock()
+	defer _evictionPoliciesMu.RUnlock()
+
+	policy, ok := _evictionPolicies[name]
+	if !ok {
+		return nil, fmt.Errorf("eviction policy %q not registered", name)
+	}
+	return policy, nil
+}
+
+// LRUPolicy evicts the least recently accessed files first.
+type LRUPolicy struct{}
+
+// Sort sorts candidates by last access time, oldest first.
+func (LRUPolicy) Sort(candidates []EvictionCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].LastAccessTime.Before(candidates[j].LastAccessTime)
+	})
+}
+
+// LFUPolicy evicts the least frequently accessed files first, breaking ties by
+// last access time.
+type LFUPolicy struct{}
+
+// Sort sorts candidates by access count, lowest first.
+func (LFUPolicy) Sort(candidates []EvictionCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.AccessCount != b.AccessCount {
+			return a.AccessCount < b.AccessCount
+		}
+		return a.LastAccessTime.Before(b.LastAccessTime)
+	})
+}
+
+// evict deletes files from op in the order of policy until disk usage drops to
+// config.LowWatermark, if usage exceeds config.HighWatermark. Returns disk usage
+// after eviction.
+func (m *cleanupManager) evict(
+	op base.FileOp, config EvictionConfig, policy EvictionPolicy) (usage int64, err error) {
+
+	names, err := op.ListNames()
+	if err != nil {
+		return 0, fmt.Errorf("list names: %s", err)
+	}
+	candidates := make([]EvictionCandidate, 0, len(names))
+	for _, name := range names {
+		c, err := m.newEvictionCandidate(op, name)
+		if err != nil {
+			log.With("name", name).Errorf("Error getting eviction candidate: %s", err)
+			continue
+		}
+		candidates = append(candidates, c)
+		usage += c.Size
+	}
+	if usage <= int64(config.HighWatermark) {
+		return usage, nil
+	}
+	policy.Sort(candidates)
+	for _, c := range candidates {
+		if usage <= int64(config.LowWatermark) {
+			break
+		}
+		if err := op.DeleteFile(c.Name); err != nil {
+			if err != base.ErrFilePersisted && !os.IsNotExist(err) {
+				log.With("name", c.Name).Errorf("Error evicting file: %s", err)
+			}
+			continue
+		}
+		usage -= c.Size
+		m.stats.Tagged(map[string]string{
+			"policy": config.Policy,
+		}).Counter("evictions").Inc(1)
+	}
+	return usage, nil
+}
+
+func (m *cleanupManager) newEvictionCandidate(
+	op base.FileOp, name string) (EvictionCandidate, error) {
+
+	info, err := op.GetFileStat(name)
+	if err != nil {
+		return EvictionCandidate{}, fmt.Errorf("stat: %s", err)
+	}
+	c := EvictionCandidate{
+		Name:    name,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	var lat metadata.LastAccessTime
+	if err := op.GetFileMetadata(name, &lat); err == nil {
+		c.LastAccessTime = lat.Time
+	} else if os.IsNotExist(err) {
+		c.LastAccessTime = info.ModTime()
+	} else {
+		return EvictionCandidate{}, fmt.Errorf("get lat: %s", err)
+	}
+	var count metadata.AccessCount
+	if err := op.GetFileMetadata(name, &count); err != nil && !os.IsNotExist(err) {
+		return EvictionCandidate{}, fmt.Errorf("get access count: %s", err)
+	}
+	c.AccessCount = count.Count
+	return c, nil
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestLRUPolicySort(t *testing.T) {
+	now := time.Now()
+	candidates := []EvictionCandidate{
+		{Name: "b", LastAccessTime: now.Add(-time.Minute)},
+		{Name: "c", LastAccessTime: now},
+		{Name: "a", LastAccessTime: now.Add(-time.Hour)},
+	}
+	LRUPolicy{}.Sort(candidates)
+
+	var names []string
+	for _, c := range candidates {
+		names = append(names, c.Name)
+	}
+	require.Equal(t, []string{"a", "b", "c"}, names)
+}
+
+func TestLFUPolicySort(t *testing.T) {
+	now := time.Now()
+	candidates := []EvictionCandidate{
+		{Name: "c", AccessCount: 10, LastAccessTime: now.Add(-time.Hour)},
+		{Name: "b", AccessCount: 1, LastAccessTime: now},
+		{Name: "a", AccessCount: 1, LastAccessTime: now.Add(-time.Minute)},
+	}
+	LFUPolicy{}.Sort(candidates)
+
+	var names []string
+	for _, c := range candidates {
+		names = append(names, c.Name)
+	}
+	require.Equal(t, []string{"a", "b", "c"}, names)
+}
+
+func TestEvictionConfigValidate(t *testing.T) {
+	tests := []struct {
+		desc   string
+		config EvictionConfig
+		valid  bool
+	}{
+		{"disabled", EvictionConfig{}, true},
+		{"lru", EvictionConfig{Policy: "lru", HighWatermark: 10}, true},
+		{"unregistered policy", EvictionConfig{Policy: "foo", HighWatermark: 10}, false},
+		{"missing high watermark", EvictionConfig{Policy: "lru"}, false},
+		{"low above high", EvictionConfig{Policy: "lru", HighWatermark: 10, LowWatermark: 20}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			err := test.config.applyDefaults().validate()
+			if test.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestCleanupManagerEvict(t *testing.T) {
+	tests := []struct {
+		policy  string
+		evicted []int
+	}{
+		// Files are accessed in index order, and file i is accessed 4-i times.
+		{"lru", []int{0, 1}},
+		{"lfu", []int{3, 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			require := require.New(t)
+
+			clk := clock.NewMock()
+			clk.Set(time.Now())
+
+			m, err := newCleanupManager(clk, tally.NoopScope)
+			require.NoError(err)
+			defer m.stop()
+
+			state, op, cleanup := fileOpFixture(clk)
+			defer cleanup()
+
+			var names []string
+			for i := 0; i < 4; i++ {
+				name := core.DigestFixture().Hex()
+				names = append(names, name)
+				require.NoError(op.CreateFile(name, state, 5))
+				_, err := op.SetFileMetadata(
+					name, metadata.NewLastAccessTime(clk.Now().Add(time.Duration(i)*time.Hour)))
+				require.NoError(err)
+				_, err = op.SetFileMetadata(name, metadata.NewAccessCount(int64(4-i)))
+				require.NoError(err)
+			}
+
+			config := EvictionConfig{Policy: test.policy, HighWatermark: 15, LowWatermark: 10}
+			policy, err := getEvictionPolicy(test.policy)
+			require.NoError(err)
+
+			usage, err := m.evict(op, config, policy)
+			require.NoError(err)
+			require.Equal(int64(10), usage)
+
+			evicted := make(map[string]bool)
+			for _, i := range test.evicted {
+				evicted[names[i]] = true
+			}
+			for _, name := range names {
+				_, err := op.GetFileStat(name)
+				if evicted[name] {
+					require.True(os.IsNotExist(err))
+				} else {
+					require.NoError(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCleanupManagerEvictBelowHighWatermark(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+
+	m, err := newCleanupManager(clk, tally.NoopScope)
+	require.NoError(err)
+	defer m.stop()
+
+	state, op, cleanup := fileOpFixture(clk)
+	defer cleanup()
+
+	for i := 0; i < 4; i++ {
+		require.NoError(op.CreateFile(core.DigestFixture().Hex(), state, 5))
+	}
+
+	usage, err := m.evict(op, EvictionConfig{Policy: "lru", HighWatermark: 20}, LRUPolicy{})
+	require.NoError(err)
+	require.Equal(int64(20), usage)
+
+	names, err := op.ListNames()
+	require.NoError(err)
+	require.Len(names, 4)
+}
+
+type evictNothingPolicy struct{}
+
+func (evictNothingPolicy) Sort(candidates []EvictionCandidate) {}
+
+func TestRegisterEvictionPolicy(t *testing.T) {
+	require := require.New(t)
+
+	RegisterEvictionPolicy("test_policy", evictNothingPolicy{})
+
+	policy, err := getEvictionPolicy("test_policy")
+	require.NoError(err)
+	require.Equal(evictNothingPolicy{}, policy)
+
+	require.NoError(EvictionConfig{Policy: "test_policy", HighWatermark: 1}.validate())
+}
+
+func TestCleanupManagerAddJobInvalidEviction(t *testing.T) {
+	m, err := newCleanupManager(clock.New(), tally.NoopScope)
+	require.NoError(t, err)
+	defer m.stop()
+
+	_, op, cleanup := fileOpFixture(clock.New())
+	defer cleanup()
+
+	config := CleanupConfig{Eviction: EvictionConfig{Policy: "foo"}}
+	require.Error(t, m.addJob("test", config, op))
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+)
+
+// FsyncPolicy defines when download file writes are flushed to disk.
+type FsyncPolicy string
+
+// Fsync policies. Every policy other than FsyncNever also flushes a download
+// file before it is moved to the cache, so complete files are always durable.
+const (
+	// FsyncNever leaves flushing to the operating system.
+	FsyncNever FsyncPolicy = "never"
+
+	// FsyncEveryWrite flushes after every write.
+	FsyncEveryWrite FsyncPolicy = "every_write"
+
+	// FsyncBatch flushes a file after every BatchSize writes to it.
+	FsyncBatch FsyncPolicy = "batch"
+
+	// FsyncInterval flushes every file written to on a fixed interval.
+	FsyncInterval FsyncPolicy = "interval"
+
+	// FsyncOnComplete only flushes files once they are complete.
+	FsyncOnComplete FsyncPolicy = "on_complete"
+)
+
+// FsyncConfig defines the durability of download file writes. Writes which
+// were not flushed may be lost in a crash, so policies other than
+// FsyncEveryWrite trade durability for throughput.
+type FsyncConfig struct {
+	// Policy defaults to FsyncNever.
+	Policy FsyncPolicy `yaml:"policy"`
+
+	// BatchSize is the number of writes between flushes for FsyncBatch.
+	BatchSize int `yaml:"batch_size"`
+
+	// Interval is the time between flushes for FsyncInterval.
+	Interval time.Duration `yaml:"interval"`
+}
+
+func (c FsyncConfig) applyDefaults() FsyncConfig {
+	if c.Policy == "" {
+		c.Policy = FsyncNever
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = 16
+	}
+	if c.Interval == 0 {
+		c.Interval = time.Second
+	}
+	return c
+}
+
+func (c FsyncConfig) validate() error {
+	switch c.Policy {
+	case FsyncNever, FsyncEveryWrite, FsyncBatch, FsyncInterval, FsyncOnComplete:
+		return nil
+	default:
+		return fmt.Errorf("unknown policy %q", c.Policy)
+	}
+}
+
+// syncer is implemented by files which can be flushed to disk.
+type syncer interface {
+	Sync() error
+}
+
+// fsyncer flushes download files according to an FsyncPolicy.
+type fsyncer struct {
+	config FsyncConfig
+	stats  tally.Scope
+
+	// open opens a download file for flushing.
+	open func(name string) (FileReadWriter, error)
+
+	mu      sync.Mutex
+	pending map[string]int // Writes since the last flush, by file name.
+
+	stopOnce sync.Once
+	stopc    chan struct{}
+}
+
+func newFsyncer(
+	config FsyncConfig,
+	clk clock.Clock,
+	stats tally.Scope,
+	open func(name string) (FileReadWriter, error)) (*fsyncer, error) {
+
+	config = config.applyDefaults()
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	f := &fsyncer{
+		config:  config,
+		stats:   stats.Tagged(map[string]string{"module": "fsync"}),
+		open:    open,
+		pending: make(map[string]int),
+		stopc:   make(chan struct{}),
+	}
+	if config.Policy == FsyncInterval {
+		go f.loop(clk.Ticker(config.Interval))
+	}
+	return f, nil
+}
+
+func (f *fsyncer) loop(ticker *clock.Ticker) {
+	for {
+		select {
+		case <-ticker.C:
+			f.flushPending()
+		case <-f.stopc:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+func (f *fsyncer) stop() {
+	f.stopOnce.Do(func() { close(f.stopc) })
+}
+
+// written records a write to name through w, flushing w if the policy
+// requires it.
+func (f *fsyncer) written(name string, w FileReadWriter) error {
+	switch f.config.Policy {
+	case FsyncNever:
+		return nil
+	case FsyncEveryWrite:
+		return f.sync(w)
+	}
+
+	f.mu.Lock()
+	f.pending[name]++
+	flush := f.config.Policy == FsyncBatch && f.pending[name] >= f.config.BatchSize
+	if flush {
+		delete(f.pending, name)
+	}
+	f.mu.Unlock()
+
+	if flush {
+		return f.sync(w)
+	}
+	return nil
+}
+
+// complete flushes name before it is moved to the cache. Files which cannot be
+// opened are skipped, leaving the move to report the error.
+func (f *fsyncer) complete(name string) error {
+	f.mu.Lock()
+	delete(f.pending, name)
+	f.mu.Unlock()
+
+	if f.config.Policy == FsyncNever || f.config.Policy == FsyncEveryWrite {
+		return nil
+	}
+	w, err := f.open(name)
+	if err != nil {
+		return nil
+	}
+	defer w.Close()
+	return f.sync(w)
+}
+
+// flushPending flushes every file written to since the last flush.
+func (f *fsyncer) flushPending() {
+	f.mu.Lock()
+	pending := f.pending
+	f.pending = make(map[string]int)
+	f.mu.Unlock()
+
+	for name := range pending {
+		w, err := f.open(name)
+		if err != nil {
+			// The file was completed or deleted in the meantime.
+			continue
+		}
+		if err := f.sync(w); err != nil {
+			log.With("name", name).Errorf("Error flushing download file: %s", err)
+		}
+		w.Close()
+	}
+}
+
+func (f *fsyncer) sync(w FileReadWriter) error {
+	s, ok := w.(syncer)
+	if !ok {
+		return errors.New("file does not support sync")
+	}
+	defer f.stats.Timer("fsync").Start().Stop()
+	return s.Sync()
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+	"go.uber.org/atomic"
+)
+
+// syncCounter is a FileReadWriter which counts calls to Sync.
+type syncCounter struct {
+	FileReadWriter
+	syncs *atomic.Int32
+}
+
+func (c syncCounter) Sync() error {
+	c.syncs.Inc()
+	return nil
+}
+
+func (c syncCounter) Close() error {
+	return nil
+}
+
+func newFsyncerFixture(
+	t *testing.T, config FsyncConfig, clk clock.Clock) (*fsyncer, syncCounter, func()) {
+
+	w := syncCounter{syncs: atomic.NewInt32(0)}
+	open := func(name string) (FileReadWriter, error) {
+		if name == "missing" {
+			return nil, os.ErrNotExist
+		}
+		return w, nil
+	}
+	f, err := newFsyncer(config, clk, tally.NoopScope, open)
+	require.NoError(t, err)
+	return f, w, f.stop
+}
+
+func TestFsyncPolicies(t *testing.T) {
+	tests := []struct {
+		config   FsyncConfig
+		writes   int
+		synced   int32
+		complete int32
+	}{
+		{FsyncConfig{}, 5, 0, 0},
+		{FsyncConfig{Policy: FsyncEveryWrite}, 5, 5, 5},
+		{FsyncConfig{Policy: FsyncBatch, BatchSize: 2}, 5, 2, 3},
+		{FsyncConfig{Policy: FsyncOnComplete}, 5, 0, 1},
+	}
+	for _, test := range tests {
+		t.Run(string(test.config.Policy), func(t *testing.T) {
+			require := require.New(t)
+
+			f, w, cleanup := newFsyncerFixture(t, test.config, clock.NewMock())
+			defer cleanup()
+
+			for i := 0; i < test.writes; i++ {
+				require.NoError(f.written("foo", w))
+			}
+			require.Equal(test.synced, w.syncs.Load())
+
+			require.NoError(f.complete("foo"))
+			require.Equal(test.complete, w.syncs.Load())
+
+			// Missing files are left for the move to report.
+			require.NoError(f.complete("missing"))
+		})
+	}
+}
+
+func TestFsyncInterval(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	f, w, cleanup := newFsyncerFixture(
+		t, FsyncConfig{Policy: FsyncInterval, Interval: time.Second}, clk)
+	defer cleanup()
+
+	require.NoError(f.written("foo", w))
+	require.NoError(f.written("foo", w))
+	require.Equal(int32(0), w.syncs.Load())
+
+	clk.Add(time.Second)
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		return w.syncs.Load() == 1
+	}))
+
+	// Nothing was written since the last flush.
+	clk.Add(time.Second)
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(int32(1), w.syncs.Load())
+}
+
+func TestFsyncInvalidPolicy(t *testing.T) {
+	_, err := newFsyncer(
+		FsyncConfig{Policy: "sometimes"}, clock.NewMock(), tally.NoopScope, nil)
+	require.Error(t, err)
+}
+
+func TestCADownloadStoreFsyncOnComplete(t *testing.T) {
+	require := require.New(t)
+
+	cleanup := &testutil.Cleanup{}
+	defer cleanup.Run()
+
+	config := CADownloadStoreConfig{
+		DownloadDir: tempdir(cleanup, "download"),
+		CacheDir:    tempdir(cleanup, "cache"),
+		Fsync:       FsyncConfig{Policy: FsyncOnComplete},
+	}
+
+	s, err := NewCADownloadStore(config, tally.NoopScope)
+	require.NoError(err)
+	defer s.Close()
+
+	name := core.DigestFixture().Hex()
+	require.NoError(s.CreateDownloadFile(name, 1))
+	w, err := s.GetDownloadFileReadWriter(name)
+	require.NoError(err)
+	_, err = w.Write([]byte{1})
+	require.NoError(err)
+	require.NoError(s.DownloadFileWritten(name, w))
+	require.NoError(w.Close())
+
+	require.NoError(s.MoveDownloadFileToCache(name))
+	require.True(os.IsExist(s.MoveDownloadFileToCache(name)))
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import (
+	"encoding/binary"
+	"fmt"
+	"regexp"
+)
+
+var _accessCountSuffix = "_access_count"
+
+func init() {
+	Register(regexp.MustCompile(_accessCountSuffix), &accessCountFactory{})
+}
+
+type accessCountFactory struct{}
+
+func (f accessCountFactory) Create(suffix string) Metadata {
+	return &AccessCount{}
+}
+
+// AccessCount tracks how many times a file has been accessed. Like
+// LastAccessTime, accesses are only counted once per update resolution of the
+// file map.
+type AccessCount struct {
+	Count int64
+}
+
+// NewAccessCount creates an AccessCount from n.
+func NewAccessCount(n int64) *AccessCount {
+	return &AccessCount{n}
+}
+
+// GetSuffix returns the metadata suffix.
+func (c *AccessCount) GetSuffix() string {
+	return _accessCountSuffix
+}
+
+// Movable is true.
+func (c *AccessCount) Movable() bool {
+	return true
+}
+
+// Serialize converts c to bytes.
+func (c *AccessCount) Serialize() ([]byte, error) {
+	b := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(b, c.Count)
+	return b[:n], nil
+}
+
+// Deserialize loads b into c.
+func (c *AccessCount) Deserialize(b []byte) error {
+	i, n := binary.Varint(b)
+	if n <= 0 {
+		return fmt.Errorf("unmarshal access count: %s", b)
+	}
+	c.Count = i
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"fmt"
+
+	"github.com/uber/kraken/lib/store/base"
+	"github.com/uber/kraken/utils/osutil"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3" // SQL driver.
+)
+
+// Metadata backends.
+const (
+	MetadataBackendFile   = "file"
+	MetadataBackendSQLite = "sqlite"
+)
+
+// MetadataConfig defines how file metadata, such as torrent metainfo, piece
+// statuses and persist flags, is stored.
+type MetadataConfig struct {
+	// Backend is either "file", which stores each metadata in its own file
+	// next to the data file, or "sqlite", which stores all metadata in an
+	// embedded SQLite database at Source. The latter avoids an inode per
+	// metadata on hosts which cache many blobs. Defaults to "file".
+	Backend string `yaml:"backend"`
+
+	// Source is the path of the SQLite database.
+	Source string `yaml:"source"`
+}
+
+func (c MetadataConfig) applyDefaults() MetadataConfig {
+	if c.Backend == "" {
+		c.Backend = MetadataBackendFile
+	}
+	return c
+}
+
+// newMetadataBackend returns a metadata backend for config, and a function
+// which releases its resources.
+func newMetadataBackend(config MetadataConfig) (base.MetadataBackend, func(), error) {
+	config = config.applyDefaults()
+	switch config.Backend {
+	case MetadataBackendFile:
+		return base.NewFileMetadataBackend(), func() {}, nil
+	case MetadataBackendSQLite:
+		if config.Source == "" {
+			return nil, nil, fmt.Errorf("sqlite metadata backend requires source")
+		}
+		if err := osutil.EnsureFilePresent(config.Source, 0775); err != nil {
+			return nil, nil, fmt.Errorf("ensure db source present: %s", err)
+		}
+		db, err := sqlx.Open("sqlite3", config.Source)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open sqlite3: %s", err)
+		}
+		// SQLite has concurrency issues where queries result in error if more
+		// than one connection is accessing a table.
+		db.SetMaxOpenConns(1)
+		if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+			db.Close()
+			return nil, nil, fmt.Errorf("enable wal: %s", err)
+		}
+		backend, err := base.NewSQLMetadataBackend(db)
+		if err != nil {
+			db.Close()
+			return nil, nil, fmt.Errorf("new sql metadata backend: %s", err)
+		}
+		return backend, func() { db.Close() }, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown metadata backend %q", config.Backend)
+	}
+}
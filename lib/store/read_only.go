@@ -0,0 +1,72 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"errors"
+
+	"github.com/uber/kraken/utils/log"
+)
+
+// ErrReadOnly is returned by operations which would add or remove files while
+// the store is read-only.
+var ErrReadOnly = errors.New("store is read-only")
+
+// SetReadOnly toggles read-only mode. While read-only, s rejects operations
+// which add or remove files with ErrReadOnly and pauses cleanup, but continues
+// to serve reads. Metadata, such as generated metainfo, may still be written
+// so that existing files can be seeded.
+func (s *CAStore) SetReadOnly(readOnly bool) {
+	if s.readOnly.Swap(readOnly) != readOnly {
+		log.Infof("Store read-only mode set to %t", readOnly)
+	}
+	s.cleanup.setReadOnly(readOnly)
+}
+
+// ReadOnly returns whether s is read-only.
+func (s *CAStore) ReadOnly() bool {
+	return s.readOnly.Load()
+}
+
+// CreateUploadFile creates an empty upload file of the given length.
+func (s *CAStore) CreateUploadFile(name string, length int64) error {
+	if s.ReadOnly() {
+		return ErrReadOnly
+	}
+	return s.uploadStore.CreateUploadFile(name, length)
+}
+
+// GetUploadFileReadWriter returns a FileReadWriter for upload file name.
+func (s *CAStore) GetUploadFileReadWriter(name string) (FileReadWriter, error) {
+	if s.ReadOnly() {
+		return nil, ErrReadOnly
+	}
+	return s.uploadFileReadWriter(name)
+}
+
+// DeleteUploadFile deletes upload file name.
+func (s *CAStore) DeleteUploadFile(name string) error {
+	if s.ReadOnly() {
+		return ErrReadOnly
+	}
+	return s.uploadStore.DeleteUploadFile(name)
+}
+
+// DeleteCacheFile deletes cache file name.
+func (s *CAStore) DeleteCacheFile(name string) error {
+	if s.ReadOnly() {
+		return ErrReadOnly
+	}
+	return s.cacheStore.DeleteCacheFile(name)
+}
@@ -0,0 +1,321 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/base"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/log"
+	"github.com/uber/kraken/utils/memsize"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
+)
+
+// ScrubConfig defines configuration for periodically verifying the contents of
+// cache files against their digests.
+type ScrubConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Interval is the time between the start of consecutive scrubs.
+	Interval time.Duration `yaml:"interval"`
+
+	// BytesPerSec limits the read bandwidth used for hashing, so scrubs do not
+	// compete with serving blobs.
+	BytesPerSec uint64 `yaml:"bytes_per_sec"`
+
+	// QuarantineDir is where corrupt files are moved to for inspection.
+	// Defaults to a "quarantine" directory next to the cache directory.
+	QuarantineDir string `yaml:"quarantine_dir"`
+}
+
+func (c ScrubConfig) applyDefaults(cacheDir string) ScrubConfig {
+	if c.Interval == 0 {
+		c.Interval = 24 * time.Hour
+	}
+	if c.BytesPerSec == 0 {
+		c.BytesPerSec = 20 * memsize.MB
+	}
+	if c.QuarantineDir == "" {
+		c.QuarantineDir = filepath.Join(filepath.Dir(filepath.Clean(cacheDir)), "quarantine")
+	}
+	return c
+}
+
+// CorruptionHook is called after a corrupt cache file is quarantined.
+// namespace is the Namespace metadata of the file, or empty if it had none.
+type CorruptionHook func(name, namespace string)
+
+// ScrubResult summarizes a single scrub.
+type ScrubResult struct {
+	Files   int      `json:"files"`
+	Bytes   int64    `json:"bytes"`
+	Corrupt []string `json:"corrupt"`
+}
+
+// scrubber re-hashes cache files and quarantines those whose content no
+// longer matches their name.
+type scrubber struct {
+	config   ScrubConfig
+	clk      clock.Clock
+	stats    tally.Scope
+	op       base.FileOp
+	limiter  *rate.Limiter
+	readOnly *atomic.Bool
+
+	mu   sync.Mutex
+	hook CorruptionHook
+
+	stopOnce sync.Once
+	stopc    chan struct{}
+}
+
+func newScrubber(
+	config ScrubConfig,
+	clk clock.Clock,
+	stats tally.Scope,
+	op base.FileOp,
+	readOnly *atomic.Bool) (*scrubber, error) {
+
+	if err := os.MkdirAll(config.QuarantineDir, 0775); err != nil {
+		return nil, fmt.Errorf("mkdir quarantine: %s", err)
+	}
+	stats = stats.Tagged(map[string]string{
+		"module": "storescrub",
+	})
+	// Files are read in chunks of at most the burst size.
+	burst := int(config.BytesPerSec)
+	if burst > int(memsize.MB) {
+		burst = int(memsize.MB)
+	}
+	return &scrubber{
+		config:   config,
+		clk:      clk,
+		stats:    stats,
+		op:       op,
+		limiter:  rate.NewLimiter(rate.Limit(config.BytesPerSec), burst),
+		readOnly: readOnly,
+		stopc:    make(chan struct{}),
+	}, nil
+}
+
+// start runs scrubs on the configured interval until stop is called.
+func (s *scrubber) start() {
+	ticker := s.clk.Ticker(s.config.Interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if s.readOnly.Load() {
+					log.Debug("Skipping scrub of read-only store")
+					continue
+				}
+				result, err := s.scrub()
+				if err != nil {
+					log.Errorf("Error scrubbing cache: %s", err)
+					continue
+				}
+				log.Infof("Scrubbed %d cache files (%d bytes), %d corrupt",
+					result.Files, result.Bytes, len(result.Corrupt))
+			case <-s.stopc:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (s *scrubber) stop() {
+	s.stopOnce.Do(func() { close(s.stopc) })
+}
+
+func (s *scrubber) setHook(h CorruptionHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hook = h
+}
+
+// scrub verifies every cache file once.
+func (s *scrubber) scrub() (*ScrubResult, error) {
+	timer := s.stats.Timer("scrub").Start()
+	defer timer.Stop()
+
+	names, err := s.op.ListNames()
+	if err != nil {
+		return nil, fmt.Errorf("list names: %s", err)
+	}
+	result := &ScrubResult{Corrupt: []string{}}
+	for _, name := range names {
+		select {
+		case <-s.stopc:
+			return result, nil
+		default:
+		}
+		n, ok, err := s.verify(name)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.With("name", name).Errorf("Error scrubbing file: %s", err)
+				s.stats.Counter("errors").Inc(1)
+			}
+			continue
+		}
+		result.Files++
+		result.Bytes += n
+		s.stats.Counter("files").Inc(1)
+		s.stats.Counter("bytes").Inc(n)
+		if ok {
+			continue
+		}
+		log.With("name", name).Error("Cache file does not match its digest")
+		s.stats.Counter("corrupt_files").Inc(1)
+		if err := s.quarantine(name); err != nil {
+			log.With("name", name).Errorf("Error quarantining corrupt file: %s", err)
+			s.stats.Counter("errors").Inc(1)
+			continue
+		}
+		result.Corrupt = append(result.Corrupt, name)
+	}
+	return result, nil
+}
+
+// verify hashes name and returns the number of bytes read and whether the
+// content matches name.
+func (s *scrubber) verify(name string) (int64, bool, error) {
+	expected, err := core.NewSHA256DigestFromHex(name)
+	if err != nil {
+		// Not a blob, e.g. a leftover temporary file.
+		return 0, true, nil
+	}
+	f, err := s.op.GetFileReader(name)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	r := &rateLimitedReader{r: f, limiter: s.limiter}
+	computed, err := core.NewDigester().FromReader(r)
+	if err != nil {
+		return r.n, false, fmt.Errorf("calculate digest: %s", err)
+	}
+	return r.n, computed == expected, nil
+}
+
+// quarantine moves the data of name into the quarantine directory and removes
+// name from the cache, so it is no longer served.
+func (s *scrubber) quarantine(name string) error {
+	var ns metadata.Namespace
+	if err := s.op.GetFileMetadata(name, &ns); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("get namespace metadata: %s", err)
+	}
+	// A corrupt file cannot be written back, so it must not be kept around
+	// just because it is persisted.
+	if err := s.op.DeleteFileMetadata(name, &metadata.Persist{}); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("delete persist metadata: %s", err)
+	}
+	path, err := s.op.GetFilePath(name)
+	if err != nil {
+		return fmt.Errorf("get file path: %s", err)
+	}
+	target := filepath.Join(
+		s.config.QuarantineDir, fmt.Sprintf("%s.%d", name, s.clk.Now().Unix()))
+	if err := moveFile(path, target); err != nil {
+		return fmt.Errorf("move to quarantine: %s", err)
+	}
+	if err := s.op.DeleteFile(name); err != nil {
+		return fmt.Errorf("delete file: %s", err)
+	}
+
+	s.mu.Lock()
+	hook := s.hook
+	s.mu.Unlock()
+	if hook != nil {
+		hook(name, ns.Value)
+	}
+	return nil
+}
+
+// moveFile renames source to target, copying it if they are on different
+// volumes.
+func moveFile(source, target string) error {
+	err := os.Rename(source, target)
+	if err == nil {
+		return nil
+	}
+	linkErr, ok := err.(*os.LinkError)
+	if !ok || linkErr.Err != syscall.EXDEV {
+		return err
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if err := copyFile(source, target, info.Mode()); err != nil {
+		os.Remove(target)
+		return fmt.Errorf("copy: %s", err)
+	}
+	return os.Remove(source)
+}
+
+// rateLimitedReader throttles reads from r and counts the bytes read.
+type rateLimitedReader struct {
+	r       io.Reader
+	limiter *rate.Limiter
+	n       int64
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.n += int64(n)
+		if werr := r.limiter.WaitN(context.Background(), n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Scrub verifies every cache file of s against its digest, quarantining
+// corrupt files. Scrubs also run in the background if enabled.
+func (s *CAStore) Scrub() (*ScrubResult, error) {
+	if s.scrub == nil {
+		return nil, errors.New("scrub not enabled")
+	}
+	if s.ReadOnly() {
+		return nil, ErrReadOnly
+	}
+	return s.scrub.scrub()
+}
+
+// SetCorruptionHook sets h to be called for each file quarantined by a scrub.
+func (s *CAStore) SetCorruptionHook(h CorruptionHook) {
+	if s.scrub != nil {
+		s.scrub.setHook(h)
+	}
+}
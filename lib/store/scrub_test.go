@@ -0,0 +1,114 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func scrubCAStoreFixture(t *testing.T) (*CAStore, func()) {
+	config, cleanup := CAStoreConfigFixture()
+	config.Scrub = ScrubConfig{
+		Enabled:       true,
+		Interval:      time.Hour,
+		QuarantineDir: filepath.Join(config.CacheDir, "..", "quarantine"),
+	}
+	s, err := NewCAStore(config, tally.NoopScope)
+	require.NoError(t, err)
+	return s, func() {
+		s.Close()
+		os.RemoveAll(s.config.Scrub.QuarantineDir)
+		cleanup()
+	}
+}
+
+func TestScrubQuarantinesCorruptFiles(t *testing.T) {
+	require := require.New(t)
+
+	s, cleanup := scrubCAStoreFixture(t)
+	defer cleanup()
+
+	var hooked []string
+	s.SetCorruptionHook(func(name, namespace string) {
+		hooked = append(hooked, name+":"+namespace)
+	})
+
+	good := core.NewBlobFixture()
+	bad := core.NewBlobFixture()
+	for _, blob := range []*core.BlobFixture{good, bad} {
+		require.NoError(s.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+	}
+	_, err := s.SetCacheFileMetadata(bad.Digest.Hex(), metadata.NewPersist(true))
+	require.NoError(err)
+	_, err = s.SetCacheFileMetadata(bad.Digest.Hex(), metadata.NewNamespace("foo"))
+	require.NoError(err)
+
+	path, err := s.cacheStore.newFileOp().GetFilePath(bad.Digest.Hex())
+	require.NoError(err)
+	corrupted := append([]byte{}, bad.Content...)
+	corrupted[0] ^= 0xff
+	require.NoError(ioutil.WriteFile(path, corrupted, 0644))
+
+	result, err := s.Scrub()
+	require.NoError(err)
+	require.Equal(2, result.Files)
+	require.Equal(int64(len(good.Content)+len(bad.Content)), result.Bytes)
+	require.Equal([]string{bad.Digest.Hex()}, result.Corrupt)
+	require.Equal([]string{bad.Digest.Hex() + ":foo"}, hooked)
+
+	_, err = s.GetCacheFileStat(bad.Digest.Hex())
+	require.True(os.IsNotExist(err))
+	_, err = s.GetCacheFileStat(good.Digest.Hex())
+	require.NoError(err)
+
+	infos, err := ioutil.ReadDir(s.config.Scrub.QuarantineDir)
+	require.NoError(err)
+	require.Len(infos, 1)
+	b, err := ioutil.ReadFile(filepath.Join(s.config.Scrub.QuarantineDir, infos[0].Name()))
+	require.NoError(err)
+	require.Equal(corrupted, b)
+
+	// The corrupt file can be fetched again.
+	require.NoError(s.CreateCacheFile(bad.Digest.Hex(), bytes.NewReader(bad.Content)))
+}
+
+func TestScrubDisabledWhileReadOnly(t *testing.T) {
+	require := require.New(t)
+
+	s, cleanup := scrubCAStoreFixture(t)
+	defer cleanup()
+
+	s.SetReadOnly(true)
+	_, err := s.Scrub()
+	require.Equal(ErrReadOnly, err)
+}
+
+func TestScrubNotEnabled(t *testing.T) {
+	s, cleanup := CAStoreFixture()
+	defer cleanup()
+
+	_, err := s.Scrub()
+	require.Error(t, err)
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/uber/kraken/lib/store/base"
+	"github.com/uber/kraken/utils/log"
+)
+
+// _shardingMarker records the layout of a directory, so unchanged layouts are
+// not rescanned on startup. Directories without a marker use the default
+// layout, which predates configurable sharding.
+const _shardingMarker = ".sharding"
+
+// ShardingConfig defines how blobs are spread across nested directories. Each
+// blob is stored under Depth levels of directories, each named by the next
+// Width hex characters of its digest. Defaults to 2 levels of width 2. Small
+// hosts may use fewer levels, and hosts with very many blobs more.
+//
+// When the layout changes, existing files are moved to their new location
+// when the store starts.
+type ShardingConfig struct {
+	Depth int `yaml:"depth"`
+	Width int `yaml:"width"`
+}
+
+func (c ShardingConfig) applyDefaults() ShardingConfig {
+	if c.Depth == 0 {
+		c.Depth = base.DefaultSharding.Depth
+	}
+	if c.Width == 0 {
+		c.Width = base.DefaultSharding.Width
+	}
+	return c
+}
+
+func (c ShardingConfig) sharding() base.Sharding {
+	c = c.applyDefaults()
+	return base.Sharding{Depth: c.Depth, Width: c.Width}
+}
+
+// migrateSharding moves every file under dir which is not where sharding
+// places it, along with its metadata in backend, and removes shard directories
+// left empty. Returns the number of files moved.
+func migrateSharding(dir string, sharding base.Sharding, backend base.MetadataBackend) (int, error) {
+	marker := filepath.Join(dir, _shardingMarker)
+	current := fmt.Sprintf("%d,%d", sharding.Depth, sharding.Width)
+	b, err := ioutil.ReadFile(marker)
+	if err == nil && string(b) == current {
+		return 0, nil
+	} else if os.IsNotExist(err) && sharding == base.DefaultSharding {
+		return 0, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("read marker: %s", err)
+	}
+
+	moves := make(map[string]string)
+	if err := walkEntries(dir, func(entryDir string) {
+		name := filepath.Base(entryDir)
+		target := filepath.Join(dir, filepath.Dir(sharding.RelativePath(name)))
+		if entryDir != target {
+			moves[entryDir] = target
+		}
+	}); err != nil {
+		return 0, fmt.Errorf("walk: %s", err)
+	}
+	for source, target := range moves {
+		if err := moveEntry(source, target, backend); err != nil {
+			return 0, fmt.Errorf("move %s: %s", source, err)
+		}
+	}
+	if _, err := removeEmptyShards(dir, sharding); err != nil {
+		return 0, fmt.Errorf("remove empty shards: %s", err)
+	}
+	if len(moves) > 0 {
+		log.With("dir", dir).Infof(
+			"Migrated %d files to shard depth %d width %d", len(moves), sharding.Depth, sharding.Width)
+	}
+	if sharding == base.DefaultSharding {
+		if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+			return 0, fmt.Errorf("remove marker: %s", err)
+		}
+		return len(moves), nil
+	}
+	return len(moves), ioutil.WriteFile(marker, []byte(current), 0664)
+}
+
+// walkEntries calls f with every directory under dir which holds a data file.
+// Symlinked shards, as created for volumes, are followed.
+func walkEntries(dir string, f func(entryDir string)) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.Name() == base.DefaultDataFileName && info.Mode().IsRegular() {
+			f(dir)
+			return nil
+		}
+	}
+	for _, info := range infos {
+		p := filepath.Join(dir, info.Name())
+		if !isDir(p, info) {
+			continue
+		}
+		if err := walkEntries(p, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isDir(p string, info os.FileInfo) bool {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Stat(p)
+		return err == nil && target.IsDir()
+	}
+	return info.IsDir()
+}
+
+// moveEntry moves the entry directory source to target. If target already
+// exists, source is deleted instead.
+func moveEntry(source, target string, backend base.MetadataBackend) error {
+	if _, err := os.Stat(target); err == nil {
+		if err := os.RemoveAll(source); err != nil {
+			return err
+		}
+		return backend.DeleteAll(source)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), base.DefaultDirPermission); err != nil {
+		return err
+	}
+	if err := os.Rename(source, target); err != nil {
+		linkErr, ok := err.(*os.LinkError)
+		if !ok || linkErr.Err != syscall.EXDEV {
+			return err
+		}
+		// Source and target are on different volumes.
+		if err := copyDir(source, target); err != nil {
+			os.RemoveAll(target)
+			return fmt.Errorf("copy: %s", err)
+		}
+		if err := os.RemoveAll(source); err != nil {
+			return err
+		}
+	}
+
+	// Metadata stored outside of the entry directory is keyed by directory,
+	// so it must be moved separately.
+	suffixes, err := backend.List(source)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("list metadata: %s", err)
+	}
+	for _, suffix := range suffixes {
+		b, err := backend.Get(source, suffix)
+		if err != nil {
+			return fmt.Errorf("get metadata %s: %s", suffix, err)
+		}
+		if _, err := backend.Set(target, suffix, b); err != nil {
+			return fmt.Errorf("set metadata %s: %s", suffix, err)
+		}
+	}
+	return backend.DeleteAll(source)
+}
+
+func copyDir(source, target string) error {
+	return filepath.Walk(source, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, p)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(target, rel)
+		if info.IsDir() {
+			return os.MkdirAll(dst, base.DefaultDirPermission)
+		}
+		return copyFile(p, dst, info.Mode())
+	})
+}
+
+func copyFile(source, target string, mode os.FileMode) error {
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+	return dst.Close()
+}
+
+// removeEmptyShards removes directories under dir which contain no files.
+// Symlinked top-level shards are only removed if their name does not match
+// sharding, in which case the link is removed but its target is kept. Returns
+// true if dir is empty afterwards.
+func removeEmptyShards(dir string, sharding base.Sharding) (bool, error) {
+	return removeEmptyShardsAt(dir, sharding, true)
+}
+
+func removeEmptyShardsAt(dir string, sharding base.Sharding, root bool) (bool, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	empty := true
+	for _, info := range infos {
+		p := filepath.Join(dir, info.Name())
+		if !isDir(p, info) {
+			if !(root && info.Name() == _shardingMarker) {
+				empty = false
+			}
+			continue
+		}
+		childEmpty, err := removeEmptyShardsAt(p, sharding, false)
+		if err != nil {
+			return false, err
+		}
+		if !childEmpty {
+			empty = false
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 && len(info.Name()) == sharding.Width {
+			// Volume shard of the current layout.
+			empty = false
+			continue
+		}
+		if err := os.Remove(p); err != nil {
+			return false, err
+		}
+	}
+	return empty, nil
+}
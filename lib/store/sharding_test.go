@@ -0,0 +1,138 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestCADownloadStoreShardingMigration(t *testing.T) {
+	for _, backend := range []string{MetadataBackendFile, MetadataBackendSQLite} {
+		t.Run(backend, func(t *testing.T) {
+			require := require.New(t)
+
+			cleanup := &testutil.Cleanup{}
+			defer cleanup.Run()
+
+			config := CADownloadStoreConfig{
+				DownloadDir: tempdir(cleanup, "download"),
+				CacheDir:    tempdir(cleanup, "cache"),
+				Metadata: MetadataConfig{
+					Backend: backend,
+					Source:  filepath.Join(tempdir(cleanup, "metadata"), "metadata.db"),
+				},
+			}
+
+			s, err := NewCADownloadStore(config, tally.NoopScope)
+			require.NoError(err)
+			downloading := core.DigestFixture().Hex()
+			require.NoError(s.CreateDownloadFile(downloading, 1))
+			cached := core.DigestFixture().Hex()
+			require.NoError(s.CreateDownloadFile(cached, 1))
+			require.NoError(s.MoveDownloadFileToCache(cached))
+			_, err = s.Cache().SetMetadata(cached, metadata.NewPersist(true))
+			require.NoError(err)
+			s.Close()
+
+			for _, sharding := range []ShardingConfig{{Depth: 1, Width: 3}, {}} {
+				config.Sharding = sharding
+				s, err = NewCADownloadStore(config, tally.NoopScope)
+				require.NoError(err)
+
+				p, err := s.Download().GetFilePath(downloading)
+				require.NoError(err)
+				require.Equal(
+					filepath.Join(config.DownloadDir, config.Sharding.sharding().RelativePath(downloading)), p)
+				_, err = s.Download().GetFileStat(downloading)
+				require.NoError(err)
+
+				_, err = s.Cache().GetFileStat(cached)
+				require.NoError(err)
+				var persist metadata.Persist
+				require.NoError(s.Cache().GetMetadata(cached, &persist))
+				require.True(persist.Value)
+
+				// Only shards of the current layout remain.
+				infos, err := ioutil.ReadDir(config.CacheDir)
+				require.NoError(err)
+				for _, info := range infos {
+					if info.Name() == _shardingMarker {
+						continue
+					}
+					require.Len(info.Name(), config.Sharding.sharding().Width)
+				}
+				s.Close()
+			}
+			_, err = os.Stat(filepath.Join(config.CacheDir, _shardingMarker))
+			require.True(os.IsNotExist(err))
+		})
+	}
+}
+
+func TestCADownloadStoreShardingWithVolumes(t *testing.T) {
+	require := require.New(t)
+
+	cleanup := &testutil.Cleanup{}
+	defer cleanup.Run()
+
+	config := CADownloadStoreConfig{
+		DownloadDir: tempdir(cleanup, "download"),
+		CacheDir:    tempdir(cleanup, "cache"),
+		Volumes:     []Volume{{Location: tempdir(cleanup, "volume"), Weight: 100}},
+		Sharding:    ShardingConfig{Depth: 3, Width: 1},
+	}
+	s, err := NewCADownloadStore(config, tally.NoopScope)
+	require.NoError(err)
+	defer s.Close()
+
+	links, err := ioutil.ReadDir(config.CacheDir)
+	require.NoError(err)
+	var n int
+	for _, link := range links {
+		if link.Mode()&os.ModeSymlink != 0 {
+			n++
+		}
+	}
+	require.Equal(16, n)
+
+	name := core.DigestFixture().Hex()
+	require.NoError(s.CreateDownloadFile(name, 1))
+	require.NoError(s.MoveDownloadFileToCache(name))
+
+	names, err := s.Cache().ListNames()
+	require.NoError(err)
+	require.Equal([]string{name}, names)
+}
+
+func TestNewCADownloadStoreInvalidSharding(t *testing.T) {
+	cleanup := &testutil.Cleanup{}
+	defer cleanup.Run()
+
+	_, err := NewCADownloadStore(CADownloadStoreConfig{
+		DownloadDir: tempdir(cleanup, "download"),
+		CacheDir:    tempdir(cleanup, "cache"),
+		Sharding:    ShardingConfig{Depth: 1, Width: 9},
+	}, tally.NoopScope)
+	require.Error(t, err)
+}
@@ -37,7 +37,7 @@ func NewSimpleStore(config SimpleStoreConfig, stats tally.Scope) (*SimpleStore,
 		"module": "simplestore",
 	})
 
-	uploadStore, err := newUploadStore(config.UploadDir)
+	uploadStore, err := newUploadStore(config.UploadDir, false)
 	if err != nil {
 		return nil, fmt.Errorf("new upload store: %s", err)
 	}
@@ -52,8 +52,13 @@ func NewSimpleStore(config SimpleStoreConfig, stats tally.Scope) (*SimpleStore,
 	if err != nil {
 		return nil, fmt.Errorf("new cleanup manager: %s", err)
 	}
-	cleanup.addJob("upload", config.UploadCleanup, uploadStore.newFileOp())
-	cleanup.addJob("cache", config.CacheCleanup, cacheStore.newFileOp())
+	if err := cleanup.addJob("upload", config.UploadCleanup, uploadStore.newFileOp()); err != nil {
+		return nil, err
+	}
+	if err := cleanup.addJob("cache", config.CacheCleanup, cacheStore.newFileOp()); err != nil {
+		cleanup.stop()
+		return nil, err
+	}
 
 	return &SimpleStore{uploadStore, cacheStore, cleanup}, nil
 }
@@ -0,0 +1,222 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/base"
+	"github.com/uber/kraken/lib/store/metadata"
+)
+
+// Snapshot archives lay out each file as:
+//
+//	<state>/<name>/metadata/<suffix>
+//	<state>/<name>/data
+//
+// Metadata entries precede the data entry of a file, so piece statuses never
+// claim more than the exported data contains.
+const (
+	_snapshotDownload = "download"
+	_snapshotCache    = "cache"
+	_snapshotData     = "data"
+	_snapshotMetadata = "metadata"
+)
+
+// Export writes a tar archive of all download and cache files in s, including
+// their metadata, to w. Data is exported in plaintext regardless of whether s
+// encrypts it at rest. Returns the number of files exported.
+func (s *CADownloadStore) Export(w io.Writer) (int, error) {
+	tw := tar.NewWriter(w)
+	var n int
+	for _, scope := range []struct {
+		state string
+		op    *CADownloadStoreScope
+	}{
+		{_snapshotDownload, s.Download()},
+		{_snapshotCache, s.Cache()},
+	} {
+		names, err := scope.op.ListNames()
+		if err != nil {
+			return n, fmt.Errorf("list %s names: %s", scope.state, err)
+		}
+		for _, name := range names {
+			if err := exportFile(tw, scope.state, scope.op, name); err != nil {
+				if os.IsNotExist(err) {
+					// File was deleted during export.
+					continue
+				}
+				return n, fmt.Errorf("export %s: %s", name, err)
+			}
+			n++
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return n, fmt.Errorf("close tar: %s", err)
+	}
+	return n, nil
+}
+
+func exportFile(tw *tar.Writer, state string, scope *CADownloadStoreScope, name string) error {
+	var mds []metadata.Metadata
+	if err := scope.op.RangeFileMetadata(name, func(md metadata.Metadata) error {
+		mds = append(mds, md)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, md := range mds {
+		if err := scope.GetMetadata(name, md); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("get metadata %s: %s", md.GetSuffix(), err)
+		}
+		b, err := md.Serialize()
+		if err != nil {
+			return fmt.Errorf("serialize metadata %s: %s", md.GetSuffix(), err)
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Name: path.Join(state, name, _snapshotMetadata, md.GetSuffix()),
+			Mode: 0664,
+			Size: int64(len(b)),
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(b); err != nil {
+			return err
+		}
+	}
+
+	r, err := scope.GetFileReader(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	size := r.Size()
+	if err := tw.WriteHeader(&tar.Header{
+		Name: path.Join(state, name, _snapshotData),
+		Mode: 0664,
+		Size: size,
+	}); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(tw, r, size); err != nil {
+		return fmt.Errorf("copy data: %s", err)
+	}
+	return nil
+}
+
+// Import restores files from a tar archive written by Export into s. Files
+// which already exist in s are skipped, as are metadata types unknown to the
+// importing binary. Returns the number of files imported.
+func (s *CADownloadStore) Import(r io.Reader) (int, error) {
+	tr := tar.NewReader(r)
+	var n int
+	pending := make(map[string][]metadata.Metadata)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, fmt.Errorf("read tar: %s", err)
+		}
+		parts := strings.SplitN(hdr.Name, "/", 4)
+		if len(parts) < 3 {
+			return n, fmt.Errorf("invalid entry %q", hdr.Name)
+		}
+		var state base.FileState
+		switch parts[0] {
+		case _snapshotDownload:
+			state = s.downloadState
+		case _snapshotCache:
+			state = s.cacheState
+		default:
+			return n, fmt.Errorf("invalid entry %q: unknown state", hdr.Name)
+		}
+		name := parts[1]
+		if _, err := core.NewSHA256DigestFromHex(name); err != nil {
+			return n, fmt.Errorf("invalid entry %q: %s", hdr.Name, err)
+		}
+		switch {
+		case len(parts) == 4 && parts[2] == _snapshotMetadata:
+			md := metadata.CreateFromSuffix(parts[3])
+			if md == nil {
+				continue
+			}
+			b := make([]byte, hdr.Size)
+			if _, err := io.ReadFull(tr, b); err != nil {
+				return n, fmt.Errorf("read metadata %q: %s", hdr.Name, err)
+			}
+			if err := md.Deserialize(b); err != nil {
+				return n, fmt.Errorf("deserialize metadata %q: %s", hdr.Name, err)
+			}
+			pending[name] = append(pending[name], md)
+		case len(parts) == 3 && parts[2] == _snapshotData:
+			mds := pending[name]
+			delete(pending, name)
+			imported, err := s.importFile(state, name, hdr.Size, tr, mds)
+			if err != nil {
+				return n, fmt.Errorf("import %s: %s", name, err)
+			}
+			if imported {
+				n++
+			}
+		default:
+			return n, fmt.Errorf("invalid entry %q", hdr.Name)
+		}
+	}
+	return n, nil
+}
+
+// importFile creates name in state with the size bytes of data read from r,
+// then sets mds. Returns false if name already exists.
+func (s *CADownloadStore) importFile(
+	state base.FileState, name string, size int64, r io.Reader, mds []metadata.Metadata) (bool, error) {
+
+	op := s.backend.NewFileOp()
+	if err := op.CreateFile(name, state, size); err != nil {
+		if os.IsExist(err) || base.IsFileStateError(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("create file: %s", err)
+	}
+	op = op.AcceptState(state)
+	cleanup := func() { op.DeleteFile(name) }
+
+	w, err := op.GetFileReadWriter(name)
+	if err != nil {
+		cleanup()
+		return false, fmt.Errorf("get read writer: %s", err)
+	}
+	defer w.Close()
+	if _, err := io.CopyN(w, r, size); err != nil {
+		cleanup()
+		return false, fmt.Errorf("copy data: %s", err)
+	}
+	for _, md := range mds {
+		if _, err := op.SetFileMetadata(name, md); err != nil {
+			cleanup()
+			return false, fmt.Errorf("set metadata %s: %s", md.GetSuffix(), err)
+		}
+	}
+	return true, nil
+}
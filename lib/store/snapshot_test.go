@@ -0,0 +1,113 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/randutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeDownloadFixture(t *testing.T, s *CADownloadStore, name string, blob []byte) {
+	require.NoError(t, s.CreateDownloadFile(name, int64(len(blob))))
+	w, err := s.GetDownloadFileReadWriter(name)
+	require.NoError(t, err)
+	defer w.Close()
+	_, err = w.Write(blob)
+	require.NoError(t, err)
+}
+
+func readFileFixture(t *testing.T, scope *CADownloadStoreScope, name string) []byte {
+	r, err := scope.GetFileReader(name)
+	require.NoError(t, err)
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	return b
+}
+
+func TestCADownloadStoreExportImport(t *testing.T) {
+	require := require.New(t)
+
+	source, cleanup := CADownloadStoreFixture()
+	defer cleanup()
+
+	cached := core.DigestFixture().Hex()
+	cachedBlob := randutil.Text(256)
+	writeDownloadFixture(t, source, cached, cachedBlob)
+	require.NoError(source.MoveDownloadFileToCache(cached))
+	_, err := source.Cache().SetMetadata(cached, metadata.NewPersist(true))
+	require.NoError(err)
+
+	downloading := core.DigestFixture().Hex()
+	downloadingBlob := randutil.Text(128)
+	writeDownloadFixture(t, source, downloading, downloadingBlob)
+	_, err = source.Download().SetMetadata(downloading, metadata.NewPersist(true))
+	require.NoError(err)
+
+	var snapshot bytes.Buffer
+	n, err := source.Export(&snapshot)
+	require.NoError(err)
+	require.Equal(2, n)
+
+	target, cleanup := CADownloadStoreFixture()
+	defer cleanup()
+
+	n, err = target.Import(bytes.NewReader(snapshot.Bytes()))
+	require.NoError(err)
+	require.Equal(2, n)
+
+	require.Equal(cachedBlob, readFileFixture(t, target.Cache(), cached))
+	var persist metadata.Persist
+	require.NoError(target.Cache().GetMetadata(cached, &persist))
+	require.True(persist.Value)
+
+	require.Equal(downloadingBlob, readFileFixture(t, target.Download(), downloading))
+	persist = metadata.Persist{}
+	require.NoError(target.Download().GetMetadata(downloading, &persist))
+	require.True(persist.Value)
+
+	// Existing files are skipped.
+	n, err = target.Import(bytes.NewReader(snapshot.Bytes()))
+	require.NoError(err)
+	require.Equal(0, n)
+}
+
+func TestCADownloadStoreImportRejectsInvalidNames(t *testing.T) {
+	require := require.New(t)
+
+	var snapshot bytes.Buffer
+	tw := tar.NewWriter(&snapshot)
+	require.NoError(tw.WriteHeader(&tar.Header{
+		Name: "cache/../../etc/data",
+		Mode: 0664,
+		Size: 4,
+	}))
+	_, err := tw.Write([]byte("evil"))
+	require.NoError(err)
+	require.NoError(tw.Close())
+
+	s, cleanup := CADownloadStoreFixture()
+	defer cleanup()
+
+	_, err = s.Import(&snapshot)
+	require.Error(err)
+}
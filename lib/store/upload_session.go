@@ -0,0 +1,221 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+)
+
+const _uploadSessionSuffix = "_upload_session"
+
+func init() {
+	metadata.Register(regexp.MustCompile(_uploadSessionSuffix), &uploadSessionFactory{})
+}
+
+type uploadSessionFactory struct{}
+
+func (f uploadSessionFactory) Create(suffix string) metadata.Metadata {
+	return &uploadSession{}
+}
+
+// uploadSession records the progress of an upload written with
+// AppendUploadFile: the number of bytes written, and the state of the sha256
+// hash of those bytes.
+type uploadSession struct {
+	offset    int64
+	hashState []byte
+}
+
+func (m *uploadSession) GetSuffix() string {
+	return _uploadSessionSuffix
+}
+
+func (m *uploadSession) Movable() bool {
+	return false
+}
+
+func (m *uploadSession) Serialize() ([]byte, error) {
+	b := make([]byte, 8+len(m.hashState))
+	binary.BigEndian.PutUint64(b, uint64(m.offset))
+	copy(b[8:], m.hashState)
+	return b, nil
+}
+
+func (m *uploadSession) Deserialize(b []byte) error {
+	if len(b) < 8 {
+		return fmt.Errorf("unmarshal upload session: invalid length %d", len(b))
+	}
+	m.offset = int64(binary.BigEndian.Uint64(b))
+	m.hashState = append([]byte(nil), b[8:]...)
+	return nil
+}
+
+// hash restores the hash of the session.
+func (m *uploadSession) hash() (hash.Hash, error) {
+	h := sha256.New()
+	if len(m.hashState) > 0 {
+		if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(m.hashState); err != nil {
+			return nil, fmt.Errorf("unmarshal hash state: %s", err)
+		}
+	}
+	return h, nil
+}
+
+// UploadOffsetError is returned by AppendUploadFile when a chunk would leave a
+// gap after the bytes already written.
+type UploadOffsetError struct {
+	Offset int64
+}
+
+func (e UploadOffsetError) Error() string {
+	return fmt.Sprintf("upload offset is %d", e.Offset)
+}
+
+// getUploadSession returns the session of upload file name. Uploads which were
+// not written with AppendUploadFile have an empty session.
+func (s *CAStore) getUploadSession(name string) (*uploadSession, error) {
+	if _, err := s.GetUploadFileStat(name); err != nil {
+		return nil, err
+	}
+	session := &uploadSession{}
+	if err := s.GetUploadFileMetadata(name, session); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("get upload session: %s", err)
+	}
+	return session, nil
+}
+
+// UploadFileOffset returns the number of bytes of upload file name written
+// with AppendUploadFile, from which the upload may be resumed.
+func (s *CAStore) UploadFileOffset(name string) (int64, error) {
+	session, err := s.getUploadSession(name)
+	if err != nil {
+		return 0, err
+	}
+	return session.offset, nil
+}
+
+// AppendUploadFile writes r to upload file name at offset and returns the
+// offset after the write. Progress is persisted with the upload, so if
+// ResumableUploads is enabled the upload can be continued from
+// UploadFileOffset after a restart. offset may not exceed the current offset,
+// in which case UploadOffsetError is returned; a smaller offset rewrites the
+// upload from offset.
+func (s *CAStore) AppendUploadFile(name string, offset int64, r io.Reader) (int64, error) {
+	if s.ReadOnly() {
+		return 0, ErrReadOnly
+	}
+	session, err := s.getUploadSession(name)
+	if err != nil {
+		return 0, err
+	}
+	if offset > session.offset {
+		return session.offset, UploadOffsetError{session.offset}
+	}
+
+	w, err := s.GetUploadFileReadWriter(name)
+	if err != nil {
+		return 0, fmt.Errorf("get upload writer: %s", err)
+	}
+	defer w.Close()
+
+	var h hash.Hash
+	if offset == session.offset {
+		h, err = session.hash()
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		// Rewriting, e.g. a retried chunk. Rehash the bytes which are kept.
+		h = sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(w, 0, offset)); err != nil {
+			return 0, fmt.Errorf("hash upload prefix: %s", err)
+		}
+	}
+
+	if _, err := w.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("seek: %s", err)
+	}
+	n, err := io.Copy(w, io.TeeReader(r, h))
+	if err != nil {
+		return 0, fmt.Errorf("copy: %s", err)
+	}
+	end := offset + n
+	if offset < session.offset && end < w.Size() {
+		if err := s.truncateUploadFile(name, end); err != nil {
+			return 0, err
+		}
+	}
+	// The persisted offset must never cover data lost in a crash.
+	if sw, ok := w.(syncer); ok {
+		if err := sw.Sync(); err != nil {
+			return 0, fmt.Errorf("sync: %s", err)
+		}
+	}
+
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return 0, fmt.Errorf("marshal hash state: %s", err)
+	}
+	if err := s.SetUploadFileMetadata(name, &uploadSession{end, state}); err != nil {
+		return 0, fmt.Errorf("set upload session: %s", err)
+	}
+	return end, nil
+}
+
+func (s *CAStore) truncateUploadFile(name string, size int64) error {
+	path, err := s.uploadStore.newFileOp().GetFilePath(name)
+	if err != nil {
+		return fmt.Errorf("get upload path: %s", err)
+	}
+	if err := os.Truncate(path, size); err != nil {
+		return fmt.Errorf("truncate: %s", err)
+	}
+	return nil
+}
+
+// sessionDigest returns the digest of upload file name from its session, if
+// the session covers the whole file.
+func (s *CAStore) sessionDigest(name string) (core.Digest, bool, error) {
+	session, err := s.getUploadSession(name)
+	if err != nil {
+		return core.Digest{}, false, err
+	}
+	info, err := s.GetUploadFileStat(name)
+	if err != nil {
+		return core.Digest{}, false, err
+	}
+	if len(session.hashState) == 0 || session.offset != info.Size() {
+		return core.Digest{}, false, nil
+	}
+	h, err := session.hash()
+	if err != nil {
+		return core.Digest{}, false, err
+	}
+	d, err := core.NewSHA256DigestFromHex(hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return core.Digest{}, false, err
+	}
+	return d, true, nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestAppendUploadFileResumesAfterRestart(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := CAStoreConfigFixture()
+	defer cleanup()
+	config.ResumableUploads = true
+
+	blob := core.NewBlobFixture()
+	half := int64(len(blob.Content) / 2)
+
+	s, err := NewCAStore(config, tally.NoopScope)
+	require.NoError(err)
+	require.NoError(s.CreateUploadFile("upload", 0))
+	n, err := s.AppendUploadFile("upload", 0, bytes.NewReader(blob.Content[:half]))
+	require.NoError(err)
+	require.Equal(half, n)
+	s.Close()
+
+	s, err = NewCAStore(config, tally.NoopScope)
+	require.NoError(err)
+	defer s.Close()
+
+	offset, err := s.UploadFileOffset("upload")
+	require.NoError(err)
+	require.Equal(half, offset)
+
+	n, err = s.AppendUploadFile("upload", offset, bytes.NewReader(blob.Content[half:]))
+	require.NoError(err)
+	require.Equal(int64(len(blob.Content)), n)
+
+	require.NoError(s.MoveUploadFileToCache("upload", blob.Digest.Hex()))
+	r, err := s.GetCacheFileReader(blob.Digest.Hex())
+	require.NoError(err)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(err)
+	require.Equal(blob.Content, b)
+}
+
+func TestUploadsWipedOnRestartByDefault(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := CAStoreConfigFixture()
+	defer cleanup()
+
+	s, err := NewCAStore(config, tally.NoopScope)
+	require.NoError(err)
+	require.NoError(s.CreateUploadFile("upload", 0))
+	s.Close()
+
+	s, err = NewCAStore(config, tally.NoopScope)
+	require.NoError(err)
+	defer s.Close()
+
+	_, err = s.UploadFileOffset("upload")
+	require.True(os.IsNotExist(err))
+}
+
+func TestAppendUploadFileRejectsGaps(t *testing.T) {
+	require := require.New(t)
+
+	s, cleanup := CAStoreFixture()
+	defer cleanup()
+
+	require.NoError(s.CreateUploadFile("upload", 0))
+	_, err := s.AppendUploadFile("upload", 0, bytes.NewReader([]byte("abc")))
+	require.NoError(err)
+
+	_, err = s.AppendUploadFile("upload", 5, bytes.NewReader([]byte("def")))
+	require.Equal(UploadOffsetError{3}, err)
+}
+
+func TestAppendUploadFileRewrite(t *testing.T) {
+	require := require.New(t)
+
+	s, cleanup := CAStoreFixture()
+	defer cleanup()
+
+	blob := core.NewBlobFixture()
+
+	require.NoError(s.CreateUploadFile("upload", 0))
+	_, err := s.AppendUploadFile("upload", 0, bytes.NewReader(blob.Content[:10]))
+	require.NoError(err)
+	_, err = s.AppendUploadFile("upload", 10, bytes.NewReader([]byte("garbage which is not part of the blob")))
+	require.NoError(err)
+
+	// Retry from offset 10 with the real content.
+	n, err := s.AppendUploadFile("upload", 10, bytes.NewReader(blob.Content[10:]))
+	require.NoError(err)
+	require.Equal(int64(len(blob.Content)), n)
+
+	require.NoError(s.MoveUploadFileToCache("upload", blob.Digest.Hex()))
+}
+
+func TestAppendUploadFileDigestMismatch(t *testing.T) {
+	require := require.New(t)
+
+	s, cleanup := CAStoreFixture()
+	defer cleanup()
+
+	blob := core.NewBlobFixture()
+
+	require.NoError(s.CreateUploadFile("upload", 0))
+	_, err := s.AppendUploadFile("upload", 0, bytes.NewReader(blob.Content[1:]))
+	require.NoError(err)
+
+	require.Error(s.MoveUploadFileToCache("upload", blob.Digest.Hex()))
+}
@@ -29,9 +29,11 @@ type uploadStore struct {
 	backend base.FileStore
 }
 
-func newUploadStore(dir string) (*uploadStore, error) {
-	// Always wipe upload directory on startup.
-	os.RemoveAll(dir)
+func newUploadStore(dir string, keep bool) (*uploadStore, error) {
+	// Wipe upload directory on startup, unless uploads are resumable.
+	if !keep {
+		os.RemoveAll(dir)
+	}
 
 	if err := os.MkdirAll(dir, 0775); err != nil {
 		return nil, fmt.Errorf("mkdir: %s", err)
@@ -0,0 +1,109 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/uber/kraken/lib/store/base"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/log"
+)
+
+// UsageStat is the number and total size of a group of files.
+type UsageStat struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (u *UsageStat) add(size int64) {
+	u.Files++
+	u.Bytes += size
+}
+
+// Usage breaks down the disk usage of a store. States maps state names, such
+// as "upload", "download" and "cache", to their usage. Namespaces maps the
+// namespace each blob was written under to its usage, with blobs of unknown
+// namespace under the empty string. Upload files are not attributed to
+// namespaces.
+type Usage struct {
+	States     map[string]UsageStat `json:"states"`
+	Namespaces map[string]UsageStat `json:"namespaces"`
+}
+
+// usageState is a state to include in usage.
+type usageState struct {
+	name string
+	op   base.FileOp
+
+	// namespaced is true if files in the state may have Namespace metadata.
+	namespaced bool
+}
+
+// computeUsage scans the files of states. Files which are deleted during the
+// scan are skipped.
+func computeUsage(states []usageState) (*Usage, error) {
+	usage := &Usage{
+		States:     make(map[string]UsageStat),
+		Namespaces: make(map[string]UsageStat),
+	}
+	for _, state := range states {
+		names, err := state.op.ListNames()
+		if err != nil {
+			return nil, fmt.Errorf("list %s names: %s", state.name, err)
+		}
+		stat := usage.States[state.name]
+		for _, name := range names {
+			info, err := state.op.GetFileStat(name)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					log.With("name", name).Errorf("Error getting file stat: %s", err)
+				}
+				continue
+			}
+			stat.add(info.Size())
+			if !state.namespaced {
+				continue
+			}
+			var ns metadata.Namespace
+			if err := state.op.GetFileMetadata(name, &ns); err != nil && !os.IsNotExist(err) {
+				log.With("name", name).Errorf("Error getting namespace metadata: %s", err)
+			}
+			nsStat := usage.Namespaces[ns.Value]
+			nsStat.add(info.Size())
+			usage.Namespaces[ns.Value] = nsStat
+		}
+		usage.States[state.name] = stat
+	}
+	return usage, nil
+}
+
+// Usage returns the number and size of upload and cache files, and of cache
+// files per namespace.
+func (s *CAStore) Usage() (*Usage, error) {
+	return computeUsage([]usageState{
+		{"upload", s.uploadStore.newFileOp(), false},
+		{"cache", s.cacheStore.newFileOp(), true},
+	})
+}
+
+// Usage returns the number and size of download and cache files, overall and
+// per namespace.
+func (s *CADownloadStore) Usage() (*Usage, error) {
+	return computeUsage([]usageState{
+		{"download", s.Download().op, true},
+		{"cache", s.Cache().op, true},
+	})
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCAStoreUsage(t *testing.T) {
+	require := require.New(t)
+
+	s, cleanup := CAStoreFixture()
+	defer cleanup()
+
+	require.NoError(s.CreateUploadFile("upload", 7))
+
+	foo := core.NewBlobFixture()
+	bar := core.NewBlobFixture()
+	unknown := core.NewBlobFixture()
+	for _, blob := range []*core.BlobFixture{foo, bar, unknown} {
+		require.NoError(s.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+	}
+	_, err := s.SetCacheFileMetadata(foo.Digest.Hex(), metadata.NewNamespace("foo"))
+	require.NoError(err)
+	_, err = s.SetCacheFileMetadata(bar.Digest.Hex(), metadata.NewNamespace("bar"))
+	require.NoError(err)
+
+	size := func(blob *core.BlobFixture) int64 { return int64(len(blob.Content)) }
+
+	usage, err := s.Usage()
+	require.NoError(err)
+	require.Equal(map[string]UsageStat{
+		"upload": {1, 7},
+		"cache":  {3, size(foo) + size(bar) + size(unknown)},
+	}, usage.States)
+	require.Equal(map[string]UsageStat{
+		"foo": {1, size(foo)},
+		"bar": {1, size(bar)},
+		"":    {1, size(unknown)},
+	}, usage.Namespaces)
+}
+
+func TestCADownloadStoreUsage(t *testing.T) {
+	require := require.New(t)
+
+	s, cleanup := CADownloadStoreFixture()
+	defer cleanup()
+
+	downloading := core.DigestFixture().Hex()
+	require.NoError(s.CreateDownloadFile(downloading, 5))
+	_, err := s.Any().SetMetadata(downloading, metadata.NewNamespace("foo"))
+	require.NoError(err)
+
+	cached := core.DigestFixture().Hex()
+	require.NoError(s.CreateDownloadFile(cached, 3))
+	require.NoError(s.MoveDownloadFileToCache(cached))
+
+	usage, err := s.Usage()
+	require.NoError(err)
+	require.Equal(map[string]UsageStat{
+		"download": {1, 5},
+		"cache":    {1, 3},
+	}, usage.States)
+	require.Equal(map[string]UsageStat{
+		"foo": {1, 5},
+		"":    {1, 3},
+	}, usage.Namespaces)
+}
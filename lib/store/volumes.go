@@ -0,0 +1,151 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"fmt"
+	"hash"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/uber/kraken/lib/hrw"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// _volumeStatsInterval is how often volume capacity gauges are emitted.
+const _volumeStatsInterval = time.Minute
+
+// initVolumes spreads the shards of dirs across volumes by creating a symlink
+// for each top-level shard of width hex characters under each dir, pointing to a directory on the
+// volume chosen for the shard by rendezvous hashing. Since the volume only
+// depends on the shard, a blob is placed on the same volume in every dir,
+// such that files can be moved between dirs with a rename.
+//
+// Shards which already exist as regular directories, for example because dir
+// was used before volumes were configured, are left in place.
+func initVolumes(dirs []string, volumes []Volume, width int) error {
+	if len(volumes) == 0 {
+		return nil
+	}
+
+	bases := make(map[string]bool)
+	for _, dir := range dirs {
+		b := path.Base(dir)
+		if bases[b] {
+			return fmt.Errorf("dirs must have distinct base names to share volumes: %s", b)
+		}
+		bases[b] = true
+	}
+
+	rendezvousHash := hrw.NewRendezvousHash(
+		func() hash.Hash { return murmur3.New64() },
+		hrw.UInt64ToFloat64)
+
+	for _, v := range volumes {
+		if _, err := os.Stat(v.Location); err != nil {
+			return fmt.Errorf("verify volume: %s", err)
+		}
+		rendezvousHash.AddNode(v.Location, v.Weight)
+	}
+
+	// Create 16^width symlinks under each dir. Shard names are lowercase hex,
+	// as file names are hex digests.
+	for subdirIndex := 0; subdirIndex < 1<<uint(4*width); subdirIndex++ {
+		subdirName := fmt.Sprintf("%0*x", width, subdirIndex)
+		nodes := rendezvousHash.GetOrderedNodes(subdirName, 1)
+		if len(nodes) != 1 {
+			return fmt.Errorf("calculate volume for subdir: %s", subdirName)
+		}
+		for _, dir := range dirs {
+			targetPath := path.Join(dir, subdirName)
+			if info, err := os.Lstat(targetPath); err == nil && info.IsDir() {
+				log.With("path", targetPath).Warn("Shard is not on a volume, skipping")
+				continue
+			}
+			sourcePath := path.Join(nodes[0].Label, path.Base(dir), subdirName)
+			if err := os.MkdirAll(sourcePath, 0775); err != nil {
+				return fmt.Errorf("volume source path: %s", err)
+			}
+			if err := createOrUpdateSymlink(sourcePath, targetPath); err != nil {
+				return fmt.Errorf("symlink to volume: %s", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// VolumeStat describes the capacity of a volume.
+type VolumeStat struct {
+	Location string `json:"location"`
+	Weight   int    `json:"weight"`
+
+	// Size is the total size of the filesystem of the volume in bytes.
+	Size uint64 `json:"size"`
+
+	// Free is the number of bytes available to unprivileged users.
+	Free uint64 `json:"free"`
+}
+
+func statVolumes(volumes []Volume) ([]VolumeStat, error) {
+	var stats []VolumeStat
+	for _, v := range volumes {
+		var fs syscall.Statfs_t
+		if err := syscall.Statfs(v.Location, &fs); err != nil {
+			return nil, fmt.Errorf("statfs %s: %s", v.Location, err)
+		}
+		stats = append(stats, VolumeStat{
+			Location: v.Location,
+			Weight:   v.Weight,
+			Size:     fs.Blocks * uint64(fs.Bsize),
+			Free:     fs.Bavail * uint64(fs.Bsize),
+		})
+	}
+	return stats, nil
+}
+
+// addVolumeJob starts a background task which periodically emits capacity
+// gauges for each volume.
+func (m *cleanupManager) addVolumeJob(volumes []Volume) {
+	if len(volumes) == 0 {
+		return
+	}
+
+	ticker := m.clk.Ticker(_volumeStatsInterval)
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				stats, err := statVolumes(volumes)
+				if err != nil {
+					log.Errorf("Error getting volume stats: %s", err)
+					continue
+				}
+				for _, s := range stats {
+					scope := m.stats.Tagged(map[string]string{"volume": s.Location})
+					scope.Gauge("volume_size").Update(float64(s.Size))
+					scope.Gauge("volume_free").Update(float64(s.Free))
+				}
+			case <-m.stopc:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
@@ -17,4 +17,7 @@ package networkevent
 type Config struct {
 	LogPath string `yaml:"log_path"`
 	Enabled bool   `yaml:"enabled"`
+
+	// Sinks export events to external systems in addition to LogPath.
+	Sinks []SinkConfig `yaml:"sinks"`
 }
@@ -29,16 +29,21 @@ type Producer interface {
 }
 
 type producer struct {
-	file *os.File
+	file  *os.File
+	sinks []*batcher
 }
 
 // NewProducer creates a new Producer.
 func NewProducer(config Config) (Producer, error) {
+	if !config.Enabled {
+		log.Warn("Kafka network events disabled")
+		return &producer{}, nil
+	}
+	if config.LogPath == "" && len(config.Sinks) == 0 {
+		return nil, errors.New("no log path or sinks supplied")
+	}
 	var f *os.File
-	if config.Enabled {
-		if config.LogPath == "" {
-			return nil, errors.New("no log path supplied")
-		}
+	if config.LogPath != "" {
 		var flag int
 		if _, err := os.Stat(config.LogPath); err != nil {
 			if os.IsNotExist(err) {
@@ -54,15 +59,23 @@ func NewProducer(config Config) (Producer, error) {
 		if err != nil {
 			return nil, fmt.Errorf("open %d: %s", flag, err)
 		}
-	} else {
-		log.Warn("Kafka network events disabled")
 	}
-	return &producer{f}, nil
+	p := &producer{file: f}
+	for _, sc := range config.Sinks {
+		sc = sc.applyDefaults()
+		s, err := newSink(sc)
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("sink: %s", err)
+		}
+		p.sinks = append(p.sinks, newBatcher(sc, s))
+	}
+	return p, nil
 }
 
 // Produce emits a network event.
 func (p *producer) Produce(e *Event) {
-	if p.file == nil {
+	if p.file == nil && len(p.sinks) == 0 {
 		return
 	}
 	b, err := json.Marshal(e)
@@ -70,6 +83,12 @@ func (p *producer) Produce(e *Event) {
 		log.Errorf("Error serializing network event to json: %s", err)
 		return
 	}
+	for _, s := range p.sinks {
+		s.enqueue(b)
+	}
+	if p.file == nil {
+		return
+	}
 	line := append(b, byte('\n'))
 	if _, err := p.file.Write(line); err != nil {
 		log.Errorf("Error writing network event: %s", err)
@@ -77,10 +96,24 @@ func (p *producer) Produce(e *Event) {
 	}
 }
 
-// Close closes the producer.
+// Close closes the producer, flushing events buffered for sinks.
 func (p *producer) Close() error {
+	err := closeBatchers(p.sinks)
 	if p.file == nil {
-		return nil
+		return err
+	}
+	if ferr := p.file.Close(); ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+func closeBatchers(bs []*batcher) error {
+	var err error
+	for _, b := range bs {
+		if cerr := b.close(); cerr != nil {
+			err = cerr
+		}
 	}
-	return p.file.Close()
+	return err
 }
@@ -0,0 +1,303 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package networkevent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+)
+
+// Sink types.
+const (
+	StdoutSink    = "stdout"
+	HTTPSink      = "http"
+	KafkaRESTSink = "kafka_rest"
+)
+
+// SinkConfig defines an external sink which network events are exported to in
+// batches.
+type SinkConfig struct {
+	// Type is one of "stdout", "http" or "kafka_rest".
+	//
+	// stdout writes events as JSON lines to stdout.
+	//
+	// http POSTs batches of events as newline-delimited JSON to URL.
+	//
+	// kafka_rest produces batches of events to Topic through the Kafka REST
+	// proxy at URL.
+	Type string `yaml:"type"`
+
+	URL   string `yaml:"url"`
+	Topic string `yaml:"topic"`
+
+	// BatchSize is the maximum number of events written at once.
+	BatchSize int `yaml:"batch_size"`
+
+	// FlushInterval is the maximum duration events are buffered for before
+	// being written.
+	FlushInterval time.Duration `yaml:"flush_interval"`
+
+	// QueueSize is the number of events which may be buffered while the sink
+	// is writing. Once the queue is full, events are dropped.
+	QueueSize int `yaml:"queue_size"`
+
+	// BlockTimeout is the duration producers wait for space in a full queue
+	// before dropping events. Defaults to not waiting, such that a slow sink
+	// never stalls torrent downloads.
+	BlockTimeout time.Duration `yaml:"block_timeout"`
+
+	// Timeout is the timeout of writes to http and kafka_rest sinks.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c SinkConfig) applyDefaults() SinkConfig {
+	if c.BatchSize == 0 {
+		c.BatchSize = 100
+	}
+	if c.FlushInterval == 0 {
+		c.FlushInterval = time.Second
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = 10000
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+	return c
+}
+
+// Sink writes batches of JSON serialized events.
+type Sink interface {
+	Write(events [][]byte) error
+	Close() error
+}
+
+func newSink(config SinkConfig) (Sink, error) {
+	switch config.Type {
+	case StdoutSink:
+		return &writerSink{w: os.Stdout}, nil
+	case HTTPSink:
+		if config.URL == "" {
+			return nil, fmt.Errorf("%s sink requires url", config.Type)
+		}
+		return &httpSink{config}, nil
+	case KafkaRESTSink:
+		if config.URL == "" || config.Topic == "" {
+			return nil, fmt.Errorf("%s sink requires url and topic", config.Type)
+		}
+		return &kafkaRESTSink{config}, nil
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", config.Type)
+	}
+}
+
+// writerSink writes events as JSON lines.
+type writerSink struct {
+	w io.Writer
+}
+
+func (s *writerSink) Write(events [][]byte) error {
+	_, err := s.w.Write(joinLines(events))
+	return err
+}
+
+func (s *writerSink) Close() error { return nil }
+
+// httpSink POSTs events as newline-delimited JSON.
+type httpSink struct {
+	config SinkConfig
+}
+
+func (s *httpSink) Write(events [][]byte) error {
+	resp, err := httputil.Post(
+		s.config.URL,
+		httputil.SendBody(bytes.NewReader(joinLines(events))),
+		httputil.SendHeaders(map[string]string{"Content-Type": "application/x-ndjson"}),
+		httputil.SendAcceptedCodes(http.StatusOK, http.StatusAccepted, http.StatusNoContent),
+		httputil.SendTimeout(s.config.Timeout))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *httpSink) Close() error { return nil }
+
+// kafkaRESTSink produces events to a Kafka topic through a Kafka REST proxy.
+type kafkaRESTSink struct {
+	config SinkConfig
+}
+
+type kafkaRecord struct {
+	Value json.RawMessage `json:"value"`
+}
+
+type kafkaProduceRequest struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+func (s *kafkaRESTSink) Write(events [][]byte) error {
+	req := kafkaProduceRequest{Records: make([]kafkaRecord, len(events))}
+	for i, e := range events {
+		req.Records[i] = kafkaRecord{Value: e}
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal records: %s", err)
+	}
+	url := fmt.Sprintf("%s/topics/%s", strings.TrimRight(s.config.URL, "/"), s.config.Topic)
+	resp, err := httputil.Post(
+		url,
+		httputil.SendBody(bytes.NewReader(b)),
+		httputil.SendHeaders(map[string]string{
+			"Content-Type": "application/vnd.kafka.json.v2+json",
+		}),
+		httputil.SendTimeout(s.config.Timeout))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *kafkaRESTSink) Close() error { return nil }
+
+func joinLines(events [][]byte) []byte {
+	var buf bytes.Buffer
+	for _, e := range events {
+		buf.Write(e)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// batcher buffers events for a Sink, writing them in batches from a single
+// goroutine. Events are dropped when the sink cannot keep up.
+type batcher struct {
+	config SinkConfig
+	sink   Sink
+	queue  chan []byte
+
+	mu      sync.Mutex
+	dropped int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newBatcher(config SinkConfig, sink Sink) *batcher {
+	b := &batcher{
+		config: config,
+		sink:   sink,
+		queue:  make(chan []byte, config.QueueSize),
+		done:   make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.loop()
+	return b
+}
+
+// enqueue adds e to the queue, waiting up to BlockTimeout if it is full.
+func (b *batcher) enqueue(e []byte) {
+	select {
+	case b.queue <- e:
+		return
+	default:
+	}
+	if b.config.BlockTimeout > 0 {
+		timer := time.NewTimer(b.config.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case b.queue <- e:
+			return
+		case <-timer.C:
+		}
+	}
+	b.mu.Lock()
+	b.dropped++
+	b.mu.Unlock()
+}
+
+func (b *batcher) loop() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.config.FlushInterval)
+	defer ticker.Stop()
+
+	var batch [][]byte
+	for {
+		select {
+		case e := <-b.queue:
+			batch = append(batch, e)
+			if len(batch) >= b.config.BatchSize {
+				b.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			b.flush(batch)
+			batch = nil
+		case <-b.done:
+			// Drain events produced before close.
+			for {
+				select {
+				case e := <-b.queue:
+					batch = append(batch, e)
+					if len(batch) >= b.config.BatchSize {
+						b.flush(batch)
+						batch = nil
+					}
+				default:
+					b.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (b *batcher) flush(batch [][]byte) {
+	b.mu.Lock()
+	dropped := b.dropped
+	b.dropped = 0
+	b.mu.Unlock()
+	if dropped > 0 {
+		log.Warnf("Dropped %d network events: %s sink queue full", dropped, b.config.Type)
+	}
+
+	if len(batch) == 0 {
+		return
+	}
+	if err := b.sink.Write(batch); err != nil {
+		log.Errorf("Error writing %d network events to %s sink: %s", len(batch), b.config.Type, err)
+	}
+}
+
+// close flushes buffered events and closes the sink. Events must not be
+// enqueued after close.
+func (b *batcher) close() error {
+	close(b.done)
+	b.wg.Wait()
+	return b.sink.Close()
+}
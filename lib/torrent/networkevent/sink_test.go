@@ -0,0 +1,205 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package networkevent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSink struct {
+	sync.Mutex
+	batches [][][]byte
+	block   chan struct{}
+}
+
+func (s *recordingSink) Write(events [][]byte) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.batches = append(s.batches, events)
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+func (s *recordingSink) count() int {
+	s.Lock()
+	defer s.Unlock()
+	var n int
+	for _, b := range s.batches {
+		n += len(b)
+	}
+	return n
+}
+
+func eventsFixture(n int) []*Event {
+	h := core.InfoHashFixture()
+	peer1 := core.PeerIDFixture()
+	peer2 := core.PeerIDFixture()
+	var events []*Event
+	for i := 0; i < n; i++ {
+		events = append(events, ReceivePieceEvent(h, peer1, peer2, i))
+	}
+	return events
+}
+
+func TestBatcherWritesFullBatches(t *testing.T) {
+	require := require.New(t)
+
+	sink := &recordingSink{}
+	b := newBatcher(SinkConfig{BatchSize: 2, FlushInterval: time.Hour}.applyDefaults(), sink)
+
+	for i := 0; i < 5; i++ {
+		b.enqueue([]byte("{}"))
+	}
+	require.NoError(b.close())
+
+	sink.Lock()
+	defer sink.Unlock()
+	require.Len(sink.batches, 3)
+	require.Len(sink.batches[0], 2)
+	require.Len(sink.batches[1], 2)
+	require.Len(sink.batches[2], 1)
+}
+
+func TestBatcherFlushesOnInterval(t *testing.T) {
+	require := require.New(t)
+
+	sink := &recordingSink{}
+	b := newBatcher(SinkConfig{FlushInterval: 10 * time.Millisecond}.applyDefaults(), sink)
+	defer b.close()
+
+	b.enqueue([]byte("{}"))
+
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool { return sink.count() == 1 }))
+}
+
+func TestBatcherDropsEventsWhenQueueFull(t *testing.T) {
+	require := require.New(t)
+
+	sink := &recordingSink{block: make(chan struct{})}
+	b := newBatcher(SinkConfig{BatchSize: 1, QueueSize: 2}.applyDefaults(), sink)
+
+	// The first event is taken by the blocked write, two fill the queue and the
+	// rest are dropped.
+	b.enqueue([]byte("{}"))
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool { return len(b.queue) == 0 }))
+	for i := 0; i < 5; i++ {
+		b.enqueue([]byte("{}"))
+	}
+	close(sink.block)
+	require.NoError(b.close())
+
+	require.Equal(3, sink.count())
+}
+
+func TestProducerExportsToHTTPSink(t *testing.T) {
+	require := require.New(t)
+
+	var mu sync.Mutex
+	var results []*Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		s := bufio.NewScanner(r.Body)
+		for s.Scan() {
+			e := new(Event)
+			require.NoError(json.Unmarshal(s.Bytes(), e))
+			results = append(results, e)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	p, err := NewProducer(Config{
+		Enabled: true,
+		Sinks:   []SinkConfig{{Type: HTTPSink, URL: server.URL}},
+	})
+	require.NoError(err)
+
+	events := eventsFixture(3)
+	for _, e := range events {
+		p.Produce(e)
+	}
+	require.NoError(p.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(StripTimestamps(events), StripTimestamps(results))
+}
+
+func TestKafkaRESTSinkProducesRecords(t *testing.T) {
+	require := require.New(t)
+
+	var req kafkaProduceRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal("/topics/netevents", r.URL.Path)
+		require.Equal("application/vnd.kafka.json.v2+json", r.Header.Get("Content-Type"))
+		b, err := ioutil.ReadAll(r.Body)
+		require.NoError(err)
+		require.NoError(json.Unmarshal(b, &req))
+	}))
+	defer server.Close()
+
+	sink, err := newSink(SinkConfig{
+		Type:  KafkaRESTSink,
+		URL:   server.URL,
+		Topic: "netevents",
+	}.applyDefaults())
+	require.NoError(err)
+
+	require.NoError(sink.Write([][]byte{[]byte(`{"a":1}`), []byte(`{"b":2}`)}))
+
+	require.Len(req.Records, 2)
+	require.JSONEq(`{"a":1}`, string(req.Records[0].Value))
+	require.JSONEq(`{"b":2}`, string(req.Records[1].Value))
+}
+
+func TestWriterSinkWritesJSONLines(t *testing.T) {
+	require := require.New(t)
+
+	var buf bytes.Buffer
+	sink := &writerSink{&buf}
+
+	require.NoError(sink.Write([][]byte{[]byte(`{"a":1}`), []byte(`{"b":2}`)}))
+	require.Equal("{\"a\":1}\n{\"b\":2}\n", buf.String())
+}
+
+func TestNewSinkErrors(t *testing.T) {
+	for _, config := range []SinkConfig{
+		{Type: "unknown"},
+		{Type: HTTPSink},
+		{Type: KafkaRESTSink, URL: "http://localhost:8082"},
+	} {
+		t.Run(config.Type, func(t *testing.T) {
+			_, err := newSink(config)
+			require.Error(t, err)
+		})
+	}
+}
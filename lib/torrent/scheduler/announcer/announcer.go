@@ -28,6 +28,14 @@ import (
 type Config struct {
 	DefaultInterval time.Duration `yaml:"default_interval"`
 	MaxInterval     time.Duration `yaml:"max_interval"`
+
+	// MinInterval bounds intervals suggested by the tracker from below, such
+	// that a misbehaving tracker cannot cause announce storms.
+	MinInterval time.Duration `yaml:"min_interval"`
+
+	// BatchSize is the maximum number of torrents announced together on each
+	// announce tick. Batching is disabled if BatchSize is at most 1.
+	BatchSize int `yaml:"batch_size"`
 }
 
 func (c Config) applyDefaults() Config {
@@ -75,7 +83,6 @@ func New(
 }
 
 // Default creates a default Announcer.
-// TODO(evelynl94): make announce interval configurable.
 func Default(
 	client announceclient.Client,
 	events Events,
@@ -87,12 +94,44 @@ func Default(
 // Announce announces through the underlying client and returns the resulting
 // peer handout. Updates the announce interval if it has changed.
 func (a *Announcer) Announce(
-	d core.Digest, h core.InfoHash, complete bool) ([]*core.PeerInfo, error) {
+	namespace string, d core.Digest, h core.InfoHash, complete bool) ([]*core.PeerInfo, error) {
+
+	peers, interval, err := a.client.Announce(namespace, d, h, complete, announceclient.V1)
+	if err != nil {
+		return nil, err
+	}
+	a.updateInterval(interval)
+	return peers, nil
+}
+
+// BatchSize returns the maximum number of torrents to announce together.
+func (a *Announcer) BatchSize() int {
+	if a.config.BatchSize < 1 {
+		return 1
+	}
+	return a.config.BatchSize
+}
+
+// BatchAnnounce announces items together through the underlying client.
+// Updates the announce interval from the first successful result.
+func (a *Announcer) BatchAnnounce(
+	items []announceclient.BatchItem) ([]announceclient.BatchResult, error) {
 
-	peers, interval, err := a.client.Announce(d, h, complete, announceclient.V1)
+	results, err := a.client.BatchAnnounce(items)
 	if err != nil {
 		return nil, err
 	}
+	for _, r := range results {
+		if r.Err() == nil {
+			a.updateInterval(r.Interval)
+			break
+		}
+	}
+	return results, nil
+}
+
+// updateInterval bounds and applies an interval suggested by the tracker.
+func (a *Announcer) updateInterval(interval time.Duration) {
 	if interval == 0 {
 		// Protect against unset intervals.
 		interval = a.config.DefaultInterval
@@ -103,11 +142,13 @@ func (a *Announcer) Announce(
 		// mistake in the central authority which will become impossible to correct.
 		interval = a.config.DefaultInterval
 	}
+	if interval < a.config.MinInterval {
+		interval = a.config.MinInterval
+	}
 	if a.interval.Swap(int64(interval)) != int64(interval) {
 		// Note: updated interval will take effect after next tick.
 		a.logger.Infof("Announce interval updated to %s", interval)
 	}
-	return peers, nil
 }
 
 // Ticker emits AnnounceTick events at the current announce interval, which may be
@@ -32,6 +32,8 @@ import (
 // to prevent flakey tests.
 const _tickerTimeout = time.Second
 
+const _testNamespace = "noexist"
+
 type mockEvents struct {
 	tick chan struct{}
 }
@@ -97,9 +99,9 @@ func TestAnnouncerAnnounceUpdatesInterval(t *testing.T) {
 	interval := 10 * time.Second
 	peers := []*core.PeerInfo{core.PeerInfoFixture()}
 
-	mocks.client.EXPECT().Announce(d, hash, false, announceclient.V1).Return(peers, interval, nil)
+	mocks.client.EXPECT().Announce(_testNamespace, d, hash, false, announceclient.V1).Return(peers, interval, nil)
 
-	result, err := announcer.Announce(d, hash, false)
+	result, err := announcer.Announce(_testNamespace, d, hash, false)
 	require.NoError(err)
 	require.Equal(peers, result)
 
@@ -129,8 +131,73 @@ func TestAnnouncerAnnounceErr(t *testing.T) {
 	hash := core.InfoHashFixture()
 	err := errors.New("some error")
 
-	mocks.client.EXPECT().Announce(d, hash, false, announceclient.V1).Return(nil, time.Duration(0), err)
+	mocks.client.EXPECT().Announce(_testNamespace, d, hash, false, announceclient.V1).Return(nil, time.Duration(0), err)
+
+	_, aErr := announcer.Announce(_testNamespace, d, hash, false)
+	require.Equal(err, aErr)
+}
+
+func TestAnnouncerAnnounceBoundsIntervalFromBelow(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newAnnouncerMocks(t)
+	defer cleanup()
+
+	config := Config{DefaultInterval: 5 * time.Second, MinInterval: 2 * time.Second}
+
+	announcer := mocks.newAnnouncer(config)
+
+	d := core.DigestFixture()
+	hash := core.InfoHashFixture()
+
+	mocks.client.EXPECT().Announce(
+		_testNamespace, d, hash, false, announceclient.V1).Return(nil, 10*time.Millisecond, nil)
+
+	_, err := announcer.Announce(_testNamespace, d, hash, false)
+	require.NoError(err)
+	require.Equal(int64(config.MinInterval), announcer.interval.Load())
+}
+
+func TestAnnouncerBatchAnnounceUpdatesIntervalFromFirstSuccess(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newAnnouncerMocks(t)
+	defer cleanup()
+
+	announcer := mocks.newAnnouncer(Config{DefaultInterval: 5 * time.Second})
+
+	items := []announceclient.BatchItem{
+		{Namespace: _testNamespace, Digest: core.DigestFixture(), InfoHash: core.InfoHashFixture()},
+		{Namespace: _testNamespace, Digest: core.DigestFixture(), InfoHash: core.InfoHashFixture()},
+	}
+	results := []announceclient.BatchResult{
+		{InfoHash: items[0].InfoHash, Error: "some error"},
+		{InfoHash: items[1].InfoHash, Interval: 10 * time.Second},
+	}
+
+	mocks.client.EXPECT().BatchAnnounce(items).Return(results, nil)
+
+	result, err := announcer.BatchAnnounce(items)
+	require.NoError(err)
+	require.Equal(results, result)
+	require.Equal(int64(10*time.Second), announcer.interval.Load())
+}
+
+func TestAnnouncerBatchAnnounceErr(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newAnnouncerMocks(t)
+	defer cleanup()
+
+	announcer := mocks.newAnnouncer(Config{})
+
+	items := []announceclient.BatchItem{
+		{Namespace: _testNamespace, Digest: core.DigestFixture(), InfoHash: core.InfoHashFixture()},
+	}
+	err := errors.New("some error")
+
+	mocks.client.EXPECT().BatchAnnounce(items).Return(nil, err)
 
-	_, aErr := announcer.Announce(d, hash, false)
+	_, aErr := announcer.BatchAnnounce(items)
 	require.Equal(err, aErr)
 }
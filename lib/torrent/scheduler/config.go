@@ -16,9 +16,13 @@ package scheduler
 import (
 	"time"
 
+	"github.com/uber/kraken/lib/torrent/scheduler/announcer"
 	"github.com/uber/kraken/lib/torrent/scheduler/conn"
 	"github.com/uber/kraken/lib/torrent/scheduler/connstate"
 	"github.com/uber/kraken/lib/torrent/scheduler/dispatch"
+	"github.com/uber/kraken/lib/torrent/scheduler/gossip"
+	"github.com/uber/kraken/lib/torrent/scheduler/lpd"
+	"github.com/uber/kraken/lib/torrent/scheduler/reputation"
 	"github.com/uber/kraken/utils/log"
 )
 
@@ -33,6 +37,10 @@ type Config struct {
 	// written to before being cancelled.
 	LeecherTTI time.Duration `yaml:"leecher_tti"`
 
+	// SeedingPolicies override how long complete torrents are seeded for
+	// matching namespaces. The first matching policy is used.
+	SeedingPolicies []SeedingPolicy `yaml:"seeding_policies"`
+
 	// ConnTTI is the duration a connection will exist without transmitting any
 	// needed pieces or requesting any pieces.
 	ConnTTI time.Duration `yaml:"conn_tti"`
@@ -60,6 +68,26 @@ type Config struct {
 
 	Dispatch dispatch.Config `yaml:"dispatch"`
 
+	// Background defines the limits of background priority torrents.
+	Background BackgroundConfig `yaml:"background"`
+
+	// Gossip configures tracker-less peer discovery.
+	Gossip gossip.Config `yaml:"gossip"`
+
+	// LPD configures discovery of peers on the local network segment.
+	LPD lpd.Config `yaml:"lpd"`
+
+	// PersistBlacklist persists connection blacklists in the torrent archive,
+	// such that misbehaving peers remain blacklisted across restarts.
+	PersistBlacklist bool `yaml:"persist_blacklist"`
+
+	// Announcer bounds the announce intervals suggested by the tracker.
+	Announcer announcer.Config `yaml:"announcer"`
+
+	// Reputation scores peers by their history, such that slow or corrupt
+	// peers are deprioritized.
+	Reputation reputation.Config `yaml:"reputation"`
+
 	TorrentLog log.Config `yaml:"torrentlog"`
 	Log        log.Config `yaml:"log"`
 }
@@ -86,5 +114,6 @@ func (c Config) applyDefaults() Config {
 	if c.ProbeTimeout == 0 {
 		c.ProbeTimeout = 3 * time.Second
 	}
+	c.Background = c.Background.applyDefaults()
 	return c
 }
@@ -0,0 +1,194 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/uber/kraken/utils/bandwidth"
+	"github.com/uber/kraken/utils/memsize"
+
+	"go.uber.org/zap"
+)
+
+// NamespaceBandwidthConfig defines bandwidth limits shared by all connections
+// for torrents whose namespace matches the Namespace regular expression. These
+// limits apply in addition to the global limits.
+type NamespaceBandwidthConfig struct {
+	Namespace         string `yaml:"namespace" json:"namespace"`
+	EgressBitsPerSec  uint64 `yaml:"egress_bits_per_sec" json:"egress_bits_per_sec"`
+	IngressBitsPerSec uint64 `yaml:"ingress_bits_per_sec" json:"ingress_bits_per_sec"`
+}
+
+// BandwidthLimits defines the global and per-namespace bandwidth limits which
+// are currently in effect. Zero global limits denote bandwidth limiting is
+// disabled.
+type BandwidthLimits struct {
+	EgressBitsPerSec  uint64                     `json:"egress_bits_per_sec"`
+	IngressBitsPerSec uint64                     `json:"ingress_bits_per_sec"`
+	Namespaces        []NamespaceBandwidthConfig `json:"namespaces"`
+}
+
+type namespaceLimiter struct {
+	re      *regexp.Regexp
+	limiter *bandwidth.Limiter
+}
+
+// bandwidthLimiter limits egress and ingress bandwidth across all connections,
+// both globally and per namespace. Limits may be replaced at runtime.
+type bandwidthLimiter struct {
+	tokenSize uint64
+	logger    *zap.SugaredLogger
+
+	mu         sync.RWMutex
+	limits     BandwidthLimits
+	global     *bandwidth.Limiter
+	namespaces []namespaceLimiter
+}
+
+func newBandwidthLimiter(config Config, logger *zap.SugaredLogger) (*bandwidthLimiter, error) {
+	global, err := bandwidth.NewLimiter(config.Bandwidth, bandwidth.WithLogger(logger))
+	if err != nil {
+		return nil, err
+	}
+	tokenSize := config.Bandwidth.TokenSize
+	if tokenSize == 0 {
+		tokenSize = 8 * memsize.Mbit
+	}
+	l := &bandwidthLimiter{
+		tokenSize: tokenSize,
+		logger:    logger,
+		global:    global,
+	}
+	if config.Bandwidth.Enable {
+		l.limits.EgressBitsPerSec = config.Bandwidth.EgressBitsPerSec
+		l.limits.IngressBitsPerSec = config.Bandwidth.IngressBitsPerSec
+	}
+	namespaces, err := l.newNamespaceLimiters(config.NamespaceBandwidth)
+	if err != nil {
+		return nil, err
+	}
+	l.limits.Namespaces = config.NamespaceBandwidth
+	l.namespaces = namespaces
+	return l, nil
+}
+
+func (l *bandwidthLimiter) newLimiter(egress, ingress uint64) (*bandwidth.Limiter, error) {
+	if (egress == 0) != (ingress == 0) {
+		return nil, errors.New("egress and ingress limits must both be zero or non-zero")
+	}
+	for _, bps := range []uint64{egress, ingress} {
+		if bps != 0 && bps < l.tokenSize {
+			return nil, fmt.Errorf(
+				"limit %s/sec is less than token size %s",
+				memsize.BitFormat(bps), memsize.BitFormat(l.tokenSize))
+		}
+	}
+	return bandwidth.NewLimiter(bandwidth.Config{
+		EgressBitsPerSec:  egress,
+		IngressBitsPerSec: ingress,
+		TokenSize:         l.tokenSize,
+		Enable:            egress != 0,
+	}, bandwidth.WithLogger(l.logger))
+}
+
+func (l *bandwidthLimiter) newNamespaceLimiters(
+	configs []NamespaceBandwidthConfig) ([]namespaceLimiter, error) {
+
+	var namespaces []namespaceLimiter
+	for _, c := range configs {
+		re, err := regexp.Compile(c.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace regexp %q: %s", c.Namespace, err)
+		}
+		limiter, err := l.newLimiter(c.EgressBitsPerSec, c.IngressBitsPerSec)
+		if err != nil {
+			return nil, fmt.Errorf("namespace %q: %s", c.Namespace, err)
+		}
+		namespaces = append(namespaces, namespaceLimiter{re, limiter})
+	}
+	return namespaces, nil
+}
+
+// set replaces all limits with limits. Reservations already in progress are
+// unaffected.
+func (l *bandwidthLimiter) set(limits BandwidthLimits) error {
+	global, err := l.newLimiter(limits.EgressBitsPerSec, limits.IngressBitsPerSec)
+	if err != nil {
+		return fmt.Errorf("global: %s", err)
+	}
+	namespaces, err := l.newNamespaceLimiters(limits.Namespaces)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limits = limits
+	l.global = global
+	l.namespaces = namespaces
+	return nil
+}
+
+// get returns the limits currently in effect.
+func (l *bandwidthLimiter) get() BandwidthLimits {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	limits := l.limits
+	limits.Namespaces = append([]NamespaceBandwidthConfig(nil), l.limits.Namespaces...)
+	return limits
+}
+
+// limiters returns the limiters which apply to namespace: the global limiter,
+// followed by the first matching namespace limiter, if any.
+func (l *bandwidthLimiter) limiters(namespace string) []*bandwidth.Limiter {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	limiters := []*bandwidth.Limiter{l.global}
+	for _, n := range l.namespaces {
+		if n.re.MatchString(namespace) {
+			limiters = append(limiters, n.limiter)
+			break
+		}
+	}
+	return limiters
+}
+
+// reserveEgress blocks until egress bandwidth for nbytes is available under
+// namespace.
+func (l *bandwidthLimiter) reserveEgress(namespace string, nbytes int64) error {
+	for _, bl := range l.limiters(namespace) {
+		if err := bl.ReserveEgress(nbytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reserveIngress blocks until ingress bandwidth for nbytes is available under
+// namespace.
+func (l *bandwidthLimiter) reserveIngress(namespace string, nbytes int64) error {
+	for _, bl := range l.limiters(namespace) {
+		if err := bl.ReserveIngress(nbytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"testing"
+
+	"github.com/uber/kraken/utils/bandwidth"
+	"github.com/uber/kraken/utils/memsize"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestBandwidthLimiterNamespaceLimiters(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{
+		Bandwidth: bandwidth.Config{
+			EgressBitsPerSec:  100 * 8 * memsize.Mbit,
+			IngressBitsPerSec: 100 * 8 * memsize.Mbit,
+			Enable:            true,
+		},
+		NamespaceBandwidth: []NamespaceBandwidthConfig{{
+			Namespace:         "^foo/.*",
+			EgressBitsPerSec:  10 * 8 * memsize.Mbit,
+			IngressBitsPerSec: 10 * 8 * memsize.Mbit,
+		}},
+	}
+	l, err := newBandwidthLimiter(config, zap.NewNop().Sugar())
+	require.NoError(err)
+
+	require.Len(l.limiters("foo/bar"), 2)
+	require.Len(l.limiters("bar/foo"), 1)
+
+	require.Equal(BandwidthLimits{
+		EgressBitsPerSec:  100 * 8 * memsize.Mbit,
+		IngressBitsPerSec: 100 * 8 * memsize.Mbit,
+		Namespaces:        config.NamespaceBandwidth,
+	}, l.get())
+
+	require.NoError(l.reserveEgress("foo/bar", int64(memsize.MB)))
+	require.NoError(l.reserveIngress("foo/bar", int64(memsize.MB)))
+}
+
+func TestBandwidthLimiterSet(t *testing.T) {
+	require := require.New(t)
+
+	l, err := newBandwidthLimiter(Config{}, zap.NewNop().Sugar())
+	require.NoError(err)
+
+	require.Equal(BandwidthLimits{}, l.get())
+
+	limits := BandwidthLimits{
+		EgressBitsPerSec:  50 * 8 * memsize.Mbit,
+		IngressBitsPerSec: 60 * 8 * memsize.Mbit,
+		Namespaces: []NamespaceBandwidthConfig{{
+			Namespace:         "bar",
+			EgressBitsPerSec:  5 * 8 * memsize.Mbit,
+			IngressBitsPerSec: 6 * 8 * memsize.Mbit,
+		}},
+	}
+	require.NoError(l.set(limits))
+	require.Equal(limits, l.get())
+
+	limiters := l.limiters("bar")
+	require.Len(limiters, 2)
+	require.Equal(int64(50), limiters[0].EgressLimit())
+	require.Equal(int64(5), limiters[1].EgressLimit())
+}
+
+func TestBandwidthLimiterSetErrors(t *testing.T) {
+	tests := []struct {
+		desc   string
+		limits BandwidthLimits
+	}{
+		{"only egress", BandwidthLimits{EgressBitsPerSec: 8 * memsize.Mbit}},
+		{"below token size", BandwidthLimits{EgressBitsPerSec: 1, IngressBitsPerSec: 1}},
+		{"invalid namespace", BandwidthLimits{
+			Namespaces: []NamespaceBandwidthConfig{{
+				Namespace:         "(",
+				EgressBitsPerSec:  8 * memsize.Mbit,
+				IngressBitsPerSec: 8 * memsize.Mbit,
+			}},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := newBandwidthLimiter(Config{}, zap.NewNop().Sugar())
+			require.NoError(err)
+
+			require.Error(l.set(test.limits))
+
+			// Limits are unchanged on error.
+			require.Equal(BandwidthLimits{}, l.get())
+		})
+	}
+}
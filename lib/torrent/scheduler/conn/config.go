@@ -38,6 +38,27 @@ type Config struct {
 	ReceiverBufferSize int `yaml:"receiver_buffer_size"`
 
 	Bandwidth bandwidth.Config `yaml:"bandwidth"`
+
+	// NamespaceBandwidth defines additional bandwidth limits for torrents under
+	// matching namespaces. The first matching entry applies. Token size is
+	// shared with the global Bandwidth config.
+	NamespaceBandwidth []NamespaceBandwidthConfig `yaml:"namespace_bandwidth"`
+
+	// Encryption configures optional TLS encryption of peer connections.
+	Encryption EncryptionConfig `yaml:"encryption"`
+
+	// CompactBitfields enables sending compact "have all" / "have none"
+	// bitfields when opening connections. Peers always reply compactly to
+	// peers which support it, but openers cannot know whether the remote peer
+	// does, so this should only be enabled once all peers support it.
+	CompactBitfields bool `yaml:"compact_bitfields"`
+
+	// Mux configures multiplexing the connections of many torrents to the same
+	// peer over a single TCP connection.
+	Mux MuxConfig `yaml:"mux"`
+
+	// Protocol configures protocol version negotiation with remote peers.
+	Protocol ProtocolConfig `yaml:"protocol"`
 }
 
 func (c Config) applyDefaults() Config {
@@ -56,5 +77,7 @@ func (c Config) applyDefaults() Config {
 	if c.Bandwidth.IngressBitsPerSec == 0 {
 		c.Bandwidth.IngressBitsPerSec = 300 * 8 * memsize.Mbit
 	}
+	c.Encryption = c.Encryption.applyDefaults()
+	c.Mux = c.Mux.applyDefaults()
 	return c
 }
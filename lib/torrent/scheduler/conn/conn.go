@@ -31,7 +31,6 @@ import (
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/storage"
 	"github.com/uber/kraken/lib/torrent/storage/piecereader"
-	"github.com/uber/kraken/utils/bandwidth"
 	"github.com/uber/kraken/utils/memsize"
 )
 
@@ -49,8 +48,9 @@ type Conn struct {
 	peerID      core.PeerID
 	infoHash    core.InfoHash
 	createdAt   time.Time
+	namespace   string
 	localPeerID core.PeerID
-	bandwidth   *bandwidth.Limiter
+	bandwidth   *bandwidthLimiter
 
 	events Events
 
@@ -67,6 +67,9 @@ type Conn struct {
 	// Marks whether the connection was opened by the remote peer, or the local peer.
 	openedByRemote bool
 
+	// capabilities are advertised by both the local and remote peer.
+	capabilities Capability
+
 	startOnce sync.Once
 
 	sender   chan *Message
@@ -85,7 +88,7 @@ func newConn(
 	stats tally.Scope,
 	clk clock.Clock,
 	networkEvents networkevent.Producer,
-	bandwidth *bandwidth.Limiter,
+	bandwidth *bandwidthLimiter,
 	events Events,
 	nc net.Conn,
 	localPeerID core.PeerID,
@@ -103,6 +106,7 @@ func newConn(
 	c := &Conn{
 		peerID:         remotePeerID,
 		infoHash:       info.InfoHash(),
+		namespace:      info.Namespace(),
 		createdAt:      clk.Now(),
 		localPeerID:    localPeerID,
 		bandwidth:      bandwidth,
@@ -145,6 +149,12 @@ func (c *Conn) InfoHash() core.InfoHash {
 	return c.infoHash
 }
 
+// Supports returns true if both the local and remote peer advertised
+// capability o.
+func (c *Conn) Supports(o Capability) bool {
+	return c.capabilities.Has(o)
+}
+
 // CreatedAt returns the time at which the Conn was created.
 func (c *Conn) CreatedAt() time.Time {
 	return c.createdAt
@@ -195,7 +205,7 @@ func (c *Conn) IsClosed() bool {
 }
 
 func (c *Conn) readPayload(length int32) ([]byte, error) {
-	if err := c.bandwidth.ReserveIngress(int64(length)); err != nil {
+	if err := c.bandwidth.reserveIngress(c.namespace, int64(length)); err != nil {
 		c.log().Errorf("Error reserving ingress bandwidth for piece payload: %s", err)
 		return nil, fmt.Errorf("ingress bandwidth: %s", err)
 	}
@@ -254,7 +264,7 @@ func (c *Conn) readLoop() {
 func (c *Conn) sendPiecePayload(pr storage.PieceReader) error {
 	defer pr.Close()
 
-	if err := c.bandwidth.ReserveEgress(int64(pr.Length())); err != nil {
+	if err := c.bandwidth.reserveEgress(c.namespace, int64(pr.Length())); err != nil {
 		// TODO(codyg): This is bad. Consider alerting here.
 		c.log().Errorf("Error reserving egress bandwidth for piece payload: %s", err)
 		return fmt.Errorf("egress bandwidth: %s", err)
@@ -0,0 +1,186 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/uber/kraken/utils/httputil"
+)
+
+// EncryptionMode defines whether peer connections are encrypted.
+type EncryptionMode string
+
+// Encryption modes.
+const (
+	// EncryptionDisable never encrypts connections. Connections to peers which
+	// require encryption fail.
+	EncryptionDisable EncryptionMode = "disable"
+
+	// EncryptionPrefer encrypts connections to peers which support encryption,
+	// and falls back to plaintext otherwise.
+	EncryptionPrefer EncryptionMode = "prefer"
+
+	// EncryptionRequire only allows encrypted connections.
+	EncryptionRequire EncryptionMode = "require"
+)
+
+// encryptionTLS is sent by the accepting peer in its handshake to confirm
+// that the connection will be upgraded to TLS.
+const encryptionTLS = "tls"
+
+var errEncryptionRequired = errors.New("encryption required but not supported by remote peer")
+
+// EncryptionConfig defines encryption of peer connections. Encryption is
+// negotiated in the handshake: the opening peer offers its mode, and the
+// accepting peer confirms whether the connection is upgraded to TLS. The
+// handshakes themselves are always sent in plaintext.
+type EncryptionConfig struct {
+	Mode EncryptionMode `yaml:"mode"`
+
+	// TLS configures the certificates of the local peer. Server is presented
+	// when accepting connections, and Client when opening connections. Remote
+	// peers are verified against CAs, with Name as the expected server name.
+	TLS httputil.TLSConfig `yaml:"tls"`
+}
+
+func (c EncryptionConfig) applyDefaults() EncryptionConfig {
+	if c.Mode == "" {
+		c.Mode = EncryptionDisable
+	}
+	return c
+}
+
+// encryptor negotiates and applies encryption of peer connections.
+type encryptor struct {
+	mode      EncryptionMode
+	timeout   time.Duration
+	clientTLS *tls.Config
+	serverTLS *tls.Config
+}
+
+func newEncryptor(config EncryptionConfig, timeout time.Duration) (*encryptor, error) {
+	e := &encryptor{mode: config.Mode, timeout: timeout}
+	switch config.Mode {
+	case EncryptionDisable:
+		return e, nil
+	case EncryptionPrefer, EncryptionRequire:
+	default:
+		return nil, fmt.Errorf("invalid encryption mode: %q", config.Mode)
+	}
+	var err error
+	e.serverTLS, err = config.TLS.BuildServer()
+	if err != nil {
+		return nil, fmt.Errorf("build server tls: %s", err)
+	}
+	e.clientTLS, err = config.TLS.BuildClient()
+	if err != nil {
+		return nil, fmt.Errorf("build client tls: %s", err)
+	}
+	if e.serverTLS == nil || e.clientTLS == nil {
+		return nil, errors.New("tls server and client must be enabled for encryption")
+	}
+	return e, nil
+}
+
+// offer returns the encryption offered by the opening peer in its handshake.
+// Empty for disabled, which matches peers which do not support encryption.
+func (e *encryptor) offer() string {
+	if e.mode == EncryptionDisable {
+		return ""
+	}
+	return string(e.mode)
+}
+
+// accept returns whether the accepting peer should upgrade a connection given
+// the remote peer's offer.
+func (e *encryptor) accept(offer string) (bool, error) {
+	switch EncryptionMode(offer) {
+	case "", EncryptionDisable:
+		if e.mode == EncryptionRequire {
+			return false, errEncryptionRequired
+		}
+		return false, nil
+	case EncryptionPrefer:
+		return e.mode != EncryptionDisable, nil
+	case EncryptionRequire:
+		if e.mode == EncryptionDisable {
+			return false, errors.New("remote peer requires encryption but encryption is disabled")
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown encryption offer: %q", offer)
+	}
+}
+
+// confirm returns whether the opening peer should upgrade a connection given
+// the accepting peer's reply.
+func (e *encryptor) confirm(reply string) (bool, error) {
+	switch reply {
+	case "":
+		if e.mode == EncryptionRequire {
+			return false, errEncryptionRequired
+		}
+		return false, nil
+	case encryptionTLS:
+		if e.mode == EncryptionDisable {
+			return false, errors.New("remote peer upgraded to tls without offer")
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown encryption reply: %q", reply)
+	}
+}
+
+// reply returns the encryption sent by the accepting peer in its handshake.
+func (e *encryptor) reply(upgrade bool) string {
+	if upgrade {
+		return encryptionTLS
+	}
+	return ""
+}
+
+// client upgrades nc, opened by the local peer, to TLS.
+func (e *encryptor) client(nc net.Conn) (net.Conn, error) {
+	c := tls.Client(nc, e.clientTLS)
+	if err := e.handshake(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// server upgrades nc, opened by the remote peer, to TLS.
+func (e *encryptor) server(nc net.Conn) (net.Conn, error) {
+	c := tls.Server(nc, e.serverTLS)
+	if err := e.handshake(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (e *encryptor) handshake(c *tls.Conn) error {
+	// NOTE: We do not use the clock interface here because the net package uses
+	// the system clock when evaluating deadlines.
+	if err := c.SetDeadline(time.Now().Add(e.timeout)); err != nil {
+		return fmt.Errorf("set deadline: %s", err)
+	}
+	if err := c.Handshake(); err != nil {
+		return fmt.Errorf("tls handshake: %s", err)
+	}
+	return nil
+}
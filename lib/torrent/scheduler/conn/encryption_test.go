@@ -0,0 +1,220 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+// tlsConfigFixture returns a TLS config where a single self-signed certificate
+// is used as server cert, client cert, and CA.
+func tlsConfigFixture(t *testing.T) (httputil.TLSConfig, func()) {
+	var cleanup testutil.Cleanup
+	defer cleanup.Recover()
+
+	require := require.New(t)
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kraken"},
+		DNSNames:              []string{"kraken"},
+		NotBefore:             time.Now().Add(-5 * time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	require.NoError(err)
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	require.NoError(err)
+
+	certPath, c := testutil.TempFile(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	cleanup.Add(c)
+	keyPath, c := testutil.TempFile(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	cleanup.Add(c)
+
+	var config httputil.TLSConfig
+	config.Name = "kraken"
+	config.CAs = []httputil.Secret{{Path: certPath}}
+	config.Server.Cert.Path = certPath
+	config.Server.Key.Path = keyPath
+	config.Client.Cert.Path = certPath
+	config.Client.Key.Path = keyPath
+
+	return config, cleanup.Run
+}
+
+func TestEncryptorNegotiation(t *testing.T) {
+	tests := []struct {
+		opener    EncryptionMode
+		acceptor  EncryptionMode
+		encrypted bool
+		ok        bool
+	}{
+		{EncryptionDisable, EncryptionDisable, false, true},
+		{EncryptionDisable, EncryptionPrefer, false, true},
+		{EncryptionDisable, EncryptionRequire, false, false},
+		{EncryptionPrefer, EncryptionDisable, false, true},
+		{EncryptionPrefer, EncryptionPrefer, true, true},
+		{EncryptionPrefer, EncryptionRequire, true, true},
+		{EncryptionRequire, EncryptionDisable, false, false},
+		{EncryptionRequire, EncryptionPrefer, true, true},
+		{EncryptionRequire, EncryptionRequire, true, true},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s_%s", test.opener, test.acceptor), func(t *testing.T) {
+			require := require.New(t)
+
+			opener := &encryptor{mode: test.opener}
+			acceptor := &encryptor{mode: test.acceptor}
+
+			upgrade, err := acceptor.accept(opener.offer())
+			if err != nil {
+				require.False(test.ok)
+				return
+			}
+			encrypted, err := opener.confirm(acceptor.reply(upgrade))
+			if err != nil {
+				require.False(test.ok)
+				return
+			}
+			require.True(test.ok)
+			require.Equal(test.encrypted, encrypted)
+			require.Equal(upgrade, encrypted)
+		})
+	}
+}
+
+func TestNewEncryptorErrors(t *testing.T) {
+	_, err := newEncryptor(EncryptionConfig{Mode: "foo"}, time.Second)
+	require.Error(t, err)
+
+	_, err = newEncryptor(EncryptionConfig{Mode: EncryptionRequire}, time.Second)
+	require.Error(t, err)
+}
+
+func TestHandshakerEncryption(t *testing.T) {
+	require := require.New(t)
+
+	tlsConfig, cleanup := tlsConfigFixture(t)
+	defer cleanup()
+
+	config := ConfigFixture()
+	config.Encryption = EncryptionConfig{
+		Mode: EncryptionRequire,
+		TLS:  tlsConfig,
+	}
+
+	h1 := HandshakerFixture(config)
+	l1, err := net.Listen("tcp", "localhost:0")
+	require.NoError(err)
+	defer l1.Close()
+
+	h2 := HandshakerFixture(config)
+
+	info := storage.TorrentInfoFixture(4, 1)
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		nc, err := l1.Accept()
+		require.NoError(err)
+
+		pc, err := h1.Accept(nc)
+		require.NoError(err)
+
+		c, err := h1.Establish(pc, info, make(RemoteBitfields))
+		require.NoError(err)
+		_, ok := c.nc.(*tls.Conn)
+		require.True(ok)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		r, err := h2.Initialize(
+			h1.peerID, l1.Addr().String(), info, make(RemoteBitfields), core.TagFixture())
+		require.NoError(err)
+		_, ok := r.Conn.nc.(*tls.Conn)
+		require.True(ok)
+	}()
+
+	wg.Wait()
+}
+
+func TestHandshakerEncryptionRequiredRejectsPlaintextPeer(t *testing.T) {
+	require := require.New(t)
+
+	tlsConfig, cleanup := tlsConfigFixture(t)
+	defer cleanup()
+
+	config := ConfigFixture()
+	config.Encryption = EncryptionConfig{
+		Mode: EncryptionRequire,
+		TLS:  tlsConfig,
+	}
+
+	h1 := HandshakerFixture(config)
+	l1, err := net.Listen("tcp", "localhost:0")
+	require.NoError(err)
+	defer l1.Close()
+
+	h2 := HandshakerFixture(ConfigFixture())
+
+	info := storage.TorrentInfoFixture(4, 1)
+
+	acceptErr := make(chan error, 1)
+	go func() {
+		nc, err := l1.Accept()
+		if err != nil {
+			acceptErr <- err
+			return
+		}
+		defer nc.Close()
+		_, err = h1.Accept(nc)
+		acceptErr <- err
+	}()
+
+	_, err = h2.Initialize(
+		h1.peerID, l1.Addr().String(), info, make(RemoteBitfields), core.TagFixture())
+	require.Error(err)
+	require.Error(<-acceptErr)
+}
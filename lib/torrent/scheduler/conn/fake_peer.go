@@ -1,7 +1,6 @@
 package conn
 
 import (
-	"fmt"
 	"net"
 	"strconv"
 	"time"
@@ -62,7 +61,7 @@ func (p *FakePeer) PeerID() core.PeerID {
 
 // Addr returns the ip:port of the peer.
 func (p *FakePeer) Addr() string {
-	return fmt.Sprintf("%s:%d", p.ip, p.port)
+	return net.JoinHostPort(p.ip, strconv.Itoa(p.port))
 }
 
 // PeerInfo returns the peers' PeerInfo.
@@ -97,7 +96,7 @@ func (p *FakePeer) handshakeConns() error {
 			bitfield:  bitset.New(req.bitfield.Len()),
 			namespace: req.namespace,
 		}
-		respMsg, err := resp.toP2PMessage()
+		respMsg, err := resp.toP2PMessage(req.compactBitfields)
 		if err != nil {
 			return err
 		}
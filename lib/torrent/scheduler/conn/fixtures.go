@@ -54,15 +54,17 @@ func PipeFixture(
 
 	var err error
 
-	local, err = HandshakerFixture(config).newConn(
-		noopDeadline{nc1}, core.PeerIDFixture(), info, false)
+	h1 := HandshakerFixture(config)
+	local, err = h1.newConn(
+		noopDeadline{nc1}, core.PeerIDFixture(), info, false, h1.capabilities)
 	if err != nil {
 		panic(err)
 	}
 	local.Start()
 
-	remote, err = HandshakerFixture(config).newConn(
-		noopDeadline{nc2}, core.PeerIDFixture(), info, true)
+	h2 := HandshakerFixture(config)
+	remote, err = h2.newConn(
+		noopDeadline{nc2}, core.PeerIDFixture(), info, true, h2.capabilities)
 	if err != nil {
 		panic(err)
 	}
@@ -87,6 +89,7 @@ func HandshakerFixture(config Config) *Handshaker {
 		networkevent.NewTestProducer(),
 		core.PeerIDFixture(),
 		noopEvents{},
+		nil,
 		zap.NewNop().Sugar())
 	if err != nil {
 		panic(err)
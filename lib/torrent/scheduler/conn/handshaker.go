@@ -17,12 +17,12 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sync"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/gen/go/proto/p2p"
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/storage"
-	"github.com/uber/kraken/utils/bandwidth"
 
 	"github.com/andres-erbsen/clock"
 	"github.com/uber-go/tally"
@@ -60,6 +60,47 @@ func (rb RemoteBitfields) unmarshalBinary(rbBytes map[string][]byte) error {
 	return nil
 }
 
+// Gossip exchanges the peers known to have a torrent during handshakes.
+type Gossip interface {
+	// Peers returns the peers to gossip for h.
+	Peers(h core.InfoHash) []*core.PeerInfo
+
+	// Learn records the peers gossiped by a remote peer for h.
+	Learn(h core.InfoHash, peers []*core.PeerInfo)
+}
+
+func gossipPeersToP2P(peers []*core.PeerInfo) []*p2p.GossipPeer {
+	var gps []*p2p.GossipPeer
+	for _, p := range peers {
+		gps = append(gps, &p2p.GossipPeer{
+			PeerID: p.PeerID.String(),
+			Ip:     p.IP,
+			Port:   int32(p.Port),
+			Origin: p.Origin,
+			Zone:   p.Zone,
+			Rack:   p.Rack,
+		})
+	}
+	return gps
+}
+
+// gossipPeersFromP2P converts gossiped peers, skipping invalid peers instead of
+// failing the handshake.
+func gossipPeersFromP2P(gps []*p2p.GossipPeer) []*core.PeerInfo {
+	var peers []*core.PeerInfo
+	for _, gp := range gps {
+		peerID, err := core.NewPeerID(gp.PeerID)
+		if err != nil || gp.Ip == "" || gp.Port <= 0 {
+			continue
+		}
+		p := core.NewPeerInfo(peerID, gp.Ip, int(gp.Port), gp.Origin, false)
+		p.Zone = gp.Zone
+		p.Rack = gp.Rack
+		peers = append(peers, p)
+	}
+	return peers
+}
+
 // handshake contains the same fields as a protobuf bitfield message, but with
 // the fields converted into types used within the scheduler package. As such,
 // in this package "handshake" and "bitfield message" are usually synonymous.
@@ -70,30 +111,73 @@ type handshake struct {
 	bitfield        *bitset.BitSet
 	remoteBitfields RemoteBitfields
 	namespace       string
+	encryption      string
+	gossipPeers     []*core.PeerInfo
+
+	// compactBitfields is whether the sender understands compact bitfields.
+	compactBitfields bool
+
+	version      int
+	capabilities Capability
 }
 
-func (h *handshake) toP2PMessage() (*p2p.Message, error) {
-	b, err := h.bitfield.MarshalBinary()
-	if err != nil {
-		return nil, err
-	}
+// toP2PMessage converts h into a bitfield message. If compact is set, complete
+// and empty bitfields are sent as "have all" / "have none" instead of in full.
+func (h *handshake) toP2PMessage(compact bool) (*p2p.Message, error) {
 	rb, err := h.remoteBitfields.marshalBinary()
 	if err != nil {
 		return nil, err
 	}
+	m := &p2p.BitfieldMessage{
+		PeerID:              h.peerID.String(),
+		Name:                h.digest.Hex(),
+		InfoHash:            h.infoHash.String(),
+		RemoteBitfieldBytes: rb,
+		Namespace:           h.namespace,
+		Encryption:          h.encryption,
+		GossipPeers:         gossipPeersToP2P(h.gossipPeers),
+		CompactBitfields:    true,
+		ProtocolVersion:     int32(h.version),
+		Capabilities:        uint64(h.capabilities),
+	}
+	switch {
+	case compact && h.bitfield.All():
+		m.HaveAll = true
+		m.NumPieces = int32(h.bitfield.Len())
+	case compact && h.bitfield.None():
+		m.HaveNone = true
+		m.NumPieces = int32(h.bitfield.Len())
+	default:
+		b, err := h.bitfield.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		m.BitfieldBytes = b
+	}
 	return &p2p.Message{
-		Type: p2p.Message_BITFIELD,
-		Bitfield: &p2p.BitfieldMessage{
-			PeerID:              h.peerID.String(),
-			Name:                h.digest.Hex(),
-			InfoHash:            h.infoHash.String(),
-			BitfieldBytes:       b,
-			RemoteBitfieldBytes: rb,
-			Namespace:           h.namespace,
-		},
+		Type:     p2p.Message_BITFIELD,
+		Bitfield: m,
 	}, nil
 }
 
+func bitfieldFromP2PMessage(m *p2p.BitfieldMessage) (*bitset.BitSet, error) {
+	if m.HaveAll || m.HaveNone {
+		if m.NumPieces < 0 {
+			return nil, fmt.Errorf("invalid num pieces %d", m.NumPieces)
+		}
+		b := bitset.New(uint(m.NumPieces))
+		if m.HaveAll {
+			b = b.Complement()
+		}
+		return b, nil
+	}
+	b := bitset.New(0)
+	if err := b.UnmarshalBinary(m.BitfieldBytes); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
 func handshakeFromP2PMessage(m *p2p.Message) (*handshake, error) {
 	if m.Type != p2p.Message_BITFIELD {
 		return nil, fmt.Errorf("expected bitfield message, got %s", m.Type)
@@ -110,23 +194,34 @@ func handshakeFromP2PMessage(m *p2p.Message) (*handshake, error) {
 	if err != nil {
 		return nil, fmt.Errorf("name: %s", err)
 	}
-	bitfield := bitset.New(0)
-	if err := bitfield.UnmarshalBinary(m.Bitfield.BitfieldBytes); err != nil {
-		return nil, err
+	bitfield, err := bitfieldFromP2PMessage(m.Bitfield)
+	if err != nil {
+		return nil, fmt.Errorf("bitfield: %s", err)
 	}
 	remoteBitfields := make(RemoteBitfields)
 	if err := remoteBitfields.unmarshalBinary(m.Bitfield.RemoteBitfieldBytes); err != nil {
 		return nil, err
 	}
 
-	return &handshake{
+	hs := &handshake{
 		peerID:          peerID,
 		infoHash:        ih,
 		bitfield:        bitfield,
 		digest:          d,
 		namespace:       m.Bitfield.Namespace,
 		remoteBitfields: remoteBitfields,
-	}, nil
+		encryption:      m.Bitfield.Encryption,
+		gossipPeers:     gossipPeersFromP2P(m.Bitfield.GossipPeers),
+
+		compactBitfields: m.Bitfield.CompactBitfields,
+
+		version:      int(m.Bitfield.ProtocolVersion),
+		capabilities: Capability(m.Bitfield.Capabilities),
+	}
+	if hs.version == 0 {
+		hs.capabilities = legacyCapabilities(hs)
+	}
+	return hs, nil
 }
 
 // PendingConn represents half-opened, pending connection initialized by a
@@ -134,6 +229,7 @@ func handshakeFromP2PMessage(m *p2p.Message) (*handshake, error) {
 type PendingConn struct {
 	handshake *handshake
 	nc        net.Conn
+	encrypt   bool
 }
 
 // PeerID returns the remote peer id.
@@ -166,6 +262,11 @@ func (pc *PendingConn) Namespace() string {
 	return pc.handshake.namespace
 }
 
+// Version returns the protocol version of the remote peer.
+func (pc *PendingConn) Version() int {
+	return pc.handshake.version
+}
+
 // Close closes the connection.
 func (pc *PendingConn) Close() {
 	pc.nc.Close()
@@ -184,13 +285,24 @@ type Handshaker struct {
 	config        Config
 	stats         tally.Scope
 	clk           clock.Clock
-	bandwidth     *bandwidth.Limiter
+	bandwidth     *bandwidthLimiter
+	encryptor     *encryptor
 	networkEvents networkevent.Producer
 	peerID        core.PeerID
 	events        Events
+	gossip        Gossip
+	mux           *muxDialer
+	capabilities  Capability
+
+	// remoteCapabilities remembers the capabilities advertised by remote peers,
+	// such that features which must be chosen before the handshake (i.e.
+	// multiplexing and compact bitfields) are only used with peers which
+	// support them.
+	remoteCapabilities sync.Map // core.PeerID -> Capability
 }
 
-// NewHandshaker creates a new Handshaker.
+// NewHandshaker creates a new Handshaker. gossip may be nil, in which case no
+// peers are gossiped.
 func NewHandshaker(
 	config Config,
 	stats tally.Scope,
@@ -198,6 +310,7 @@ func NewHandshaker(
 	networkEvents networkevent.Producer,
 	peerID core.PeerID,
 	events Events,
+	gossip Gossip,
 	logger *zap.SugaredLogger) (*Handshaker, error) {
 
 	config = config.applyDefaults()
@@ -206,22 +319,59 @@ func NewHandshaker(
 		"module": "conn",
 	})
 
-	bl, err := bandwidth.NewLimiter(config.Bandwidth, bandwidth.WithLogger(logger))
+	bl, err := newBandwidthLimiter(config, logger)
 	if err != nil {
 		return nil, fmt.Errorf("bandwidth: %s", err)
 	}
 
+	e, err := newEncryptor(config.Encryption, config.HandshakeTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: %s", err)
+	}
+
+	var mux *muxDialer
+	if config.Mux.Enabled {
+		mux = newMuxDialer(config.Mux, config.HandshakeTimeout)
+	}
+
+	capabilities, err := localCapabilities(config, gossip != nil)
+	if err != nil {
+		return nil, fmt.Errorf("protocol: %s", err)
+	}
+
 	return &Handshaker{
 		config:        config,
 		stats:         stats,
 		clk:           clk,
 		bandwidth:     bl,
+		encryptor:     e,
 		networkEvents: networkEvents,
 		peerID:        peerID,
 		events:        events,
+		gossip:        gossip,
+		mux:           mux,
+		capabilities:  capabilities,
 	}, nil
 }
 
+// SetBandwidthLimits replaces the bandwidth limits of all connections created by
+// h, including existing connections.
+func (h *Handshaker) SetBandwidthLimits(limits BandwidthLimits) error {
+	return h.bandwidth.set(limits)
+}
+
+// BandwidthLimits returns the bandwidth limits currently in effect.
+func (h *Handshaker) BandwidthLimits() BandwidthLimits {
+	return h.bandwidth.get()
+}
+
+// Listen wraps l to accept the streams of multiplexed connections in addition to
+// plain connections. Connections returned by the wrapped listener should be
+// passed to Accept.
+func (h *Handshaker) Listen(l net.Listener) net.Listener {
+	return newMuxListener(l, h.config.Mux, h.config.HandshakeTimeout)
+}
+
 // Accept upgrades a raw network connection opened by a remote peer into a
 // PendingConn.
 func (h *Handshaker) Accept(nc net.Conn) (*PendingConn, error) {
@@ -229,7 +379,11 @@ func (h *Handshaker) Accept(nc net.Conn) (*PendingConn, error) {
 	if err != nil {
 		return nil, fmt.Errorf("read handshake: %s", err)
 	}
-	return &PendingConn{hs, nc}, nil
+	encrypt, err := h.encryptor.accept(hs.encryption)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: %s", err)
+	}
+	return &PendingConn{hs, nc, encrypt}, nil
 }
 
 // Establish upgrades a PendingConn returned via Accept into a fully
@@ -241,10 +395,21 @@ func (h *Handshaker) Establish(
 
 	// Namespace is one-directional: it is only supplied by the connection opener
 	// and is not reciprocated by the connection acceptor.
-	if err := h.sendHandshake(pc.nc, info, remoteBitfields, ""); err != nil {
+	// Replies are compact whenever the opener supports it.
+	reply := h.encryptor.reply(pc.encrypt)
+	if err := h.sendHandshake(
+		pc.nc, info, remoteBitfields, "", reply, pc.handshake.compactBitfields); err != nil {
 		return nil, fmt.Errorf("send handshake: %s", err)
 	}
-	c, err := h.newConn(pc.nc, pc.handshake.peerID, info, true)
+	nc := pc.nc
+	if pc.encrypt {
+		var err error
+		nc, err = h.encryptor.server(nc)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: %s", err)
+		}
+	}
+	c, err := h.newConn(nc, pc.handshake.peerID, info, true, pc.handshake.capabilities)
 	if err != nil {
 		return nil, fmt.Errorf("new conn: %s", err)
 	}
@@ -261,7 +426,7 @@ func (h *Handshaker) Initialize(
 	remoteBitfields RemoteBitfields,
 	namespace string) (*HandshakeResult, error) {
 
-	nc, err := net.DialTimeout("tcp", addr, h.config.HandshakeTimeout)
+	nc, err := h.dial(peerID, addr)
 	if err != nil {
 		return nil, fmt.Errorf("dial: %s", err)
 	}
@@ -273,11 +438,22 @@ func (h *Handshaker) Initialize(
 	return r, nil
 }
 
+// dial opens a connection to peerID at addr, which is a stream of a shared
+// session if multiplexing is enabled and peerID is known to accept it.
+func (h *Handshaker) dial(peerID core.PeerID, addr string) (net.Conn, error) {
+	if h.mux != nil && h.remoteSupports(peerID, CapabilityMux) {
+		return h.mux.dial(addr)
+	}
+	return net.DialTimeout("tcp", addr, h.config.HandshakeTimeout)
+}
+
 func (h *Handshaker) sendHandshake(
 	nc net.Conn,
 	info *storage.TorrentInfo,
 	remoteBitfields RemoteBitfields,
-	namespace string) error {
+	namespace string,
+	encryption string,
+	compact bool) error {
 
 	hs := &handshake{
 		peerID:          h.peerID,
@@ -286,8 +462,14 @@ func (h *Handshaker) sendHandshake(
 		bitfield:        info.Bitfield(),
 		remoteBitfields: remoteBitfields,
 		namespace:       namespace,
+		encryption:      encryption,
+		version:         ProtocolVersion,
+		capabilities:    h.capabilities,
 	}
-	msg, err := hs.toP2PMessage()
+	if h.gossip != nil {
+		hs.gossipPeers = h.gossip.Peers(info.InfoHash())
+	}
+	msg, err := hs.toP2PMessage(compact)
 	if err != nil {
 		return err
 	}
@@ -303,9 +485,37 @@ func (h *Handshaker) readHandshake(nc net.Conn) (*handshake, error) {
 	if err != nil {
 		return nil, fmt.Errorf("handshake from p2p message: %s", err)
 	}
+	if hs.version < h.config.Protocol.MinVersion {
+		return nil, fmt.Errorf(
+			"protocol version %d below minimum %d", hs.version, h.config.Protocol.MinVersion)
+	}
+	h.remoteCapabilities.Store(hs.peerID, hs.capabilities)
+	if h.gossip != nil {
+		h.learn(hs)
+	}
 	return hs, nil
 }
 
+// remoteSupports returns true if peerID advertised c in its last handshake.
+func (h *Handshaker) remoteSupports(peerID core.PeerID, c Capability) bool {
+	v, ok := h.remoteCapabilities.Load(peerID)
+	if !ok {
+		return false
+	}
+	return v.(Capability).Has(c)
+}
+
+// learn records the peers gossiped in hs, excluding the local peer.
+func (h *Handshaker) learn(hs *handshake) {
+	var peers []*core.PeerInfo
+	for _, p := range hs.gossipPeers {
+		if p.PeerID != h.peerID {
+			peers = append(peers, p)
+		}
+	}
+	h.gossip.Learn(hs.infoHash, peers)
+}
+
 func (h *Handshaker) fullHandshake(
 	nc net.Conn,
 	peerID core.PeerID,
@@ -313,7 +523,9 @@ func (h *Handshaker) fullHandshake(
 	remoteBitfields RemoteBitfields,
 	namespace string) (*HandshakeResult, error) {
 
-	if err := h.sendHandshake(nc, info, remoteBitfields, namespace); err != nil {
+	compact := h.config.CompactBitfields || h.remoteSupports(peerID, CapabilityCompactBitfields)
+	if err := h.sendHandshake(
+		nc, info, remoteBitfields, namespace, h.encryptor.offer(), compact); err != nil {
 		return nil, fmt.Errorf("send handshake: %s", err)
 	}
 	hs, err := h.readHandshake(nc)
@@ -323,7 +535,17 @@ func (h *Handshaker) fullHandshake(
 	if hs.peerID != peerID {
 		return nil, errors.New("unexpected peer id")
 	}
-	c, err := h.newConn(nc, peerID, info, false)
+	encrypt, err := h.encryptor.confirm(hs.encryption)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: %s", err)
+	}
+	if encrypt {
+		nc, err = h.encryptor.client(nc)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: %s", err)
+		}
+	}
+	c, err := h.newConn(nc, peerID, info, false, hs.capabilities)
 	if err != nil {
 		return nil, fmt.Errorf("new conn: %s", err)
 	}
@@ -334,9 +556,10 @@ func (h *Handshaker) newConn(
 	nc net.Conn,
 	peerID core.PeerID,
 	info *storage.TorrentInfo,
-	openedByRemote bool) (*Conn, error) {
+	openedByRemote bool,
+	remoteCapabilities Capability) (*Conn, error) {
 
-	return newConn(
+	c, err := newConn(
 		h.config,
 		h.stats,
 		h.clk,
@@ -349,4 +572,9 @@ func (h *Handshaker) newConn(
 		info,
 		openedByRemote,
 		zap.NewNop().Sugar())
+	if err != nil {
+		return nil, err
+	}
+	c.capabilities = h.capabilities & remoteCapabilities
+	return c, nil
 }
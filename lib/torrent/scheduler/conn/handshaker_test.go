@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"github.com/willf/bitset"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/torrent/storage"
@@ -87,3 +88,127 @@ func TestHandshakerSetsConnFieldsProperly(t *testing.T) {
 
 	wg.Wait()
 }
+
+type fakeGossip struct {
+	sync.Mutex
+	peers map[core.InfoHash][]*core.PeerInfo
+}
+
+func newFakeGossip() *fakeGossip {
+	return &fakeGossip{peers: make(map[core.InfoHash][]*core.PeerInfo)}
+}
+
+func (g *fakeGossip) Peers(h core.InfoHash) []*core.PeerInfo {
+	g.Lock()
+	defer g.Unlock()
+	return g.peers[h]
+}
+
+func (g *fakeGossip) Learn(h core.InfoHash, peers []*core.PeerInfo) {
+	g.Lock()
+	defer g.Unlock()
+	g.peers[h] = append(g.peers[h], peers...)
+}
+
+func TestHandshakerGossipsPeers(t *testing.T) {
+	require := require.New(t)
+
+	config := ConfigFixture()
+
+	h1 := HandshakerFixture(config)
+	g1 := newFakeGossip()
+	h1.gossip = g1
+	l1, err := net.Listen("tcp", "localhost:0")
+	require.NoError(err)
+	defer l1.Close()
+
+	h2 := HandshakerFixture(config)
+	g2 := newFakeGossip()
+	h2.gossip = g2
+
+	info := storage.TorrentInfoFixture(4, 1)
+
+	p1 := core.PeerInfoFixture()
+	p2 := core.PeerInfoFixture()
+	g1.Learn(info.InfoHash(), []*core.PeerInfo{p1})
+
+	// h2 should not learn itself.
+	g2.Learn(info.InfoHash(), []*core.PeerInfo{
+		p2, core.NewPeerInfo(h1.peerID, "localhost", 8080, false, false)})
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		nc, err := l1.Accept()
+		require.NoError(err)
+
+		pc, err := h1.Accept(nc)
+		require.NoError(err)
+
+		_, err = h1.Establish(pc, info, make(RemoteBitfields))
+		require.NoError(err)
+	}()
+
+	_, err = h2.Initialize(
+		h1.peerID, l1.Addr().String(), info, make(RemoteBitfields), core.TagFixture())
+	require.NoError(err)
+
+	wg.Wait()
+
+	var peerIDs []core.PeerID
+	for _, p := range g1.Peers(info.InfoHash()) {
+		peerIDs = append(peerIDs, p.PeerID)
+	}
+	require.Equal([]core.PeerID{p1.PeerID, p2.PeerID}, peerIDs)
+
+	peerIDs = nil
+	for _, p := range g2.Peers(info.InfoHash()) {
+		peerIDs = append(peerIDs, p.PeerID)
+	}
+	require.Contains(peerIDs, p1.PeerID)
+}
+
+func TestHandshakeCompactBitfields(t *testing.T) {
+	tests := []struct {
+		desc     string
+		bitfield *bitset.BitSet
+		compact  bool
+		haveAll  bool
+		haveNone bool
+	}{
+		{"have all", bitsetutil.FromBools(true, true, true), true, true, false},
+		{"have none", bitsetutil.FromBools(false, false, false), true, false, true},
+		{"partial", bitsetutil.FromBools(true, false, true), true, false, false},
+		{"not compact", bitsetutil.FromBools(true, true, true), false, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			info := storage.TorrentInfoFixture(1, 1)
+			hs := &handshake{
+				peerID:          core.PeerIDFixture(),
+				digest:          info.Digest(),
+				infoHash:        info.InfoHash(),
+				bitfield:        test.bitfield,
+				remoteBitfields: make(RemoteBitfields),
+			}
+
+			m, err := hs.toP2PMessage(test.compact)
+			require.NoError(err)
+			require.Equal(test.haveAll, m.Bitfield.HaveAll)
+			require.Equal(test.haveNone, m.Bitfield.HaveNone)
+			require.Equal(test.haveAll || test.haveNone, len(m.Bitfield.BitfieldBytes) == 0)
+			require.True(m.Bitfield.CompactBitfields)
+
+			result, err := handshakeFromP2PMessage(m)
+			require.NoError(err)
+			require.Equal(test.bitfield.Len(), result.bitfield.Len())
+			require.True(test.bitfield.Equal(result.bitfield))
+			require.True(result.compactBitfields)
+		})
+	}
+}
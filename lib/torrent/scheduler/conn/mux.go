@@ -0,0 +1,714 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/utils/memsize"
+)
+
+// Multiplexing allows the connections of many torrents to the same peer to
+// share a single TCP connection, called a session. Each torrent's connection is
+// a stream within the session with its own flow control window, so a torrent
+// with a slow reader cannot stall the other torrents of the session.
+//
+// Streams implement net.Conn, so handshakes, encryption and Conns work the same
+// over streams as over plain TCP connections.
+
+// muxMagic is sent by the dialer to start a session. Interpreted as the length
+// prefix of a plain handshake, it exceeds maxMessageSize, so sessions cannot be
+// confused with plain connections.
+const muxMagic uint32 = 0x4b4d5558 // "KMUX"
+
+const (
+	muxFrameHeaderSize = 9
+	muxMaxFrameSize    = 32 * memsize.KB
+
+	// muxInitialWindow is the window of new streams. Receivers grant the rest
+	// of their configured window once a stream is opened.
+	muxInitialWindow = 256 * memsize.KB
+)
+
+type muxFrameType uint8
+
+const (
+	muxFrameOpen muxFrameType = iota
+	muxFrameData
+	muxFrameWindow
+	muxFrameClose
+)
+
+var (
+	errMuxSessionClosed  = errors.New("mux session closed")
+	errMuxStreamClosed   = errors.New("mux stream closed")
+	errMuxListenerClosed = errors.New("mux listener closed")
+)
+
+// muxTimeoutError is returned when stream deadlines are exceeded.
+type muxTimeoutError struct{}
+
+func (muxTimeoutError) Error() string   { return "mux stream deadline exceeded" }
+func (muxTimeoutError) Timeout() bool   { return true }
+func (muxTimeoutError) Temporary() bool { return true }
+
+// MuxConfig defines multiplexing of connections to the same peer.
+type MuxConfig struct {
+
+	// Enabled enables multiplexing outgoing connections to the same peer over a
+	// single TCP connection. Incoming multiplexed connections are always
+	// accepted, so this should only be enabled once all peers support it.
+	Enabled bool `yaml:"enabled"`
+
+	// StreamWindow is the number of bytes which may be in flight on each
+	// stream before its receiver reads them.
+	StreamWindow uint64 `yaml:"stream_window"`
+}
+
+func (c MuxConfig) applyDefaults() MuxConfig {
+	if c.StreamWindow == 0 {
+		c.StreamWindow = 4 * memsize.MB
+	}
+	if c.StreamWindow < muxInitialWindow {
+		c.StreamWindow = muxInitialWindow
+	}
+	return c
+}
+
+// muxSession multiplexes streams over a single connection. Only the dialer of a
+// session opens streams.
+type muxSession struct {
+	nc     net.Conn
+	r      io.Reader
+	window uint32
+	dialer bool
+
+	// accept delivers streams opened by the remote peer. Returns false if the
+	// stream could not be accepted.
+	accept func(net.Conn) bool
+
+	onClose func(*muxSession)
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex // Protects the following fields:
+	streams map[uint32]*muxStream
+	nextID  uint32
+	err     error
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newMuxSession(
+	nc net.Conn,
+	r io.Reader,
+	window uint64,
+	dialer bool,
+	accept func(net.Conn) bool,
+	onClose func(*muxSession)) *muxSession {
+
+	s := &muxSession{
+		nc:      nc,
+		r:       r,
+		window:  uint32(window),
+		dialer:  dialer,
+		accept:  accept,
+		onClose: onClose,
+		streams: make(map[uint32]*muxStream),
+		nextID:  1,
+		done:    make(chan struct{}),
+	}
+	go s.readLoop()
+	return s
+}
+
+// open opens a new stream to the remote peer.
+func (s *muxSession) open() (*muxStream, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, errMuxSessionClosed
+	}
+	st := newMuxStream(s, s.nextID)
+	s.streams[st.id] = st
+	s.nextID += 2
+	s.mu.Unlock()
+
+	if err := s.writeFrame(muxFrameOpen, st.id, nil); err != nil {
+		return nil, err
+	}
+	if err := s.grant(st.id, s.window-uint32(muxInitialWindow)); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s *muxSession) readLoop() {
+	var header [muxFrameHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(s.r, header[:]); err != nil {
+			s.close(fmt.Errorf("read frame header: %s", err))
+			return
+		}
+		t := muxFrameType(header[0])
+		id := binary.BigEndian.Uint32(header[1:5])
+		length := binary.BigEndian.Uint32(header[5:9])
+		if uint64(length) > muxMaxFrameSize {
+			s.close(fmt.Errorf("frame exceeds max size: %d > %d", length, muxMaxFrameSize))
+			return
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(s.r, payload); err != nil {
+			s.close(fmt.Errorf("read frame payload: %s", err))
+			return
+		}
+		if err := s.handle(t, id, payload); err != nil {
+			s.close(err)
+			return
+		}
+	}
+}
+
+func (s *muxSession) handle(t muxFrameType, id uint32, payload []byte) error {
+	if t == muxFrameOpen {
+		return s.handleOpen(id)
+	}
+	s.mu.Lock()
+	st, ok := s.streams[id]
+	s.mu.Unlock()
+	if !ok {
+		// Stream was already closed locally.
+		return nil
+	}
+	switch t {
+	case muxFrameData:
+		return st.push(payload)
+	case muxFrameWindow:
+		if len(payload) != 4 {
+			return fmt.Errorf("invalid window frame length %d", len(payload))
+		}
+		st.addCredit(binary.BigEndian.Uint32(payload))
+	case muxFrameClose:
+		st.remoteClose()
+	default:
+		return fmt.Errorf("unknown frame type %d", t)
+	}
+	return nil
+}
+
+func (s *muxSession) handleOpen(id uint32) error {
+	if s.dialer {
+		return errors.New("unexpected open frame from acceptor")
+	}
+	s.mu.Lock()
+	if _, ok := s.streams[id]; ok {
+		s.mu.Unlock()
+		return fmt.Errorf("duplicate stream %d", id)
+	}
+	st := newMuxStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	if err := s.grant(id, s.window-uint32(muxInitialWindow)); err != nil {
+		return err
+	}
+	if !s.accept(st) {
+		st.Close()
+	}
+	return nil
+}
+
+func (s *muxSession) grant(id uint32, n uint32) error {
+	if n == 0 {
+		return nil
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], n)
+	return s.writeFrame(muxFrameWindow, id, b[:])
+}
+
+func (s *muxSession) writeFrame(t muxFrameType, id uint32, payload []byte) error {
+	frame := make([]byte, muxFrameHeaderSize+len(payload))
+	frame[0] = byte(t)
+	binary.BigEndian.PutUint32(frame[1:5], id)
+	binary.BigEndian.PutUint32(frame[5:9], uint32(len(payload)))
+	copy(frame[muxFrameHeaderSize:], payload)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	select {
+	case <-s.done:
+		return errMuxSessionClosed
+	default:
+	}
+	if _, err := s.nc.Write(frame); err != nil {
+		s.close(fmt.Errorf("write frame: %s", err))
+		return errMuxSessionClosed
+	}
+	return nil
+}
+
+// removeStream removes a stream which is closed on both sides. Dialers close
+// the session once it has no streams left.
+func (s *muxSession) removeStream(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	idle := s.dialer && len(s.streams) == 0
+	if idle && s.err == nil {
+		// Prevent new streams from being opened on a closing session.
+		s.err = errMuxSessionClosed
+	}
+	s.mu.Unlock()
+
+	if idle {
+		s.close(errMuxSessionClosed)
+	}
+}
+
+func (s *muxSession) close(err error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		if s.err == nil {
+			s.err = err
+		}
+		s.mu.Unlock()
+
+		close(s.done)
+		s.nc.Close()
+		if s.onClose != nil {
+			s.onClose(s)
+		}
+	})
+}
+
+func (s *muxSession) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// muxStream is a net.Conn for a single stream of a muxSession.
+type muxStream struct {
+	session *muxSession
+	id      uint32
+
+	mu            sync.Mutex // Protects the following fields:
+	buf           bytes.Buffer
+	unacked       uint32 // Bytes read but not yet granted back to the sender.
+	sendWindow    uint32
+	localClosed   bool
+	remoteClosed  bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	readNotify  chan struct{}
+	writeNotify chan struct{}
+}
+
+func newMuxStream(s *muxSession, id uint32) *muxStream {
+	return &muxStream{
+		session:     s,
+		id:          id,
+		sendWindow:  uint32(muxInitialWindow),
+		readNotify:  make(chan struct{}, 1),
+		writeNotify: make(chan struct{}, 1),
+	}
+}
+
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+func (st *muxStream) push(payload []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.localClosed {
+		// Data which arrives after closing is discarded.
+		return nil
+	}
+	if uint64(st.buf.Len()+len(payload)) > uint64(st.session.window) {
+		return fmt.Errorf("stream %d exceeded flow control window", st.id)
+	}
+	st.buf.Write(payload)
+	notify(st.readNotify)
+	return nil
+}
+
+func (st *muxStream) addCredit(n uint32) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.sendWindow += n
+	notify(st.writeNotify)
+}
+
+func (st *muxStream) remoteClose() {
+	st.mu.Lock()
+	st.remoteClosed = true
+	remove := st.localClosed
+	st.mu.Unlock()
+
+	notify(st.readNotify)
+	notify(st.writeNotify)
+	if remove {
+		st.session.removeStream(st.id)
+	}
+}
+
+// wait blocks until c is notified, deadline is exceeded, or the session closes.
+func (st *muxStream) wait(c chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return muxTimeoutError{}
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-c:
+		return nil
+	case <-timeout:
+		return muxTimeoutError{}
+	case <-st.session.done:
+		return errMuxSessionClosed
+	}
+}
+
+// Read implements net.Conn.
+func (st *muxStream) Read(b []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		if st.localClosed {
+			st.mu.Unlock()
+			return 0, errMuxStreamClosed
+		}
+		if st.buf.Len() > 0 {
+			n, _ := st.buf.Read(b)
+			st.unacked += uint32(n)
+			var ack uint32
+			if st.unacked >= st.session.window/2 {
+				ack = st.unacked
+				st.unacked = 0
+			}
+			st.mu.Unlock()
+			if err := st.session.grant(st.id, ack); err != nil {
+				return n, err
+			}
+			return n, nil
+		}
+		if st.remoteClosed {
+			st.mu.Unlock()
+			return 0, io.EOF
+		}
+		deadline := st.readDeadline
+		st.mu.Unlock()
+
+		if st.session.closed() {
+			return 0, errMuxSessionClosed
+		}
+		if err := st.wait(st.readNotify, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write implements net.Conn.
+func (st *muxStream) Write(b []byte) (int, error) {
+	var total int
+	for len(b) > 0 {
+		st.mu.Lock()
+		if st.localClosed || st.remoteClosed {
+			st.mu.Unlock()
+			return total, errMuxStreamClosed
+		}
+		if st.sendWindow == 0 {
+			deadline := st.writeDeadline
+			st.mu.Unlock()
+			if err := st.wait(st.writeNotify, deadline); err != nil {
+				return total, err
+			}
+			continue
+		}
+		n := len(b)
+		if uint64(n) > muxMaxFrameSize {
+			n = int(muxMaxFrameSize)
+		}
+		if uint32(n) > st.sendWindow {
+			n = int(st.sendWindow)
+		}
+		st.sendWindow -= uint32(n)
+		st.mu.Unlock()
+
+		if err := st.session.writeFrame(muxFrameData, st.id, b[:n]); err != nil {
+			return total, err
+		}
+		total += n
+		b = b[n:]
+	}
+	return total, nil
+}
+
+// Close implements net.Conn.
+func (st *muxStream) Close() error {
+	st.mu.Lock()
+	if st.localClosed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	remove := st.remoteClosed
+	st.buf.Reset()
+	st.mu.Unlock()
+
+	notify(st.readNotify)
+	notify(st.writeNotify)
+	err := st.session.writeFrame(muxFrameClose, st.id, nil)
+	if remove || err != nil {
+		st.session.removeStream(st.id)
+	}
+	return nil
+}
+
+// LocalAddr implements net.Conn.
+func (st *muxStream) LocalAddr() net.Addr { return st.session.nc.LocalAddr() }
+
+// RemoteAddr implements net.Conn.
+func (st *muxStream) RemoteAddr() net.Addr { return st.session.nc.RemoteAddr() }
+
+// SetDeadline implements net.Conn.
+func (st *muxStream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	st.SetWriteDeadline(t)
+	return nil
+}
+
+// SetReadDeadline implements net.Conn.
+func (st *muxStream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.mu.Unlock()
+	notify(st.readNotify)
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn.
+func (st *muxStream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDeadline = t
+	st.mu.Unlock()
+	notify(st.writeNotify)
+	return nil
+}
+
+// muxDialer opens streams to peers, reusing a single session per address.
+type muxDialer struct {
+	config  MuxConfig
+	timeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*muxSession
+}
+
+func newMuxDialer(config MuxConfig, timeout time.Duration) *muxDialer {
+	return &muxDialer{
+		config:   config,
+		timeout:  timeout,
+		sessions: make(map[string]*muxSession),
+	}
+}
+
+// dial opens a stream to addr, dialing a new session if none exists.
+func (d *muxDialer) dial(addr string) (net.Conn, error) {
+	d.mu.Lock()
+	s, ok := d.sessions[addr]
+	d.mu.Unlock()
+	if ok {
+		if st, err := s.open(); err == nil {
+			return st, nil
+		}
+	}
+
+	nc, err := net.DialTimeout("tcp", addr, d.timeout)
+	if err != nil {
+		return nil, err
+	}
+	var magic [4]byte
+	binary.BigEndian.PutUint32(magic[:], muxMagic)
+	if err := nc.SetWriteDeadline(time.Now().Add(d.timeout)); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("set write deadline: %s", err)
+	}
+	if _, err := nc.Write(magic[:]); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("write magic: %s", err)
+	}
+	if err := nc.SetWriteDeadline(time.Time{}); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("clear write deadline: %s", err)
+	}
+	s = newMuxSession(nc, nc, d.config.StreamWindow, true, nil, func(s *muxSession) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.sessions[addr] == s {
+			delete(d.sessions, addr)
+		}
+	})
+	st, err := s.open()
+	if err != nil {
+		s.close(err)
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if prev, ok := d.sessions[addr]; !ok || prev.closed() {
+		d.sessions[addr] = s
+	}
+	return st, nil
+}
+
+// bufferedConn is a net.Conn whose reads are served from a bufio.Reader which
+// may hold bytes already read from the connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// muxListener accepts both plain connections and the streams of multiplexed
+// sessions.
+type muxListener struct {
+	net.Listener
+	window  uint64
+	timeout time.Duration
+
+	conns chan net.Conn
+
+	mu  sync.Mutex
+	err error
+
+	acceptDone chan struct{} // Closed when the underlying listener fails.
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+func newMuxListener(l net.Listener, config MuxConfig, timeout time.Duration) *muxListener {
+	ml := &muxListener{
+		Listener:   l,
+		window:     config.StreamWindow,
+		timeout:    timeout,
+		conns:      make(chan net.Conn),
+		acceptDone: make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go ml.acceptLoop()
+	return ml
+}
+
+func (ml *muxListener) acceptLoop() {
+	for {
+		nc, err := ml.Listener.Accept()
+		if err != nil {
+			ml.mu.Lock()
+			ml.err = err
+			ml.mu.Unlock()
+			close(ml.acceptDone)
+			return
+		}
+		go ml.serve(nc)
+	}
+}
+
+// serve determines whether nc is a plain connection or a multiplexed session.
+func (ml *muxListener) serve(nc net.Conn) {
+	if err := nc.SetReadDeadline(time.Now().Add(ml.timeout)); err != nil {
+		nc.Close()
+		return
+	}
+	br := bufio.NewReader(nc)
+	b, err := br.Peek(4)
+	if err != nil {
+		nc.Close()
+		return
+	}
+	if err := nc.SetReadDeadline(time.Time{}); err != nil {
+		nc.Close()
+		return
+	}
+	bc := &bufferedConn{nc, br}
+	if binary.BigEndian.Uint32(b) != muxMagic {
+		if !ml.deliver(bc) {
+			nc.Close()
+		}
+		return
+	}
+	br.Discard(4)
+	newMuxSession(bc, br, ml.window, false, ml.deliver, nil)
+}
+
+func (ml *muxListener) deliver(c net.Conn) bool {
+	select {
+	case ml.conns <- c:
+		return true
+	case <-ml.done:
+		return false
+	}
+}
+
+// Accept implements net.Listener.
+func (ml *muxListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ml.conns:
+		return c, nil
+	case <-ml.acceptDone:
+		ml.mu.Lock()
+		defer ml.mu.Unlock()
+		return nil, ml.err
+	case <-ml.done:
+		return nil, errMuxListenerClosed
+	}
+}
+
+// Close implements net.Listener.
+func (ml *muxListener) Close() error {
+	var err error
+	ml.closeOnce.Do(func() {
+		close(ml.done)
+		err = ml.Listener.Close()
+	})
+	return err
+}
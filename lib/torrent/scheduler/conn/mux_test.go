@@ -0,0 +1,159 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func muxListenerFixture(t *testing.T, config MuxConfig) (*muxListener, func()) {
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	ml := newMuxListener(l, config.applyDefaults(), 5*time.Second)
+	return ml, func() { ml.Close() }
+}
+
+func TestMuxDialerReusesSessionPerAddress(t *testing.T) {
+	require := require.New(t)
+
+	ml, cleanup := muxListenerFixture(t, MuxConfig{})
+	defer cleanup()
+
+	d := newMuxDialer(MuxConfig{}.applyDefaults(), 5*time.Second)
+	addr := ml.Addr().String()
+
+	c1, err := d.dial(addr)
+	require.NoError(err)
+	defer c1.Close()
+	c2, err := d.dial(addr)
+	require.NoError(err)
+	defer c2.Close()
+
+	require.Len(d.sessions, 1)
+	require.Equal(c1.(*muxStream).session, c2.(*muxStream).session)
+
+	s1, err := ml.Accept()
+	require.NoError(err)
+	s2, err := ml.Accept()
+	require.NoError(err)
+
+	_, err = c2.Write([]byte("two"))
+	require.NoError(err)
+	_, err = c1.Write([]byte("one"))
+	require.NoError(err)
+
+	b := make([]byte, 3)
+	_, err = io.ReadFull(s1, b)
+	require.NoError(err)
+	require.Equal("one", string(b))
+	_, err = io.ReadFull(s2, b)
+	require.NoError(err)
+	require.Equal("two", string(b))
+}
+
+func TestMuxStreamFlowControlBlocksWriter(t *testing.T) {
+	require := require.New(t)
+
+	ml, cleanup := muxListenerFixture(t, MuxConfig{})
+	defer cleanup()
+
+	d := newMuxDialer(MuxConfig{}.applyDefaults(), 5*time.Second)
+
+	c, err := d.dial(ml.Addr().String())
+	require.NoError(err)
+	defer c.Close()
+
+	s, err := ml.Accept()
+	require.NoError(err)
+
+	// The receiver never reads, so writes beyond its window must block.
+	require.NoError(c.SetWriteDeadline(time.Now().Add(500 * time.Millisecond)))
+	_, err = c.Write(make([]byte, 2*MuxConfig{}.applyDefaults().StreamWindow))
+	require.Error(err)
+	nerr, ok := err.(net.Error)
+	require.True(ok)
+	require.True(nerr.Timeout())
+
+	// A stalled stream does not block other streams of the same session.
+	c2, err := d.dial(ml.Addr().String())
+	require.NoError(err)
+	defer c2.Close()
+	s2, err := ml.Accept()
+	require.NoError(err)
+
+	require.NoError(c2.SetWriteDeadline(time.Time{}))
+	_, err = c2.Write([]byte("ok"))
+	require.NoError(err)
+	b := make([]byte, 2)
+	_, err = io.ReadFull(s2, b)
+	require.NoError(err)
+	require.Equal("ok", string(b))
+
+	s.Close()
+}
+
+func TestMuxStreamCloseEndsRemoteReads(t *testing.T) {
+	require := require.New(t)
+
+	ml, cleanup := muxListenerFixture(t, MuxConfig{})
+	defer cleanup()
+
+	d := newMuxDialer(MuxConfig{}.applyDefaults(), 5*time.Second)
+
+	c, err := d.dial(ml.Addr().String())
+	require.NoError(err)
+
+	s, err := ml.Accept()
+	require.NoError(err)
+
+	_, err = c.Write([]byte("bye"))
+	require.NoError(err)
+	require.NoError(c.Close())
+
+	b, err := ioutil.ReadAll(s)
+	require.NoError(err)
+	require.Equal("bye", string(b))
+}
+
+func TestMuxListenerAcceptsPlainConnections(t *testing.T) {
+	require := require.New(t)
+
+	ml, cleanup := muxListenerFixture(t, MuxConfig{})
+	defer cleanup()
+
+	c, err := net.Dial("tcp", ml.Addr().String())
+	require.NoError(err)
+	defer c.Close()
+
+	_, err = c.Write([]byte("plain"))
+	require.NoError(err)
+
+	s, err := ml.Accept()
+	require.NoError(err)
+	defer s.Close()
+
+	_, ok := s.(*muxStream)
+	require.False(ok)
+
+	b := make([]byte, 5)
+	_, err = io.ReadFull(s, b)
+	require.NoError(err)
+	require.Equal("plain", string(b))
+}
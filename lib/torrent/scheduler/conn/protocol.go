@@ -0,0 +1,127 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ProtocolVersion is the version of the peer protocol spoken by this agent.
+// Peers which predate versioning are considered version 0.
+const ProtocolVersion = 1
+
+// Capability is an optional protocol feature which peers advertise in their
+// handshakes. Features are only used on connections where both peers
+// advertise them, which allows rolling them out across peers of mixed versions.
+type Capability uint64
+
+// Capabilities.
+const (
+	// CapabilityCompactBitfields denotes understanding "have all" / "have
+	// none" bitfields.
+	CapabilityCompactBitfields Capability = 1 << iota
+
+	// CapabilityPex denotes understanding peer exchange messages.
+	CapabilityPex
+
+	// CapabilityEncryption denotes support for encrypting connections.
+	CapabilityEncryption
+
+	// CapabilityMux denotes accepting multiplexed connections.
+	CapabilityMux
+)
+
+var capabilityNames = map[string]Capability{
+	"compact_bitfields": CapabilityCompactBitfields,
+	"pex":               CapabilityPex,
+	"encryption":        CapabilityEncryption,
+	"mux":               CapabilityMux,
+}
+
+// Has returns true if c includes all of o.
+func (c Capability) Has(o Capability) bool {
+	return c&o == o
+}
+
+func (c Capability) String() string {
+	var names []string
+	for name, o := range capabilityNames {
+		if c.Has(o) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// ProtocolConfig defines protocol versioning of peer connections.
+type ProtocolConfig struct {
+
+	// MinVersion is the lowest protocol version of remote peers which
+	// handshakes are accepted from. Defaults to 0, which accepts all peers.
+	MinVersion int `yaml:"min_version"`
+
+	// DisabledCapabilities are not advertised to remote peers, and as such
+	// are never used. Allows holding back features until enough of the fleet
+	// supports them. Valid values are "compact_bitfields", "pex", "encryption",
+	// and "mux".
+	DisabledCapabilities []string `yaml:"disabled_capabilities"`
+}
+
+// disabled returns the capabilities disabled by c.
+func (c ProtocolConfig) disabled() (Capability, error) {
+	var d Capability
+	for _, name := range c.DisabledCapabilities {
+		o, ok := capabilityNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown capability %q", name)
+		}
+		d |= o
+	}
+	return d, nil
+}
+
+// localCapabilities returns the capabilities advertised under config. gossip
+// is whether peer exchange is enabled.
+func localCapabilities(config Config, gossip bool) (Capability, error) {
+	disabled, err := config.Protocol.disabled()
+	if err != nil {
+		return 0, err
+	}
+	// Multiplexed connections are always accepted, regardless of whether
+	// they are dialed.
+	c := CapabilityCompactBitfields | CapabilityMux
+	if gossip {
+		c |= CapabilityPex
+	}
+	if config.Encryption.Mode != EncryptionDisable {
+		c |= CapabilityEncryption
+	}
+	return c &^ disabled, nil
+}
+
+// legacyCapabilities infers the capabilities of peers which predate
+// versioning from the fields of their handshake.
+func legacyCapabilities(hs *handshake) Capability {
+	var c Capability
+	if hs.compactBitfields {
+		c |= CapabilityCompactBitfields
+	}
+	if hs.encryption != "" {
+		c |= CapabilityEncryption
+	}
+	return c
+}
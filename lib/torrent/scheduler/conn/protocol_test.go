@@ -0,0 +1,167 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/utils/bitsetutil"
+)
+
+func TestLocalCapabilities(t *testing.T) {
+	tests := []struct {
+		desc     string
+		config   Config
+		gossip   bool
+		expected Capability
+	}{
+		{
+			"defaults",
+			ConfigFixture(),
+			false,
+			CapabilityCompactBitfields | CapabilityMux,
+		}, {
+			"gossip",
+			ConfigFixture(),
+			true,
+			CapabilityCompactBitfields | CapabilityMux | CapabilityPex,
+		}, {
+			"disabled",
+			Config{Protocol: ProtocolConfig{
+				DisabledCapabilities: []string{"mux", "pex"},
+			}}.applyDefaults(),
+			true,
+			CapabilityCompactBitfields,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			c, err := localCapabilities(test.config, test.gossip)
+			require.NoError(err)
+			require.Equal(test.expected, c)
+		})
+	}
+}
+
+func TestLocalCapabilitiesUnknownCapability(t *testing.T) {
+	config := Config{Protocol: ProtocolConfig{
+		DisabledCapabilities: []string{"teleportation"},
+	}}.applyDefaults()
+
+	_, err := localCapabilities(config, false)
+	require.Error(t, err)
+}
+
+func TestHandshakeLegacyPeerCapabilities(t *testing.T) {
+	require := require.New(t)
+
+	info := storage.TorrentInfoFixture(1, 1)
+	hs := &handshake{
+		peerID:          core.PeerIDFixture(),
+		digest:          info.Digest(),
+		infoHash:        info.InfoHash(),
+		bitfield:        bitsetutil.FromBools(true),
+		remoteBitfields: make(RemoteBitfields),
+	}
+	m, err := hs.toP2PMessage(false)
+	require.NoError(err)
+
+	// Legacy peers send no version nor capabilities.
+	m.Bitfield.ProtocolVersion = 0
+	m.Bitfield.Capabilities = 0
+
+	result, err := handshakeFromP2PMessage(m)
+	require.NoError(err)
+	require.Equal(0, result.version)
+	require.Equal(CapabilityCompactBitfields, result.capabilities)
+}
+
+func handshakePair(
+	t *testing.T, h1, h2 *Handshaker) (accepted *Conn, initialized *Conn, err error) {
+
+	l, lerr := net.Listen("tcp", "localhost:0")
+	require.NoError(t, lerr)
+	defer l.Close()
+
+	info := storage.TorrentInfoFixture(4, 1)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		nc, err := l.Accept()
+		if err != nil {
+			return
+		}
+		pc, err := h1.Accept(nc)
+		if err != nil {
+			nc.Close()
+			return
+		}
+		accepted, _ = h1.Establish(pc, info, make(RemoteBitfields))
+	}()
+
+	r, err := h2.Initialize(h1.peerID, l.Addr().String(), info, make(RemoteBitfields), "")
+	wg.Wait()
+	if err != nil {
+		return accepted, nil, err
+	}
+	return accepted, r.Conn, nil
+}
+
+func TestHandshakeNegotiatesCommonCapabilities(t *testing.T) {
+	require := require.New(t)
+
+	h1 := HandshakerFixture(ConfigFixture())
+	h2 := HandshakerFixture(Config{Protocol: ProtocolConfig{
+		DisabledCapabilities: []string{"mux"},
+	}})
+
+	accepted, initialized, err := handshakePair(t, h1, h2)
+	require.NoError(err)
+	defer accepted.Close()
+	defer initialized.Close()
+
+	for _, c := range []*Conn{accepted, initialized} {
+		require.True(c.Supports(CapabilityCompactBitfields))
+		require.False(c.Supports(CapabilityMux))
+		require.False(c.Supports(CapabilityPex))
+	}
+
+	// Both peers remember what the other advertised.
+	require.False(h1.remoteSupports(h2.peerID, CapabilityMux))
+	require.True(h2.remoteSupports(h1.peerID, CapabilityMux))
+}
+
+func TestHandshakeRejectsPeersBelowMinVersion(t *testing.T) {
+	require := require.New(t)
+
+	h1 := HandshakerFixture(Config{Protocol: ProtocolConfig{
+		MinVersion: ProtocolVersion + 1,
+	}})
+	h2 := HandshakerFixture(ConfigFixture())
+
+	accepted, _, err := handshakePair(t, h1, h2)
+	require.Error(err)
+	require.Nil(accepted)
+}
@@ -32,6 +32,12 @@ type Config struct {
 
 	// BlacklistDuration is the duration a connection will remain blacklisted.
 	BlacklistDuration time.Duration `yaml:"blacklist_duration"`
+
+	// CrossZonePenalty is the fraction, between 0 and 1, of each torrent's
+	// connection capacity which may not be used by peers in other zones. Zero
+	// only prefers same-zone peers, while one never connects to peers in other
+	// zones.
+	CrossZonePenalty float64 `yaml:"cross_zone_penalty"`
 }
 
 func (c Config) applyDefaults() Config {
@@ -47,3 +53,9 @@ func (c Config) applyDefaults() Config {
 	}
 	return c
 }
+
+// maxCrossZoneConnections returns the number of connections, out of max,
+// which may be to peers in other zones.
+func (c Config) maxCrossZoneConnections(max int) int {
+	return int(float64(max) * (1 - c.CrossZonePenalty))
+}
@@ -32,6 +32,7 @@ var (
 	ErrConnClosed              = errors.New("conn is closed")
 	ErrInvalidActiveTransition = errors.New("conn must be pending to transition to active")
 	ErrTooManyMutualConns      = errors.New("conn has too many mutual connections")
+	ErrCrossZoneAtCapacity     = errors.New("torrent is at cross-zone capacity")
 
 	// This should NEVER happen.
 	errUnknownStatus = errors.New("invariant violation: unknown status")
@@ -48,8 +49,9 @@ const (
 )
 
 type entry struct {
-	status status
-	conn   *conn.Conn
+	status    status
+	conn      *conn.Conn
+	crossZone bool
 }
 
 type connKey struct {
@@ -90,6 +92,9 @@ type State struct {
 
 	// All blacklisted conns. These do not count towards conn capacity.
 	blacklist map[connKey]*blacklistEntry
+
+	// Per-torrent overrides of MaxOpenConnectionsPerTorrent.
+	maxConns map[core.InfoHash]int
 }
 
 // New creates a new State.
@@ -110,6 +115,7 @@ func New(
 		logger:      logger,
 		conns:       make(map[core.InfoHash]map[core.PeerID]entry),
 		blacklist:   make(map[connKey]*blacklistEntry),
+		maxConns:    make(map[core.InfoHash]int),
 	}
 }
 
@@ -138,7 +144,25 @@ func (s *State) Saturated(h core.InfoHash) bool {
 			active++
 		}
 	}
-	return active == s.config.MaxOpenConnectionsPerTorrent
+	return active >= s.maxOpenConns(h)
+}
+
+// SetMaxOpenConnections overrides the maximum number of connections for h. Conns
+// already exceeding the new maximum are not closed. A max of zero restores the
+// configured maximum.
+func (s *State) SetMaxOpenConnections(h core.InfoHash, max int) {
+	if max == 0 {
+		delete(s.maxConns, h)
+		return
+	}
+	s.maxConns[h] = max
+}
+
+func (s *State) maxOpenConns(h core.InfoHash) int {
+	if max, ok := s.maxConns[h]; ok {
+		return max
+	}
+	return s.config.MaxOpenConnectionsPerTorrent
 }
 
 // Blacklist blacklists peerID/h for the configured BlacklistDuration.
@@ -168,6 +192,26 @@ func (s *State) Blacklisted(peerID core.PeerID, h core.InfoHash) bool {
 	return ok && e.Blacklisted(s.clk.Now())
 }
 
+// RestoreBlacklist blacklists peerID/h until expiration, e.g. to restore a
+// blacklist persisted before restart. Expired entries are ignored.
+func (s *State) RestoreBlacklist(peerID core.PeerID, h core.InfoHash, expiration time.Time) {
+	if s.config.DisableBlacklist || !expiration.After(s.clk.Now()) {
+		return
+	}
+	s.blacklist[connKey{h, peerID}] = &blacklistEntry{expiration}
+}
+
+// BlacklistExpirations returns when each blacklisted connection for h expires.
+func (s *State) BlacklistExpirations(h core.InfoHash) map[core.PeerID]time.Time {
+	expirations := make(map[core.PeerID]time.Time)
+	for k, e := range s.blacklist {
+		if k.hash == h && e.Blacklisted(s.clk.Now()) {
+			expirations[k.peerID] = e.expiration
+		}
+	}
+	return expirations
+}
+
 // ClearBlacklist un-blacklists all connections for h.
 func (s *State) ClearBlacklist(h core.InfoHash) {
 	for k := range s.blacklist {
@@ -180,7 +224,7 @@ func (s *State) ClearBlacklist(h core.InfoHash) {
 // AddPending sets the connection for peerID/h as pending and reserves capacity
 // for it.
 func (s *State) AddPending(peerID core.PeerID, h core.InfoHash, neighbors []core.PeerID) error {
-	if len(s.conns[h]) == s.config.MaxOpenConnectionsPerTorrent {
+	if len(s.conns[h]) >= s.maxOpenConns(h) {
 		return ErrTorrentAtCapacity
 	}
 	switch s.get(h, peerID).status {
@@ -201,6 +245,22 @@ func (s *State) AddPending(peerID core.PeerID, h core.InfoHash, neighbors []core
 	}
 }
 
+// AddPendingCrossZone is like AddPending, but for a peer in another zone. Only
+// part of each torrent's capacity may be used by cross-zone conns, according to
+// the configured CrossZonePenalty.
+func (s *State) AddPendingCrossZone(
+	peerID core.PeerID, h core.InfoHash, neighbors []core.PeerID) error {
+
+	if s.numCrossZoneConns(h) >= s.config.maxCrossZoneConnections(s.maxOpenConns(h)) {
+		return ErrCrossZoneAtCapacity
+	}
+	if err := s.AddPending(peerID, h, neighbors); err != nil {
+		return err
+	}
+	s.put(h, peerID, entry{status: _pending, crossZone: true})
+	return nil
+}
+
 // DeletePending deletes the pending connection for peerID/h and frees capacity.
 func (s *State) DeletePending(peerID core.PeerID, h core.InfoHash) {
 	if s.get(h, peerID).status != _pending {
@@ -219,7 +279,8 @@ func (s *State) MovePendingToActive(c *conn.Conn) error {
 	if s.get(c.InfoHash(), c.PeerID()).status != _pending {
 		return ErrInvalidActiveTransition
 	}
-	s.put(c.InfoHash(), c.PeerID(), entry{status: _active, conn: c})
+	crossZone := s.get(c.InfoHash(), c.PeerID()).crossZone
+	s.put(c.InfoHash(), c.PeerID(), entry{status: _active, conn: c, crossZone: crossZone})
 
 	s.log("hash", c.InfoHash(), "peer", c.PeerID()).Info("Moved conn from pending to active")
 	s.netevents.Produce(networkevent.AddActiveConnEvent(c.InfoHash(), s.localPeerID, c.PeerID()))
@@ -264,6 +325,16 @@ type BlacklistedConn struct {
 	Remaining time.Duration `json:"remaining"`
 }
 
+func (s *State) numCrossZoneConns(h core.InfoHash) int {
+	var n int
+	for _, e := range s.conns[h] {
+		if e.crossZone {
+			n++
+		}
+	}
+	return n
+}
+
 // BlacklistSnapshot returns a snapshot of all valid blacklist entries.
 func (s *State) BlacklistSnapshot() []BlacklistedConn {
 	var conns []BlacklistedConn
@@ -307,7 +378,7 @@ func (s *State) delete(h core.InfoHash, peerID core.PeerID) {
 }
 
 func (s *State) capacity(h core.InfoHash) int {
-	return s.config.MaxOpenConnectionsPerTorrent - len(s.conns[h])
+	return s.maxOpenConns(h) - len(s.conns[h])
 }
 
 func (s *State) log(args ...interface{}) *zap.SugaredLogger {
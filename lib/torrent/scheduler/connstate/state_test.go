@@ -71,6 +71,31 @@ func TestStateBlacklistSnapshot(t *testing.T) {
 	require.Equal(expected, s.BlacklistSnapshot())
 }
 
+func TestStateRestoreBlacklist(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	s := testState(Config{}, clk)
+
+	h := core.InfoHashFixture()
+	p1 := core.PeerIDFixture()
+	p2 := core.PeerIDFixture()
+
+	s.RestoreBlacklist(p1, h, clk.Now().Add(time.Minute))
+	s.RestoreBlacklist(p2, h, clk.Now().Add(-time.Minute))
+
+	require.True(s.Blacklisted(p1, h))
+	require.False(s.Blacklisted(p2, h))
+	require.Equal(
+		map[core.PeerID]time.Time{p1: clk.Now().Add(time.Minute)},
+		s.BlacklistExpirations(h))
+
+	clk.Add(time.Minute)
+
+	require.False(s.Blacklisted(p1, h))
+	require.Empty(s.BlacklistExpirations(h))
+}
+
 func TestStateClearBlacklist(t *testing.T) {
 	require := require.New(t)
 
@@ -122,6 +147,33 @@ func TestStateAddPendingReservesCapacity(t *testing.T) {
 	require.Equal(ErrTorrentAtCapacity, s.AddPending(core.PeerIDFixture(), h, nil))
 }
 
+func TestStateSetMaxOpenConnections(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{
+		MaxOpenConnectionsPerTorrent: 10,
+	}
+	s := testState(config, clock.New())
+
+	h := core.InfoHashFixture()
+	s.SetMaxOpenConnections(h, 2)
+
+	for i := 0; i < 2; i++ {
+		require.NoError(s.AddPending(core.PeerIDFixture(), h, nil))
+	}
+	require.Equal(ErrTorrentAtCapacity, s.AddPending(core.PeerIDFixture(), h, nil))
+
+	// Other torrents keep the configured maximum.
+	require.NoError(s.AddPending(core.PeerIDFixture(), core.InfoHashFixture(), nil))
+
+	s.SetMaxOpenConnections(h, 0)
+
+	for i := 2; i < config.MaxOpenConnectionsPerTorrent; i++ {
+		require.NoError(s.AddPending(core.PeerIDFixture(), h, nil))
+	}
+	require.Equal(ErrTorrentAtCapacity, s.AddPending(core.PeerIDFixture(), h, nil))
+}
+
 func TestStateDeletePendingAllowsFutureAddPending(t *testing.T) {
 	require := require.New(t)
 
@@ -302,3 +354,25 @@ func TestMaxMutualConns(t *testing.T) {
 	require.Equal(s.AddPending(core.PeerIDFixture(), h, neighbors[:mutualConnLimit+1]), ErrTooManyMutualConns)
 	require.NoError(s.AddPending(core.PeerIDFixture(), h, neighbors[:mutualConnLimit]))
 }
+
+func TestStateAddPendingCrossZoneLimitedByPenalty(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{
+		MaxOpenConnectionsPerTorrent: 4,
+		CrossZonePenalty:             0.5,
+	}
+	s := testState(config, clock.NewMock())
+
+	h := core.InfoHashFixture()
+
+	require.NoError(s.AddPendingCrossZone(core.PeerIDFixture(), h, nil))
+	require.NoError(s.AddPendingCrossZone(core.PeerIDFixture(), h, nil))
+	require.Equal(
+		ErrCrossZoneAtCapacity, s.AddPendingCrossZone(core.PeerIDFixture(), h, nil))
+
+	// Same-zone peers may use the remaining capacity.
+	require.NoError(s.AddPending(core.PeerIDFixture(), h, nil))
+	require.NoError(s.AddPending(core.PeerIDFixture(), h, nil))
+	require.Equal(ErrTorrentAtCapacity, s.AddPending(core.PeerIDFixture(), h, nil))
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package connstate
+
+import (
+	"sort"
+
+	"github.com/uber/kraken/core"
+)
+
+// Locality is the topological distance between the local peer and a remote
+// peer.
+type Locality int
+
+// Localities, from nearest to furthest.
+const (
+	SameRack Locality = iota
+	SameZone
+	CrossZone
+)
+
+// GetLocality returns the locality of p relative to pctx. Peers whose zone is
+// unknown are assumed to be in the same zone.
+func GetLocality(pctx core.PeerContext, p *core.PeerInfo) Locality {
+	if pctx.Zone == "" || p.Zone == "" {
+		return SameZone
+	}
+	if pctx.Zone != p.Zone {
+		return CrossZone
+	}
+	if pctx.Rack != "" && pctx.Rack == p.Rack {
+		return SameRack
+	}
+	return SameZone
+}
+
+// SortByLocality returns a copy of peers sorted from nearest to furthest
+// relative to pctx. The relative order of equally near peers is preserved.
+func SortByLocality(pctx core.PeerContext, peers []*core.PeerInfo) []*core.PeerInfo {
+	c := make([]*core.PeerInfo, len(peers))
+	copy(c, peers)
+	sort.SliceStable(c, func(i, j int) bool {
+		return GetLocality(pctx, c[i]) < GetLocality(pctx, c[j])
+	})
+	return c
+}
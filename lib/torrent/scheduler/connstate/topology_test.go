@@ -0,0 +1,64 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package connstate
+
+import (
+	"testing"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetLocality(t *testing.T) {
+	pctx := core.PeerContext{Zone: "zone1", Rack: "rack1"}
+
+	tests := []struct {
+		desc     string
+		zone     string
+		rack     string
+		expected Locality
+	}{
+		{"same rack", "zone1", "rack1", SameRack},
+		{"same zone", "zone1", "rack2", SameZone},
+		{"unknown rack", "zone1", "", SameZone},
+		{"unknown zone", "", "", SameZone},
+		{"cross zone", "zone2", "rack1", CrossZone},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			p := core.PeerInfoFixture()
+			p.Zone = test.zone
+			p.Rack = test.rack
+			require.Equal(t, test.expected, GetLocality(pctx, p))
+		})
+	}
+}
+
+func TestSortByLocality(t *testing.T) {
+	pctx := core.PeerContext{Zone: "zone1", Rack: "rack1"}
+
+	cross := core.PeerInfoFixture()
+	cross.Zone = "zone2"
+	zone := core.PeerInfoFixture()
+	zone.Zone = "zone1"
+	rack := core.PeerInfoFixture()
+	rack.Zone = "zone1"
+	rack.Rack = "rack1"
+
+	require.Equal(
+		t,
+		[]*core.PeerInfo{rack, zone, cross},
+		SortByLocality(pctx, []*core.PeerInfo{cross, zone, rack}))
+}
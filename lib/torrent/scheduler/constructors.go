@@ -23,31 +23,64 @@ import (
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler/announcequeue"
+	"github.com/uber/kraken/lib/torrent/scheduler/dispatch"
+	"github.com/uber/kraken/lib/torrent/storage"
 	"github.com/uber/kraken/lib/torrent/storage/agentstorage"
 	"github.com/uber/kraken/lib/torrent/storage/originstorage"
 	"github.com/uber/kraken/tracker/announceclient"
 	"github.com/uber/kraken/tracker/metainfoclient"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/uber-go/tally"
 )
 
 // NewAgentScheduler creates and starts a ReloadableScheduler configured for an agent.
+// archiveBackend selects the registered storage archive, configured by
+// archiveConfig, and defaults to agentstorage. trackerToken authenticates
+// requests to trackers if set. webseed may be nil, in which case webseeding is
+// disabled.
 func NewAgentScheduler(
 	config Config,
+	archiveBackend string,
+	archiveConfig interface{},
+	metaInfoConfig metainfoclient.Config,
 	stats tally.Scope,
 	pctx core.PeerContext,
 	cads *store.CADownloadStore,
 	netevents networkevent.Producer,
 	trackers hashring.PassiveRing,
-	tls *tls.Config) (ReloadableScheduler, error) {
+	tls *tls.Config,
+	trackerToken string,
+	webseed dispatch.Webseed) (ReloadableScheduler, error) {
+
+	var metaInfoOpts []metainfoclient.Option
+	var announceOpts []announceclient.Option
+	if trackerToken != "" {
+		metaInfoOpts = append(metaInfoOpts, metainfoclient.WithToken(trackerToken))
+		announceOpts = append(announceOpts, announceclient.WithToken(trackerToken))
+	}
+
+	if archiveBackend == "" {
+		archiveBackend = agentstorage.Name
+	}
+	archive, err := storage.NewArchive(archiveBackend, archiveConfig, storage.ArchiveDeps{
+		Stats:           stats,
+		Clock:           clock.New(),
+		CADownloadStore: cads,
+		MetaInfoClient:  metainfoclient.New(metaInfoConfig, stats, trackers, tls, metaInfoOpts...),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("new torrent archive: %s", err)
+	}
 
 	s, err := newScheduler(
 		config,
-		agentstorage.NewTorrentArchive(stats, cads, metainfoclient.New(trackers, tls)),
+		archive,
 		stats,
 		pctx,
-		announceclient.New(pctx, trackers, tls),
-		netevents)
+		announceclient.New(pctx, trackers, tls, announceOpts...),
+		netevents,
+		withWebseed(webseed))
 	if err != nil {
 		return nil, fmt.Errorf("new scheduler: %s", err)
 	}
@@ -62,17 +95,34 @@ func NewAgentScheduler(
 }
 
 // NewOriginScheduler creates and starts a ReloadableScheduler configured for an origin.
+// archiveBackend selects the registered storage archive, configured by
+// archiveConfig, and defaults to originstorage.
 func NewOriginScheduler(
 	config Config,
+	archiveBackend string,
+	archiveConfig interface{},
 	stats tally.Scope,
 	pctx core.PeerContext,
 	cas *store.CAStore,
 	netevents networkevent.Producer,
 	blobRefresher *blobrefresh.Refresher) (ReloadableScheduler, error) {
 
+	if archiveBackend == "" {
+		archiveBackend = originstorage.Name
+	}
+	archive, err := storage.NewArchive(archiveBackend, archiveConfig, storage.ArchiveDeps{
+		Stats:         stats,
+		Clock:         clock.New(),
+		CAStore:       cas,
+		BlobRefresher: blobRefresher,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("new torrent archive: %s", err)
+	}
+
 	s, err := newScheduler(
 		config,
-		originstorage.NewTorrentArchive(cas, blobRefresher),
+		archive,
 		stats,
 		pctx,
 		announceclient.Disabled(),
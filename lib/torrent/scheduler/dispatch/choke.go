@@ -0,0 +1,324 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dispatch
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+)
+
+// Choke policies, which decide which peers are unchoked (i.e. allowed to
+// download pieces from us).
+const (
+	// NoChokePolicy unchokes every peer.
+	NoChokePolicy = "none"
+
+	// TitForTatChokePolicy unchokes the peers which sent us the most pieces
+	// during the last interval.
+	TitForTatChokePolicy = "tit_for_tat"
+
+	// FastestChokePolicy unchokes the peers which downloaded the most pieces
+	// from us during the last interval.
+	FastestChokePolicy = "fastest"
+
+	// RoundRobinChokePolicy rotates all upload slots through peers each
+	// interval.
+	RoundRobinChokePolicy = "round_robin"
+)
+
+// ChokeConfig defines how upload slots are assigned to peers.
+type ChokeConfig struct {
+	Policy string `yaml:"policy"`
+
+	// UploadSlots is the number of peers per torrent which are unchoked by
+	// the policy.
+	UploadSlots int `yaml:"upload_slots"`
+
+	// OptimisticUploadSlots is the number of additional peers per torrent
+	// which are unchoked in round-robin order, regardless of the policy.
+	// Zero disables optimistic unchoking.
+	OptimisticUploadSlots int `yaml:"optimistic_upload_slots"`
+
+	// GlobalUploadSlots limits the total upload slots across all torrents.
+	// Zero means unlimited.
+	GlobalUploadSlots int `yaml:"global_upload_slots"`
+
+	// Interval is how often unchoked peers are recomputed.
+	Interval time.Duration `yaml:"interval"`
+}
+
+func (c ChokeConfig) applyDefaults() ChokeConfig {
+	if c.Policy == "" {
+		c.Policy = NoChokePolicy
+	}
+	if c.UploadSlots == 0 {
+		c.UploadSlots = 4
+	}
+	if c.Interval == 0 {
+		c.Interval = 10 * time.Second
+	}
+	return c
+}
+
+// UploadSlots is a pool of upload slots shared between the dispatchers of all
+// torrents.
+type UploadSlots struct {
+	mu    sync.Mutex
+	limit int
+	used  int
+}
+
+// NewUploadSlots creates a new UploadSlots with limit slots. A limit of zero
+// means unlimited.
+func NewUploadSlots(limit int) *UploadSlots {
+	return &UploadSlots{limit: limit}
+}
+
+// acquire acquires up to n slots and returns the number acquired.
+func (s *UploadSlots) acquire(n int) int {
+	if s == nil || s.limit == 0 {
+		return n
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if free := s.limit - s.used; n > free {
+		n = free
+	}
+	s.used += n
+	return n
+}
+
+// release returns n slots to the pool.
+func (s *UploadSlots) release(n int) {
+	if s == nil || s.limit == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.used -= n
+}
+
+// chokeCandidate is a peer which may be unchoked, along with its cumulative
+// piece stats.
+type chokeCandidate struct {
+	peerID   core.PeerID
+	received int // Pieces received from the peer.
+	sent     int // Pieces sent to the peer.
+}
+
+// chokePolicy ranks candidates in the order they should be unchoked. received
+// and sent stats are relative to the previous round.
+type chokePolicy interface {
+	rank(candidates []chokeCandidate, round int) []core.PeerID
+}
+
+func newChokePolicy(name string) (chokePolicy, error) {
+	switch name {
+	case TitForTatChokePolicy:
+		return titForTatChokePolicy{}, nil
+	case FastestChokePolicy:
+		return fastestChokePolicy{}, nil
+	case RoundRobinChokePolicy:
+		return roundRobinChokePolicy{}, nil
+	default:
+		return nil, fmt.Errorf("unknown choke policy %q", name)
+	}
+}
+
+type titForTatChokePolicy struct{}
+
+func (titForTatChokePolicy) rank(candidates []chokeCandidate, round int) []core.PeerID {
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.received != b.received {
+			return a.received > b.received
+		}
+		if a.sent != b.sent {
+			return a.sent > b.sent
+		}
+		return a.peerID.LessThan(b.peerID)
+	})
+	return candidatePeerIDs(candidates)
+}
+
+type fastestChokePolicy struct{}
+
+func (fastestChokePolicy) rank(candidates []chokeCandidate, round int) []core.PeerID {
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.sent != b.sent {
+			return a.sent > b.sent
+		}
+		return a.peerID.LessThan(b.peerID)
+	})
+	return candidatePeerIDs(candidates)
+}
+
+type roundRobinChokePolicy struct{}
+
+func (roundRobinChokePolicy) rank(candidates []chokeCandidate, round int) []core.PeerID {
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].peerID.LessThan(candidates[j].peerID)
+	})
+	return rotate(candidatePeerIDs(candidates), round)
+}
+
+func candidatePeerIDs(candidates []chokeCandidate) []core.PeerID {
+	ids := make([]core.PeerID, len(candidates))
+	for i, c := range candidates {
+		ids[i] = c.peerID
+	}
+	return ids
+}
+
+func rotate(ids []core.PeerID, n int) []core.PeerID {
+	if len(ids) == 0 {
+		return ids
+	}
+	n %= len(ids)
+	rotated := make([]core.PeerID, 0, len(ids))
+	rotated = append(rotated, ids[n:]...)
+	return append(rotated, ids[:n]...)
+}
+
+// choker tracks which peers of a torrent are unchoked.
+type choker struct {
+	config ChokeConfig
+	policy chokePolicy
+	slots  *UploadSlots
+	clk    clock.Clock
+
+	mu         sync.Mutex
+	unchoked   map[core.PeerID]bool
+	held       int                            // Slots held from the global pool.
+	prev       map[core.PeerID]chokeCandidate // Stats as of the last round.
+	round      int
+	lastUpdate time.Time
+	closed     bool
+}
+
+func newChoker(config ChokeConfig, slots *UploadSlots, clk clock.Clock) (*choker, error) {
+	policy, err := newChokePolicy(config.Policy)
+	if err != nil {
+		return nil, err
+	}
+	return &choker{
+		config:   config,
+		policy:   policy,
+		slots:    slots,
+		clk:      clk,
+		unchoked: make(map[core.PeerID]bool),
+		prev:     make(map[core.PeerID]chokeCandidate),
+	}, nil
+}
+
+// allowed returns whether peerID is unchoked. Unchoked peers are recomputed
+// from candidates once the interval has elapsed. Between rounds, peerID is
+// unchoked immediately if there is a free slot.
+func (c *choker) allowed(peerID core.PeerID, candidates func() []chokeCandidate) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	if c.lastUpdate.IsZero() || c.clk.Now().Sub(c.lastUpdate) >= c.config.Interval {
+		c.update(candidates())
+	}
+	if c.unchoked[peerID] {
+		return true
+	}
+	if len(c.unchoked) < c.config.UploadSlots+c.config.OptimisticUploadSlots &&
+		c.slots.acquire(1) == 1 {
+
+		c.held++
+		c.unchoked[peerID] = true
+		return true
+	}
+	return false
+}
+
+// update recomputes unchoked peers. Must hold c.mu.
+func (c *choker) update(candidates []chokeCandidate) {
+	c.lastUpdate = c.clk.Now()
+	c.round++
+
+	c.slots.release(c.held)
+	c.held = 0
+	c.unchoked = make(map[core.PeerID]bool)
+
+	prev := c.prev
+	c.prev = make(map[core.PeerID]chokeCandidate, len(candidates))
+	deltas := make([]chokeCandidate, len(candidates))
+	for i, cand := range candidates {
+		c.prev[cand.peerID] = cand
+		p := prev[cand.peerID]
+		deltas[i] = chokeCandidate{
+			peerID:   cand.peerID,
+			received: cand.received - p.received,
+			sent:     cand.sent - p.sent,
+		}
+	}
+
+	want := c.config.UploadSlots + c.config.OptimisticUploadSlots
+	if want > len(deltas) {
+		want = len(deltas)
+	}
+	c.held = c.slots.acquire(want)
+
+	ranked := c.policy.rank(deltas, c.round)
+	regular := c.config.UploadSlots
+	if regular > c.held {
+		regular = c.held
+	}
+	for _, id := range ranked[:regular] {
+		c.unchoked[id] = true
+	}
+	rest := rotate(ranked[regular:], c.round)
+	for _, id := range rest[:c.held-regular] {
+		c.unchoked[id] = true
+	}
+}
+
+// removePeer frees the slot held by peerID, if any.
+func (c *choker) removePeer(peerID core.PeerID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.prev, peerID)
+	if c.unchoked[peerID] {
+		delete(c.unchoked, peerID)
+		c.slots.release(1)
+		c.held--
+	}
+}
+
+// close returns all held slots to the global pool.
+func (c *choker) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	c.slots.release(c.held)
+	c.held = 0
+	c.unchoked = make(map[core.PeerID]bool)
+}
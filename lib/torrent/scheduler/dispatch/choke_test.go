@@ -0,0 +1,234 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dispatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/gen/go/proto/p2p"
+	"github.com/uber/kraken/lib/torrent/scheduler/conn"
+	"github.com/uber/kraken/lib/torrent/storage/agentstorage"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+	"github.com/uber/kraken/utils/bitsetutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func sortedPeerIDFixtures(n int) []core.PeerID {
+	var ids []core.PeerID
+	for i := 0; i < n; i++ {
+		ids = append(ids, core.PeerIDFixture())
+	}
+	return roundRobinChokePolicy{}.rank(candidatesFixture(ids), 0)
+}
+
+func candidatesFixture(ids []core.PeerID) []chokeCandidate {
+	var cs []chokeCandidate
+	for _, id := range ids {
+		cs = append(cs, chokeCandidate{peerID: id})
+	}
+	return cs
+}
+
+func TestTitForTatChokePolicyPrefersPeersWhichSentMost(t *testing.T) {
+	ids := sortedPeerIDFixtures(3)
+	candidates := []chokeCandidate{
+		{peerID: ids[0], received: 1, sent: 5},
+		{peerID: ids[1], received: 3},
+		{peerID: ids[2], received: 1, sent: 7},
+	}
+	require.Equal(
+		t,
+		[]core.PeerID{ids[1], ids[2], ids[0]},
+		titForTatChokePolicy{}.rank(candidates, 0))
+}
+
+func TestFastestChokePolicyPrefersPeersWhichDownloadedMost(t *testing.T) {
+	ids := sortedPeerIDFixtures(3)
+	candidates := []chokeCandidate{
+		{peerID: ids[0], received: 9, sent: 1},
+		{peerID: ids[1], sent: 4},
+		{peerID: ids[2], sent: 2},
+	}
+	require.Equal(
+		t,
+		[]core.PeerID{ids[1], ids[2], ids[0]},
+		fastestChokePolicy{}.rank(candidates, 0))
+}
+
+func TestRoundRobinChokePolicyRotates(t *testing.T) {
+	ids := sortedPeerIDFixtures(3)
+	require.Equal(
+		t,
+		[]core.PeerID{ids[1], ids[2], ids[0]},
+		roundRobinChokePolicy{}.rank(candidatesFixture(ids), 1))
+}
+
+func TestNewChokePolicyUnknown(t *testing.T) {
+	_, err := newChokePolicy("foo")
+	require.Error(t, err)
+}
+
+func TestChokerUnchokesUpToSlots(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	config := ChokeConfig{
+		Policy:                TitForTatChokePolicy,
+		UploadSlots:           1,
+		OptimisticUploadSlots: 1,
+	}.applyDefaults()
+	c, err := newChoker(config, nil, clk)
+	require.NoError(err)
+
+	ids := sortedPeerIDFixtures(4)
+	candidates := []chokeCandidate{
+		{peerID: ids[0]},
+		{peerID: ids[1], received: 5},
+		{peerID: ids[2]},
+		{peerID: ids[3]},
+	}
+	getCandidates := func() []chokeCandidate { return candidates }
+
+	var unchoked int
+	for _, id := range ids {
+		if c.allowed(id, getCandidates) {
+			unchoked++
+		}
+	}
+	require.Equal(2, unchoked)
+	require.True(c.allowed(ids[1], getCandidates))
+
+	// Once a peer is removed, its slot is given to the next requester.
+	for _, id := range ids {
+		if id != ids[1] && c.allowed(id, getCandidates) {
+			c.removePeer(id)
+			break
+		}
+	}
+	var newlyUnchoked int
+	for _, id := range ids {
+		if id != ids[1] && c.allowed(id, getCandidates) {
+			newlyUnchoked++
+		}
+	}
+	require.Equal(1, newlyUnchoked)
+}
+
+func TestChokerRecomputesAfterInterval(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	config := ChokeConfig{
+		Policy:      TitForTatChokePolicy,
+		UploadSlots: 1,
+		Interval:    time.Minute,
+	}
+	c, err := newChoker(config, nil, clk)
+	require.NoError(err)
+
+	ids := sortedPeerIDFixtures(2)
+	candidates := []chokeCandidate{
+		{peerID: ids[0], received: 5},
+		{peerID: ids[1], received: 1},
+	}
+	getCandidates := func() []chokeCandidate { return candidates }
+
+	require.True(c.allowed(ids[0], getCandidates))
+	require.False(c.allowed(ids[1], getCandidates))
+
+	// Ranking uses pieces received during the last interval only.
+	candidates = []chokeCandidate{
+		{peerID: ids[0], received: 6},
+		{peerID: ids[1], received: 4},
+	}
+	require.False(c.allowed(ids[1], getCandidates))
+
+	clk.Add(time.Minute)
+	require.True(c.allowed(ids[1], getCandidates))
+	require.False(c.allowed(ids[0], getCandidates))
+}
+
+func TestChokerGlobalUploadSlots(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	config := ChokeConfig{Policy: RoundRobinChokePolicy, UploadSlots: 2}.applyDefaults()
+	slots := NewUploadSlots(2)
+
+	c1, err := newChoker(config, slots, clk)
+	require.NoError(err)
+	c2, err := newChoker(config, slots, clk)
+	require.NoError(err)
+
+	ids1 := sortedPeerIDFixtures(2)
+	ids2 := sortedPeerIDFixtures(2)
+	candidates1 := func() []chokeCandidate { return candidatesFixture(ids1) }
+	candidates2 := func() []chokeCandidate { return candidatesFixture(ids2) }
+
+	require.True(c1.allowed(ids1[0], candidates1))
+	require.True(c1.allowed(ids1[1], candidates1))
+	require.False(c2.allowed(ids2[0], candidates2))
+
+	c1.close()
+	require.True(c2.allowed(ids2[0], candidates2))
+}
+
+func TestDispatcherRejectsPieceRequestsFromChokedPeers(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{
+		Choke: ChokeConfig{
+			Policy:      RoundRobinChokePolicy,
+			UploadSlots: 1,
+		},
+	}
+
+	blob := core.SizedBlobFixture(2, 1)
+
+	torrent, cleanup := agentstorage.TorrentFixture(blob.MetaInfo)
+	defer cleanup()
+
+	for i := 0; i < 2; i++ {
+		require.NoError(torrent.WritePiece(piecereader.NewBuffer(blob.Content[i:i+1]), i))
+	}
+
+	d := testDispatcher(config, clock.NewMock(), torrent)
+
+	var peers []*peer
+	for i := 0; i < 2; i++ {
+		p, err := d.addPeer(
+			core.PeerIDFixture(), bitsetutil.FromBools(false, false), newMockMessages())
+		require.NoError(err)
+		peers = append(peers, p)
+	}
+
+	var payloads, errs int
+	for _, p := range peers {
+		require.NoError(d.dispatch(p, conn.NewPieceRequestMessage(0, 1)))
+		for _, msg := range p.messages.(*mockMessages).sent {
+			switch msg.Message.Type {
+			case p2p.Message_PIECE_PAYLOAD:
+				payloads++
+			case p2p.Message_ERROR:
+				errs++
+			}
+		}
+	}
+	require.Equal(1, payloads)
+	require.Equal(1, errs)
+}
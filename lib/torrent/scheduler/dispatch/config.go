@@ -14,7 +14,9 @@
 package dispatch
 
 import (
+	"fmt"
 	"math"
+	"regexp"
 	"time"
 
 	"github.com/uber/kraken/lib/torrent/scheduler/dispatch/piecerequest"
@@ -37,6 +39,10 @@ type Config struct {
 	// from a peer.
 	PieceRequestPolicy string `yaml:"piece_request_policy"`
 
+	// NamespacePieceRequestPolicies overrides PieceRequestPolicy for torrents
+	// whose namespace matches. The first matching entry is used.
+	NamespacePieceRequestPolicies []NamespacePieceRequestPolicy `yaml:"namespace_piece_request_policies"`
+
 	// PipelineLimit limits the total number of requests can be sent to a peer
 	// at the same time.
 	PipelineLimit int `yaml:"pipeline_limit"`
@@ -46,7 +52,32 @@ type Config struct {
 	// requests to multiple peers.
 	EndgameThreshold int `yaml:"endgame_threshold"`
 
+	// EndgamePercent is the percentage of pieces which must be complete before
+	// the torrent enters endgame, regardless of EndgameThreshold. Once a piece
+	// arrives in endgame, duplicate requests for it sent to other peers are
+	// cancelled.
+	EndgamePercent float64 `yaml:"endgame_percent"`
+
 	DisableEndgame bool `yaml:"disable_endgame"`
+
+	// Superseed configures superseeding of complete torrents.
+	Superseed SuperseedConfig `yaml:"superseed"`
+
+	// Choke configures how upload slots are assigned to peers.
+	Choke ChokeConfig `yaml:"choke"`
+
+	// Webseed configures fetching pieces from origins over HTTP.
+	Webseed WebseedConfig `yaml:"webseed"`
+
+	// SLO configures falling back to origins when torrents download too slowly.
+	SLO SLOConfig `yaml:"slo"`
+}
+
+// NamespacePieceRequestPolicy selects a piece request policy for namespaces
+// matching a regular expression.
+type NamespacePieceRequestPolicy struct {
+	Namespace string `yaml:"namespace"`
+	Policy    string `yaml:"policy"`
 }
 
 func (c Config) applyDefaults() Config {
@@ -65,6 +96,13 @@ func (c Config) applyDefaults() Config {
 	if c.EndgameThreshold == 0 {
 		c.EndgameThreshold = c.PipelineLimit
 	}
+	if c.EndgamePercent == 0 {
+		c.EndgamePercent = 95
+	}
+	c.Superseed = c.Superseed.applyDefaults()
+	c.Choke = c.Choke.applyDefaults()
+	c.Webseed = c.Webseed.applyDefaults()
+	c.SLO = c.SLO.applyDefaults()
 	return c
 }
 
@@ -73,3 +111,18 @@ func (c Config) calcPieceRequestTimeout(maxPieceLength int64) time.Duration {
 	d := time.Duration(math.Ceil(n))
 	return timeutil.MaxDuration(d, c.PieceRequestMinTimeout)
 }
+
+// pieceRequestPolicy returns the piece request policy for torrents under
+// namespace.
+func (c Config) pieceRequestPolicy(namespace string) (string, error) {
+	for _, p := range c.NamespacePieceRequestPolicies {
+		re, err := regexp.Compile(p.Namespace)
+		if err != nil {
+			return "", fmt.Errorf("invalid namespace regexp %q: %s", p.Namespace, err)
+		}
+		if re.MatchString(namespace) {
+			return p.Policy, nil
+		}
+	}
+	return c.PieceRequestPolicy, nil
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dispatch
+
+import (
+	"testing"
+
+	"github.com/uber/kraken/lib/torrent/scheduler/dispatch/piecerequest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigPieceRequestPolicyByNamespace(t *testing.T) {
+	config := Config{
+		NamespacePieceRequestPolicies: []NamespacePieceRequestPolicy{{
+			Namespace: "^stream/.*",
+			Policy:    piecerequest.SequentialPolicy,
+		}, {
+			Namespace: ".*",
+			Policy:    piecerequest.RandomFirstPiecePolicy,
+		}},
+	}.applyDefaults()
+
+	for _, test := range []struct {
+		namespace string
+		expected  string
+	}{
+		{"stream/video", piecerequest.SequentialPolicy},
+		{"other", piecerequest.RandomFirstPiecePolicy},
+	} {
+		t.Run(test.namespace, func(t *testing.T) {
+			policy, err := config.pieceRequestPolicy(test.namespace)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, policy)
+		})
+	}
+}
+
+func TestConfigPieceRequestPolicyDefault(t *testing.T) {
+	policy, err := Config{}.applyDefaults().pieceRequestPolicy("foo")
+	require.NoError(t, err)
+	require.Equal(t, piecerequest.DefaultPolicy, policy)
+}
+
+func TestConfigPieceRequestPolicyInvalidNamespace(t *testing.T) {
+	config := Config{
+		NamespacePieceRequestPolicies: []NamespacePieceRequestPolicy{{
+			Namespace: "(",
+			Policy:    piecerequest.SequentialPolicy,
+		}},
+	}
+	_, err := config.pieceRequestPolicy("foo")
+	require.Error(t, err)
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler/conn"
 	"github.com/uber/kraken/lib/torrent/scheduler/dispatch/piecerequest"
+	"github.com/uber/kraken/lib/torrent/scheduler/reputation"
 	"github.com/uber/kraken/lib/torrent/scheduler/torrentlog"
 	"github.com/uber/kraken/lib/torrent/storage"
 	"github.com/uber/kraken/utils/syncutil"
@@ -31,6 +32,7 @@ import (
 	"github.com/andres-erbsen/clock"
 	"github.com/uber-go/tally"
 	"github.com/willf/bitset"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 	"golang.org/x/sync/syncmap"
 )
@@ -39,6 +41,7 @@ var (
 	errPeerAlreadyDispatched   = errors.New("peer is already dispatched for the torrent")
 	errPieceOutOfBounds        = errors.New("piece index out of bounds")
 	errChunkNotSupported       = errors.New("reading / writing chunk of piece not supported")
+	errPeerChoked              = errors.New("peer is choked")
 	errRepeatedBitfieldMessage = errors.New("received repeated bitfield message")
 )
 
@@ -46,6 +49,7 @@ var (
 type Events interface {
 	DispatcherComplete(*Dispatcher)
 	PeerRemoved(core.PeerID, core.InfoHash)
+	PeersExchanged(core.InfoHash, []*core.PeerInfo)
 }
 
 // Messages defines a subset of conn.Conn methods which Dispatcher requires to
@@ -64,6 +68,7 @@ type Dispatcher struct {
 	stats                 tally.Scope
 	clk                   clock.Clock
 	createdAt             time.Time
+	initialBytes          int64
 	localPeerID           core.PeerID
 	torrent               *torrentAccessWatcher
 	peers                 syncmap.Map // core.PeerID -> *peer
@@ -72,6 +77,14 @@ type Dispatcher struct {
 	netevents             networkevent.Producer
 	pieceRequestTimeout   time.Duration
 	pieceRequestManager   *piecerequest.Manager
+	superseeder           *superseeder        // Nil if superseeding is disabled.
+	choker                *choker             // Nil if every peer is unchoked.
+	webseeder             *webseeder          // Nil if webseeding is disabled.
+	slo                   *sloMonitor         // Nil if SLOs are disabled.
+	fallback              *atomic.Bool        // Set once the torrent falls back to origins.
+	reputation            *reputation.Tracker // Nil if reputation is disabled.
+	paused                *atomic.Bool
+	bytesUploaded         *atomic.Int64
 	pendingPiecesDoneOnce sync.Once
 	pendingPiecesDone     chan struct{}
 	completeOnce          sync.Once
@@ -89,10 +102,15 @@ func New(
 	events Events,
 	peerID core.PeerID,
 	t storage.Torrent,
+	slots *UploadSlots,
+	writes *storage.WriteQueue,
+	rep *reputation.Tracker,
+	webseed Webseed,
 	logger *zap.SugaredLogger,
 	tlog *torrentlog.Logger) (*Dispatcher, error) {
 
-	d, err := newDispatcher(config, stats, clk, netevents, events, peerID, t, logger, tlog)
+	d, err := newDispatcher(
+		config, stats, clk, netevents, events, peerID, t, slots, writes, rep, webseed, logger, tlog)
 	if err != nil {
 		return nil, err
 	}
@@ -100,6 +118,11 @@ func New(
 	// Exits when d.pendingPiecesDone is closed.
 	go d.watchPendingPieceRequests()
 
+	if d.webseeder != nil {
+		// Exits when d.pendingPiecesDone is closed.
+		go d.watchWebseed()
+	}
+
 	if t.Complete() {
 		d.complete()
 	}
@@ -116,6 +139,10 @@ func newDispatcher(
 	events Events,
 	peerID core.PeerID,
 	t storage.Torrent,
+	slots *UploadSlots,
+	writes *storage.WriteQueue,
+	rep *reputation.Tracker,
+	webseed Webseed,
 	logger *zap.SugaredLogger,
 	tlog *torrentlog.Logger) (*Dispatcher, error) {
 
@@ -125,24 +152,69 @@ func newDispatcher(
 		"module": "dispatch",
 	})
 
+	policy, err := config.pieceRequestPolicy(t.Stat().Namespace())
+	if err != nil {
+		return nil, fmt.Errorf("piece request policy: %s", err)
+	}
+
 	pieceRequestTimeout := config.calcPieceRequestTimeout(t.MaxPieceLength())
 	pieceRequestManager, err := piecerequest.NewManager(
-		clk, pieceRequestTimeout, config.PieceRequestPolicy, config.PipelineLimit)
+		clk, pieceRequestTimeout, policy, config.PipelineLimit)
 	if err != nil {
 		return nil, fmt.Errorf("piece request manager: %s", err)
 	}
+	if writes != nil {
+		// Throttles piece requests while writes are backed up.
+		pieceRequestManager.SetPipelineThrottle(writes.Throttle)
+	}
+	if rep != nil {
+		// Sends fewer requests to peers with poor reputation.
+		pieceRequestManager.SetPeerPipelineLimit(rep.Limit)
+	}
+
+	var ss *superseeder
+	if config.Superseed.Enabled {
+		ss = newSuperseeder(config.Superseed, t.NumPieces())
+	}
+
+	var ch *choker
+	if config.Choke.Policy != NoChokePolicy {
+		ch, err = newChoker(config.Choke, slots, clk)
+		if err != nil {
+			return nil, fmt.Errorf("choker: %s", err)
+		}
+	}
+
+	var ws *webseeder
+	if (config.Webseed.Enabled || config.SLO.Enabled) && webseed != nil {
+		ws = newWebseeder(config.Webseed, webseed, t.Stat().Namespace())
+	}
+
+	var slo *sloMonitor
+	if config.SLO.Enabled && ws != nil {
+		slo = newSLOMonitor(config.SLO, clk.Now(), t.Stat().BytesDownloaded())
+	}
 
 	return &Dispatcher{
 		config:              config,
 		stats:               stats,
 		clk:                 clk,
 		createdAt:           clk.Now(),
+		initialBytes:        t.Stat().BytesDownloaded(),
 		localPeerID:         peerID,
 		torrent:             newTorrentAccessWatcher(t, clk),
 		numPeersByPiece:     syncutil.NewCounters(t.NumPieces()),
 		netevents:           netevents,
 		pieceRequestTimeout: pieceRequestTimeout,
 		pieceRequestManager: pieceRequestManager,
+		superseeder:         ss,
+		choker:              ch,
+		webseeder:           ws,
+		slo:                 slo,
+		fallback:            atomic.NewBool(false),
+		reputation:          rep,
+		paused:              atomic.NewBool(false),
+		bytesUploaded:       atomic.NewInt64(0),
 		pendingPiecesDone:   make(chan struct{}),
 		events:              events,
 		logger:              logger,
@@ -165,9 +237,16 @@ func (d *Dispatcher) Length() int64 {
 	return d.torrent.Length()
 }
 
-// Stat returns d's TorrentInfo.
+// Stat returns d's TorrentInfo, including the average download rate since d
+// was created.
 func (d *Dispatcher) Stat() *storage.TorrentInfo {
-	return d.torrent.Stat()
+	info := d.torrent.Stat()
+	elapsed := d.clk.Now().Sub(d.createdAt)
+	if elapsed <= 0 {
+		return info
+	}
+	return info.WithDownloadRate(
+		float64(info.BytesDownloaded()-d.initialBytes) / elapsed.Seconds())
 }
 
 // Complete returns true if d's torrent is complete.
@@ -175,6 +254,27 @@ func (d *Dispatcher) Complete() bool {
 	return d.torrent.Complete()
 }
 
+// SetPaused sets whether d's torrent is paused. Paused dispatchers do not
+// fetch pieces from webseeds.
+func (d *Dispatcher) SetPaused(paused bool) {
+	d.paused.Store(paused)
+}
+
+// SetPipelineLimit changes the maximum number of pending piece requests per
+// peer, e.g. to throttle low priority torrents. A limit of zero restores the
+// configured limit.
+func (d *Dispatcher) SetPipelineLimit(limit int) {
+	if limit == 0 {
+		limit = d.config.PipelineLimit
+	}
+	d.pieceRequestManager.SetPipelineLimit(limit)
+}
+
+// BytesUploaded returns the number of piece bytes d has sent to peers.
+func (d *Dispatcher) BytesUploaded() int64 {
+	return d.bytesUploaded.Load()
+}
+
 // CreatedAt returns when d was created.
 func (d *Dispatcher) CreatedAt() time.Time {
 	return d.createdAt
@@ -238,6 +338,9 @@ func (d *Dispatcher) AddPeer(
 	if err != nil {
 		return err
 	}
+	if d.superseeding() {
+		go d.revealPieces(p)
+	}
 	go d.maybeRequestMorePieces(p)
 	go d.feed(p)
 	return nil
@@ -267,6 +370,12 @@ func (d *Dispatcher) addPeer(
 func (d *Dispatcher) removePeer(p *peer) error {
 	d.peers.Delete(p.id)
 	d.pieceRequestManager.ClearPeer(p.id)
+	if d.superseeder != nil {
+		d.superseeder.removePeer(p.id)
+	}
+	if d.choker != nil {
+		d.choker.removePeer(p.id)
+	}
 
 	for _, i := range p.bitfield.GetAllSet() {
 		d.numPeersByPiece.Decrement(int(i))
@@ -280,6 +389,10 @@ func (d *Dispatcher) TearDown() {
 		close(d.pendingPiecesDone)
 	})
 
+	if d.choker != nil {
+		d.choker.close()
+	}
+
 	d.peers.Range(func(k, v interface{}) bool {
 		p := v.(*peer)
 		d.log("peer", p).Info("Dispatcher teardown closing connection")
@@ -358,8 +471,12 @@ func (d *Dispatcher) endgame() bool {
 	if d.config.DisableEndgame {
 		return false
 	}
-	remaining := d.torrent.NumPieces() - int(d.torrent.Bitfield().Count())
-	return remaining <= d.config.EndgameThreshold
+	total := d.torrent.NumPieces()
+	remaining := total - int(d.torrent.Bitfield().Count())
+	if remaining <= d.config.EndgameThreshold {
+		return true
+	}
+	return float64(total-remaining) >= float64(total)*d.config.EndgamePercent/100
 }
 
 func (d *Dispatcher) maybeRequestMorePieces(p *peer) (bool, error) {
@@ -369,6 +486,10 @@ func (d *Dispatcher) maybeRequestMorePieces(p *peer) (bool, error) {
 }
 
 func (d *Dispatcher) maybeSendPieceRequests(p *peer, candidates *bitset.BitSet) (bool, error) {
+	if d.fallback.Load() {
+		// Torrent is being completed from origins.
+		return false, nil
+	}
 	pieces, err := d.pieceRequestManager.ReservePieces(p.id, candidates, d.numPeersByPiece, d.endgame())
 	if err != nil {
 		return false, err
@@ -463,6 +584,8 @@ func (d *Dispatcher) dispatch(p *peer, msg *conn.Message) error {
 		d.handleBitfield(p, msg.Message.Bitfield)
 	case p2p.Message_COMPLETE:
 		d.handleComplete(p)
+	case p2p.Message_PEX:
+		d.handlePex(p, msg.Message.Pex)
 	default:
 		return fmt.Errorf("unknown message type: %d", msg.Message.Type)
 	}
@@ -474,6 +597,10 @@ func (d *Dispatcher) handleError(p *peer, msg *p2p.ErrorMessage) {
 	case p2p.ErrorMessage_PIECE_REQUEST_FAILED:
 		d.log().Errorf("Piece request failed: %s", msg.Error)
 		d.pieceRequestManager.MarkInvalid(p.id, int(msg.Index))
+		if d.reputation != nil && msg.Error != errPeerChoked.Error() {
+			// Choked peers are healthy, they just have no slot for us.
+			d.reputation.RequestFailed(p.id)
+		}
 	}
 }
 
@@ -503,6 +630,12 @@ func (d *Dispatcher) handlePieceRequest(p *peer, msg *p2p.PieceRequestMessage) {
 		return
 	}
 
+	if d.choker != nil && !d.choker.allowed(p.id, d.chokeCandidates) {
+		d.stats.Counter("choked_piece_requests").Inc(1)
+		p.messages.Send(conn.NewErrorMessage(i, p2p.ErrorMessage_PIECE_REQUEST_FAILED, errPeerChoked))
+		return
+	}
+
 	payload, err := d.torrent.GetPieceReader(i)
 	if err != nil {
 		d.log("peer", p, "piece", i).Errorf("Error getting reader for requested piece: %s", err)
@@ -516,9 +649,33 @@ func (d *Dispatcher) handlePieceRequest(p *peer, msg *p2p.PieceRequestMessage) {
 
 	p.touchLastPieceSent()
 	p.pstats.incrementPiecesSent()
+	d.bytesUploaded.Add(d.torrent.PieceLength(i))
 
 	// Assume that the peer successfully received the piece.
 	p.bitfield.Set(uint(i), true)
+
+	if d.superseeding() {
+		d.superseeder.served(p.id, i)
+		d.revealPieces(p)
+	}
+}
+
+// chokeCandidates returns the peers which may be unchoked, i.e. peers which
+// do not have every piece.
+func (d *Dispatcher) chokeCandidates() []chokeCandidate {
+	var candidates []chokeCandidate
+	d.peers.Range(func(k, v interface{}) bool {
+		p := v.(*peer)
+		if !p.bitfield.Complete() {
+			candidates = append(candidates, chokeCandidate{
+				peerID:   p.id,
+				received: p.pstats.getGoodPiecesReceived(),
+				sent:     p.pstats.getPiecesSent(),
+			})
+		}
+		return true
+	})
+	return candidates
 }
 
 func (d *Dispatcher) handlePiecePayload(
@@ -533,10 +690,15 @@ func (d *Dispatcher) handlePiecePayload(
 		return
 	}
 
+	elapsed, _ := d.pieceRequestManager.Elapsed(p.id, i)
+
 	if err := d.torrent.WritePiece(payload, i); err != nil {
 		if err != storage.ErrPieceComplete {
 			d.log("peer", p, "piece", i).Errorf("Error writing piece payload: %s", err)
 			d.pieceRequestManager.MarkInvalid(p.id, i)
+			if d.reputation != nil {
+				d.reputation.PieceCorrupt(p.id)
+			}
 		} else {
 			p.pstats.incrementDuplicatePiecesReceived()
 		}
@@ -548,11 +710,14 @@ func (d *Dispatcher) handlePiecePayload(
 
 	p.pstats.incrementGoodPiecesReceived()
 	p.touchLastGoodPieceReceived()
+	if d.reputation != nil {
+		d.reputation.PieceReceived(p.id, int64(payload.Length()), elapsed)
+	}
 	if d.torrent.Complete() {
 		d.complete()
 	}
 
-	d.pieceRequestManager.Clear(i)
+	d.cancelDuplicateRequests(p.id, i, d.pieceRequestManager.MarkComplete(i))
 
 	d.maybeRequestMorePieces(p)
 
@@ -568,6 +733,25 @@ func (d *Dispatcher) handlePiecePayload(
 	})
 }
 
+// cancelDuplicateRequests cancels requests for piece i which were sent to peers
+// other than winner, which won the race for i. Occurs in endgame, where pieces
+// may be requested from multiple peers, or when i was fetched from a webseed.
+func (d *Dispatcher) cancelDuplicateRequests(winner core.PeerID, i int, pending []core.PeerID) {
+	for _, peerID := range pending {
+		if peerID == winner {
+			continue
+		}
+		v, ok := d.peers.Load(peerID)
+		if !ok {
+			continue
+		}
+		if err := v.(*peer).messages.Send(conn.NewCancelPieceMessage(i)); err != nil {
+			continue
+		}
+		d.stats.Counter("endgame_cancelled_requests").Inc(1)
+	}
+}
+
 func (d *Dispatcher) handleCancelPiece(p *peer, msg *p2p.CancelPieceMessage) {
 	// No-op: cancelling not supported because all received messages are synchronized,
 	// therefore if we receive a cancel it is already too late -- we've already read
@@ -588,6 +772,15 @@ func (d *Dispatcher) handleComplete(p *peer) {
 	}
 }
 
+func (d *Dispatcher) handlePex(p *peer, msg *p2p.PexMessage) {
+	peers := conn.PeersFromPexMessage(msg)
+	if len(peers) == 0 {
+		return
+	}
+	d.log("peer", p).Debugf("Received %d peers via peer exchange", len(peers))
+	d.events.PeersExchanged(d.torrent.InfoHash(), peers)
+}
+
 func (d *Dispatcher) log(args ...interface{}) *zap.SugaredLogger {
 	args = append(args, "torrent", d.torrent)
 	return d.logger.With(args...)
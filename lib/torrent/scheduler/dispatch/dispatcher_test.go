@@ -85,6 +85,16 @@ func announcedPieces(messages Messages) []int {
 	return ps
 }
 
+func cancelledPieces(messages Messages) []int {
+	var ps []int
+	for _, msg := range messages.(*mockMessages).sent {
+		if msg.Message.Type == p2p.Message_CANCEL_PIECE {
+			ps = append(ps, int(msg.Message.CancelPiece.Index))
+		}
+	}
+	return ps
+}
+
 func hasComplete(messages Messages) bool {
 	for _, m := range messages.(*mockMessages).sent {
 		if m.Message.Type == p2p.Message_COMPLETE {
@@ -104,6 +114,8 @@ func (e noopEvents) DispatcherComplete(*Dispatcher) {}
 
 func (e noopEvents) PeerRemoved(core.PeerID, core.InfoHash) {}
 
+func (e noopEvents) PeersExchanged(core.InfoHash, []*core.PeerInfo) {}
+
 func testDispatcher(config Config, clk clock.Clock, t storage.Torrent) *Dispatcher {
 	d, err := newDispatcher(
 		config,
@@ -113,6 +125,10 @@ func testDispatcher(config Config, clk clock.Clock, t storage.Torrent) *Dispatch
 		noopEvents{},
 		core.PeerIDFixture(),
 		t,
+		nil,
+		nil,
+		nil,
+		nil,
 		zap.NewNop().Sugar(),
 		torrentlog.NewNopLogger())
 	if err != nil {
@@ -316,6 +332,63 @@ func TestDispatcherEndgame(t *testing.T) {
 	require.Equal(map[int]int{0: 1}, numRequestsPerPiece(p2.messages))
 }
 
+func TestDispatcherEndgamePercent(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{
+		PipelineLimit:    1,
+		EndgameThreshold: 1,
+		EndgamePercent:   50,
+	}
+
+	blob := core.SizedBlobFixture(4, 1)
+
+	torrent, cleanup := agentstorage.TorrentFixture(blob.MetaInfo)
+	defer cleanup()
+
+	d := testDispatcher(config, clock.NewMock(), torrent)
+	require.False(d.endgame())
+
+	for i := 0; i < 2; i++ {
+		require.NoError(torrent.WritePiece(piecereader.NewBuffer(blob.Content[i:i+1]), i))
+	}
+	require.True(d.endgame())
+}
+
+func TestDispatcherEndgameCancelsDuplicateRequests(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{
+		PipelineLimit:    1,
+		EndgameThreshold: 2,
+	}
+
+	blob := core.SizedBlobFixture(2, 1)
+
+	torrent, cleanup := agentstorage.TorrentFixture(blob.MetaInfo)
+	defer cleanup()
+
+	d := testDispatcher(config, clock.NewMock(), torrent)
+
+	p1, err := d.addPeer(core.PeerIDFixture(), bitsetutil.FromBools(true, false), newMockMessages())
+	require.NoError(err)
+
+	p2, err := d.addPeer(core.PeerIDFixture(), bitsetutil.FromBools(true, false), newMockMessages())
+	require.NoError(err)
+
+	d.maybeRequestMorePieces(p1)
+	d.maybeRequestMorePieces(p2)
+	require.Equal(map[int]int{0: 1}, numRequestsPerPiece(p1.messages))
+	require.Equal(map[int]int{0: 1}, numRequestsPerPiece(p2.messages))
+
+	msg := conn.NewPiecePayloadMessage(0, piecereader.NewBuffer(blob.Content[0:1]))
+	require.NoError(d.dispatch(p1, msg))
+
+	// Only the peer which lost the race should be cancelled.
+	require.Empty(cancelledPieces(p1.messages))
+	require.Equal([]int{0}, cancelledPieces(p2.messages))
+}
+
 func TestDispatcherHandlePiecePayloadAnnouncesPiece(t *testing.T) {
 	require := require.New(t)
 
@@ -472,3 +545,63 @@ func TestDispatcherPeerPieceCounts(t *testing.T) {
 	require.Equal(1, d.numPeersByPiece.Get(1))
 	require.Equal(2, d.numPeersByPiece.Get(2))
 }
+
+func TestDispatcherStatDownloadRate(t *testing.T) {
+	require := require.New(t)
+
+	blob := core.SizedBlobFixture(4, 1)
+	clk := clock.NewMock()
+
+	torrent, cleanup := agentstorage.TorrentFixture(blob.MetaInfo)
+	defer cleanup()
+
+	require.NoError(torrent.WritePiece(piecereader.NewBuffer(blob.Content[0:1]), 0))
+
+	d := testDispatcher(Config{}, clk, torrent)
+
+	// Rate is unknown until time has passed.
+	require.Equal(float64(0), d.Stat().DownloadRate())
+
+	clk.Add(time.Second)
+	require.NoError(torrent.WritePiece(piecereader.NewBuffer(blob.Content[1:2]), 1))
+	clk.Add(time.Second)
+
+	// Pieces complete before d was created do not count towards the rate.
+	info := d.Stat()
+	require.Equal(int64(2), info.BytesDownloaded())
+	require.Equal(0.5, info.DownloadRate())
+	eta, ok := info.ETA()
+	require.True(ok)
+	require.Equal(4*time.Second, eta)
+}
+
+type pexEvents struct {
+	noopEvents
+	sync.Mutex
+	peers map[core.InfoHash][]*core.PeerInfo
+}
+
+func (e *pexEvents) PeersExchanged(h core.InfoHash, peers []*core.PeerInfo) {
+	e.Lock()
+	defer e.Unlock()
+	e.peers[h] = append(e.peers[h], peers...)
+}
+
+func TestDispatcherForwardsExchangedPeers(t *testing.T) {
+	require := require.New(t)
+
+	torrent, cleanup := agentstorage.TorrentFixture(core.SizedBlobFixture(1, 1).MetaInfo)
+	defer cleanup()
+
+	d := testDispatcher(Config{}, clock.NewMock(), torrent)
+	events := &pexEvents{peers: make(map[core.InfoHash][]*core.PeerInfo)}
+	d.events = events
+
+	p, err := d.addPeer(core.PeerIDFixture(), bitsetutil.FromBools(true), newMockMessages())
+	require.NoError(err)
+
+	exchanged := []*core.PeerInfo{core.PeerInfoFixture(), core.PeerInfoFixture()}
+	require.NoError(d.dispatch(p, conn.NewPexMessage(exchanged)))
+
+	require.Equal(exchanged, events.peers[torrent.InfoHash()])
+}
@@ -14,7 +14,6 @@
 package piecerequest
 
 import (
-	"fmt"
 	"sort"
 	"sync"
 	"time"
@@ -66,6 +65,14 @@ type Manager struct {
 
 	policy        pieceSelectionPolicy
 	pipelineLimit int
+
+	// throttle, if set, scales pipelineLimit down when pieces cannot be
+	// written as fast as they are received.
+	throttle func(limit int) int
+
+	// peerLimit, if set, scales the pipeline limit of individual peers, e.g.
+	// by their reputation.
+	peerLimit func(peerID core.PeerID, limit int) int
 }
 
 // NewManager creates a new Manager.
@@ -83,14 +90,11 @@ func NewManager(
 		pipelineLimit:  pipelineLimit,
 	}
 
-	switch policy {
-	case DefaultPolicy:
-		m.policy = newDefaultPolicy()
-	case RarestFirstPolicy:
-		m.policy = newRarestFirstPolicy()
-	default:
-		return nil, fmt.Errorf("invalid piece selection policy: %s", policy)
+	p, err := newPolicy(policy)
+	if err != nil {
+		return nil, err
 	}
+	m.policy = p
 	return m, nil
 }
 
@@ -152,6 +156,33 @@ func (m *Manager) Clear(i int) {
 	m.Lock()
 	defer m.Unlock()
 
+	m.clear(i)
+}
+
+// MarkComplete clears the piece request for piece i and notifies the piece
+// selection policy that piece i has been written. Returns the peers which still
+// had pending requests for piece i, which may be cancelled.
+func (m *Manager) MarkComplete(i int) []core.PeerID {
+	m.Lock()
+	defer m.Unlock()
+
+	var pending []core.PeerID
+	for _, r := range m.requests[i] {
+		if r.Status == StatusPending && !m.expired(r) {
+			pending = append(pending, r.PeerID)
+		}
+	}
+
+	m.clear(i)
+
+	if o, ok := m.policy.(pieceCompletionObserver); ok {
+		o.pieceCompleted(i)
+	}
+
+	return pending
+}
+
+func (m *Manager) clear(i int) {
 	delete(m.requests, i)
 
 	for peerID, pm := range m.requestsByPeer {
@@ -235,8 +266,54 @@ func (m *Manager) validRequest(peerID core.PeerID, i int, allowDuplicates bool)
 	return true
 }
 
+// SetPipelineLimit changes the maximum number of pending requests per peer.
+// Requests already exceeding the new limit are not cancelled.
+func (m *Manager) SetPipelineLimit(limit int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.pipelineLimit = limit
+}
+
+// SetPipelineThrottle sets a function which scales the pipeline limit when
+// reserving pieces, e.g. to apply backpressure from slow piece writes.
+func (m *Manager) SetPipelineThrottle(throttle func(limit int) int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.throttle = throttle
+}
+
+// SetPeerPipelineLimit sets a function which scales the pipeline limit of
+// individual peers when reserving pieces.
+func (m *Manager) SetPeerPipelineLimit(limit func(peerID core.PeerID, limit int) int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.peerLimit = limit
+}
+
+// Elapsed returns how long ago piece i was requested from peerID. Returns
+// false if no such request exists.
+func (m *Manager) Elapsed(peerID core.PeerID, i int) (time.Duration, bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	r, ok := m.requestsByPeer[peerID][i]
+	if !ok {
+		return 0, false
+	}
+	return m.clock.Now().Sub(r.sentAt), true
+}
+
 func (m *Manager) requestQuota(peerID core.PeerID) int {
 	quota := m.pipelineLimit
+	if m.throttle != nil {
+		quota = m.throttle(quota)
+	}
+	if m.peerLimit != nil {
+		quota = m.peerLimit(peerID, quota)
+	}
 	pm, ok := m.requestsByPeer[peerID]
 	if !ok {
 		return quota
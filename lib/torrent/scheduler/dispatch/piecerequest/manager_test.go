@@ -62,6 +62,106 @@ func TestManagerPipelineLimit(t *testing.T) {
 	require.Len(m.PendingPieces(peerID), 3)
 }
 
+func TestManagerSetPipelineLimit(t *testing.T) {
+	require := require.New(t)
+
+	m := newManager(clock.NewMock(), 5*time.Second, DefaultPolicy, 3)
+
+	m.SetPipelineLimit(1)
+
+	peerID := core.PeerIDFixture()
+
+	pieces, err := m.ReservePieces(peerID, bitsetutil.FromBools(true, true, true, true),
+		countsFromInts(0, 0, 0, 0), false)
+	require.NoError(err)
+	require.Len(pieces, 1)
+
+	m.SetPipelineLimit(3)
+
+	pieces, err = m.ReservePieces(peerID, bitsetutil.FromBools(true, true, true, true),
+		countsFromInts(0, 0, 0, 0), false)
+	require.NoError(err)
+	require.Len(pieces, 2)
+
+	require.Len(m.PendingPieces(peerID), 3)
+}
+
+func TestManagerSetPipelineThrottle(t *testing.T) {
+	require := require.New(t)
+
+	m := newManager(clock.NewMock(), 5*time.Second, DefaultPolicy, 3)
+
+	throttled := true
+	m.SetPipelineThrottle(func(limit int) int {
+		if throttled {
+			return 1
+		}
+		return limit
+	})
+
+	peerID := core.PeerIDFixture()
+
+	pieces, err := m.ReservePieces(peerID, bitsetutil.FromBools(true, true, true, true),
+		countsFromInts(0, 0, 0, 0), false)
+	require.NoError(err)
+	require.Len(pieces, 1)
+
+	throttled = false
+
+	pieces, err = m.ReservePieces(peerID, bitsetutil.FromBools(true, true, true, true),
+		countsFromInts(0, 0, 0, 0), false)
+	require.NoError(err)
+	require.Len(pieces, 2)
+}
+
+func TestManagerSetPeerPipelineLimit(t *testing.T) {
+	require := require.New(t)
+
+	m := newManager(clock.NewMock(), 5*time.Second, DefaultPolicy, 3)
+
+	slow := core.PeerIDFixture()
+	m.SetPeerPipelineLimit(func(peerID core.PeerID, limit int) int {
+		if peerID == slow {
+			return 1
+		}
+		return limit
+	})
+
+	pieces, err := m.ReservePieces(slow, bitsetutil.FromBools(true, true, true, true),
+		countsFromInts(0, 0, 0, 0), false)
+	require.NoError(err)
+	require.Len(pieces, 1)
+
+	pieces, err = m.ReservePieces(core.PeerIDFixture(), bitsetutil.FromBools(true, true, true, true),
+		countsFromInts(0, 0, 0, 0), false)
+	require.NoError(err)
+	require.Len(pieces, 3)
+}
+
+func TestManagerElapsed(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+
+	m := newManager(clk, 5*time.Second, DefaultPolicy, 1)
+
+	peerID := core.PeerIDFixture()
+
+	_, ok := m.Elapsed(peerID, 0)
+	require.False(ok)
+
+	pieces, err := m.ReservePieces(peerID, bitsetutil.FromBools(true),
+		countsFromInts(0), false)
+	require.NoError(err)
+	require.Equal([]int{0}, pieces)
+
+	clk.Add(2 * time.Second)
+
+	elapsed, ok := m.Elapsed(peerID, 0)
+	require.True(ok)
+	require.Equal(2*time.Second, elapsed)
+}
+
 func TestManagerReserveExpiredRequest(t *testing.T) {
 	require := require.New(t)
 
@@ -406,3 +506,79 @@ func TestRarestFirstPolicy(t *testing.T) {
 	require.NoError(err)
 	require.Empty(pieces)
 }
+
+func TestSequentialPolicy(t *testing.T) {
+	require := require.New(t)
+
+	m := newManager(clock.NewMock(), 5*time.Second, SequentialPolicy, 2)
+
+	p1 := core.PeerIDFixture()
+	p2 := core.PeerIDFixture()
+
+	pieces, err := m.ReservePieces(p1, bitsetutil.FromBools(false, true, true, true),
+		countsFromInts(2, 3, 1, 0), false)
+	require.NoError(err)
+	require.Equal([]int{1, 2}, pieces)
+
+	pieces, err = m.ReservePieces(p2, bitsetutil.FromBools(true, true, true, true),
+		countsFromInts(2, 3, 1, 0), false)
+	require.NoError(err)
+	require.Equal([]int{0, 3}, pieces)
+}
+
+func TestRandomFirstPiecePolicySwitchesToRarestFirst(t *testing.T) {
+	require := require.New(t)
+
+	m := newManager(clock.NewMock(), 5*time.Second, RandomFirstPiecePolicy, 1)
+
+	n := randomFirstPieceCount + 2
+	candidates := bitsetutil.FromBools(make([]bool, n)...)
+	for i := 0; i < n; i++ {
+		candidates.Set(uint(i))
+	}
+	counts := syncutil.NewCounters(n)
+	for i := 0; i < n; i++ {
+		counts.Set(i, n-i)
+	}
+
+	for i := 0; i < randomFirstPieceCount; i++ {
+		pieces, err := m.ReservePieces(core.PeerIDFixture(), candidates, counts, false)
+		require.NoError(err)
+		require.Len(pieces, 1)
+		candidates.Clear(uint(pieces[0]))
+		m.MarkComplete(pieces[0])
+	}
+
+	// Once enough pieces are complete, the rarest remaining piece is selected.
+	rarest := -1
+	for i, e := candidates.NextSet(0); e; i, e = candidates.NextSet(i + 1) {
+		rarest = int(i)
+	}
+	pieces, err := m.ReservePieces(core.PeerIDFixture(), candidates, counts, false)
+	require.NoError(err)
+	require.Equal([]int{rarest}, pieces)
+}
+
+func TestNewManagerInvalidPolicy(t *testing.T) {
+	_, err := NewManager(clock.NewMock(), 5*time.Second, "foo", 1)
+	require.Error(t, err)
+}
+
+func TestManagerMarkCompleteReturnsPendingPeers(t *testing.T) {
+	require := require.New(t)
+
+	m := newManager(clock.NewMock(), 5*time.Second, DefaultPolicy, 1)
+
+	p1 := core.PeerIDFixture()
+	p2 := core.PeerIDFixture()
+
+	for _, p := range []core.PeerID{p1, p2} {
+		pieces, err := m.ReservePieces(p, bitsetutil.FromBools(true), countsFromInts(1), true)
+		require.NoError(err)
+		require.Equal([]int{0}, pieces)
+	}
+	m.MarkUnsent(p2, 0)
+
+	require.Equal([]core.PeerID{p1}, m.MarkComplete(0))
+	require.Empty(m.PendingPieces(p1))
+}
@@ -14,6 +14,8 @@
 package piecerequest
 
 import (
+	"fmt"
+
 	"github.com/uber/kraken/utils/syncutil"
 
 	"github.com/willf/bitset"
@@ -29,3 +31,25 @@ type pieceSelectionPolicy interface {
 		candidates *bitset.BitSet,
 		numPeersByPiece syncutil.Counters) ([]int, error)
 }
+
+// pieceCompletionObserver is optionally implemented by policies which change
+// their selection as the torrent progresses. Like selectPieces, pieceCompleted
+// is called with the Manager lock held.
+type pieceCompletionObserver interface {
+	pieceCompleted(i int)
+}
+
+func newPolicy(name string) (pieceSelectionPolicy, error) {
+	switch name {
+	case DefaultPolicy:
+		return newDefaultPolicy(), nil
+	case RarestFirstPolicy:
+		return newRarestFirstPolicy(), nil
+	case SequentialPolicy:
+		return newSequentialPolicy(), nil
+	case RandomFirstPiecePolicy:
+		return newRandomFirstPiecePolicy(), nil
+	default:
+		return nil, fmt.Errorf("invalid piece selection policy: %s", name)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package piecerequest
+
+import (
+	"github.com/uber/kraken/utils/syncutil"
+
+	"github.com/willf/bitset"
+)
+
+// RandomFirstPiecePolicy selects pieces randomly until a few pieces have been
+// completed, then switches to rarest first. Getting complete pieces quickly lets
+// a new peer start uploading sooner, before rarity matters.
+const RandomFirstPiecePolicy = "random_first_piece"
+
+// randomFirstPieceCount is the number of completed pieces after which the
+// random first piece policy switches to rarest first.
+const randomFirstPieceCount = 4
+
+type randomFirstPiecePolicy struct {
+	completed   int
+	random      *defaultPolicy
+	rarestFirst *rarestFirstPolicy
+}
+
+func newRandomFirstPiecePolicy() *randomFirstPiecePolicy {
+	return &randomFirstPiecePolicy{
+		random:      newDefaultPolicy(),
+		rarestFirst: newRarestFirstPolicy(),
+	}
+}
+
+func (p *randomFirstPiecePolicy) selectPieces(
+	limit int,
+	valid func(int) bool,
+	candidates *bitset.BitSet,
+	numPeersByPiece syncutil.Counters) ([]int, error) {
+
+	if p.completed < randomFirstPieceCount {
+		return p.random.selectPieces(limit, valid, candidates, numPeersByPiece)
+	}
+	return p.rarestFirst.selectPieces(limit, valid, candidates, numPeersByPiece)
+}
+
+func (p *randomFirstPiecePolicy) pieceCompleted(i int) {
+	p.completed++
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dispatch
+
+import (
+	"time"
+)
+
+// SLO violations.
+const (
+	sloStalled       = "stalled"
+	sloLowThroughput = "low_throughput"
+)
+
+// SLOConfig defines the download service level which torrents must meet.
+// Torrents which violate it abandon p2p and are completed from origins via the
+// webseed, so SLOs have no effect unless origins are configured.
+type SLOConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// MaxStall is the duration without receiving any piece after which a
+	// torrent falls back to origins.
+	MaxStall time.Duration `yaml:"max_stall"`
+
+	// MinThroughput is the download rate, in bytes per second and averaged
+	// over Window, below which a torrent falls back to origins. Defaults to 0,
+	// which does not enforce throughput.
+	MinThroughput uint64 `yaml:"min_throughput"`
+
+	// Window is the duration over which throughput is averaged.
+	Window time.Duration `yaml:"window"`
+
+	// Concurrency limits the number of pieces per torrent which are fetched
+	// from origins at the same time once a torrent has fallen back. Replaces
+	// the webseed concurrency.
+	Concurrency int `yaml:"concurrency"`
+}
+
+func (c SLOConfig) applyDefaults() SLOConfig {
+	if c.MaxStall == 0 {
+		c.MaxStall = time.Minute
+	}
+	if c.Window == 0 {
+		c.Window = 30 * time.Second
+	}
+	if c.Concurrency == 0 {
+		c.Concurrency = 8
+	}
+	return c
+}
+
+// sloMonitor detects torrents violating their SLO. Not thread-safe.
+type sloMonitor struct {
+	config SLOConfig
+
+	windowStart time.Time
+	windowBytes int64
+
+	// pausedAt is the last time the torrent was seen paused. Stalls are not
+	// measured while paused.
+	pausedAt time.Time
+}
+
+func newSLOMonitor(config SLOConfig, now time.Time, bytesDownloaded int64) *sloMonitor {
+	return &sloMonitor{
+		config:      config,
+		windowStart: now,
+		windowBytes: bytesDownloaded,
+	}
+}
+
+// reset starts a new throughput window.
+func (m *sloMonitor) reset(now time.Time, bytesDownloaded int64) {
+	m.windowStart = now
+	m.windowBytes = bytesDownloaded
+}
+
+// pause records that the torrent is paused at now.
+func (m *sloMonitor) pause(now time.Time, bytesDownloaded int64) {
+	m.reset(now, bytesDownloaded)
+	m.pausedAt = now
+}
+
+// check returns the SLO violated by a torrent which has downloaded
+// bytesDownloaded and last received a piece at lastWrite, or the empty string
+// if the torrent is within its SLO.
+func (m *sloMonitor) check(now time.Time, bytesDownloaded int64, lastWrite time.Time) string {
+	if m.pausedAt.After(lastWrite) {
+		lastWrite = m.pausedAt
+	}
+	if now.Sub(lastWrite) >= m.config.MaxStall {
+		return sloStalled
+	}
+	elapsed := now.Sub(m.windowStart)
+	if elapsed < m.config.Window {
+		return ""
+	}
+	rate := float64(bytesDownloaded-m.windowBytes) / elapsed.Seconds()
+	m.reset(now, bytesDownloaded)
+	if rate < float64(m.config.MinThroughput) {
+		return sloLowThroughput
+	}
+	return ""
+}
+
+// checkSLO falls back to origins if d violates its SLO.
+func (d *Dispatcher) checkSLO() {
+	if d.torrent.Complete() || d.fallback.Load() {
+		return
+	}
+	now := d.clk.Now()
+	bytesDownloaded := d.torrent.Stat().BytesDownloaded()
+	if d.paused.Load() {
+		// Paused torrents are not expected to make progress.
+		d.slo.pause(now, bytesDownloaded)
+		return
+	}
+	if v := d.slo.check(now, bytesDownloaded, d.torrent.getLastWriteTime()); v != "" {
+		d.fallbackToOrigin(v)
+	}
+}
+
+// fallbackToOrigin stops requesting pieces from peers and instead fetches all
+// missing pieces from the webseed. Connections remain open, such that peers
+// may still download from d.
+func (d *Dispatcher) fallbackToOrigin(violation string) {
+	if !d.fallback.CAS(false, true) {
+		return
+	}
+	d.log("violation", violation).Warn("Torrent violated download SLO, falling back to origins")
+	d.stats.Tagged(map[string]string{
+		"violation": violation,
+	}).Counter("slo_origin_fallbacks").Inc(1)
+	d.webseeder.setConcurrency(d.slo.config.Concurrency)
+}
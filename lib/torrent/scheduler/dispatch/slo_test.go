@@ -0,0 +1,137 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dispatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage/agentstorage"
+	"github.com/uber/kraken/utils/bitsetutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSLOMonitorStalled(t *testing.T) {
+	require := require.New(t)
+
+	start := time.Now()
+	m := newSLOMonitor(SLOConfig{MaxStall: time.Minute}.applyDefaults(), start, 0)
+
+	require.Equal("", m.check(start.Add(59*time.Second), 0, start))
+	require.Equal(sloStalled, m.check(start.Add(time.Minute), 0, start))
+}
+
+func TestSLOMonitorLowThroughput(t *testing.T) {
+	require := require.New(t)
+
+	config := SLOConfig{
+		MaxStall:      time.Hour,
+		MinThroughput: 100,
+		Window:        10 * time.Second,
+	}.applyDefaults()
+
+	start := time.Now()
+	m := newSLOMonitor(config, start, 0)
+
+	// Throughput is not measured until a full window has elapsed.
+	require.Equal("", m.check(start.Add(5*time.Second), 0, start))
+
+	// 1000 bytes / 10s = 100 bytes/s.
+	now := start.Add(10 * time.Second)
+	require.Equal("", m.check(now, 1000, now))
+
+	// 500 bytes / 10s = 50 bytes/s.
+	now = now.Add(10 * time.Second)
+	require.Equal(sloLowThroughput, m.check(now, 1500, now))
+}
+
+func TestSLOMonitorIgnoresThroughputByDefault(t *testing.T) {
+	require := require.New(t)
+
+	start := time.Now()
+	m := newSLOMonitor(SLOConfig{}.applyDefaults(), start, 0)
+
+	now := start.Add(time.Hour)
+	require.Equal("", m.check(now, 0, now))
+}
+
+func TestDispatcherFallbackToOriginStopsPieceRequests(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{
+		SLO: SLOConfig{
+			Enabled:     true,
+			MaxStall:    time.Minute,
+			Concurrency: 4,
+		},
+	}
+	clk := clock.NewMock()
+
+	blob := core.SizedBlobFixture(4, 1)
+
+	torrent, cleanup := agentstorage.TorrentFixture(blob.MetaInfo)
+	defer cleanup()
+
+	d := testWebseedDispatcher(config, clk, torrent, testWebseed{content: blob.Content})
+	d.slo = newSLOMonitor(config.SLO.applyDefaults(), clk.Now(), 0)
+
+	p, err := d.addPeer(
+		core.PeerIDFixture(), bitsetutil.FromBools(true, true, true, true), newMockMessages())
+	require.NoError(err)
+
+	// Webseed is only used for fallback.
+	require.False(d.webseedNeeded())
+
+	clk.Add(time.Minute)
+	d.checkSLO()
+
+	require.True(d.fallback.Load())
+	require.True(d.webseedNeeded())
+	require.Equal([]int{0, 1, 2, 3}, d.webseeder.reserve([]int{0, 1, 2, 3}))
+
+	sent, err := d.maybeRequestMorePieces(p)
+	require.NoError(err)
+	require.False(sent)
+}
+
+func TestDispatcherCheckSLOIgnoresPausedTorrents(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{SLO: SLOConfig{Enabled: true, MaxStall: time.Minute}}
+	clk := clock.NewMock()
+
+	torrent, cleanup := agentstorage.TorrentFixture(core.SizedBlobFixture(4, 1).MetaInfo)
+	defer cleanup()
+
+	d := testWebseedDispatcher(config, clk, torrent, testWebseed{})
+	d.slo = newSLOMonitor(config.SLO.applyDefaults(), clk.Now(), 0)
+	d.SetPaused(true)
+
+	clk.Add(time.Minute)
+	d.checkSLO()
+	require.False(d.fallback.Load())
+
+	// Time spent paused does not count towards stalls.
+	d.SetPaused(false)
+	clk.Add(30 * time.Second)
+	d.checkSLO()
+	require.False(d.fallback.Load())
+
+	clk.Add(30 * time.Second)
+	d.checkSLO()
+	require.True(d.fallback.Load())
+}
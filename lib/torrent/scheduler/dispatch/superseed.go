@@ -0,0 +1,132 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dispatch
+
+import (
+	"sync"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/scheduler/conn"
+	"github.com/uber/kraken/utils/syncutil"
+
+	"github.com/willf/bitset"
+)
+
+// SuperseedConfig defines superseeding, where a complete peer (typically an
+// origin) hides its bitfield and reveals only a few rare pieces to each peer at
+// a time. A new piece is revealed once a previously revealed piece has been
+// served, which forces the remaining pieces to replicate between peers instead
+// of all being served by the seeder.
+type SuperseedConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// RevealLimit is the maximum number of revealed pieces per peer which have
+	// not yet been served.
+	RevealLimit int `yaml:"reveal_limit"`
+}
+
+func (c SuperseedConfig) applyDefaults() SuperseedConfig {
+	if c.RevealLimit == 0 {
+		c.RevealLimit = 2
+	}
+	return c
+}
+
+// superseeder tracks which pieces have been revealed to each peer.
+type superseeder struct {
+	limit int
+
+	mu       sync.Mutex
+	revealed map[core.PeerID]map[int]bool // Revealed pieces not yet served.
+	times    []int                        // Number of times each piece was revealed.
+}
+
+func newSuperseeder(config SuperseedConfig, numPieces int) *superseeder {
+	return &superseeder{
+		limit:    config.RevealLimit,
+		revealed: make(map[core.PeerID]map[int]bool),
+		times:    make([]int, numPieces),
+	}
+}
+
+// reveal selects pieces to reveal to peerID, which already has the pieces in
+// has. Pieces which the fewest peers have, and which have been revealed the
+// fewest times, are selected first.
+func (s *superseeder) reveal(
+	peerID core.PeerID, has *bitset.BitSet, numPeersByPiece syncutil.Counters) []int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revealed, ok := s.revealed[peerID]
+	if !ok {
+		revealed = make(map[int]bool)
+		s.revealed[peerID] = revealed
+	}
+
+	var pieces []int
+	for len(revealed) < s.limit {
+		best := -1
+		var bestScore int
+		for i := range s.times {
+			if has.Test(uint(i)) || revealed[i] {
+				continue
+			}
+			score := numPeersByPiece.Get(i) + s.times[i]
+			if best == -1 || score < bestScore {
+				best = i
+				bestScore = score
+			}
+		}
+		if best == -1 {
+			break
+		}
+		revealed[best] = true
+		s.times[best]++
+		pieces = append(pieces, best)
+	}
+	return pieces
+}
+
+// served marks piece i as served to peerID, freeing up a reveal.
+func (s *superseeder) served(peerID core.PeerID, i int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.revealed[peerID], i)
+}
+
+// removePeer clears the revealed pieces of peerID.
+func (s *superseeder) removePeer(peerID core.PeerID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.revealed, peerID)
+}
+
+// superseeding returns true if d hides its bitfield from peers and reveals
+// pieces via revealPieces instead.
+func (d *Dispatcher) superseeding() bool {
+	return d.superseeder != nil && d.torrent.Complete()
+}
+
+// revealPieces announces rare pieces to p, up to the reveal limit.
+func (d *Dispatcher) revealPieces(p *peer) {
+	for _, i := range d.superseeder.reveal(p.id, p.bitfield.Copy(), d.numPeersByPiece) {
+		if err := p.messages.Send(conn.NewAnnouncePieceMessage(i)); err != nil {
+			return
+		}
+		d.stats.Counter("superseed_revealed_pieces").Inc(1)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dispatch
+
+import (
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/scheduler/conn"
+	"github.com/uber/kraken/lib/torrent/storage/agentstorage"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+	"github.com/uber/kraken/utils/bitsetutil"
+	"github.com/uber/kraken/utils/syncutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuperseederRevealsRarestPieces(t *testing.T) {
+	require := require.New(t)
+
+	s := newSuperseeder(SuperseedConfig{RevealLimit: 2}, 4)
+
+	numPeersByPiece := syncutil.NewCounters(4)
+	numPeersByPiece.Set(0, 3)
+	numPeersByPiece.Set(2, 1)
+
+	p1 := core.PeerIDFixture()
+	p2 := core.PeerIDFixture()
+
+	require.Equal(
+		[]int{3, 2},
+		s.reveal(p1, bitsetutil.FromBools(false, true, false, false), numPeersByPiece))
+
+	// Already at the reveal limit.
+	require.Empty(s.reveal(p1, bitsetutil.FromBools(false, true, false, false), numPeersByPiece))
+
+	// Pieces revealed to p1 are less preferred for p2.
+	require.Equal(
+		[]int{1, 3},
+		s.reveal(p2, bitsetutil.FromBools(false, false, false, false), numPeersByPiece))
+
+	s.served(p1, 3)
+	require.Equal(
+		[]int{1},
+		s.reveal(p1, bitsetutil.FromBools(false, true, false, true), numPeersByPiece))
+}
+
+func TestDispatcherSuperseedRevealsPieceAfterServing(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{
+		Superseed: SuperseedConfig{
+			Enabled:     true,
+			RevealLimit: 1,
+		},
+	}
+
+	blob := core.SizedBlobFixture(4, 1)
+
+	torrent, cleanup := agentstorage.TorrentFixture(blob.MetaInfo)
+	defer cleanup()
+
+	for i := 0; i < 4; i++ {
+		require.NoError(torrent.WritePiece(piecereader.NewBuffer(blob.Content[i:i+1]), i))
+	}
+
+	d := testDispatcher(config, clock.NewMock(), torrent)
+	require.True(d.superseeding())
+
+	p, err := d.addPeer(
+		core.PeerIDFixture(), bitsetutil.FromBools(false, false, false, false), newMockMessages())
+	require.NoError(err)
+
+	d.revealPieces(p)
+	revealed := announcedPieces(p.messages)
+	require.Len(revealed, 1)
+
+	require.NoError(d.dispatch(p, conn.NewPieceRequestMessage(revealed[0], 1)))
+
+	revealed = announcedPieces(p.messages)
+	require.Len(revealed, 2)
+	require.NotEqual(revealed[0], revealed[1])
+}
+
+func TestDispatcherSuperseedDisabledForIncompleteTorrent(t *testing.T) {
+	config := Config{Superseed: SuperseedConfig{Enabled: true}}
+
+	torrent, cleanup := agentstorage.TorrentFixture(core.SizedBlobFixture(4, 1).MetaInfo)
+	defer cleanup()
+
+	d := testDispatcher(config, clock.NewMock(), torrent)
+	require.False(t, d.superseeding())
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dispatch
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/scheduler/conn"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+)
+
+// WebseedConfig defines fetching missing pieces directly from origins over
+// HTTP, in parallel with p2p, when the swarm is too small or has stalled.
+type WebseedConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// MinPeers is the number of connected peers below which missing pieces
+	// are fetched from origins.
+	MinPeers int `yaml:"min_peers"`
+
+	// StallTimeout is the duration without receiving any piece after which
+	// missing pieces are fetched from origins, regardless of MinPeers.
+	StallTimeout time.Duration `yaml:"stall_timeout"`
+
+	// Interval is how often the swarm is checked.
+	Interval time.Duration `yaml:"interval"`
+
+	// Concurrency limits the number of pieces per torrent which are fetched
+	// from origins at the same time.
+	Concurrency int `yaml:"concurrency"`
+}
+
+func (c WebseedConfig) applyDefaults() WebseedConfig {
+	if c.MinPeers == 0 {
+		c.MinPeers = 1
+	}
+	if c.StallTimeout == 0 {
+		c.StallTimeout = 10 * time.Second
+	}
+	if c.Interval == 0 {
+		c.Interval = time.Second
+	}
+	if c.Concurrency == 0 {
+		c.Concurrency = 2
+	}
+	return c
+}
+
+// Webseed fetches byte ranges of blobs over HTTP.
+type Webseed interface {
+	DownloadBlobRange(namespace string, d core.Digest, start, end int64, dst io.Writer) error
+}
+
+// webseeder tracks pieces which are being fetched from a Webseed.
+type webseeder struct {
+	config    WebseedConfig
+	webseed   Webseed
+	namespace string
+
+	mu          sync.Mutex
+	inflight    map[int]bool
+	concurrency int
+}
+
+func newWebseeder(config WebseedConfig, webseed Webseed, namespace string) *webseeder {
+	return &webseeder{
+		config:      config,
+		webseed:     webseed,
+		namespace:   namespace,
+		inflight:    make(map[int]bool),
+		concurrency: config.Concurrency,
+	}
+}
+
+// setConcurrency changes the number of pieces fetched at the same time.
+func (w *webseeder) setConcurrency(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.concurrency = n
+}
+
+// reserve marks up to the concurrency limit of candidates as inflight and
+// returns them.
+func (w *webseeder) reserve(candidates []int) []int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var pieces []int
+	for _, i := range candidates {
+		if len(w.inflight) >= w.concurrency {
+			break
+		}
+		if w.inflight[i] {
+			continue
+		}
+		w.inflight[i] = true
+		pieces = append(pieces, i)
+	}
+	return pieces
+}
+
+func (w *webseeder) release(i int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.inflight, i)
+}
+
+// watchWebseed periodically fetches missing pieces from the webseed while the
+// swarm cannot supply them, or once d has violated its SLO. Exits when
+// d.pendingPiecesDone is closed.
+func (d *Dispatcher) watchWebseed() {
+	for {
+		select {
+		case <-d.clk.After(d.webseeder.config.Interval):
+			if d.slo != nil {
+				d.checkSLO()
+			}
+			if d.webseedNeeded() {
+				d.fetchWebseedPieces()
+			}
+		case <-d.pendingPiecesDone:
+			return
+		}
+	}
+}
+
+// webseedNeeded returns true if d has fallen back to origins, has too few
+// peers, or if no piece has been written within the stall timeout.
+func (d *Dispatcher) webseedNeeded() bool {
+	if d.torrent.Complete() || d.paused.Load() {
+		return false
+	}
+	if d.fallback.Load() {
+		return true
+	}
+	if !d.webseeder.config.Enabled {
+		// Webseed is only used for SLO fallback.
+		return false
+	}
+	var numPeers int
+	d.peers.Range(func(k, v interface{}) bool {
+		numPeers++
+		return true
+	})
+	if numPeers < d.webseeder.config.MinPeers {
+		return true
+	}
+	return d.clk.Now().Sub(d.torrent.getLastWriteTime()) >= d.webseeder.config.StallTimeout
+}
+
+// fetchWebseedPieces starts fetching missing pieces from the webseed, preferring
+// pieces which the fewest peers have.
+func (d *Dispatcher) fetchWebseedPieces() {
+	missing := d.torrent.MissingPieces()
+	sort.SliceStable(missing, func(a, b int) bool {
+		return d.numPeersByPiece.Get(missing[a]) < d.numPeersByPiece.Get(missing[b])
+	})
+	for _, i := range d.webseeder.reserve(missing) {
+		go d.fetchWebseedPiece(i)
+	}
+}
+
+func (d *Dispatcher) fetchWebseedPiece(i int) {
+	defer d.webseeder.release(i)
+
+	start := int64(i) * d.torrent.MaxPieceLength()
+	end := start + d.torrent.PieceLength(i)
+
+	var b bytes.Buffer
+	if err := d.webseeder.webseed.DownloadBlobRange(
+		d.webseeder.namespace, d.torrent.Digest(), start, end, &b); err != nil {
+
+		d.log("piece", i).Infof("Error fetching piece from webseed: %s", err)
+		d.stats.Counter("webseed_errors").Inc(1)
+		return
+	}
+	if err := d.torrent.WritePiece(piecereader.NewBuffer(b.Bytes()), i); err != nil {
+		if err != storage.ErrPieceComplete {
+			d.log("piece", i).Errorf("Error writing webseed piece: %s", err)
+			d.stats.Counter("webseed_errors").Inc(1)
+		}
+		return
+	}
+	d.stats.Counter("webseed_pieces").Inc(1)
+
+	if d.torrent.Complete() {
+		d.complete()
+	}
+
+	d.cancelDuplicateRequests(core.PeerID{}, i, d.pieceRequestManager.MarkComplete(i))
+
+	d.peers.Range(func(k, v interface{}) bool {
+		v.(*peer).messages.Send(conn.NewAnnouncePieceMessage(i))
+		return true
+	})
+}
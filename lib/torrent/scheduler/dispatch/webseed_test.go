@@ -0,0 +1,137 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dispatch
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage/agentstorage"
+	"github.com/uber/kraken/utils/bitsetutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+)
+
+type testWebseed struct {
+	content []byte
+	err     error
+}
+
+func (w testWebseed) DownloadBlobRange(
+	namespace string, d core.Digest, start, end int64, dst io.Writer) error {
+
+	if w.err != nil {
+		return w.err
+	}
+	_, err := dst.Write(w.content[start:end])
+	return err
+}
+
+func testWebseedDispatcher(
+	config Config, clk clock.Clock, t *agentstorage.Torrent, w Webseed) *Dispatcher {
+
+	d := testDispatcher(config, clk, t)
+	d.webseeder = newWebseeder(config.Webseed.applyDefaults(), w, "")
+	return d
+}
+
+func TestWebseederReserveRespectsConcurrency(t *testing.T) {
+	require := require.New(t)
+
+	w := newWebseeder(WebseedConfig{Concurrency: 2}, nil, "")
+
+	require.Equal([]int{0, 1}, w.reserve([]int{0, 1, 2}))
+	require.Empty(w.reserve([]int{2}))
+
+	w.release(0)
+	require.Equal([]int{2}, w.reserve([]int{1, 2}))
+}
+
+func TestDispatcherWebseedNeeded(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{
+		Webseed: WebseedConfig{
+			Enabled:      true,
+			MinPeers:     1,
+			StallTimeout: time.Minute,
+		},
+	}
+	clk := clock.NewMock()
+
+	torrent, cleanup := agentstorage.TorrentFixture(core.SizedBlobFixture(4, 1).MetaInfo)
+	defer cleanup()
+
+	d := testWebseedDispatcher(config, clk, torrent, testWebseed{})
+
+	// No peers.
+	require.True(d.webseedNeeded())
+
+	_, err := d.addPeer(
+		core.PeerIDFixture(), bitsetutil.FromBools(true, true, true, true), newMockMessages())
+	require.NoError(err)
+	require.False(d.webseedNeeded())
+
+	// Swarm has stalled.
+	clk.Add(time.Minute)
+	require.True(d.webseedNeeded())
+}
+
+func TestDispatcherFetchWebseedPiece(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{Webseed: WebseedConfig{Enabled: true}}
+
+	blob := core.SizedBlobFixture(4, 2)
+
+	torrent, cleanup := agentstorage.TorrentFixture(blob.MetaInfo)
+	defer cleanup()
+
+	d := testWebseedDispatcher(config, clock.NewMock(), torrent, testWebseed{content: blob.Content})
+
+	p, err := d.addPeer(
+		core.PeerIDFixture(), bitsetutil.FromBools(false, false), newMockMessages())
+	require.NoError(err)
+
+	d.fetchWebseedPiece(1)
+	require.True(torrent.HasPiece(1))
+	require.Equal([]int{1}, announcedPieces(p.messages))
+
+	d.fetchWebseedPiece(0)
+	require.True(d.Complete())
+}
+
+func TestDispatcherFetchWebseedPieceError(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{Webseed: WebseedConfig{Enabled: true}}
+
+	blob := core.SizedBlobFixture(4, 2)
+
+	torrent, cleanup := agentstorage.TorrentFixture(blob.MetaInfo)
+	defer cleanup()
+
+	d := testWebseedDispatcher(
+		config, clock.NewMock(), torrent, testWebseed{err: errors.New("some error")})
+
+	require.Equal([]int{0}, d.webseeder.reserve([]int{0}))
+
+	d.fetchWebseedPiece(0)
+	require.False(torrent.HasPiece(0))
+	require.Empty(d.webseeder.inflight)
+}
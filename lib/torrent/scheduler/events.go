@@ -22,6 +22,7 @@ import (
 	"github.com/uber/kraken/lib/torrent/scheduler/connstate"
 	"github.com/uber/kraken/lib/torrent/scheduler/dispatch"
 	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/tracker/announceclient"
 	"github.com/uber/kraken/utils/memsize"
 	"github.com/uber/kraken/utils/timeutil"
 
@@ -116,6 +117,10 @@ func (l *liftedEventLoop) PeerRemoved(peerID core.PeerID, h core.InfoHash) {
 	l.send(peerRemovedEvent{peerID, h})
 }
 
+func (l *liftedEventLoop) PeersExchanged(h core.InfoHash, peers []*core.PeerInfo) {
+	l.send(peersExchangedEvent{h, peers})
+}
+
 func (l *liftedEventLoop) AnnounceTick() {
 	l.send(announceTickEvent{})
 }
@@ -130,7 +135,9 @@ func (e connClosedEvent) apply(s *state) {
 	s.conns.DeleteActive(e.c)
 	if err := s.conns.Blacklist(e.c.PeerID(), e.c.InfoHash()); err != nil {
 		s.log("conn", e.c).Infof("Cannot blacklist active conn: %s", err)
+		return
 	}
+	s.persistBlacklist(e.c.InfoHash())
 }
 
 // incomingHandshakeEvent when a handshake was received from a new connection.
@@ -143,6 +150,22 @@ type incomingHandshakeEvent struct {
 // to the scheduler's pending connections and asynchronously attempts to establish
 // the connection.
 func (e incomingHandshakeEvent) apply(s *state) {
+	if ctrl, ok := s.torrentControls[e.pc.InfoHash()]; ok && ctrl.paused {
+		s.log("peer", e.pc.PeerID(), "hash", e.pc.InfoHash()).Info(
+			"Rejecting incoming handshake: torrent is paused")
+		s.sched.torrentlog.IncomingConnectionReject(
+			e.pc.Digest(), e.pc.InfoHash(), e.pc.PeerID(), errTorrentPaused)
+		e.pc.Close()
+		return
+	}
+	if s.sched.reputation != nil && !s.sched.reputation.Admit(e.pc.PeerID()) {
+		s.log("peer", e.pc.PeerID(), "hash", e.pc.InfoHash()).Info(
+			"Rejecting incoming handshake: peer has poor reputation")
+		s.sched.torrentlog.IncomingConnectionReject(
+			e.pc.Digest(), e.pc.InfoHash(), e.pc.PeerID(), errPoorReputation)
+		e.pc.Close()
+		return
+	}
 	peerNeighbors := make([]core.PeerID, len(e.pc.RemoteBitfields()))
 	var i int
 	for peerID := range e.pc.RemoteBitfields() {
@@ -203,7 +226,9 @@ func (e failedOutgoingHandshakeEvent) apply(s *state) {
 	s.conns.DeletePending(e.peerID, e.infoHash)
 	if err := s.conns.Blacklist(e.peerID, e.infoHash); err != nil {
 		s.log("peer", e.peerID, "hash", e.infoHash).Infof("Cannot blacklist pending conn: %s", err)
+		return
 	}
+	s.persistBlacklist(e.infoHash)
 }
 
 // outgoingConnEvent occurs when a pending outgoing connection finishes handshaking.
@@ -226,9 +251,14 @@ func (e outgoingConnEvent) apply(s *state) {
 // announceTickEvent occurs when it is time to announce to the tracker.
 type announceTickEvent struct{}
 
-// apply pulls the next dispatcher from the announce queue and asynchronously
-// makes an announce request to the tracker.
+// apply pulls the next dispatchers from the announce queue and asynchronously
+// makes an announce request to the tracker. Up to the announcer's batch size
+// of dispatchers are announced together.
 func (e announceTickEvent) apply(s *state) {
+	if batchSize := s.sched.announcer.BatchSize(); batchSize > 1 {
+		e.applyBatch(s, batchSize)
+		return
+	}
 	var skipped []core.InfoHash
 	for {
 		h, ok := s.announceQueue.Next()
@@ -247,7 +277,8 @@ func (e announceTickEvent) apply(s *state) {
 			continue
 		}
 		go s.sched.announce(
-			ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(), ctrl.dispatcher.Complete())
+			ctrl.namespace, ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(),
+			ctrl.dispatcher.Complete())
 		break
 	}
 	// Re-enqueue any torrents we pulled off and ignored, else we would never
@@ -257,6 +288,40 @@ func (e announceTickEvent) apply(s *state) {
 	}
 }
 
+// applyBatch pulls up to batchSize dispatchers from the announce queue and
+// asynchronously announces them together.
+func (e announceTickEvent) applyBatch(s *state, batchSize int) {
+	var skipped []core.InfoHash
+	var items []announceclient.BatchItem
+	for len(items) < batchSize {
+		h, ok := s.announceQueue.Next()
+		if !ok {
+			break
+		}
+		if s.conns.Saturated(h) {
+			skipped = append(skipped, h)
+			continue
+		}
+		ctrl, ok := s.torrentControls[h]
+		if !ok {
+			s.log("hash", h).Error("Pulled unknown torrent off announce queue")
+			continue
+		}
+		items = append(items, announceclient.BatchItem{
+			Namespace: ctrl.namespace,
+			Digest:    ctrl.dispatcher.Digest(),
+			InfoHash:  ctrl.dispatcher.InfoHash(),
+			Complete:  ctrl.dispatcher.Complete(),
+		})
+	}
+	if len(items) > 0 {
+		go s.sched.batchAnnounce(items)
+	}
+	for _, h := range skipped {
+		s.announceQueue.Ready(h)
+	}
+}
+
 // announceResultEvent occurs when a successfully announced response was received
 // from the tracker.
 type announceResultEvent struct {
@@ -264,11 +329,8 @@ type announceResultEvent struct {
 	peers    []*core.PeerInfo
 }
 
-// apply selects new peers returned via an announce response to open connections to
-// if there is capacity. These connections are added to the scheduler's pending
-// connections and handshaked asynchronously.
-//
-// Also marks the dispatcher as ready to announce again.
+// apply selects new peers returned via an announce response to open connections
+// to. Also marks the dispatcher as ready to announce again.
 func (e announceResultEvent) apply(s *state) {
 	ctrl, ok := s.torrentControls[e.infoHash]
 	if !ok {
@@ -276,26 +338,73 @@ func (e announceResultEvent) apply(s *state) {
 		return
 	}
 	s.announceQueue.Ready(e.infoHash)
-	if ctrl.dispatcher.Complete() {
-		// Torrent is already complete, don't open any new connections.
+	s.addOutgoingPeers(e.infoHash, ctrl, e.peers)
+}
+
+// peersExchangedEvent occurs when a connected peer shares its peers via peer
+// exchange.
+type peersExchangedEvent struct {
+	infoHash core.InfoHash
+	peers    []*core.PeerInfo
+}
+
+// apply records the exchanged peers and opens connections to them, as if they
+// were returned by the tracker.
+func (e peersExchangedEvent) apply(s *state) {
+	if s.sched.gossip == nil {
+		return
+	}
+	ctrl, ok := s.torrentControls[e.infoHash]
+	if !ok {
 		return
 	}
-	for _, p := range e.peers {
-		if p.PeerID == s.sched.pctx.PeerID {
-			// Tracker may return our own peer.
+	s.sched.gossip.Learn(e.infoHash, e.peers)
+	s.addOutgoingPeers(e.infoHash, ctrl, e.peers)
+}
+
+// pexTickEvent occurs when it is time to exchange peers with connected peers.
+type pexTickEvent struct{}
+
+// apply sends each active conn the peers of its torrent which the local peer is
+// connected to, excluding the conn's own peer. Only peers whose addresses are
+// known, i.e. gossiped or returned by the tracker, are shared.
+func (e pexTickEvent) apply(s *state) {
+	conns := make(map[core.InfoHash][]*conn.Conn)
+	for _, c := range s.conns.ActiveConns() {
+		conns[c.InfoHash()] = append(conns[c.InfoHash()], c)
+	}
+	for h, cs := range conns {
+		if len(cs) < 2 {
 			continue
 		}
-		if s.conns.Blacklisted(p.PeerID, e.infoHash) {
-			continue
+		connected := make(map[core.PeerID]bool)
+		for _, c := range cs {
+			connected[c.PeerID()] = true
 		}
-		if err := s.conns.AddPending(p.PeerID, e.infoHash, nil); err != nil {
-			if err == connstate.ErrTorrentAtCapacity {
-				break
+		var peers []*core.PeerInfo
+		for _, p := range s.sched.gossip.Peers(h) {
+			if connected[p.PeerID] {
+				peers = append(peers, p)
+			}
+		}
+		for _, c := range cs {
+			if !c.Supports(conn.CapabilityPex) {
+				// Peer predates peer exchange or has it disabled.
+				continue
+			}
+			var others []*core.PeerInfo
+			for _, p := range peers {
+				if p.PeerID != c.PeerID() {
+					others = append(others, p)
+				}
+			}
+			if len(others) == 0 {
+				continue
+			}
+			if err := c.Send(conn.NewPexMessage(others)); err != nil {
+				s.log("conn", c).Infof("Error sending peer exchange: %s", err)
 			}
-			continue
 		}
-		go s.sched.initializeOutgoingHandshake(
-			p, ctrl.dispatcher.Stat(), ctrl.dispatcher.RemoteBitfields(), ctrl.namespace)
 	}
 }
 
@@ -315,10 +424,12 @@ func (e announceErrEvent) apply(s *state) {
 type newTorrentEvent struct {
 	namespace string
 	torrent   storage.Torrent
+	priority  Priority
 	errc      chan error
 }
 
-// apply begins seeding / leeching a new torrent.
+// apply begins seeding / leeching a new torrent, or raises the priority of an
+// existing torrent.
 func (e newTorrentEvent) apply(s *state) {
 	ctrl, ok := s.torrentControls[e.torrent.InfoHash()]
 	if !ok {
@@ -330,6 +441,9 @@ func (e newTorrentEvent) apply(s *state) {
 		}
 		s.log("torrent", e.torrent).Info("Added new torrent")
 	}
+	if !ok || e.priority < ctrl.priority {
+		s.setTorrentPriority(e.torrent.InfoHash(), e.priority)
+	}
 	if ctrl.dispatcher.Complete() {
 		e.errc <- nil
 		return
@@ -337,7 +451,8 @@ func (e newTorrentEvent) apply(s *state) {
 	ctrl.errors = append(ctrl.errors, e.errc)
 
 	// Immediately announce new torrents.
-	go s.sched.announce(ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(), ctrl.dispatcher.Complete())
+	go s.sched.announce(
+		ctrl.namespace, ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(), ctrl.dispatcher.Complete())
 }
 
 // dispatcherCompleteEvent occurs when a dispatcher finishes downloading its torrent.
@@ -356,6 +471,8 @@ func (e dispatcherCompleteEvent) apply(s *state) {
 		s.log("dispatcher", e.dispatcher).Error("Completed dispatcher not found")
 		return
 	}
+	s.persistBlacklist(infoHash)
+	ctrl.completedAt = s.sched.clock.Now()
 	for _, errc := range ctrl.errors {
 		errc <- nil
 	}
@@ -374,7 +491,7 @@ func (e dispatcherCompleteEvent) apply(s *state) {
 	s.sched.netevents.Produce(networkevent.TorrentCompleteEvent(infoHash, s.sched.pctx.PeerID))
 
 	// Immediately announce completed torrents.
-	go s.sched.announce(ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(), true)
+	go s.sched.announce(ctrl.namespace, ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(), true)
 }
 
 // peerRemovedEvent occurs when a dispatcher removes a peer with a closed
@@ -416,16 +533,34 @@ func (e preemptionTickEvent) apply(s *state) {
 	}
 
 	for h, ctrl := range s.torrentControls {
+		if ctrl.paused {
+			continue
+		}
+		// Paused time does not count towards idleness.
+		lastRead := timeutil.MostRecent(ctrl.dispatcher.LastReadTime(), ctrl.resumedAt)
+		lastWrite := timeutil.MostRecent(ctrl.dispatcher.LastWriteTime(), ctrl.resumedAt)
+
+		policy := s.sched.seeding.match(ctrl.namespace)
+		seeded := ctrl.dispatcher.Complete() &&
+			policy.minSeedTimeElapsed(ctrl, s.sched.clock.Now())
+
+		if seeded && policy.targetRatioReached(ctrl) {
+			s.log("hash", h).Info("Removing torrent which reached its target seeding ratio")
+			s.sched.stats.Counter("seeding_ratio_reached").Inc(1)
+			s.removeTorrent(h, ErrTorrentSeeded)
+			continue
+		}
+
 		idleSeeder :=
-			ctrl.dispatcher.Complete() &&
-				s.sched.clock.Now().Sub(ctrl.dispatcher.LastReadTime()) >= s.sched.config.SeederTTI
+			seeded &&
+				s.sched.clock.Now().Sub(lastRead) >= s.sched.config.SeederTTI
 		if idleSeeder {
 			s.sched.torrentlog.SeedTimeout(ctrl.dispatcher.Digest(), h)
 		}
 
 		idleLeecher :=
 			!ctrl.dispatcher.Complete() &&
-				s.sched.clock.Now().Sub(ctrl.dispatcher.LastWriteTime()) >= s.sched.config.LeecherTTI
+				s.sched.clock.Now().Sub(lastWrite) >= s.sched.config.LeecherTTI
 		if idleLeecher {
 			s.sched.torrentlog.LeechTimeout(ctrl.dispatcher.Digest(), h)
 		}
@@ -435,6 +570,12 @@ func (e preemptionTickEvent) apply(s *state) {
 			s.removeTorrent(h, ErrTorrentTimeout)
 		}
 	}
+
+	for _, h := range s.seedersOverLimit() {
+		s.log("hash", h).Info("Removing torrent over its namespace seeding limit")
+		s.sched.stats.Counter("seeding_limit_exceeded").Inc(1)
+		s.removeTorrent(h, ErrTorrentSeeded)
+	}
 }
 
 // emitStatsEvent occurs periodically to emit scheduler stats.
@@ -470,6 +611,58 @@ func (e removeTorrentEvent) apply(s *state) {
 	e.errc <- s.sched.torrentArchive.DeleteTorrent(e.digest)
 }
 
+// pauseTorrentEvent occurs when a torrent is paused via scheduler API.
+type pauseTorrentEvent struct {
+	digest core.Digest
+	errc   chan error
+}
+
+func (e pauseTorrentEvent) apply(s *state) {
+	for h, ctrl := range s.torrentControls {
+		if ctrl.dispatcher.Digest() == e.digest {
+			s.log("hash", h).Info("Pausing torrent")
+			s.pauseTorrent(h)
+			e.errc <- nil
+			return
+		}
+	}
+	e.errc <- ErrTorrentNotFound
+}
+
+// resumeTorrentEvent occurs when a torrent is resumed via scheduler API.
+type resumeTorrentEvent struct {
+	digest core.Digest
+	errc   chan error
+}
+
+func (e resumeTorrentEvent) apply(s *state) {
+	for h, ctrl := range s.torrentControls {
+		if ctrl.dispatcher.Digest() == e.digest {
+			s.log("hash", h).Info("Resuming torrent")
+			s.resumeTorrent(h)
+			e.errc <- nil
+			return
+		}
+	}
+	e.errc <- ErrTorrentNotFound
+}
+
+// progressEvent occurs when torrent progress is requested via scheduler API.
+type progressEvent struct {
+	digest core.Digest
+	result chan *storage.TorrentInfo
+}
+
+func (e progressEvent) apply(s *state) {
+	for _, ctrl := range s.torrentControls {
+		if ctrl.dispatcher.Digest() == e.digest {
+			e.result <- ctrl.dispatcher.Stat()
+			return
+		}
+	}
+	e.result <- nil
+}
+
 // probeEvent occurs when a probe is manually requested via scheduler API.
 // The event loop is unbuffered, so if a probe can be successfully sent, then
 // the event loop is healthy.
@@ -14,9 +14,11 @@
 package scheduler
 
 import (
+	"context"
 	"testing"
 	"time"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 	"github.com/uber-go/tally"
@@ -24,6 +26,7 @@ import (
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler/announcequeue"
+	"github.com/uber/kraken/lib/torrent/scheduler/announcer"
 	"github.com/uber/kraken/lib/torrent/scheduler/conn"
 	"github.com/uber/kraken/lib/torrent/scheduler/connstate"
 	"github.com/uber/kraken/lib/torrent/storage"
@@ -86,7 +89,7 @@ func newStateMocks(t *testing.T) (*stateMocks, func()) {
 		metainfoClient: metainfoClient,
 		announceClient: announceClient,
 		announceQueue:  announcequeue.New(),
-		torrentArchive: agentstorage.NewTorrentArchive(tally.NoopScope, cads, metainfoClient),
+		torrentArchive: agentstorage.NewTorrentArchive(agentstorage.Config{}, tally.NoopScope, clock.New(), cads, metainfoClient),
 		eventLoop:      &mockEventLoop{t, make(chan event)},
 	}
 	return mocks, cleanup.Run
@@ -111,10 +114,10 @@ func (m *stateMocks) newTorrent() storage.Torrent {
 	mi := core.MetaInfoFixture()
 
 	m.metainfoClient.EXPECT().
-		Download(_testNamespace, mi.Digest()).
+		Download(gomock.Any(), _testNamespace, mi.Digest()).
 		Return(mi, nil)
 
-	t, err := m.torrentArchive.CreateTorrent(_testNamespace, mi.Digest())
+	t, err := m.torrentArchive.CreateTorrent(context.Background(), _testNamespace, mi.Digest())
 	if err != nil {
 		panic(err)
 	}
@@ -139,6 +142,7 @@ func TestAnnounceTickEvent(t *testing.T) {
 	// First torrent should announce.
 	mocks.announceClient.EXPECT().
 		Announce(
+			_testNamespace,
 			ctrls[0].dispatcher.Digest(),
 			ctrls[0].dispatcher.InfoHash(),
 			false,
@@ -152,6 +156,50 @@ func TestAnnounceTickEvent(t *testing.T) {
 	})
 }
 
+func TestAnnounceTickEventBatchesTorrents(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newStateMocks(t)
+	defer cleanup()
+
+	state := mocks.newState(Config{
+		Announcer: announcer.Config{BatchSize: 3},
+	})
+
+	var ctrls []*torrentControl
+	for i := 0; i < 5; i++ {
+		c, err := state.addTorrent(_testNamespace, mocks.newTorrent(), true)
+		require.NoError(err)
+		ctrls = append(ctrls, c)
+	}
+
+	var items []announceclient.BatchItem
+	var results []announceclient.BatchResult
+	for _, c := range ctrls[:3] {
+		items = append(items, announceclient.BatchItem{
+			Namespace: _testNamespace,
+			Digest:    c.dispatcher.Digest(),
+			InfoHash:  c.dispatcher.InfoHash(),
+		})
+		results = append(results, announceclient.BatchResult{
+			InfoHash: c.dispatcher.InfoHash(),
+			Interval: time.Second,
+		})
+	}
+	results[2].Error = "some error"
+
+	mocks.announceClient.EXPECT().BatchAnnounce(items).Return(results, nil)
+
+	announceTickEvent{}.apply(state)
+
+	mocks.eventLoop.expect(announceResultEvent{infoHash: ctrls[0].dispatcher.InfoHash()})
+	mocks.eventLoop.expect(announceResultEvent{infoHash: ctrls[1].dispatcher.InfoHash()})
+	mocks.eventLoop.expect(announceErrEvent{
+		infoHash: ctrls[2].dispatcher.InfoHash(),
+		err:      results[2].Err(),
+	})
+}
+
 func TestAnnounceTickEventSkipsFullTorrents(t *testing.T) {
 	require := require.New(t)
 
@@ -184,6 +232,7 @@ func TestAnnounceTickEventSkipsFullTorrents(t *testing.T) {
 	// torrent.
 	mocks.announceClient.EXPECT().
 		Announce(
+			_testNamespace,
 			empty.dispatcher.Digest(),
 			empty.dispatcher.InfoHash(),
 			false,
@@ -216,6 +265,7 @@ func TestAnnounceTickEventSkipsFullTorrents(t *testing.T) {
 
 	mocks.announceClient.EXPECT().
 		Announce(
+			_testNamespace,
 			full.dispatcher.Digest(),
 			full.dispatcher.InfoHash(),
 			false,
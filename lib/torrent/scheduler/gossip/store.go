@@ -0,0 +1,254 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package gossip provides tracker-less peer discovery. Peers exchange the
+// peers they know to have a torrent during handshakes, so that when the
+// tracker is unavailable, torrents can still find peers.
+package gossip
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+)
+
+// Config defines Store configuration.
+type Config struct {
+
+	// Enabled enables gossiping peers during handshakes, and falling back to
+	// gossiped peers when announcing to the tracker fails.
+	Enabled bool `yaml:"enabled"`
+
+	// PeerTTL is how long learned peers are remembered.
+	PeerTTL time.Duration `yaml:"peer_ttl"`
+
+	// MaxPeersPerTorrent is the maximum number of peers remembered, and
+	// gossiped, per torrent.
+	MaxPeersPerTorrent int `yaml:"max_peers_per_torrent"`
+
+	// MaxTorrents is the maximum number of torrents whose peers are remembered.
+	MaxTorrents int `yaml:"max_torrents"`
+
+	// FallbackPeers is the number of peers handed out when announcing fails.
+	// Peers known to have the torrent are preferred, but any known peer may be
+	// tried, since origins and other seeders may have the torrent as well.
+	FallbackPeers int `yaml:"fallback_peers"`
+
+	// PEXInterval is the interval at which connected peers exchange the peers
+	// they are connected to (PEX).
+	PEXInterval time.Duration `yaml:"pex_interval"`
+
+	// DisablePEX disables peer exchange, in which case peers are only
+	// gossiped during handshakes.
+	DisablePEX bool `yaml:"disable_pex"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.PeerTTL == 0 {
+		c.PeerTTL = 30 * time.Minute
+	}
+	if c.MaxPeersPerTorrent == 0 {
+		c.MaxPeersPerTorrent = 20
+	}
+	if c.MaxTorrents == 0 {
+		c.MaxTorrents = 1000
+	}
+	if c.FallbackPeers == 0 {
+		c.FallbackPeers = 10
+	}
+	if c.PEXInterval == 0 {
+		c.PEXInterval = 30 * time.Second
+	}
+	return c
+}
+
+type entry struct {
+	peer      *core.PeerInfo
+	expiresAt time.Time
+}
+
+// Store remembers the peers of torrents learned from announce responses and
+// from the handshakes of other peers. Store is thread-safe.
+type Store struct {
+	config Config
+	clk    clock.Clock
+
+	mu       sync.Mutex
+	torrents map[core.InfoHash]map[core.PeerID]entry
+}
+
+// NewStore creates a new Store.
+func NewStore(config Config, clk clock.Clock) *Store {
+	return &Store{
+		config:   config.applyDefaults(),
+		clk:      clk,
+		torrents: make(map[core.InfoHash]map[core.PeerID]entry),
+	}
+}
+
+// PEXInterval returns the interval of peer exchange, or zero if peer exchange
+// is disabled.
+func (s *Store) PEXInterval() time.Duration {
+	if s.config.DisablePEX {
+		return 0
+	}
+	return s.config.PEXInterval
+}
+
+// Learn records peers known to have h. Learned peers replace the soonest to
+// expire peers once MaxPeersPerTorrent is reached.
+func (s *Store) Learn(h core.InfoHash, peers []*core.PeerInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(peers) == 0 {
+		return
+	}
+	m, ok := s.torrents[h]
+	if !ok {
+		s.evictTorrent()
+		m = make(map[core.PeerID]entry)
+		s.torrents[h] = m
+	}
+	expiresAt := s.clk.Now().Add(s.config.PeerTTL)
+	for _, p := range peers {
+		if _, ok := m[p.PeerID]; !ok && len(m) >= s.config.MaxPeersPerTorrent {
+			evictPeer(m)
+		}
+		m[p.PeerID] = entry{p, expiresAt}
+	}
+}
+
+// Peers returns the unexpired peers known to have h, most recently learned
+// first.
+func (s *Store) Peers(h core.InfoHash) []*core.PeerInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.peers(h)
+}
+
+// Fallback returns up to FallbackPeers peers to try in place of a failed
+// announce for h. Peers known to have h come first, followed by a random
+// selection of other known peers, origins first.
+func (s *Store) Fallback(h core.InfoHash) []*core.PeerInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := s.peers(h)
+	if len(result) >= s.config.FallbackPeers {
+		return result[:s.config.FallbackPeers]
+	}
+	seen := make(map[core.PeerID]bool)
+	for _, p := range result {
+		seen[p.PeerID] = true
+	}
+	var origins, others []*core.PeerInfo
+	now := s.clk.Now()
+	for oh, m := range s.torrents {
+		if oh == h {
+			continue
+		}
+		for peerID, e := range m {
+			if seen[peerID] || now.After(e.expiresAt) {
+				continue
+			}
+			seen[peerID] = true
+			if e.peer.Origin {
+				origins = append(origins, e.peer)
+			} else {
+				others = append(others, e.peer)
+			}
+		}
+	}
+	rand.Shuffle(len(origins), func(i, j int) { origins[i], origins[j] = origins[j], origins[i] })
+	rand.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
+	for _, p := range append(origins, others...) {
+		if len(result) == s.config.FallbackPeers {
+			break
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+func (s *Store) peers(h core.InfoHash) []*core.PeerInfo {
+	now := s.clk.Now()
+	var entries []entry
+	for peerID, e := range s.torrents[h] {
+		if now.After(e.expiresAt) {
+			delete(s.torrents[h], peerID)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if len(s.torrents[h]) == 0 {
+		delete(s.torrents, h)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].expiresAt.After(entries[j].expiresAt)
+	})
+	peers := make([]*core.PeerInfo, len(entries))
+	for i, e := range entries {
+		peers[i] = e.peer
+	}
+	return peers
+}
+
+// evictTorrent makes room for a new torrent by removing expired torrents, or
+// the torrent whose peers were least recently learned.
+func (s *Store) evictTorrent() {
+	if len(s.torrents) < s.config.MaxTorrents {
+		return
+	}
+	now := s.clk.Now()
+	var oldest core.InfoHash
+	var oldestExpiresAt time.Time
+	for h, m := range s.torrents {
+		var expiresAt time.Time
+		for _, e := range m {
+			if e.expiresAt.After(expiresAt) {
+				expiresAt = e.expiresAt
+			}
+		}
+		if now.After(expiresAt) {
+			delete(s.torrents, h)
+			continue
+		}
+		if oldestExpiresAt.IsZero() || expiresAt.Before(oldestExpiresAt) {
+			oldest = h
+			oldestExpiresAt = expiresAt
+		}
+	}
+	if len(s.torrents) >= s.config.MaxTorrents {
+		delete(s.torrents, oldest)
+	}
+}
+
+// evictPeer removes the soonest to expire peer of m.
+func evictPeer(m map[core.PeerID]entry) {
+	var oldest core.PeerID
+	var oldestExpiresAt time.Time
+	for peerID, e := range m {
+		if oldestExpiresAt.IsZero() || e.expiresAt.Before(oldestExpiresAt) {
+			oldest = peerID
+			oldestExpiresAt = e.expiresAt
+		}
+	}
+	delete(m, oldest)
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package gossip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func peerIDs(peers []*core.PeerInfo) []core.PeerID {
+	var ids []core.PeerID
+	for _, p := range peers {
+		ids = append(ids, p.PeerID)
+	}
+	return ids
+}
+
+func TestStoreLearnAndPeers(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	s := NewStore(Config{}, clk)
+
+	h := core.InfoHashFixture()
+	p1 := core.PeerInfoFixture()
+	p2 := core.PeerInfoFixture()
+
+	s.Learn(h, []*core.PeerInfo{p1})
+	clk.Add(time.Second)
+	s.Learn(h, []*core.PeerInfo{p2})
+
+	require.Equal([]core.PeerID{p2.PeerID, p1.PeerID}, peerIDs(s.Peers(h)))
+	require.Empty(s.Peers(core.InfoHashFixture()))
+}
+
+func TestStorePeersExpire(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{PeerTTL: time.Minute}
+	clk := clock.NewMock()
+	s := NewStore(config, clk)
+
+	h := core.InfoHashFixture()
+	s.Learn(h, []*core.PeerInfo{core.PeerInfoFixture()})
+	require.Len(s.Peers(h), 1)
+
+	clk.Add(config.PeerTTL + time.Second)
+
+	require.Empty(s.Peers(h))
+}
+
+func TestStoreLearnEvictsOldestPeer(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	s := NewStore(Config{MaxPeersPerTorrent: 2}, clk)
+
+	h := core.InfoHashFixture()
+	p1 := core.PeerInfoFixture()
+	p2 := core.PeerInfoFixture()
+	p3 := core.PeerInfoFixture()
+
+	s.Learn(h, []*core.PeerInfo{p1})
+	clk.Add(time.Second)
+	s.Learn(h, []*core.PeerInfo{p2})
+	clk.Add(time.Second)
+	s.Learn(h, []*core.PeerInfo{p3})
+
+	require.Equal([]core.PeerID{p3.PeerID, p2.PeerID}, peerIDs(s.Peers(h)))
+}
+
+func TestStoreLearnEvictsOldestTorrent(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	s := NewStore(Config{MaxTorrents: 2}, clk)
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+	h3 := core.InfoHashFixture()
+
+	s.Learn(h1, []*core.PeerInfo{core.PeerInfoFixture()})
+	clk.Add(time.Second)
+	s.Learn(h2, []*core.PeerInfo{core.PeerInfoFixture()})
+	clk.Add(time.Second)
+	s.Learn(h3, []*core.PeerInfo{core.PeerInfoFixture()})
+
+	require.Empty(s.Peers(h1))
+	require.Len(s.Peers(h2), 1)
+	require.Len(s.Peers(h3), 1)
+}
+
+func TestStoreFallbackPrefersTorrentPeersThenOrigins(t *testing.T) {
+	require := require.New(t)
+
+	s := NewStore(Config{FallbackPeers: 3}, clock.NewMock())
+
+	h := core.InfoHashFixture()
+	p := core.PeerInfoFixture()
+	s.Learn(h, []*core.PeerInfo{p})
+
+	origin := core.OriginPeerInfoFixture()
+	s.Learn(core.InfoHashFixture(), []*core.PeerInfo{
+		core.PeerInfoFixture(), core.PeerInfoFixture(), origin})
+
+	fallback := s.Fallback(h)
+	require.Len(fallback, 3)
+	require.Equal(p.PeerID, fallback[0].PeerID)
+	require.Equal(origin.PeerID, fallback[1].PeerID)
+}
+
+func TestStoreFallbackDeduplicatesPeers(t *testing.T) {
+	require := require.New(t)
+
+	s := NewStore(Config{}, clock.NewMock())
+
+	h := core.InfoHashFixture()
+	p := core.PeerInfoFixture()
+	s.Learn(h, []*core.PeerInfo{p})
+	s.Learn(core.InfoHashFixture(), []*core.PeerInfo{p})
+
+	require.Equal([]core.PeerID{p.PeerID}, peerIDs(s.Fallback(h)))
+}
@@ -0,0 +1,359 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package lpd implements local peer discovery. Peers periodically multicast
+// the torrents they have to their local network segment, allowing peers on the
+// same segment to find each other without the tracker.
+package lpd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+	"go.uber.org/zap"
+)
+
+// maxDatagramSize is the maximum size of sent announcements, chosen to avoid
+// fragmentation on typical networks.
+const maxDatagramSize = 1400
+
+// Config defines Discovery configuration.
+type Config struct {
+
+	// Enabled enables local peer discovery.
+	Enabled bool `yaml:"enabled"`
+
+	// Group is the multicast "ip:port" which announcements are sent to.
+	Group string `yaml:"group"`
+
+	// Interface is the name of the network interface to multicast on. Defaults
+	// to the system default interface.
+	Interface string `yaml:"interface"`
+
+	// Interval is the interval at which torrents are announced.
+	Interval time.Duration `yaml:"interval"`
+
+	// PeerTTL is how long discovered peers are remembered without being
+	// announced again.
+	PeerTTL time.Duration `yaml:"peer_ttl"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.Group == "" {
+		c.Group = "239.192.152.143:6771"
+	}
+	if c.Interval == 0 {
+		c.Interval = 10 * time.Second
+	}
+	if c.PeerTTL == 0 {
+		c.PeerTTL = 3 * c.Interval
+	}
+	return c
+}
+
+// announcement is the multicast message of a peer's torrents. Queries ask
+// peers which have any of the torrents to announce them immediately, instead
+// of waiting for the next interval.
+type announcement struct {
+	PeerID     string   `json:"peer_id"`
+	Port       int      `json:"port"`
+	Origin     bool     `json:"origin,omitempty"`
+	Zone       string   `json:"zone,omitempty"`
+	Rack       string   `json:"rack,omitempty"`
+	InfoHashes []string `json:"info_hashes"`
+	Query      bool     `json:"query,omitempty"`
+}
+
+type entry struct {
+	peer      *core.PeerInfo
+	expiresAt time.Time
+}
+
+// Discovery announces local torrents to, and discovers peers from, the local
+// network segment. Discovery is thread-safe.
+type Discovery struct {
+	config Config
+	pctx   core.PeerContext
+	clk    clock.Clock
+	logger *zap.SugaredLogger
+
+	group    *net.UDPAddr
+	listener *net.UDPConn
+	sender   *net.UDPConn
+
+	mu       sync.Mutex
+	torrents map[core.InfoHash]bool
+	peers    map[core.InfoHash]map[core.PeerID]entry
+
+	done     chan struct{}
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// New creates a new Discovery which listens on the configured multicast group.
+// Announcements begin once Start is called.
+func New(
+	config Config,
+	pctx core.PeerContext,
+	clk clock.Clock,
+	logger *zap.SugaredLogger) (*Discovery, error) {
+
+	config = config.applyDefaults()
+
+	group, err := net.ResolveUDPAddr("udp4", config.Group)
+	if err != nil {
+		return nil, fmt.Errorf("resolve group: %s", err)
+	}
+	var ifi *net.Interface
+	if config.Interface != "" {
+		ifi, err = net.InterfaceByName(config.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("interface: %s", err)
+		}
+	}
+	listener, err := net.ListenMulticastUDP("udp4", ifi, group)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %s", err)
+	}
+	sender, err := net.DialUDP("udp4", nil, group)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("dial: %s", err)
+	}
+	return newDiscovery(config, pctx, clk, logger, group, listener, sender), nil
+}
+
+func newDiscovery(
+	config Config,
+	pctx core.PeerContext,
+	clk clock.Clock,
+	logger *zap.SugaredLogger,
+	group *net.UDPAddr,
+	listener *net.UDPConn,
+	sender *net.UDPConn) *Discovery {
+
+	return &Discovery{
+		config:   config,
+		pctx:     pctx,
+		clk:      clk,
+		logger:   logger,
+		group:    group,
+		listener: listener,
+		sender:   sender,
+		torrents: make(map[core.InfoHash]bool),
+		peers:    make(map[core.InfoHash]map[core.PeerID]entry),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start begins announcing torrents and listening for announcements.
+func (d *Discovery) Start() {
+	d.wg.Add(2)
+	go d.announceLoop()
+	go d.listenLoop()
+}
+
+// Stop stops d.
+func (d *Discovery) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.done)
+		d.listener.Close()
+		d.sender.Close()
+		d.wg.Wait()
+	})
+}
+
+// Add begins announcing h. Incomplete torrents immediately query for peers.
+func (d *Discovery) Add(h core.InfoHash, complete bool) {
+	d.mu.Lock()
+	d.torrents[h] = true
+	d.mu.Unlock()
+
+	if !complete {
+		d.send(d.newAnnouncement([]core.InfoHash{h}, true))
+	}
+}
+
+// Remove stops announcing h.
+func (d *Discovery) Remove(h core.InfoHash) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.torrents, h)
+}
+
+// Peers returns the unexpired local peers which announced h.
+func (d *Discovery) Peers(h core.InfoHash) []*core.PeerInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.clk.Now()
+	var peers []*core.PeerInfo
+	for peerID, e := range d.peers[h] {
+		if now.After(e.expiresAt) {
+			delete(d.peers[h], peerID)
+			continue
+		}
+		peers = append(peers, e.peer)
+	}
+	if len(d.peers[h]) == 0 {
+		delete(d.peers, h)
+	}
+	return peers
+}
+
+func (d *Discovery) announceLoop() {
+	defer d.wg.Done()
+
+	ticker := d.clk.Ticker(d.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.announce()
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *Discovery) listenLoop() {
+	defer d.wg.Done()
+
+	buf := make([]byte, 65536)
+	for {
+		n, src, err := d.listener.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-d.done:
+				return
+			default:
+			}
+			d.logger.Infof("Error reading local peer discovery announcement: %s", err)
+			continue
+		}
+		if reply := d.handle(buf[:n], src); reply != nil {
+			d.send(reply)
+		}
+	}
+}
+
+// announce sends announcements of all local torrents.
+func (d *Discovery) announce() {
+	d.mu.Lock()
+	hashes := make([]core.InfoHash, 0, len(d.torrents))
+	for h := range d.torrents {
+		hashes = append(hashes, h)
+	}
+	d.mu.Unlock()
+
+	for _, a := range d.split(hashes) {
+		d.send(a)
+	}
+}
+
+// split splits hashes into announcements which fit within maxDatagramSize.
+func (d *Discovery) split(hashes []core.InfoHash) []*announcement {
+	var result []*announcement
+	a := d.newAnnouncement(nil, false)
+	for _, h := range hashes {
+		a.InfoHashes = append(a.InfoHashes, h.Hex())
+		if b, err := json.Marshal(a); err == nil && len(b) > maxDatagramSize {
+			a.InfoHashes = a.InfoHashes[:len(a.InfoHashes)-1]
+			result = append(result, a)
+			a = d.newAnnouncement([]core.InfoHash{h}, false)
+		}
+	}
+	if len(a.InfoHashes) > 0 {
+		result = append(result, a)
+	}
+	return result
+}
+
+// handle records the peer of an announcement received from src. Returns an
+// announcement to reply with if the announcement is a query for torrents d
+// has.
+func (d *Discovery) handle(b []byte, src *net.UDPAddr) *announcement {
+	var a announcement
+	if err := json.Unmarshal(b, &a); err != nil {
+		d.logger.Infof("Error parsing local peer discovery announcement from %s: %s", src, err)
+		return nil
+	}
+	peerID, err := core.NewPeerID(a.PeerID)
+	if err != nil {
+		d.logger.Infof("Invalid peer id in local peer discovery announcement from %s: %s", src, err)
+		return nil
+	}
+	if peerID == d.pctx.PeerID || a.Port <= 0 {
+		return nil
+	}
+	p := core.NewPeerInfo(peerID, src.IP.String(), a.Port, a.Origin, false)
+	p.Zone = a.Zone
+	p.Rack = a.Rack
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	expiresAt := d.clk.Now().Add(d.config.PeerTTL)
+	var matches []core.InfoHash
+	for _, hex := range a.InfoHashes {
+		h, err := core.NewInfoHashFromHex(hex)
+		if err != nil {
+			continue
+		}
+		if _, ok := d.peers[h]; !ok {
+			d.peers[h] = make(map[core.PeerID]entry)
+		}
+		d.peers[h][peerID] = entry{p, expiresAt}
+		if a.Query && d.torrents[h] {
+			matches = append(matches, h)
+		}
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+	return d.newAnnouncement(matches, false)
+}
+
+func (d *Discovery) newAnnouncement(hashes []core.InfoHash, query bool) *announcement {
+	a := &announcement{
+		PeerID: d.pctx.PeerID.String(),
+		Port:   d.pctx.Port,
+		Origin: d.pctx.Origin,
+		Zone:   d.pctx.Zone,
+		Rack:   d.pctx.Rack,
+		Query:  query,
+	}
+	for _, h := range hashes {
+		a.InfoHashes = append(a.InfoHashes, h.Hex())
+	}
+	return a
+}
+
+func (d *Discovery) send(a *announcement) {
+	b, err := json.Marshal(a)
+	if err != nil {
+		d.logger.Errorf("Error marshaling local peer discovery announcement: %s", err)
+		return
+	}
+	if _, err := d.sender.Write(b); err != nil {
+		d.logger.Infof("Error sending local peer discovery announcement: %s", err)
+	}
+}
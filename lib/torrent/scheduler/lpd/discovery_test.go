@@ -0,0 +1,163 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package lpd
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func discoveryFixture(clk clock.Clock) *Discovery {
+	return newDiscovery(
+		Config{}.applyDefaults(),
+		core.PeerContextFixture(),
+		clk,
+		zap.NewNop().Sugar(),
+		nil,
+		nil,
+		nil)
+}
+
+func encode(t *testing.T, a *announcement) []byte {
+	b, err := json.Marshal(a)
+	require.NoError(t, err)
+	return b
+}
+
+func TestDiscoveryHandleRecordsPeers(t *testing.T) {
+	require := require.New(t)
+
+	d := discoveryFixture(clock.NewMock())
+
+	remote := discoveryFixture(clock.NewMock())
+	h := core.InfoHashFixture()
+	src := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 6771}
+
+	require.Nil(d.handle(encode(t, remote.newAnnouncement([]core.InfoHash{h}, false)), src))
+
+	peers := d.Peers(h)
+	require.Len(peers, 1)
+	require.Equal(remote.pctx.PeerID, peers[0].PeerID)
+	require.Equal("10.0.0.1", peers[0].IP)
+	require.Equal(remote.pctx.Port, peers[0].Port)
+}
+
+func TestDiscoveryHandleIgnoresOwnAnnouncements(t *testing.T) {
+	require := require.New(t)
+
+	d := discoveryFixture(clock.NewMock())
+
+	h := core.InfoHashFixture()
+	src := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 6771}
+
+	require.Nil(d.handle(encode(t, d.newAnnouncement([]core.InfoHash{h}, true)), src))
+	require.Empty(d.Peers(h))
+}
+
+func TestDiscoveryHandleRepliesToQueries(t *testing.T) {
+	require := require.New(t)
+
+	d := discoveryFixture(clock.NewMock())
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+	d.torrents[h1] = true
+
+	remote := discoveryFixture(clock.NewMock())
+	src := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 6771}
+
+	reply := d.handle(encode(t, remote.newAnnouncement([]core.InfoHash{h1, h2}, true)), src)
+	require.NotNil(reply)
+	require.False(reply.Query)
+	require.Equal([]string{h1.Hex()}, reply.InfoHashes)
+
+	// Announcements which are not queries are not replied to.
+	require.Nil(d.handle(encode(t, remote.newAnnouncement([]core.InfoHash{h1}, false)), src))
+}
+
+func TestDiscoveryPeersExpire(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	d := discoveryFixture(clk)
+
+	remote := discoveryFixture(clock.NewMock())
+	h := core.InfoHashFixture()
+	src := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 6771}
+
+	d.handle(encode(t, remote.newAnnouncement([]core.InfoHash{h}, false)), src)
+	require.Len(d.Peers(h), 1)
+
+	clk.Add(d.config.PeerTTL + time.Second)
+
+	require.Empty(d.Peers(h))
+}
+
+func TestDiscoverySplitsLargeAnnouncements(t *testing.T) {
+	require := require.New(t)
+
+	d := discoveryFixture(clock.NewMock())
+
+	var hashes []core.InfoHash
+	for i := 0; i < 100; i++ {
+		hashes = append(hashes, core.InfoHashFixture())
+	}
+
+	var n int
+	for _, a := range d.split(hashes) {
+		require.True(len(encode(t, a)) <= maxDatagramSize)
+		n += len(a.InfoHashes)
+	}
+	require.Equal(len(hashes), n)
+}
+
+func TestDiscoveryAnnounceOverLoopback(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+
+	listener, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(err)
+	addr := listener.LocalAddr().(*net.UDPAddr)
+	sender, err := net.DialUDP("udp4", nil, addr)
+	require.NoError(err)
+
+	d1 := discoveryFixture(clk)
+	d2 := newDiscovery(
+		Config{}.applyDefaults(),
+		core.PeerContextFixture(),
+		clk,
+		zap.NewNop().Sugar(),
+		addr,
+		listener,
+		sender)
+	d2.Start()
+	defer d2.Stop()
+
+	h := core.InfoHashFixture()
+
+	d2.send(d1.newAnnouncement([]core.InfoHash{h}, false))
+
+	require.NoError(testutil.PollUntilTrue(
+		5*time.Second, func() bool { return len(d2.Peers(h)) == 1 }))
+	require.Equal(d1.pctx.PeerID, d2.Peers(h)[0].PeerID)
+}
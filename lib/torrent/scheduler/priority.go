@@ -0,0 +1,76 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package scheduler
+
+import "fmt"
+
+// Priority is the priority class of a torrent download.
+type Priority int
+
+// Priorities, from highest to lowest.
+const (
+	// PriorityInteractive is for downloads which block a caller, e.g. image
+	// pulls which block container startup.
+	PriorityInteractive Priority = iota
+
+	// PriorityBackground is for downloads which no one is waiting on, e.g.
+	// preheats. Background torrents use fewer connections and pipeline fewer
+	// piece requests, leaving bandwidth for interactive torrents.
+	PriorityBackground
+)
+
+// ParsePriority parses a Priority from its name.
+func ParsePriority(s string) (Priority, error) {
+	switch s {
+	case "interactive":
+		return PriorityInteractive, nil
+	case "background":
+		return PriorityBackground, nil
+	default:
+		return 0, fmt.Errorf("unknown priority %q", s)
+	}
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityInteractive:
+		return "interactive"
+	case PriorityBackground:
+		return "background"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+}
+
+// BackgroundConfig defines the limits of background priority torrents.
+type BackgroundConfig struct {
+
+	// MaxOpenConnectionsPerTorrent is the maximum number of connections for
+	// each background torrent.
+	MaxOpenConnectionsPerTorrent int `yaml:"max_open_conn"`
+
+	// PipelineLimit is the maximum number of pending piece requests per peer
+	// for each background torrent.
+	PipelineLimit int `yaml:"pipeline_limit"`
+}
+
+func (c BackgroundConfig) applyDefaults() BackgroundConfig {
+	if c.MaxOpenConnectionsPerTorrent == 0 {
+		c.MaxOpenConnectionsPerTorrent = 5
+	}
+	if c.PipelineLimit == 0 {
+		c.PipelineLimit = 1
+	}
+	return c
+}
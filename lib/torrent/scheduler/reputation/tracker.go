@@ -0,0 +1,206 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package reputation
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/memsize"
+
+	"github.com/andres-erbsen/clock"
+)
+
+// Config defines how peers are scored.
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+
+	// HalfLife is the duration after which past behavior counts half as much
+	// towards scores, such that peers recover from transient problems.
+	HalfLife time.Duration `yaml:"half_life"`
+
+	// MinScore is the score below which connections to and from peers are
+	// refused.
+	MinScore float64 `yaml:"min_score"`
+
+	// CorruptPenalty is how many failed piece requests a corrupt piece counts
+	// as.
+	CorruptPenalty float64 `yaml:"corrupt_penalty"`
+
+	// SlowThroughput is the piece throughput, in bytes per second, below which
+	// peers are considered slow. Slow peers have their scores reduced by up to
+	// half, such that they are deprioritized but not refused.
+	SlowThroughput uint64 `yaml:"slow_throughput"`
+
+	// MaxPeers bounds the number of peers whose history is kept.
+	MaxPeers int `yaml:"max_peers"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.HalfLife == 0 {
+		c.HalfLife = 10 * time.Minute
+	}
+	if c.MinScore == 0 {
+		c.MinScore = 0.25
+	}
+	if c.CorruptPenalty == 0 {
+		c.CorruptPenalty = 5
+	}
+	if c.SlowThroughput == 0 {
+		c.SlowThroughput = memsize.MB
+	}
+	if c.MaxPeers == 0 {
+		c.MaxPeers = 10000
+	}
+	return c
+}
+
+// record is the decayed history of a peer.
+type record struct {
+	good       float64
+	failed     float64
+	corrupt    float64
+	throughput float64 // Bytes per second, 0 if unknown.
+	updatedAt  time.Time
+}
+
+// decay ages r to now.
+func (r *record) decay(now time.Time, halfLife time.Duration) {
+	f := math.Pow(0.5, float64(now.Sub(r.updatedAt))/float64(halfLife))
+	r.good *= f
+	r.failed *= f
+	r.corrupt *= f
+	r.updatedAt = now
+}
+
+// Tracker tracks the historical behavior of peers across torrents and scores
+// them between 0 and 1, where 1 is a reliable, fast peer. Peers without
+// history score 1.
+type Tracker struct {
+	config Config
+	clk    clock.Clock
+
+	mu      sync.Mutex
+	records map[core.PeerID]*record
+}
+
+// New creates a new Tracker.
+func New(config Config, clk clock.Clock) *Tracker {
+	return &Tracker{
+		config:  config.applyDefaults(),
+		clk:     clk,
+		records: make(map[core.PeerID]*record),
+	}
+}
+
+// update applies f to the decayed record of peerID. Assumes t is locked.
+func (t *Tracker) update(peerID core.PeerID, f func(*record)) {
+	now := t.clk.Now()
+	r, ok := t.records[peerID]
+	if !ok {
+		if len(t.records) >= t.config.MaxPeers {
+			t.evictOldest()
+		}
+		r = &record{updatedAt: now}
+		t.records[peerID] = r
+	}
+	r.decay(now, t.config.HalfLife)
+	f(r)
+}
+
+// evictOldest removes the least recently updated record. Assumes t is locked.
+func (t *Tracker) evictOldest() {
+	var oldest core.PeerID
+	var oldestAt time.Time
+	for peerID, r := range t.records {
+		if oldestAt.IsZero() || r.updatedAt.Before(oldestAt) {
+			oldest = peerID
+			oldestAt = r.updatedAt
+		}
+	}
+	delete(t.records, oldest)
+}
+
+// PieceReceived records a valid piece of the given length received from peerID,
+// elapsed after it was requested.
+func (t *Tracker) PieceReceived(peerID core.PeerID, length int64, elapsed time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.update(peerID, func(r *record) {
+		r.good++
+		if elapsed <= 0 {
+			return
+		}
+		sample := float64(length) / elapsed.Seconds()
+		if r.throughput == 0 {
+			r.throughput = sample
+		} else {
+			r.throughput = 0.8*r.throughput + 0.2*sample
+		}
+	})
+}
+
+// PieceCorrupt records an invalid piece received from peerID, e.g. one which
+// failed hash verification.
+func (t *Tracker) PieceCorrupt(peerID core.PeerID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.update(peerID, func(r *record) { r.corrupt++ })
+}
+
+// RequestFailed records a piece request which peerID failed to serve.
+func (t *Tracker) RequestFailed(peerID core.PeerID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.update(peerID, func(r *record) { r.failed++ })
+}
+
+// Score returns the score of peerID.
+func (t *Tracker) Score(peerID core.PeerID) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r, ok := t.records[peerID]
+	if !ok {
+		return 1
+	}
+	r.decay(t.clk.Now(), t.config.HalfLife)
+
+	reliability := (r.good + 1) / (r.good + 1 + r.failed + t.config.CorruptPenalty*r.corrupt)
+	speed := 1.0
+	if r.throughput > 0 {
+		speed = 0.5 + 0.5*math.Min(1, r.throughput/float64(t.config.SlowThroughput))
+	}
+	return reliability * speed
+}
+
+// Admit returns false if connections to and from peerID should be refused.
+func (t *Tracker) Admit(peerID core.PeerID) bool {
+	return t.Score(peerID) >= t.config.MinScore
+}
+
+// Limit scales limit, the maximum number of pending piece requests to
+// peerID, by its score. Every peer may have at least one pending request.
+func (t *Tracker) Limit(peerID core.PeerID, limit int) int {
+	scaled := int(math.Round(float64(limit) * t.Score(peerID)))
+	if scaled < 1 {
+		return 1
+	}
+	return scaled
+}
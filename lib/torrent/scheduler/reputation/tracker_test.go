@@ -0,0 +1,134 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package reputation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/memsize"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTracker(clk clock.Clock) *Tracker {
+	return New(Config{
+		Enabled:        true,
+		HalfLife:       time.Minute,
+		MinScore:       0.25,
+		CorruptPenalty: 5,
+		SlowThroughput: memsize.MB,
+	}, clk)
+}
+
+func TestTrackerUnknownPeerScoresOne(t *testing.T) {
+	require := require.New(t)
+
+	tr := newTestTracker(clock.NewMock())
+
+	peerID := core.PeerIDFixture()
+	require.Equal(1.0, tr.Score(peerID))
+	require.True(tr.Admit(peerID))
+	require.Equal(8, tr.Limit(peerID, 8))
+}
+
+func TestTrackerFastReliablePeerKeepsFullScore(t *testing.T) {
+	require := require.New(t)
+
+	tr := newTestTracker(clock.NewMock())
+
+	peerID := core.PeerIDFixture()
+	for i := 0; i < 10; i++ {
+		tr.PieceReceived(peerID, int64(2*memsize.MB), time.Second)
+	}
+	require.Equal(1.0, tr.Score(peerID))
+}
+
+func TestTrackerSlowPeerIsDeprioritizedButAdmitted(t *testing.T) {
+	require := require.New(t)
+
+	tr := newTestTracker(clock.NewMock())
+
+	peerID := core.PeerIDFixture()
+	for i := 0; i < 10; i++ {
+		tr.PieceReceived(peerID, int64(memsize.KB), time.Second)
+	}
+	score := tr.Score(peerID)
+	require.True(score < 0.51)
+	require.True(score >= 0.5)
+	require.True(tr.Admit(peerID))
+	require.Equal(4, tr.Limit(peerID, 8))
+}
+
+func TestTrackerCorruptPeerIsRefused(t *testing.T) {
+	require := require.New(t)
+
+	tr := newTestTracker(clock.NewMock())
+
+	peerID := core.PeerIDFixture()
+	tr.PieceCorrupt(peerID)
+	require.True(tr.Score(peerID) < 0.25)
+	require.False(tr.Admit(peerID))
+	require.Equal(1, tr.Limit(peerID, 8))
+}
+
+func TestTrackerFailedRequestsLowerScore(t *testing.T) {
+	require := require.New(t)
+
+	tr := newTestTracker(clock.NewMock())
+
+	peerID := core.PeerIDFixture()
+	tr.PieceReceived(peerID, int64(memsize.MB), 0)
+	tr.RequestFailed(peerID)
+	tr.RequestFailed(peerID)
+	require.InDelta(0.5, tr.Score(peerID), 0.0001)
+}
+
+func TestTrackerPeerRecoversOverTime(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	tr := newTestTracker(clk)
+
+	peerID := core.PeerIDFixture()
+	tr.PieceCorrupt(peerID)
+	require.False(tr.Admit(peerID))
+
+	clk.Add(5 * time.Minute)
+
+	require.True(tr.Admit(peerID))
+}
+
+func TestTrackerEvictsOldestPeer(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	tr := New(Config{Enabled: true, MaxPeers: 2}, clk)
+
+	p1 := core.PeerIDFixture()
+	p2 := core.PeerIDFixture()
+	p3 := core.PeerIDFixture()
+
+	tr.PieceCorrupt(p1)
+	clk.Add(time.Second)
+	tr.PieceCorrupt(p2)
+	clk.Add(time.Second)
+	tr.PieceCorrupt(p3)
+
+	require.Equal(1.0, tr.Score(p1))
+	require.False(tr.Admit(p2))
+	require.False(tr.Admit(p3))
+}
@@ -14,6 +14,7 @@
 package scheduler
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -22,6 +23,7 @@ import (
 
 	"github.com/andres-erbsen/clock"
 	"github.com/uber-go/tally"
+	"github.com/willf/bitset"
 	"go.uber.org/zap"
 
 	"github.com/uber/kraken/core"
@@ -30,6 +32,10 @@ import (
 	"github.com/uber/kraken/lib/torrent/scheduler/announcer"
 	"github.com/uber/kraken/lib/torrent/scheduler/conn"
 	"github.com/uber/kraken/lib/torrent/scheduler/connstate"
+	"github.com/uber/kraken/lib/torrent/scheduler/dispatch"
+	"github.com/uber/kraken/lib/torrent/scheduler/gossip"
+	"github.com/uber/kraken/lib/torrent/scheduler/lpd"
+	"github.com/uber/kraken/lib/torrent/scheduler/reputation"
 	"github.com/uber/kraken/lib/torrent/scheduler/torrentlog"
 	"github.com/uber/kraken/lib/torrent/storage"
 	"github.com/uber/kraken/tracker/announceclient"
@@ -42,6 +48,7 @@ var (
 	ErrSchedulerStopped  = errors.New("scheduler has been stopped")
 	ErrTorrentTimeout    = errors.New("torrent timed out")
 	ErrTorrentRemoved    = errors.New("torrent manually removed")
+	ErrTorrentSeeded     = errors.New("torrent seeding policy satisfied")
 	ErrSendEventTimedOut = errors.New("event loop send timed out")
 )
 
@@ -49,9 +56,15 @@ var (
 type Scheduler interface {
 	Stop()
 	Download(namespace string, d core.Digest) error
+	DownloadWithPriority(namespace string, d core.Digest, priority Priority) error
 	BlacklistSnapshot() ([]connstate.BlacklistedConn, error)
 	RemoveTorrent(d core.Digest) error
+	PauseTorrent(d core.Digest) error
+	ResumeTorrent(d core.Digest) error
+	Progress(d core.Digest) (*storage.TorrentInfo, error)
 	Probe() error
+	BandwidthLimits() conn.BandwidthLimits
+	SetBandwidthLimits(limits conn.BandwidthLimits) error
 }
 
 // scheduler manages global state for the peer. This includes:
@@ -76,6 +89,7 @@ type scheduler struct {
 
 	preemptionTick <-chan time.Time
 	emitStatsTick  <-chan time.Time
+	pexTick        <-chan time.Time
 
 	// TODO(codyg): We only need this hold on this reference for reloading the scheduler...
 	announceClient announceclient.Client
@@ -86,19 +100,57 @@ type scheduler struct {
 
 	torrentlog *torrentlog.Logger
 
+	// uploadSlots is shared by all dispatchers to limit global upload slots.
+	uploadSlots *dispatch.UploadSlots
+
+	// blacklists persists connection blacklists. Nil if blacklists are not
+	// persisted.
+	blacklists storage.BlacklistStore
+
+	// seeding holds the seeding policies of namespaces.
+	seeding seedingPolicies
+
+	// writes signals backpressure when piece writes back up. Nil if the
+	// torrent archive does not track its writes.
+	writes *storage.WriteQueue
+
+	// reputation scores peers by their history. Nil if reputation is
+	// disabled.
+	reputation *reputation.Tracker
+
+	// webseed fetches pieces from origins. Nil if not configured.
+	webseed dispatch.Webseed
+
+	// gossip remembers peers for when the tracker is unavailable. Nil if
+	// gossip is disabled.
+	gossip *gossip.Store
+
+	// lpd discovers peers on the local network segment. Nil if local peer
+	// discovery is disabled.
+	lpd *lpd.Discovery
+
 	logger *zap.SugaredLogger
 
 	// The following fields orchestrate the stopping of the scheduler.
-	stopOnce sync.Once      // Ensures the stop sequence is executed only once.
-	done     chan struct{}  // Signals all goroutines to exit.
-	wg       sync.WaitGroup // Waits for eventLoop and listenLoop to exit.
+	stopOnce sync.Once          // Ensures the stop sequence is executed only once.
+	done     chan struct{}      // Signals all goroutines to exit.
+	wg       sync.WaitGroup     // Waits for eventLoop and listenLoop to exit.
+	ctx      context.Context    // Cancels torrent archive operations on stop.
+	cancel   context.CancelFunc // Cancels ctx.
+}
+
+// availabilityRecorder is implemented by torrent archives which cache swarm
+// availability learned from announce responses.
+type availabilityRecorder interface {
+	RecordSwarmAvailability(d core.Digest, peers []*core.PeerInfo)
 }
 
-// schedOverrides defines scheduler fields which may be overrided for testing
-// purposes.
+// schedOverrides defines optional scheduler dependencies, and scheduler fields
+// which may be overrided for testing purposes.
 type schedOverrides struct {
 	clock     clock.Clock
 	eventLoop eventLoop
+	webseed   dispatch.Webseed
 }
 
 type option func(*schedOverrides)
@@ -111,6 +163,13 @@ func withEventLoop(l eventLoop) option {
 	return func(o *schedOverrides) { o.eventLoop = l }
 }
 
+// withWebseed configures the scheduler to fetch pieces from w when swarms
+// cannot supply them. Only used if webseeding or SLOs are enabled in dispatch
+// config.
+func withWebseed(w dispatch.Webseed) option {
+	return func(o *schedOverrides) { o.webseed = w }
+}
+
 // newScheduler creates and starts a scheduler.
 func newScheduler(
 	config Config,
@@ -130,6 +189,7 @@ func newScheduler(
 	slogger := logger.Sugar()
 
 	done := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
 
 	stats = stats.Tagged(map[string]string{
 		"module": "scheduler",
@@ -150,8 +210,16 @@ func newScheduler(
 		preemptionTick = overrides.clock.Tick(config.PreemptionInterval)
 	}
 
+	var gossipStore *gossip.Store
+	var handshakeGossip conn.Gossip
+	if config.Gossip.Enabled {
+		gossipStore = gossip.NewStore(config.Gossip, overrides.clock)
+		handshakeGossip = gossipStore
+	}
+
 	handshaker, err := conn.NewHandshaker(
-		config.Conn, stats, overrides.clock, netevents, pctx.PeerID, eventLoop, slogger)
+		config.Conn, stats, overrides.clock, netevents, pctx.PeerID, eventLoop,
+		handshakeGossip, slogger)
 	if err != nil {
 		return nil, fmt.Errorf("conn: %s", err)
 	}
@@ -161,6 +229,43 @@ func newScheduler(
 		return nil, fmt.Errorf("torrentlog: %s", err)
 	}
 
+	var pexTick <-chan time.Time
+	if gossipStore != nil && gossipStore.PEXInterval() > 0 {
+		pexTick = overrides.clock.Tick(gossipStore.PEXInterval())
+	}
+
+	var discovery *lpd.Discovery
+	if config.LPD.Enabled {
+		discovery, err = lpd.New(config.LPD, pctx, overrides.clock, slogger)
+		if err != nil {
+			return nil, fmt.Errorf("lpd: %s", err)
+		}
+	}
+
+	seeding, err := newSeedingPolicies(config.SeedingPolicies)
+	if err != nil {
+		return nil, fmt.Errorf("seeding policies: %s", err)
+	}
+
+	var blacklists storage.BlacklistStore
+	if config.PersistBlacklist {
+		bs, ok := ta.(storage.BlacklistStore)
+		if !ok {
+			return nil, errors.New("torrent archive cannot persist blacklists")
+		}
+		blacklists = bs
+	}
+
+	var writes *storage.WriteQueue
+	if wb, ok := ta.(storage.WriteBackpressure); ok {
+		writes = wb.WriteQueue()
+	}
+
+	var rep *reputation.Tracker
+	if config.Reputation.Enabled {
+		rep = reputation.New(config.Reputation, overrides.clock)
+	}
+
 	s := &scheduler{
 		pctx:           pctx,
 		config:         config,
@@ -171,12 +276,23 @@ func newScheduler(
 		eventLoop:      eventLoop,
 		preemptionTick: preemptionTick,
 		emitStatsTick:  overrides.clock.Tick(config.EmitStatsInterval),
+		pexTick:        pexTick,
 		announceClient: announceClient,
-		announcer:      announcer.Default(announceClient, eventLoop, overrides.clock, slogger),
+		announcer:      announcer.New(config.Announcer, announceClient, eventLoop, overrides.clock, slogger),
 		netevents:      netevents,
 		torrentlog:     tlog,
+		uploadSlots:    dispatch.NewUploadSlots(config.Dispatch.Choke.GlobalUploadSlots),
+		blacklists:     blacklists,
+		seeding:        seeding,
+		writes:         writes,
+		reputation:     rep,
+		webseed:        overrides.webseed,
+		gossip:         gossipStore,
+		lpd:            discovery,
 		logger:         slogger,
 		done:           done,
+		ctx:            ctx,
+		cancel:         cancel,
 	}
 
 	if config.DisablePreemption {
@@ -202,7 +318,7 @@ func (s *scheduler) start(aq announcequeue.Queue) error {
 	if err != nil {
 		return err
 	}
-	s.listener = l
+	s.listener = s.handshaker.Listen(l)
 
 	s.wg.Add(4)
 	go s.runEventLoop(aq) // Careful, this should be the only reference to aq.
@@ -210,6 +326,10 @@ func (s *scheduler) start(aq announcequeue.Queue) error {
 	go s.tickerLoop()
 	go s.announceLoop()
 
+	if s.lpd != nil {
+		s.lpd.Start()
+	}
+
 	return nil
 }
 
@@ -219,7 +339,11 @@ func (s *scheduler) Stop() {
 		s.log().Info("Stopping scheduler...")
 
 		close(s.done)
+		s.cancel()
 		s.listener.Close()
+		if s.lpd != nil {
+			s.lpd.Stop()
+		}
 		s.eventLoop.send(shutdownEvent{})
 
 		// Waits for all loops to stop.
@@ -231,28 +355,42 @@ func (s *scheduler) Stop() {
 	})
 }
 
-func (s *scheduler) doDownload(namespace string, d core.Digest) (size int64, err error) {
-	t, err := s.torrentArchive.CreateTorrent(namespace, d)
+func (s *scheduler) doDownload(
+	namespace string, d core.Digest, priority Priority) (size int64, err error) {
+
+	t, err := s.torrentArchive.CreateTorrent(s.ctx, namespace, d)
 	if err != nil {
 		if err == storage.ErrNotFound {
 			return 0, ErrTorrentNotFound
 		}
+		if err == context.Canceled {
+			return 0, ErrSchedulerStopped
+		}
 		return 0, fmt.Errorf("create torrent: %s", err)
 	}
 
 	// Buffer size of 1 so sends do not block.
 	errc := make(chan error, 1)
-	if !s.eventLoop.send(newTorrentEvent{namespace, t, errc}) {
+	if !s.eventLoop.send(newTorrentEvent{namespace, t, priority, errc}) {
 		return 0, ErrSchedulerStopped
 	}
 	return t.Length(), <-errc
 }
 
-// Download downloads the torrent given metainfo. Once the torrent is downloaded,
-// it will begin seeding asynchronously.
+// Download downloads the torrent given metainfo with interactive priority. Once
+// the torrent is downloaded, it will begin seeding asynchronously.
 func (s *scheduler) Download(namespace string, d core.Digest) error {
+	return s.DownloadWithPriority(namespace, d, PriorityInteractive)
+}
+
+// DownloadWithPriority is like Download, but with the given priority. If the
+// torrent is already being downloaded with a lower priority, it is raised to
+// priority.
+func (s *scheduler) DownloadWithPriority(
+	namespace string, d core.Digest, priority Priority) error {
+
 	start := time.Now()
-	size, err := s.doDownload(namespace, d)
+	size, err := s.doDownload(namespace, d, priority)
 	if err != nil {
 		var errTag string
 		switch err {
@@ -299,11 +437,64 @@ func (s *scheduler) RemoveTorrent(d core.Digest) error {
 	return <-errc
 }
 
+// PauseTorrent stops leeching / seeding torrent for d until it is resumed, by
+// closing its connections and no longer announcing it. Downloaded pieces are
+// preserved, and pending downloads of d continue to wait. Returns
+// ErrTorrentNotFound if d is not being leeched or seeded.
+func (s *scheduler) PauseTorrent(d core.Digest) error {
+	// Buffer size of 1 so sends do not block.
+	errc := make(chan error, 1)
+	if !s.eventLoop.send(pauseTorrentEvent{d, errc}) {
+		return ErrSchedulerStopped
+	}
+	return <-errc
+}
+
+// ResumeTorrent resumes leeching / seeding torrent for d after PauseTorrent.
+// Returns ErrTorrentNotFound if d is not being leeched or seeded.
+func (s *scheduler) ResumeTorrent(d core.Digest) error {
+	// Buffer size of 1 so sends do not block.
+	errc := make(chan error, 1)
+	if !s.eventLoop.send(resumeTorrentEvent{d, errc}) {
+		return ErrSchedulerStopped
+	}
+	return <-errc
+}
+
+// Progress returns the TorrentInfo of the active torrent for d, including its
+// download rate. Returns ErrTorrentNotFound if d is not being leeched or
+// seeded.
+func (s *scheduler) Progress(d core.Digest) (*storage.TorrentInfo, error) {
+	// Buffer size of 1 so sends do not block.
+	result := make(chan *storage.TorrentInfo, 1)
+	if !s.eventLoop.send(progressEvent{d, result}) {
+		return nil, ErrSchedulerStopped
+	}
+	info := <-result
+	if info == nil {
+		return nil, ErrTorrentNotFound
+	}
+	return info, nil
+}
+
 // Probe verifies that the scheduler event loop is running and unblocked.
 func (s *scheduler) Probe() error {
 	return s.eventLoop.sendTimeout(probeEvent{}, s.config.ProbeTimeout)
 }
 
+// BandwidthLimits returns the global and per-namespace bandwidth limits
+// currently in effect.
+func (s *scheduler) BandwidthLimits() conn.BandwidthLimits {
+	return s.handshaker.BandwidthLimits()
+}
+
+// SetBandwidthLimits replaces the global and per-namespace bandwidth limits of
+// all connections without restarting the scheduler. Limits are reset to the
+// configured limits when the scheduler is reloaded.
+func (s *scheduler) SetBandwidthLimits(limits conn.BandwidthLimits) error {
+	return s.handshaker.SetBandwidthLimits(limits)
+}
+
 func (s *scheduler) runEventLoop(aq announcequeue.Queue) {
 	defer s.wg.Done()
 
@@ -344,6 +535,8 @@ func (s *scheduler) tickerLoop() {
 			s.eventLoop.send(preemptionTickEvent{})
 		case <-s.emitStatsTick:
 			s.eventLoop.send(emitStatsEvent{})
+		case <-s.pexTick:
+			s.eventLoop.send(pexTickEvent{})
 		case <-s.done:
 			return
 		}
@@ -357,17 +550,88 @@ func (s *scheduler) announceLoop() {
 	s.announcer.Ticker(s.done)
 }
 
-func (s *scheduler) announce(d core.Digest, h core.InfoHash, complete bool) {
-	peers, err := s.announcer.Announce(d, h, complete)
+func (s *scheduler) announce(namespace string, d core.Digest, h core.InfoHash, complete bool) {
+	peers, err := s.announcer.Announce(namespace, d, h, complete)
+	if err == announceclient.ErrDisabled {
+		return
+	}
+	s.handleAnnounce(d, h, complete, peers, err)
+}
+
+// batchAnnounce announces items together.
+func (s *scheduler) batchAnnounce(items []announceclient.BatchItem) {
+	results, err := s.announcer.BatchAnnounce(items)
+	if err == announceclient.ErrDisabled {
+		return
+	}
+	for i, item := range items {
+		if err != nil {
+			s.handleAnnounce(item.Digest, item.InfoHash, item.Complete, nil, err)
+			continue
+		}
+		r := results[i]
+		s.handleAnnounce(item.Digest, item.InfoHash, item.Complete, r.Peers, r.Err())
+	}
+}
+
+// handleAnnounce sends the result of announcing (d, h) to the event loop.
+func (s *scheduler) handleAnnounce(
+	d core.Digest, h core.InfoHash, complete bool, peers []*core.PeerInfo, err error) {
+
 	if err != nil {
-		if err != announceclient.ErrDisabled {
-			s.eventLoop.send(announceErrEvent{h, err})
+		if fallback := s.fallbackPeers(h, complete); len(fallback) > 0 {
+			s.log("hash", h).Infof(
+				"Error announcing, falling back to %d local and gossiped peers: %s", len(fallback), err)
+			s.stats.Counter("fallback_announces").Inc(1)
+			s.eventLoop.send(announceResultEvent{h, fallback})
+			return
 		}
+		s.eventLoop.send(announceErrEvent{h, err})
 		return
 	}
+	if s.gossip != nil {
+		s.gossip.Learn(h, peers)
+	}
+	if s.lpd != nil {
+		peers = mergePeers(peers, s.lpd.Peers(h))
+	}
+	if r, ok := s.torrentArchive.(availabilityRecorder); ok {
+		r.RecordSwarmAvailability(d, peers)
+	}
 	s.eventLoop.send(announceResultEvent{h, peers})
 }
 
+// fallbackPeers returns the peers to try for h when announcing fails, which are
+// peers discovered on the local network followed by gossiped peers.
+func (s *scheduler) fallbackPeers(h core.InfoHash, complete bool) []*core.PeerInfo {
+	if complete {
+		return nil
+	}
+	var peers []*core.PeerInfo
+	if s.lpd != nil {
+		peers = s.lpd.Peers(h)
+	}
+	if s.gossip != nil {
+		peers = mergePeers(peers, s.gossip.Fallback(h))
+	}
+	return peers
+}
+
+// mergePeers appends the peers of b which are not in a to a.
+func mergePeers(a, b []*core.PeerInfo) []*core.PeerInfo {
+	seen := make(map[core.PeerID]bool)
+	for _, p := range a {
+		seen[p.PeerID] = true
+	}
+	for _, p := range b {
+		if !seen[p.PeerID] {
+			seen[p.PeerID] = true
+			a = append(a, p)
+		}
+	}
+	return a
+}
+
 func (s *scheduler) failIncomingHandshake(pc *conn.PendingConn, err error) {
 	s.log(
 		"peer", pc.PeerID(),
@@ -379,12 +643,12 @@ func (s *scheduler) failIncomingHandshake(pc *conn.PendingConn, err error) {
 // establishIncomingHandshake attempts to establish a pending conn initialized
 // by a remote peer. Success / failure is communicated via events.
 func (s *scheduler) establishIncomingHandshake(pc *conn.PendingConn, rb conn.RemoteBitfields) {
-	info, err := s.torrentArchive.Stat(pc.Namespace(), pc.Digest())
+	info, err := s.torrentArchive.Stat(s.ctx, pc.Namespace(), pc.Digest())
 	if err != nil {
 		s.failIncomingHandshake(pc, fmt.Errorf("torrent stat: %s", err))
 		return
 	}
-	c, err := s.handshaker.Establish(pc, info, rb)
+	c, err := s.handshaker.Establish(pc, s.advertisedInfo(info), rb)
 	if err != nil {
 		s.failIncomingHandshake(pc, fmt.Errorf("establish handshake: %s", err))
 		return
@@ -398,8 +662,9 @@ func (s *scheduler) establishIncomingHandshake(pc *conn.PendingConn, rb conn.Rem
 func (s *scheduler) initializeOutgoingHandshake(
 	p *core.PeerInfo, info *storage.TorrentInfo, rb conn.RemoteBitfields, namespace string) {
 
-	addr := fmt.Sprintf("%s:%d", p.IP, p.Port)
-	result, err := s.handshaker.Initialize(p.PeerID, addr, info, rb, namespace)
+	addr := p.Addr()
+	result, err := s.handshaker.Initialize(
+		p.PeerID, addr, s.advertisedInfo(info), rb, namespace)
 	if err != nil {
 		s.log(
 			"peer", p.PeerID,
@@ -413,6 +678,17 @@ func (s *scheduler) initializeOutgoingHandshake(
 	s.eventLoop.send(outgoingConnEvent{result.Conn, result.Bitfield, info})
 }
 
+// advertisedInfo returns the torrent info sent to peers during handshake. When
+// superseeding, complete torrents advertise an empty bitfield and the dispatcher
+// reveals pieces individually instead.
+func (s *scheduler) advertisedInfo(info *storage.TorrentInfo) *storage.TorrentInfo {
+	b := info.Bitfield()
+	if !s.config.Dispatch.Superseed.Enabled || !b.All() {
+		return info
+	}
+	return info.WithBitfield(bitset.New(b.Len()))
+}
+
 func (s *scheduler) log(args ...interface{}) *zap.SugaredLogger {
 	return s.logger.With(args...)
 }
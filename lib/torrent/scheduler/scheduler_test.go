@@ -14,6 +14,7 @@
 package scheduler
 
 import (
+	"context"
 	"os"
 	"sync"
 	"testing"
@@ -29,6 +30,7 @@ import (
 	"github.com/uber/kraken/utils/bitsetutil"
 
 	"github.com/andres-erbsen/clock"
+	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -47,7 +49,7 @@ func TestDownloadTorrentWithSeederAndLeecher(t *testing.T) {
 	namespace := core.TagFixture()
 
 	mocks.metaInfoClient.EXPECT().Download(
-		namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+		gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
 
 	seeder.writeTorrent(namespace, blob)
 	require.NoError(seeder.scheduler.Download(namespace, blob.Digest))
@@ -56,6 +58,32 @@ func TestDownloadTorrentWithSeederAndLeecher(t *testing.T) {
 	leecher.checkTorrent(t, namespace, blob)
 }
 
+func TestDownloadTorrentWithBackgroundPriority(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	config := configFixture()
+	config.Background.MaxOpenConnectionsPerTorrent = 1
+
+	seeder := mocks.newPeer(config)
+	leecher := mocks.newPeer(config)
+
+	blob := core.NewBlobFixture()
+	namespace := core.TagFixture()
+
+	mocks.metaInfoClient.EXPECT().Download(
+		gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+
+	seeder.writeTorrent(namespace, blob)
+	require.NoError(seeder.scheduler.Download(namespace, blob.Digest))
+
+	require.NoError(leecher.scheduler.DownloadWithPriority(
+		namespace, blob.Digest, PriorityBackground))
+	leecher.checkTorrent(t, namespace, blob)
+}
+
 func TestDownloadManyTorrentsWithSeederAndLeecher(t *testing.T) {
 	require := require.New(t)
 
@@ -73,7 +101,7 @@ func TestDownloadManyTorrentsWithSeederAndLeecher(t *testing.T) {
 		blob := core.NewBlobFixture()
 
 		mocks.metaInfoClient.EXPECT().Download(
-			namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+			gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
 
 		wg.Add(1)
 		go func() {
@@ -108,7 +136,7 @@ func TestDownloadManyTorrentsWithSeederAndManyLeechers(t *testing.T) {
 		blobs[i] = blob
 
 		mocks.metaInfoClient.EXPECT().Download(
-			namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(6)
+			gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(6)
 
 		seeder.writeTorrent(namespace, blob)
 		require.NoError(seeder.scheduler.Download(namespace, blob.Digest))
@@ -145,11 +173,11 @@ func TestDownloadTorrentWhenPeersAllHaveDifferentPiece(t *testing.T) {
 	blob := core.SizedBlobFixture(uint64(len(peers)*pieceLength), uint64(pieceLength))
 
 	mocks.metaInfoClient.EXPECT().Download(
-		namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(len(peers))
+		gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(len(peers))
 
 	var wg sync.WaitGroup
 	for i, p := range peers {
-		tor, err := p.torrentArchive.CreateTorrent(namespace, blob.Digest)
+		tor, err := p.torrentArchive.CreateTorrent(context.Background(), namespace, blob.Digest)
 		require.NoError(err)
 
 		piece := make([]byte, pieceLength)
@@ -181,7 +209,7 @@ func TestSeederTTI(t *testing.T) {
 	namespace := core.TagFixture()
 
 	mocks.metaInfoClient.EXPECT().Download(
-		namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+		gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
 
 	clk := clock.NewMock()
 	w := newEventWatcher()
@@ -214,7 +242,7 @@ func TestSeederTTI(t *testing.T) {
 	require.False(hasConn(leecher.scheduler, seeder.pctx.PeerID, blob.MetaInfo.InfoHash()))
 
 	// Idle seeder should keep around the torrent file so it can still serve content.
-	_, err := seeder.torrentArchive.Stat(namespace, blob.Digest)
+	_, err := seeder.torrentArchive.Stat(context.Background(), namespace, blob.Digest)
 	require.NoError(err)
 }
 
@@ -233,7 +261,7 @@ func TestLeecherTTI(t *testing.T) {
 	blob := core.NewBlobFixture()
 	namespace := core.TagFixture()
 
-	mocks.metaInfoClient.EXPECT().Download(namespace, blob.Digest).Return(blob.MetaInfo, nil)
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
 
 	p := mocks.newPeer(config, withEventLoop(w), withClock(clk))
 	errc := make(chan error)
@@ -248,7 +276,7 @@ func TestLeecherTTI(t *testing.T) {
 	require.Equal(ErrTorrentTimeout, <-errc)
 
 	// Idle leecher should delete torrent file to prevent it from being revived.
-	_, err := p.torrentArchive.Stat(namespace, blob.Digest)
+	_, err := p.torrentArchive.Stat(context.Background(), namespace, blob.Digest)
 	require.True(os.IsNotExist(err))
 }
 
@@ -263,7 +291,7 @@ func TestMultipleDownloadsForSameTorrentSucceed(t *testing.T) {
 
 	// Allow any number of downloads due to concurrency below.
 	mocks.metaInfoClient.EXPECT().Download(
-		namespace, blob.Digest).Return(blob.MetaInfo, nil).AnyTimes()
+		gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil).AnyTimes()
 
 	config := configFixture()
 
@@ -322,7 +350,7 @@ func TestNetworkEvents(t *testing.T) {
 	namespace := core.TagFixture()
 
 	mocks.metaInfoClient.EXPECT().Download(
-		namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+		gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
 
 	seeder.writeTorrent(namespace, blob)
 	require.NoError(seeder.scheduler.Download(namespace, blob.Digest))
@@ -376,7 +404,7 @@ func TestPullInactiveTorrent(t *testing.T) {
 	namespace := core.TagFixture()
 
 	mocks.metaInfoClient.EXPECT().Download(
-		namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+		gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
 
 	seeder := mocks.newPeer(config)
 
@@ -386,7 +414,7 @@ func TestPullInactiveTorrent(t *testing.T) {
 	// Force announce the scheduler for this torrent to simulate a peer which
 	// is registered in tracker but does not have the torrent in memory.
 	ac := announceclient.New(seeder.pctx, hashring.NoopPassiveRing(hostlist.Fixture(mocks.trackerAddr)), nil)
-	ac.Announce(blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V1)
+	ac.Announce(namespace, blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V1)
 
 	leecher := mocks.newPeer(config)
 
@@ -410,7 +438,7 @@ func TestSchedulerReload(t *testing.T) {
 		blob := core.NewBlobFixture()
 
 		mocks.metaInfoClient.EXPECT().Download(
-			namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+			gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
 
 		seeder.writeTorrent(namespace, blob)
 		require.NoError(seeder.scheduler.Download(namespace, blob.Digest))
@@ -443,7 +471,7 @@ func TestSchedulerRemoveTorrent(t *testing.T) {
 	namespace := core.TagFixture()
 
 	mocks.metaInfoClient.EXPECT().Download(
-		namespace, blob.Digest).Return(blob.MetaInfo, nil)
+		gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
 
 	errc := make(chan error)
 	go func() { errc <- p.scheduler.Download(namespace, blob.Digest) }()
@@ -454,10 +482,85 @@ func TestSchedulerRemoveTorrent(t *testing.T) {
 
 	require.Equal(ErrTorrentRemoved, <-errc)
 
-	_, err := p.torrentArchive.Stat(namespace, blob.Digest)
+	_, err := p.torrentArchive.Stat(context.Background(), namespace, blob.Digest)
 	require.True(os.IsNotExist(err))
 }
 
+func TestSchedulerPauseAndResumeTorrent(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	config := configFixture()
+
+	w := newEventWatcher()
+
+	leecher := mocks.newPeer(config, withEventLoop(w))
+	seeder := mocks.newPeer(config)
+
+	blob := core.NewBlobFixture()
+	namespace := core.TagFixture()
+
+	require.Equal(ErrTorrentNotFound, leecher.scheduler.PauseTorrent(blob.Digest))
+
+	mocks.metaInfoClient.EXPECT().Download(
+		gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+
+	errc := make(chan error)
+	go func() { errc <- leecher.scheduler.Download(namespace, blob.Digest) }()
+
+	w.waitFor(t, newTorrentEvent{})
+
+	require.NoError(leecher.scheduler.PauseTorrent(blob.Digest))
+
+	seeder.writeTorrent(namespace, blob)
+	require.NoError(seeder.scheduler.Download(namespace, blob.Digest))
+
+	select {
+	case err := <-errc:
+		t.Fatalf("Download finished while paused: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	require.NoError(leecher.scheduler.ResumeTorrent(blob.Digest))
+	require.NoError(<-errc)
+	leecher.checkTorrent(t, namespace, blob)
+}
+
+func TestSchedulerProgress(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	w := newEventWatcher()
+
+	p := mocks.newPeer(configFixture(), withEventLoop(w))
+
+	blob := core.NewBlobFixture()
+	namespace := core.TagFixture()
+
+	_, err := p.scheduler.Progress(blob.Digest)
+	require.Equal(ErrTorrentNotFound, err)
+
+	mocks.metaInfoClient.EXPECT().Download(
+		gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+
+	errc := make(chan error)
+	go func() { errc <- p.scheduler.Download(namespace, blob.Digest) }()
+
+	w.waitFor(t, newTorrentEvent{})
+
+	info, err := p.scheduler.Progress(blob.Digest)
+	require.NoError(err)
+	require.Equal(blob.Digest, info.Digest())
+	require.Equal(int64(0), info.BytesDownloaded())
+
+	require.NoError(p.scheduler.RemoveTorrent(blob.Digest))
+	require.Equal(ErrTorrentRemoved, <-errc)
+}
+
 func TestSchedulerProbe(t *testing.T) {
 	require := require.New(t)
 
@@ -503,3 +606,14 @@ func TestSchedulerProbeTimeoutsIfDeadlocked(t *testing.T) {
 
 	close(release)
 }
+
+func TestMergePeers(t *testing.T) {
+	require := require.New(t)
+
+	p1 := core.PeerInfoFixture()
+	p2 := core.PeerInfoFixture()
+	p3 := core.PeerInfoFixture()
+
+	merged := mergePeers([]*core.PeerInfo{p1, p2}, []*core.PeerInfo{p2, p3})
+	require.Equal([]*core.PeerInfo{p1, p2, p3}, merged)
+}
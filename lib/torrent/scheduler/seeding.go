@@ -0,0 +1,144 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package scheduler
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/uber/kraken/core"
+)
+
+// SeedingPolicy defines how complete torrents under matching namespaces are
+// seeded. Torrents under namespaces which match no policy are seeded until
+// they have not been read from for SeederTTI.
+type SeedingPolicy struct {
+
+	// Namespace is a regular expression matching the namespaces which the
+	// policy applies to.
+	Namespace string `yaml:"namespace"`
+
+	// MinSeedTime is the duration complete torrents are seeded for before they
+	// may be removed, even if idle.
+	MinSeedTime time.Duration `yaml:"min_seed_time"`
+
+	// TargetRatio is the ratio of bytes uploaded to torrent length at which
+	// torrents stop seeding. If 0, torrents seed until idle.
+	TargetRatio float64 `yaml:"target_ratio"`
+
+	// MaxSeeding is the maximum number of torrents under the policy which are
+	// seeded at once. Once exceeded, the least recently read torrents which
+	// have seeded for MinSeedTime are removed. If 0, unlimited.
+	MaxSeeding int `yaml:"max_seeding"`
+}
+
+type seedingPolicy struct {
+	SeedingPolicy
+	re *regexp.Regexp
+}
+
+// seedingPolicies matches namespaces to their seeding policy. The first
+// matching policy is used.
+type seedingPolicies []*seedingPolicy
+
+func newSeedingPolicies(configs []SeedingPolicy) (seedingPolicies, error) {
+	var ps seedingPolicies
+	for _, c := range configs {
+		re, err := regexp.Compile(c.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace regexp %q: %s", c.Namespace, err)
+		}
+		ps = append(ps, &seedingPolicy{c, re})
+	}
+	return ps, nil
+}
+
+// match returns the seeding policy of namespace, or nil if none matches.
+func (ps seedingPolicies) match(namespace string) *seedingPolicy {
+	for _, p := range ps {
+		if p.re.MatchString(namespace) {
+			return p
+		}
+	}
+	return nil
+}
+
+// minSeedTimeElapsed returns true if ctrl has seeded for the minimum seed time
+// of p. Torrents under no policy have no minimum seed time.
+func (p *seedingPolicy) minSeedTimeElapsed(ctrl *torrentControl, now time.Time) bool {
+	if p == nil {
+		return true
+	}
+	if ctrl.completedAt.IsZero() {
+		// Completion has not been processed yet.
+		return false
+	}
+	return now.Sub(ctrl.completedAt) >= p.MinSeedTime
+}
+
+// targetRatioReached returns true if ctrl has uploaded enough to satisfy p.
+func (p *seedingPolicy) targetRatioReached(ctrl *torrentControl) bool {
+	if p == nil || p.TargetRatio <= 0 {
+		return false
+	}
+	length := ctrl.dispatcher.Length()
+	if length == 0 {
+		return true
+	}
+	return float64(ctrl.dispatcher.BytesUploaded())/float64(length) >= p.TargetRatio
+}
+
+// seedersOverLimit returns the seeding torrents which must be removed to keep
+// each seeding policy within its max seeding limit.
+func (s *state) seedersOverLimit() []core.InfoHash {
+	now := s.sched.clock.Now()
+
+	seeding := make(map[*seedingPolicy][]core.InfoHash)
+	for h, ctrl := range s.torrentControls {
+		if !ctrl.dispatcher.Complete() {
+			continue
+		}
+		p := s.sched.seeding.match(ctrl.namespace)
+		if p == nil || p.MaxSeeding <= 0 {
+			continue
+		}
+		seeding[p] = append(seeding[p], h)
+	}
+
+	var remove []core.InfoHash
+	for p, hs := range seeding {
+		excess := len(hs) - p.MaxSeeding
+		if excess <= 0 {
+			continue
+		}
+		var candidates []core.InfoHash
+		for _, h := range hs {
+			if p.minSeedTimeElapsed(s.torrentControls[h], now) {
+				candidates = append(candidates, h)
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			a := s.torrentControls[candidates[i]].dispatcher.LastReadTime()
+			b := s.torrentControls[candidates[j]].dispatcher.LastReadTime()
+			return a.Before(b)
+		})
+		if excess > len(candidates) {
+			excess = len(candidates)
+		}
+		remove = append(remove, candidates[:excess]...)
+	}
+	return remove
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeedingPoliciesMatchFirst(t *testing.T) {
+	require := require.New(t)
+
+	ps, err := newSeedingPolicies([]SeedingPolicy{
+		{Namespace: "^base/.*", MinSeedTime: time.Hour},
+		{Namespace: ".*", MinSeedTime: time.Minute},
+	})
+	require.NoError(err)
+
+	require.Equal(time.Hour, ps.match("base/ubuntu").MinSeedTime)
+	require.Equal(time.Minute, ps.match("team/service").MinSeedTime)
+}
+
+func TestSeedingPoliciesNoMatch(t *testing.T) {
+	require := require.New(t)
+
+	ps, err := newSeedingPolicies([]SeedingPolicy{{Namespace: "^base/.*"}})
+	require.NoError(err)
+
+	p := ps.match("team/service")
+	require.Nil(p)
+
+	// Torrents under no policy may always be removed.
+	require.True(p.minSeedTimeElapsed(&torrentControl{}, time.Now()))
+	require.False(p.targetRatioReached(&torrentControl{}))
+}
+
+func TestSeedingPoliciesInvalidNamespace(t *testing.T) {
+	_, err := newSeedingPolicies([]SeedingPolicy{{Namespace: "("}})
+	require.Error(t, err)
+}
+
+func TestSeedingPolicyMinSeedTimeElapsed(t *testing.T) {
+	require := require.New(t)
+
+	ps, err := newSeedingPolicies([]SeedingPolicy{{Namespace: ".*", MinSeedTime: time.Hour}})
+	require.NoError(err)
+	p := ps.match("foo")
+
+	now := time.Now()
+
+	// Completion has not been processed yet.
+	require.False(p.minSeedTimeElapsed(&torrentControl{}, now))
+
+	require.False(p.minSeedTimeElapsed(&torrentControl{completedAt: now.Add(-time.Minute)}, now))
+	require.True(p.minSeedTimeElapsed(&torrentControl{completedAt: now.Add(-time.Hour)}, now))
+}
@@ -16,6 +16,8 @@ package scheduler
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/torrent/networkevent"
@@ -29,12 +31,21 @@ import (
 	"github.com/willf/bitset"
 )
 
+var (
+	errTorrentPaused  = errors.New("torrent is paused")
+	errPoorReputation = errors.New("peer has poor reputation")
+)
+
 // torrentControl bundles torrent control structures.
 type torrentControl struct {
 	namespace    string
 	dispatcher   *dispatch.Dispatcher
 	errors       []chan error
 	localRequest bool
+	paused       bool
+	resumedAt    time.Time
+	priority     Priority
+	completedAt  time.Time
 }
 
 // state is a superset of scheduler, which includes protected state which can
@@ -77,6 +88,10 @@ func (s *state) addTorrent(
 		s.sched.eventLoop,
 		s.sched.pctx.PeerID,
 		t,
+		s.sched.uploadSlots,
+		s.sched.writes,
+		s.sched.reputation,
+		s.sched.webseed,
 		s.sched.logger,
 		s.sched.torrentlog)
 	if err != nil {
@@ -88,15 +103,56 @@ func (s *state) addTorrent(
 		localRequest: localRequest,
 	}
 	s.announceQueue.Add(t.InfoHash())
+	if s.sched.lpd != nil {
+		s.sched.lpd.Add(t.InfoHash(), t.Complete())
+	}
 	s.sched.netevents.Produce(networkevent.AddTorrentEvent(
 		t.InfoHash(),
 		s.sched.pctx.PeerID,
 		t.Bitfield(),
 		s.sched.config.ConnState.MaxOpenConnectionsPerTorrent))
 	s.torrentControls[t.InfoHash()] = ctrl
+	s.restoreBlacklist(t.InfoHash(), t.Digest())
 	return ctrl, nil
 }
 
+// persistBlacklist saves the blacklist of h, such that it survives restarts.
+func (s *state) persistBlacklist(h core.InfoHash) {
+	if s.sched.blacklists == nil {
+		return
+	}
+	ctrl, ok := s.torrentControls[h]
+	if !ok {
+		return
+	}
+	var entries []storage.BlacklistEntry
+	for peerID, expiration := range s.conns.BlacklistExpirations(h) {
+		entries = append(entries, storage.BlacklistEntry{PeerID: peerID, ExpiresAt: expiration})
+	}
+	if err := s.sched.blacklists.SaveBlacklist(ctrl.dispatcher.Digest(), entries); err != nil {
+		s.log("hash", h).Errorf("Error persisting blacklist: %s", err)
+	}
+}
+
+// restoreBlacklist blacklists the connections for h which were blacklisted
+// before restart.
+func (s *state) restoreBlacklist(h core.InfoHash, d core.Digest) {
+	if s.sched.blacklists == nil {
+		return
+	}
+	entries, err := s.sched.blacklists.LoadBlacklist(d)
+	if err != nil {
+		s.log("hash", h).Errorf("Error loading blacklist: %s", err)
+		return
+	}
+	for _, e := range entries {
+		s.conns.RestoreBlacklist(e.PeerID, h, e.ExpiresAt)
+	}
+	if len(entries) > 0 {
+		s.log("hash", h).Infof("Restored %d blacklisted connections", len(entries))
+	}
+}
+
 // removeTorrent tears down the torrentControl associated with h, sending err to
 // all clients waiting on this torrent.
 func (s *state) removeTorrent(h core.InfoHash, err error) {
@@ -113,9 +169,128 @@ func (s *state) removeTorrent(h core.InfoHash, err error) {
 		s.sched.netevents.Produce(networkevent.TorrentCancelledEvent(h, s.sched.pctx.PeerID))
 		s.sched.torrentArchive.DeleteTorrent(ctrl.dispatcher.Digest())
 	}
+	s.conns.SetMaxOpenConnections(h, 0)
+	if s.sched.lpd != nil {
+		s.sched.lpd.Remove(h)
+	}
 	delete(s.torrentControls, h)
 }
 
+// addOutgoingPeers opens connections to peers if there is capacity, preferring
+// peers nearest to the local peer. These connections are added to the
+// scheduler's pending connections and handshaked asynchronously.
+func (s *state) addOutgoingPeers(
+	h core.InfoHash, ctrl *torrentControl, peers []*core.PeerInfo) {
+
+	if ctrl.dispatcher.Complete() || ctrl.paused {
+		// Torrent is already complete or paused, don't open any new connections.
+		return
+	}
+	for _, p := range s.sortPeers(peers) {
+		if p.PeerID == s.sched.pctx.PeerID {
+			// Tracker may return our own peer.
+			continue
+		}
+		if s.conns.Blacklisted(p.PeerID, h) {
+			continue
+		}
+		if s.sched.reputation != nil && !p.Origin && !s.sched.reputation.Admit(p.PeerID) {
+			// Origins are exempt, since they may be the only source of the blob.
+			continue
+		}
+		var err error
+		if !p.Origin && connstate.GetLocality(s.sched.pctx, p) == connstate.CrossZone {
+			// Origins are exempt, since they may be the only source of the blob.
+			err = s.conns.AddPendingCrossZone(p.PeerID, h, nil)
+		} else {
+			err = s.conns.AddPending(p.PeerID, h, nil)
+		}
+		if err != nil {
+			if err == connstate.ErrTorrentAtCapacity {
+				break
+			}
+			continue
+		}
+		go s.sched.initializeOutgoingHandshake(
+			p, ctrl.dispatcher.Stat(), ctrl.dispatcher.RemoteBitfields(), ctrl.namespace)
+	}
+}
+
+// sortPeers orders peers by locality and, within the same locality, by
+// descending reputation.
+func (s *state) sortPeers(peers []*core.PeerInfo) []*core.PeerInfo {
+	peers = connstate.SortByLocality(s.sched.pctx, peers)
+	if s.sched.reputation == nil {
+		return peers
+	}
+	scores := make(map[core.PeerID]float64, len(peers))
+	for _, p := range peers {
+		scores[p.PeerID] = s.sched.reputation.Score(p.PeerID)
+	}
+	sort.SliceStable(peers, func(i, j int) bool {
+		li := connstate.GetLocality(s.sched.pctx, peers[i])
+		lj := connstate.GetLocality(s.sched.pctx, peers[j])
+		if li != lj {
+			return li < lj
+		}
+		return scores[peers[i].PeerID] > scores[peers[j].PeerID]
+	})
+	return peers
+}
+
+// setTorrentPriority applies the connection and piece request limits of
+// priority to h.
+func (s *state) setTorrentPriority(h core.InfoHash, priority Priority) {
+	ctrl, ok := s.torrentControls[h]
+	if !ok {
+		return
+	}
+	ctrl.priority = priority
+	switch priority {
+	case PriorityBackground:
+		s.conns.SetMaxOpenConnections(h, s.sched.config.Background.MaxOpenConnectionsPerTorrent)
+		ctrl.dispatcher.SetPipelineLimit(s.sched.config.Background.PipelineLimit)
+	default:
+		s.conns.SetMaxOpenConnections(h, 0)
+		ctrl.dispatcher.SetPipelineLimit(0)
+	}
+}
+
+// pauseTorrent closes all conns of h and stops announcing h until
+// resumeTorrent is called.
+func (s *state) pauseTorrent(h core.InfoHash) {
+	ctrl, ok := s.torrentControls[h]
+	if !ok || ctrl.paused {
+		return
+	}
+	ctrl.paused = true
+	ctrl.dispatcher.SetPaused(true)
+	s.announceQueue.Eject(h)
+	for _, c := range s.conns.ActiveConns() {
+		if c.InfoHash() == h {
+			c.Close()
+		}
+	}
+}
+
+// resumeTorrent resumes announcing h and accepting conns for h.
+func (s *state) resumeTorrent(h core.InfoHash) {
+	ctrl, ok := s.torrentControls[h]
+	if !ok || !ctrl.paused {
+		return
+	}
+	ctrl.paused = false
+	ctrl.resumedAt = s.sched.clock.Now()
+	ctrl.dispatcher.SetPaused(false)
+
+	// Conns closed by pausing were blacklisted.
+	s.conns.ClearBlacklist(h)
+	s.persistBlacklist(h)
+	if !ctrl.dispatcher.Complete() {
+		s.announceQueue.Add(h)
+	}
+}
+
 // addOutgoingConn adds a conn, initialized by us, to state. The conn must already
 // be in a pending state, and the torrent control must already be initialized.
 func (s *state) addOutgoingConn(c *conn.Conn, b *bitset.BitSet, info *storage.TorrentInfo) error {
@@ -127,6 +302,9 @@ func (s *state) addOutgoingConn(c *conn.Conn, b *bitset.BitSet, info *storage.To
 	if !ok {
 		return errors.New("torrent controls must be created before sending handshake")
 	}
+	if ctrl.paused {
+		return errTorrentPaused
+	}
 	if err := ctrl.dispatcher.AddPeer(c.PeerID(), b, c); err != nil {
 		return fmt.Errorf("add conn to dispatcher: %s", err)
 	}
@@ -145,7 +323,7 @@ func (s *state) addIncomingConn(
 	c.Start()
 	ctrl, ok := s.torrentControls[info.InfoHash()]
 	if !ok {
-		t, err := s.sched.torrentArchive.GetTorrent(namespace, info.Digest())
+		t, err := s.sched.torrentArchive.GetTorrent(s.sched.ctx, namespace, info.Digest())
 		if err != nil {
 			return fmt.Errorf("get torrent: %s", err)
 		}
@@ -154,6 +332,9 @@ func (s *state) addIncomingConn(
 			return err
 		}
 	}
+	if ctrl.paused {
+		return errTorrentPaused
+	}
 	if err := ctrl.dispatcher.AddPeer(c.PeerID(), b, c); err != nil {
 		return fmt.Errorf("add conn to dispatcher: %s", err)
 	}
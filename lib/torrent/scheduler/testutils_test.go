@@ -14,6 +14,7 @@
 package scheduler
 
 import (
+	"context"
 	"flag"
 	"io/ioutil"
 	"net"
@@ -25,6 +26,7 @@ import (
 
 	"go.uber.org/zap"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 	"github.com/uber-go/tally"
@@ -124,7 +126,8 @@ func (m *testMocks) newPeer(config Config, options ...option) *testPeer {
 
 	stats := tally.NewTestScope("", nil)
 
-	ta := agentstorage.NewTorrentArchive(stats, cads, m.metaInfoClient)
+	ta := agentstorage.NewTorrentArchive(
+		agentstorage.Config{}, stats, clock.New(), cads, m.metaInfoClient)
 
 	pctx := core.PeerContext{
 		PeerID: core.PeerIDFixture(),
@@ -158,7 +161,7 @@ func (m *testMocks) newPeers(n int, config Config) []*testPeer {
 // writeTorrent writes the given content into a torrent file into peers storage.
 // Useful for populating a completed torrent before seeding it.
 func (p *testPeer) writeTorrent(namespace string, blob *core.BlobFixture) {
-	t, err := p.torrentArchive.CreateTorrent(namespace, blob.Digest)
+	t, err := p.torrentArchive.CreateTorrent(context.Background(), namespace, blob.Digest)
 	if err != nil {
 		panic(err)
 	}
@@ -174,7 +177,7 @@ func (p *testPeer) writeTorrent(namespace string, blob *core.BlobFixture) {
 func (p *testPeer) checkTorrent(t *testing.T, namespace string, blob *core.BlobFixture) {
 	require := require.New(t)
 
-	tor, err := p.torrentArchive.GetTorrent(namespace, blob.Digest)
+	tor, err := p.torrentArchive.GetTorrent(context.Background(), namespace, blob.Digest)
 	require.NoError(err)
 
 	require.True(tor.Complete())
@@ -0,0 +1,111 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/lib/torrent/storage"
+)
+
+const _blacklistSuffix = "_blacklist"
+
+func init() {
+	metadata.Register(regexp.MustCompile(_blacklistSuffix), blacklistMetadataFactory{})
+}
+
+type blacklistMetadataFactory struct{}
+
+func (m blacklistMetadataFactory) Create(suffix string) metadata.Metadata {
+	return &blacklistMetadata{}
+}
+
+type blacklistMetadataEntry struct {
+	PeerID    string    `json:"peer_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// blacklistMetadata stores the peers blacklisted for a torrent.
+type blacklistMetadata struct {
+	entries []blacklistMetadataEntry
+}
+
+func (m *blacklistMetadata) GetSuffix() string {
+	return _blacklistSuffix
+}
+
+func (m *blacklistMetadata) Movable() bool {
+	return true
+}
+
+func (m *blacklistMetadata) Serialize() ([]byte, error) {
+	return json.Marshal(m.entries)
+}
+
+func (m *blacklistMetadata) Deserialize(b []byte) error {
+	return json.Unmarshal(b, &m.entries)
+}
+
+// SaveBlacklist replaces the persisted blacklist of d with entries.
+func (a *TorrentArchive) SaveBlacklist(d core.Digest, entries []storage.BlacklistEntry) error {
+	if err := a.enter(); err != nil {
+		return err
+	}
+	defer a.exit()
+
+	md := &blacklistMetadata{entries: make([]blacklistMetadataEntry, len(entries))}
+	for i, e := range entries {
+		md.entries[i] = blacklistMetadataEntry{e.PeerID.String(), e.ExpiresAt}
+	}
+	if _, err := a.cads.Any().SetMetadata(d.Hex(), md); err != nil {
+		return fmt.Errorf("set metadata: %s", err)
+	}
+	return nil
+}
+
+// LoadBlacklist returns the persisted blacklist entries of d which have not
+// expired.
+func (a *TorrentArchive) LoadBlacklist(d core.Digest) ([]storage.BlacklistEntry, error) {
+	if err := a.enter(); err != nil {
+		return nil, err
+	}
+	defer a.exit()
+
+	md := &blacklistMetadata{}
+	if err := a.cads.Any().GetMetadata(d.Hex(), md); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get metadata: %s", err)
+	}
+	now := a.clk.Now()
+	var entries []storage.BlacklistEntry
+	for _, e := range md.entries {
+		if !e.ExpiresAt.After(now) {
+			continue
+		}
+		peerID, err := core.NewPeerID(e.PeerID)
+		if err != nil {
+			return nil, fmt.Errorf("parse peer id: %s", err)
+		}
+		entries = append(entries, storage.BlacklistEntry{PeerID: peerID, ExpiresAt: e.ExpiresAt})
+	}
+	return entries, nil
+}
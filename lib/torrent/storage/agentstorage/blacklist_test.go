@@ -0,0 +1,69 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTorrentArchiveBlacklistRoundTrip(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(10, 4)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+
+	_, err := archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+	require.NoError(err)
+
+	entries, err := archive.LoadBlacklist(blob.Digest)
+	require.NoError(err)
+	require.Empty(entries)
+
+	now := mocks.clk.Now()
+	short := storage.BlacklistEntry{PeerID: core.PeerIDFixture(), ExpiresAt: now.Add(time.Minute)}
+	long := storage.BlacklistEntry{PeerID: core.PeerIDFixture(), ExpiresAt: now.Add(time.Hour)}
+
+	require.NoError(archive.SaveBlacklist(blob.Digest, []storage.BlacklistEntry{short, long}))
+
+	entries, err = archive.LoadBlacklist(blob.Digest)
+	require.NoError(err)
+	require.Len(entries, 2)
+	for i, e := range []storage.BlacklistEntry{short, long} {
+		require.Equal(e.PeerID, entries[i].PeerID)
+		require.True(e.ExpiresAt.Equal(entries[i].ExpiresAt))
+	}
+
+	// Expired entries are not loaded.
+	mocks.clk.Add(2 * time.Minute)
+
+	entries, err = archive.LoadBlacklist(blob.Digest)
+	require.NoError(err)
+	require.Len(entries, 1)
+	require.Equal(long.PeerID, entries[0].PeerID)
+}
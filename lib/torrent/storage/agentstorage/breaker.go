@@ -0,0 +1,179 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+)
+
+// ErrCircuitOpen occurs when metainfo downloads are failing fast because the
+// tracker has been consistently unavailable.
+var ErrCircuitOpen = errors.New("metainfo circuit breaker is open")
+
+// CircuitBreakerConfig defines configuration for the circuit breaker around
+// metainfo downloads.
+type CircuitBreakerConfig struct {
+	Disabled bool `yaml:"disabled"`
+
+	// FailureThreshold is the number of consecutive failed downloads which
+	// opens the circuit.
+	FailureThreshold int `yaml:"failure_threshold"`
+
+	// Cooldown is the duration the circuit stays open before a single probe
+	// download is let through.
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+func (c CircuitBreakerConfig) applyDefaults() CircuitBreakerConfig {
+	if c.FailureThreshold == 0 {
+		c.FailureThreshold = 5
+	}
+	if c.Cooldown == 0 {
+		c.Cooldown = 30 * time.Second
+	}
+	return c
+}
+
+type breakerState int
+
+const (
+	_closed breakerState = iota
+	_halfOpen
+	_open
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case _closed:
+		return "closed"
+	case _halfOpen:
+		return "half_open"
+	case _open:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// breaker is a circuit breaker which fails calls fast after consecutive
+// failures, periodically letting a single probe through to detect recovery.
+type breaker struct {
+	sync.Mutex
+	config   CircuitBreakerConfig
+	clk      clock.Clock
+	stats    tally.Scope
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(config CircuitBreakerConfig, clk clock.Clock, stats tally.Scope) *breaker {
+	b := &breaker{
+		config: config,
+		clk:    clk,
+		stats:  stats,
+	}
+	b.stats.Gauge("metainfo_circuit_state").Update(float64(_closed))
+	return b
+}
+
+// allow returns ErrCircuitOpen if the call should fail fast. Otherwise, the
+// result of the call must be passed to record.
+func (b *breaker) allow() error {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.config.Disabled {
+		return nil
+	}
+
+	switch b.state {
+	case _open:
+		if b.clk.Now().Sub(b.openedAt) < b.config.Cooldown {
+			return ErrCircuitOpen
+		}
+		b.transition(_halfOpen)
+		b.probing = true
+	case _halfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record updates the breaker with the result of an allowed call.
+func (b *breaker) record(failed bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.config.Disabled {
+		return
+	}
+
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		if b.state != _closed {
+			b.transition(_closed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == _halfOpen || (b.state == _closed && b.failures >= b.config.FailureThreshold) {
+		b.openedAt = b.clk.Now()
+		b.transition(_open)
+	}
+}
+
+// update applies config to b. The current state is kept, such that an open
+// circuit remains open for the new cooldown. Disabling b closes the circuit.
+func (b *breaker) update(config CircuitBreakerConfig) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.config = config
+	if config.Disabled {
+		b.failures = 0
+		b.probing = false
+		if b.state != _closed {
+			b.transition(_closed)
+		}
+	}
+}
+
+// abandon releases an allowed call whose result says nothing about the
+// health of the callee, e.g. because the caller cancelled it.
+func (b *breaker) abandon() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.probing = false
+}
+
+// transition moves b to state s. Assumes b is locked.
+func (b *breaker) transition(s breakerState) {
+	b.state = s
+	b.stats.Gauge("metainfo_circuit_state").Update(float64(s))
+	b.stats.Tagged(map[string]string{
+		"state": s.String(),
+	}).Counter("metainfo_circuit_transitions").Inc(1)
+}
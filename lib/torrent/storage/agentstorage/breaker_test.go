@@ -0,0 +1,95 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func newTestBreaker(clk clock.Clock) *breaker {
+	config := CircuitBreakerConfig{
+		FailureThreshold: 3,
+		Cooldown:         time.Minute,
+	}
+	return newBreaker(config.applyDefaults(), clk, tally.NoopScope)
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	require := require.New(t)
+
+	b := newTestBreaker(clock.NewMock())
+
+	for i := 0; i < 2; i++ {
+		require.NoError(b.allow())
+		b.record(true)
+	}
+
+	// A success resets the consecutive failure count.
+	require.NoError(b.allow())
+	b.record(false)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(b.allow())
+		b.record(true)
+	}
+	require.Equal(ErrCircuitOpen, b.allow())
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	b := newTestBreaker(clk)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(b.allow())
+		b.record(true)
+	}
+	require.Equal(ErrCircuitOpen, b.allow())
+
+	clk.Add(time.Minute)
+
+	// Only a single probe is let through.
+	require.NoError(b.allow())
+	require.Equal(ErrCircuitOpen, b.allow())
+
+	// Failed probe re-opens the circuit.
+	b.record(true)
+	require.Equal(ErrCircuitOpen, b.allow())
+
+	clk.Add(time.Minute)
+
+	// Successful probe closes the circuit.
+	require.NoError(b.allow())
+	b.record(false)
+	require.NoError(b.allow())
+	require.NoError(b.allow())
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	require := require.New(t)
+
+	config := CircuitBreakerConfig{Disabled: true, FailureThreshold: 1}
+	b := newBreaker(config.applyDefaults(), clock.NewMock(), tally.NoopScope)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(b.allow())
+		b.record(true)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+)
+
+// ErrDiskBudgetExceeded occurs when initializing a torrent or reserving
+// capacity would exceed the configured disk budget.
+var ErrDiskBudgetExceeded = errors.New("disk budget exceeded")
+
+// Reservation holds part of the disk budget aside for a batch of CreateTorrent
+// calls. Torrents which cannot fit in the unreserved budget consume from
+// outstanding reservations, oldest first.
+type Reservation interface {
+	// Release returns the unconsumed portion of the reservation to the budget.
+	// Release is safe to call multiple times.
+	Release()
+}
+
+type reservation struct {
+	budget    *budget
+	remaining int64
+	expiresAt time.Time
+}
+
+func (r *reservation) Release() {
+	r.budget.Lock()
+	defer r.budget.Unlock()
+
+	r.budget.drop(r)
+}
+
+// budget tracks bytes of in-flight downloads initialized by the archive.
+// Charges are released once the download is committed to cache or deleted.
+type budget struct {
+	sync.Mutex
+	clk          clock.Clock
+	limit        int64
+	ttl          time.Duration
+	used         int64
+	reserved     int64
+	charges      map[core.Digest]int64
+	reservations []*reservation
+}
+
+func newBudget(config Config, clk clock.Clock) *budget {
+	return &budget{
+		clk:     clk,
+		limit:   int64(config.DiskBudget),
+		ttl:     config.ReservationTTL,
+		charges: make(map[core.Digest]int64),
+	}
+}
+
+// update applies the limit and reservation ttl of config. Existing charges and
+// reservations are kept, such that lowering the limit below current usage only
+// prevents new charges until usage drops.
+func (b *budget) update(config Config) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.limit = int64(config.DiskBudget)
+	b.ttl = config.ReservationTTL
+}
+
+// free returns the unreserved bytes available. Assumes b is locked.
+func (b *budget) free() int64 {
+	return b.limit - b.used - b.reserved
+}
+
+// expire drops all reservations which have passed their ttl. Assumes b is
+// locked.
+func (b *budget) expire() {
+	now := b.clk.Now()
+	for _, r := range append([]*reservation(nil), b.reservations...) {
+		if now.After(r.expiresAt) {
+			b.drop(r)
+		}
+	}
+}
+
+// drop returns the remaining bytes of r to the budget. Assumes b is locked.
+func (b *budget) drop(r *reservation) {
+	for i, o := range b.reservations {
+		if o == r {
+			b.reservations = append(b.reservations[:i], b.reservations[i+1:]...)
+			b.reserved -= r.remaining
+			r.remaining = 0
+			return
+		}
+	}
+}
+
+func (b *budget) reserve(n int64) (*reservation, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.expire()
+
+	if b.limit > 0 && n > b.free() {
+		return nil, ErrDiskBudgetExceeded
+	}
+	r := &reservation{
+		budget:    b,
+		remaining: n,
+		expiresAt: b.clk.Now().Add(b.ttl),
+	}
+	b.reservations = append(b.reservations, r)
+	b.reserved += n
+	return r, nil
+}
+
+// charge accounts n bytes against the budget for d. Bytes are taken from the
+// unreserved budget first, and then from outstanding reservations. Charging
+// the same digest twice is a no-op.
+func (b *budget) charge(d core.Digest, n int64) error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.expire()
+
+	if _, ok := b.charges[d]; ok {
+		return nil
+	}
+	if b.limit > 0 {
+		need := n - b.free()
+		if need > b.reserved {
+			return ErrDiskBudgetExceeded
+		}
+		for need > 0 {
+			r := b.reservations[0]
+			take := min(r.remaining, need)
+			r.remaining -= take
+			b.reserved -= take
+			need -= take
+			if r.remaining == 0 {
+				b.drop(r)
+			}
+		}
+	}
+	b.used += n
+	b.charges[d] = n
+	return nil
+}
+
+// inflight returns the number of in-flight downloads charged to the budget.
+func (b *budget) inflight() int {
+	b.Lock()
+	defer b.Unlock()
+
+	return len(b.charges)
+}
+
+// release returns the bytes charged for d to the budget.
+func (b *budget) release(d core.Digest) {
+	b.Lock()
+	defer b.Unlock()
+
+	if n, ok := b.charges[d]; ok {
+		delete(b.charges, d)
+		b.used -= n
+	}
+}
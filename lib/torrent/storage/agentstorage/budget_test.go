@@ -0,0 +1,90 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBudgetUnlimited(t *testing.T) {
+	require := require.New(t)
+
+	b := newBudget(Config{}.applyDefaults(), clock.NewMock())
+
+	_, err := b.reserve(1 << 40)
+	require.NoError(err)
+	require.NoError(b.charge(core.DigestFixture(), 1<<40))
+}
+
+func TestBudgetChargeIsIdempotent(t *testing.T) {
+	require := require.New(t)
+
+	b := newBudget(Config{DiskBudget: 10}.applyDefaults(), clock.NewMock())
+
+	d := core.DigestFixture()
+	require.NoError(b.charge(d, 6))
+	require.NoError(b.charge(d, 6))
+
+	b.release(d)
+	b.release(d)
+
+	require.NoError(b.charge(core.DigestFixture(), 10))
+}
+
+func TestBudgetReservationExpires(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	b := newBudget(Config{DiskBudget: 10, ReservationTTL: time.Minute}.applyDefaults(), clk)
+
+	_, err := b.reserve(10)
+	require.NoError(err)
+
+	require.Equal(ErrDiskBudgetExceeded, b.charge(core.DigestFixture(), 10))
+	_, err = b.reserve(1)
+	require.Equal(ErrDiskBudgetExceeded, err)
+
+	clk.Add(time.Minute + time.Second)
+
+	_, err = b.reserve(10)
+	require.NoError(err)
+}
+
+func TestBudgetChargeConsumesOldestReservationFirst(t *testing.T) {
+	require := require.New(t)
+
+	b := newBudget(Config{DiskBudget: 10}.applyDefaults(), clock.NewMock())
+
+	r1, err := b.reserve(4)
+	require.NoError(err)
+	r2, err := b.reserve(4)
+	require.NoError(err)
+
+	require.NoError(b.charge(core.DigestFixture(), 5))
+	require.Equal(int64(1), r1.remaining)
+	require.Equal(int64(4), r2.remaining)
+
+	r1.Release()
+	r2.Release()
+	r2.Release()
+
+	require.NoError(b.charge(core.DigestFixture(), 5))
+	require.Equal(ErrDiskBudgetExceeded, b.charge(core.DigestFixture(), 1))
+}
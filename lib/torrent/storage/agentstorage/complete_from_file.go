@@ -0,0 +1,101 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+)
+
+// CompleteFromFile completes the download of name using a trusted local copy
+// of the blob at path, without transferring from peers or origins. Metainfo is
+// downloaded if the torrent does not already exist. Every piece of the local
+// file is verified against the metainfo before any piece is written, such that
+// a mismatched file aborts without affecting the download. Once all pieces are
+// written, the blob is moved to cache.
+func (a *TorrentArchive) CompleteFromFile(ctx context.Context, namespace, name, path string) error {
+	d, err := core.NewSHA256DigestFromHex(name)
+	if err != nil {
+		return fmt.Errorf("new digest: %s", err)
+	}
+	st, err := a.CreateTorrent(ctx, namespace, d)
+	if err != nil {
+		return fmt.Errorf("create torrent: %s", err)
+	}
+	t := st.(*Torrent)
+	if t.Complete() {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open: %s", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat: %s", err)
+	}
+	if info.Size() != t.Length() {
+		return fmt.Errorf(
+			"file length %d does not match metainfo length %d", info.Size(), t.Length())
+	}
+
+	for pi := 0; pi < t.NumPieces(); pi++ {
+		b, err := readPiece(f, t.metaInfo, pi)
+		if err != nil {
+			return err
+		}
+		h := core.PieceHash()
+		h.Write(b)
+		if h.Sum32() != t.metaInfo.GetPieceSum(pi) {
+			return fmt.Errorf("invalid piece sum for piece %d", pi)
+		}
+	}
+
+	for pi, p := range t.pieces {
+		if p.complete() {
+			continue
+		}
+		b, err := readPiece(f, t.metaInfo, pi)
+		if err != nil {
+			return err
+		}
+		if err := t.WritePiece(piecereader.NewBuffer(b), pi); err != nil && err != storage.ErrPieceComplete {
+			return fmt.Errorf("write piece %d: %s", pi, err)
+		}
+	}
+	if !t.Complete() {
+		return errors.New("torrent incomplete after writing all pieces")
+	}
+	a.stats.Counter("completed_from_file").Inc(1)
+	return nil
+}
+
+// readPiece reads piece pi of mi from f.
+func readPiece(f io.ReaderAt, mi *core.MetaInfo, pi int) ([]byte, error) {
+	b := make([]byte, mi.PieceSize(pi))
+	if _, err := f.ReadAt(b, mi.PieceOffset(pi)); err != nil {
+		return nil, fmt.Errorf("read piece %d: %s", pi, err)
+	}
+	return b, nil
+}
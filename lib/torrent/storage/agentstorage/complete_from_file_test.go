@@ -0,0 +1,128 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTorrentArchiveCompleteFromFile(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(7, 2)
+
+	path, c := testutil.TempFile(blob.Content)
+	defer c()
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+
+	require.NoError(archive.CompleteFromFile(context.Background(), namespace, blob.Digest.Hex(), path))
+
+	tor, err := archive.GetTorrent(context.Background(), namespace, blob.Digest)
+	require.NoError(err)
+	require.True(tor.Complete())
+
+	r, err := mocks.cads.GetCacheFileReader(blob.Digest.Hex())
+	require.NoError(err)
+	defer r.Close()
+	result, err := ioutil.ReadAll(r)
+	require.NoError(err)
+	require.Equal(blob.Content, result)
+
+	// Completing an already complete blob is a no-op.
+	require.NoError(archive.CompleteFromFile(context.Background(), namespace, blob.Digest.Hex(), path))
+}
+
+func TestTorrentArchiveCompleteFromFilePartialDownload(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(8, 2)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+
+	tor, err := archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+	require.NoError(err)
+	require.NoError(tor.WritePiece(piecereader.NewBuffer(blob.Content[2:4]), 1))
+
+	path, c := testutil.TempFile(blob.Content)
+	defer c()
+
+	require.NoError(archive.CompleteFromFile(context.Background(), namespace, blob.Digest.Hex(), path))
+
+	tor, err = archive.GetTorrent(context.Background(), namespace, blob.Digest)
+	require.NoError(err)
+	require.True(tor.Complete())
+}
+
+func TestTorrentArchiveCompleteFromFileMismatch(t *testing.T) {
+	tests := []struct {
+		desc    string
+		corrupt func(b []byte) []byte
+	}{
+		{"corrupt final piece", func(b []byte) []byte {
+			b[len(b)-1]++
+			return b
+		}},
+		{"truncated", func(b []byte) []byte { return b[:len(b)-1] }},
+		{"extended", func(b []byte) []byte { return append(b, 'x') }},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			mocks, cleanup := newArchiveMocks(t)
+			defer cleanup()
+
+			archive := mocks.new()
+
+			namespace := core.TagFixture()
+			blob := core.SizedBlobFixture(8, 2)
+
+			content := test.corrupt(append([]byte(nil), blob.Content...))
+			path, c := testutil.TempFile(content)
+			defer c()
+
+			mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+
+			require.Error(archive.CompleteFromFile(context.Background(), namespace, blob.Digest.Hex(), path))
+
+			// No pieces were written.
+			tor, err := archive.GetTorrent(context.Background(), namespace, blob.Digest)
+			require.NoError(err)
+			require.False(tor.Complete())
+			require.Equal(uint(0), tor.Bitfield().Count())
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/uber/kraken/lib/torrent/storage"
+
+	"github.com/c2h5oh/datasize"
+)
+
+// Config defines TorrentArchive configuration.
+type Config struct {
+	// DiskBudget limits the total bytes of in-flight downloads which the
+	// archive may initialize. If 0, the budget is unlimited.
+	DiskBudget datasize.ByteSize `yaml:"disk_budget"`
+
+	// ReservationTTL is the duration a Reservation holds budget before it
+	// expires and its unconsumed portion is returned.
+	ReservationTTL time.Duration `yaml:"reservation_ttl"`
+
+	// ValidateOnGet controls how thoroughly existing on-disk state is validated
+	// before GetTorrent and CreateTorrent (when the torrent already exists)
+	// return a Torrent. Torrents which fail validation are deleted so they may
+	// be downloaded again. Defaults to none.
+	ValidateOnGet ValidationLevel `yaml:"validate_on_get"`
+
+	// VerifyOrder controls the order pieces are checked in during validation
+	// and Verify. Defaults to sequential.
+	VerifyOrder VerifyOrder `yaml:"verify_order"`
+
+	// HeartbeatInterval is the interval in which ArchiveHealth heartbeats are
+	// sent to the HeartbeatSink, if one is provided.
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+
+	// MetaInfoCircuitBreaker fails metainfo downloads fast while the tracker
+	// is consistently unavailable.
+	MetaInfoCircuitBreaker CircuitBreakerConfig `yaml:"metainfo_circuit_breaker"`
+
+	// FailFastWhenPaused causes CreateTorrent to return ErrDownloadsPaused
+	// instead of blocking when a new download is initialized while downloads
+	// are paused.
+	FailFastWhenPaused bool `yaml:"fail_fast_when_paused"`
+
+	// MaxPieceCount rejects metainfo with more pieces than the limit, which
+	// would otherwise create huge bitfields and piece metadata. If 0, the
+	// piece count is unlimited.
+	MaxPieceCount int `yaml:"max_piece_count"`
+
+	// SwarmAvailabilityTTL is the duration swarm availability snapshots learned
+	// from announce responses are cached for.
+	SwarmAvailabilityTTL time.Duration `yaml:"swarm_availability_ttl"`
+
+	// EnforceNamespace causes Stat, GetTorrent and CreateTorrent to return
+	// storage.ErrNamespaceMismatch when a torrent is accessed under a different
+	// namespace than it was initialized under. Disabled by default, since
+	// blobs such as docker layers are commonly shared across namespaces.
+	EnforceNamespace bool `yaml:"enforce_namespace"`
+
+	// DeleteParallelism bounds the number of concurrent deletes in
+	// DeleteTorrents.
+	DeleteParallelism int `yaml:"delete_parallelism"`
+
+	// Quota limits the total bytes of files in the download and cache
+	// directories.
+	Quota QuotaConfig `yaml:"quota"`
+
+	// Durability controls consistency between piece data and piece status
+	// across crashes.
+	Durability DurabilityConfig `yaml:"durability"`
+
+	// MetaInfoCache caches metainfo in memory.
+	MetaInfoCache MetaInfoCacheConfig `yaml:"metainfo_cache"`
+
+	// ConsistencyCheck periodically samples blobs for drift between piece
+	// bookkeeping and data files.
+	ConsistencyCheck ConsistencyCheckConfig `yaml:"consistency_check"`
+
+	// Mmap serves piece reads of complete torrents from memory-mapped files.
+	Mmap MmapConfig `yaml:"mmap"`
+
+	// WriteQueue signals backpressure to the scheduler when piece writes back
+	// up.
+	WriteQueue storage.WriteQueueConfig `yaml:"write_queue"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.ReservationTTL == 0 {
+		c.ReservationTTL = 10 * time.Minute
+	}
+	if c.ValidateOnGet == "" {
+		c.ValidateOnGet = ValidateNone
+	}
+	if c.VerifyOrder == "" {
+		c.VerifyOrder = VerifySequential
+	}
+	if c.HeartbeatInterval == 0 {
+		c.HeartbeatInterval = time.Minute
+	}
+	if c.DeleteParallelism == 0 {
+		c.DeleteParallelism = 16
+	}
+	if c.SwarmAvailabilityTTL == 0 {
+		c.SwarmAvailabilityTTL = 30 * time.Second
+	}
+	c.MetaInfoCircuitBreaker = c.MetaInfoCircuitBreaker.applyDefaults()
+	c.ConsistencyCheck = c.ConsistencyCheck.applyDefaults()
+	c.MetaInfoCache = c.MetaInfoCache.applyDefaults()
+	c.Durability = c.Durability.applyDefaults()
+	c.Mmap = c.Mmap.applyDefaults()
+	return c
+}
+
+func (c Config) validate() error {
+	switch c.ValidateOnGet {
+	case ValidateNone, ValidateLength, ValidateSample, ValidateFull:
+	default:
+		return fmt.Errorf("invalid validate_on_get: %q", string(c.ValidateOnGet))
+	}
+	switch c.VerifyOrder {
+	case VerifySequential, VerifyFailuresFirst:
+	default:
+		return fmt.Errorf("invalid verify_order: %q", string(c.VerifyOrder))
+	}
+	switch c.Durability.VerifyOnResume {
+	case ValidateNone, ValidateSample, ValidateFull:
+	default:
+		return fmt.Errorf(
+			"invalid durability.verify_on_resume: %q", string(c.Durability.VerifyOnResume))
+	}
+	if c.ReservationTTL < 0 {
+		return errors.New("reservation_ttl must be positive")
+	}
+	if c.SwarmAvailabilityTTL < 0 {
+		return errors.New("swarm_availability_ttl must be positive")
+	}
+	if c.DeleteParallelism < 0 {
+		return errors.New("delete_parallelism must be positive")
+	}
+	if c.MaxPieceCount < 0 {
+		return errors.New("max_piece_count must be non-negative")
+	}
+	if c.MetaInfoCircuitBreaker.FailureThreshold < 0 {
+		return errors.New("metainfo_circuit_breaker.failure_threshold must be positive")
+	}
+	if c.MetaInfoCircuitBreaker.Cooldown < 0 {
+		return errors.New("metainfo_circuit_breaker.cooldown must be positive")
+	}
+	if c.MetaInfoCache.Size < 0 {
+		return errors.New("metainfo_cache.size must be positive")
+	}
+	if c.MetaInfoCache.TTL < 0 {
+		return errors.New("metainfo_cache.ttl must be positive")
+	}
+	switch c.Mmap.Advice {
+	case MmapAdviceNormal, MmapAdviceRandom, MmapAdviceSequential:
+	default:
+		return fmt.Errorf("invalid mmap.advice: %q", string(c.Mmap.Advice))
+	}
+	if c.Mmap.MaxMappings < 0 {
+		return errors.New("mmap.max_mappings must be positive")
+	}
+	if err := c.Quota.validate(); err != nil {
+		return err
+	}
+	if r := c.ConsistencyCheck.SampleRate; r < 0 || r > 1 {
+		return errors.New("consistency_check.sample_rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// checkImmutable returns an error if c changes fields of old which cannot be
+// updated without restarting the archive.
+func (c Config) checkImmutable(old Config) error {
+	if c.HeartbeatInterval != old.HeartbeatInterval {
+		return errors.New("heartbeat_interval cannot be changed without restart")
+	}
+	if c.ConsistencyCheck.Enabled != old.ConsistencyCheck.Enabled ||
+		c.ConsistencyCheck.Interval != old.ConsistencyCheck.Interval {
+		return errors.New("consistency_check.enabled and consistency_check.interval " +
+			"cannot be changed without restart")
+	}
+	if c.MetaInfoCache.Size != old.MetaInfoCache.Size {
+		return errors.New("metainfo_cache.size cannot be changed without restart")
+	}
+	if c.Mmap.MaxMappings != old.Mmap.MaxMappings {
+		return errors.New("mmap.max_mappings cannot be changed without restart")
+	}
+	return nil
+}
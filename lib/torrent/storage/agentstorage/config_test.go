@@ -0,0 +1,68 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		desc  string
+		input Config
+		valid bool
+	}{
+		{"defaults", Config{}, true},
+		{"all fields", Config{
+			DiskBudget:     1024,
+			ReservationTTL: time.Minute,
+			ValidateOnGet:  ValidateSample,
+			VerifyOrder:    VerifyFailuresFirst,
+			MaxPieceCount:  100,
+		}, true},
+		{"invalid validation level", Config{ValidateOnGet: "bogus"}, false},
+		{"invalid verify order", Config{VerifyOrder: "bogus"}, false},
+		{"negative reservation ttl", Config{ReservationTTL: -time.Second}, false},
+		{"negative max piece count", Config{MaxPieceCount: -1}, false},
+		{"negative delete parallelism", Config{DeleteParallelism: -1}, false},
+		{"negative failure threshold", Config{
+			MetaInfoCircuitBreaker: CircuitBreakerConfig{FailureThreshold: -1},
+		}, false},
+		{"negative cooldown", Config{
+			MetaInfoCircuitBreaker: CircuitBreakerConfig{Cooldown: -time.Second},
+		}, false},
+		{"invalid verify on resume", Config{
+			Durability: DurabilityConfig{VerifyOnResume: ValidateLength},
+		}, false},
+		{"negative metainfo cache size", Config{
+			MetaInfoCache: MetaInfoCacheConfig{Size: -1},
+		}, false},
+		{"negative metainfo cache ttl", Config{
+			MetaInfoCache: MetaInfoCacheConfig{TTL: -time.Second},
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			err := test.input.applyDefaults().validate()
+			if test.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
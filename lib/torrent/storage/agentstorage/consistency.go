@@ -0,0 +1,168 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/andres-erbsen/clock"
+)
+
+// ConsistencyCheckConfig defines configuration for the background check which
+// compares piece bookkeeping against data files. The check only stats files
+// and reads metadata, so it is far cheaper than Verify, but still catches
+// bookkeeping bugs such as complete pieces claimed for a truncated file.
+type ConsistencyCheckConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Interval is the duration between checks.
+	Interval time.Duration `yaml:"interval"`
+
+	// SampleRate is the fraction of blobs checked on each interval, between 0
+	// and 1. Keep low to bound disk IO on nodes with many blobs.
+	SampleRate float64 `yaml:"sample_rate"`
+
+	// VerifyOnDrift fully verifies blobs for which drift is detected.
+	VerifyOnDrift bool `yaml:"verify_on_drift"`
+}
+
+func (c ConsistencyCheckConfig) applyDefaults() ConsistencyCheckConfig {
+	if c.Interval == 0 {
+		c.Interval = 10 * time.Minute
+	}
+	if c.SampleRate == 0 {
+		c.SampleRate = 0.01
+	}
+	return c
+}
+
+// checkBlobConsistency returns an error describing drift between the piece
+// bookkeeping of name and its data file. Blobs deleted or initialized
+// concurrently with the check are skipped.
+func (a *TorrentArchive) checkBlobConsistency(name string) error {
+	var tm metadata.TorrentMeta
+	if err := a.cads.Any().GetMetadata(name, &tm); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("get metainfo: %s", err)
+	}
+	mi := tm.MetaInfo
+
+	info, err := a.cads.Any().GetFileStat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("stat: %s", err)
+	}
+	if info.Size() != mi.Length() {
+		return fmt.Errorf(
+			"file length %d does not match metainfo length %d", info.Size(), mi.Length())
+	}
+
+	if _, err := a.cads.Download().GetFileStat(name); err != nil {
+		// Cached blobs have no piece bookkeeping beyond the file itself.
+		return nil
+	}
+	var psm pieceStatusMetadata
+	if err := a.cads.Download().GetMetadata(name, &psm); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("get piece status: %s", err)
+	}
+	if len(psm.pieces) != mi.NumPieces() {
+		return fmt.Errorf(
+			"piece status has %d pieces, metainfo has %d", len(psm.pieces), mi.NumPieces())
+	}
+	var complete int
+	for _, p := range psm.pieces {
+		if p.status == _complete {
+			complete++
+		}
+	}
+	if complete == mi.NumPieces() && complete > 0 {
+		return fmt.Errorf("all %d pieces claimed complete but blob not in cache", complete)
+	}
+	return nil
+}
+
+// checkConsistency checks a random sample of blobs for drift, returning the
+// number of blobs with drift.
+func (a *TorrentArchive) checkConsistency() (int, error) {
+	if err := a.enter(); err != nil {
+		return 0, err
+	}
+	defer a.exit()
+
+	config := a.getConfig().ConsistencyCheck
+
+	names, err := a.cads.Any().ListNames()
+	if err != nil {
+		return 0, fmt.Errorf("list names: %s", err)
+	}
+	var drift int
+	for _, name := range names {
+		if rand.Float64() >= config.SampleRate {
+			continue
+		}
+		a.stats.Counter("consistency_checks").Inc(1)
+		err := a.checkBlobConsistency(name)
+		if err == nil {
+			continue
+		}
+		drift++
+		a.stats.Counter("consistency_drift").Inc(1)
+		log.With("name", name).Errorf("Consistency drift detected: %s", err)
+		if config.VerifyOnDrift {
+			if err := a.verifyName(name); err != nil {
+				log.With("name", name).Errorf("Verify after consistency drift failed: %s", err)
+			}
+		}
+	}
+	return drift, nil
+}
+
+func (a *TorrentArchive) verifyName(name string) error {
+	d, err := core.NewSHA256DigestFromHex(name)
+	if err != nil {
+		return fmt.Errorf("new digest: %s", err)
+	}
+	return a.verify(d)
+}
+
+// consistencyLoop runs checkConsistency on every tick until a is closed.
+func (a *TorrentArchive) consistencyLoop(ticker *clock.Ticker) {
+	defer a.wg.Done()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+			if _, err := a.checkConsistency(); err != nil {
+				log.Errorf("Error checking archive consistency: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTorrentArchiveCheckConsistency(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{
+		ConsistencyCheck: ConsistencyCheckConfig{SampleRate: 1},
+	})
+
+	namespace := core.TagFixture()
+	blob1 := core.SizedBlobFixture(4, 2)
+	blob2 := core.SizedBlobFixture(4, 2)
+	blob3 := core.SizedBlobFixture(4, 4)
+
+	for _, blob := range []*core.BlobFixture{blob1, blob2, blob3} {
+		mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+		_, err := archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+		require.NoError(err)
+	}
+
+	// Complete blob3, such that it is checked in the cache.
+	tor, err := archive.GetTorrent(context.Background(), namespace, blob3.Digest)
+	require.NoError(err)
+	require.NoError(tor.WritePiece(piecereader.NewBuffer(blob3.Content), 0))
+
+	n, err := archive.checkConsistency()
+	require.NoError(err)
+	require.Equal(0, n)
+
+	// Extend the data file of blob2 past its metainfo length.
+	f, err := mocks.cads.GetDownloadFileReadWriter(blob2.Digest.Hex())
+	require.NoError(err)
+	_, err = f.WriteAt([]byte{0}, blob2.MetaInfo.Length())
+	require.NoError(err)
+	require.NoError(f.Close())
+
+	require.NoError(archive.checkBlobConsistency(blob1.Digest.Hex()))
+	require.Error(archive.checkBlobConsistency(blob2.Digest.Hex()))
+	require.NoError(archive.checkBlobConsistency(blob3.Digest.Hex()))
+
+	n, err = archive.checkConsistency()
+	require.NoError(err)
+	require.Equal(1, n)
+}
+
+func TestTorrentArchiveCheckConsistencyVerifyOnDrift(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{
+		ConsistencyCheck: ConsistencyCheckConfig{SampleRate: 1, VerifyOnDrift: true},
+	})
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(4, 2)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+	_, err := archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+	require.NoError(err)
+
+	f, err := mocks.cads.GetDownloadFileReadWriter(blob.Digest.Hex())
+	require.NoError(err)
+	_, err = f.WriteAt([]byte{0}, blob.MetaInfo.Length())
+	require.NoError(err)
+	require.NoError(f.Close())
+
+	n, err := archive.checkConsistency()
+	require.NoError(err)
+	require.Equal(1, n)
+
+	var md lastVerifiedMetadata
+	require.NoError(mocks.cads.Any().GetMetadata(blob.Digest.Hex(), &md))
+	require.False(md.ok)
+}
+
+func TestTorrentArchiveCheckConsistencySampleRate(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{
+		ConsistencyCheck: ConsistencyCheckConfig{SampleRate: 1},
+	})
+	require.NoError(archive.UpdateConfig(Config{
+		ConsistencyCheck: ConsistencyCheckConfig{SampleRate: 0.000001},
+	}))
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(4, 2)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+	_, err := archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+	require.NoError(err)
+
+	f, err := mocks.cads.GetDownloadFileReadWriter(blob.Digest.Hex())
+	require.NoError(err)
+	_, err = f.WriteAt([]byte{0}, blob.MetaInfo.Length())
+	require.NoError(err)
+	require.NoError(f.Close())
+
+	// Practically never sampled.
+	n, err := archive.checkConsistency()
+	require.NoError(err)
+	require.Equal(0, n)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/errutil"
+)
+
+// DeleteTorrents deletes the torrents of names from disk, running up to the
+// configured delete parallelism deletes concurrently. Names which do not
+// exist are ignored. All names are attempted even if some fail, and failures
+// are returned as an errutil.MultiError.
+func (a *TorrentArchive) DeleteTorrents(names []string) error {
+	if err := a.enter(); err != nil {
+		return err
+	}
+	defer a.exit()
+
+	var mu sync.Mutex
+	var errs []error
+	fail := func(name string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, fmt.Errorf("%s: %s", name, err))
+	}
+
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < a.getConfig().DeleteParallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				d, err := core.NewSHA256DigestFromHex(name)
+				if err != nil {
+					fail(name, err)
+					continue
+				}
+				if err := a.deleteTorrent(d); err != nil {
+					fail(name, err)
+					continue
+				}
+				a.stats.Counter("batch_deleted").Inc(1)
+			}
+		}()
+	}
+	for _, name := range names {
+		work <- name
+	}
+	close(work)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		a.stats.Counter("batch_delete_errors").Inc(int64(len(errs)))
+	}
+	return errutil.Join(errs)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/errutil"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTorrentArchiveDeleteTorrents(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{DeleteParallelism: 2})
+
+	namespace := core.TagFixture()
+	var names []string
+	for i := 0; i < 10; i++ {
+		mi := core.SizedBlobFixture(4, 1).MetaInfo
+		mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil)
+		_, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+		require.NoError(err)
+		names = append(names, mi.Digest().Hex())
+	}
+	// Names which do not exist are ignored.
+	names = append(names, core.DigestFixture().Hex())
+
+	require.NoError(archive.DeleteTorrents(names))
+
+	for _, name := range names {
+		_, err := mocks.cads.Any().GetFileStat(name)
+		require.True(os.IsNotExist(err))
+	}
+}
+
+func TestTorrentArchiveDeleteTorrentsAggregatesErrors(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	mi := core.SizedBlobFixture(4, 1).MetaInfo
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil)
+	_, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+	require.NoError(err)
+
+	err = archive.DeleteTorrents([]string{"foo", mi.Digest().Hex(), "bar"})
+	require.Error(err)
+	require.Len(err.(errutil.MultiError), 2)
+
+	// Valid names are deleted despite other failures.
+	_, err = mocks.cads.Any().GetFileStat(mi.Digest().Hex())
+	require.True(os.IsNotExist(err))
+}
+
+func TestTorrentArchiveDeleteTorrentsClosed(t *testing.T) {
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+	require.NoError(t, archive.Close())
+
+	require.Equal(t, ErrClosed, archive.DeleteTorrents([]string{core.DigestFixture().Hex()}))
+}
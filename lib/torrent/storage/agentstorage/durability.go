@@ -0,0 +1,191 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/log"
+)
+
+// DurabilityConfig defines how piece data and piece status are kept consistent
+// across crashes. By default, piece status is recorded once piece data is
+// written to the page cache, so a crash may leave pieces marked complete whose
+// data was never flushed.
+type DurabilityConfig struct {
+	// SyncPieces flushes piece data to disk before the piece is recorded as
+	// complete, such that piece status never claims data lost in a crash.
+	// Costs an fsync per piece. If false, pieces are flushed according to the
+	// fsync policy of the store.
+	SyncPieces bool `yaml:"sync_pieces"`
+
+	// ReconcileOnStartup hashes every complete piece of in-progress downloads
+	// when the archive is created, marking pieces which fail verification as
+	// empty so they are downloaded again. Recovers downloads written without
+	// SyncPieces, at the cost of reading every in-progress download on startup.
+	ReconcileOnStartup bool `yaml:"reconcile_on_startup"`
+
+	// VerifyOnResume re-hashes the complete pieces of an in-progress download
+	// the first time it is loaded after a restart, before the Torrent is
+	// returned and announced, marking pieces which fail verification as empty.
+	// Unlike ReconcileOnStartup, the cost is spread across downloads as they
+	// resume. Either ValidateNone, ValidateSample or ValidateFull. Defaults to
+	// none.
+	VerifyOnResume ValidationLevel `yaml:"verify_on_resume"`
+}
+
+func (c DurabilityConfig) applyDefaults() DurabilityConfig {
+	if c.VerifyOnResume == "" {
+		c.VerifyOnResume = ValidateNone
+	}
+	return c
+}
+
+// syncer is implemented by files which can be flushed to disk.
+type syncer interface {
+	Sync() error
+}
+
+// reconcileBlob verifies the complete pieces of the in-progress download name
+// per level, marking invalid pieces as empty. Returns the number of pieces
+// marked empty. Blobs not in the download directory, or which crashed before
+// their metadata was initialized, are skipped since they have no pieces to
+// reconcile.
+func (a *TorrentArchive) reconcileBlob(name string, level ValidationLevel) (int, error) {
+	var tm metadata.TorrentMeta
+	if err := a.cads.Download().GetMetadata(name, &tm); err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("get metainfo: %s", err)
+	}
+	mi := tm.MetaInfo
+
+	var psm pieceStatusMetadata
+	if err := a.cads.Download().GetMetadata(name, &psm); err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("get piece status: %s", err)
+	}
+	if len(psm.pieces) != mi.NumPieces() {
+		return 0, fmt.Errorf(
+			"piece status has %d pieces, metainfo has %d", len(psm.pieces), mi.NumPieces())
+	}
+
+	f, err := a.cads.GetDownloadFileReadWriter(name)
+	if err != nil {
+		return 0, fmt.Errorf("get download file: %s", err)
+	}
+	defer f.Close()
+
+	var complete []int
+	for i, p := range psm.pieces {
+		if p.status == _complete {
+			complete = append(complete, i)
+		}
+	}
+	if level == ValidateSample && len(complete) > 0 {
+		complete = []int{complete[rand.Intn(len(complete))]}
+	}
+
+	var reset int
+	for _, i := range complete {
+		if ok, err := checkPiece(f, mi, i); err != nil {
+			return reset, err
+		} else if ok {
+			continue
+		}
+		if _, err := a.cads.Download().SetMetadataAt(
+			name, &pieceStatusMetadata{}, []byte{byte(_empty)}, int64(i)); err != nil {
+			return reset, fmt.Errorf("reset piece %d: %s", i, err)
+		}
+		reset++
+	}
+	return reset, nil
+}
+
+// checkPiece returns whether the content of piece pi in f matches its piece sum.
+func checkPiece(f io.ReaderAt, mi *core.MetaInfo, pi int) (bool, error) {
+	b, err := readPiece(f, mi, pi)
+	if err != nil {
+		return false, err
+	}
+	h := core.PieceHash()
+	h.Write(b)
+	return h.Sum32() == mi.GetPieceSum(pi), nil
+}
+
+// reconcile verifies every in-progress download, such that piece status only
+// claims pieces whose data survived the last shutdown.
+func (a *TorrentArchive) reconcile() {
+	names, err := a.cads.Download().ListNames()
+	if err != nil {
+		log.Errorf("Error listing downloads for reconciliation: %s", err)
+		return
+	}
+	for _, name := range names {
+		reset, err := a.reconcileBlob(name, ValidateFull)
+		if err != nil {
+			log.With("name", name).Errorf("Error reconciling download: %s", err)
+			continue
+		}
+		a.stats.Counter("reconciled_downloads").Inc(1)
+		if reset > 0 {
+			a.stats.Counter("reconciled_pieces_reset").Inc(int64(reset))
+			log.With("name", name).Warnf("Reset %d pieces which failed verification on startup", reset)
+		}
+	}
+}
+
+// resumeVerifier verifies each in-progress download once per process, the
+// first time it is loaded.
+type resumeVerifier struct {
+	verified sync.Map // core.Digest -> *sync.Once
+}
+
+// verifyOnResume reconciles d per the configured level if it has not been
+// loaded since the archive was created. Concurrent callers for the same digest
+// block until verification completes, such that no caller restores piece
+// status which is about to be reset.
+func (a *TorrentArchive) verifyOnResume(d core.Digest) {
+	level := a.getConfig().Durability.VerifyOnResume
+	if level == ValidateNone {
+		return
+	}
+	once, _ := a.resumes.verified.LoadOrStore(d, new(sync.Once))
+	once.(*sync.Once).Do(func() {
+		reset, err := a.reconcileBlob(d.Hex(), level)
+		if err != nil {
+			log.With("name", d.Hex()).Errorf("Error verifying resumed download: %s", err)
+			return
+		}
+		a.stats.Counter("resume_verifications").Inc(1)
+		if reset > 0 {
+			a.stats.Counter("resume_pieces_reset").Inc(int64(reset))
+			log.With("name", d.Hex()).Warnf("Reset %d pieces which failed verification on resume", reset)
+		}
+	})
+}
+
+// forget allows d to be verified again, e.g. once it has been deleted.
+func (v *resumeVerifier) forget(d core.Digest) {
+	v.verified.Delete(d)
+}
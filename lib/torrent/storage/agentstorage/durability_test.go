@@ -0,0 +1,166 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+	"github.com/uber/kraken/utils/bitsetutil"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestTorrentArchiveSyncPieces(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{
+		Durability: DurabilityConfig{SyncPieces: true},
+	})
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(4, 1)
+	mi := blob.MetaInfo
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil)
+
+	tor, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+	require.NoError(err)
+
+	for i := 0; i < tor.NumPieces(); i++ {
+		require.NoError(tor.WritePiece(piecereader.NewBuffer(blob.Content[i:i+1]), i))
+	}
+	require.True(tor.Complete())
+}
+
+func TestTorrentArchiveReconcileOnStartup(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(4, 1)
+	mi := blob.MetaInfo
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil)
+
+	tor, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+	require.NoError(err)
+
+	require.NoError(tor.WritePiece(piecereader.NewBuffer(blob.Content[0:1]), 0))
+	require.NoError(tor.WritePiece(piecereader.NewBuffer(blob.Content[1:2]), 1))
+	require.NoError(archive.Close())
+
+	// Simulate piece 1 being lost in a crash after its status was recorded.
+	f, err := mocks.cads.GetDownloadFileReadWriter(mi.Digest().Hex())
+	require.NoError(err)
+	_, err = f.WriteAt([]byte{^blob.Content[1]}, 1)
+	require.NoError(err)
+	require.NoError(f.Close())
+
+	stats := tally.NewTestScope("", nil)
+	archive = NewTorrentArchive(
+		Config{Durability: DurabilityConfig{ReconcileOnStartup: true}},
+		stats, mocks.clk, mocks.cads, mocks.metaInfoClient)
+	defer archive.Close()
+
+	info, err := archive.Stat(context.Background(), namespace, mi.Digest())
+	require.NoError(err)
+	require.Equal(bitsetutil.FromBools(true, false, false, false), info.Bitfield())
+
+	counters := stats.Snapshot().Counters()
+	require.Equal(int64(1), counters["reconciled_downloads+module=agenttorrentarchive"].Value())
+	require.Equal(int64(1), counters["reconciled_pieces_reset+module=agenttorrentarchive"].Value())
+
+	// The reset piece can be downloaded again.
+	tor, err = archive.GetTorrent(context.Background(), namespace, mi.Digest())
+	require.NoError(err)
+	require.NoError(tor.WritePiece(piecereader.NewBuffer(blob.Content[1:2]), 1))
+}
+
+func TestTorrentArchiveReconcileSkipsUninitializedDownloads(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	// Simulate a crash between creating the download file and setting metainfo.
+	d := core.DigestFixture()
+	require.NoError(mocks.cads.CreateDownloadFile(d.Hex(), 4))
+
+	reset, err := archive.reconcileBlob(d.Hex(), ValidateFull)
+	require.NoError(err)
+	require.Equal(0, reset)
+}
+
+func TestTorrentArchiveVerifyOnResume(t *testing.T) {
+	for _, level := range []ValidationLevel{ValidateSample, ValidateFull} {
+		t.Run(string(level), func(t *testing.T) {
+			require := require.New(t)
+
+			mocks, cleanup := newArchiveMocks(t)
+			defer cleanup()
+
+			archive := mocks.new()
+
+			namespace := core.TagFixture()
+			blob := core.SizedBlobFixture(4, 1)
+			mi := blob.MetaInfo
+
+			mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil)
+
+			tor, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+			require.NoError(err)
+			require.NoError(tor.WritePiece(piecereader.NewBuffer(blob.Content[1:2]), 1))
+			require.NoError(archive.Close())
+
+			// Simulate piece 1 being lost in a crash after its status was recorded.
+			f, err := mocks.cads.GetDownloadFileReadWriter(mi.Digest().Hex())
+			require.NoError(err)
+			_, err = f.WriteAt([]byte{^blob.Content[1]}, 1)
+			require.NoError(err)
+			require.NoError(f.Close())
+
+			stats := tally.NewTestScope("", nil)
+			archive = NewTorrentArchive(
+				Config{Durability: DurabilityConfig{VerifyOnResume: level}},
+				stats, mocks.clk, mocks.cads, mocks.metaInfoClient)
+			defer archive.Close()
+
+			tor, err = archive.GetTorrent(context.Background(), namespace, mi.Digest())
+			require.NoError(err)
+			require.Equal(bitsetutil.FromBools(false, false, false, false), tor.Bitfield())
+
+			// Only verified once per process.
+			_, err = archive.GetTorrent(context.Background(), namespace, mi.Digest())
+			require.NoError(err)
+
+			counters := stats.Snapshot().Counters()
+			require.Equal(int64(1), counters["resume_verifications+module=agenttorrentarchive"].Value())
+			require.Equal(int64(1), counters["resume_pieces_reset+module=agenttorrentarchive"].Value())
+		})
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/uber/kraken/lib/torrent/storage"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+	"gopkg.in/yaml.v2"
+)
+
+// Name is the name agent TorrentArchives are registered with.
+const Name = "agent"
+
+func init() {
+	storage.RegisterArchive(Name, factory{})
+}
+
+type factory struct{}
+
+func (f factory) Create(
+	configRaw interface{}, deps storage.ArchiveDeps) (storage.TorrentArchive, error) {
+
+	if deps.CADownloadStore == nil || deps.MetaInfoClient == nil {
+		return nil, errors.New("ca download store and metainfo client are required")
+	}
+	config, ok := configRaw.(Config)
+	if !ok && configRaw != nil {
+		b, err := yaml.Marshal(configRaw)
+		if err != nil {
+			return nil, fmt.Errorf("marshal config: %s", err)
+		}
+		if err := yaml.Unmarshal(b, &config); err != nil {
+			return nil, fmt.Errorf("unmarshal config: %s", err)
+		}
+	}
+	if err := config.applyDefaults().validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %s", err)
+	}
+	stats := deps.Stats
+	if stats == nil {
+		stats = tally.NoopScope
+	}
+	clk := deps.Clock
+	if clk == nil {
+		clk = clock.New()
+	}
+	return NewTorrentArchive(config, stats, clk, deps.CADownloadStore, deps.MetaInfoClient), nil
+}
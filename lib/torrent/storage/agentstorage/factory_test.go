@@ -0,0 +1,87 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/lib/torrent/storage"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestFactoryCreateFromYAML(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	var raw interface{}
+	require.NoError(yaml.Unmarshal([]byte("max_piece_count: 10\nheartbeat_interval: 5s"), &raw))
+
+	a, err := storage.NewArchive(Name, raw, storage.ArchiveDeps{
+		CADownloadStore: mocks.cads,
+		MetaInfoClient:  mocks.metaInfoClient,
+	})
+	require.NoError(err)
+
+	archive := a.(*TorrentArchive)
+	defer archive.Close()
+
+	require.Equal(10, archive.getConfig().MaxPieceCount)
+	require.Equal(5*time.Second, archive.getConfig().HeartbeatInterval)
+}
+
+func TestFactoryCreateFromConfig(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	a, err := storage.NewArchive(Name, Config{MaxPieceCount: 10}, storage.ArchiveDeps{
+		CADownloadStore: mocks.cads,
+		MetaInfoClient:  mocks.metaInfoClient,
+	})
+	require.NoError(err)
+
+	archive := a.(*TorrentArchive)
+	defer archive.Close()
+
+	require.Equal(10, archive.getConfig().MaxPieceCount)
+}
+
+func TestFactoryCreateErrors(t *testing.T) {
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	tests := []struct {
+		desc   string
+		config interface{}
+		deps   storage.ArchiveDeps
+	}{
+		{"missing dependencies", nil, storage.ArchiveDeps{}},
+		{"invalid config", Config{VerifyOrder: "bogus"}, storage.ArchiveDeps{
+			CADownloadStore: mocks.cads,
+			MetaInfoClient:  mocks.metaInfoClient,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := storage.NewArchive(Name, test.config, test.deps)
+			require.Error(t, err)
+		})
+	}
+}
@@ -14,17 +14,21 @@
 package agentstorage
 
 import (
+	"context"
+
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/tracker/metainfoclient"
 	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/andres-erbsen/clock"
 	"github.com/uber-go/tally"
 )
 
 // TorrentArchiveFixture returns a TorrrentArchive for testing purposes.
 func TorrentArchiveFixture() (*TorrentArchive, func()) {
 	cads, cleanup := store.CADownloadStoreFixture()
-	archive := NewTorrentArchive(tally.NoopScope, cads, nil)
+	archive := NewTorrentArchive(Config{}, tally.NoopScope, clock.New(), cads, nil)
 	return archive, cleanup
 }
 
@@ -38,13 +42,13 @@ func TorrentFixture(mi *core.MetaInfo) (*Torrent, func()) {
 
 	tc := metainfoclient.NewTestClient()
 
-	ta := NewTorrentArchive(tally.NoopScope, cads, tc)
+	ta := NewTorrentArchive(Config{}, tally.NoopScope, clock.New(), cads, tc)
 
 	if err := tc.Upload(mi); err != nil {
 		panic(err)
 	}
 
-	t, err := ta.CreateTorrent("noexist", mi.Digest())
+	t, err := ta.CreateTorrent(context.Background(), "noexist", mi.Digest())
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,96 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/andres-erbsen/clock"
+)
+
+// ArchiveHealth is a compact snapshot of TorrentArchive state, suitable for
+// central fleet monitoring.
+type ArchiveHealth struct {
+	BlobCount         int     `json:"blob_count"`
+	Bytes             int64   `json:"bytes"`
+	HitRatio          float64 `json:"hit_ratio"`
+	InFlightDownloads int     `json:"in_flight_downloads"`
+	Version           string  `json:"version"`
+}
+
+// HeartbeatSink receives periodic ArchiveHealth heartbeats.
+type HeartbeatSink interface {
+	Send(h ArchiveHealth) error
+}
+
+// Health returns an ArchiveHealth snapshot of a.
+func (a *TorrentArchive) Health() (ArchiveHealth, error) {
+	if err := a.enter(); err != nil {
+		return ArchiveHealth{}, err
+	}
+	defer a.exit()
+
+	names, err := a.cads.Any().ListNames()
+	if err != nil {
+		return ArchiveHealth{}, fmt.Errorf("list names: %s", err)
+	}
+	var bytes int64
+	for _, name := range names {
+		info, err := a.cads.Any().GetFileStat(name)
+		if err != nil {
+			// File may have been deleted since listing.
+			continue
+		}
+		bytes += info.Size()
+	}
+	var hitRatio float64
+	hits, misses := a.hits.Load(), a.misses.Load()
+	if hits+misses > 0 {
+		hitRatio = float64(hits) / float64(hits+misses)
+	}
+	return ArchiveHealth{
+		BlobCount:         len(names),
+		Bytes:             bytes,
+		HitRatio:          hitRatio,
+		InFlightDownloads: a.budget.inflight(),
+		Version:           os.Getenv("GIT_DESCRIBE"),
+	}, nil
+}
+
+// heartbeat sends Health snapshots to sink on every tick until a is closed.
+func (a *TorrentArchive) heartbeat(sink HeartbeatSink, ticker *clock.Ticker) {
+	defer a.wg.Done()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+			h, err := a.Health()
+			if err != nil {
+				a.stats.Counter("heartbeat_failures").Inc(1)
+				log.Errorf("Error assembling archive heartbeat: %s", err)
+				continue
+			}
+			if err := sink.Send(h); err != nil {
+				a.stats.Counter("heartbeat_failures").Inc(1)
+				log.Errorf("Error sending archive heartbeat: %s", err)
+			}
+		}
+	}
+}
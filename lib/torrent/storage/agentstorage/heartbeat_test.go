@@ -0,0 +1,102 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+type chanSink struct {
+	c   chan ArchiveHealth
+	err error
+}
+
+func (s *chanSink) Send(h ArchiveHealth) error {
+	s.c <- h
+	return s.err
+}
+
+func TestTorrentArchiveHealth(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+	defer archive.Close()
+
+	namespace := core.TagFixture()
+	blob1 := core.SizedBlobFixture(8, 8)
+	blob2 := core.SizedBlobFixture(4, 4)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob1.Digest).Return(blob1.MetaInfo, nil)
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob2.Digest).Return(blob2.MetaInfo, nil)
+
+	tor, err := archive.CreateTorrent(context.Background(), namespace, blob1.Digest)
+	require.NoError(err)
+	require.NoError(tor.WritePiece(piecereader.NewBuffer(blob1.Content), 0))
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, blob2.Digest)
+	require.NoError(err)
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, blob2.Digest)
+	require.NoError(err)
+
+	h, err := archive.Health()
+	require.NoError(err)
+	require.Equal(2, h.BlobCount)
+	require.Equal(int64(12), h.Bytes)
+	require.InDelta(1.0/3.0, h.HitRatio, 0.001)
+	require.Equal(1, h.InFlightDownloads)
+}
+
+func TestTorrentArchiveHeartbeat(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	sink := &chanSink{c: make(chan ArchiveHealth, 1), err: errors.New("some error")}
+
+	archive := NewTorrentArchive(
+		Config{HeartbeatInterval: time.Minute},
+		tally.NoopScope,
+		mocks.clk,
+		mocks.cads,
+		mocks.metaInfoClient,
+		WithHeartbeatSink(sink))
+
+	for i := 0; i < 2; i++ {
+		mocks.clk.Add(time.Minute)
+		select {
+		case h := <-sink.c:
+			require.Equal(0, h.BlobCount)
+		case <-time.After(5 * time.Second):
+			require.FailNow("timed out waiting for heartbeat")
+		}
+	}
+
+	archive.Close()
+	archive.Close()
+}
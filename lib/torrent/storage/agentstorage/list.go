@@ -0,0 +1,105 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/utils/log"
+)
+
+type listOptions struct {
+	complete     bool
+	incomplete   bool
+	namespace    string
+	hasNamespace bool
+}
+
+// ListOption filters the torrents returned by List.
+type ListOption func(*listOptions)
+
+// ListComplete only lists torrents with all pieces complete.
+func ListComplete() ListOption {
+	return func(o *listOptions) { o.complete = true }
+}
+
+// ListIncomplete only lists torrents with missing pieces.
+func ListIncomplete() ListOption {
+	return func(o *listOptions) { o.incomplete = true }
+}
+
+// ListNamespace only lists torrents initialized under namespace. Torrents
+// initialized before namespaces were recorded never match.
+func ListNamespace(namespace string) ListOption {
+	return func(o *listOptions) {
+		o.namespace = namespace
+		o.hasNamespace = true
+	}
+}
+
+func (o listOptions) match(info *storage.TorrentInfo) bool {
+	complete := info.Bitfield().All()
+	if o.complete && !complete {
+		return false
+	}
+	if o.incomplete && complete {
+		return false
+	}
+	if o.hasNamespace && info.Namespace() != o.namespace {
+		return false
+	}
+	return true
+}
+
+// List returns TorrentInfo for all torrents in the download and cache
+// directories matching opts. Torrents which cannot be read are logged and
+// skipped, such that a single corrupt torrent does not fail the listing.
+func (a *TorrentArchive) List(opts ...ListOption) ([]*storage.TorrentInfo, error) {
+	if err := a.enter(); err != nil {
+		return nil, err
+	}
+	defer a.exit()
+
+	var o listOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	names, err := a.cads.Any().ListNames()
+	if err != nil {
+		return nil, fmt.Errorf("list names: %s", err)
+	}
+	var infos []*storage.TorrentInfo
+	for _, name := range names {
+		d, err := core.NewSHA256DigestFromHex(name)
+		if err != nil {
+			continue
+		}
+		info, err := a.torrentInfo("", d)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				a.stats.Counter("list_errors").Inc(1)
+				log.With("name", name).Errorf("Error listing torrent: %s", err)
+			}
+			continue
+		}
+		if o.match(info) {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
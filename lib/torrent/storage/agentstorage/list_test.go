@@ -0,0 +1,82 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTorrentArchiveList(t *testing.T) {
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	complete := core.SizedBlobFixture(4, 1)
+	createCompleteTorrent(t, mocks, archive, "foo", complete)
+
+	incomplete := core.SizedBlobFixture(4, 1)
+	mocks.metaInfoClient.EXPECT().Download(
+		gomock.Any(), "bar", incomplete.Digest).Return(incomplete.MetaInfo, nil)
+	tor, err := archive.CreateTorrent(context.Background(), "bar", incomplete.Digest)
+	require.NoError(t, err)
+	require.NoError(t, tor.WritePiece(piecereader.NewBuffer(incomplete.Content[:1]), 0))
+
+	digests := func(infos []*storage.TorrentInfo) []core.Digest {
+		var ds []core.Digest
+		for _, info := range infos {
+			ds = append(ds, info.Digest())
+		}
+		return ds
+	}
+
+	tests := []struct {
+		desc     string
+		opts     []ListOption
+		expected []core.Digest
+	}{
+		{"all", nil, []core.Digest{complete.Digest, incomplete.Digest}},
+		{"complete", []ListOption{ListComplete()}, []core.Digest{complete.Digest}},
+		{"incomplete", []ListOption{ListIncomplete()}, []core.Digest{incomplete.Digest}},
+		{"namespace", []ListOption{ListNamespace("bar")}, []core.Digest{incomplete.Digest}},
+		{"complete in namespace", []ListOption{ListComplete(), ListNamespace("bar")}, nil},
+		{"unknown namespace", []ListOption{ListNamespace("baz")}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			infos, err := archive.List(test.opts...)
+			require.NoError(t, err)
+			require.ElementsMatch(t, test.expected, digests(infos))
+		})
+	}
+}
+
+func TestTorrentArchiveListClosed(t *testing.T) {
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+	require.NoError(t, archive.Close())
+
+	_, err := archive.List()
+	require.Equal(t, ErrClosed, err)
+}
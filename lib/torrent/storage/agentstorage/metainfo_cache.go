@@ -0,0 +1,163 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+)
+
+// MetaInfoCacheConfig defines configuration for the in-memory metainfo cache,
+// which saves reading and parsing on-disk metadata for hot blobs, and saves
+// re-downloading metainfo from the tracker for blobs which are re-initialized
+// shortly after being removed from disk.
+type MetaInfoCacheConfig struct {
+	Disabled bool `yaml:"disabled"`
+
+	// Size is the maximum number of metainfos cached. The least recently
+	// used metainfo is evicted once the cache is full.
+	Size int `yaml:"size"`
+
+	// TTL is the duration metainfos are cached for.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+func (c MetaInfoCacheConfig) applyDefaults() MetaInfoCacheConfig {
+	if c.Size == 0 {
+		c.Size = 1000
+	}
+	if c.TTL == 0 {
+		c.TTL = 5 * time.Minute
+	}
+	return c
+}
+
+type metaInfoCacheEntry struct {
+	digest  core.Digest
+	mi      *core.MetaInfo
+	addedAt time.Time
+}
+
+// metaInfoCache is an LRU cache of metainfo with expiring entries. Metainfo
+// is immutable for a given digest, so entries only expire to bound how long
+// unused metainfo is held.
+type metaInfoCache struct {
+	sync.Mutex
+	size    int
+	entries map[core.Digest]*list.Element
+	lru     *list.List
+}
+
+func newMetaInfoCache(size int) *metaInfoCache {
+	return &metaInfoCache{
+		size:    size,
+		entries: make(map[core.Digest]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// get returns the metainfo cached for d, and whether it was found. Expired
+// entries are deleted.
+func (c *metaInfoCache) get(d core.Digest, now time.Time, ttl time.Duration) (*core.MetaInfo, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.entries[d]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*metaInfoCacheEntry)
+	if now.Sub(entry.addedAt) >= ttl {
+		c.lru.Remove(e)
+		delete(c.entries, d)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return entry.mi, true
+}
+
+// put caches mi, evicting the least recently used entry if the cache is full.
+func (c *metaInfoCache) put(mi *core.MetaInfo, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	d := mi.Digest()
+	if e, ok := c.entries[d]; ok {
+		e.Value = &metaInfoCacheEntry{d, mi, now}
+		c.lru.MoveToFront(e)
+		return
+	}
+	if c.lru.Len() >= c.size {
+		if oldest := c.lru.Back(); oldest != nil {
+			c.lru.Remove(oldest)
+			delete(c.entries, oldest.Value.(*metaInfoCacheEntry).digest)
+		}
+	}
+	c.entries[d] = c.lru.PushFront(&metaInfoCacheEntry{d, mi, now})
+}
+
+func (c *metaInfoCache) delete(d core.Digest) {
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.entries[d]; ok {
+		c.lru.Remove(e)
+		delete(c.entries, d)
+	}
+}
+
+// cachedMetaInfo returns the cached metainfo for d, recording a hit or miss
+// against namespace.
+func (a *TorrentArchive) cachedMetaInfo(namespace string, d core.Digest) (*core.MetaInfo, bool) {
+	config := a.getConfig().MetaInfoCache
+	if config.Disabled {
+		return nil, false
+	}
+	mi, ok := a.metaInfoCache.get(d, a.clk.Now(), config.TTL)
+	if ok {
+		a.namespaceStats(namespace).Counter("metainfo_cache_hits").Inc(1)
+	} else {
+		a.namespaceStats(namespace).Counter("metainfo_cache_misses").Inc(1)
+	}
+	return mi, ok
+}
+
+func (a *TorrentArchive) cacheMetaInfo(mi *core.MetaInfo) {
+	if a.getConfig().MetaInfoCache.Disabled {
+		return
+	}
+	a.metaInfoCache.put(mi, a.clk.Now())
+}
+
+// getMetaInfo returns the metainfo of d on disk. Returns os.ErrNotExist if the
+// file does not exist. Cached metainfo is only returned while the file exists,
+// since cached entries outlive files removed by cache cleanup. Cache hits and
+// misses are recorded against namespace.
+func (a *TorrentArchive) getMetaInfo(namespace string, d core.Digest) (*core.MetaInfo, error) {
+	if mi, ok := a.cachedMetaInfo(namespace, d); ok {
+		if _, err := a.cads.Any().GetFileStat(d.Hex()); err == nil {
+			return mi, nil
+		}
+	}
+	var tm metadata.TorrentMeta
+	if err := a.cads.Any().GetMetadata(d.Hex(), &tm); err != nil {
+		return nil, err
+	}
+	a.cacheMetaInfo(tm.MetaInfo)
+	return tm.MetaInfo, nil
+}
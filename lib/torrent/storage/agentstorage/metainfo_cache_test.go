@@ -0,0 +1,148 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestMetaInfoCacheExpires(t *testing.T) {
+	require := require.New(t)
+
+	c := newMetaInfoCache(10)
+	now := time.Now()
+	mi := core.MetaInfoFixture()
+
+	c.put(mi, now)
+
+	result, ok := c.get(mi.Digest(), now.Add(time.Minute-time.Second), time.Minute)
+	require.True(ok)
+	require.Equal(mi, result)
+
+	_, ok = c.get(mi.Digest(), now.Add(time.Minute), time.Minute)
+	require.False(ok)
+}
+
+func TestMetaInfoCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	require := require.New(t)
+
+	c := newMetaInfoCache(2)
+	now := time.Now()
+	mi1 := core.MetaInfoFixture()
+	mi2 := core.MetaInfoFixture()
+	mi3 := core.MetaInfoFixture()
+
+	c.put(mi1, now)
+	c.put(mi2, now)
+
+	// Touch mi1 such that mi2 is evicted.
+	_, ok := c.get(mi1.Digest(), now, time.Minute)
+	require.True(ok)
+
+	c.put(mi3, now)
+
+	_, ok = c.get(mi1.Digest(), now, time.Minute)
+	require.True(ok)
+	_, ok = c.get(mi2.Digest(), now, time.Minute)
+	require.False(ok)
+	_, ok = c.get(mi3.Digest(), now, time.Minute)
+	require.True(ok)
+}
+
+func TestTorrentArchiveMetaInfoCacheMetrics(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	stats := tally.NewTestScope("", nil)
+	archive := NewTorrentArchive(Config{}, stats, mocks.clk, mocks.cads, mocks.metaInfoClient)
+
+	namespace := core.TagFixture()
+	mi := core.SizedBlobFixture(4, 1).MetaInfo
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil)
+
+	// Miss on disk, and miss on initialize.
+	_, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+	require.NoError(err)
+
+	for i := 0; i < 3; i++ {
+		info, err := archive.Stat(context.Background(), namespace, mi.Digest())
+		require.NoError(err)
+		require.Equal(mi.InfoHash(), info.InfoHash())
+	}
+
+	counters := stats.Snapshot().Counters()
+	tags := "+module=agenttorrentarchive,namespace=" + namespace
+	require.Equal(int64(3), counters["metainfo_cache_hits"+tags].Value())
+	require.Equal(int64(2), counters["metainfo_cache_misses"+tags].Value())
+}
+
+func TestTorrentArchiveMetaInfoCacheSkipsTrackerAfterFileRemoved(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	mi := core.SizedBlobFixture(4, 1).MetaInfo
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil).Times(1)
+
+	_, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+	require.NoError(err)
+
+	// Simulate cleanup removing the file outside of the archive.
+	require.NoError(mocks.cads.Any().DeleteFile(mi.Digest().Hex()))
+
+	tor, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+	require.NoError(err)
+	require.Equal(mi.InfoHash(), tor.InfoHash())
+	require.False(tor.Complete())
+}
+
+func TestTorrentArchiveMetaInfoCacheDisabled(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{
+		MetaInfoCache: MetaInfoCacheConfig{Disabled: true},
+	})
+
+	namespace := core.TagFixture()
+	mi := core.SizedBlobFixture(4, 1).MetaInfo
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil).Times(2)
+
+	_, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+	require.NoError(err)
+
+	require.NoError(mocks.cads.Any().DeleteFile(mi.Digest().Hex()))
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+	require.NoError(err)
+}
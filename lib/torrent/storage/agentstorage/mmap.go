@@ -0,0 +1,232 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/uber-go/tally"
+)
+
+// MmapAdvice defines the access pattern hinted to the kernel for mapped blobs.
+type MmapAdvice string
+
+const (
+	// MmapAdviceNormal applies the kernel's default readahead.
+	MmapAdviceNormal MmapAdvice = "normal"
+
+	// MmapAdviceRandom disables readahead. Suited for seeders serving pieces
+	// to many peers in no particular order.
+	MmapAdviceRandom MmapAdvice = "random"
+
+	// MmapAdviceSequential enables aggressive readahead.
+	MmapAdviceSequential MmapAdvice = "sequential"
+)
+
+// MmapConfig defines configuration for serving piece reads of complete
+// torrents from memory-mapped files, which saves a read syscall and copy per
+// piece request on high fan-out seeders. Torrents which are still downloading
+// are always read through regular file I/O.
+type MmapConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Advice is the access pattern hinted via madvise. Defaults to random.
+	Advice MmapAdvice `yaml:"advice"`
+
+	// MaxMappings is the maximum number of blobs mapped at once. The least
+	// recently read blob is unmapped once the limit is reached.
+	MaxMappings int `yaml:"max_mappings"`
+}
+
+func (c MmapConfig) applyDefaults() MmapConfig {
+	if c.Advice == "" {
+		c.Advice = MmapAdviceRandom
+	}
+	if c.MaxMappings == 0 {
+		c.MaxMappings = 256
+	}
+	return c
+}
+
+// errMmapUnsupported is returned by mmapFile on platforms without mmap.
+var errMmapUnsupported = errors.New("mmap not supported")
+
+// mapping is a read-only memory-mapped blob. The mapping is unmapped once it
+// has been evicted from the mmapCache and all readers have been closed.
+type mapping struct {
+	digest  core.Digest
+	data    []byte
+	refs    int
+	evicted bool
+}
+
+// mmapCache is an LRU cache of mappings.
+type mmapCache struct {
+	sync.Mutex
+	size    int
+	entries map[core.Digest]*list.Element
+	lru     *list.List
+	stats   tally.Scope
+}
+
+func newMmapCache(size int, stats tally.Scope) *mmapCache {
+	return &mmapCache{
+		size:    size,
+		entries: make(map[core.Digest]*list.Element),
+		lru:     list.New(),
+		stats:   stats,
+	}
+}
+
+// acquire returns a mapping of the blob d located at path, mapping it if not
+// already mapped. Callers must release the mapping once done reading.
+func (c *mmapCache) acquire(d core.Digest, path string, advice MmapAdvice) (*mapping, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.entries[d]; ok {
+		c.lru.MoveToFront(e)
+		m := e.Value.(*mapping)
+		m.refs++
+		return m, nil
+	}
+	data, err := mmapFile(path, advice)
+	if err != nil {
+		return nil, err
+	}
+	if c.lru.Len() >= c.size {
+		if oldest := c.lru.Back(); oldest != nil {
+			c.evict(oldest)
+		}
+	}
+	m := &mapping{digest: d, data: data, refs: 1}
+	c.entries[d] = c.lru.PushFront(m)
+	c.stats.Counter("mmap_mapped").Inc(1)
+	return m, nil
+}
+
+// release drops a reference to m acquired by acquire.
+func (c *mmapCache) release(m *mapping) {
+	c.Lock()
+	defer c.Unlock()
+
+	m.refs--
+	if m.refs == 0 && m.evicted {
+		c.unmap(m)
+	}
+}
+
+// remove evicts the mapping of d, if any. Must be called before the blob of d
+// is deleted.
+func (c *mmapCache) remove(d core.Digest) {
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.entries[d]; ok {
+		c.evict(e)
+	}
+}
+
+// clear evicts all mappings.
+func (c *mmapCache) clear() {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, e := range c.entries {
+		c.evict(e)
+	}
+}
+
+func (c *mmapCache) evict(e *list.Element) {
+	m := e.Value.(*mapping)
+	c.lru.Remove(e)
+	delete(c.entries, m.digest)
+	m.evicted = true
+	if m.refs == 0 {
+		c.unmap(m)
+	}
+}
+
+func (c *mmapCache) unmap(m *mapping) {
+	if err := munmap(m.data); err != nil {
+		log.With("name", m.digest.Hex()).Errorf("Error unmapping blob: %s", err)
+	}
+	m.data = nil
+	c.stats.Counter("mmap_unmapped").Inc(1)
+}
+
+// mmapPieceReader is a storage.PieceReader which reads a piece from a mapped
+// blob.
+type mmapPieceReader struct {
+	cache   *mmapCache
+	mapping *mapping
+	reader  *bytes.Reader
+	length  int
+	once    sync.Once
+}
+
+func (r *mmapPieceReader) Read(p []byte) (int, error) {
+	if r.reader == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return r.reader.Read(p)
+}
+
+// Close releases the underlying mapping. Close is idempotent.
+func (r *mmapPieceReader) Close() error {
+	r.once.Do(func() {
+		r.reader = nil
+		r.cache.release(r.mapping)
+	})
+	return nil
+}
+
+func (r *mmapPieceReader) Length() int {
+	return r.length
+}
+
+// getMappedPieceReader returns a reader for piece pi backed by a mapping of the
+// committed blob.
+func (t *Torrent) getMappedPieceReader(pi int) (storage.PieceReader, error) {
+	path, err := t.cads.Any().GetFilePath(t.Digest().Hex())
+	if err != nil {
+		return nil, fmt.Errorf("get path: %s", err)
+	}
+	m, err := t.mmaps.acquire(t.Digest(), path, t.mmapAdvice)
+	if err != nil {
+		return nil, fmt.Errorf("mmap: %s", err)
+	}
+	start := t.getFileOffset(pi)
+	end := start + t.PieceLength(pi)
+	if end > int64(len(m.data)) {
+		t.mmaps.release(m)
+		return nil, fmt.Errorf(
+			"piece %d ends at %d, beyond mapped length %d", pi, end, len(m.data))
+	}
+	return &mmapPieceReader{
+		cache:   t.mmaps,
+		mapping: m,
+		reader:  bytes.NewReader(m.data[start:end]),
+		length:  int(end - start),
+	}, nil
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+var _madvise = map[MmapAdvice]int{
+	MmapAdviceNormal:     syscall.MADV_NORMAL,
+	MmapAdviceRandom:     syscall.MADV_RANDOM,
+	MmapAdviceSequential: syscall.MADV_SEQUENTIAL,
+}
+
+// mmapFile maps the file at path read-only.
+func mmapFile(path string, advice MmapAdvice) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat: %s", err)
+	}
+	if info.Size() == 0 {
+		return nil, errors.New("cannot map empty file")
+	}
+	data, err := syscall.Mmap(
+		int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Madvise(data, _madvise[advice]); err != nil {
+		syscall.Munmap(data)
+		return nil, fmt.Errorf("madvise: %s", err)
+	}
+	return data, nil
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestTorrentArchiveMmapPieceReader(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{Mmap: MmapConfig{Enabled: true}})
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(32, 8)
+
+	createCompleteTorrent(t, mocks, archive, namespace, blob)
+
+	tor, err := archive.GetTorrent(namespace, blob.Digest)
+	require.NoError(err)
+
+	var readers []*mmapPieceReader
+	for i := 0; i < tor.NumPieces(); i++ {
+		r, err := tor.GetPieceReader(i)
+		require.NoError(err)
+		mr, ok := r.(*mmapPieceReader)
+		require.True(ok)
+		readers = append(readers, mr)
+
+		start := blob.MetaInfo.PieceOffset(i)
+		end := start + blob.MetaInfo.PieceSize(i)
+		require.Equal(int(end-start), r.Length())
+		b, err := ioutil.ReadAll(r)
+		require.NoError(err)
+		require.Equal(blob.Content[start:end], b)
+	}
+
+	// All readers share a single mapping.
+	require.Equal(1, archive.mmaps.lru.Len())
+	require.Equal(tor.NumPieces(), readers[0].mapping.refs)
+
+	for _, r := range readers {
+		require.NoError(r.Close())
+		require.NoError(r.Close())
+	}
+	require.Equal(0, readers[0].mapping.refs)
+
+	require.NoError(archive.DeleteTorrent(blob.Digest))
+	require.Equal(0, archive.mmaps.lru.Len())
+	require.Nil(readers[0].mapping.data)
+}
+
+func TestTorrentArchiveMmapDisabledUsesFileReader(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(8, 8)
+
+	createCompleteTorrent(t, mocks, archive, namespace, blob)
+
+	tor, err := archive.GetTorrent(namespace, blob.Digest)
+	require.NoError(err)
+
+	r, err := tor.GetPieceReader(0)
+	require.NoError(err)
+	defer r.Close()
+	_, ok := r.(*mmapPieceReader)
+	require.False(ok)
+}
+
+func TestMmapCacheDefersUnmapUntilReleased(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	blob1 := core.SizedBlobFixture(8, 8)
+	blob2 := core.SizedBlobFixture(8, 8)
+
+	createCompleteTorrent(t, mocks, archive, namespace, blob1)
+	createCompleteTorrent(t, mocks, archive, namespace, blob2)
+
+	path1, err := mocks.cads.Any().GetFilePath(blob1.Digest.Hex())
+	require.NoError(err)
+	path2, err := mocks.cads.Any().GetFilePath(blob2.Digest.Hex())
+	require.NoError(err)
+
+	c := newMmapCache(1, tally.NoopScope)
+
+	m1, err := c.acquire(blob1.Digest, path1, MmapAdviceRandom)
+	require.NoError(err)
+
+	// Mapping blob2 evicts blob1, but blob1 is still being read.
+	m2, err := c.acquire(blob2.Digest, path2, MmapAdviceRandom)
+	require.NoError(err)
+	require.True(m1.evicted)
+	require.Equal(blob1.Content, m1.data)
+
+	c.release(m1)
+	require.Nil(m1.data)
+
+	c.release(m2)
+	require.Equal(blob2.Content, m2.data)
+
+	c.clear()
+	require.Nil(m2.data)
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"sync"
+
+	"github.com/uber/kraken/core"
+)
+
+// NamespaceStats summarizes the cache footprint of a single namespace.
+type NamespaceStats struct {
+	BlobCount         int     `json:"blob_count"`
+	Bytes             int64   `json:"bytes"`
+	HitRatio          float64 `json:"hit_ratio"`
+	InFlightDownloads int     `json:"in_flight_downloads"`
+}
+
+type namespaceCounters struct {
+	blobs    int
+	bytes    int64
+	hits     int64
+	misses   int64
+	inflight int
+}
+
+type namespaceBlob struct {
+	namespace string
+	length    int64
+	inflight  bool
+}
+
+// namespaceTracker maintains per-namespace counters for blobs initialized by
+// the archive. Blobs are attributed to the namespace of the CreateTorrent call
+// which initialized them.
+type namespaceTracker struct {
+	sync.Mutex
+	blobs    map[core.Digest]*namespaceBlob
+	counters map[string]*namespaceCounters
+}
+
+func newNamespaceTracker() *namespaceTracker {
+	return &namespaceTracker{
+		blobs:    make(map[core.Digest]*namespaceBlob),
+		counters: make(map[string]*namespaceCounters),
+	}
+}
+
+// get returns the counters for namespace. Assumes t is locked.
+func (t *namespaceTracker) get(namespace string) *namespaceCounters {
+	c, ok := t.counters[namespace]
+	if !ok {
+		c = &namespaceCounters{}
+		t.counters[namespace] = c
+	}
+	return c
+}
+
+func (t *namespaceTracker) hit(namespace string) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.get(namespace).hits++
+}
+
+func (t *namespaceTracker) miss(namespace string) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.get(namespace).misses++
+}
+
+// add attributes a newly initialized download of d to namespace.
+func (t *namespaceTracker) add(namespace string, d core.Digest, length int64) {
+	t.Lock()
+	defer t.Unlock()
+
+	if _, ok := t.blobs[d]; ok {
+		return
+	}
+	t.blobs[d] = &namespaceBlob{namespace, length, true}
+	c := t.get(namespace)
+	c.blobs++
+	c.bytes += length
+	c.inflight++
+}
+
+// complete marks the download of d as no longer in-flight.
+func (t *namespaceTracker) complete(d core.Digest) {
+	t.Lock()
+	defer t.Unlock()
+
+	b, ok := t.blobs[d]
+	if !ok || !b.inflight {
+		return
+	}
+	b.inflight = false
+	t.get(b.namespace).inflight--
+}
+
+func (t *namespaceTracker) remove(d core.Digest) {
+	t.Lock()
+	defer t.Unlock()
+
+	b, ok := t.blobs[d]
+	if !ok {
+		return
+	}
+	delete(t.blobs, d)
+	c := t.get(b.namespace)
+	c.blobs--
+	c.bytes -= b.length
+	if b.inflight {
+		c.inflight--
+	}
+}
+
+func (t *namespaceTracker) stats(namespace string) NamespaceStats {
+	t.Lock()
+	defer t.Unlock()
+
+	c, ok := t.counters[namespace]
+	if !ok {
+		return NamespaceStats{}
+	}
+	var hitRatio float64
+	if c.hits+c.misses > 0 {
+		hitRatio = float64(c.hits) / float64(c.hits+c.misses)
+	}
+	return NamespaceStats{
+		BlobCount:         c.blobs,
+		Bytes:             c.bytes,
+		HitRatio:          hitRatio,
+		InFlightDownloads: c.inflight,
+	}
+}
+
+// NamespaceStats returns aggregate stats for blobs initialized under namespace
+// since the archive was created. Stats are maintained as counters rather than
+// scanning disk, so blobs which existed before the archive was created, or
+// which were evicted by store cleanup, are not reflected. Returns zero-valued
+// stats for namespaces with no activity.
+func (a *TorrentArchive) NamespaceStats(namespace string) (NamespaceStats, error) {
+	if err := a.enter(); err != nil {
+		return NamespaceStats{}, err
+	}
+	defer a.exit()
+
+	return a.namespaces.stats(namespace), nil
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTorrentArchiveNamespaceStats(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	ns1 := core.TagFixture()
+	ns2 := core.TagFixture()
+	blob1 := core.SizedBlobFixture(8, 8)
+	blob2 := core.SizedBlobFixture(4, 4)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), ns1, blob1.Digest).Return(blob1.MetaInfo, nil)
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), ns2, blob2.Digest).Return(blob2.MetaInfo, nil)
+
+	tor, err := archive.CreateTorrent(context.Background(), ns1, blob1.Digest)
+	require.NoError(err)
+
+	_, err = archive.CreateTorrent(context.Background(), ns2, blob2.Digest)
+	require.NoError(err)
+	_, err = archive.CreateTorrent(context.Background(), ns2, blob2.Digest)
+	require.NoError(err)
+
+	stats, err := archive.NamespaceStats(ns1)
+	require.NoError(err)
+	require.Equal(NamespaceStats{BlobCount: 1, Bytes: 8, InFlightDownloads: 1}, stats)
+
+	require.NoError(tor.WritePiece(piecereader.NewBuffer(blob1.Content), 0))
+
+	stats, err = archive.NamespaceStats(ns1)
+	require.NoError(err)
+	require.Equal(NamespaceStats{BlobCount: 1, Bytes: 8}, stats)
+
+	stats, err = archive.NamespaceStats(ns2)
+	require.NoError(err)
+	require.Equal(NamespaceStats{
+		BlobCount:         1,
+		Bytes:             4,
+		HitRatio:          0.5,
+		InFlightDownloads: 1,
+	}, stats)
+
+	require.NoError(archive.DeleteTorrent(blob2.Digest))
+
+	stats, err = archive.NamespaceStats(ns2)
+	require.NoError(err)
+	require.Equal(NamespaceStats{HitRatio: 0.5}, stats)
+
+	stats, err = archive.NamespaceStats("noexist")
+	require.NoError(err)
+	require.Equal(NamespaceStats{}, stats)
+}
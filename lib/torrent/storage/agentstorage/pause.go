@@ -0,0 +1,119 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/uber/kraken/utils/log"
+)
+
+// ErrDownloadsPaused occurs when initializing a download while downloads are
+// paused and the archive is configured to fail fast.
+var ErrDownloadsPaused = errors.New("downloads are paused")
+
+// pauseGate blocks callers while paused.
+type pauseGate struct {
+	sync.Mutex
+	paused  bool
+	resumed chan struct{} // Closed on resume.
+}
+
+func (g *pauseGate) pause() bool {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.paused {
+		return false
+	}
+	g.paused = true
+	g.resumed = make(chan struct{})
+	return true
+}
+
+func (g *pauseGate) resume() bool {
+	g.Lock()
+	defer g.Unlock()
+
+	if !g.paused {
+		return false
+	}
+	g.paused = false
+	close(g.resumed)
+	return true
+}
+
+func (g *pauseGate) isPaused() bool {
+	g.Lock()
+	defer g.Unlock()
+
+	return g.paused
+}
+
+// wait blocks until g is resumed, ctx is done, or done is closed, in which case
+// ErrClosed is returned.
+func (g *pauseGate) wait(ctx context.Context, done <-chan struct{}) error {
+	g.Lock()
+	if !g.paused {
+		g.Unlock()
+		return nil
+	}
+	resumed := g.resumed
+	g.Unlock()
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return ErrClosed
+	}
+}
+
+// PauseDownloads suspends all downloads. New downloads initialized by
+// CreateTorrent block until downloads are resumed (or fail with
+// ErrDownloadsPaused if configured to fail fast), and piece writes to existing
+// torrents block, which suspends piece fetching in the scheduler. Existing
+// torrents may still be read from, so cached blobs continue to be served.
+func (a *TorrentArchive) PauseDownloads() {
+	if a.downloads.pause() {
+		log.Info("Downloads paused")
+		a.stats.Gauge("downloads_paused").Update(1)
+	}
+}
+
+// ResumeDownloads resumes downloads suspended by PauseDownloads. Suspended
+// torrents continue from their current piece state.
+func (a *TorrentArchive) ResumeDownloads() {
+	if a.downloads.resume() {
+		log.Info("Downloads resumed")
+		a.stats.Gauge("downloads_paused").Update(0)
+	}
+}
+
+// DownloadsPaused returns true if downloads are paused.
+func (a *TorrentArchive) DownloadsPaused() bool {
+	return a.downloads.isPaused()
+}
+
+// waitForDownloads blocks while downloads are paused.
+func (a *TorrentArchive) waitForDownloads(ctx context.Context) error {
+	if a.getConfig().FailFastWhenPaused && a.downloads.isPaused() {
+		return ErrDownloadsPaused
+	}
+	return a.downloads.wait(ctx, a.done)
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func requireBlocked(t *testing.T, errc chan error) {
+	select {
+	case err := <-errc:
+		require.FailNow(t, "operation not blocked", "err: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTorrentArchivePauseDownloadsFailFast(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{FailFastWhenPaused: true})
+
+	namespace := core.TagFixture()
+	blob1 := core.SizedBlobFixture(4, 4)
+	blob2 := core.SizedBlobFixture(4, 4)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob1.Digest).Return(blob1.MetaInfo, nil)
+
+	tor, err := archive.CreateTorrent(context.Background(), namespace, blob1.Digest)
+	require.NoError(err)
+	require.NoError(tor.WritePiece(piecereader.NewBuffer(blob1.Content), 0))
+
+	archive.PauseDownloads()
+	require.True(archive.DownloadsPaused())
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, blob2.Digest)
+	require.Equal(ErrDownloadsPaused, err)
+
+	// Cached blobs are still served.
+	tor, err = archive.GetTorrent(context.Background(), namespace, blob1.Digest)
+	require.NoError(err)
+	r, err := tor.GetPieceReader(0)
+	require.NoError(err)
+	require.NoError(r.Close())
+
+	archive.ResumeDownloads()
+	require.False(archive.DownloadsPaused())
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob2.Digest).Return(blob2.MetaInfo, nil)
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, blob2.Digest)
+	require.NoError(err)
+}
+
+func TestTorrentArchivePauseDownloadsBlocks(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	blob1 := core.SizedBlobFixture(4, 4)
+	blob2 := core.SizedBlobFixture(4, 4)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob1.Digest).Return(blob1.MetaInfo, nil)
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob2.Digest).Return(blob2.MetaInfo, nil)
+
+	tor, err := archive.CreateTorrent(context.Background(), namespace, blob1.Digest)
+	require.NoError(err)
+
+	archive.PauseDownloads()
+
+	createErr := make(chan error, 1)
+	go func() {
+		_, err := archive.CreateTorrent(context.Background(), namespace, blob2.Digest)
+		createErr <- err
+	}()
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- tor.WritePiece(piecereader.NewBuffer(blob1.Content), 0)
+	}()
+
+	requireBlocked(t, createErr)
+	requireBlocked(t, writeErr)
+
+	archive.ResumeDownloads()
+
+	require.NoError(<-createErr)
+	require.NoError(<-writeErr)
+	require.True(tor.Complete())
+}
+
+func TestTorrentArchiveCloseWakesPausedDownloads(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(4, 4)
+
+	archive.PauseDownloads()
+
+	createErr := make(chan error, 1)
+	go func() {
+		_, err := archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+		createErr <- err
+	}()
+
+	requireBlocked(t, createErr)
+
+	require.NoError(archive.Close())
+	require.Equal(ErrClosed, <-createErr)
+}
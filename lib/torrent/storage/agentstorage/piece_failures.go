@@ -0,0 +1,128 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/log"
+)
+
+const _pieceFailuresSuffix = "_piece_failures"
+
+func init() {
+	metadata.Register(regexp.MustCompile(_pieceFailuresSuffix), pieceFailuresMetadataFactory{})
+}
+
+// VerifyOrder defines the order in which pieces are checked during verification.
+type VerifyOrder string
+
+const (
+	// VerifySequential checks pieces in index order.
+	VerifySequential VerifyOrder = "sequential"
+
+	// VerifyFailuresFirst checks pieces which previously failed verification
+	// first, most failures first, such that re-verifying a known-bad blob fails
+	// fast.
+	VerifyFailuresFirst VerifyOrder = "failures_first"
+)
+
+type pieceFailuresMetadataFactory struct{}
+
+func (m pieceFailuresMetadataFactory) Create(suffix string) metadata.Metadata {
+	return &pieceFailuresMetadata{}
+}
+
+// pieceFailuresMetadata stores the number of times each piece has failed
+// verification, saturating at 255. Counts are cleared once a piece verifies
+// clean.
+type pieceFailuresMetadata struct {
+	counts []byte
+}
+
+func (m *pieceFailuresMetadata) GetSuffix() string {
+	return _pieceFailuresSuffix
+}
+
+func (m *pieceFailuresMetadata) Movable() bool {
+	return true
+}
+
+func (m *pieceFailuresMetadata) Serialize() ([]byte, error) {
+	return m.counts, nil
+}
+
+func (m *pieceFailuresMetadata) Deserialize(b []byte) error {
+	m.counts = b
+	return nil
+}
+
+// getPieceFailures returns the persisted failure history of t.
+func (t *Torrent) getPieceFailures() (*pieceFailuresMetadata, error) {
+	md := &pieceFailuresMetadata{}
+	if err := t.cads.Any().GetMetadata(t.Digest().Hex(), md); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(md.counts) != len(t.pieces) {
+		md.counts = make([]byte, len(t.pieces))
+	}
+	return md, nil
+}
+
+// orderPieces sorts pieces per order using the failure history in md.
+func orderPieces(pieces []int, order VerifyOrder, md *pieceFailuresMetadata) {
+	if order != VerifyFailuresFirst {
+		return
+	}
+	sort.SliceStable(pieces, func(i, j int) bool {
+		return md.counts[pieces[i]] > md.counts[pieces[j]]
+	})
+}
+
+// verifyPieces checks pieces in the given order, failing on the first invalid
+// piece. The failure history is updated as pieces are checked.
+func (t *Torrent) verifyPieces(pieces []int, order VerifyOrder) error {
+	md, err := t.getPieceFailures()
+	if err != nil {
+		return fmt.Errorf("get piece failures: %s", err)
+	}
+	orderPieces(pieces, order, md)
+
+	var changed bool
+	var verifyErr error
+	for _, pi := range pieces {
+		if err := t.verifyPiece(pi); err != nil {
+			if md.counts[pi] < 255 {
+				md.counts[pi]++
+			}
+			changed = true
+			verifyErr = err
+			break
+		}
+		if md.counts[pi] > 0 {
+			md.counts[pi] = 0
+			changed = true
+		}
+	}
+	if changed {
+		if _, err := t.cads.Any().SetMetadata(t.Digest().Hex(), md); err != nil {
+			log.With("name", t.Digest().Hex()).Errorf("Error persisting piece failures: %s", err)
+		}
+	}
+	return verifyErr
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderPieces(t *testing.T) {
+	md := &pieceFailuresMetadata{counts: []byte{0, 2, 0, 1, 2}}
+
+	tests := []struct {
+		desc     string
+		order    VerifyOrder
+		expected []int
+	}{
+		{"sequential", VerifySequential, []int{0, 1, 2, 3, 4}},
+		{"failures first", VerifyFailuresFirst, []int{1, 4, 3, 0, 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			pieces := []int{0, 1, 2, 3, 4}
+			orderPieces(pieces, test.order, md)
+			require.Equal(t, test.expected, pieces)
+		})
+	}
+}
+
+func TestTorrentArchiveVerifyTracksPieceFailures(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{VerifyOrder: VerifyFailuresFirst})
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(8, 2)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+
+	tor, err := archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+	require.NoError(err)
+	for i := 0; i < 3; i++ {
+		require.NoError(
+			tor.WritePiece(piecereader.NewBuffer(blob.Content[i*2:(i+1)*2]), i))
+	}
+
+	require.NoError(archive.Verify(namespace, blob.Digest))
+
+	// Corrupt piece 2.
+	f, err := mocks.cads.GetDownloadFileReadWriter(blob.Digest.Hex())
+	require.NoError(err)
+	_, err = f.WriteAt([]byte{0, 0}, 4)
+	require.NoError(err)
+
+	require.Error(archive.Verify(namespace, blob.Digest))
+
+	md := &pieceFailuresMetadata{}
+	require.NoError(mocks.cads.Any().GetMetadata(blob.Digest.Hex(), md))
+	require.Equal([]byte{0, 0, 1, 0}, md.counts)
+
+	// Repair piece 2.
+	_, err = f.WriteAt(blob.Content[4:6], 4)
+	require.NoError(err)
+	require.NoError(f.Close())
+
+	require.NoError(archive.Verify(namespace, blob.Digest))
+
+	require.NoError(mocks.cads.Any().GetMetadata(blob.Digest.Hex(), md))
+	require.Equal([]byte{0, 0, 0, 0}, md.counts)
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/lib/torrent/storage"
+)
+
+// PieceEntry describes where a piece lives within a blob and its expected
+// checksum.
+type PieceEntry struct {
+	Index  int    `json:"index"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	Sum    uint32 `json:"sum"`
+}
+
+// pieceLayout returns the layout of all pieces described by mi, ordered by index.
+func pieceLayout(mi *core.MetaInfo) []PieceEntry {
+	entries := make([]PieceEntry, mi.NumPieces())
+	var offset int64
+	for i := range entries {
+		entries[i] = PieceEntry{
+			Index:  i,
+			Offset: offset,
+			Length: mi.PieceSize(i),
+			Sum:    mi.GetPieceSum(i),
+		}
+		offset += entries[i].Length
+	}
+	return entries
+}
+
+// PieceLayout returns the offset, length, and expected checksum of every piece
+// of the blob for d, ordered by index, such that external tools can read and
+// verify blobs without re-deriving piece offsets. Returns storage.ErrNotFound
+// if no metainfo exists for d.
+func (a *TorrentArchive) PieceLayout(d core.Digest) ([]PieceEntry, error) {
+	if err := a.enter(); err != nil {
+		return nil, err
+	}
+	defer a.exit()
+
+	var tm metadata.TorrentMeta
+	if err := a.cads.Any().GetMetadata(d.Hex(), &tm); err != nil {
+		if os.IsNotExist(err) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, fmt.Errorf("get metainfo: %s", err)
+	}
+	return pieceLayout(tm.MetaInfo), nil
+}
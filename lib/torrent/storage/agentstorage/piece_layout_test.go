@@ -0,0 +1,82 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPieceLayout(t *testing.T) {
+	tests := []struct {
+		desc        string
+		size        uint64
+		pieceLength uint64
+		lengths     []int64
+	}{
+		{"exact multiple", 8, 4, []int64{4, 4}},
+		{"ragged final piece", 10, 4, []int64{4, 4, 2}},
+		{"single piece", 3, 4, []int64{3}},
+		{"empty", 0, 4, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			blob := core.SizedBlobFixture(test.size, test.pieceLength)
+			entries := pieceLayout(blob.MetaInfo)
+			require.Len(entries, len(test.lengths))
+
+			var offset int64
+			for i, e := range entries {
+				require.Equal(i, e.Index)
+				require.Equal(offset, e.Offset)
+				require.Equal(test.lengths[i], e.Length)
+				require.Equal(blob.MetaInfo.GetPieceSum(i), e.Sum)
+				offset += e.Length
+			}
+			require.Equal(int64(test.size), offset)
+		})
+	}
+}
+
+func TestTorrentArchivePieceLayout(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(10, 4)
+
+	_, err := archive.PieceLayout(blob.Digest)
+	require.Equal(storage.ErrNotFound, err)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+	require.NoError(err)
+
+	entries, err := archive.PieceLayout(blob.Digest)
+	require.NoError(err)
+	require.Equal(pieceLayout(blob.MetaInfo), entries)
+}
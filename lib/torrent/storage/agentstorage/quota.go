@@ -0,0 +1,280 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/c2h5oh/datasize"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/log"
+)
+
+// ErrQuotaExceeded occurs when initializing a torrent would exceed the
+// configured disk quota.
+var ErrQuotaExceeded = errors.New("disk quota exceeded")
+
+// QuotaConfig defines a limit on the total bytes of files in the download and
+// cache directories. Unlike DiskBudget, which only accounts for in-flight
+// downloads initialized by the archive, the quota is checked against the files
+// actually on disk.
+type QuotaConfig struct {
+	// Limit is the maximum total bytes of download and cache files. New
+	// downloads which would exceed the limit are rejected with
+	// ErrQuotaExceeded. If 0, the quota is unlimited.
+	Limit datasize.ByteSize `yaml:"limit"`
+
+	// EvictLRU deletes the least recently accessed cached blobs to make room
+	// for new downloads which would otherwise exceed the limit. In-progress
+	// downloads are never evicted. When a namespace quota is exceeded, only
+	// blobs of that namespace are evicted.
+	EvictLRU bool `yaml:"evict_lru"`
+
+	// NamespaceDefault limits the files of each namespace without an entry
+	// in Namespaces, such that a single namespace cannot take over the disk.
+	// Blobs belong to the namespace they were initialized under.
+	NamespaceDefault NamespaceQuota `yaml:"namespace_default"`
+
+	// Namespaces overrides NamespaceDefault for specific namespaces.
+	Namespaces map[string]NamespaceQuota `yaml:"namespaces"`
+}
+
+// NamespaceQuota limits the download and cache files of a single namespace.
+type NamespaceQuota struct {
+	// Bytes is the maximum total bytes of files. If 0, bytes are unlimited.
+	Bytes datasize.ByteSize `yaml:"bytes"`
+
+	// Files is the maximum number of files. If 0, files are unlimited.
+	Files int `yaml:"files"`
+}
+
+func (q NamespaceQuota) unlimited() bool {
+	return q.Bytes == 0 && q.Files == 0
+}
+
+// namespaceQuota returns the quota of namespace.
+func (c QuotaConfig) namespaceQuota(namespace string) NamespaceQuota {
+	if q, ok := c.Namespaces[namespace]; ok {
+		return q
+	}
+	return c.NamespaceDefault
+}
+
+func (c QuotaConfig) validate() error {
+	quotas := []NamespaceQuota{c.NamespaceDefault}
+	for _, q := range c.Namespaces {
+		quotas = append(quotas, q)
+	}
+	for _, q := range quotas {
+		if q.Files < 0 {
+			return errors.New("quota namespace files must be non-negative")
+		}
+	}
+	return nil
+}
+
+// createDownloadFile creates the download file of mi for namespace if doing so
+// exceeds neither the quota nor the quota of namespace, evicting cached blobs
+// if configured. Returns ErrQuotaExceeded if there is no room.
+func (a *TorrentArchive) createDownloadFile(namespace string, mi *core.MetaInfo) error {
+	config := a.getConfig().Quota
+	nsQuota := config.namespaceQuota(namespace)
+	if config.Limit == 0 && nsQuota.unlimited() {
+		return a.cads.CreateDownloadFile(mi.Digest().Hex(), mi.Length())
+	}
+
+	// Serializes usage checks with file creation, such that concurrent
+	// downloads cannot jointly exceed the quota.
+	a.quotaMu.Lock()
+	defer a.quotaMu.Unlock()
+
+	if _, err := a.cads.Any().GetFileStat(mi.Digest().Hex()); err == nil {
+		// Already on disk, so creation is a no-op which returns the proper error.
+		return a.cads.CreateDownloadFile(mi.Digest().Hex(), mi.Length())
+	}
+	if !nsQuota.unlimited() {
+		if err := a.checkNamespaceQuota(namespace, nsQuota, mi.Length(), config.EvictLRU); err != nil {
+			return err
+		}
+	}
+	if config.Limit == 0 {
+		return a.cads.CreateDownloadFile(mi.Digest().Hex(), mi.Length())
+	}
+	used, err := a.diskUsage()
+	if err != nil {
+		return fmt.Errorf("disk usage: %s", err)
+	}
+	if over := used + mi.Length() - int64(config.Limit); over > 0 {
+		if !config.EvictLRU {
+			return ErrQuotaExceeded
+		}
+		if freed, _ := a.evict(over, 0, nil); freed < over {
+			return ErrQuotaExceeded
+		}
+	}
+	return a.cads.CreateDownloadFile(mi.Digest().Hex(), mi.Length())
+}
+
+// diskUsage returns the total bytes of files in the download and cache
+// directories.
+func (a *TorrentArchive) diskUsage() (int64, error) {
+	names, err := a.cads.Any().ListNames()
+	if err != nil {
+		return 0, fmt.Errorf("list names: %s", err)
+	}
+	var used int64
+	for _, name := range names {
+		info, err := a.cads.Any().GetFileStat(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, fmt.Errorf("stat %s: %s", name, err)
+		}
+		used += info.Size()
+	}
+	return used, nil
+}
+
+// checkNamespaceQuota returns ErrQuotaExceeded if adding a file of length to
+// namespace would exceed q, after evicting cached blobs of namespace if evict
+// is set. Assumes quotaMu is held.
+func (a *TorrentArchive) checkNamespaceQuota(
+	namespace string, q NamespaceQuota, length int64, evict bool) error {
+
+	bytes, files, err := a.namespaceUsage(namespace)
+	if err != nil {
+		return fmt.Errorf("namespace usage: %s", err)
+	}
+	var overBytes int64
+	var overFiles int
+	if q.Bytes > 0 {
+		overBytes = bytes + length - int64(q.Bytes)
+	}
+	if q.Files > 0 {
+		overFiles = files + 1 - q.Files
+	}
+	if overBytes <= 0 && overFiles <= 0 {
+		return nil
+	}
+	if evict {
+		freedBytes, freedFiles := a.evict(overBytes, overFiles, func(d core.Digest) bool {
+			stored, err := a.storedNamespace(d)
+			return err == nil && stored == namespace
+		})
+		if freedBytes >= overBytes && freedFiles >= overFiles {
+			return nil
+		}
+	}
+	a.namespaceStats(namespace).Counter("namespace_quota_exceeded").Inc(1)
+	return ErrQuotaExceeded
+}
+
+// namespaceUsage returns the total bytes and number of download and cache
+// files of namespace.
+func (a *TorrentArchive) namespaceUsage(namespace string) (bytes int64, files int, err error) {
+	names, err := a.cads.Any().ListNames()
+	if err != nil {
+		return 0, 0, fmt.Errorf("list names: %s", err)
+	}
+	for _, name := range names {
+		d, err := core.NewSHA256DigestFromHex(name)
+		if err != nil {
+			continue
+		}
+		stored, err := a.storedNamespace(d)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, 0, fmt.Errorf("get namespace of %s: %s", name, err)
+		}
+		if stored != namespace {
+			continue
+		}
+		info, err := a.cads.Any().GetFileStat(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, 0, fmt.Errorf("stat %s: %s", name, err)
+		}
+		bytes += info.Size()
+		files++
+	}
+	return bytes, files, nil
+}
+
+type evictionCandidate struct {
+	digest     core.Digest
+	size       int64
+	lastAccess time.Time
+}
+
+// evict deletes cached blobs for which match returns true, in least recently
+// accessed order, until at least n bytes and m files are freed or no matching
+// cached blobs remain. A nil match matches all blobs. Returns the bytes and
+// files freed.
+func (a *TorrentArchive) evict(n int64, m int, match func(core.Digest) bool) (int64, int) {
+	names, err := a.cads.Cache().ListNames()
+	if err != nil {
+		log.Errorf("Error listing cached blobs for eviction: %s", err)
+		return 0, 0
+	}
+	var candidates []evictionCandidate
+	for _, name := range names {
+		d, err := core.NewSHA256DigestFromHex(name)
+		if err != nil {
+			continue
+		}
+		if match != nil && !match(d) {
+			continue
+		}
+		info, err := a.cads.Cache().GetFileStat(name)
+		if err != nil {
+			continue
+		}
+		// Blobs without an access time sort first.
+		var lat metadata.LastAccessTime
+		if err := a.cads.Cache().GetMetadata(name, &lat); err != nil && !os.IsNotExist(err) {
+			log.With("name", name).Errorf("Error getting last access time: %s", err)
+		}
+		candidates = append(candidates, evictionCandidate{d, info.Size(), lat.Time})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastAccess.Before(candidates[j].lastAccess)
+	})
+
+	var freedBytes int64
+	var freedFiles int
+	for _, c := range candidates {
+		if freedBytes >= n && freedFiles >= m {
+			break
+		}
+		if err := a.deleteTorrent(c.digest); err != nil {
+			log.With("name", c.digest.Hex()).Errorf("Error evicting blob: %s", err)
+			continue
+		}
+		a.stats.Counter("quota_evictions").Inc(1)
+		freedBytes += c.size
+		freedFiles++
+	}
+	return freedBytes, freedFiles
+}
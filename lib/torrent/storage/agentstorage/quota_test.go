@@ -0,0 +1,216 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func createCompleteTorrent(
+	t *testing.T, mocks *archiveMocks, archive *TorrentArchive, namespace string, blob *core.BlobFixture) {
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+
+	tor, err := archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+	require.NoError(t, err)
+	for i := 0; i < tor.NumPieces(); i++ {
+		start := blob.MetaInfo.PieceOffset(i)
+		end := start + blob.MetaInfo.PieceSize(i)
+		require.NoError(t, tor.WritePiece(piecereader.NewBuffer(blob.Content[start:end]), i))
+	}
+	require.True(t, tor.Complete())
+}
+
+func TestTorrentArchiveCreateTorrentQuotaExceeded(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{Quota: QuotaConfig{Limit: 10}})
+
+	namespace := core.TagFixture()
+	blob1 := core.SizedBlobFixture(8, 1)
+	blob2 := core.SizedBlobFixture(8, 1)
+
+	createCompleteTorrent(t, mocks, archive, namespace, blob1)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob2.Digest).Return(blob2.MetaInfo, nil)
+
+	_, err := archive.CreateTorrent(context.Background(), namespace, blob2.Digest)
+	require.Equal(ErrQuotaExceeded, err)
+
+	_, err = mocks.cads.Any().GetFileStat(blob2.Digest.Hex())
+	require.True(os.IsNotExist(err))
+}
+
+func TestTorrentArchiveCreateTorrentQuotaEvictsLRU(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{Quota: QuotaConfig{Limit: 20, EvictLRU: true}})
+
+	namespace := core.TagFixture()
+	blob1 := core.SizedBlobFixture(8, 1)
+	blob2 := core.SizedBlobFixture(8, 1)
+	blob3 := core.SizedBlobFixture(8, 1)
+
+	createCompleteTorrent(t, mocks, archive, namespace, blob1)
+	createCompleteTorrent(t, mocks, archive, namespace, blob2)
+
+	// blob1 was accessed more recently than blob2.
+	now := time.Now()
+	_, err := mocks.cads.Cache().SetMetadata(blob1.Digest.Hex(), metadata.NewLastAccessTime(now))
+	require.NoError(err)
+	_, err = mocks.cads.Cache().SetMetadata(
+		blob2.Digest.Hex(), metadata.NewLastAccessTime(now.Add(-time.Hour)))
+	require.NoError(err)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob3.Digest).Return(blob3.MetaInfo, nil)
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, blob3.Digest)
+	require.NoError(err)
+
+	_, err = archive.Stat(context.Background(), namespace, blob1.Digest)
+	require.NoError(err)
+	_, err = archive.Stat(context.Background(), namespace, blob2.Digest)
+	require.True(os.IsNotExist(err))
+}
+
+func TestTorrentArchiveCreateTorrentQuotaNeverEvictsDownloads(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{Quota: QuotaConfig{Limit: 10, EvictLRU: true}})
+
+	namespace := core.TagFixture()
+	blob1 := core.SizedBlobFixture(8, 1)
+	blob2 := core.SizedBlobFixture(8, 1)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob1.Digest).Return(blob1.MetaInfo, nil)
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob2.Digest).Return(blob2.MetaInfo, nil)
+
+	_, err := archive.CreateTorrent(context.Background(), namespace, blob1.Digest)
+	require.NoError(err)
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, blob2.Digest)
+	require.Equal(ErrQuotaExceeded, err)
+
+	_, err = archive.Stat(context.Background(), namespace, blob1.Digest)
+	require.NoError(err)
+}
+
+func TestTorrentArchiveCreateTorrentNamespaceQuotaExceeded(t *testing.T) {
+	tests := []struct {
+		desc  string
+		quota QuotaConfig
+	}{
+		{"default bytes", QuotaConfig{NamespaceDefault: NamespaceQuota{Bytes: 10}}},
+		{"default files", QuotaConfig{NamespaceDefault: NamespaceQuota{Files: 1}}},
+		{"override", QuotaConfig{Namespaces: map[string]NamespaceQuota{"foo": {Files: 1}}}},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			mocks, cleanup := newArchiveMocks(t)
+			defer cleanup()
+
+			archive := mocks.newWithConfig(Config{Quota: test.quota})
+
+			blob1 := core.SizedBlobFixture(8, 1)
+			blob2 := core.SizedBlobFixture(8, 1)
+			blob3 := core.SizedBlobFixture(8, 1)
+
+			createCompleteTorrent(t, mocks, archive, "foo", blob1)
+
+			mocks.metaInfoClient.EXPECT().Download(gomock.Any(), "foo", blob2.Digest).Return(blob2.MetaInfo, nil)
+
+			_, err := archive.CreateTorrent(context.Background(), "foo", blob2.Digest)
+			require.Equal(ErrQuotaExceeded, err)
+
+			// Other namespaces are unaffected by the quota of foo.
+			mocks.metaInfoClient.EXPECT().Download(gomock.Any(), "bar", blob3.Digest).Return(blob3.MetaInfo, nil)
+
+			_, err = archive.CreateTorrent(context.Background(), "bar", blob3.Digest)
+			require.NoError(err)
+		})
+	}
+}
+
+func TestTorrentArchiveCreateTorrentNamespaceQuotaEvictsOwnBlobs(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{Quota: QuotaConfig{
+		EvictLRU:         true,
+		NamespaceDefault: NamespaceQuota{Files: 2},
+	}})
+
+	other := core.SizedBlobFixture(8, 1)
+	blob1 := core.SizedBlobFixture(8, 1)
+	blob2 := core.SizedBlobFixture(8, 1)
+	blob3 := core.SizedBlobFixture(8, 1)
+
+	createCompleteTorrent(t, mocks, archive, "bar", other)
+	createCompleteTorrent(t, mocks, archive, "foo", blob1)
+	createCompleteTorrent(t, mocks, archive, "foo", blob2)
+
+	// The blob of bar was accessed least recently, followed by blob1.
+	now := time.Now()
+	for d, lat := range map[core.Digest]time.Time{
+		other.Digest: now.Add(-2 * time.Hour),
+		blob1.Digest: now.Add(-time.Hour),
+		blob2.Digest: now,
+	} {
+		_, err := mocks.cads.Cache().SetMetadata(d.Hex(), metadata.NewLastAccessTime(lat))
+		require.NoError(err)
+	}
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), "foo", blob3.Digest).Return(blob3.MetaInfo, nil)
+
+	_, err := archive.CreateTorrent(context.Background(), "foo", blob3.Digest)
+	require.NoError(err)
+
+	_, err = archive.Stat(context.Background(), "bar", other.Digest)
+	require.NoError(err)
+	_, err = archive.Stat(context.Background(), "foo", blob1.Digest)
+	require.True(os.IsNotExist(err))
+	_, err = archive.Stat(context.Background(), "foo", blob2.Digest)
+	require.NoError(err)
+}
+
+func TestQuotaConfigValidate(t *testing.T) {
+	require.NoError(t, QuotaConfig{NamespaceDefault: NamespaceQuota{Files: 1}}.validate())
+	require.Error(t, QuotaConfig{NamespaceDefault: NamespaceQuota{Files: -1}}.validate())
+	require.Error(t, QuotaConfig{
+		Namespaces: map[string]NamespaceQuota{"foo": {Files: -1}},
+	}.validate())
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package service exposes TorrentArchive operations over HTTP for remote
+// management. The service is optional: embedders which do not want a network
+// surface simply do not mount its Handler.
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/middleware"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/lib/torrent/storage/agentstorage"
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/httputil"
+
+	"github.com/pressly/chi"
+	"github.com/uber-go/tally"
+)
+
+// Config defines Server configuration.
+type Config struct {
+	// Token is the bearer token which requests must present in the
+	// Authorization header. Required.
+	Token string `yaml:"token"`
+}
+
+// DeleteTorrentsRequest is the body of batch delete requests.
+type DeleteTorrentsRequest struct {
+	Names []string `json:"names"`
+}
+
+// Server exposes a TorrentArchive over HTTP.
+type Server struct {
+	config  Config
+	stats   tally.Scope
+	archive *agentstorage.TorrentArchive
+}
+
+// New creates a new Server.
+func New(
+	config Config, stats tally.Scope, archive *agentstorage.TorrentArchive) (*Server, error) {
+
+	if config.Token == "" {
+		return nil, errors.New("token is required")
+	}
+
+	stats = stats.Tagged(map[string]string{
+		"module": "agentstorageservice",
+	})
+
+	return &Server{config, stats, archive}, nil
+}
+
+// Handler returns the HTTP handler.
+func (s *Server) Handler() http.Handler {
+	r := chi.NewRouter()
+
+	r.Use(middleware.StatusCounter(s.stats))
+	r.Use(middleware.LatencyTimer(s.stats))
+	r.Use(s.authenticate)
+
+	r.Get("/health", handler.Wrap(s.healthHandler))
+
+	r.Get("/namespace/{namespace}/blobs/{digest}/stat", handler.Wrap(s.statHandler))
+	r.Post("/namespace/{namespace}/blobs/{digest}/verify", handler.Wrap(s.verifyHandler))
+	r.Get("/namespace/{namespace}/stats", handler.Wrap(s.namespaceStatsHandler))
+
+	r.Get("/blobs", handler.Wrap(s.listHandler))
+	r.Delete("/blobs/{digest}", handler.Wrap(s.deleteHandler))
+	r.Post("/blobs/delete", handler.Wrap(s.deleteBatchHandler))
+	r.Get("/blobs/{digest}/pieces", handler.Wrap(s.pieceLayoutHandler))
+	r.Get("/blobs/{digest}/availability", handler.Wrap(s.availabilityHandler))
+
+	r.Get("/downloads", handler.Wrap(s.getDownloadsHandler))
+	r.Post("/downloads/pause", handler.Wrap(s.pauseDownloadsHandler))
+	r.Post("/downloads/resume", handler.Wrap(s.resumeDownloadsHandler))
+
+	return r
+}
+
+// authenticate rejects requests which do not present the configured token.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) != 1 {
+			s.stats.Counter("unauthorized").Inc(1)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// toHandlerError maps archive errors to HTTP status codes.
+func toHandlerError(op string, err error) error {
+	switch {
+	case os.IsNotExist(err), err == agentstorage.ErrSwarmAvailabilityNotFound:
+		return handler.ErrorStatus(http.StatusNotFound)
+	case err == agentstorage.ErrClosed,
+		err == agentstorage.ErrDownloadsPaused,
+		err == agentstorage.ErrCircuitOpen:
+		return handler.Errorf("%s: %s", op, err).Status(http.StatusServiceUnavailable)
+	case err == agentstorage.ErrDiskBudgetExceeded, err == agentstorage.ErrQuotaExceeded:
+		return handler.Errorf("%s: %s", op, err).Status(http.StatusInsufficientStorage)
+	case err == agentstorage.ErrTooManyPieces:
+		return handler.Errorf("%s: %s", op, err).Status(http.StatusUnprocessableEntity)
+	default:
+		return handler.Errorf("%s: %s", op, err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
+func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) error {
+	h, err := s.archive.Health()
+	if err != nil {
+		return toHandlerError("health", err)
+	}
+	return writeJSON(w, h)
+}
+
+// blobStat is the JSON representation of storage.TorrentInfo.
+type blobStat struct {
+	Digest            string `json:"digest"`
+	Namespace         string `json:"namespace,omitempty"`
+	InfoHash          string `json:"info_hash"`
+	PercentDownloaded int    `json:"percent_downloaded"`
+	BytesDownloaded   int64  `json:"bytes_downloaded"`
+	CompletePieces    uint   `json:"complete_pieces"`
+	NumPieces         uint   `json:"num_pieces"`
+}
+
+func (s *Server) statHandler(w http.ResponseWriter, r *http.Request) error {
+	namespace, err := httputil.ParseParam(r, "namespace")
+	if err != nil {
+		return err
+	}
+	d, err := httputil.ParseDigest(r, "digest")
+	if err != nil {
+		return err
+	}
+	info, err := s.archive.Stat(r.Context(), namespace, d)
+	if err != nil {
+		return toHandlerError("stat", err)
+	}
+	return writeJSON(w, newBlobStat(info))
+}
+
+func newBlobStat(info *storage.TorrentInfo) blobStat {
+	return blobStat{
+		Digest:            info.Digest().String(),
+		Namespace:         info.Namespace(),
+		InfoHash:          info.InfoHash().Hex(),
+		PercentDownloaded: info.PercentDownloaded(),
+		BytesDownloaded:   info.BytesDownloaded(),
+		CompletePieces:    info.Bitfield().Count(),
+		NumPieces:         info.Bitfield().Len(),
+	}
+}
+
+// listHandler lists blobs on disk. Supports optional "complete" (true or
+// false) and "namespace" query filters.
+func (s *Server) listHandler(w http.ResponseWriter, r *http.Request) error {
+	var opts []agentstorage.ListOption
+	q := r.URL.Query()
+	if v := q.Get("complete"); v != "" {
+		complete, err := strconv.ParseBool(v)
+		if err != nil {
+			return handler.Errorf("parse complete: %s", err).Status(http.StatusBadRequest)
+		}
+		if complete {
+			opts = append(opts, agentstorage.ListComplete())
+		} else {
+			opts = append(opts, agentstorage.ListIncomplete())
+		}
+	}
+	if _, ok := q["namespace"]; ok {
+		opts = append(opts, agentstorage.ListNamespace(q.Get("namespace")))
+	}
+	infos, err := s.archive.List(opts...)
+	if err != nil {
+		return toHandlerError("list", err)
+	}
+	stats := make([]blobStat, 0, len(infos))
+	for _, info := range infos {
+		stats = append(stats, newBlobStat(info))
+	}
+	return writeJSON(w, stats)
+}
+
+func (s *Server) verifyHandler(w http.ResponseWriter, r *http.Request) error {
+	namespace, err := httputil.ParseParam(r, "namespace")
+	if err != nil {
+		return err
+	}
+	d, err := httputil.ParseDigest(r, "digest")
+	if err != nil {
+		return err
+	}
+	if _, err := s.archive.Stat(r.Context(), namespace, d); err != nil {
+		return toHandlerError("stat", err)
+	}
+	if err := s.archive.Verify(namespace, d); err != nil {
+		if err == agentstorage.ErrClosed {
+			return toHandlerError("verify", err)
+		}
+		// Verification ran and found the blob invalid.
+		return handler.Errorf("verify: %s", err).Status(http.StatusConflict)
+	}
+	return nil
+}
+
+func (s *Server) namespaceStatsHandler(w http.ResponseWriter, r *http.Request) error {
+	namespace, err := httputil.ParseParam(r, "namespace")
+	if err != nil {
+		return err
+	}
+	stats, err := s.archive.NamespaceStats(namespace)
+	if err != nil {
+		return toHandlerError("namespace stats", err)
+	}
+	return writeJSON(w, stats)
+}
+
+func (s *Server) deleteHandler(w http.ResponseWriter, r *http.Request) error {
+	d, err := httputil.ParseDigest(r, "digest")
+	if err != nil {
+		return err
+	}
+	if err := s.archive.DeleteTorrent(d); err != nil {
+		return toHandlerError("delete", err)
+	}
+	return nil
+}
+
+func (s *Server) deleteBatchHandler(w http.ResponseWriter, r *http.Request) error {
+	var req DeleteTorrentsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return handler.Errorf("json decode: %s", err).Status(http.StatusBadRequest)
+	}
+	if err := s.archive.DeleteTorrents(req.Names); err != nil {
+		return toHandlerError("delete torrents", err)
+	}
+	return nil
+}
+
+func (s *Server) pieceLayoutHandler(w http.ResponseWriter, r *http.Request) error {
+	d, err := httputil.ParseDigest(r, "digest")
+	if err != nil {
+		return err
+	}
+	layout, err := s.archive.PieceLayout(d)
+	if err != nil {
+		return toHandlerError("piece layout", err)
+	}
+	return writeJSON(w, layout)
+}
+
+func (s *Server) availabilityHandler(w http.ResponseWriter, r *http.Request) error {
+	d, err := httputil.ParseDigest(r, "digest")
+	if err != nil {
+		return err
+	}
+	a, err := s.archive.SwarmAvailability(d.Hex())
+	if err != nil {
+		return toHandlerError("swarm availability", err)
+	}
+	return writeJSON(w, a)
+}
+
+type downloadsStatus struct {
+	Paused bool `json:"paused"`
+}
+
+func (s *Server) getDownloadsHandler(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, downloadsStatus{s.archive.DownloadsPaused()})
+}
+
+func (s *Server) pauseDownloadsHandler(w http.ResponseWriter, r *http.Request) error {
+	s.archive.PauseDownloads()
+	return nil
+}
+
+func (s *Server) resumeDownloadsHandler(w http.ResponseWriter, r *http.Request) error {
+	s.archive.ResumeDownloads()
+	return nil
+}
@@ -0,0 +1,249 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/torrent/storage/agentstorage"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+	"github.com/uber/kraken/tracker/metainfoclient"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+const _testToken = "secret"
+
+type serverMocks struct {
+	archive        *agentstorage.TorrentArchive
+	metaInfoClient *metainfoclient.TestClient
+	addr           string
+}
+
+func newServerMocks(t *testing.T) (*serverMocks, func()) {
+	var cleanup testutil.Cleanup
+
+	cads, c := store.CADownloadStoreFixture()
+	cleanup.Add(c)
+
+	mic := metainfoclient.NewTestClient()
+
+	archive := agentstorage.NewTorrentArchive(
+		agentstorage.Config{}, tally.NoopScope, clock.New(), cads, mic)
+	cleanup.Add(func() { archive.Close() })
+
+	s, err := New(Config{Token: _testToken}, tally.NoopScope, archive)
+	require.NoError(t, err)
+
+	addr, stop := testutil.StartServer(s.Handler())
+	cleanup.Add(stop)
+
+	return &serverMocks{archive, mic, addr}, cleanup.Run
+}
+
+func (m *serverMocks) url(format string, args ...interface{}) string {
+	return fmt.Sprintf("http://%s%s", m.addr, fmt.Sprintf(format, args...))
+}
+
+func auth() httputil.SendOption {
+	return httputil.SendHeaders(map[string]string{"Authorization": "Bearer " + _testToken})
+}
+
+func TestNewRequiresToken(t *testing.T) {
+	archive, cleanup := agentstorage.TorrentArchiveFixture()
+	defer cleanup()
+
+	_, err := New(Config{}, tally.NoopScope, archive)
+	require.Error(t, err)
+}
+
+func TestUnauthorized(t *testing.T) {
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	for _, opt := range []httputil.SendOption{
+		httputil.SendNoop(),
+		httputil.SendHeaders(map[string]string{"Authorization": "Bearer wrong"}),
+	} {
+		_, err := httputil.Get(mocks.url("/health"), opt)
+		require.True(t, httputil.IsStatus(err, http.StatusUnauthorized))
+	}
+}
+
+func TestStatAndDelete(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(8, 2)
+
+	_, err := httputil.Get(mocks.url("/namespace/%s/blobs/%s/stat", namespace, blob.Digest), auth())
+	require.True(httputil.IsNotFound(err))
+
+	require.NoError(mocks.metaInfoClient.Upload(blob.MetaInfo))
+	tor, err := mocks.archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+	require.NoError(err)
+	require.NoError(tor.WritePiece(piecereader.NewBuffer(blob.Content[:2]), 0))
+
+	resp, err := httputil.Get(mocks.url("/namespace/%s/blobs/%s/stat", namespace, blob.Digest), auth())
+	require.NoError(err)
+	defer resp.Body.Close()
+	var stat blobStat
+	require.NoError(json.NewDecoder(resp.Body).Decode(&stat))
+	require.Equal(blob.Digest.String(), stat.Digest)
+	require.Equal(uint(1), stat.CompletePieces)
+	require.Equal(uint(4), stat.NumPieces)
+
+	_, err = httputil.Post(mocks.url("/namespace/%s/blobs/%s/verify", namespace, blob.Digest), auth())
+	require.NoError(err)
+
+	_, err = httputil.Delete(mocks.url("/blobs/%s", blob.Digest), auth())
+	require.NoError(err)
+
+	_, err = httputil.Get(mocks.url("/namespace/%s/blobs/%s/stat", namespace, blob.Digest), auth())
+	require.True(httputil.IsNotFound(err))
+
+	_, err = httputil.Post(mocks.url("/namespace/%s/blobs/%s/verify", namespace, blob.Digest), auth())
+	require.True(httputil.IsNotFound(err))
+}
+
+func TestInvalidDigest(t *testing.T) {
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	_, err := httputil.Delete(mocks.url("/blobs/foo"), auth())
+	require.True(t, httputil.IsStatus(err, http.StatusBadRequest))
+}
+
+func TestPauseAndResumeDownloads(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	paused := func() bool {
+		resp, err := httputil.Get(mocks.url("/downloads"), auth())
+		require.NoError(err)
+		defer resp.Body.Close()
+		var status downloadsStatus
+		require.NoError(json.NewDecoder(resp.Body).Decode(&status))
+		return status.Paused
+	}
+
+	require.False(paused())
+
+	_, err := httputil.Post(mocks.url("/downloads/pause"), auth())
+	require.NoError(err)
+	require.True(paused())
+	require.True(mocks.archive.DownloadsPaused())
+
+	_, err = httputil.Post(mocks.url("/downloads/resume"), auth())
+	require.NoError(err)
+	require.False(paused())
+}
+
+func TestClosedArchiveUnavailable(t *testing.T) {
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	require.NoError(t, mocks.archive.Close())
+
+	_, err := httputil.Get(mocks.url("/health"), auth())
+	require.True(t, httputil.IsStatus(err, http.StatusServiceUnavailable))
+}
+
+func TestDeleteBatch(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	var names []string
+	for i := 0; i < 3; i++ {
+		blob := core.SizedBlobFixture(8, 2)
+		require.NoError(mocks.metaInfoClient.Upload(blob.MetaInfo))
+		_, err := mocks.archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+		require.NoError(err)
+		names = append(names, blob.Digest.Hex())
+	}
+
+	b, err := json.Marshal(DeleteTorrentsRequest{Names: names})
+	require.NoError(err)
+	_, err = httputil.Post(mocks.url("/blobs/delete"), auth(), httputil.SendBody(bytes.NewReader(b)))
+	require.NoError(err)
+
+	for _, name := range names {
+		d, err := core.NewSHA256DigestFromHex(name)
+		require.NoError(err)
+		_, err = mocks.archive.Stat(context.Background(), namespace, d)
+		require.Error(err)
+	}
+}
+
+func TestDeleteBatchInvalidBody(t *testing.T) {
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	_, err := httputil.Post(
+		mocks.url("/blobs/delete"), auth(), httputil.SendBody(bytes.NewReader([]byte("foo"))))
+	require.True(t, httputil.IsStatus(err, http.StatusBadRequest))
+}
+
+func TestList(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(8, 2)
+	require.NoError(mocks.metaInfoClient.Upload(blob.MetaInfo))
+	_, err := mocks.archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+	require.NoError(err)
+
+	list := func(query string) []blobStat {
+		resp, err := httputil.Get(mocks.url("/blobs?%s", query), auth())
+		require.NoError(err)
+		defer resp.Body.Close()
+		var stats []blobStat
+		require.NoError(json.NewDecoder(resp.Body).Decode(&stats))
+		return stats
+	}
+
+	stats := list("")
+	require.Len(stats, 1)
+	require.Equal(blob.Digest.String(), stats[0].Digest)
+	require.Equal(namespace, stats[0].Namespace)
+
+	require.Len(list("complete=false&namespace="+namespace), 1)
+	require.Len(list("complete=true"), 0)
+	require.Len(list("namespace=other"), 0)
+
+	_, err = httputil.Get(mocks.url("/blobs?complete=foo"), auth())
+	require.True(httputil.IsStatus(err, http.StatusBadRequest))
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+)
+
+// ErrSwarmAvailabilityNotFound occurs when no fresh swarm availability is
+// cached for a blob.
+var ErrSwarmAvailabilityNotFound = errors.New("swarm availability not found")
+
+// _maxAvailabilityEntries bounds the number of blobs with cached availability.
+const _maxAvailabilityEntries = 10000
+
+// AvailabilitySnapshot summarizes the swarm of a blob as of the last announce.
+// Snapshots are optimization hints only and must never be relied on for
+// correctness, since availability changes quickly.
+type AvailabilitySnapshot struct {
+	Peers     int       `json:"peers"`
+	Seeders   int       `json:"seeders"`
+	Origins   int       `json:"origins"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// availabilityCache maps blobs to their most recent AvailabilitySnapshot.
+type availabilityCache struct {
+	sync.Mutex
+	snapshots map[core.Digest]AvailabilitySnapshot
+}
+
+func newAvailabilityCache() *availabilityCache {
+	return &availabilityCache{
+		snapshots: make(map[core.Digest]AvailabilitySnapshot),
+	}
+}
+
+// put caches s for d. If the cache is full, expired snapshots are purged, and
+// s is dropped if no room was made.
+func (c *availabilityCache) put(d core.Digest, s AvailabilitySnapshot, ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.snapshots[d]; !ok && len(c.snapshots) >= _maxAvailabilityEntries {
+		for k, v := range c.snapshots {
+			if s.UpdatedAt.Sub(v.UpdatedAt) >= ttl {
+				delete(c.snapshots, k)
+			}
+		}
+		if len(c.snapshots) >= _maxAvailabilityEntries {
+			return
+		}
+	}
+	c.snapshots[d] = s
+}
+
+// get returns the snapshot for d, and whether it was found and fresh. Stale
+// snapshots are deleted.
+func (c *availabilityCache) get(
+	d core.Digest, now time.Time, ttl time.Duration) (s AvailabilitySnapshot, found, fresh bool) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	s, ok := c.snapshots[d]
+	if !ok {
+		return AvailabilitySnapshot{}, false, false
+	}
+	if now.Sub(s.UpdatedAt) >= ttl {
+		delete(c.snapshots, d)
+		return AvailabilitySnapshot{}, true, false
+	}
+	return s, true, true
+}
+
+func (c *availabilityCache) delete(d core.Digest) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.snapshots, d)
+}
+
+// RecordSwarmAvailability caches an AvailabilitySnapshot of peers, as returned
+// by the tracker in an announce response for d.
+func (a *TorrentArchive) RecordSwarmAvailability(d core.Digest, peers []*core.PeerInfo) {
+	if err := a.enter(); err != nil {
+		return
+	}
+	defer a.exit()
+
+	s := AvailabilitySnapshot{
+		Peers:     len(peers),
+		UpdatedAt: a.clk.Now(),
+	}
+	for _, p := range peers {
+		if p.Complete {
+			s.Seeders++
+		}
+		if p.Origin {
+			s.Origins++
+		}
+	}
+	a.availability.put(d, s, a.getConfig().SwarmAvailabilityTTL)
+}
+
+// SwarmAvailability returns the cached AvailabilitySnapshot of name. Returns
+// ErrSwarmAvailabilityNotFound if no snapshot was recorded within the
+// configured ttl. Intended for debugging and as a scheduling hint only.
+func (a *TorrentArchive) SwarmAvailability(name string) (AvailabilitySnapshot, error) {
+	if err := a.enter(); err != nil {
+		return AvailabilitySnapshot{}, err
+	}
+	defer a.exit()
+
+	d, err := core.NewSHA256DigestFromHex(name)
+	if err != nil {
+		return AvailabilitySnapshot{}, fmt.Errorf("new digest: %s", err)
+	}
+	s, found, fresh := a.availability.get(d, a.clk.Now(), a.getConfig().SwarmAvailabilityTTL)
+	switch {
+	case fresh:
+		a.stats.Counter("swarm_availability_hits").Inc(1)
+		return s, nil
+	case found:
+		a.stats.Counter("swarm_availability_stale").Inc(1)
+	}
+	a.stats.Counter("swarm_availability_misses").Inc(1)
+	return AvailabilitySnapshot{}, ErrSwarmAvailabilityNotFound
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTorrentArchiveSwarmAvailability(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{SwarmAvailabilityTTL: time.Minute})
+
+	d := core.DigestFixture()
+
+	_, err := archive.SwarmAvailability(d.Hex())
+	require.Equal(ErrSwarmAvailabilityNotFound, err)
+
+	seeder := core.PeerInfoFixture()
+	seeder.Complete = true
+	peers := []*core.PeerInfo{core.PeerInfoFixture(), seeder, core.OriginPeerInfoFixture()}
+
+	archive.RecordSwarmAvailability(d, peers)
+
+	s, err := archive.SwarmAvailability(d.Hex())
+	require.NoError(err)
+	require.Equal(3, s.Peers)
+	require.Equal(2, s.Seeders)
+	require.Equal(1, s.Origins)
+	require.Equal(mocks.clk.Now(), s.UpdatedAt)
+
+	mocks.clk.Add(time.Minute)
+
+	_, err = archive.SwarmAvailability(d.Hex())
+	require.Equal(ErrSwarmAvailabilityNotFound, err)
+}
+
+func TestTorrentArchiveSwarmAvailabilityInvalidName(t *testing.T) {
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	_, err := archive.SwarmAvailability("foo")
+	require.Error(t, err)
+}
+
+func TestAvailabilityCachePurgesExpiredWhenFull(t *testing.T) {
+	require := require.New(t)
+
+	c := newAvailabilityCache()
+	now := time.Now()
+
+	for i := 0; i < _maxAvailabilityEntries; i++ {
+		c.put(core.DigestFixture(), AvailabilitySnapshot{UpdatedAt: now}, time.Minute)
+	}
+
+	// Dropped while every entry is fresh.
+	d1 := core.DigestFixture()
+	c.put(d1, AvailabilitySnapshot{UpdatedAt: now}, time.Minute)
+	_, found, _ := c.get(d1, now, time.Minute)
+	require.False(found)
+
+	// Expired entries are purged to make room.
+	d2 := core.DigestFixture()
+	later := now.Add(time.Minute)
+	c.put(d2, AvailabilitySnapshot{UpdatedAt: later}, time.Minute)
+	_, _, fresh := c.get(d2, later, time.Minute)
+	require.True(fresh)
+	require.Len(c.snapshots, 1)
+}
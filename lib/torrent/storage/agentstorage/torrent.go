@@ -39,6 +39,7 @@ var (
 type caDownloadStore interface {
 	MoveDownloadFileToCache(name string) error
 	GetDownloadFileReadWriter(name string) (store.FileReadWriter, error)
+	DownloadFileWritten(name string, w store.FileReadWriter) error
 	Any() *store.CADownloadStoreScope
 	Download() *store.CADownloadStoreScope
 	InCacheError(error) bool
@@ -49,15 +50,54 @@ type caDownloadStore interface {
 // pieces. Behavior is undefined if multiple Torrent instances are backed
 // by the same file store and metainfo.
 type Torrent struct {
+	namespace   string
 	metaInfo    *core.MetaInfo
 	cads        caDownloadStore
 	pieces      []*piece
 	numComplete *atomic.Int32
 	committed   *atomic.Bool
+	hooks       torrentHooks
+	syncPieces  bool
+	mmaps       *mmapCache
+	mmapAdvice  MmapAdvice
+}
+
+// torrentHooks allows TorrentArchive to observe and gate Torrent operations.
+type torrentHooks struct {
+	// beforeWrite is called before a piece is written. If it returns an error,
+	// the write is aborted.
+	beforeWrite func() error
+
+	// onCommit is called once the download file has been moved to the cache
+	// directory.
+	onCommit func()
+
+	// beginWrite is called before piece data is written. The returned function
+	// is called once the write completes.
+	beginWrite func() (done func())
+}
+
+func (h torrentHooks) applyDefaults() torrentHooks {
+	if h.beforeWrite == nil {
+		h.beforeWrite = func() error { return nil }
+	}
+	if h.onCommit == nil {
+		h.onCommit = func() {}
+	}
+	if h.beginWrite == nil {
+		h.beginWrite = func() func() { return func() {} }
+	}
+	return h
 }
 
 // NewTorrent creates a new Torrent.
 func NewTorrent(cads caDownloadStore, mi *core.MetaInfo) (*Torrent, error) {
+	return newTorrent(cads, mi, torrentHooks{})
+}
+
+func newTorrent(cads caDownloadStore, mi *core.MetaInfo, hooks torrentHooks) (*Torrent, error) {
+	hooks = hooks.applyDefaults()
+
 	pieces, numComplete, err := restorePieces(mi.Digest(), cads, mi.NumPieces())
 	if err != nil {
 		return nil, fmt.Errorf("restore pieces: %s", err)
@@ -69,6 +109,7 @@ func NewTorrent(cads caDownloadStore, mi *core.MetaInfo) (*Torrent, error) {
 			return nil, fmt.Errorf("move file to cache: %s", err)
 		}
 		committed = true
+		hooks.onCommit()
 	}
 
 	return &Torrent{
@@ -77,6 +118,7 @@ func NewTorrent(cads caDownloadStore, mi *core.MetaInfo) (*Torrent, error) {
 		pieces:      pieces,
 		numComplete: atomic.NewInt32(int32(numComplete)),
 		committed:   atomic.NewBool(committed),
+		hooks:       hooks,
 	}, nil
 }
 
@@ -87,7 +129,7 @@ func (t *Torrent) Digest() core.Digest {
 
 // Stat returns the storage.TorrentInfo for t.
 func (t *Torrent) Stat() *storage.TorrentInfo {
-	return storage.NewTorrentInfo(t.metaInfo, t.Bitfield())
+	return storage.NewTorrentInfo(t.namespace, t.metaInfo, t.Bitfield())
 }
 
 // InfoHash returns the torrent metainfo hash.
@@ -107,7 +149,7 @@ func (t *Torrent) Length() int64 {
 
 // PieceLength returns the length of piece pi.
 func (t *Torrent) PieceLength(pi int) int64 {
-	return t.metaInfo.GetPieceLength(pi)
+	return t.metaInfo.PieceSize(pi)
 }
 
 // MaxPieceLength returns the longest piece length of the torrent.
@@ -191,6 +233,17 @@ func (t *Torrent) writePiece(src storage.PieceReader, pi int) error {
 	if h.Sum32() != t.metaInfo.GetPieceSum(pi) {
 		return errors.New("invalid piece sum")
 	}
+	if t.syncPieces {
+		s, ok := f.(syncer)
+		if !ok {
+			return errors.New("download file does not support sync")
+		}
+		if err := s.Sync(); err != nil {
+			return fmt.Errorf("sync: %s", err)
+		}
+	} else if err := t.cads.DownloadFileWritten(t.metaInfo.Digest().Hex(), f); err != nil {
+		return fmt.Errorf("sync: %s", err)
+	}
 
 	if err := t.markPieceComplete(pi); err != nil {
 		return fmt.Errorf("mark piece complete: %s", err)
@@ -213,6 +266,9 @@ func (t *Torrent) WritePiece(src storage.PieceReader, pi int) error {
 	if piece.complete() {
 		return storage.ErrPieceComplete
 	}
+	if err := t.hooks.beforeWrite(); err != nil {
+		return err
+	}
 	if piece.dirty() {
 		return errWritePieceConflict
 	}
@@ -228,7 +284,10 @@ func (t *Torrent) WritePiece(src storage.PieceReader, pi int) error {
 	// we are the only thread which may write the piece. We do not block other
 	// threads from checking if the piece is writable.
 
-	if err := t.writePiece(src, pi); err != nil {
+	done := t.hooks.beginWrite()
+	err = t.writePiece(src, pi)
+	done()
+	if err != nil {
 		// Allow other threads to write this piece since we mysteriously failed.
 		piece.markEmpty()
 		return fmt.Errorf("write piece: %s", err)
@@ -243,6 +302,7 @@ func (t *Torrent) WritePiece(src storage.PieceReader, pi int) error {
 			return fmt.Errorf("download completed but failed to move file to cache directory: %s", err)
 		}
 		t.committed.Store(true)
+		t.hooks.onCommit()
 	}
 
 	return nil
@@ -265,6 +325,14 @@ func (t *Torrent) GetPieceReader(pi int) (storage.PieceReader, error) {
 	if !piece.complete() {
 		return nil, errPieceNotComplete
 	}
+	if t.mmaps != nil && t.committed.Load() {
+		r, err := t.getMappedPieceReader(pi)
+		if err == nil {
+			return r, nil
+		}
+		log.With("name", t.Digest().Hex()).Warnf("Falling back to file reader: %s", err)
+		t.mmaps.stats.Counter("mmap_fallbacks").Inc(1)
+	}
 	return piecereader.NewFileReader(t.getFileOffset(pi), t.PieceLength(pi), &opener{t}), nil
 }
 
@@ -291,7 +359,7 @@ func (t *Torrent) MissingPieces() []int {
 // getFileOffset calculates the offset in the torrent file given piece index.
 // Assumes pi is a valid piece index.
 func (t *Torrent) getFileOffset(pi int) int64 {
-	return t.metaInfo.PieceLength() * int64(pi)
+	return t.metaInfo.PieceOffset(pi)
 }
 
 func min(a, b int64) int64 {
@@ -14,47 +14,265 @@
 package agentstorage
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"sync"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/uber-go/tally"
 	"github.com/willf/bitset"
+	"go.uber.org/atomic"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/store/metadata"
 	"github.com/uber/kraken/lib/torrent/storage"
 	"github.com/uber/kraken/tracker/metainfoclient"
+	"github.com/uber/kraken/utils/log"
 )
 
+// ErrClosed occurs when operating on a closed TorrentArchive.
+var ErrClosed = errors.New("torrent archive closed")
+
+// ErrTooManyPieces occurs when metainfo has more pieces than the configured
+// maximum piece count.
+var ErrTooManyPieces = errors.New("metainfo exceeds max piece count")
+
+var _pieceCountBuckets = tally.MustMakeExponentialValueBuckets(1, 4, 12)
+
 // TorrentArchive is capable of initializing torrents in the download directory
 // and serving torrents from either the download or cache directory.
 type TorrentArchive struct {
+	config         atomic.Value // Config
 	stats          tally.Scope
+	clk            clock.Clock
 	cads           *store.CADownloadStore
 	metaInfoClient metainfoclient.Client
+	budget         *budget
+	breaker        *breaker
+	namespaces     *namespaceTracker
+	downloads      *pauseGate
+	inits          singleflight.Group
+	availability   *availabilityCache
+	metaInfoCache  *metaInfoCache
+	resumes        resumeVerifier
+	mmaps          *mmapCache
+	writes         *storage.WriteQueue
+
+	// Existing vs. newly initialized torrents in CreateTorrent.
+	hits   *atomic.Int64
+	misses *atomic.Int64
+
+	// Serializes UpdateConfig calls.
+	reloadMu sync.Mutex
+
+	// Serializes quota checks with download file creation.
+	quotaMu sync.Mutex
+
+	// Operations hold mu for reading while they run, such that Close waits for
+	// in-flight operations to complete before returning.
+	mu        sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+type options struct {
+	heartbeatSink HeartbeatSink
+}
+
+// Option defines an optional NewTorrentArchive parameter.
+type Option func(*options)
+
+// WithHeartbeatSink periodically sends ArchiveHealth heartbeats to s.
+func WithHeartbeatSink(s HeartbeatSink) Option {
+	return func(o *options) { o.heartbeatSink = s }
 }
 
 // NewTorrentArchive creates a new TorrentArchive.
 func NewTorrentArchive(
+	config Config,
 	stats tally.Scope,
+	clk clock.Clock,
 	cads *store.CADownloadStore,
-	mic metainfoclient.Client) *TorrentArchive {
+	mic metainfoclient.Client,
+	opts ...Option) *TorrentArchive {
+
+	config = config.applyDefaults()
 
 	stats = stats.Tagged(map[string]string{
 		"module": "agenttorrentarchive",
 	})
 
-	return &TorrentArchive{stats, cads, mic}
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	a := &TorrentArchive{
+		stats:          stats,
+		clk:            clk,
+		cads:           cads,
+		metaInfoClient: mic,
+		budget:         newBudget(config, clk),
+		breaker:        newBreaker(config.MetaInfoCircuitBreaker, clk, stats),
+		namespaces:     newNamespaceTracker(),
+		downloads:      &pauseGate{},
+		availability:   newAvailabilityCache(),
+		metaInfoCache:  newMetaInfoCache(config.MetaInfoCache.Size),
+		mmaps:          newMmapCache(config.Mmap.MaxMappings, stats),
+		writes:         storage.NewWriteQueue(config.WriteQueue, stats),
+		hits:           atomic.NewInt64(0),
+		misses:         atomic.NewInt64(0),
+		done:           make(chan struct{}),
+	}
+	a.config.Store(config)
+	if config.Durability.ReconcileOnStartup {
+		a.reconcile()
+	}
+	if config.ConsistencyCheck.Enabled {
+		a.wg.Add(1)
+		go a.consistencyLoop(clk.Ticker(config.ConsistencyCheck.Interval))
+	}
+	if o.heartbeatSink != nil {
+		a.wg.Add(1)
+		go a.heartbeat(o.heartbeatSink, clk.Ticker(config.HeartbeatInterval))
+	}
+	return a
+}
+
+// Close waits for in-flight operations to complete and terminates all goroutines
+// started by a. Subsequent operations return ErrClosed. Close is idempotent.
+func (a *TorrentArchive) Close() error {
+	// Closing done first wakes operations blocked on paused downloads, which
+	// must exit before mu can be acquired.
+	a.closeOnce.Do(func() { close(a.done) })
+
+	a.mu.Lock()
+	a.closed = true
+	a.mu.Unlock()
+
+	a.wg.Wait()
+	a.mmaps.clear()
+	return nil
+}
+
+// getConfig returns the live configuration.
+func (a *TorrentArchive) getConfig() Config {
+	return a.config.Load().(Config)
+}
+
+// WriteQueue returns the queue of piece writes in flight to a's disk.
+func (a *TorrentArchive) WriteQueue() *storage.WriteQueue {
+	return a.writes
+}
+
+// UpdateConfig atomically swaps the live configuration for config, such that
+// subsequent operations observe the new values. Operations already in flight
+// may observe either configuration. Returns an error if config is invalid or
+// changes a field which requires restart, in which case the live configuration
+// is unchanged.
+func (a *TorrentArchive) UpdateConfig(config Config) error {
+	if err := a.enter(); err != nil {
+		return err
+	}
+	defer a.exit()
+
+	config = config.applyDefaults()
+	if err := config.validate(); err != nil {
+		return fmt.Errorf("invalid config: %s", err)
+	}
+
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	if err := config.checkImmutable(a.getConfig()); err != nil {
+		return err
+	}
+	a.budget.update(config)
+	a.breaker.update(config.MetaInfoCircuitBreaker)
+	a.writes.Update(config.WriteQueue)
+	a.config.Store(config)
+
+	a.stats.Counter("config_updates").Inc(1)
+	log.Info("Torrent archive config updated")
+
+	return nil
+}
+
+// enter must be called at the start of every operation. If enter succeeds,
+// exit must be called when the operation completes.
+func (a *TorrentArchive) enter() error {
+	a.mu.RLock()
+	if a.closed {
+		a.mu.RUnlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+func (a *TorrentArchive) exit() {
+	a.mu.RUnlock()
+}
+
+// Reserve holds n bytes of the disk budget for subsequent CreateTorrent calls,
+// such that a batch of torrents can be admitted all-or-nothing. Returns
+// ErrDiskBudgetExceeded if n bytes are not available. Callers should Release
+// the reservation once the batch is initialized; otherwise, the unconsumed
+// portion is returned after the configured reservation ttl.
+func (a *TorrentArchive) Reserve(n int64) (Reservation, error) {
+	if err := a.enter(); err != nil {
+		return nil, err
+	}
+	defer a.exit()
+
+	r, err := a.budget.reserve(n)
+	if err != nil {
+		a.stats.Counter("reserve_budget_exceeded").Inc(1)
+		return nil, err
+	}
+	return r, nil
 }
 
 // Stat returns TorrentInfo for the given digest. Returns os.ErrNotExist if the
-// file does not exist. Ignores namespace.
-func (a *TorrentArchive) Stat(namespace string, d core.Digest) (*storage.TorrentInfo, error) {
-	var tm metadata.TorrentMeta
-	if err := a.cads.Any().GetMetadata(d.Hex(), &tm); err != nil {
+// file does not exist. Returns storage.ErrNamespaceMismatch if namespace
+// enforcement is enabled and the torrent was initialized under a different
+// namespace.
+func (a *TorrentArchive) Stat(
+	ctx context.Context, namespace string, d core.Digest) (*storage.TorrentInfo, error) {
+
+	if err := a.enter(); err != nil {
+		return nil, err
+	}
+	defer a.exit()
+
+	info, err := a.torrentInfo(namespace, d)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.checkNamespace(namespace, info.Namespace()); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// torrentInfo returns TorrentInfo for d, accessed under namespace. Returns
+// os.ErrNotExist if the file does not exist.
+func (a *TorrentArchive) torrentInfo(
+	namespace string, d core.Digest) (*storage.TorrentInfo, error) {
+
+	mi, err := a.getMetaInfo(namespace, d)
+	if err != nil {
 		return nil, err
 	}
+	stored, err := a.storedNamespace(d)
+	if err != nil {
+		return nil, fmt.Errorf("get namespace: %s", err)
+	}
 	var psm pieceStatusMetadata
 	if err := a.cads.Any().GetMetadata(d.Hex(), &psm); err != nil {
 		return nil, err
@@ -65,66 +283,348 @@ func (a *TorrentArchive) Stat(namespace string, d core.Digest) (*storage.Torrent
 			b.Set(uint(i))
 		}
 	}
-	return storage.NewTorrentInfo(tm.MetaInfo, b), nil
+	return storage.NewTorrentInfo(stored, mi, b), nil
 }
 
 // CreateTorrent returns a Torrent for either an existing metainfo / file on
 // disk, or downloads metainfo and initializes the file. Returns ErrNotFound
-// if no metainfo was found.
-func (a *TorrentArchive) CreateTorrent(namespace string, d core.Digest) (storage.Torrent, error) {
-	var tm metadata.TorrentMeta
-	if err := a.cads.Any().GetMetadata(d.Hex(), &tm); os.IsNotExist(err) {
-		downloadTimer := a.stats.Timer("metainfo_download").Start()
-		mi, err := a.metaInfoClient.Download(namespace, d)
+// if no metainfo was found. Returns ctx.Err() if ctx is done before the metainfo
+// is downloaded and the file initialized. Returns storage.ErrNamespaceMismatch
+// if namespace enforcement is enabled and the torrent was initialized under a
+// different namespace.
+func (a *TorrentArchive) CreateTorrent(
+	ctx context.Context, namespace string, d core.Digest) (storage.Torrent, error) {
+
+	if err := a.enter(); err != nil {
+		return nil, err
+	}
+	defer a.exit()
+
+	existing := true
+	mi, err := a.getMetaInfo(namespace, d)
+	if os.IsNotExist(err) {
+		existing = false
+		a.misses.Inc()
+		a.namespaces.miss(namespace)
+		mi, err = a.initializeOnce(ctx, namespace, d)
 		if err != nil {
-			if err == metainfoclient.ErrNotFound {
-				return nil, storage.ErrNotFound
-			}
-			return nil, fmt.Errorf("download metainfo: %s", err)
-		}
-		downloadTimer.Stop()
-
-		// There's a race condition here, but it's "okay"... Basically, we could
-		// initialize a download file with metainfo that is rejected by file store,
-		// because someone else beats us to it. However, we catch a lucky break
-		// because the only piece of metainfo we use is file length -- which digest
-		// is derived from, so it's "okay".
-		createErr := a.cads.CreateDownloadFile(mi.Digest().Hex(), mi.Length())
-		if createErr != nil &&
-			!(a.cads.InDownloadError(createErr) || a.cads.InCacheError(createErr)) {
-			return nil, fmt.Errorf("create download file: %s", createErr)
-		}
-		tm.MetaInfo = mi
-		if err := a.cads.Any().GetOrSetMetadata(d.Hex(), &tm); err != nil {
-			return nil, fmt.Errorf("get or set metainfo: %s", err)
+			return nil, err
 		}
 	} else if err != nil {
 		return nil, fmt.Errorf("get metainfo: %s", err)
 	}
-	t, err := NewTorrent(a.cads, tm.MetaInfo)
+	stored, err := a.storedNamespace(d)
+	if err != nil {
+		return nil, fmt.Errorf("get namespace: %s", err)
+	}
+	if err := a.checkNamespace(namespace, stored); err != nil {
+		return nil, err
+	}
+	t, err := a.newTorrent(stored, mi)
 	if err != nil {
 		return nil, fmt.Errorf("initialize torrent: %s", err)
 	}
+	if existing {
+		a.hits.Inc()
+		a.namespaces.hit(namespace)
+		if err := a.validate(t); err != nil {
+			return nil, err
+		}
+	}
 	return t, nil
 }
 
-// GetTorrent returns a Torrent for an existing metainfo / file on disk. Ignores namespace.
-func (a *TorrentArchive) GetTorrent(namespace string, d core.Digest) (storage.Torrent, error) {
-	var tm metadata.TorrentMeta
-	if err := a.cads.Any().GetMetadata(d.Hex(), &tm); err != nil {
+// initializeOnce initializes the download of d, deduplicating concurrent calls
+// such that only one metainfo download and file initialization runs per blob,
+// while other callers wait on its result. If the shared call was cancelled by
+// the context of the caller which started it, waiters whose own context is
+// still live start a new call.
+func (a *TorrentArchive) initializeOnce(
+	ctx context.Context, namespace string, d core.Digest) (*core.MetaInfo, error) {
+
+	for {
+		v, err, shared := a.inits.Do(d.Hex(), func() (interface{}, error) {
+			return a.initialize(ctx, namespace, d)
+		})
+		if shared {
+			a.stats.Counter("initialize_shared").Inc(1)
+		}
+		if err != nil {
+			if (err == context.Canceled || err == context.DeadlineExceeded) && ctx.Err() == nil {
+				continue
+			}
+			return nil, err
+		}
+		return v.(*core.MetaInfo), nil
+	}
+}
+
+// initialize downloads metainfo for d and initializes its download file.
+func (a *TorrentArchive) initialize(
+	ctx context.Context, namespace string, d core.Digest) (*core.MetaInfo, error) {
+
+	if err := a.waitForDownloads(ctx); err != nil {
+		return nil, err
+	}
+	mi, ok := a.cachedMetaInfo(namespace, d)
+	if !ok {
+		var err error
+		mi, err = a.downloadMetaInfo(ctx, namespace, d)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a.stats.Histogram("piece_count", _pieceCountBuckets).RecordValue(float64(mi.NumPieces()))
+	if err := a.checkMetaInfo(mi); err != nil {
+		a.stats.Counter("invalid_metainfo").Inc(1)
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := a.budget.charge(d, mi.Length()); err != nil {
+		a.stats.Counter("create_budget_exceeded").Inc(1)
+		return nil, err
+	}
+
+	// There's a race condition here, but it's "okay"... Basically, we could
+	// initialize a download file with metainfo that is rejected by file store,
+	// because someone else beats us to it. However, we catch a lucky break
+	// because the only piece of metainfo we use is file length -- which digest
+	// is derived from, so it's "okay".
+	initTimer := a.namespaceStats(namespace).Timer("file_initialization").Start()
+	createErr := a.createDownloadFile(namespace, mi)
+	if createErr == ErrQuotaExceeded {
+		a.budget.release(d)
+		a.stats.Counter("quota_exceeded").Inc(1)
+		return nil, createErr
+	}
+	if createErr != nil {
+		// Either someone else initialized the file (and owns the charge)
+		// or we failed to, so our charge is released.
+		a.budget.release(d)
+		if !(a.cads.InDownloadError(createErr) || a.cads.InCacheError(createErr)) {
+			return nil, fmt.Errorf("create download file: %s", createErr)
+		}
+		a.raceLost(namespace, "file")
+	} else {
+		a.namespaces.add(namespace, d, mi.Length())
+	}
+	tm := metadata.TorrentMeta{MetaInfo: mi}
+	if err := a.cads.Any().GetOrSetMetadata(d.Hex(), &tm); err != nil {
+		return nil, fmt.Errorf("get or set metainfo: %s", err)
+	}
+	if tm.MetaInfo != mi {
+		// Existing metadata was deserialized into tm.
+		a.raceLost(namespace, "metainfo")
+	}
+	a.cacheMetaInfo(tm.MetaInfo)
+	// If someone else initialized the file first, their namespace wins and
+	// is checked by the caller.
+	nm := metadata.Namespace{Value: namespace}
+	if err := a.cads.Any().GetOrSetMetadata(d.Hex(), &nm); err != nil {
+		return nil, fmt.Errorf("get or set namespace: %s", err)
+	}
+	if nm.Value != namespace {
+		a.raceLost(namespace, "namespace")
+	}
+	initTimer.Stop()
+	return tm.MetaInfo, nil
+}
+
+// downloadMetaInfo downloads the metainfo of d from the tracker, failing fast
+// while the tracker is unavailable.
+func (a *TorrentArchive) downloadMetaInfo(
+	ctx context.Context, namespace string, d core.Digest) (*core.MetaInfo, error) {
+
+	if err := a.breaker.allow(); err != nil {
+		return nil, err
+	}
+	stats := a.namespaceStats(namespace)
+	downloadTimer := stats.Timer("metainfo_download").Start()
+	mi, err := a.metaInfoClient.Download(ctx, namespace, d)
+	if ctx.Err() != nil {
+		// Cancellation says nothing about tracker availability.
+		a.breaker.abandon()
+		return nil, ctx.Err()
+	}
+	// Not found means the tracker is available.
+	a.breaker.record(err != nil && err != metainfoclient.ErrNotFound)
+	if err != nil {
+		if err == metainfoclient.ErrNotFound {
+			stats.Counter("metainfo_not_found").Inc(1)
+			return nil, storage.ErrNotFound
+		}
+		return nil, fmt.Errorf("download metainfo: %s", err)
+	}
+	downloadTimer.Stop()
+	return mi, nil
+}
+
+// GetTorrent returns a Torrent for an existing metainfo / file on disk. Returns
+// storage.ErrNamespaceMismatch if namespace enforcement is enabled and the
+// torrent was initialized under a different namespace.
+func (a *TorrentArchive) GetTorrent(
+	ctx context.Context, namespace string, d core.Digest) (storage.Torrent, error) {
+
+	if err := a.enter(); err != nil {
+		return nil, err
+	}
+	defer a.exit()
+
+	mi, err := a.getMetaInfo(namespace, d)
+	if err != nil {
 		return nil, fmt.Errorf("get metainfo: %s", err)
 	}
-	t, err := NewTorrent(a.cads, tm.MetaInfo)
+	stored, err := a.storedNamespace(d)
+	if err != nil {
+		return nil, fmt.Errorf("get namespace: %s", err)
+	}
+	if err := a.checkNamespace(namespace, stored); err != nil {
+		return nil, err
+	}
+	t, err := a.newTorrent(stored, mi)
 	if err != nil {
 		return nil, fmt.Errorf("initialize torrent: %s", err)
 	}
+	if err := a.validate(t); err != nil {
+		return nil, err
+	}
 	return t, nil
 }
 
+// GetTorrentRange returns a view of an existing Torrent restricted to pieces
+// [startPiece, endPiece), such that only the pieces in the range are fetched
+// and read. Returns ErrRangeInvalid if the range is out of bounds.
+func (a *TorrentArchive) GetTorrentRange(
+	ctx context.Context,
+	namespace string,
+	d core.Digest,
+	startPiece, endPiece int) (storage.Torrent, error) {
+
+	t, err := a.GetTorrent(ctx, namespace, d)
+	if err != nil {
+		return nil, err
+	}
+	rt, err := newRangeTorrent(t.(*Torrent), startPiece, endPiece)
+	if err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
 // DeleteTorrent deletes a torrent from disk.
 func (a *TorrentArchive) DeleteTorrent(d core.Digest) error {
-	if err := a.cads.Any().DeleteFile(d.Hex()); err != nil && !os.IsNotExist(err) {
+	if err := a.enter(); err != nil {
+		return err
+	}
+	defer a.exit()
+
+	return a.deleteTorrent(d)
+}
+
+func (a *TorrentArchive) deleteTorrent(d core.Digest) error {
+	// Best effort, since the namespace only attributes the delete in metrics.
+	namespace, _ := a.storedNamespace(d)
+
+	a.mmaps.remove(d)
+	if err := a.cads.Any().DeleteFile(d.Hex()); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	a.namespaceStats(namespace).Counter("deleted").Inc(1)
+	a.budget.release(d)
+	a.namespaces.remove(d)
+	a.availability.delete(d)
+	a.metaInfoCache.delete(d)
+	a.resumes.forget(d)
+	return nil
+}
+
+// Verify hashes every complete piece of the torrent for d against its metainfo,
+// returning an error on the first invalid piece. Unlike validation on get,
+// Verify does not delete invalid torrents. Ignores namespace.
+func (a *TorrentArchive) Verify(namespace string, d core.Digest) error {
+	if err := a.enter(); err != nil {
 		return err
 	}
+	defer a.exit()
+
+	return a.verify(d)
+}
+
+func (a *TorrentArchive) verify(d core.Digest) error {
+	var tm metadata.TorrentMeta
+	if err := a.cads.Any().GetMetadata(d.Hex(), &tm); err != nil {
+		return fmt.Errorf("get metainfo: %s", err)
+	}
+	t, err := a.newTorrent("", tm.MetaInfo)
+	if err != nil {
+		return fmt.Errorf("initialize torrent: %s", err)
+	}
+	verifyErr := t.validate(ValidateFull, a.getConfig().VerifyOrder)
+	a.recordVerification(d, verifyErr == nil)
+	if verifyErr != nil {
+		a.stats.Counter("verify_failures").Inc(1)
+		return verifyErr
+	}
+	return nil
+}
+
+// validate checks t per the configured validation level. Torrents which fail
+// validation are deleted such that the next CreateTorrent downloads them again.
+func (a *TorrentArchive) validate(t *Torrent) error {
+	config := a.getConfig()
+	if err := t.validate(config.ValidateOnGet, config.VerifyOrder); err != nil {
+		a.stats.Counter("validation_failures").Inc(1)
+		log.With("name", t.Digest().Hex()).Errorf("Torrent failed validation, deleting: %s", err)
+		if err := a.deleteTorrent(t.Digest()); err != nil {
+			log.With("name", t.Digest().Hex()).Errorf("Error deleting invalid torrent: %s", err)
+		}
+		return fmt.Errorf("validate torrent: %s", err)
+	}
+	return nil
+}
+
+// checkMetaInfo returns an error if mi should not be initialized as a Torrent.
+func (a *TorrentArchive) checkMetaInfo(mi *core.MetaInfo) error {
+	if err := mi.Validate(); err != nil {
+		return fmt.Errorf("invalid metainfo: %s", err)
+	}
+	if max := a.getConfig().MaxPieceCount; max > 0 && mi.NumPieces() > max {
+		return ErrTooManyPieces
+	}
 	return nil
 }
+
+func (a *TorrentArchive) newTorrent(namespace string, mi *core.MetaInfo) (*Torrent, error) {
+	if err := a.checkMetaInfo(mi); err != nil {
+		return nil, err
+	}
+	d := mi.Digest()
+	a.verifyOnResume(d)
+	t, err := newTorrent(a.cads, mi, torrentHooks{
+		beforeWrite: func() error { return a.downloads.wait(context.Background(), a.done) },
+		beginWrite:  a.writes.Begin,
+		onCommit: func() {
+			a.budget.release(d)
+			a.namespaces.complete(d)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.namespace = namespace
+	t.syncPieces = a.getConfig().Durability.SyncPieces
+	// Mapped files would expose ciphertext, so encrypted stores are always read
+	// through the store.
+	if config := a.getConfig().Mmap; config.Enabled && !a.cads.Encrypted() {
+		t.mmaps = a.mmaps
+		t.mmapAdvice = config.Advice
+	}
+	return t, nil
+}
@@ -14,9 +14,13 @@
 package agentstorage
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/store"
@@ -28,6 +32,7 @@ import (
 	"github.com/uber/kraken/utils/bitsetutil"
 	"github.com/uber/kraken/utils/testutil"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 	"github.com/uber-go/tally"
@@ -36,6 +41,7 @@ import (
 const pieceLength = 4
 
 type archiveMocks struct {
+	clk            *clock.Mock
 	cads           *store.CADownloadStore
 	metaInfoClient *mockmetainfoclient.MockClient
 }
@@ -51,11 +57,15 @@ func newArchiveMocks(t *testing.T) (*archiveMocks, func()) {
 
 	metaInfoClient := mockmetainfoclient.NewMockClient(ctrl)
 
-	return &archiveMocks{cads, metaInfoClient}, cleanup.Run
+	return &archiveMocks{clock.NewMock(), cads, metaInfoClient}, cleanup.Run
 }
 
 func (m *archiveMocks) new() *TorrentArchive {
-	return NewTorrentArchive(tally.NoopScope, m.cads, m.metaInfoClient)
+	return m.newWithConfig(Config{})
+}
+
+func (m *archiveMocks) newWithConfig(config Config) *TorrentArchive {
+	return NewTorrentArchive(config, tally.NoopScope, m.clk, m.cads, m.metaInfoClient)
 }
 
 func TestTorrentArchiveStatBitfield(t *testing.T) {
@@ -70,14 +80,14 @@ func TestTorrentArchiveStatBitfield(t *testing.T) {
 	blob := core.SizedBlobFixture(4, 1)
 	mi := blob.MetaInfo
 
-	mocks.metaInfoClient.EXPECT().Download(namespace, mi.Digest()).Return(mi, nil).Times(1)
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil).Times(1)
 
-	tor, err := archive.CreateTorrent(namespace, mi.Digest())
+	tor, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
 	require.NoError(err)
 
 	require.NoError(tor.WritePiece(piecereader.NewBuffer(blob.Content[2:3]), 2))
 
-	info, err := archive.Stat(namespace, mi.Digest())
+	info, err := archive.Stat(context.Background(), namespace, mi.Digest())
 	require.NoError(err)
 	require.Equal(bitsetutil.FromBools(false, false, true, false), info.Bitfield())
 	require.Equal(int64(1), info.MaxPieceLength())
@@ -94,7 +104,7 @@ func TestTorrentArchiveStatNotExist(t *testing.T) {
 	namespace := core.TagFixture()
 	d := core.DigestFixture()
 
-	_, err := archive.Stat(namespace, d)
+	_, err := archive.Stat(context.Background(), namespace, d)
 	require.True(os.IsNotExist(err))
 }
 
@@ -109,9 +119,9 @@ func TestTorrentArchiveCreateTorrent(t *testing.T) {
 	mi := core.MetaInfoFixture()
 	namespace := core.TagFixture()
 
-	mocks.metaInfoClient.EXPECT().Download(namespace, mi.Digest()).Return(mi, nil)
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil)
 
-	tor, err := archive.CreateTorrent(namespace, mi.Digest())
+	tor, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
 	require.NoError(err)
 	require.NotNil(tor)
 
@@ -121,7 +131,7 @@ func TestTorrentArchiveCreateTorrent(t *testing.T) {
 	require.Equal(mi, tm.MetaInfo)
 
 	// Create again reads from disk.
-	tor, err = archive.CreateTorrent(namespace, mi.Digest())
+	tor, err = archive.CreateTorrent(context.Background(), namespace, mi.Digest())
 	require.NoError(err)
 	require.NotNil(tor)
 }
@@ -137,9 +147,9 @@ func TestTorrentArchiveCreateTorrentNotFound(t *testing.T) {
 	mi := core.MetaInfoFixture()
 	namespace := core.TagFixture()
 
-	mocks.metaInfoClient.EXPECT().Download(namespace, mi.Digest()).Return(nil, metainfoclient.ErrNotFound)
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(nil, metainfoclient.ErrNotFound)
 
-	_, err := archive.CreateTorrent(namespace, mi.Digest())
+	_, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
 	require.Equal(storage.ErrNotFound, err)
 }
 
@@ -154,15 +164,15 @@ func TestTorrentArchiveDeleteTorrent(t *testing.T) {
 	mi := core.MetaInfoFixture()
 	namespace := core.TagFixture()
 
-	mocks.metaInfoClient.EXPECT().Download(namespace, mi.Digest()).Return(mi, nil)
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil)
 
-	tor, err := archive.CreateTorrent(namespace, mi.Digest())
+	tor, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
 	require.NoError(err)
 	require.NotNil(tor)
 
 	require.NoError(archive.DeleteTorrent(mi.Digest()))
 
-	_, err = archive.Stat(namespace, mi.Digest())
+	_, err = archive.Stat(context.Background(), namespace, mi.Digest())
 	require.True(os.IsNotExist(err))
 }
 
@@ -178,14 +188,14 @@ func TestTorrentArchiveConcurrentGet(t *testing.T) {
 	namespace := core.TagFixture()
 
 	// Allow any times for concurrency below.
-	mocks.metaInfoClient.EXPECT().Download(namespace, mi.Digest()).Return(mi, nil).AnyTimes()
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil).AnyTimes()
 
 	var wg sync.WaitGroup
 	for i := 0; i < 50; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			tor, err := archive.CreateTorrent(namespace, mi.Digest())
+			tor, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
 			require.NoError(err)
 			require.NotNil(tor)
 		}()
@@ -205,16 +215,554 @@ func TestTorrentArchiveGetTorrent(t *testing.T) {
 	namespace := core.TagFixture()
 
 	// Since metainfo is not yet on disk, get should fail.
-	_, err := archive.GetTorrent(namespace, mi.Digest())
+	_, err := archive.GetTorrent(context.Background(), namespace, mi.Digest())
 	require.Error(err)
 
-	mocks.metaInfoClient.EXPECT().Download(namespace, mi.Digest()).Return(mi, nil)
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil)
 
-	_, err = archive.CreateTorrent(namespace, mi.Digest())
+	_, err = archive.CreateTorrent(context.Background(), namespace, mi.Digest())
 	require.NoError(err)
 
 	// After creating the torrent, get should succeed.
-	tor, err := archive.GetTorrent(namespace, mi.Digest())
+	tor, err := archive.GetTorrent(context.Background(), namespace, mi.Digest())
 	require.NoError(err)
 	require.NotNil(tor)
 }
+
+func TestTorrentArchiveCreateTorrentDiskBudgetExceeded(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{DiskBudget: 10})
+
+	namespace := core.TagFixture()
+	blob1 := core.SizedBlobFixture(8, 1)
+	blob2 := core.SizedBlobFixture(8, 1)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob1.Digest).Return(blob1.MetaInfo, nil)
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob2.Digest).Return(blob2.MetaInfo, nil)
+
+	_, err := archive.CreateTorrent(context.Background(), namespace, blob1.Digest)
+	require.NoError(err)
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, blob2.Digest)
+	require.Equal(ErrDiskBudgetExceeded, err)
+}
+
+func TestTorrentArchiveCompletedTorrentReleasesBudget(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{DiskBudget: 10})
+
+	namespace := core.TagFixture()
+	blob1 := core.SizedBlobFixture(8, 8)
+	blob2 := core.SizedBlobFixture(8, 8)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob1.Digest).Return(blob1.MetaInfo, nil)
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob2.Digest).Return(blob2.MetaInfo, nil)
+
+	tor, err := archive.CreateTorrent(context.Background(), namespace, blob1.Digest)
+	require.NoError(err)
+	require.NoError(tor.WritePiece(piecereader.NewBuffer(blob1.Content), 0))
+	require.True(tor.Complete())
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, blob2.Digest)
+	require.NoError(err)
+}
+
+func TestTorrentArchiveReserve(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{DiskBudget: 20})
+
+	namespace := core.TagFixture()
+	blob1 := core.SizedBlobFixture(8, 1)
+	blob2 := core.SizedBlobFixture(8, 1)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob1.Digest).Return(blob1.MetaInfo, nil)
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob2.Digest).Return(blob2.MetaInfo, nil)
+
+	r, err := archive.Reserve(16)
+	require.NoError(err)
+
+	// Only 4 bytes remain unreserved.
+	_, err = archive.Reserve(8)
+	require.Equal(ErrDiskBudgetExceeded, err)
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, blob1.Digest)
+	require.NoError(err)
+	_, err = archive.CreateTorrent(context.Background(), namespace, blob2.Digest)
+	require.NoError(err)
+
+	r.Release()
+
+	// 16 bytes are charged to in-flight downloads.
+	_, err = archive.Reserve(4)
+	require.NoError(err)
+}
+
+func TestTorrentArchiveGetTorrentValidation(t *testing.T) {
+	tests := []struct {
+		level ValidationLevel
+		valid bool
+	}{
+		{ValidateNone, true},
+		{ValidateLength, true},
+		{ValidateSample, false},
+		{ValidateFull, false},
+	}
+	for _, test := range tests {
+		t.Run(string(test.level), func(t *testing.T) {
+			require := require.New(t)
+
+			mocks, cleanup := newArchiveMocks(t)
+			defer cleanup()
+
+			archive := mocks.newWithConfig(Config{ValidateOnGet: test.level})
+
+			namespace := core.TagFixture()
+			blob := core.SizedBlobFixture(4, 2)
+
+			mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+
+			tor, err := archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+			require.NoError(err)
+			require.NoError(tor.WritePiece(piecereader.NewBuffer(blob.Content[:2]), 0))
+
+			// Corrupt the only complete piece.
+			f, err := mocks.cads.GetDownloadFileReadWriter(blob.Digest.Hex())
+			require.NoError(err)
+			_, err = f.Write([]byte{0, 0})
+			require.NoError(err)
+			require.NoError(f.Close())
+
+			_, err = archive.GetTorrent(context.Background(), namespace, blob.Digest)
+			if test.valid {
+				require.NoError(err)
+			} else {
+				require.Error(err)
+
+				// Invalid torrents are deleted.
+				_, err = archive.Stat(context.Background(), namespace, blob.Digest)
+				require.True(os.IsNotExist(err))
+			}
+		})
+	}
+}
+
+func TestTorrentArchiveClose(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	mi := core.MetaInfoFixture()
+	namespace := core.TagFixture()
+
+	require.NoError(archive.Close())
+	require.NoError(archive.Close())
+
+	_, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+	require.Equal(ErrClosed, err)
+
+	_, err = archive.GetTorrent(context.Background(), namespace, mi.Digest())
+	require.Equal(ErrClosed, err)
+
+	_, err = archive.Stat(context.Background(), namespace, mi.Digest())
+	require.Equal(ErrClosed, err)
+
+	require.Equal(ErrClosed, archive.DeleteTorrent(mi.Digest()))
+
+	_, err = archive.Reserve(1)
+	require.Equal(ErrClosed, err)
+}
+
+func TestTorrentArchiveCloseWaitsForInFlightOperations(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	mi := core.MetaInfoFixture()
+	namespace := core.TagFixture()
+
+	downloading := make(chan struct{})
+	release := make(chan struct{})
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).DoAndReturn(
+		func(string, core.Digest) (*core.MetaInfo, error) {
+			close(downloading)
+			<-release
+			return mi, nil
+		})
+
+	createErr := make(chan error)
+	go func() {
+		_, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+		createErr <- err
+	}()
+	<-downloading
+
+	closed := make(chan struct{})
+	go func() {
+		archive.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		require.FailNow("close returned before in-flight operation completed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(<-createErr)
+	<-closed
+}
+
+func TestTorrentArchiveCreateTorrentRejectsInconsistentMetaInfo(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	d := core.DigestFixture()
+
+	mi, err := core.DeserializeMetaInfo([]byte(fmt.Sprintf(
+		`{"Info":{"PieceLength":4,"PieceSums":[],"Name":"%s","Length":10}}`, d.Hex())))
+	require.NoError(err)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, d).Return(mi, nil)
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, d)
+	require.Error(err)
+
+	// Nothing was allocated.
+	_, err = archive.Stat(context.Background(), namespace, d)
+	require.True(os.IsNotExist(err))
+}
+
+func TestTorrentArchiveCreateTorrentCircuitBreaker(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{
+		MetaInfoCircuitBreaker: CircuitBreakerConfig{
+			FailureThreshold: 2,
+			Cooldown:         time.Minute,
+		},
+	})
+
+	namespace := core.TagFixture()
+	mi := core.MetaInfoFixture()
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(
+		nil, errors.New("some error")).Times(2)
+
+	for i := 0; i < 2; i++ {
+		_, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+		require.Error(err)
+	}
+
+	_, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+	require.Equal(ErrCircuitOpen, err)
+
+	mocks.clk.Add(time.Minute)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil)
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+	require.NoError(err)
+}
+
+func TestTorrentArchiveCreateTorrentMaxPieceCount(t *testing.T) {
+	tests := []struct {
+		desc      string
+		numPieces uint64
+		expected  error
+	}{
+		{"below limit", 3, nil},
+		{"at limit", 4, nil},
+		{"one over limit", 5, ErrTooManyPieces},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			mocks, cleanup := newArchiveMocks(t)
+			defer cleanup()
+
+			archive := mocks.newWithConfig(Config{MaxPieceCount: 4})
+
+			namespace := core.TagFixture()
+			blob := core.SizedBlobFixture(test.numPieces, 1)
+
+			mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+
+			_, err := archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+			require.Equal(test.expected, err)
+
+			if test.expected != nil {
+				// Nothing was allocated.
+				_, err = archive.Stat(context.Background(), namespace, blob.Digest)
+				require.True(os.IsNotExist(err))
+			}
+		})
+	}
+}
+
+func TestTorrentArchiveUpdateConfigMaxPieceCount(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{MaxPieceCount: 8})
+
+	namespace := core.TagFixture()
+	blob1 := core.SizedBlobFixture(6, 1)
+	blob2 := core.SizedBlobFixture(7, 1)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob1.Digest).Return(blob1.MetaInfo, nil)
+
+	_, err := archive.CreateTorrent(context.Background(), namespace, blob1.Digest)
+	require.NoError(err)
+
+	require.NoError(archive.UpdateConfig(Config{MaxPieceCount: 4}))
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob2.Digest).Return(blob2.MetaInfo, nil)
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, blob2.Digest)
+	require.Equal(ErrTooManyPieces, err)
+}
+
+func TestTorrentArchiveUpdateConfigCircuitBreakerCooldown(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{
+		MetaInfoCircuitBreaker: CircuitBreakerConfig{
+			FailureThreshold: 1,
+			Cooldown:         time.Hour,
+		},
+	})
+
+	namespace := core.TagFixture()
+	mi := core.MetaInfoFixture()
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(
+		nil, errors.New("some error"))
+
+	_, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+	require.Error(err)
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+	require.Equal(ErrCircuitOpen, err)
+
+	require.NoError(archive.UpdateConfig(Config{
+		MetaInfoCircuitBreaker: CircuitBreakerConfig{
+			FailureThreshold: 1,
+			Cooldown:         time.Minute,
+		},
+	}))
+
+	mocks.clk.Add(time.Minute)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil)
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+	require.NoError(err)
+}
+
+func TestTorrentArchiveUpdateConfigRejectsImmutableFields(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{MaxPieceCount: 4})
+
+	err := archive.UpdateConfig(Config{HeartbeatInterval: time.Hour})
+	require.Error(err)
+	require.Contains(err.Error(), "heartbeat_interval")
+
+	// Live config is unchanged.
+	require.Equal(4, archive.getConfig().MaxPieceCount)
+}
+
+func TestTorrentArchiveUpdateConfigRejectsInvalidConfig(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{MaxPieceCount: 4})
+
+	require.Error(archive.UpdateConfig(Config{ValidateOnGet: "bogus"}))
+	require.Equal(4, archive.getConfig().MaxPieceCount)
+	require.Equal(ValidateNone, archive.getConfig().ValidateOnGet)
+}
+
+func TestTorrentArchiveUpdateConfigClosed(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+	require.NoError(archive.Close())
+
+	require.Equal(ErrClosed, archive.UpdateConfig(Config{}))
+}
+
+func TestTorrentArchiveCreateTorrentCancelledMetaInfoDownload(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{
+		MetaInfoCircuitBreaker: CircuitBreakerConfig{FailureThreshold: 1},
+	})
+
+	namespace := core.TagFixture()
+	mi := core.MetaInfoFixture()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).DoAndReturn(
+		func(ctx context.Context, namespace string, d core.Digest) (*core.MetaInfo, error) {
+			cancel()
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+
+	_, err := archive.CreateTorrent(ctx, namespace, mi.Digest())
+	require.Equal(context.Canceled, err)
+
+	// Nothing was allocated.
+	_, err = archive.Stat(context.Background(), namespace, mi.Digest())
+	require.True(os.IsNotExist(err))
+
+	// Cancellation does not open the circuit.
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil)
+
+	_, err = archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+	require.NoError(err)
+}
+
+func TestTorrentArchiveCreateTorrentCancelledWhilePaused(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+	archive.PauseDownloads()
+
+	namespace := core.TagFixture()
+	mi := core.MetaInfoFixture()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := archive.CreateTorrent(ctx, namespace, mi.Digest())
+	require.Equal(context.DeadlineExceeded, err)
+}
+
+func TestTorrentArchiveCreateTorrentDeduplicatesConcurrentCalls(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	mi := core.MetaInfoFixture()
+
+	release := make(chan struct{})
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).DoAndReturn(
+		func(ctx context.Context, namespace string, d core.Digest) (*core.MetaInfo, error) {
+			<-release
+			return mi, nil
+		}).Times(1)
+
+	var wg sync.WaitGroup
+	errc := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+			errc <- err
+		}()
+	}
+
+	// Give all callers a chance to join the in-flight download.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		require.NoError(err)
+	}
+}
+
+func TestTorrentArchiveCreateTorrentRetriesAfterSharedCallCancelled(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	mi := core.MetaInfoFixture()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+
+	gomock.InOrder(
+		mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).DoAndReturn(
+			func(ctx context.Context, namespace string, d core.Digest) (*core.MetaInfo, error) {
+				close(started)
+				// Wait for the second caller to join.
+				time.Sleep(100 * time.Millisecond)
+				cancel()
+				return nil, ctx.Err()
+			}),
+		mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, mi.Digest()).Return(mi, nil),
+	)
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := archive.CreateTorrent(ctx, namespace, mi.Digest())
+		errc <- err
+	}()
+
+	<-started
+	_, err := archive.CreateTorrent(context.Background(), namespace, mi.Digest())
+	require.NoError(err)
+	require.Equal(context.Canceled, <-errc)
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"os"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/lib/torrent/storage"
+
+	"github.com/uber-go/tally"
+)
+
+// storedNamespace returns the namespace d was initialized under. Torrents
+// initialized before namespaces were recorded have an empty namespace.
+func (a *TorrentArchive) storedNamespace(d core.Digest) (string, error) {
+	var md metadata.Namespace
+	if err := a.cads.Any().GetMetadata(d.Hex(), &md); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return md.Value, nil
+}
+
+// checkNamespace returns storage.ErrNamespaceMismatch if namespace enforcement
+// is enabled and stored differs from namespace. Torrents without a stored
+// namespace match any namespace.
+func (a *TorrentArchive) checkNamespace(namespace, stored string) error {
+	if !a.getConfig().EnforceNamespace {
+		return nil
+	}
+	if stored != "" && stored != namespace {
+		a.stats.Counter("namespace_mismatch").Inc(1)
+		return storage.ErrNamespaceMismatch
+	}
+	return nil
+}
+
+// _unknownNamespace tags metrics of operations without a namespace, such as
+// deletes of torrents initialized before namespaces were recorded.
+const _unknownNamespace = "unknown"
+
+// namespaceStats returns a.stats tagged with namespace.
+func (a *TorrentArchive) namespaceStats(namespace string) tally.Scope {
+	if namespace == "" {
+		namespace = _unknownNamespace
+	}
+	return a.stats.Tagged(map[string]string{"namespace": namespace})
+}
+
+// raceLost records that initialization of a torrent under namespace found
+// state of kind already written by a concurrent initialization.
+func (a *TorrentArchive) raceLost(namespace, kind string) {
+	a.namespaceStats(namespace).Tagged(map[string]string{
+		"kind": kind,
+	}).Counter("initialize_race_lost").Inc(1)
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/tracker/metainfoclient"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestTorrentArchiveStatExposesNamespace(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	mi := core.SizedBlobFixture(4, 1).MetaInfo
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), "foo", mi.Digest()).Return(mi, nil)
+
+	tor, err := archive.CreateTorrent(context.Background(), "foo", mi.Digest())
+	require.NoError(err)
+	require.Equal("foo", tor.Stat().Namespace())
+
+	info, err := archive.Stat(context.Background(), "foo", mi.Digest())
+	require.NoError(err)
+	require.Equal("foo", info.Namespace())
+
+	tor, err = archive.GetTorrent(context.Background(), "foo", mi.Digest())
+	require.NoError(err)
+	require.Equal("foo", tor.Stat().Namespace())
+}
+
+func TestTorrentArchiveNamespaceMismatch(t *testing.T) {
+	tests := []struct {
+		desc     string
+		enforce  bool
+		expected error
+	}{
+		{"enforced", true, storage.ErrNamespaceMismatch},
+		{"not enforced", false, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			mocks, cleanup := newArchiveMocks(t)
+			defer cleanup()
+
+			archive := mocks.newWithConfig(Config{EnforceNamespace: test.enforce})
+
+			mi := core.SizedBlobFixture(4, 1).MetaInfo
+
+			mocks.metaInfoClient.EXPECT().Download(gomock.Any(), "foo", mi.Digest()).Return(mi, nil)
+
+			_, err := archive.CreateTorrent(context.Background(), "foo", mi.Digest())
+			require.NoError(err)
+
+			_, err = archive.Stat(context.Background(), "bar", mi.Digest())
+			require.Equal(test.expected, err)
+
+			_, err = archive.GetTorrent(context.Background(), "bar", mi.Digest())
+			require.Equal(test.expected, err)
+
+			_, err = archive.GetTorrentRange(context.Background(), "bar", mi.Digest(), 0, 1)
+			require.Equal(test.expected, err)
+
+			_, err = archive.CreateTorrent(context.Background(), "bar", mi.Digest())
+			require.Equal(test.expected, err)
+		})
+	}
+}
+
+func TestTorrentArchiveNamespaceMissingMatchesAny(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.newWithConfig(Config{EnforceNamespace: true})
+
+	mi := core.SizedBlobFixture(4, 1).MetaInfo
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), "foo", mi.Digest()).Return(mi, nil)
+
+	_, err := archive.CreateTorrent(context.Background(), "foo", mi.Digest())
+	require.NoError(err)
+
+	// Simulate a torrent initialized before namespaces were recorded.
+	_, err = mocks.cads.Any().SetMetadata(mi.Digest().Hex(), &metadata.Namespace{})
+	require.NoError(err)
+
+	info, err := archive.Stat(context.Background(), "bar", mi.Digest())
+	require.NoError(err)
+	require.Equal("", info.Namespace())
+
+	_, err = archive.GetTorrent(context.Background(), "bar", mi.Digest())
+	require.NoError(err)
+}
+
+func TestTorrentArchiveNamespaceMetrics(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	stats := tally.NewTestScope("", nil)
+	archive := NewTorrentArchive(Config{}, stats, mocks.clk, mocks.cads, mocks.metaInfoClient)
+
+	blob := core.SizedBlobFixture(4, 1)
+	missing := core.DigestFixture()
+
+	mocks.metaInfoClient.EXPECT().Download(
+		gomock.Any(), "foo", blob.Digest).Return(blob.MetaInfo, nil)
+	mocks.metaInfoClient.EXPECT().Download(
+		gomock.Any(), "bar", missing).Return(nil, metainfoclient.ErrNotFound)
+
+	_, err := archive.CreateTorrent(context.Background(), "foo", blob.Digest)
+	require.NoError(err)
+
+	_, err = archive.CreateTorrent(context.Background(), "bar", missing)
+	require.Equal(storage.ErrNotFound, err)
+
+	require.NoError(archive.DeleteTorrent(blob.Digest))
+
+	snapshot := stats.Snapshot()
+	counters := snapshot.Counters()
+	timers := snapshot.Timers()
+	require.Equal(int64(1), counters["metainfo_not_found+module=agenttorrentarchive,namespace=bar"].Value())
+	require.Equal(int64(1), counters["deleted+module=agenttorrentarchive,namespace=foo"].Value())
+	require.Len(timers["file_initialization+module=agenttorrentarchive,namespace=foo"].Values(), 1)
+	require.Len(timers["metainfo_download+module=agenttorrentarchive,namespace=foo"].Values(), 1)
+}
+
+func TestTorrentArchiveInitializeRaceLostMetrics(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	stats := tally.NewTestScope("", nil)
+	archive := NewTorrentArchive(Config{}, stats, mocks.clk, mocks.cads, mocks.metaInfoClient)
+
+	mi := core.SizedBlobFixture(4, 1).MetaInfo
+
+	// Simulate a concurrent initialization which created the file and wrote
+	// the metainfo, but not yet the namespace.
+	require.NoError(mocks.cads.CreateDownloadFile(mi.Digest().Hex(), mi.Length()))
+	_, err := mocks.cads.Download().SetMetadata(mi.Digest().Hex(), &metadata.TorrentMeta{MetaInfo: mi})
+	require.NoError(err)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), "foo", mi.Digest()).Return(mi, nil)
+
+	_, err = archive.initialize(context.Background(), "foo", mi.Digest())
+	require.NoError(err)
+
+	counters := stats.Snapshot().Counters()
+	prefix := "initialize_race_lost+kind="
+	tags := ",module=agenttorrentarchive,namespace=foo"
+	require.Equal(int64(1), counters[prefix+"file"+tags].Value())
+	require.Equal(int64(1), counters[prefix+"metainfo"+tags].Value())
+	require.NotContains(counters, prefix+"namespace"+tags)
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/uber/kraken/lib/torrent/storage"
+)
+
+// ErrRangeInvalid occurs when a piece range is out of bounds for a torrent.
+var ErrRangeInvalid = errors.New("invalid piece range")
+
+// rangeTorrent is a view of a Torrent restricted to pieces [start, end).
+// Piece indices are unchanged from the underlying Torrent, however only pieces
+// in the range may be read or written, and completeness only considers pieces
+// in the range.
+type rangeTorrent struct {
+	*Torrent
+	start int
+	end   int
+}
+
+func newRangeTorrent(t *Torrent, start, end int) (*rangeTorrent, error) {
+	if start < 0 || end > t.NumPieces() || start >= end {
+		return nil, ErrRangeInvalid
+	}
+	return &rangeTorrent{t, start, end}, nil
+}
+
+func (t *rangeTorrent) inRange(pi int) bool {
+	return pi >= t.start && pi < t.end
+}
+
+// Complete returns true if all pieces in the range are complete.
+func (t *rangeTorrent) Complete() bool {
+	if t.Torrent.Complete() {
+		return true
+	}
+	for i := t.start; i < t.end; i++ {
+		if !t.pieces[i].complete() {
+			return false
+		}
+	}
+	return true
+}
+
+// BytesDownloaded returns the number of bytes downloaded in the range.
+func (t *rangeTorrent) BytesDownloaded() int64 {
+	var n int64
+	for i := t.start; i < t.end; i++ {
+		if t.pieces[i].complete() {
+			n += t.PieceLength(i)
+		}
+	}
+	return n
+}
+
+// MissingPieces returns the indices of all missing pieces in the range.
+func (t *rangeTorrent) MissingPieces() []int {
+	var missing []int
+	for i := t.start; i < t.end; i++ {
+		if !t.pieces[i].complete() {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// WritePiece writes data to piece pi, which must be in the range.
+func (t *rangeTorrent) WritePiece(src storage.PieceReader, pi int) error {
+	if !t.inRange(pi) {
+		return ErrRangeInvalid
+	}
+	return t.Torrent.WritePiece(src, pi)
+}
+
+// GetPieceReader returns a reader for piece pi, which must be in the range.
+func (t *rangeTorrent) GetPieceReader(pi int) (storage.PieceReader, error) {
+	if !t.inRange(pi) {
+		return nil, ErrRangeInvalid
+	}
+	return t.Torrent.GetPieceReader(pi)
+}
+
+func (t *rangeTorrent) String() string {
+	return fmt.Sprintf(
+		"torrent(name=%s, hash=%s, pieces=[%d, %d))",
+		t.Digest().Hex(), t.InfoHash().Hex(), t.start, t.end)
+}
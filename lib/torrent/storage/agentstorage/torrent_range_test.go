@@ -0,0 +1,85 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTorrentArchiveGetTorrentRange(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(8, 2)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+
+	_, err := archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+	require.NoError(err)
+
+	tor, err := archive.GetTorrentRange(context.Background(), namespace, blob.Digest, 1, 3)
+	require.NoError(err)
+
+	require.Equal([]int{1, 2}, tor.MissingPieces())
+	require.False(tor.Complete())
+
+	require.Equal(ErrRangeInvalid, tor.WritePiece(piecereader.NewBuffer(blob.Content[0:2]), 0))
+	_, err = tor.GetPieceReader(3)
+	require.Equal(ErrRangeInvalid, err)
+
+	require.NoError(tor.WritePiece(piecereader.NewBuffer(blob.Content[2:4]), 1))
+	require.NoError(tor.WritePiece(piecereader.NewBuffer(blob.Content[4:6]), 2))
+
+	require.True(tor.Complete())
+	require.Equal(int64(4), tor.BytesDownloaded())
+	require.Empty(tor.MissingPieces())
+
+	// The underlying torrent is still incomplete.
+	full, err := archive.GetTorrent(context.Background(), namespace, blob.Digest)
+	require.NoError(err)
+	require.False(full.Complete())
+	require.Equal([]int{0, 3}, full.MissingPieces())
+}
+
+func TestTorrentArchiveGetTorrentRangeInvalid(t *testing.T) {
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(8, 2)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+
+	_, err := archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+	require.NoError(t, err)
+
+	for _, r := range [][2]int{{-1, 2}, {0, 5}, {2, 2}, {3, 1}} {
+		_, err := archive.GetTorrentRange(context.Background(), namespace, blob.Digest, r[0], r[1])
+		require.Equal(t, ErrRangeInvalid, err)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+
+	"github.com/uber/kraken/core"
+)
+
+// ValidationLevel defines how thoroughly a Torrent's on-disk state is checked
+// against its metainfo before it is served.
+type ValidationLevel string
+
+const (
+	// ValidateNone trusts on-disk state.
+	ValidateNone ValidationLevel = "none"
+
+	// ValidateLength checks that the file length matches the metainfo. Near-free.
+	ValidateLength ValidationLevel = "length"
+
+	// ValidateSample checks the file length and hashes a single randomly
+	// selected complete piece. Cheap.
+	ValidateSample ValidationLevel = "sample"
+
+	// ValidateFull checks the file length and hashes every complete piece.
+	// Expensive for large blobs.
+	ValidateFull ValidationLevel = "full"
+)
+
+// validate checks t's on-disk state per level, checking pieces in the given order.
+func (t *Torrent) validate(level ValidationLevel, order VerifyOrder) error {
+	switch level {
+	case ValidateNone:
+		return nil
+	case ValidateLength, ValidateSample, ValidateFull:
+	default:
+		return fmt.Errorf("invalid validation level: %q", string(level))
+	}
+
+	info, err := t.cads.Any().GetFileStat(t.Digest().Hex())
+	if err != nil {
+		return fmt.Errorf("stat: %s", err)
+	}
+	if info.Size() != t.Length() {
+		return fmt.Errorf(
+			"file length %d does not match metainfo length %d", info.Size(), t.Length())
+	}
+
+	var complete []int
+	for i, p := range t.pieces {
+		if p.complete() {
+			complete = append(complete, i)
+		}
+	}
+	if len(complete) == 0 {
+		return nil
+	}
+
+	switch level {
+	case ValidateSample:
+		return t.verifyPieces([]int{complete[rand.Intn(len(complete))]}, order)
+	case ValidateFull:
+		return t.verifyPieces(complete, order)
+	}
+	return nil
+}
+
+// verifyPiece hashes the on-disk content of piece pi and compares it against
+// the metainfo piece sum.
+func (t *Torrent) verifyPiece(pi int) error {
+	r, err := t.GetPieceReader(pi)
+	if err != nil {
+		return fmt.Errorf("get piece reader %d: %s", pi, err)
+	}
+	defer r.Close()
+
+	h := core.PieceHash()
+	if _, err := io.Copy(h, r); err != nil {
+		return fmt.Errorf("read piece %d: %s", pi, err)
+	}
+	if h.Sum32() != t.metaInfo.GetPieceSum(pi) {
+		return fmt.Errorf("invalid piece sum for piece %d", pi)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/uber-go/tally"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/log"
+)
+
+const _lastVerifiedSuffix = "_last_verified"
+
+// _maxVerificationSeries bounds the number of blobs EmitVerificationSeries
+// reports, regardless of how many blobs the selector matches.
+const _maxVerificationSeries = 1000
+
+func init() {
+	metadata.Register(regexp.MustCompile(_lastVerifiedSuffix), lastVerifiedMetadataFactory{})
+}
+
+type lastVerifiedMetadataFactory struct{}
+
+func (m lastVerifiedMetadataFactory) Create(suffix string) metadata.Metadata {
+	return &lastVerifiedMetadata{}
+}
+
+// lastVerifiedMetadata stores the time and result of the most recent Verify
+// of a blob.
+type lastVerifiedMetadata struct {
+	time time.Time
+	ok   bool
+}
+
+func (m *lastVerifiedMetadata) GetSuffix() string {
+	return _lastVerifiedSuffix
+}
+
+func (m *lastVerifiedMetadata) Movable() bool {
+	return true
+}
+
+func (m *lastVerifiedMetadata) Serialize() ([]byte, error) {
+	b := make([]byte, 9)
+	binary.PutVarint(b[:8], m.time.Unix())
+	if m.ok {
+		b[8] = 1
+	}
+	return b, nil
+}
+
+func (m *lastVerifiedMetadata) Deserialize(b []byte) error {
+	if len(b) != 9 {
+		return fmt.Errorf("unmarshal last verified: invalid length %d", len(b))
+	}
+	i, n := binary.Varint(b[:8])
+	if n <= 0 {
+		return fmt.Errorf("unmarshal last verified: %s", b)
+	}
+	m.time = time.Unix(i, 0)
+	m.ok = b[8] == 1
+	return nil
+}
+
+// recordVerification persists the result of verifying d. Failures to persist
+// are logged, since they only affect reporting.
+func (a *TorrentArchive) recordVerification(d core.Digest, ok bool) {
+	md := &lastVerifiedMetadata{a.clk.Now(), ok}
+	if _, err := a.cads.Any().SetMetadata(d.Hex(), md); err != nil {
+		log.With("name", d.Hex()).Errorf("Error persisting verification result: %s", err)
+	}
+}
+
+// BlobSelector restricts per-blob metrics to a bounded subset of blobs.
+type BlobSelector func(d core.Digest) bool
+
+// EmitVerificationSeries reports, for each blob on disk matching selector which
+// has been verified via Verify, the seconds since its last verification and
+// whether it passed, tagged by blob. Per-blob series are high cardinality, so
+// selector should match only a small set of blobs which need fine-grained
+// visibility; at most 1000 blobs are reported regardless. Series are emitted
+// once per call rather than continuously. Returns the number of blobs
+// reported.
+func (a *TorrentArchive) EmitVerificationSeries(
+	stats tally.Scope, selector BlobSelector) (int, error) {
+
+	if err := a.enter(); err != nil {
+		return 0, err
+	}
+	defer a.exit()
+
+	names, err := a.cads.Any().ListNames()
+	if err != nil {
+		return 0, fmt.Errorf("list names: %s", err)
+	}
+	now := a.clk.Now()
+	var n int
+	for _, name := range names {
+		if n == _maxVerificationSeries {
+			log.Warnf("Verification series truncated to %d blobs", _maxVerificationSeries)
+			break
+		}
+		d, err := core.NewSHA256DigestFromHex(name)
+		if err != nil || !selector(d) {
+			continue
+		}
+		var md lastVerifiedMetadata
+		if err := a.cads.Any().GetMetadata(name, &md); err != nil {
+			if !os.IsNotExist(err) {
+				log.With("name", name).Errorf("Error getting verification result: %s", err)
+			}
+			continue
+		}
+		var ok float64
+		if md.ok {
+			ok = 1
+		}
+		s := stats.Tagged(map[string]string{"blob": name})
+		s.Gauge("verification_age_seconds").Update(now.Sub(md.time).Seconds())
+		s.Gauge("verification_ok").Update(ok)
+		n++
+	}
+	return n, nil
+}
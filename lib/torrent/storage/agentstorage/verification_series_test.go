@@ -0,0 +1,129 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agentstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestLastVerifiedMetadataSerialization(t *testing.T) {
+	for _, ok := range []bool{true, false} {
+		require := require.New(t)
+
+		md := &lastVerifiedMetadata{time.Unix(1000, 0), ok}
+		b, err := md.Serialize()
+		require.NoError(err)
+
+		var result lastVerifiedMetadata
+		require.NoError(result.Deserialize(b))
+		require.Equal(md.time.Unix(), result.time.Unix())
+		require.Equal(ok, result.ok)
+	}
+}
+
+func TestTorrentArchiveEmitVerificationSeries(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	blob1 := core.SizedBlobFixture(4, 2)
+	blob2 := core.SizedBlobFixture(4, 2)
+	blob3 := core.SizedBlobFixture(4, 2)
+
+	for _, blob := range []*core.BlobFixture{blob1, blob2, blob3} {
+		mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+
+		tor, err := archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+		require.NoError(err)
+		require.NoError(tor.WritePiece(piecereader.NewBuffer(blob.Content[:2]), 0))
+	}
+
+	// blob3 is selected but never verified.
+	require.NoError(archive.Verify(namespace, blob1.Digest))
+	require.NoError(archive.Verify(namespace, blob2.Digest))
+
+	mocks.clk.Add(90 * time.Second)
+
+	stats := tally.NewTestScope("", nil)
+	n, err := archive.EmitVerificationSeries(stats, func(d core.Digest) bool {
+		return d == blob1.Digest || d == blob3.Digest
+	})
+	require.NoError(err)
+	require.Equal(1, n)
+
+	gauges := stats.Snapshot().Gauges()
+	require.Len(gauges, 2)
+	for _, g := range gauges {
+		require.Equal(map[string]string{"blob": blob1.Digest.Hex()}, g.Tags())
+		switch g.Name() {
+		case "verification_age_seconds":
+			require.Equal(float64(90), g.Value())
+		case "verification_ok":
+			require.Equal(float64(1), g.Value())
+		default:
+			t.Fatalf("unexpected gauge %s", g.Name())
+		}
+	}
+}
+
+func TestTorrentArchiveEmitVerificationSeriesFailedVerify(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(4, 2)
+
+	mocks.metaInfoClient.EXPECT().Download(gomock.Any(), namespace, blob.Digest).Return(blob.MetaInfo, nil)
+
+	tor, err := archive.CreateTorrent(context.Background(), namespace, blob.Digest)
+	require.NoError(err)
+	require.NoError(tor.WritePiece(piecereader.NewBuffer(blob.Content[:2]), 0))
+
+	// Corrupt piece 0.
+	f, err := mocks.cads.GetDownloadFileReadWriter(blob.Digest.Hex())
+	require.NoError(err)
+	_, err = f.WriteAt([]byte{0, 0}, 0)
+	require.NoError(err)
+	require.NoError(f.Close())
+
+	require.Error(archive.Verify(namespace, blob.Digest))
+
+	stats := tally.NewTestScope("", nil)
+	n, err := archive.EmitVerificationSeries(stats, func(core.Digest) bool { return true })
+	require.NoError(err)
+	require.Equal(1, n)
+
+	for _, g := range stats.Snapshot().Gauges() {
+		if g.Name() == "verification_ok" {
+			require.Equal(float64(0), g.Value())
+		}
+	}
+}
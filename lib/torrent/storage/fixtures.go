@@ -23,5 +23,5 @@ import (
 func TorrentInfoFixture(size, pieceLength uint64) *TorrentInfo {
 	mi := core.SizedBlobFixture(size, pieceLength).MetaInfo
 	bitfield := bitsetutil.FromBools(randutil.Bools(mi.NumPieces())...)
-	return NewTorrentInfo(mi, bitfield)
+	return NewTorrentInfo("", mi, bitfield)
 }
@@ -0,0 +1,39 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package originstorage
+
+import (
+	"errors"
+
+	"github.com/uber/kraken/lib/torrent/storage"
+)
+
+// Name is the name origin TorrentArchives are registered with.
+const Name = "origin"
+
+func init() {
+	storage.RegisterArchive(Name, factory{})
+}
+
+type factory struct{}
+
+// Create ignores config, since origin TorrentArchives are not configurable.
+func (f factory) Create(
+	config interface{}, deps storage.ArchiveDeps) (storage.TorrentArchive, error) {
+
+	if deps.CAStore == nil || deps.BlobRefresher == nil {
+		return nil, errors.New("ca store and blob refresher are required")
+	}
+	return NewTorrentArchive(deps.CAStore, deps.BlobRefresher), nil
+}
@@ -55,7 +55,7 @@ func (t *Torrent) Digest() core.Digest {
 
 // Stat returns the TorrentInfo for t.
 func (t *Torrent) Stat() *storage.TorrentInfo {
-	return storage.NewTorrentInfo(t.metaInfo, t.Bitfield())
+	return storage.NewTorrentInfo("", t.metaInfo, t.Bitfield())
 }
 
 // InfoHash returns the torrent metainfo hash.
@@ -75,7 +75,7 @@ func (t *Torrent) Length() int64 {
 
 // PieceLength returns the length of piece pi.
 func (t *Torrent) PieceLength(pi int) int64 {
-	return t.metaInfo.GetPieceLength(pi)
+	return t.metaInfo.PieceSize(pi)
 }
 
 // MaxPieceLength returns the longest piece length of the torrent.
@@ -138,5 +138,5 @@ func (t *Torrent) MissingPieces() []int {
 // getFileOffset calculates the offset in the torrent file given piece index.
 // Assumes pi is a valid piece index.
 func (t *Torrent) getFileOffset(pi int) int64 {
-	return t.metaInfo.PieceLength() * int64(pi)
+	return t.metaInfo.PieceOffset(pi)
 }
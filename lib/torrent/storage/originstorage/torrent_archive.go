@@ -14,6 +14,7 @@
 package originstorage
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -59,24 +60,30 @@ func (a *TorrentArchive) getMetaInfo(namespace string, d core.Digest) (*core.Met
 // Stat returns TorrentInfo for given digest. If the file does not exist,
 // attempts to re-fetch the file from the storae backend configured for namespace
 // in a background goroutine.
-func (a *TorrentArchive) Stat(namespace string, d core.Digest) (*storage.TorrentInfo, error) {
+func (a *TorrentArchive) Stat(
+	ctx context.Context, namespace string, d core.Digest) (*storage.TorrentInfo, error) {
+
 	mi, err := a.getMetaInfo(namespace, d)
 	if err != nil {
 		return nil, err
 	}
 	bitfield := bitset.New(uint(mi.NumPieces())).Complement()
-	return storage.NewTorrentInfo(mi, bitfield), nil
+	return storage.NewTorrentInfo(namespace, mi, bitfield), nil
 }
 
 // CreateTorrent is not supported.
-func (a *TorrentArchive) CreateTorrent(namespace string, d core.Digest) (storage.Torrent, error) {
+func (a *TorrentArchive) CreateTorrent(
+	ctx context.Context, namespace string, d core.Digest) (storage.Torrent, error) {
+
 	return nil, errors.New("not supported for origin")
 }
 
 // GetTorrent returns a Torrent for an existing file on disk. If the file does
 // not exist, attempts to re-fetch the file from the storae backend configured
 // for namespace in a background goroutine, and returns os.ErrNotExist.
-func (a *TorrentArchive) GetTorrent(namespace string, d core.Digest) (storage.Torrent, error) {
+func (a *TorrentArchive) GetTorrent(
+	ctx context.Context, namespace string, d core.Digest) (storage.Torrent, error) {
+
 	mi, err := a.getMetaInfo(namespace, d)
 	if err != nil {
 		return nil, err
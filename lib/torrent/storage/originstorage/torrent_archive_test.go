@@ -14,6 +14,7 @@
 package originstorage
 
 import (
+	"context"
 	"os"
 	"testing"
 	"time"
@@ -80,11 +81,11 @@ func TestTorrentArchiveStatNoExistTriggersRefresh(t *testing.T) {
 	mocks.backendClient.EXPECT().Download(namespace, blob.Digest.Hex(), mockutil.MatchWriter(blob.Content))
 
 	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
-		_, err := archive.Stat(namespace, blob.Digest)
+		_, err := archive.Stat(context.Background(), namespace, blob.Digest)
 		return err == nil
 	}))
 
-	info, err := archive.Stat(namespace, blob.Digest)
+	info, err := archive.Stat(context.Background(), namespace, blob.Digest)
 	require.NoError(err)
 	require.Equal(blob.Digest, info.Digest())
 	require.Equal(blob.MetaInfo.InfoHash(), info.InfoHash())
@@ -107,11 +108,11 @@ func TestTorrentArchiveGetTorrentNoExistTriggersRefresh(t *testing.T) {
 	mocks.backendClient.EXPECT().Download(namespace, blob.Digest.Hex(), mockutil.MatchWriter(blob.Content))
 
 	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
-		_, err := archive.GetTorrent(namespace, blob.Digest)
+		_, err := archive.GetTorrent(context.Background(), namespace, blob.Digest)
 		return err == nil
 	}))
 
-	tor, err := archive.GetTorrent(namespace, blob.Digest)
+	tor, err := archive.GetTorrent(context.Background(), namespace, blob.Digest)
 	require.NoError(err)
 	require.Equal(blob.Digest, tor.Digest())
 	require.Equal(blob.MetaInfo.InfoHash(), tor.InfoHash())
@@ -134,7 +135,7 @@ func TestTorrentArchiveDeleteTorrent(t *testing.T) {
 	mocks.backendClient.EXPECT().Download(namespace, blob.Digest.Hex(), mockutil.MatchWriter(blob.Content))
 
 	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
-		_, err := archive.Stat(namespace, blob.Digest)
+		_, err := archive.Stat(context.Background(), namespace, blob.Digest)
 		return err == nil
 	}))
 
@@ -0,0 +1,64 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package storage
+
+import (
+	"fmt"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+
+	"github.com/uber/kraken/lib/blobrefresh"
+	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/tracker/metainfoclient"
+)
+
+var _archiveFactories = make(map[string]ArchiveFactory)
+
+// ArchiveDeps defines the runtime dependencies available to ArchiveFactory.
+// Fields not applicable to the running binary are nil, e.g. CAStore on agents
+// and CADownloadStore on origins.
+type ArchiveDeps struct {
+	Stats           tally.Scope
+	Clock           clock.Clock
+	CADownloadStore *store.CADownloadStore
+	CAStore         *store.CAStore
+	MetaInfoClient  metainfoclient.Client
+	BlobRefresher   *blobrefresh.Refresher
+}
+
+// ArchiveFactory creates TorrentArchives given raw config, as unmarshalled
+// from yaml, or the archive's own config type.
+type ArchiveFactory interface {
+	Create(config interface{}, deps ArchiveDeps) (TorrentArchive, error)
+}
+
+// RegisterArchive registers factory with corresponding archive name.
+// RegisterArchive is not thread-safe and should be called from init functions.
+func RegisterArchive(name string, factory ArchiveFactory) {
+	_archiveFactories[name] = factory
+}
+
+// NewArchive creates a TorrentArchive using the factory registered with name.
+func NewArchive(name string, config interface{}, deps ArchiveDeps) (TorrentArchive, error) {
+	factory, ok := _archiveFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("no torrent archive defined with name %s", name)
+	}
+	a, err := factory.Create(config, deps)
+	if err != nil {
+		return nil, fmt.Errorf("create %s torrent archive: %s", name, err)
+	}
+	return a, nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testArchiveFactory struct {
+	archive TorrentArchive
+	err     error
+	config  interface{}
+}
+
+func (f *testArchiveFactory) Create(config interface{}, deps ArchiveDeps) (TorrentArchive, error) {
+	f.config = config
+	return f.archive, f.err
+}
+
+func TestNewArchive(t *testing.T) {
+	require := require.New(t)
+
+	f := &testArchiveFactory{}
+	RegisterArchive("test_new_archive", f)
+
+	_, err := NewArchive("test_new_archive", "some config", ArchiveDeps{})
+	require.NoError(err)
+	require.Equal("some config", f.config)
+}
+
+func TestNewArchiveUnknownName(t *testing.T) {
+	_, err := NewArchive("bogus", nil, ArchiveDeps{})
+	require.Error(t, err)
+}
+
+func TestNewArchiveFactoryError(t *testing.T) {
+	RegisterArchive("test_factory_error", &testArchiveFactory{err: errors.New("some error")})
+
+	_, err := NewArchive("test_factory_error", nil, ArchiveDeps{})
+	require.Error(t, err)
+}
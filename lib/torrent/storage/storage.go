@@ -14,8 +14,10 @@
 package storage
 
 import (
+	"context"
 	"errors"
 	"io"
+	"time"
 
 	"github.com/uber/kraken/core"
 
@@ -25,6 +27,10 @@ import (
 // ErrNotFound occurs when TorrentArchive cannot found a torrent.
 var ErrNotFound = errors.New("torrent not found")
 
+// ErrNamespaceMismatch occurs when a torrent is accessed under a different
+// namespace than it was initialized under.
+var ErrNamespaceMismatch = errors.New("torrent namespace mismatch")
+
 // ErrPieceComplete occurs when Torrent cannot write a piece because it is already
 // complete.
 var ErrPieceComplete = errors.New("piece is already complete")
@@ -56,10 +62,29 @@ type Torrent interface {
 	GetPieceReader(piece int) (PieceReader, error)
 }
 
+// WriteBackpressure is implemented by TorrentArchives which track piece writes
+// in flight to their disk.
+type WriteBackpressure interface {
+	WriteQueue() *WriteQueue
+}
+
+// BlacklistEntry is a peer which may not connect for a torrent until ExpiresAt.
+type BlacklistEntry struct {
+	PeerID    core.PeerID
+	ExpiresAt time.Time
+}
+
+// BlacklistStore is implemented by TorrentArchives which persist the peer
+// blacklists of torrents, such that they survive restarts.
+type BlacklistStore interface {
+	SaveBlacklist(d core.Digest, entries []BlacklistEntry) error
+	LoadBlacklist(d core.Digest) ([]BlacklistEntry, error)
+}
+
 // TorrentArchive creates and open torrent file
 type TorrentArchive interface {
-	Stat(namespace string, d core.Digest) (*TorrentInfo, error)
-	CreateTorrent(namespace string, d core.Digest) (Torrent, error)
-	GetTorrent(namespace string, d core.Digest) (Torrent, error)
+	Stat(ctx context.Context, namespace string, d core.Digest) (*TorrentInfo, error)
+	CreateTorrent(ctx context.Context, namespace string, d core.Digest) (Torrent, error)
+	GetTorrent(ctx context.Context, namespace string, d core.Digest) (Torrent, error)
 	DeleteTorrent(d core.Digest) error
 }
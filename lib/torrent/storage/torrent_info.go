@@ -14,6 +14,8 @@
 package storage
 
 import (
+	"time"
+
 	"github.com/uber/kraken/core"
 
 	"github.com/willf/bitset"
@@ -21,22 +23,58 @@ import (
 
 // TorrentInfo encapsulates read-only torrent information.
 type TorrentInfo struct {
+	namespace         string
 	metainfo          *core.MetaInfo
 	bitfield          *bitset.BitSet
 	percentDownloaded int
+	bytesDownloaded   int64
+	downloadRate      float64
 }
 
-// NewTorrentInfo creates a new TorrentInfo.
-func NewTorrentInfo(mi *core.MetaInfo, bitfield *bitset.BitSet) *TorrentInfo {
+// NewTorrentInfo creates a new TorrentInfo. namespace may be empty if the
+// namespace of the torrent is unknown.
+func NewTorrentInfo(namespace string, mi *core.MetaInfo, bitfield *bitset.BitSet) *TorrentInfo {
 	numComplete := bitfield.Count()
 	downloaded := int(float64(numComplete) / float64(mi.NumPieces()) * 100)
-	return &TorrentInfo{mi, bitfield, downloaded}
+	var bytesDownloaded int64
+	for i, ok := bitfield.NextSet(0); ok; i, ok = bitfield.NextSet(i + 1) {
+		bytesDownloaded += mi.PieceSize(int(i))
+	}
+	return &TorrentInfo{
+		namespace:         namespace,
+		metainfo:          mi,
+		bitfield:          bitfield,
+		percentDownloaded: downloaded,
+		bytesDownloaded:   bytesDownloaded,
+	}
+}
+
+// WithDownloadRate returns a copy of i with the download rate set to rate, in
+// bytes per second. Storage has no notion of time, so the rate is supplied by
+// whoever is downloading the torrent.
+func (i *TorrentInfo) WithDownloadRate(rate float64) *TorrentInfo {
+	c := *i
+	c.downloadRate = rate
+	return &c
+}
+
+// WithBitfield returns a copy of i with the bitfield replaced by b.
+func (i *TorrentInfo) WithBitfield(b *bitset.BitSet) *TorrentInfo {
+	c := NewTorrentInfo(i.namespace, i.metainfo, b)
+	c.downloadRate = i.downloadRate
+	return c
 }
 
 func (i *TorrentInfo) String() string {
 	return i.InfoHash().Hex()
 }
 
+// Namespace returns the namespace the torrent was initialized under, or empty
+// if unknown.
+func (i *TorrentInfo) Namespace() string {
+	return i.namespace
+}
+
 // Digest returns the torrent's blob digest.
 func (i *TorrentInfo) Digest() core.Digest {
 	return i.metainfo.Digest()
@@ -58,6 +96,38 @@ func (i *TorrentInfo) PercentDownloaded() int {
 	return i.percentDownloaded
 }
 
+// BytesDownloaded returns the number of bytes of complete pieces.
+func (i *TorrentInfo) BytesDownloaded() int64 {
+	return i.bytesDownloaded
+}
+
+// CompletionRatio returns the fraction of pieces which are complete, between
+// 0 and 1.
+func (i *TorrentInfo) CompletionRatio() float64 {
+	if i.metainfo.NumPieces() == 0 {
+		return 1
+	}
+	return float64(i.bitfield.Count()) / float64(i.metainfo.NumPieces())
+}
+
+// DownloadRate returns the download rate in bytes per second, or 0 if unknown.
+func (i *TorrentInfo) DownloadRate() float64 {
+	return i.downloadRate
+}
+
+// ETA returns the estimated duration until the torrent is complete at the
+// current download rate. Returns false if the download rate is unknown.
+func (i *TorrentInfo) ETA() (time.Duration, bool) {
+	remaining := i.metainfo.Length() - i.bytesDownloaded
+	if remaining <= 0 {
+		return 0, true
+	}
+	if i.downloadRate <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(remaining) / i.downloadRate * float64(time.Second)), true
+}
+
 // Bitfield returns the piece status bitfield of the torrent. Note, this is a
 // snapshot and may be stale information.
 func (i *TorrentInfo) Bitfield() *bitset.BitSet {
@@ -16,6 +16,7 @@ package storage
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/utils/bitsetutil"
@@ -38,7 +39,7 @@ func TestTorrentInfoPercentDownloaded(t *testing.T) {
 		t.Run(fmt.Sprintf("%d%%", test.expected), func(t *testing.T) {
 			require := require.New(t)
 
-			info := NewTorrentInfo(mi, test.bitfield)
+			info := NewTorrentInfo("", mi, test.bitfield)
 			require.Equal(test.expected, info.PercentDownloaded())
 			require.Equal(test.bitfield, info.Bitfield())
 			require.Equal(int64(25), info.MaxPieceLength())
@@ -48,3 +49,32 @@ func TestTorrentInfoPercentDownloaded(t *testing.T) {
 		})
 	}
 }
+
+func TestTorrentInfoProgress(t *testing.T) {
+	require := require.New(t)
+
+	// Pieces of length 4, 4 and 2.
+	mi := core.SizedBlobFixture(10, 4).MetaInfo
+
+	info := NewTorrentInfo("", mi, bitsetutil.FromBools(true, false, true))
+	require.Equal(int64(6), info.BytesDownloaded())
+	require.InDelta(2.0/3.0, info.CompletionRatio(), 0.0001)
+
+	_, ok := info.ETA()
+	require.False(ok)
+
+	info = info.WithDownloadRate(2)
+	require.Equal(float64(2), info.DownloadRate())
+	eta, ok := info.ETA()
+	require.True(ok)
+	require.Equal(2*time.Second, eta)
+}
+
+func TestTorrentInfoETAComplete(t *testing.T) {
+	mi := core.SizedBlobFixture(10, 4).MetaInfo
+
+	info := NewTorrentInfo("", mi, bitsetutil.FromBools(true, true, true))
+	eta, ok := info.ETA()
+	require.True(t, ok)
+	require.Equal(t, time.Duration(0), eta)
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package storage
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/uber-go/tally"
+)
+
+// WriteQueueConfig defines when piece writes are considered backed up.
+type WriteQueueConfig struct {
+	Disabled bool `yaml:"disabled"`
+
+	// LowWatermark is the number of piece writes in flight below which piece
+	// requests are not throttled.
+	LowWatermark int `yaml:"low_watermark"`
+
+	// HighWatermark is the number of piece writes in flight at which piece
+	// requests are throttled to a single pending request per peer.
+	HighWatermark int `yaml:"high_watermark"`
+}
+
+func (c WriteQueueConfig) applyDefaults() WriteQueueConfig {
+	if c.LowWatermark == 0 {
+		c.LowWatermark = 16
+	}
+	if c.HighWatermark == 0 {
+		c.HighWatermark = 64
+	}
+	if c.HighWatermark <= c.LowWatermark {
+		c.HighWatermark = c.LowWatermark + 1
+	}
+	return c
+}
+
+// WriteQueue tracks piece writes in flight to a disk shared by many torrents.
+// When writes back up, e.g. due to a slow disk, piece requesters use Throttle
+// to stop requesting pieces faster than they can be written, which would
+// otherwise buffer received pieces in memory.
+type WriteQueue struct {
+	config atomic.Value // WriteQueueConfig
+	stats  tally.Scope
+	depth  int64
+}
+
+// NewWriteQueue creates a new WriteQueue.
+func NewWriteQueue(config WriteQueueConfig, stats tally.Scope) *WriteQueue {
+	q := &WriteQueue{stats: stats}
+	q.config.Store(config.applyDefaults())
+	return q
+}
+
+// Update applies config to q.
+func (q *WriteQueue) Update(config WriteQueueConfig) {
+	q.config.Store(config.applyDefaults())
+}
+
+func (q *WriteQueue) getConfig() WriteQueueConfig {
+	return q.config.Load().(WriteQueueConfig)
+}
+
+// Begin records the start of a piece write. The returned function must be
+// called once the write completes.
+func (q *WriteQueue) Begin() (done func()) {
+	start := time.Now()
+	q.stats.Gauge("piece_write_queue_depth").Update(float64(atomic.AddInt64(&q.depth, 1)))
+	return func() {
+		q.stats.Gauge("piece_write_queue_depth").Update(float64(atomic.AddInt64(&q.depth, -1)))
+		q.stats.Timer("piece_write_latency").Record(time.Since(start))
+	}
+}
+
+// Depth returns the number of piece writes in flight.
+func (q *WriteQueue) Depth() int {
+	return int(atomic.LoadInt64(&q.depth))
+}
+
+// Throttle scales limit, the maximum number of pending piece requests per
+// peer, down to 1 as the number of writes in flight rises from the low to the
+// high watermark. Peers may always have one pending request, so downloads
+// progress at the rate of the disk.
+func (q *WriteQueue) Throttle(limit int) int {
+	config := q.getConfig()
+	if config.Disabled || limit <= 1 {
+		return limit
+	}
+	depth := q.Depth()
+	var throttled int
+	switch {
+	case depth <= config.LowWatermark:
+		return limit
+	case depth >= config.HighWatermark:
+		throttled = 1
+	default:
+		backlog := depth - config.LowWatermark
+		span := config.HighWatermark - config.LowWatermark
+		throttled = limit - (limit-1)*backlog/span
+	}
+	if throttled < limit {
+		q.stats.Counter("piece_requests_throttled").Inc(1)
+	}
+	return throttled
+}
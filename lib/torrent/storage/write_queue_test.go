@@ -0,0 +1,78 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestWriteQueueTracksDepth(t *testing.T) {
+	require := require.New(t)
+
+	q := NewWriteQueue(WriteQueueConfig{}, tally.NoopScope)
+
+	done1 := q.Begin()
+	done2 := q.Begin()
+	require.Equal(2, q.Depth())
+
+	done1()
+	require.Equal(1, q.Depth())
+	done2()
+	require.Equal(0, q.Depth())
+}
+
+func TestWriteQueueThrottle(t *testing.T) {
+	config := WriteQueueConfig{
+		LowWatermark:  2,
+		HighWatermark: 6,
+	}
+	tests := []struct {
+		depth    int
+		expected int
+	}{
+		{0, 5},
+		{2, 5},
+		{3, 4},
+		{4, 3},
+		{5, 2},
+		{6, 1},
+		{10, 1},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("depth=%d", test.depth), func(t *testing.T) {
+			q := NewWriteQueue(config, tally.NoopScope)
+			for i := 0; i < test.depth; i++ {
+				q.Begin()
+			}
+			require.Equal(t, test.expected, q.Throttle(5))
+		})
+	}
+}
+
+func TestWriteQueueThrottleDisabled(t *testing.T) {
+	require := require.New(t)
+
+	q := NewWriteQueue(WriteQueueConfig{LowWatermark: 1, HighWatermark: 2}, tally.NoopScope)
+	for i := 0; i < 10; i++ {
+		q.Begin()
+	}
+	require.Equal(1, q.Throttle(5))
+
+	q.Update(WriteQueueConfig{Disabled: true})
+	require.Equal(5, q.Throttle(5))
+}
@@ -59,6 +59,20 @@ func (mr *MockManagerMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockManager)(nil).Close))
 }
 
+// DiscardDead mocks base method
+func (m *MockManager) DiscardDead(arg0 persistedretry.Task) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DiscardDead", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DiscardDead indicates an expected call of DiscardDead
+func (mr *MockManagerMockRecorder) DiscardDead(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DiscardDead", reflect.TypeOf((*MockManager)(nil).DiscardDead), arg0)
+}
+
 // Find mocks base method
 func (m *MockManager) Find(arg0 interface{}) ([]persistedretry.Task, error) {
 	m.ctrl.T.Helper()
@@ -74,6 +88,35 @@ func (mr *MockManagerMockRecorder) Find(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Find", reflect.TypeOf((*MockManager)(nil).Find), arg0)
 }
 
+// GetDead mocks base method
+func (m *MockManager) GetDead() ([]persistedretry.Task, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDead")
+	ret0, _ := ret[0].([]persistedretry.Task)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDead indicates an expected call of GetDead
+func (mr *MockManagerMockRecorder) GetDead() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDead", reflect.TypeOf((*MockManager)(nil).GetDead))
+}
+
+// RetryDead mocks base method
+func (m *MockManager) RetryDead(arg0 persistedretry.Task) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RetryDead", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RetryDead indicates an expected call of RetryDead
+func (mr *MockManagerMockRecorder) RetryDead(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetryDead", reflect.TypeOf((*MockManager)(nil).RetryDead), arg0)
+}
+
 // SyncExec mocks base method
 func (m *MockManager) SyncExec(arg0 persistedretry.Task) error {
 	m.ctrl.T.Helper()
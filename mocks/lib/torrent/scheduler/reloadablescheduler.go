@@ -8,7 +8,9 @@ import (
 	gomock "github.com/golang/mock/gomock"
 	core "github.com/uber/kraken/core"
 	scheduler "github.com/uber/kraken/lib/torrent/scheduler"
+	conn "github.com/uber/kraken/lib/torrent/scheduler/conn"
 	connstate "github.com/uber/kraken/lib/torrent/scheduler/connstate"
+	storage "github.com/uber/kraken/lib/torrent/storage"
 	reflect "reflect"
 )
 
@@ -35,6 +37,20 @@ func (m *MockReloadableScheduler) EXPECT() *MockReloadableSchedulerMockRecorder
 	return m.recorder
 }
 
+// BandwidthLimits mocks base method
+func (m *MockReloadableScheduler) BandwidthLimits() conn.BandwidthLimits {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BandwidthLimits")
+	ret0, _ := ret[0].(conn.BandwidthLimits)
+	return ret0
+}
+
+// BandwidthLimits indicates an expected call of BandwidthLimits
+func (mr *MockReloadableSchedulerMockRecorder) BandwidthLimits() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BandwidthLimits", reflect.TypeOf((*MockReloadableScheduler)(nil).BandwidthLimits))
+}
+
 // BlacklistSnapshot mocks base method
 func (m *MockReloadableScheduler) BlacklistSnapshot() ([]connstate.BlacklistedConn, error) {
 	m.ctrl.T.Helper()
@@ -64,6 +80,34 @@ func (mr *MockReloadableSchedulerMockRecorder) Download(arg0, arg1 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Download", reflect.TypeOf((*MockReloadableScheduler)(nil).Download), arg0, arg1)
 }
 
+// DownloadWithPriority mocks base method
+func (m *MockReloadableScheduler) DownloadWithPriority(arg0 string, arg1 core.Digest, arg2 scheduler.Priority) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DownloadWithPriority", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DownloadWithPriority indicates an expected call of DownloadWithPriority
+func (mr *MockReloadableSchedulerMockRecorder) DownloadWithPriority(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadWithPriority", reflect.TypeOf((*MockReloadableScheduler)(nil).DownloadWithPriority), arg0, arg1, arg2)
+}
+
+// PauseTorrent mocks base method
+func (m *MockReloadableScheduler) PauseTorrent(arg0 core.Digest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PauseTorrent", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PauseTorrent indicates an expected call of PauseTorrent
+func (mr *MockReloadableSchedulerMockRecorder) PauseTorrent(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PauseTorrent", reflect.TypeOf((*MockReloadableScheduler)(nil).PauseTorrent), arg0)
+}
+
 // Probe mocks base method
 func (m *MockReloadableScheduler) Probe() error {
 	m.ctrl.T.Helper()
@@ -78,6 +122,21 @@ func (mr *MockReloadableSchedulerMockRecorder) Probe() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Probe", reflect.TypeOf((*MockReloadableScheduler)(nil).Probe))
 }
 
+// Progress mocks base method
+func (m *MockReloadableScheduler) Progress(arg0 core.Digest) (*storage.TorrentInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Progress", arg0)
+	ret0, _ := ret[0].(*storage.TorrentInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Progress indicates an expected call of Progress
+func (mr *MockReloadableSchedulerMockRecorder) Progress(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Progress", reflect.TypeOf((*MockReloadableScheduler)(nil).Progress), arg0)
+}
+
 // Reload mocks base method
 func (m *MockReloadableScheduler) Reload(arg0 scheduler.Config) {
 	m.ctrl.T.Helper()
@@ -104,6 +163,34 @@ func (mr *MockReloadableSchedulerMockRecorder) RemoveTorrent(arg0 interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveTorrent", reflect.TypeOf((*MockReloadableScheduler)(nil).RemoveTorrent), arg0)
 }
 
+// ResumeTorrent mocks base method
+func (m *MockReloadableScheduler) ResumeTorrent(arg0 core.Digest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResumeTorrent", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResumeTorrent indicates an expected call of ResumeTorrent
+func (mr *MockReloadableSchedulerMockRecorder) ResumeTorrent(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResumeTorrent", reflect.TypeOf((*MockReloadableScheduler)(nil).ResumeTorrent), arg0)
+}
+
+// SetBandwidthLimits mocks base method
+func (m *MockReloadableScheduler) SetBandwidthLimits(arg0 conn.BandwidthLimits) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetBandwidthLimits", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetBandwidthLimits indicates an expected call of SetBandwidthLimits
+func (mr *MockReloadableSchedulerMockRecorder) SetBandwidthLimits(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetBandwidthLimits", reflect.TypeOf((*MockReloadableScheduler)(nil).SetBandwidthLimits), arg0)
+}
+
 // Stop mocks base method
 func (m *MockReloadableScheduler) Stop() {
 	m.ctrl.T.Helper()
@@ -7,7 +7,10 @@ package mockscheduler
 import (
 	gomock "github.com/golang/mock/gomock"
 	core "github.com/uber/kraken/core"
+	scheduler "github.com/uber/kraken/lib/torrent/scheduler"
+	conn "github.com/uber/kraken/lib/torrent/scheduler/conn"
 	connstate "github.com/uber/kraken/lib/torrent/scheduler/connstate"
+	storage "github.com/uber/kraken/lib/torrent/storage"
 	reflect "reflect"
 )
 
@@ -34,6 +37,20 @@ func (m *MockScheduler) EXPECT() *MockSchedulerMockRecorder {
 	return m.recorder
 }
 
+// BandwidthLimits mocks base method
+func (m *MockScheduler) BandwidthLimits() conn.BandwidthLimits {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BandwidthLimits")
+	ret0, _ := ret[0].(conn.BandwidthLimits)
+	return ret0
+}
+
+// BandwidthLimits indicates an expected call of BandwidthLimits
+func (mr *MockSchedulerMockRecorder) BandwidthLimits() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BandwidthLimits", reflect.TypeOf((*MockScheduler)(nil).BandwidthLimits))
+}
+
 // BlacklistSnapshot mocks base method
 func (m *MockScheduler) BlacklistSnapshot() ([]connstate.BlacklistedConn, error) {
 	m.ctrl.T.Helper()
@@ -63,6 +80,34 @@ func (mr *MockSchedulerMockRecorder) Download(arg0, arg1 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Download", reflect.TypeOf((*MockScheduler)(nil).Download), arg0, arg1)
 }
 
+// DownloadWithPriority mocks base method
+func (m *MockScheduler) DownloadWithPriority(arg0 string, arg1 core.Digest, arg2 scheduler.Priority) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DownloadWithPriority", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DownloadWithPriority indicates an expected call of DownloadWithPriority
+func (mr *MockSchedulerMockRecorder) DownloadWithPriority(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadWithPriority", reflect.TypeOf((*MockScheduler)(nil).DownloadWithPriority), arg0, arg1, arg2)
+}
+
+// PauseTorrent mocks base method
+func (m *MockScheduler) PauseTorrent(arg0 core.Digest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PauseTorrent", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PauseTorrent indicates an expected call of PauseTorrent
+func (mr *MockSchedulerMockRecorder) PauseTorrent(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PauseTorrent", reflect.TypeOf((*MockScheduler)(nil).PauseTorrent), arg0)
+}
+
 // Probe mocks base method
 func (m *MockScheduler) Probe() error {
 	m.ctrl.T.Helper()
@@ -77,6 +122,21 @@ func (mr *MockSchedulerMockRecorder) Probe() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Probe", reflect.TypeOf((*MockScheduler)(nil).Probe))
 }
 
+// Progress mocks base method
+func (m *MockScheduler) Progress(arg0 core.Digest) (*storage.TorrentInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Progress", arg0)
+	ret0, _ := ret[0].(*storage.TorrentInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Progress indicates an expected call of Progress
+func (mr *MockSchedulerMockRecorder) Progress(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Progress", reflect.TypeOf((*MockScheduler)(nil).Progress), arg0)
+}
+
 // RemoveTorrent mocks base method
 func (m *MockScheduler) RemoveTorrent(arg0 core.Digest) error {
 	m.ctrl.T.Helper()
@@ -91,6 +151,34 @@ func (mr *MockSchedulerMockRecorder) RemoveTorrent(arg0 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveTorrent", reflect.TypeOf((*MockScheduler)(nil).RemoveTorrent), arg0)
 }
 
+// ResumeTorrent mocks base method
+func (m *MockScheduler) ResumeTorrent(arg0 core.Digest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResumeTorrent", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResumeTorrent indicates an expected call of ResumeTorrent
+func (mr *MockSchedulerMockRecorder) ResumeTorrent(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResumeTorrent", reflect.TypeOf((*MockScheduler)(nil).ResumeTorrent), arg0)
+}
+
+// SetBandwidthLimits mocks base method
+func (m *MockScheduler) SetBandwidthLimits(arg0 conn.BandwidthLimits) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetBandwidthLimits", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetBandwidthLimits indicates an expected call of SetBandwidthLimits
+func (mr *MockSchedulerMockRecorder) SetBandwidthLimits(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetBandwidthLimits", reflect.TypeOf((*MockScheduler)(nil).SetBandwidthLimits), arg0)
+}
+
 // Stop mocks base method
 func (m *MockScheduler) Stop() {
 	m.ctrl.T.Helper()
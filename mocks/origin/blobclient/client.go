@@ -77,6 +77,20 @@ func (mr *MockClientMockRecorder) DownloadBlob(arg0, arg1, arg2 interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadBlob", reflect.TypeOf((*MockClient)(nil).DownloadBlob), arg0, arg1, arg2)
 }
 
+// DownloadBlobRange mocks base method
+func (m *MockClient) DownloadBlobRange(arg0 string, arg1 core.Digest, arg2, arg3 int64, arg4 io.Writer) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DownloadBlobRange", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DownloadBlobRange indicates an expected call of DownloadBlobRange
+func (mr *MockClientMockRecorder) DownloadBlobRange(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadBlobRange", reflect.TypeOf((*MockClient)(nil).DownloadBlobRange), arg0, arg1, arg2, arg3, arg4)
+}
+
 // DuplicateUploadBlob mocks base method
 func (m *MockClient) DuplicateUploadBlob(arg0 string, arg1 core.Digest, arg2 io.Reader, arg3 time.Duration) error {
 	m.ctrl.T.Helper()
@@ -193,6 +207,21 @@ func (mr *MockClientMockRecorder) Stat(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stat", reflect.TypeOf((*MockClient)(nil).Stat), arg0, arg1)
 }
 
+// StatBatch mocks base method
+func (m *MockClient) StatBatch(arg0 string, arg1 []core.Digest) (map[core.Digest]*core.BlobInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StatBatch", arg0, arg1)
+	ret0, _ := ret[0].(map[core.Digest]*core.BlobInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StatBatch indicates an expected call of StatBatch
+func (mr *MockClientMockRecorder) StatBatch(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StatBatch", reflect.TypeOf((*MockClient)(nil).StatBatch), arg0, arg1)
+}
+
 // StatLocal mocks base method
 func (m *MockClient) StatLocal(arg0 string, arg1 core.Digest) (*core.BlobInfo, error) {
 	m.ctrl.T.Helper()
@@ -48,6 +48,20 @@ func (mr *MockClusterClientMockRecorder) DownloadBlob(arg0, arg1, arg2 interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadBlob", reflect.TypeOf((*MockClusterClient)(nil).DownloadBlob), arg0, arg1, arg2)
 }
 
+// DownloadBlobRange mocks base method
+func (m *MockClusterClient) DownloadBlobRange(arg0 string, arg1 core.Digest, arg2, arg3 int64, arg4 io.Writer) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DownloadBlobRange", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DownloadBlobRange indicates an expected call of DownloadBlobRange
+func (mr *MockClusterClientMockRecorder) DownloadBlobRange(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadBlobRange", reflect.TypeOf((*MockClusterClient)(nil).DownloadBlobRange), arg0, arg1, arg2, arg3, arg4)
+}
+
 // GetMetaInfo mocks base method
 func (m *MockClusterClient) GetMetaInfo(arg0 string, arg1 core.Digest) (*core.MetaInfo, error) {
 	m.ctrl.T.Helper()
@@ -121,6 +135,21 @@ func (mr *MockClusterClientMockRecorder) Stat(arg0, arg1 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stat", reflect.TypeOf((*MockClusterClient)(nil).Stat), arg0, arg1)
 }
 
+// StatBatch mocks base method
+func (m *MockClusterClient) StatBatch(arg0 string, arg1 []core.Digest) (map[core.Digest]*core.BlobInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StatBatch", arg0, arg1)
+	ret0, _ := ret[0].(map[core.Digest]*core.BlobInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StatBatch indicates an expected call of StatBatch
+func (mr *MockClusterClientMockRecorder) StatBatch(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StatBatch", reflect.TypeOf((*MockClusterClient)(nil).StatBatch), arg0, arg1)
+}
+
 // UploadBlob mocks base method
 func (m *MockClusterClient) UploadBlob(arg0 string, arg1 core.Digest, arg2 io.Reader) error {
 	m.ctrl.T.Helper()
@@ -7,6 +7,7 @@ package mockannounceclient
 import (
 	gomock "github.com/golang/mock/gomock"
 	core "github.com/uber/kraken/core"
+	announceclient "github.com/uber/kraken/tracker/announceclient"
 	reflect "reflect"
 	time "time"
 )
@@ -35,9 +36,9 @@ func (m *MockClient) EXPECT() *MockClientMockRecorder {
 }
 
 // Announce mocks base method
-func (m *MockClient) Announce(arg0 core.Digest, arg1 core.InfoHash, arg2 bool, arg3 int) ([]*core.PeerInfo, time.Duration, error) {
+func (m *MockClient) Announce(arg0 string, arg1 core.Digest, arg2 core.InfoHash, arg3 bool, arg4 int) ([]*core.PeerInfo, time.Duration, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Announce", arg0, arg1, arg2, arg3)
+	ret := m.ctrl.Call(m, "Announce", arg0, arg1, arg2, arg3, arg4)
 	ret0, _ := ret[0].([]*core.PeerInfo)
 	ret1, _ := ret[1].(time.Duration)
 	ret2, _ := ret[2].(error)
@@ -45,7 +46,22 @@ func (m *MockClient) Announce(arg0 core.Digest, arg1 core.InfoHash, arg2 bool, a
 }
 
 // Announce indicates an expected call of Announce
-func (mr *MockClientMockRecorder) Announce(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+func (mr *MockClientMockRecorder) Announce(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Announce", reflect.TypeOf((*MockClient)(nil).Announce), arg0, arg1, arg2, arg3)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Announce", reflect.TypeOf((*MockClient)(nil).Announce), arg0, arg1, arg2, arg3, arg4)
+}
+
+// BatchAnnounce mocks base method
+func (m *MockClient) BatchAnnounce(arg0 []announceclient.BatchItem) ([]announceclient.BatchResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BatchAnnounce", arg0)
+	ret0, _ := ret[0].([]announceclient.BatchResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// BatchAnnounce indicates an expected call of BatchAnnounce
+func (mr *MockClientMockRecorder) BatchAnnounce(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BatchAnnounce", reflect.TypeOf((*MockClient)(nil).BatchAnnounce), arg0)
 }
@@ -22,6 +22,7 @@ upstream registry-backend {
 
 server {
   listen {{.port}};
+  {{if .ipv6}}listen [::]:{{.port}};{{end}}
 
   {{range .allowed_cidrs}}
     allow {{.}};
@@ -52,6 +52,8 @@ http {
   proxy_set_header  X-Forwarded-Proto $http_x_forwarded_proto;
   proxy_set_header  X-Real-IP         $remote_addr;
   proxy_set_header  X-Original-URI    $request_uri;
+  proxy_set_header  X-SSL-Client-Verify $ssl_client_verify;
+  proxy_set_header  X-SSL-Client-S-DN   $ssl_client_s_dn;
 
   # Overwrites http with $scheme if Location header is set to http by upstream.
   proxy_redirect ~^http://[^:]+:\d+(/.+)$ $1;
@@ -25,6 +25,7 @@ upstream build-index {
 
 server {
   listen {{.port}};
+  {{if .ipv6}}listen [::]:{{.port}};{{end}}
 
   {{.client_verification}}
 
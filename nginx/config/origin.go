@@ -17,6 +17,7 @@ package config
 const OriginTemplate = `
 server {
   listen {{.port}};
+  {{if .ipv6}}listen [::]:{{.port}};{{end}}
 
   {{.client_verification}}
 
@@ -26,6 +26,7 @@ upstream registry-override {
 {{range .ports}}
 server {
   listen {{.}};
+  {{if $.ipv6}}listen [::]:{{.}};{{end}}
 
   {{$.client_verification}}
 
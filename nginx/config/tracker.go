@@ -23,6 +23,7 @@ upstream tracker {
 
 server {
   listen {{.port}};
+  {{if .ipv6}}listen [::]:{{.port}};{{end}}
 
   {{.client_verification}}
 
@@ -38,6 +39,9 @@ server {
 
     proxy_cache         metainfo;
     proxy_cache_methods GET;
+    # Cache per client identity, such that cached metainfo is never served to
+    # clients the tracker would reject.
+    proxy_cache_key     $scheme$proxy_host$request_uri$http_authorization$ssl_client_s_dn;
     proxy_cache_valid   200 5m;
     proxy_cache_valid   any 1s;
     proxy_cache_lock    on;
@@ -48,17 +48,21 @@ type Config struct {
 	CacheDir string `yaml:"cache_dir"`
 	LogDir   string `yaml:"log_dir"`
 
+	// IPv6 additionally listens on IPv6 addresses.
+	IPv6 bool `yaml:"ipv6"`
+
 	tls httputil.TLSConfig
 }
 
 func (c *Config) inject(params map[string]interface{}) error {
-	for _, s := range []string{"cache_dir", "log_dir"} {
+	for _, s := range []string{"cache_dir", "log_dir", "ipv6"} {
 		if _, ok := params[s]; ok {
 			return fmt.Errorf("invalid params: %s is reserved", s)
 		}
 	}
 	params["cache_dir"] = c.CacheDir
 	params["log_dir"] = c.LogDir
+	params["ipv6"] = c.IPv6
 	return nil
 }
 
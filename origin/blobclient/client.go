@@ -14,6 +14,7 @@
 package blobclient
 
 import (
+	"bytes"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
@@ -42,6 +43,7 @@ type Client interface {
 
 	Stat(namespace string, d core.Digest) (*core.BlobInfo, error)
 	StatLocal(namespace string, d core.Digest) (*core.BlobInfo, error)
+	StatBatch(namespace string, ds []core.Digest) (map[core.Digest]*core.BlobInfo, error)
 
 	GetMetaInfo(namespace string, d core.Digest) (*core.MetaInfo, error)
 	OverwriteMetaInfo(d core.Digest, pieceLength int64) error
@@ -50,6 +52,7 @@ type Client interface {
 	DuplicateUploadBlob(namespace string, d core.Digest, blob io.Reader, delay time.Duration) error
 
 	DownloadBlob(namespace string, d core.Digest, dst io.Writer) error
+	DownloadBlobRange(namespace string, d core.Digest, start, end int64, dst io.Writer) error
 
 	ReplicateToRemote(namespace string, d core.Digest, remoteDNS string) error
 
@@ -60,9 +63,10 @@ type Client interface {
 
 // HTTPClient defines the Client implementation.
 type HTTPClient struct {
-	addr      string
-	chunkSize uint64
-	tls       *tls.Config
+	addr       string
+	chunkSize  uint64
+	maxResumes int
+	tls        *tls.Config
 }
 
 // Option allows setting optional HTTPClient parameters.
@@ -73,6 +77,13 @@ func WithChunkSize(s uint64) Option {
 	return func(c *HTTPClient) { c.chunkSize = s }
 }
 
+// WithMaxUploadResumes configures the number of times an HTTPClient resumes
+// an upload from the offset committed by the server after a chunk fails, e.g.
+// due to a network error. Set to 0 to fail uploads on the first error.
+func WithMaxUploadResumes(n int) Option {
+	return func(c *HTTPClient) { c.maxResumes = n }
+}
+
 // WithTLS configures an HTTPClient with tls configuration.
 func WithTLS(tls *tls.Config) Option {
 	return func(c *HTTPClient) { c.tls = tls }
@@ -81,8 +92,9 @@ func WithTLS(tls *tls.Config) Option {
 // New returns a new HTTPClient scoped to addr.
 func New(addr string, opts ...Option) *HTTPClient {
 	c := &HTTPClient{
-		addr:      addr,
-		chunkSize: 32 * memsize.MB,
+		addr:       addr,
+		chunkSize:  32 * memsize.MB,
+		maxResumes: 5,
 	}
 	for _, opt := range opts {
 		opt(c)
@@ -123,6 +135,63 @@ func (c *HTTPClient) StatLocal(namespace string, d core.Digest) (*core.BlobInfo,
 	return c.stat(namespace, d, true)
 }
 
+// MaxStatBatchSize is the maximum number of digests in a single batch stat
+// request. StatBatch splits larger batches into several requests.
+const MaxStatBatchSize = 1000
+
+// StatBatchRequest defines the body of a batch stat request.
+type StatBatchRequest struct {
+	Namespace string        `json:"namespace"`
+	Digests   []core.Digest `json:"digests"`
+	Local     bool          `json:"local"`
+}
+
+// StatBatchResult is the result of a batch stat request for a single digest.
+type StatBatchResult struct {
+	Digest core.Digest `json:"digest"`
+	Exists bool        `json:"exists"`
+	Size   int64       `json:"size"`
+}
+
+// StatBatch returns blob info for each of ds which exists, either on the
+// origin or in the storage backend configured for namespace, in as few round
+// trips as possible. Digests which do not exist are omitted from the result.
+func (c *HTTPClient) StatBatch(
+	namespace string, ds []core.Digest) (map[core.Digest]*core.BlobInfo, error) {
+
+	result := make(map[core.Digest]*core.BlobInfo)
+	for start := 0; start < len(ds); start += MaxStatBatchSize {
+		end := start + MaxStatBatchSize
+		if end > len(ds) {
+			end = len(ds)
+		}
+		b, err := json.Marshal(StatBatchRequest{Namespace: namespace, Digests: ds[start:end]})
+		if err != nil {
+			return nil, fmt.Errorf("json marshal: %s", err)
+		}
+		r, err := httputil.Post(
+			fmt.Sprintf("http://%s/blobs/exists", c.addr),
+			httputil.SendBody(bytes.NewReader(b)),
+			httputil.SendTimeout(time.Minute),
+			httputil.SendTLS(c.tls))
+		if err != nil {
+			return nil, err
+		}
+		var results []StatBatchResult
+		err = json.NewDecoder(r.Body).Decode(&results)
+		r.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("json decode: %s", err)
+		}
+		for _, res := range results {
+			if res.Exists {
+				result[res.Digest] = core.NewBlobInfo(res.Size)
+			}
+		}
+	}
+	return result, nil
+}
+
 func (c *HTTPClient) stat(namespace string, d core.Digest, local bool) (*core.BlobInfo, error) {
 	u := fmt.Sprintf(
 		"http://%s/internal/namespace/%s/blobs/%s",
@@ -167,14 +236,14 @@ func (c *HTTPClient) DeleteBlob(d core.Digest) error {
 // TransferBlob is an internal API which does not replicate the blob.
 func (c *HTTPClient) TransferBlob(d core.Digest, blob io.Reader) error {
 	tc := newTransferClient(c.addr, c.tls)
-	return runChunkedUpload(tc, d, blob, int64(c.chunkSize))
+	return runChunkedUpload(tc, d, blob, int64(c.chunkSize), c.maxResumes)
 }
 
 // UploadBlob uploads and replicates blob to the origin cluster, asynchronously
 // backing the blob up to the remote storage configured for namespace.
 func (c *HTTPClient) UploadBlob(namespace string, d core.Digest, blob io.Reader) error {
 	uc := newUploadClient(c.addr, namespace, _publicUpload, 0, c.tls)
-	return runChunkedUpload(uc, d, blob, int64(c.chunkSize))
+	return runChunkedUpload(uc, d, blob, int64(c.chunkSize), c.maxResumes)
 }
 
 // DuplicateUploadBlob duplicates an blob upload request, which will attempt to
@@ -183,7 +252,7 @@ func (c *HTTPClient) DuplicateUploadBlob(
 	namespace string, d core.Digest, blob io.Reader, delay time.Duration) error {
 
 	uc := newUploadClient(c.addr, namespace, _duplicateUpload, delay, c.tls)
-	return runChunkedUpload(uc, d, blob, int64(c.chunkSize))
+	return runChunkedUpload(uc, d, blob, int64(c.chunkSize), c.maxResumes)
 }
 
 // DownloadBlob downloads blob for d. If the blob of d is not available yet
@@ -204,6 +273,31 @@ func (c *HTTPClient) DownloadBlob(namespace string, d core.Digest, dst io.Writer
 	return nil
 }
 
+// DownloadBlobRange downloads bytes [start, end) of the blob for d. Returns the
+// same errors as DownloadBlob.
+func (c *HTTPClient) DownloadBlobRange(
+	namespace string, d core.Digest, start, end int64, dst io.Writer) error {
+
+	if start < 0 || end <= start {
+		return fmt.Errorf("invalid range [%d, %d)", start, end)
+	}
+	r, err := httputil.Get(
+		fmt.Sprintf("http://%s/namespace/%s/blobs/%s", c.addr, url.PathEscape(namespace), d),
+		httputil.SendHeaders(map[string]string{
+			"Range": fmt.Sprintf("bytes=%d-%d", start, end-1),
+		}),
+		httputil.SendAcceptedCodes(http.StatusPartialContent),
+		httputil.SendTLS(c.tls))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if _, err := io.Copy(dst, r.Body); err != nil {
+		return fmt.Errorf("copy body: %s", err)
+	}
+	return nil
+}
+
 // ReplicateToRemote replicates the blob of d to a remote origin cluster. If the
 // blob of d is not available yet, returns 202 httputil.StatusError, indicating
 // that the request should be retried later.
@@ -80,8 +80,10 @@ func (r *clientResolver) Resolve(d core.Digest) ([]Client, error) {
 type ClusterClient interface {
 	UploadBlob(namespace string, d core.Digest, blob io.Reader) error
 	DownloadBlob(namespace string, d core.Digest, dst io.Writer) error
+	DownloadBlobRange(namespace string, d core.Digest, start, end int64, dst io.Writer) error
 	GetMetaInfo(namespace string, d core.Digest) (*core.MetaInfo, error)
 	Stat(namespace string, d core.Digest) (*core.BlobInfo, error)
+	StatBatch(namespace string, ds []core.Digest) (map[core.Digest]*core.BlobInfo, error)
 	OverwriteMetaInfo(d core.Digest, pieceLength int64) error
 	Owners(d core.Digest) ([]core.PeerContext, error)
 	ReplicateToRemote(namespace string, d core.Digest, remoteDNS string) error
@@ -164,6 +166,32 @@ func (c *clusterClient) Stat(namespace string, d core.Digest) (bi *core.BlobInfo
 	return bi, err
 }
 
+// StatBatch checks availability of many blobs in the cluster. Since origins
+// fall back to the storage backend for blobs they lack, any origin can answer
+// for all of ds, so the whole batch is sent to the owners of the first digest
+// instead of resolving the owners of every digest.
+func (c *clusterClient) StatBatch(
+	namespace string, ds []core.Digest) (result map[core.Digest]*core.BlobInfo, err error) {
+
+	if len(ds) == 0 {
+		return make(map[core.Digest]*core.BlobInfo), nil
+	}
+	clients, err := c.resolver.Resolve(ds[0])
+	if err != nil {
+		return nil, fmt.Errorf("resolve clients: %s", err)
+	}
+
+	shuffle(clients)
+	for _, client := range clients {
+		result, err = client.StatBatch(namespace, ds)
+		if err != nil {
+			continue
+		}
+		break
+	}
+	return result, err
+}
+
 // OverwriteMetaInfo overwrites existing metainfo for d with new metainfo configured
 // with pieceLength on every origin server. Returns error if any origin was unable
 // to overwrite metainfo. Primarly intended for benchmarking purposes.
@@ -192,6 +220,29 @@ func (c *clusterClient) DownloadBlob(namespace string, d core.Digest, dst io.Wri
 	return err
 }
 
+// DownloadBlobRange downloads bytes [start, end) of the blob for d. Unlike
+// DownloadBlob, does not poll: if the blob is not available yet, returns a 202
+// httputil.StatusError.
+func (c *clusterClient) DownloadBlobRange(
+	namespace string, d core.Digest, start, end int64, dst io.Writer) error {
+
+	clients, err := c.resolver.Resolve(d)
+	if err != nil {
+		return fmt.Errorf("resolve clients: %s", err)
+	}
+	for _, client := range clients {
+		err = client.DownloadBlobRange(namespace, d, start, end, dst)
+		if httputil.IsNetworkError(err) {
+			continue
+		}
+		break
+	}
+	if httputil.IsNotFound(err) {
+		err = ErrBlobNotFound
+	}
+	return err
+}
+
 // Owners returns the origin peers which own d.
 func (c *clusterClient) Owners(d core.Digest) ([]core.PeerContext, error) {
 	clients, err := c.resolver.Resolve(d)
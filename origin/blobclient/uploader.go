@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"time"
 
@@ -32,21 +33,31 @@ type uploader interface {
 	start(d core.Digest) (uid string, err error)
 	patch(d core.Digest, uid string, start, stop int64, chunk io.Reader) error
 	commit(d core.Digest, uid string) error
+
+	// offset returns the number of bytes of upload uid committed by the
+	// server, from which the upload may be resumed.
+	offset(d core.Digest, uid string) (int64, error)
 }
 
-func runChunkedUpload(u uploader, d core.Digest, blob io.Reader, chunkSize int64) error {
-	if err := runChunkedUploadHelper(u, d, blob, chunkSize); err != nil && !httputil.IsConflict(err) {
+func runChunkedUpload(
+	u uploader, d core.Digest, blob io.Reader, chunkSize int64, maxResumes int) error {
+
+	err := runChunkedUploadHelper(u, d, blob, chunkSize, maxResumes)
+	if err != nil && !httputil.IsConflict(err) {
 		return err
 	}
 	return nil
 }
 
-func runChunkedUploadHelper(u uploader, d core.Digest, blob io.Reader, chunkSize int64) error {
+func runChunkedUploadHelper(
+	u uploader, d core.Digest, blob io.Reader, chunkSize int64, maxResumes int) error {
+
 	uid, err := u.start(d)
 	if err != nil {
 		return err
 	}
 	var pos int64
+	var resumes int
 	buf := make([]byte, chunkSize)
 	for {
 		n, err := blob.Read(buf)
@@ -56,16 +67,68 @@ func runChunkedUploadHelper(u uploader, d core.Digest, blob io.Reader, chunkSize
 			}
 			return fmt.Errorf("read blob: %s", err)
 		}
-		chunk := bytes.NewReader(buf[:n])
 		stop := pos + int64(n)
-		if err := u.patch(d, uid, pos, stop, chunk); err != nil {
-			return err
+		// Chunks which fail mid-flight are resumed from the offset the server
+		// committed, which must fall within the buffered chunk.
+		start := pos
+		for {
+			err := u.patch(d, uid, start, stop, bytes.NewReader(buf[start-pos:n]))
+			if err == nil {
+				break
+			}
+			if !resumable(err) || resumes >= maxResumes {
+				return err
+			}
+			resumes++
+			offset, oerr := u.offset(d, uid)
+			if oerr != nil {
+				return fmt.Errorf("%s (get upload offset: %s)", err, oerr)
+			}
+			if offset < pos || offset > stop {
+				return fmt.Errorf(
+					"%s (cannot resume from offset %d outside chunk [%d, %d))", err, offset, pos, stop)
+			}
+			if offset == stop {
+				break
+			}
+			start = offset
 		}
 		pos = stop
 	}
 	return u.commit(d, uid)
 }
 
+// resumable returns true if a chunk which failed with err may have been
+// partially received, such that the upload should resume from the offset
+// committed by the server.
+func resumable(err error) bool {
+	if httputil.IsNetworkError(err) {
+		return true
+	}
+	if serr, ok := err.(httputil.StatusError); ok {
+		return serr.Status == http.StatusRequestedRangeNotSatisfiable || serr.Status >= 500
+	}
+	return false
+}
+
+// uploadStatus mirrors the status of an upload returned by origins.
+type uploadStatus struct {
+	Offset int64 `json:"offset"`
+}
+
+func getUploadOffset(url string, tls *tls.Config) (int64, error) {
+	r, err := httputil.Get(url, httputil.SendTimeout(15*time.Second), httputil.SendTLS(tls))
+	if err != nil {
+		return 0, err
+	}
+	defer r.Body.Close()
+	var status uploadStatus
+	if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+		return 0, fmt.Errorf("json: %s", err)
+	}
+	return status.Offset, nil
+}
+
 // transferClient executes chunked uploads for internal blob transfers.
 type transferClient struct {
 	addr string
@@ -103,6 +166,11 @@ func (c *transferClient) patch(
 	return err
 }
 
+func (c *transferClient) offset(d core.Digest, uid string) (int64, error) {
+	return getUploadOffset(
+		fmt.Sprintf("http://%s/internal/blobs/%s/uploads/%s", c.addr, d, uid), c.tls)
+}
+
 func (c *transferClient) commit(d core.Digest, uid string) error {
 	_, err := httputil.Put(
 		fmt.Sprintf("http://%s/internal/blobs/%s/uploads/%s", c.addr, d, uid),
@@ -162,6 +230,13 @@ func (c *uploadClient) patch(
 	return err
 }
 
+func (c *uploadClient) offset(d core.Digest, uid string) (int64, error) {
+	return getUploadOffset(
+		fmt.Sprintf("http://%s/namespace/%s/blobs/%s/uploads/%s",
+			c.addr, url.PathEscape(c.namespace), d, uid),
+		c.tls)
+}
+
 // DuplicateCommitUploadRequest defines HTTP request body.
 type DuplicateCommitUploadRequest struct {
 	Delay time.Duration `yaml:"delay"`
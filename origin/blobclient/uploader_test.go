@@ -0,0 +1,134 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobclient
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/httputil"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUploader commits chunks to an in-memory buffer. failures are consumed by
+// successive patches: each failing patch commits the given number of bytes of
+// the chunk before returning the given error.
+type fakeUploader struct {
+	content   []byte
+	failures  []fakeFailure
+	offsetErr error
+	committed bool
+}
+
+type fakeFailure struct {
+	commit int64
+	err    error
+}
+
+func (u *fakeUploader) start(d core.Digest) (string, error) {
+	return "uid", nil
+}
+
+func (u *fakeUploader) patch(d core.Digest, uid string, start, stop int64, chunk io.Reader) error {
+	if start != int64(len(u.content)) {
+		return httputil.StatusError{Status: http.StatusRequestedRangeNotSatisfiable}
+	}
+	b, err := ioutil.ReadAll(chunk)
+	if err != nil {
+		return err
+	}
+	if int64(len(b)) != stop-start {
+		return httputil.StatusError{Status: http.StatusBadRequest}
+	}
+	if len(u.failures) > 0 {
+		f := u.failures[0]
+		u.failures = u.failures[1:]
+		u.content = append(u.content, b[:f.commit]...)
+		return f.err
+	}
+	u.content = append(u.content, b...)
+	return nil
+}
+
+func (u *fakeUploader) offset(d core.Digest, uid string) (int64, error) {
+	if u.offsetErr != nil {
+		return 0, u.offsetErr
+	}
+	return int64(len(u.content)), nil
+}
+
+func (u *fakeUploader) commit(d core.Digest, uid string) error {
+	u.committed = true
+	return nil
+}
+
+var errServerUnavailable = httputil.StatusError{Status: http.StatusServiceUnavailable}
+
+func TestRunChunkedUploadResumesPartialChunk(t *testing.T) {
+	require := require.New(t)
+
+	blob := core.SizedBlobFixture(64, 8)
+	u := &fakeUploader{failures: []fakeFailure{{5, errServerUnavailable}, {0, errServerUnavailable}}}
+
+	require.NoError(runChunkedUpload(u, blob.Digest, bytes.NewReader(blob.Content), 16, 2))
+	require.Equal(blob.Content, u.content)
+	require.True(u.committed)
+}
+
+func TestRunChunkedUploadSkipsChunkCommittedBeforeFailure(t *testing.T) {
+	require := require.New(t)
+
+	blob := core.SizedBlobFixture(64, 8)
+	u := &fakeUploader{failures: []fakeFailure{{16, errServerUnavailable}}}
+
+	require.NoError(runChunkedUpload(u, blob.Digest, bytes.NewReader(blob.Content), 16, 1))
+	require.Equal(blob.Content, u.content)
+	require.True(u.committed)
+}
+
+func TestRunChunkedUploadFailsAfterMaxResumes(t *testing.T) {
+	blob := core.SizedBlobFixture(64, 8)
+	u := &fakeUploader{failures: []fakeFailure{{0, errServerUnavailable}, {0, errServerUnavailable}}}
+
+	require.Error(t, runChunkedUpload(u, blob.Digest, bytes.NewReader(blob.Content), 16, 1))
+	require.False(t, u.committed)
+}
+
+func TestRunChunkedUploadDoesNotResumeClientErrors(t *testing.T) {
+	blob := core.SizedBlobFixture(64, 8)
+	u := &fakeUploader{
+		failures: []fakeFailure{{0, httputil.StatusError{Status: http.StatusBadRequest}}},
+	}
+
+	err := runChunkedUpload(u, blob.Digest, bytes.NewReader(blob.Content), 16, 5)
+	require.True(t, httputil.IsStatus(err, http.StatusBadRequest))
+	require.False(t, u.committed)
+}
+
+func TestRunChunkedUploadOffsetError(t *testing.T) {
+	blob := core.SizedBlobFixture(64, 8)
+	u := &fakeUploader{
+		failures:  []fakeFailure{{0, errServerUnavailable}},
+		offsetErr: errors.New("some error"),
+	}
+
+	require.Error(t, runChunkedUpload(u, blob.Digest, bytes.NewReader(blob.Content), 16, 5))
+	require.False(t, u.committed)
+}
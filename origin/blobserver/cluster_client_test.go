@@ -15,6 +15,7 @@ package blobserver
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"sort"
 	"testing"
@@ -244,3 +245,42 @@ func TestClusterClientStatContinueWhenNotFound(t *testing.T) {
 	require.NotNil(bi)
 	require.Equal(int64(256), bi.Size)
 }
+
+func TestClusterClientStatBatchTriesNextOrigin(t *testing.T) {
+	require := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockResolver := mockblobclient.NewMockClientResolver(ctrl)
+
+	cc := blobclient.NewClusterClient(mockResolver)
+
+	namespace := core.TagFixture()
+	ds := []core.Digest{core.DigestFixture(), core.DigestFixture()}
+	found := map[core.Digest]*core.BlobInfo{ds[1]: core.NewBlobInfo(256)}
+
+	mockClient := mockblobclient.NewMockClient(ctrl)
+	// Reuse the same mockClient for two origins because origins are shuffled.
+	mockResolver.EXPECT().Resolve(ds[0]).Return([]blobclient.Client{mockClient, mockClient}, nil)
+
+	gomock.InOrder(
+		mockClient.EXPECT().StatBatch(namespace, ds).Return(nil, errors.New("some error")),
+		mockClient.EXPECT().StatBatch(namespace, ds).Return(found, nil),
+	)
+
+	result, err := cc.StatBatch(namespace, ds)
+	require.NoError(err)
+	require.Equal(found, result)
+}
+
+func TestClusterClientStatBatchEmpty(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cc := blobclient.NewClusterClient(mockblobclient.NewMockClientResolver(ctrl))
+
+	result, err := cc.StatBatch(core.TagFixture(), nil)
+	require.NoError(t, err)
+	require.Empty(t, result)
+}
@@ -23,11 +23,26 @@ import (
 type Config struct {
 	Listener                  listener.Config `yaml:"listener"`
 	DuplicateWriteBackStagger time.Duration   `yaml:"duplicate_write_back_stagger"`
+
+	// NamespaceReplicas overrides the hash ring max_replica for blobs under
+	// matching namespaces. The first matching config is used.
+	NamespaceReplicas []NamespaceReplicaConfig `yaml:"namespace_replicas"`
+
+	// Repair configures automatic repairs on hash ring membership changes.
+	Repair RepairConfig `yaml:"repair"`
+
+	// Expiry configures TTL based expiry of cached blobs per namespace.
+	Expiry ExpiryConfig `yaml:"expiry"`
+
+	// Drain configures draining the origin before decommissioning.
+	Drain DrainConfig `yaml:"drain"`
 }
 
 func (c Config) applyDefaults() Config {
 	if c.DuplicateWriteBackStagger == 0 {
 		c.DuplicateWriteBackStagger = 30 * time.Minute
 	}
+	c.Expiry = c.Expiry.applyDefaults()
+	c.Drain = c.Drain.applyDefaults()
 	return c
 }
@@ -0,0 +1,175 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/writeback"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+)
+
+// deadWriteBack describes a write-back task which exhausted its retries.
+type deadWriteBack struct {
+	Namespace   string    `json:"namespace"`
+	Name        string    `json:"name"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastAttempt time.Time `json:"last_attempt"`
+	Failures    int       `json:"failures"`
+
+	// Cached is false if the blob is no longer on the origin, in which case
+	// the write-back cannot succeed.
+	Cached bool `json:"cached"`
+}
+
+// deadWriteBacks returns the dead write-back tasks, limited to those of name
+// if name is set.
+func (s *Server) deadWriteBacks(name string) ([]*writeback.Task, error) {
+	tasks, err := s.writeBackManager.GetDead()
+	if err != nil {
+		if err == persistedretry.ErrDeadLettersUnsupported {
+			return nil, handler.Errorf("%s", err).Status(http.StatusNotFound)
+		}
+		return nil, handler.Errorf("get dead tasks: %s", err)
+	}
+	var result []*writeback.Task
+	for _, t := range tasks {
+		wt, ok := t.(*writeback.Task)
+		if !ok {
+			return nil, handler.Errorf("unexpected task type %T", t)
+		}
+		if name == "" || wt.Name == name {
+			result = append(result, wt)
+		}
+	}
+	return result, nil
+}
+
+// deadWriteBacksOf returns the dead write-back tasks of the name parameter of
+// r, or a 404 handler error if there are none.
+func (s *Server) deadWriteBacksOf(r *http.Request) (string, []*writeback.Task, error) {
+	name, err := httputil.ParseParam(r, "name")
+	if err != nil {
+		return "", nil, err
+	}
+	tasks, err := s.deadWriteBacks(name)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(tasks) == 0 {
+		return "", nil, handler.ErrorStatus(http.StatusNotFound)
+	}
+	return name, tasks, nil
+}
+
+func (s *Server) describeDeadWriteBacks(tasks []*writeback.Task) []deadWriteBack {
+	result := make([]deadWriteBack, 0, len(tasks))
+	for _, t := range tasks {
+		_, err := s.cas.GetCacheFileStat(t.Name)
+		result = append(result, deadWriteBack{
+			Namespace:   t.Namespace,
+			Name:        t.Name,
+			CreatedAt:   t.CreatedAt,
+			LastAttempt: t.LastAttempt,
+			Failures:    t.Failures,
+			Cached:      err == nil,
+		})
+	}
+	return result
+}
+
+// listDeadWriteBacksHandler lists all write-backs which exhausted their
+// retries.
+func (s *Server) listDeadWriteBacksHandler(w http.ResponseWriter, r *http.Request) error {
+	tasks, err := s.deadWriteBacks("")
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(w).Encode(s.describeDeadWriteBacks(tasks)); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
+// getDeadWriteBackHandler inspects the dead write-backs of a single blob.
+func (s *Server) getDeadWriteBackHandler(w http.ResponseWriter, r *http.Request) error {
+	_, tasks, err := s.deadWriteBacksOf(r)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(w).Encode(s.describeDeadWriteBacks(tasks)); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
+// retryDeadWriteBackHandler moves the dead write-backs of a blob back into the
+// retry loop.
+func (s *Server) retryDeadWriteBackHandler(w http.ResponseWriter, r *http.Request) error {
+	_, tasks, err := s.deadWriteBacksOf(r)
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		if err := s.writeBackManager.RetryDead(t); err != nil {
+			return handler.Errorf("retry %s: %s", t, err)
+		}
+	}
+	return nil
+}
+
+// discardDeadWriteBackHandler gives up on the dead write-backs of a blob. Once
+// no write-backs remain, the blob is no longer pinned and may be cleaned up,
+// after which it is lost unless the backend has it.
+func (s *Server) discardDeadWriteBackHandler(w http.ResponseWriter, r *http.Request) error {
+	name, tasks, err := s.deadWriteBacksOf(r)
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		if err := s.writeBackManager.DiscardDead(t); err != nil {
+			return handler.Errorf("discard %s: %s", t, err)
+		}
+		log.With("namespace", t.Namespace, "name", t.Name).Warn("Discarded dead write-back")
+	}
+	if err := s.unpinIfWrittenBack(name); err != nil {
+		return handler.Errorf("%s", err)
+	}
+	return nil
+}
+
+// unpinIfWrittenBack removes the persist metadata of name if it has no
+// remaining write-back tasks.
+func (s *Server) unpinIfWrittenBack(name string) error {
+	remaining, err := s.writeBackManager.Find(writeback.NewNameQuery(name))
+	if err != nil {
+		return fmt.Errorf("find writeback tasks: %s", err)
+	}
+	if len(remaining) > 0 {
+		return nil
+	}
+	err = s.cas.DeleteCacheFileMetadata(name, &metadata.Persist{})
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("delete persist: %s", err)
+	}
+	return nil
+}
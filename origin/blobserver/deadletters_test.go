@@ -0,0 +1,152 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/writeback"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/httputil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func deadWriteBackURL(s *testServer, name string) string {
+	return fmt.Sprintf("http://%s/x/writeback/dead/%s", s.addr, name)
+}
+
+func TestListDeadWriteBacks(t *testing.T) {
+	require := require.New(t)
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), newTestClientProvider())
+	defer s.cleanup()
+
+	cached := core.SizedBlobFixture(64, 8)
+	addBlob(t, s, "foo", cached)
+
+	cachedTask := writeback.NewTask("foo", cached.Digest.Hex(), 0)
+	cachedTask.Failures = 5
+	missingTask := writeback.TaskFixture()
+
+	s.writeBackManager.EXPECT().GetDead().Return(
+		[]persistedretry.Task{cachedTask, missingTask}, nil)
+
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/x/writeback/dead", s.addr))
+	require.NoError(err)
+	defer resp.Body.Close()
+
+	var result []deadWriteBack
+	require.NoError(json.NewDecoder(resp.Body).Decode(&result))
+	require.Len(result, 2)
+	require.Equal(cachedTask.Name, result[0].Name)
+	require.Equal(5, result[0].Failures)
+	require.True(result[0].Cached)
+	require.Equal(missingTask.Name, result[1].Name)
+	require.False(result[1].Cached)
+}
+
+func TestListDeadWriteBacksUnsupported(t *testing.T) {
+	s := newTestServer(t, master1, hashRingMaxReplica(), newTestClientProvider())
+	defer s.cleanup()
+
+	s.writeBackManager.EXPECT().GetDead().Return(nil, persistedretry.ErrDeadLettersUnsupported)
+
+	_, err := httputil.Get(fmt.Sprintf("http://%s/x/writeback/dead", s.addr))
+	require.True(t, httputil.IsNotFound(err))
+}
+
+func TestGetDeadWriteBackNotFound(t *testing.T) {
+	s := newTestServer(t, master1, hashRingMaxReplica(), newTestClientProvider())
+	defer s.cleanup()
+
+	s.writeBackManager.EXPECT().GetDead().Return(
+		[]persistedretry.Task{writeback.TaskFixture()}, nil)
+
+	_, err := httputil.Get(deadWriteBackURL(s, core.DigestFixture().Hex()))
+	require.True(t, httputil.IsNotFound(err))
+}
+
+func TestRetryDeadWriteBack(t *testing.T) {
+	require := require.New(t)
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), newTestClientProvider())
+	defer s.cleanup()
+
+	task := writeback.TaskFixture()
+
+	s.writeBackManager.EXPECT().GetDead().Return(
+		[]persistedretry.Task{task, writeback.TaskFixture()}, nil)
+	s.writeBackManager.EXPECT().RetryDead(task).Return(nil)
+
+	_, err := httputil.Post(deadWriteBackURL(s, task.Name) + "/retry")
+	require.NoError(err)
+}
+
+func TestDiscardDeadWriteBackUnpinsBlob(t *testing.T) {
+	require := require.New(t)
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), newTestClientProvider())
+	defer s.cleanup()
+
+	blob := core.SizedBlobFixture(64, 8)
+	addBlob(t, s, "foo", blob)
+	_, err := s.cas.SetCacheFileMetadata(blob.Digest.Hex(), metadata.NewPersist(true))
+	require.NoError(err)
+
+	task := writeback.NewTask("foo", blob.Digest.Hex(), 0)
+
+	s.writeBackManager.EXPECT().GetDead().Return([]persistedretry.Task{task}, nil)
+	s.writeBackManager.EXPECT().DiscardDead(task).Return(nil)
+	s.writeBackManager.EXPECT().Find(writeback.NewNameQuery(task.Name)).Return(nil, nil)
+
+	_, err = httputil.Delete(deadWriteBackURL(s, task.Name))
+	require.NoError(err)
+
+	var pm metadata.Persist
+	err = s.cas.GetCacheFileMetadata(blob.Digest.Hex(), &pm)
+	require.True(err != nil || !pm.Value)
+}
+
+func TestDiscardDeadWriteBackKeepsPinWhileTasksRemain(t *testing.T) {
+	require := require.New(t)
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), newTestClientProvider())
+	defer s.cleanup()
+
+	blob := core.SizedBlobFixture(64, 8)
+	addBlob(t, s, "foo", blob)
+	_, err := s.cas.SetCacheFileMetadata(blob.Digest.Hex(), metadata.NewPersist(true))
+	require.NoError(err)
+
+	task := writeback.NewTask("foo", blob.Digest.Hex(), 0)
+	other := writeback.NewTask("bar", blob.Digest.Hex(), 0)
+
+	s.writeBackManager.EXPECT().GetDead().Return([]persistedretry.Task{task}, nil)
+	s.writeBackManager.EXPECT().DiscardDead(task).Return(nil)
+	s.writeBackManager.EXPECT().Find(
+		writeback.NewNameQuery(task.Name)).Return([]persistedretry.Task{other}, nil)
+
+	_, err = httputil.Delete(deadWriteBackURL(s, task.Name))
+	require.NoError(err)
+
+	var pm metadata.Persist
+	require.NoError(s.cas.GetCacheFileMetadata(blob.Digest.Hex(), &pm))
+	require.True(pm.Value)
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/log"
+)
+
+// DrainConfig defines how origins are drained before decommissioning.
+type DrainConfig struct {
+	// NumWorkers limits the number of blobs drained concurrently.
+	NumWorkers int `yaml:"num_workers"`
+}
+
+func (c DrainConfig) applyDefaults() DrainConfig {
+	if c.NumWorkers == 0 {
+		c.NumWorkers = 8
+	}
+	return c
+}
+
+// DrainStatus reports the progress of draining an origin.
+type DrainStatus struct {
+	Draining    bool      `json:"draining"`
+	Running     bool      `json:"running"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Total       int       `json:"total"`
+	Processed   int       `json:"processed"`
+	WrittenBack int       `json:"written_back"`
+	Transferred int       `json:"transferred"`
+	Errors      int       `json:"errors"`
+
+	// SafeToTerminate is set once every blob is written back to the storage
+	// backend and pushed to the origins which own it in the origin's absence,
+	// and the origin is still rejecting writes.
+	SafeToTerminate bool `json:"safe_to_terminate"`
+}
+
+// drainer tracks the drain of a Server.
+type drainer struct {
+	mu     sync.Mutex
+	status DrainStatus
+}
+
+func (d *drainer) update(f func(*DrainStatus)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f(&d.status)
+}
+
+func (d *drainer) get() DrainStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.status
+}
+
+// drainStatus returns the progress of the drain of s.
+func (s *Server) drainStatus() DrainStatus {
+	status := s.drainer.get()
+	status.SafeToTerminate = status.Draining &&
+		!status.Running &&
+		status.Processed == status.Total &&
+		status.Errors == 0 &&
+		s.cas.ReadOnly()
+	return status
+}
+
+// startDrainHandler puts the origin into draining: it becomes read-only, and
+// then writes back and hands off all of its blobs in the background. Draining
+// again after errors retries all blobs.
+func (s *Server) startDrainHandler(w http.ResponseWriter, r *http.Request) error {
+	s.cas.SetReadOnly(true)
+
+	var start bool
+	s.drainer.update(func(status *DrainStatus) {
+		if status.Running {
+			return
+		}
+		start = true
+		*status = DrainStatus{
+			Draining:  true,
+			Running:   true,
+			StartedAt: s.clk.Now(),
+		}
+	})
+	if start {
+		log.Info("Draining origin")
+		go s.drain()
+	}
+	if err := json.NewEncoder(w).Encode(s.drainStatus()); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
+// getDrainStatusHandler returns the progress of the drain, including whether
+// the origin is safe to terminate.
+func (s *Server) getDrainStatusHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := json.NewEncoder(w).Encode(s.drainStatus()); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
+func (s *Server) drain() {
+	names, err := s.cas.ListCacheFiles()
+	if err != nil {
+		log.Errorf("Error listing cache files for drain: %s", err)
+		s.stats.Counter("drain_list_errors").Inc(1)
+		s.drainer.update(func(status *DrainStatus) {
+			status.Running = false
+			status.Errors++
+			status.FinishedAt = s.clk.Now()
+		})
+		return
+	}
+	s.drainer.update(func(status *DrainStatus) { status.Total = len(names) })
+
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < s.config.Drain.NumWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				writtenBack, transferred, err := s.drainBlob(name)
+				if err != nil {
+					log.With("blob", name).Errorf("Error draining blob: %s", err)
+					s.stats.Counter("drain_errors").Inc(1)
+				}
+				s.drainer.update(func(status *DrainStatus) {
+					status.Processed++
+					if writtenBack {
+						status.WrittenBack++
+					}
+					status.Transferred += len(transferred)
+					if err != nil {
+						status.Errors++
+					}
+				})
+			}
+		}()
+	}
+	for _, name := range names {
+		work <- name
+	}
+	close(work)
+	wg.Wait()
+
+	s.drainer.update(func(status *DrainStatus) {
+		status.Running = false
+		status.FinishedAt = s.clk.Now()
+	})
+	status := s.drainStatus()
+	log.Infof(
+		"Drain wrote back %d blobs, transferred %d blobs, with %d errors. Safe to terminate: %t",
+		status.WrittenBack, status.Transferred, status.Errors, status.SafeToTerminate)
+}
+
+// drainBlob writes back the blob of name if a write-back is pending, and
+// transfers it to the origins which own it once s leaves the hash ring.
+func (s *Server) drainBlob(name string) (writtenBack bool, transferred []string, err error) {
+	d, err := core.NewSHA256DigestFromHex(name)
+	if err != nil {
+		return false, nil, fmt.Errorf("parse digest: %s", err)
+	}
+	namespace, ok, err := s.blobNamespace(d)
+	if err != nil {
+		return false, nil, err
+	}
+	if ok {
+		writtenBack, err = s.ensureWrittenBack(namespace, name)
+		if err != nil {
+			return false, nil, err
+		}
+	}
+	for _, addr := range s.successors(namespace, ok, d) {
+		var sent bool
+		if ok {
+			sent, err = s.transferIfMissing(addr, namespace, d)
+		} else {
+			// Without a namespace the blob cannot be stat'd, but transfers of
+			// existing blobs are no-ops.
+			sent, err = true, s.transfer(addr, d)
+		}
+		if err != nil {
+			return writtenBack, transferred, fmt.Errorf("transfer to %s: %s", addr, err)
+		}
+		if sent {
+			transferred = append(transferred, addr)
+		}
+	}
+	return writtenBack, transferred, nil
+}
+
+// successors returns the origins which would own d if s left the hash ring.
+func (s *Server) successors(namespace string, namespaceKnown bool, d core.Digest) []string {
+	var n int
+	if namespaceKnown {
+		n = len(s.locations(namespace, d))
+	} else {
+		n = len(s.hashRing.Locations(d))
+	}
+	var addrs []string
+	for _, addr := range s.hashRing.ReplicaLocations(d, n+1) {
+		if addr != s.addr && len(addrs) < n {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+func (s *Server) transfer(addr string, d core.Digest) error {
+	f, err := s.cas.GetCacheFileReader(d.Hex())
+	if err != nil {
+		return fmt.Errorf("get cache reader: %s", err)
+	}
+	defer f.Close()
+	return s.clientProvider.Provide(addr).TransferBlob(d, f)
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/backend/backenderrors"
+	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/writeback"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func startDrain(t *testing.T, s *testServer) {
+	_, err := httputil.Post(fmt.Sprintf("http://%s/x/drain", s.addr))
+	require.NoError(t, err)
+}
+
+func getDrainStatus(t *testing.T, s *testServer) DrainStatus {
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/x/drain/status", s.addr))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var status DrainStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	return status
+}
+
+func waitForDrain(t *testing.T, s *testServer) DrainStatus {
+	var status DrainStatus
+	require.NoError(t, testutil.PollUntilTrue(5*time.Second, func() bool {
+		status = getDrainStatus(t, s)
+		return !status.Running
+	}))
+	return status
+}
+
+func TestDrainStatusBeforeDrain(t *testing.T) {
+	s := newTestServer(t, master1, hashRingMaxReplica(), newTestClientProvider())
+	defer s.cleanup()
+
+	require.Equal(t, DrainStatus{}, getDrainStatus(t, s))
+}
+
+func TestDrainTransfersBlobsToSuccessors(t *testing.T) {
+	require := require.New(t)
+
+	ring := hashRingSomeReplica()
+	cp := newTestClientProvider()
+
+	s1 := newTestServer(t, master1, ring, cp)
+	defer s1.cleanup()
+
+	s2 := newTestServer(t, master2, ring, cp)
+	defer s2.cleanup()
+
+	s3 := newTestServer(t, master3, ring, cp)
+	defer s3.cleanup()
+
+	namespace := core.TagFixture()
+	blob := computeBlobForHosts(ring, s1.host, s2.host)
+	addBlob(t, s1, namespace, blob)
+
+	startDrain(t, s1)
+	status := waitForDrain(t, s1)
+
+	require.True(status.Draining)
+	require.Equal(1, status.Total)
+	require.Equal(1, status.Processed)
+	require.Equal(2, status.Transferred)
+	require.Equal(0, status.Errors)
+	require.True(status.SafeToTerminate)
+
+	for _, s := range []*testServer{s2, s3} {
+		_, err := s.cas.GetCacheFileStat(blob.Digest.Hex())
+		require.NoError(err)
+	}
+}
+
+func TestDrainRejectsWrites(t *testing.T) {
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, hashRingNoReplica(), cp)
+	defer s.cleanup()
+
+	startDrain(t, s)
+	waitForDrain(t, s)
+
+	blob := core.SizedBlobFixture(64, 8)
+	err := cp.Provide(s.host).UploadBlob(core.TagFixture(), blob.Digest, bytes.NewReader(blob.Content))
+	require.True(t, httputil.IsStatus(err, http.StatusServiceUnavailable))
+}
+
+func TestDrainWritesBackPendingBlobs(t *testing.T) {
+	require := require.New(t)
+
+	ring := hashRingNoReplica()
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, ring, cp)
+	defer s.cleanup()
+
+	// Successors of the draining origin.
+	for _, host := range []string{master2, master3} {
+		defer newTestServer(t, host, ring, cp).cleanup()
+	}
+
+	namespace := core.TagFixture()
+	blob := computeBlobForHosts(ring, s.host)
+	addBlob(t, s, namespace, blob)
+	_, err := s.cas.SetCacheFileMetadata(blob.Digest.Hex(), metadata.NewPersist(true))
+	require.NoError(err)
+
+	backendClient := s.backendClient(namespace)
+	task := writeback.NewTask(namespace, blob.Digest.Hex(), 0)
+
+	s.writeBackManager.EXPECT().Find(
+		writeback.NewNameQuery(blob.Digest.Hex())).Return([]persistedretry.Task{task}, nil)
+	s.writeBackManager.EXPECT().SyncExec(task).Return(nil)
+	backendClient.EXPECT().Stat(namespace, blob.Digest.Hex()).Return(core.NewBlobInfo(64), nil)
+
+	startDrain(t, s)
+	status := waitForDrain(t, s)
+
+	require.Equal(1, status.WrittenBack)
+	require.Equal(1, status.Transferred)
+	require.Equal(0, status.Errors)
+	require.True(status.SafeToTerminate)
+}
+
+func TestDrainNotSafeToTerminateWhenWriteBackFails(t *testing.T) {
+	require := require.New(t)
+
+	s := newTestServer(t, master1, hashRingNoReplica(), newTestClientProvider())
+	defer s.cleanup()
+
+	namespace := core.TagFixture()
+	blob := computeBlobForHosts(s.server.hashRing, s.host)
+	addBlob(t, s, namespace, blob)
+	_, err := s.cas.SetCacheFileMetadata(blob.Digest.Hex(), metadata.NewPersist(true))
+	require.NoError(err)
+
+	backendClient := s.backendClient(namespace)
+
+	s.writeBackManager.EXPECT().Find(writeback.NewNameQuery(blob.Digest.Hex())).Return(nil, nil)
+	backendClient.EXPECT().Stat(namespace, blob.Digest.Hex()).Return(nil, backenderrors.ErrBlobNotFound)
+
+	startDrain(t, s)
+	status := waitForDrain(t, s)
+
+	require.Equal(1, status.Errors)
+	require.False(status.SafeToTerminate)
+}
+
+func TestDrainNotSafeToTerminateOnceWritable(t *testing.T) {
+	s := newTestServer(t, master1, hashRingNoReplica(), newTestClientProvider())
+	defer s.cleanup()
+
+	startDrain(t, s)
+	require.True(t, waitForDrain(t, s).SafeToTerminate)
+
+	s.cas.SetReadOnly(false)
+	require.False(t, getDrainStatus(t, s).SafeToTerminate)
+}
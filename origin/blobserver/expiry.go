@@ -0,0 +1,203 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobserver
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/persistedretry/writeback"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/log"
+)
+
+// NamespaceTTLConfig expires cached blobs under matching namespaces once TTL
+// has passed since they were written to the origin.
+type NamespaceTTLConfig struct {
+	// Namespace is a regexp matched against blob namespaces.
+	Namespace string        `yaml:"namespace"`
+	TTL       time.Duration `yaml:"ttl"`
+}
+
+// ExpiryConfig defines configuration for expiring cached blobs per namespace.
+// Unlike size based cleanup, a blob is never expired before its write-back to
+// the storage backend has completed.
+type ExpiryConfig struct {
+	Interval time.Duration `yaml:"interval"` // How often expiry runs.
+
+	// Namespaces configures the TTL of blobs under matching namespaces. The
+	// first matching config is used. Blobs which match no config, or whose
+	// namespace is unknown, are left to regular cleanup.
+	Namespaces []NamespaceTTLConfig `yaml:"namespaces"`
+}
+
+func (c ExpiryConfig) applyDefaults() ExpiryConfig {
+	if c.Interval == 0 {
+		c.Interval = 10 * time.Minute
+	}
+	return c
+}
+
+type namespaceTTL struct {
+	regexp *regexp.Regexp
+	ttl    time.Duration
+}
+
+// namespaceTTLs matches namespaces to their blob TTL.
+type namespaceTTLs []namespaceTTL
+
+func newNamespaceTTLs(configs []NamespaceTTLConfig) (namespaceTTLs, error) {
+	var n namespaceTTLs
+	for _, c := range configs {
+		re, err := regexp.Compile(c.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace regexp %q: %s", c.Namespace, err)
+		}
+		if c.TTL <= 0 {
+			return nil, fmt.Errorf("namespace %q: ttl must be positive", c.Namespace)
+		}
+		n = append(n, namespaceTTL{re, c.TTL})
+	}
+	return n, nil
+}
+
+// get returns the TTL of namespace, or 0 if no config matches.
+func (n namespaceTTLs) get(namespace string) time.Duration {
+	for _, t := range n {
+		if t.regexp.MatchString(namespace) {
+			return t.ttl
+		}
+	}
+	return 0
+}
+
+// expiryResult summarizes a single expiry pass.
+type expiryResult struct {
+	Expired   int
+	Reclaimed int64
+	Errors    int
+}
+
+func (s *Server) runExpiry() {
+	for range s.clk.Ticker(s.config.Expiry.Interval).C {
+		s.expireBlobs()
+	}
+}
+
+// expireBlobs deletes all cached blobs whose namespace TTL has passed.
+func (s *Server) expireBlobs() expiryResult {
+	var res expiryResult
+
+	names, err := s.cas.ListCacheFiles()
+	if err != nil {
+		log.Errorf("Error listing cache files for expiry: %s", err)
+		s.stats.Counter("expiry_errors").Inc(1)
+		res.Errors++
+		return res
+	}
+	for _, name := range names {
+		size, err := s.maybeExpire(name)
+		if err != nil {
+			log.With("name", name).Errorf("Error expiring blob: %s", err)
+			res.Errors++
+		} else if size > 0 {
+			res.Expired++
+			res.Reclaimed += size
+		}
+	}
+	s.stats.Counter("expired_blobs").Inc(int64(res.Expired))
+	s.stats.Counter("expired_bytes").Inc(res.Reclaimed)
+	s.stats.Counter("expiry_errors").Inc(int64(res.Errors))
+	log.Infof(
+		"Expired %d blobs reclaiming %d bytes, with %d errors",
+		res.Expired, res.Reclaimed, res.Errors)
+	return res
+}
+
+// maybeExpire deletes the blob of name if its namespace TTL has passed.
+// Returns the size of the deleted blob, or 0 if it was not deleted.
+func (s *Server) maybeExpire(name string) (int64, error) {
+	d, err := core.NewSHA256DigestFromHex(name)
+	if err != nil {
+		return 0, fmt.Errorf("parse digest: %s", err)
+	}
+	namespace, ok, err := s.blobNamespace(d)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, nil
+	}
+	ttl := s.namespaceTTLs.get(namespace)
+	if ttl == 0 {
+		return 0, nil
+	}
+	info, err := s.cas.GetCacheFileStat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("store: %s", err)
+	}
+	if s.clk.Now().Sub(info.ModTime()) <= ttl {
+		return 0, nil
+	}
+	if _, err := s.ensureWrittenBack(namespace, name); err != nil {
+		s.stats.Counter("expiry_writeback_incomplete").Inc(1)
+		return 0, err
+	}
+	if err := s.cas.DeleteCacheFile(name); err != nil {
+		return 0, fmt.Errorf("delete: %s", err)
+	}
+	return info.Size(), nil
+}
+
+// ensureWrittenBack executes any pending write-back tasks for the blob of name,
+// and then confirms the storage backend has the blob. Blobs which were never
+// marked for write-back originate from the backend and are returned as is.
+// Returns whether the blob was pending write-back.
+func (s *Server) ensureWrittenBack(namespace, name string) (bool, error) {
+	var pm metadata.Persist
+	if err := s.cas.GetCacheFileMetadata(name, &pm); err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("store: %s", err)
+	}
+	if !pm.Value {
+		return false, nil
+	}
+	tasks, err := s.writeBackManager.Find(writeback.NewNameQuery(name))
+	if err != nil {
+		return false, fmt.Errorf("find writeback tasks: %s", err)
+	}
+	for _, task := range tasks {
+		if err := s.writeBackManager.SyncExec(task); err != nil {
+			return false, fmt.Errorf("writeback: %s", err)
+		}
+	}
+	// Write-back tasks may be dropped without uploading, e.g. for unconfigured
+	// namespaces, so the backend is the source of truth.
+	client, err := s.backends.GetClient(namespace)
+	if err != nil {
+		return false, fmt.Errorf("get backend client: %s", err)
+	}
+	if _, err := client.Stat(namespace, name); err != nil {
+		return false, fmt.Errorf("backend stat: %s", err)
+	}
+	if err := s.cas.DeleteCacheFileMetadata(name, &metadata.Persist{}); err != nil {
+		return false, fmt.Errorf("delete persist: %s", err)
+	}
+	return true, nil
+}
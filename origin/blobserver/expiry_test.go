@@ -0,0 +1,142 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobserver
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/backend/backenderrors"
+	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/writeback"
+	"github.com/uber/kraken/lib/store/metadata"
+
+	"github.com/stretchr/testify/require"
+)
+
+func expiryConfig(namespace string, ttl time.Duration) Config {
+	return Config{
+		Expiry: ExpiryConfig{
+			Namespaces: []NamespaceTTLConfig{{Namespace: namespace, TTL: ttl}},
+		},
+	}
+}
+
+func hasBlob(s *testServer, d core.Digest) bool {
+	_, err := s.cas.GetCacheFileStat(d.Hex())
+	return err == nil
+}
+
+func TestNamespaceTTLsInvalidConfig(t *testing.T) {
+	for _, c := range []NamespaceTTLConfig{
+		{Namespace: "(", TTL: time.Hour},
+		{Namespace: ".*", TTL: 0},
+	} {
+		_, err := newNamespaceTTLs([]NamespaceTTLConfig{c})
+		require.Error(t, err)
+	}
+}
+
+func TestExpireBlobsAfterNamespaceTTL(t *testing.T) {
+	require := require.New(t)
+
+	s := newTestServerWithConfig(
+		t, expiryConfig("scratch/.*", time.Hour), nil, master1, hashRingMaxReplica(), newTestClientProvider())
+	defer s.cleanup()
+
+	expiring := core.SizedBlobFixture(64, 8)
+	addBlob(t, s, "scratch/foo", expiring)
+
+	other := core.SizedBlobFixture(64, 8)
+	addBlob(t, s, "other/foo", other)
+
+	s.clk.Add(30 * time.Minute)
+	require.Equal(expiryResult{}, s.server.expireBlobs())
+	require.True(hasBlob(s, expiring.Digest))
+
+	s.clk.Add(time.Hour)
+	require.Equal(expiryResult{Expired: 1, Reclaimed: 64}, s.server.expireBlobs())
+	require.False(hasBlob(s, expiring.Digest))
+	require.True(hasBlob(s, other.Digest))
+}
+
+func TestExpireBlobsSkipsUnknownNamespace(t *testing.T) {
+	require := require.New(t)
+
+	s := newTestServerWithConfig(
+		t, expiryConfig(".*", time.Hour), nil, master1, hashRingMaxReplica(), newTestClientProvider())
+	defer s.cleanup()
+
+	blob := core.SizedBlobFixture(64, 8)
+	require.NoError(s.cas.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+
+	s.clk.Add(2 * time.Hour)
+	require.Equal(expiryResult{}, s.server.expireBlobs())
+	require.True(hasBlob(s, blob.Digest))
+}
+
+func TestExpireBlobsCompletesWriteBackBeforeDeletion(t *testing.T) {
+	require := require.New(t)
+
+	s := newTestServerWithConfig(
+		t, expiryConfig(".*", time.Hour), nil, master1, hashRingMaxReplica(), newTestClientProvider())
+	defer s.cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(64, 8)
+	addBlob(t, s, namespace, blob)
+	_, err := s.cas.SetCacheFileMetadata(blob.Digest.Hex(), metadata.NewPersist(true))
+	require.NoError(err)
+
+	backendClient := s.backendClient(namespace)
+	task := writeback.NewTask(namespace, blob.Digest.Hex(), 0)
+
+	s.writeBackManager.EXPECT().Find(
+		writeback.NewNameQuery(blob.Digest.Hex())).Return([]persistedretry.Task{task}, nil)
+	s.writeBackManager.EXPECT().SyncExec(task).Return(nil)
+	backendClient.EXPECT().Stat(namespace, blob.Digest.Hex()).Return(core.NewBlobInfo(64), nil)
+
+	s.clk.Add(2 * time.Hour)
+	require.Equal(expiryResult{Expired: 1, Reclaimed: 64}, s.server.expireBlobs())
+	require.False(hasBlob(s, blob.Digest))
+}
+
+func TestExpireBlobsKeepsBlobsMissingFromBackend(t *testing.T) {
+	require := require.New(t)
+
+	s := newTestServerWithConfig(
+		t, expiryConfig(".*", time.Hour), nil, master1, hashRingMaxReplica(), newTestClientProvider())
+	defer s.cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(64, 8)
+	addBlob(t, s, namespace, blob)
+	_, err := s.cas.SetCacheFileMetadata(blob.Digest.Hex(), metadata.NewPersist(true))
+	require.NoError(err)
+
+	backendClient := s.backendClient(namespace)
+
+	s.writeBackManager.EXPECT().Find(writeback.NewNameQuery(blob.Digest.Hex())).Return(nil, nil)
+	backendClient.EXPECT().Stat(namespace, blob.Digest.Hex()).Return(nil, backenderrors.ErrBlobNotFound)
+
+	s.clk.Add(2 * time.Hour)
+	require.Equal(expiryResult{Errors: 1}, s.server.expireBlobs())
+	require.True(hasBlob(s, blob.Digest))
+
+	var pm metadata.Persist
+	require.NoError(s.cas.GetCacheFileMetadata(blob.Digest.Hex(), &pm))
+	require.True(pm.Value)
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/log"
+	"github.com/uber/kraken/utils/stringset"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+)
+
+// RepairConfig defines automatic repairs on hash ring membership changes.
+type RepairConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// SettleDelay is how long to wait after a membership change before
+	// repairing, such that several changes in a row trigger a single repair.
+	SettleDelay time.Duration `yaml:"settle_delay"`
+
+	// NumWorkers limits the number of blobs repaired concurrently.
+	NumWorkers int `yaml:"num_workers"`
+
+	// DeleteUnowned deletes blobs which are no longer owned after repairing.
+	// Unowned blobs are otherwise left for cleanup.
+	DeleteUnowned bool `yaml:"delete_unowned"`
+}
+
+func (c RepairConfig) applyDefaults() RepairConfig {
+	if c.SettleDelay == 0 {
+		c.SettleDelay = time.Minute
+	}
+	if c.NumWorkers == 0 {
+		c.NumWorkers = 8
+	}
+	return c
+}
+
+// RepairStatus reports the progress of the latest repair.
+type RepairStatus struct {
+	Running    bool      `json:"running"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Total      int       `json:"total"`
+	Processed  int       `json:"processed"`
+	Replicated int       `json:"replicated"`
+	Deleted    int       `json:"deleted"`
+	Errors     int       `json:"errors"`
+}
+
+// RepairController repairs local blobs whenever hash ring membership changes,
+// replicating them to origins which newly own them. RepairController
+// implements hashring.Watcher.
+type RepairController struct {
+	config  RepairConfig
+	stats   tally.Scope
+	clk     clock.Clock
+	changes chan struct{}
+
+	mu       sync.Mutex
+	baseline bool
+	status   RepairStatus
+}
+
+// NewRepairController creates a new RepairController.
+func NewRepairController(config RepairConfig, stats tally.Scope, clk clock.Clock) *RepairController {
+	stats = stats.Tagged(map[string]string{
+		"module": "repaircontroller",
+	})
+	return &RepairController{
+		config:  config.applyDefaults(),
+		stats:   stats,
+		clk:     clk,
+		changes: make(chan struct{}, 1),
+	}
+}
+
+// Notify schedules a repair. The first notification only reports the initial
+// membership and is ignored.
+func (c *RepairController) Notify(latest stringset.Set) {
+	c.mu.Lock()
+	baseline := c.baseline
+	c.baseline = true
+	c.mu.Unlock()
+
+	if !baseline {
+		return
+	}
+	log.With("latest", latest.ToSlice()).Info("Hash ring membership changed, scheduling repair")
+	select {
+	case c.changes <- struct{}{}:
+	default:
+		// A repair is already scheduled.
+	}
+}
+
+// Status returns the progress of the latest repair.
+func (c *RepairController) Status() RepairStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.status
+}
+
+// StartRepairController runs c against s in the background.
+func (s *Server) StartRepairController(c *RepairController) {
+	s.repairController = c
+	go c.run(s)
+}
+
+func (c *RepairController) run(s *Server) {
+	for range c.changes {
+		<-c.clk.After(c.config.SettleDelay)
+		c.repairAll(s)
+	}
+}
+
+func (c *RepairController) repairAll(s *Server) {
+	names, err := s.cas.ListCacheFiles()
+	if err != nil {
+		log.Errorf("Error listing cache files for repair: %s", err)
+		c.stats.Counter("list_errors").Inc(1)
+		return
+	}
+
+	c.update(func(status *RepairStatus) {
+		*status = RepairStatus{
+			Running:   true,
+			StartedAt: c.clk.Now(),
+			Total:     len(names),
+		}
+	})
+	log.Infof("Repairing %d blobs", len(names))
+
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < c.config.NumWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				c.repairBlob(s, name)
+			}
+		}()
+	}
+	for _, name := range names {
+		work <- name
+	}
+	close(work)
+	wg.Wait()
+
+	c.update(func(status *RepairStatus) {
+		status.Running = false
+		status.FinishedAt = c.clk.Now()
+	})
+	status := c.Status()
+	c.stats.Counter("runs").Inc(1)
+	log.Infof(
+		"Repair replicated %d blobs, deleted %d blobs, with %d errors",
+		status.Replicated, status.Deleted, status.Errors)
+}
+
+func (c *RepairController) repairBlob(s *Server, name string) {
+	replicated, deleted, err := s.repair(name, c.config.DeleteUnowned)
+	if err != nil {
+		log.With("blob", name).Errorf("Error repairing blob: %s", err)
+		c.stats.Counter("errors").Inc(1)
+	}
+	c.stats.Counter("replicated").Inc(int64(len(replicated)))
+	if deleted {
+		c.stats.Counter("deleted").Inc(1)
+	}
+	c.update(func(status *RepairStatus) {
+		status.Processed++
+		status.Replicated += len(replicated)
+		if deleted {
+			status.Deleted++
+		}
+		if err != nil {
+			status.Errors++
+		}
+	})
+}
+
+func (c *RepairController) update(f func(*RepairStatus)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f(&c.status)
+}
+
+// getRepairStatusHandler returns the progress of the latest automatic repair.
+func (s *Server) getRepairStatusHandler(w http.ResponseWriter, r *http.Request) error {
+	if s.repairController == nil {
+		return handler.Errorf("repair controller not enabled").Status(http.StatusNotFound)
+	}
+	return json.NewEncoder(w).Encode(s.repairController.Status())
+}
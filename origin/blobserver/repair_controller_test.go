@@ -0,0 +1,144 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/origin/blobclient"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/stringset"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestRepairControllerIgnoresInitialMembership(t *testing.T) {
+	require := require.New(t)
+
+	c := NewRepairController(RepairConfig{}, tally.NoopScope, clock.NewMock())
+
+	c.Notify(stringset.New(master1))
+	require.Len(c.changes, 0)
+
+	c.Notify(stringset.New(master1, master2))
+	require.Len(c.changes, 1)
+
+	// Changes are coalesced while a repair is scheduled.
+	c.Notify(stringset.New(master1, master2, master3))
+	require.Len(c.changes, 1)
+}
+
+func TestRepairControllerHandsOffBlobsOnRingChange(t *testing.T) {
+	require := require.New(t)
+
+	ring := hashRingNoReplica()
+	namespace := core.TagFixture()
+
+	cp := newTestClientProvider()
+
+	s1 := newTestServer(t, master1, ring, cp)
+	defer s1.cleanup()
+
+	s2 := newTestServer(t, master2, ring, cp)
+	defer s2.cleanup()
+
+	// Simulates s2 joining the ring and taking ownership of a blob on s1.
+	blob := computeBlobForHosts(ring, s2.host)
+	addBlob(t, s1, namespace, blob)
+
+	clk := clock.NewMock()
+	c := NewRepairController(
+		RepairConfig{SettleDelay: time.Minute, DeleteUnowned: true}, tally.NoopScope, clk)
+	s1.server.StartRepairController(c)
+
+	c.Notify(stringset.New(master1))
+	c.Notify(stringset.New(master1, master2))
+
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		clk.Add(time.Minute)
+		status := c.Status()
+		return !status.Running && status.Processed == 1
+	}))
+
+	_, err := cp.Provide(s2.host).StatLocal(namespace, blob.Digest)
+	require.NoError(err)
+
+	_, err = cp.Provide(s1.host).StatLocal(namespace, blob.Digest)
+	require.Equal(blobclient.ErrBlobNotFound, err)
+
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/x/repair/status", s1.addr))
+	require.NoError(err)
+	defer resp.Body.Close()
+
+	var status RepairStatus
+	require.NoError(json.NewDecoder(resp.Body).Decode(&status))
+	require.Equal(1, status.Total)
+	require.Equal(1, status.Replicated)
+	require.Equal(1, status.Deleted)
+	require.Equal(0, status.Errors)
+}
+
+func TestRepairControllerKeepsUnownedBlobsByDefault(t *testing.T) {
+	require := require.New(t)
+
+	ring := hashRingNoReplica()
+	namespace := core.TagFixture()
+
+	cp := newTestClientProvider()
+
+	s1 := newTestServer(t, master1, ring, cp)
+	defer s1.cleanup()
+
+	s2 := newTestServer(t, master2, ring, cp)
+	defer s2.cleanup()
+
+	blob := computeBlobForHosts(ring, s2.host)
+	addBlob(t, s1, namespace, blob)
+
+	clk := clock.NewMock()
+	c := NewRepairController(RepairConfig{SettleDelay: time.Minute}, tally.NoopScope, clk)
+	s1.server.StartRepairController(c)
+
+	c.Notify(stringset.New(master1))
+	c.Notify(stringset.New(master1, master2))
+
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		clk.Add(time.Minute)
+		status := c.Status()
+		return !status.Running && status.Processed == 1
+	}))
+
+	_, err := cp.Provide(s2.host).StatLocal(namespace, blob.Digest)
+	require.NoError(err)
+
+	_, err = cp.Provide(s1.host).StatLocal(namespace, blob.Digest)
+	require.NoError(err)
+}
+
+func TestGetRepairStatusNotEnabled(t *testing.T) {
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, hashRingNoReplica(), cp)
+	defer s.cleanup()
+
+	_, err := httputil.Get(fmt.Sprintf("http://%s/x/repair/status", s.addr))
+	require.True(t, httputil.IsNotFound(err))
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/origin/blobclient"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+	"github.com/uber/kraken/utils/stringset"
+)
+
+// NamespaceReplicaConfig overrides the number of origins each blob is
+// replicated across for blobs under matching namespaces.
+type NamespaceReplicaConfig struct {
+	// Namespace is a regexp matched against blob namespaces.
+	Namespace string `yaml:"namespace"`
+	Replicas  int    `yaml:"replicas"`
+}
+
+type namespaceReplica struct {
+	regexp   *regexp.Regexp
+	replicas int
+}
+
+// namespaceReplicas matches namespaces to their replica count. The first
+// matching config is used, else the hash ring max_replica.
+type namespaceReplicas struct {
+	replicas []namespaceReplica
+
+	// max is the largest configured replica count.
+	max int
+}
+
+func newNamespaceReplicas(configs []NamespaceReplicaConfig) (*namespaceReplicas, error) {
+	n := &namespaceReplicas{}
+	for _, c := range configs {
+		re, err := regexp.Compile(c.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace regexp %q: %s", c.Namespace, err)
+		}
+		if c.Replicas < 1 {
+			return nil, fmt.Errorf("namespace %q: replicas must be positive", c.Namespace)
+		}
+		n.replicas = append(n.replicas, namespaceReplica{re, c.Replicas})
+		if c.Replicas > n.max {
+			n.max = c.Replicas
+		}
+	}
+	return n, nil
+}
+
+// get returns the replica count of namespace, or 0 if no config matches.
+func (n *namespaceReplicas) get(namespace string) int {
+	for _, r := range n.replicas {
+		if r.regexp.MatchString(namespace) {
+			return r.replicas
+		}
+	}
+	return 0
+}
+
+// locations returns the origins which own d under namespace.
+func (s *Server) locations(namespace string, d core.Digest) []string {
+	if n := s.namespaceReplicas.get(namespace); n > 0 {
+		return s.hashRing.ReplicaLocations(d, n)
+	}
+	return s.hashRing.Locations(d)
+}
+
+// blobNamespace returns the namespace of the blob of d. Returns false if the
+// namespace is unknown, e.g. for blobs received via internal transfers.
+func (s *Server) blobNamespace(d core.Digest) (string, bool, error) {
+	var ns metadata.Namespace
+	if err := s.cas.GetCacheFileMetadata(d.Hex(), &ns); err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("get namespace metadata: %s", err)
+	}
+	return ns.Value, true, nil
+}
+
+// owns returns whether s owns the blob of d. Blobs with unknown namespaces are
+// owned by the largest replica set they may belong to, such that they are
+// never deleted by mistake.
+func (s *Server) owns(d core.Digest) (bool, error) {
+	namespace, ok, err := s.blobNamespace(d)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return stringset.FromSlice(s.locations(namespace, d)).Has(s.addr), nil
+	}
+	if stringset.FromSlice(s.hashRing.Locations(d)).Has(s.addr) {
+		return true, nil
+	}
+	max := s.namespaceReplicas.max
+	return max > 0 && stringset.FromSlice(s.hashRing.ReplicaLocations(d, max)).Has(s.addr), nil
+}
+
+// repairResult describes the actions taken by a repair.
+type repairResult struct {
+	Replicated []string `json:"replicated"`
+	Deleted    []string `json:"deleted"`
+	Errors     []string `json:"errors"`
+}
+
+// repairHandler reconciles local blobs with their current replica sets, e.g.
+// after namespace replica counts change. Blobs are transferred to owners which
+// are missing them, and deleted if s no longer owns them.
+func (s *Server) repairHandler(w http.ResponseWriter, r *http.Request) error {
+	names, err := s.cas.ListCacheFiles()
+	if err != nil {
+		return err
+	}
+	var result repairResult
+	for _, name := range names {
+		replicated, deleted, err := s.repair(name, true)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", name, err))
+			s.stats.Counter("repair_errors").Inc(1)
+			continue
+		}
+		for _, addr := range replicated {
+			result.Replicated = append(result.Replicated, fmt.Sprintf("%s: %s", name, addr))
+		}
+		if deleted {
+			result.Deleted = append(result.Deleted, name)
+		}
+	}
+	s.stats.Counter("repair_replicated").Inc(int64(len(result.Replicated)))
+	s.stats.Counter("repair_deleted").Inc(int64(len(result.Deleted)))
+	log.Infof(
+		"Repair replicated %d blobs, deleted %d blobs, with %d errors",
+		len(result.Replicated), len(result.Deleted), len(result.Errors))
+	return json.NewEncoder(w).Encode(result)
+}
+
+// repair reconciles the blob of name with its replica set, transferring it to
+// owners which are missing it. If s no longer owns the blob and deleteUnowned
+// is set, the blob is then deleted. Returns the origins the blob was
+// replicated to, and whether the blob was deleted.
+func (s *Server) repair(name string, deleteUnowned bool) (replicated []string, deleted bool, err error) {
+	d, err := core.NewSHA256DigestFromHex(name)
+	if err != nil {
+		return nil, false, fmt.Errorf("parse digest: %s", err)
+	}
+	namespace, ok, err := s.blobNamespace(d)
+	if err != nil {
+		return nil, false, err
+	}
+	// Replicas of blobs with unknown namespaces cannot be determined.
+	if ok {
+		for _, addr := range s.locations(namespace, d) {
+			if addr == s.addr {
+				continue
+			}
+			ok, err := s.transferIfMissing(addr, namespace, d)
+			if err != nil {
+				return replicated, false, fmt.Errorf("replicate to %s: %s", addr, err)
+			}
+			if ok {
+				replicated = append(replicated, addr)
+			}
+		}
+	}
+	if !deleteUnowned {
+		return replicated, false, nil
+	}
+	owns, err := s.owns(d)
+	if err != nil {
+		return replicated, false, err
+	}
+	if owns {
+		return replicated, false, nil
+	}
+	if err := s.persistAndDelete(name); err != nil {
+		return replicated, false, err
+	}
+	return replicated, true, nil
+}
+
+// transferIfMissing transfers the blob of d to addr if addr does not have it.
+// If the transfer fails, addr is instead asked to fetch the blob from the
+// storage backend. Returns whether the blob was replicated.
+func (s *Server) transferIfMissing(addr, namespace string, d core.Digest) (bool, error) {
+	client := s.clientProvider.Provide(addr)
+	if _, err := client.StatLocal(namespace, d); err == nil {
+		return false, nil
+	} else if err != blobclient.ErrBlobNotFound {
+		return false, fmt.Errorf("stat: %s", err)
+	}
+	f, err := s.cas.GetCacheFileReader(d.Hex())
+	if err != nil {
+		return false, fmt.Errorf("get cache reader: %s", err)
+	}
+	defer f.Close()
+	if err := client.TransferBlob(d, f); err != nil {
+		// Fetching metainfo triggers a backend download if addr lacks the blob.
+		if _, ferr := client.GetMetaInfo(namespace, d); ferr != nil && !httputil.IsAccepted(ferr) {
+			return false, fmt.Errorf("transfer blob: %s, backend fallback: %s", err, ferr)
+		}
+		log.With("blob", d.Hex(), "addr", addr).Warnf(
+			"Transfer failed, replicating from backend instead: %s", err)
+	}
+	return true, nil
+}
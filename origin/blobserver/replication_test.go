@@ -0,0 +1,266 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/persistedretry/blobreplication"
+	"github.com/uber/kraken/lib/persistedretry/writeback"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/origin/blobclient"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/stringset"
+
+	"github.com/stretchr/testify/require"
+)
+
+func namespaceReplicasConfig(replicas int) Config {
+	return Config{
+		NamespaceReplicas: []NamespaceReplicaConfig{{Namespace: ".*", Replicas: replicas}},
+	}
+}
+
+func repair(t *testing.T, s *testServer) repairResult {
+	resp, err := httputil.Post(fmt.Sprintf("http://%s/x/repair", s.addr))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var result repairResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	return result
+}
+
+func addBlob(t *testing.T, s *testServer, namespace string, blob *core.BlobFixture) {
+	require.NoError(t, s.cas.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+	_, err := s.cas.SetCacheFileMetadata(blob.Digest.Hex(), metadata.NewNamespace(namespace))
+	require.NoError(t, err)
+}
+
+func TestNamespaceReplicasInvalidConfig(t *testing.T) {
+	for _, c := range []NamespaceReplicaConfig{
+		{Namespace: "(", Replicas: 1},
+		{Namespace: ".*", Replicas: 0},
+	} {
+		_, err := newNamespaceReplicas([]NamespaceReplicaConfig{c})
+		require.Error(t, err)
+	}
+}
+
+func TestNamespaceReplicasFirstMatchWins(t *testing.T) {
+	require := require.New(t)
+
+	n, err := newNamespaceReplicas([]NamespaceReplicaConfig{
+		{Namespace: "critical/.*", Replicas: 5},
+		{Namespace: "scratch/.*", Replicas: 1},
+		{Namespace: ".*", Replicas: 3},
+	})
+	require.NoError(err)
+
+	require.Equal(5, n.get("critical/foo"))
+	require.Equal(1, n.get("scratch/foo"))
+	require.Equal(3, n.get("other/foo"))
+	require.Equal(5, n.max)
+}
+
+func TestUploadBlobHonorsNamespaceReplicas(t *testing.T) {
+	require := require.New(t)
+
+	ring := hashRingNoReplica()
+	namespace := core.TagFixture()
+	config := namespaceReplicasConfig(2)
+
+	cp := newTestClientProvider()
+
+	s1 := newTestServerWithConfig(t, config, nil, master1, ring, cp)
+	defer s1.cleanup()
+
+	s2 := newTestServerWithConfig(t, config, nil, master2, ring, cp)
+	defer s2.cleanup()
+
+	blob := computeBlobForReplicas(ring, 2, s1.host, s2.host)
+
+	s1.writeBackManager.EXPECT().Add(
+		writeback.MatchTask(writeback.NewTask(namespace, blob.Digest.Hex(), 0))).Return(nil)
+	s2.writeBackManager.EXPECT().Add(
+		writeback.MatchTask(writeback.NewTask(namespace, blob.Digest.Hex(), 30*time.Minute)))
+
+	err := cp.Provide(s1.host).UploadBlob(namespace, blob.Digest, bytes.NewReader(blob.Content))
+	require.NoError(err)
+
+	ensureHasBlob(t, cp.Provide(s1.host), namespace, blob)
+	ensureHasBlob(t, cp.Provide(s2.host), namespace, blob)
+}
+
+func TestRepairReplicatesToNewReplicas(t *testing.T) {
+	require := require.New(t)
+
+	ring := hashRingNoReplica()
+	namespace := core.TagFixture()
+	config := namespaceReplicasConfig(2)
+
+	cp := newTestClientProvider()
+
+	s1 := newTestServerWithConfig(t, config, nil, master1, ring, cp)
+	defer s1.cleanup()
+
+	s2 := newTestServerWithConfig(t, config, nil, master2, ring, cp)
+	defer s2.cleanup()
+
+	blob := computeBlobForReplicas(ring, 2, s1.host, s2.host)
+
+	// Simulates a blob written while the namespace had a single replica.
+	addBlob(t, s1, namespace, blob)
+
+	result := repair(t, s1)
+	require.Empty(result.Errors)
+	require.Equal([]string{fmt.Sprintf("%s: %s", blob.Digest.Hex(), s2.host)}, result.Replicated)
+	require.Empty(result.Deleted)
+
+	_, err := cp.Provide(s2.host).StatLocal(namespace, blob.Digest)
+	require.NoError(err)
+
+	// Repairs are idempotent.
+	result = repair(t, s1)
+	require.Empty(result.Replicated)
+}
+
+func TestRepairDeletesBlobsNoLongerOwned(t *testing.T) {
+	require := require.New(t)
+
+	ring := hashRingSomeReplica()
+	namespace := core.TagFixture()
+	config := namespaceReplicasConfig(1)
+
+	cp := newTestClientProvider()
+
+	s1 := newTestServerWithConfig(t, config, nil, master1, ring, cp)
+	defer s1.cleanup()
+
+	s2 := newTestServerWithConfig(t, config, nil, master2, ring, cp)
+	defer s2.cleanup()
+
+	blob := computeBlobForReplicas(ring, 1, s1.host)
+	for !stringset.FromSlice(ring.Locations(blob.Digest)).Has(s2.host) {
+		blob = computeBlobForReplicas(ring, 1, s1.host)
+	}
+
+	// Simulates a blob replicated while the namespace had two replicas.
+	addBlob(t, s1, namespace, blob)
+	addBlob(t, s2, namespace, blob)
+
+	result := repair(t, s2)
+	require.Empty(result.Errors)
+	require.Equal([]string{blob.Digest.Hex()}, result.Deleted)
+
+	_, err := cp.Provide(s2.host).StatLocal(namespace, blob.Digest)
+	require.Equal(blobclient.ErrBlobNotFound, err)
+
+	result = repair(t, s1)
+	require.Empty(result.Errors)
+	require.Empty(result.Deleted)
+}
+
+func TestOwnsBlobWithUnknownNamespace(t *testing.T) {
+	require := require.New(t)
+
+	ring := hashRingNoReplica()
+
+	cp := newTestClientProvider()
+
+	s1 := newTestServerWithConfig(t, namespaceReplicasConfig(2), nil, master1, ring, cp)
+	defer s1.cleanup()
+
+	blob := computeBlobForReplicas(ring, 2, master1, master2)
+	for stringset.FromSlice(ring.Locations(blob.Digest)).Has(master1) {
+		blob = computeBlobForReplicas(ring, 2, master1, master2)
+	}
+	require.NoError(s1.cas.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+
+	// The blob may belong to a namespace with two replicas, so it must be kept.
+	result := repair(t, s1)
+	require.Empty(result.Errors)
+	require.Empty(result.Deleted)
+}
+
+func TestUploadBlobReplicatesToRemotes(t *testing.T) {
+	require := require.New(t)
+
+	ring := hashRingSomeReplica()
+	namespace := core.TagFixture()
+
+	remotes, err := blobreplication.RemotesConfig{
+		"remote-origin-1": []string{".*"},
+		"remote-origin-2": []string{".*"},
+	}.Build()
+	require.NoError(err)
+
+	cp := newTestClientProvider()
+
+	s1 := newTestServerWithConfig(t, Config{}, remotes, master1, ring, cp)
+	defer s1.cleanup()
+
+	s2 := newTestServerWithConfig(t, Config{}, remotes, master2, ring, cp)
+	defer s2.cleanup()
+
+	blob := computeBlobForHosts(ring, s1.host, s2.host)
+
+	s1.writeBackManager.EXPECT().Add(
+		writeback.MatchTask(writeback.NewTask(namespace, blob.Digest.Hex(), 0))).Return(nil)
+	s2.writeBackManager.EXPECT().Add(
+		writeback.MatchTask(writeback.NewTask(namespace, blob.Digest.Hex(), 30*time.Minute)))
+
+	// Only the origin which receives the upload replicates to remotes.
+	for _, dest := range []string{"remote-origin-1", "remote-origin-2"} {
+		s1.blobReplicationManager.EXPECT().Add(
+			blobreplication.MatchTask(blobreplication.NewTask(namespace, blob.Digest, dest, 0))).Return(nil)
+	}
+
+	err = cp.Provide(s1.host).UploadBlob(namespace, blob.Digest, bytes.NewReader(blob.Content))
+	require.NoError(err)
+}
+
+func TestUploadBlobResilientToRemoteReplicationFailure(t *testing.T) {
+	require := require.New(t)
+
+	ring := hashRingNoReplica()
+	namespace := core.TagFixture()
+
+	remotes, err := blobreplication.RemotesConfig{"remote-origin": []string{".*"}}.Build()
+	require.NoError(err)
+
+	cp := newTestClientProvider()
+
+	s := newTestServerWithConfig(t, Config{}, remotes, master1, ring, cp)
+	defer s.cleanup()
+
+	blob := computeBlobForHosts(ring, s.host)
+
+	s.writeBackManager.EXPECT().Add(
+		writeback.MatchTask(writeback.NewTask(namespace, blob.Digest.Hex(), 0))).Return(nil)
+	s.blobReplicationManager.EXPECT().Add(
+		blobreplication.MatchTask(blobreplication.NewTask(namespace, blob.Digest, "remote-origin", 0))).Return(
+		errors.New("some error"))
+
+	err = cp.Provide(s.host).UploadBlob(namespace, blob.Digest, bytes.NewReader(blob.Content))
+	require.NoError(err)
+
+	ensureHasBlob(t, cp.Provide(s.host), namespace, blob)
+}
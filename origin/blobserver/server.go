@@ -16,7 +16,6 @@ package blobserver
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	_ "net/http/pprof" // Registers /debug/pprof endpoints in http.DefaultServeMux.
 	"os"
@@ -33,6 +32,7 @@ import (
 	"github.com/uber/kraken/lib/metainfogen"
 	"github.com/uber/kraken/lib/middleware"
 	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/blobreplication"
 	"github.com/uber/kraken/lib/persistedretry/writeback"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/store/metadata"
@@ -67,6 +67,17 @@ type Server struct {
 	metaInfoGenerator *metainfogen.Generator
 	uploader          *uploader
 	writeBackManager  persistedretry.Manager
+	namespaceReplicas *namespaceReplicas
+	namespaceTTLs     namespaceTTLs
+
+	// Replicates uploaded blobs to remote origin clusters.
+	blobRemotes            blobreplication.Remotes
+	blobReplicationManager persistedretry.Manager
+
+	// Set if automatic repairs are enabled.
+	repairController *RepairController
+
+	drainer *drainer
 
 	// This is an unfortunate coupling between the p2p client and the blob server.
 	// Tracker queries the origin cluster to discover which origins can seed
@@ -89,15 +100,27 @@ func New(
 	backends *backend.Manager,
 	blobRefresher *blobrefresh.Refresher,
 	metaInfoGenerator *metainfogen.Generator,
-	writeBackManager persistedretry.Manager) (*Server, error) {
+	writeBackManager persistedretry.Manager,
+	blobRemotes blobreplication.Remotes,
+	blobReplicationManager persistedretry.Manager) (*Server, error) {
 
 	config = config.applyDefaults()
 
+	namespaceReplicas, err := newNamespaceReplicas(config.NamespaceReplicas)
+	if err != nil {
+		return nil, fmt.Errorf("namespace replicas: %s", err)
+	}
+
+	namespaceTTLs, err := newNamespaceTTLs(config.Expiry.Namespaces)
+	if err != nil {
+		return nil, fmt.Errorf("expiry: %s", err)
+	}
+
 	stats = stats.Tagged(map[string]string{
 		"module": "blobserver",
 	})
 
-	return &Server{
+	s := &Server{
 		config:            config,
 		stats:             stats,
 		clk:               clk,
@@ -111,8 +134,20 @@ func New(
 		metaInfoGenerator: metaInfoGenerator,
 		uploader:          newUploader(cas),
 		writeBackManager:  writeBackManager,
+		namespaceReplicas: namespaceReplicas,
+		namespaceTTLs:     namespaceTTLs,
 		pctx:              pctx,
-	}, nil
+
+		blobRemotes:            blobRemotes,
+		blobReplicationManager: blobReplicationManager,
+
+		drainer: &drainer{},
+	}
+	cas.SetCorruptionHook(s.refetchCorruptBlob)
+	if len(namespaceTTLs) > 0 {
+		go s.runExpiry()
+	}
+	return s, nil
 }
 
 // Addr returns the address the blob server is configured on.
@@ -133,25 +168,30 @@ func (s *Server) Handler() http.Handler {
 
 	r.Get("/blobs/{digest}/locations", handler.Wrap(s.getLocationsHandler))
 
-	r.Post("/namespace/{namespace}/blobs/{digest}/uploads", handler.Wrap(s.startClusterUploadHandler))
-	r.Patch("/namespace/{namespace}/blobs/{digest}/uploads/{uid}", handler.Wrap(s.patchClusterUploadHandler))
-	r.Put("/namespace/{namespace}/blobs/{digest}/uploads/{uid}", handler.Wrap(s.commitClusterUploadHandler))
+	r.Post("/blobs/exists", handler.Wrap(s.statBatchHandler))
+
+	r.Post("/namespace/{namespace}/blobs/{digest}/uploads", handler.Wrap(s.writable(s.startClusterUploadHandler)))
+	r.Get("/namespace/{namespace}/blobs/{digest}/uploads/{uid}", handler.Wrap(s.getUploadStatusHandler))
+	r.Patch("/namespace/{namespace}/blobs/{digest}/uploads/{uid}", handler.Wrap(s.writable(s.patchClusterUploadHandler)))
+	r.Put("/namespace/{namespace}/blobs/{digest}/uploads/{uid}", handler.Wrap(s.writable(s.commitClusterUploadHandler)))
 
 	r.Get("/namespace/{namespace}/blobs/{digest}", handler.Wrap(s.downloadBlobHandler))
+	r.Head("/namespace/{namespace}/blobs/{digest}", handler.Wrap(s.downloadBlobHandler))
 
 	r.Post("/namespace/{namespace}/blobs/{digest}/remote/{remote}", handler.Wrap(s.replicateToRemoteHandler))
 
-	r.Post("/forcecleanup", handler.Wrap(s.forceCleanupHandler))
+	r.Post("/forcecleanup", handler.Wrap(s.writable(s.forceCleanupHandler)))
 
 	// Internal endpoints:
 
-	r.Post("/internal/blobs/{digest}/uploads", handler.Wrap(s.startTransferHandler))
-	r.Patch("/internal/blobs/{digest}/uploads/{uid}", handler.Wrap(s.patchTransferHandler))
-	r.Put("/internal/blobs/{digest}/uploads/{uid}", handler.Wrap(s.commitTransferHandler))
+	r.Post("/internal/blobs/{digest}/uploads", handler.Wrap(s.writable(s.startTransferHandler)))
+	r.Get("/internal/blobs/{digest}/uploads/{uid}", handler.Wrap(s.getUploadStatusHandler))
+	r.Patch("/internal/blobs/{digest}/uploads/{uid}", handler.Wrap(s.writable(s.patchTransferHandler)))
+	r.Put("/internal/blobs/{digest}/uploads/{uid}", handler.Wrap(s.writable(s.commitTransferHandler)))
 
-	r.Delete("/internal/blobs/{digest}", handler.Wrap(s.deleteBlobHandler))
+	r.Delete("/internal/blobs/{digest}", handler.Wrap(s.writable(s.deleteBlobHandler)))
 
-	r.Post("/internal/blobs/{digest}/metainfo", handler.Wrap(s.overwriteMetaInfoHandler))
+	r.Post("/internal/blobs/{digest}/metainfo", handler.Wrap(s.writable(s.overwriteMetaInfoHandler)))
 
 	r.Get("/internal/peercontext", handler.Wrap(s.getPeerContextHandler))
 
@@ -161,14 +201,82 @@ func (s *Server) Handler() http.Handler {
 
 	r.Put(
 		"/internal/duplicate/namespace/{namespace}/blobs/{digest}/uploads/{uid}",
-		handler.Wrap(s.duplicateCommitClusterUploadHandler))
+		handler.Wrap(s.writable(s.duplicateCommitClusterUploadHandler)))
+
+	// Admin endpoints:
+
+	r.Get("/x/readonly", handler.Wrap(s.getReadOnlyHandler))
+	r.Put("/x/readonly", handler.Wrap(s.putReadOnlyHandler))
+
+	r.Get("/x/usage", handler.Wrap(s.getUsageHandler))
+
+	r.Post("/x/repair", handler.Wrap(s.writable(s.repairHandler)))
+	r.Get("/x/repair/status", handler.Wrap(s.getRepairStatusHandler))
+
+	r.Post("/x/drain", handler.Wrap(s.startDrainHandler))
+	r.Get("/x/drain/status", handler.Wrap(s.getDrainStatusHandler))
+
+	r.Get("/x/writeback/dead", handler.Wrap(s.listDeadWriteBacksHandler))
+	r.Get("/x/writeback/dead/{name}", handler.Wrap(s.getDeadWriteBackHandler))
+	r.Post("/x/writeback/dead/{name}/retry", handler.Wrap(s.writable(s.retryDeadWriteBackHandler)))
+	r.Delete("/x/writeback/dead/{name}", handler.Wrap(s.writable(s.discardDeadWriteBackHandler)))
 
 	r.Mount("/", http.DefaultServeMux) // Serves /debug/pprof endpoints.
 
 	return r
 }
 
+// errReadOnly returns the error for handlers which would modify the store while
+// it is read-only.
+func errReadOnly() error {
+	return handler.Errorf("origin is read-only").Status(http.StatusServiceUnavailable)
+}
+
+// writable wraps h such that it is rejected while the store is read-only.
+func (s *Server) writable(h handler.ErrHandler) handler.ErrHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if s.cas.ReadOnly() {
+			return errReadOnly()
+		}
+		return h(w, r)
+	}
+}
+
+type readOnlyStatus struct {
+	ReadOnly bool `json:"read_only"`
+}
+
+func (s *Server) getReadOnlyHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := json.NewEncoder(w).Encode(readOnlyStatus{s.cas.ReadOnly()}); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
+// putReadOnlyHandler toggles read-only mode, e.g. for disk maintenance. While
+// read-only, blobs are served and seeded but never added or removed.
+func (s *Server) putReadOnlyHandler(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	var status readOnlyStatus
+	if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+		return handler.Errorf("json decode: %s", err).Status(http.StatusBadRequest)
+	}
+	s.cas.SetReadOnly(status.ReadOnly)
+	return nil
+}
+
 // ListenAndServe is a blocking call which runs s.
+func (s *Server) getUsageHandler(w http.ResponseWriter, r *http.Request) error {
+	usage, err := s.cas.Usage()
+	if err != nil {
+		return handler.Errorf("usage: %s", err)
+	}
+	if err := json.NewEncoder(w).Encode(usage); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
 func (s *Server) ListenAndServe(h http.Handler) error {
 	log.Infof("Starting blob server on %s", s.config.Listener)
 	return listener.Serve(s.config.Listener, h)
@@ -238,11 +346,7 @@ func (s *Server) downloadBlobHandler(w http.ResponseWriter, r *http.Request) err
 	if err != nil {
 		return err
 	}
-	if err := s.downloadBlob(namespace, d, w); err != nil {
-		return err
-	}
-	setOctetStreamContentType(w)
-	return nil
+	return s.downloadBlob(namespace, d, w, r)
 }
 
 func (s *Server) replicateToRemoteHandler(w http.ResponseWriter, r *http.Request) error {
@@ -374,12 +478,13 @@ func (s *Server) getMetaInfo(namespace string, d core.Digest) ([]byte, error) {
 }
 
 type localReplicationHook struct {
-	server *Server
+	server    *Server
+	namespace string
 }
 
 func (h *localReplicationHook) Run(d core.Digest) {
 	timer := h.server.stats.Timer("replicate_blob").Start()
-	if err := h.server.replicateBlobLocally(d); err != nil {
+	if err := h.server.replicateBlobLocally(h.namespace, d); err != nil {
 		// Don't return error here as we only want to cache storage backend errors.
 		log.With("blob", d.Hex()).Errorf("Error replicating remote blob: %s", err)
 		h.server.stats.Counter("replicate_blob_errors").Inc(1)
@@ -391,9 +496,14 @@ func (h *localReplicationHook) Run(d core.Digest) {
 func (s *Server) startRemoteBlobDownload(
 	namespace string, d core.Digest, replicateLocally bool) error {
 
+	if s.cas.ReadOnly() {
+		// Blobs cannot be added to the cache.
+		return errReadOnly()
+	}
+
 	var hooks []blobrefresh.PostHook
 	if replicateLocally {
-		hooks = append(hooks, &localReplicationHook{s})
+		hooks = append(hooks, &localReplicationHook{s, namespace})
 	}
 	err := s.blobRefresher.Refresh(namespace, d, hooks...)
 	switch err {
@@ -408,8 +518,32 @@ func (s *Server) startRemoteBlobDownload(
 	}
 }
 
-func (s *Server) replicateBlobLocally(d core.Digest) error {
-	return s.applyToReplicas(d, func(i int, client blobclient.Client) error {
+// refetchCorruptBlob downloads a blob which was quarantined by the cache
+// scrubber from the backend it was originally written to, so the next
+// request for it does not have to wait for the download.
+func (s *Server) refetchCorruptBlob(name, namespace string) {
+	if namespace == "" {
+		log.With("blob", name).Warn("Not refetching corrupt blob with unknown namespace")
+		return
+	}
+	d, err := core.NewSHA256DigestFromHex(name)
+	if err != nil {
+		log.With("blob", name).Errorf("Error parsing corrupt blob digest: %s", err)
+		return
+	}
+	err = s.blobRefresher.Refresh(namespace, d)
+	switch err {
+	case nil, blobrefresh.ErrPending:
+		s.stats.Counter("refetch_corrupt_blob").Inc(1)
+	default:
+		log.With("blob", name, "namespace", namespace).Errorf(
+			"Error refetching corrupt blob: %s", err)
+		s.stats.Counter("refetch_corrupt_blob_errors").Inc(1)
+	}
+}
+
+func (s *Server) replicateBlobLocally(namespace string, d core.Digest) error {
+	return s.applyToReplicas(namespace, d, func(i int, client blobclient.Client) error {
 		f, err := s.cas.GetCacheFileReader(d.Hex())
 		if err != nil {
 			return fmt.Errorf("get cache reader: %s", err)
@@ -421,10 +555,13 @@ func (s *Server) replicateBlobLocally(d core.Digest) error {
 	})
 }
 
-// applyToReplicas applies f to the replicas of d concurrently in random order,
-// not including the current origin. Passes the index of the iteration to f.
-func (s *Server) applyToReplicas(d core.Digest, f func(i int, c blobclient.Client) error) error {
-	replicas := stringset.FromSlice(s.hashRing.Locations(d))
+// applyToReplicas applies f to the replicas of d under namespace concurrently
+// in random order, not including the current origin. Passes the index of the
+// iteration to f.
+func (s *Server) applyToReplicas(
+	namespace string, d core.Digest, f func(i int, c blobclient.Client) error) error {
+
+	replicas := stringset.FromSlice(s.locations(namespace, d))
 	replicas.Remove(s.addr)
 
 	var mu sync.Mutex
@@ -449,11 +586,15 @@ func (s *Server) applyToReplicas(d core.Digest, f func(i int, c blobclient.Clien
 	return errutil.Join(errs)
 }
 
-// downloadBlob downloads blob for d into dst. If no blob exists under d, a
-// download of the blob from the storage backend configured for namespace will
-// be initiated. This download is asynchronous and downloadBlob will immediately
-// return a "202 Accepted" handler error.
-func (s *Server) downloadBlob(namespace string, d core.Digest, dst io.Writer) error {
+// downloadBlob serves the blob for d, or the byte ranges of it requested by
+// r's Range header. Since blobs are content addressed, d is used as a strong
+// ETag such that clients may resume downloads with If-Range. If no blob exists
+// under d, a download of the blob from the storage backend configured for
+// namespace will be initiated. This download is asynchronous and downloadBlob
+// will immediately return a "202 Accepted" handler error.
+func (s *Server) downloadBlob(
+	namespace string, d core.Digest, w http.ResponseWriter, r *http.Request) error {
+
 	f, err := s.cas.GetCacheFileReader(d.Hex())
 	if os.IsNotExist(err) {
 		return s.startRemoteBlobDownload(namespace, d, true)
@@ -462,9 +603,9 @@ func (s *Server) downloadBlob(namespace string, d core.Digest, dst io.Writer) er
 	}
 	defer f.Close()
 
-	if _, err := io.Copy(dst, f); err != nil {
-		return handler.Errorf("copy blob: %s", err)
-	}
+	setOctetStreamContentType(w)
+	w.Header().Set("ETag", fmt.Sprintf("%q", d.Hex()))
+	http.ServeContent(w, r, "", time.Time{}, f)
 	return nil
 }
 
@@ -515,6 +656,33 @@ func (s *Server) patchTransferHandler(w http.ResponseWriter, r *http.Request) er
 	return s.uploader.patch(d, uid, r.Body, start, end)
 }
 
+// uploadStatus describes an in-progress upload.
+type uploadStatus struct {
+	// Offset is the number of bytes received. Interrupted uploads resume by
+	// patching from Offset.
+	Offset int64 `json:"offset"`
+}
+
+// getUploadStatusHandler returns the uploadStatus of a cluster upload or
+// internal transfer.
+func (s *Server) getUploadStatusHandler(w http.ResponseWriter, r *http.Request) error {
+	if _, err := httputil.ParseDigest(r, "digest"); err != nil {
+		return err
+	}
+	uid, err := httputil.ParseParam(r, "uid")
+	if err != nil {
+		return err
+	}
+	offset, err := s.uploader.offset(uid)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(w).Encode(uploadStatus{offset}); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
 // commitTransferHandler commits the upload of an internal blob transfer.
 // Internal blob transfers are not replicated to the rest of the cluster.
 func (s *Server) commitTransferHandler(w http.ResponseWriter, r *http.Request) error {
@@ -614,7 +782,7 @@ func (s *Server) commitClusterUploadHandler(w http.ResponseWriter, r *http.Reque
 	if err := s.writeBack(namespace, d, 0); err != nil {
 		return err
 	}
-	err = s.applyToReplicas(d, func(i int, client blobclient.Client) error {
+	err = s.applyToReplicas(namespace, d, func(i int, client blobclient.Client) error {
 		delay := s.config.DuplicateWriteBackStagger * time.Duration(i+1)
 		f, err := s.cas.GetCacheFileReader(d.Hex())
 		if err != nil {
@@ -629,9 +797,26 @@ func (s *Server) commitClusterUploadHandler(w http.ResponseWriter, r *http.Reque
 		s.stats.Counter("duplicate_write_back_errors").Inc(1)
 		log.Errorf("Error duplicating write-back task to replicas: %s", err)
 	}
+	s.replicateToRemotes(namespace, d)
 	return nil
 }
 
+// replicateToRemotes adds tasks to asynchronously replicate the blob of d to
+// the remote origin clusters configured for namespace. Only the origin which
+// receives the upload replicates, not its replicas.
+func (s *Server) replicateToRemotes(namespace string, d core.Digest) {
+	for _, dest := range s.blobRemotes.Match(namespace) {
+		task := blobreplication.NewTask(namespace, d, dest, 0)
+		if err := s.blobReplicationManager.Add(task); err != nil && err != persistedretry.ErrTaskExists {
+			s.stats.Counter("blob_replication_errors").Inc(1)
+			log.With(
+				"namespace", namespace,
+				"digest", d.Hex(),
+				"dest", dest).Errorf("Error adding blob replication task: %s", err)
+		}
+	}
+}
+
 // duplicateCommitClusterUploadHandler commits a duplicate blob upload, which
 // will attempt to write-back after the requested delay.
 func (s *Server) duplicateCommitClusterUploadHandler(w http.ResponseWriter, r *http.Request) error {
@@ -664,6 +849,9 @@ func (s *Server) writeBack(namespace string, d core.Digest, delay time.Duration)
 	if _, err := s.cas.SetCacheFileMetadata(d.Hex(), metadata.NewPersist(true)); err != nil {
 		return handler.Errorf("set persist metadata: %s", err)
 	}
+	if _, err := s.cas.SetCacheFileMetadata(d.Hex(), metadata.NewNamespace(namespace)); err != nil {
+		return handler.Errorf("set namespace metadata: %s", err)
+	}
 	task := writeback.NewTask(namespace, d.Hex(), delay)
 	if err := s.writeBackManager.Add(task); err != nil {
 		return handler.Errorf("add write-back task: %s", err)
@@ -717,34 +905,45 @@ func (s *Server) maybeDelete(name string, ttl time.Duration) (deleted bool, err
 		return false, fmt.Errorf("store: %s", err)
 	}
 	expired := s.clk.Now().Sub(info.ModTime()) > ttl
-	owns := stringset.FromSlice(s.hashRing.Locations(d)).Has(s.addr)
+	owns, err := s.owns(d)
+	if err != nil {
+		return false, err
+	}
 	if expired || !owns {
-		// Ensure file is backed up properly before deleting.
-		var pm metadata.Persist
-		if err := s.cas.GetCacheFileMetadata(name, &pm); err != nil && !os.IsNotExist(err) {
-			return false, fmt.Errorf("store: %s", err)
+		if err := s.persistAndDelete(name); err != nil {
+			return false, err
 		}
-		if pm.Value {
-			// Note: It is possible that no writeback tasks exist, but the file
-			// is persisted. We classify this as a leaked file which is safe to
-			// delete.
-			tasks, err := s.writeBackManager.Find(writeback.NewNameQuery(name))
-			if err != nil {
-				return false, fmt.Errorf("find writeback tasks: %s", err)
-			}
-			for _, task := range tasks {
-				if err := s.writeBackManager.SyncExec(task); err != nil {
-					return false, fmt.Errorf("writeback: %s", err)
-				}
-			}
-			if err := s.cas.DeleteCacheFileMetadata(name, &metadata.Persist{}); err != nil {
-				return false, fmt.Errorf("delete persist: %s", err)
+		return true, nil
+	}
+	return false, nil
+}
+
+// persistAndDelete deletes the blob of name, first executing any pending
+// write-back tasks for it.
+func (s *Server) persistAndDelete(name string) error {
+	var pm metadata.Persist
+	if err := s.cas.GetCacheFileMetadata(name, &pm); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("store: %s", err)
+	}
+	if pm.Value {
+		// Note: It is possible that no writeback tasks exist, but the file
+		// is persisted. We classify this as a leaked file which is safe to
+		// delete.
+		tasks, err := s.writeBackManager.Find(writeback.NewNameQuery(name))
+		if err != nil {
+			return fmt.Errorf("find writeback tasks: %s", err)
+		}
+		for _, task := range tasks {
+			if err := s.writeBackManager.SyncExec(task); err != nil {
+				return fmt.Errorf("writeback: %s", err)
 			}
 		}
-		if err := s.cas.DeleteCacheFile(name); err != nil {
-			return false, fmt.Errorf("delete: %s", err)
+		if err := s.cas.DeleteCacheFileMetadata(name, &metadata.Persist{}); err != nil {
+			return fmt.Errorf("delete persist: %s", err)
 		}
-		return true, nil
 	}
-	return false, nil
+	if err := s.cas.DeleteCacheFile(name); err != nil {
+		return fmt.Errorf("delete: %s", err)
+	}
+	return nil
 }
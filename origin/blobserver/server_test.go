@@ -15,10 +15,12 @@ package blobserver
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"testing"
 	"time"
 
@@ -29,6 +31,7 @@ import (
 	"github.com/uber/kraken/lib/backend/backenderrors"
 	"github.com/uber/kraken/lib/persistedretry"
 	"github.com/uber/kraken/lib/persistedretry/writeback"
+	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/store/metadata"
 	"github.com/uber/kraken/origin/blobclient"
 	"github.com/uber/kraken/utils/httputil"
@@ -200,6 +203,137 @@ func TestDownloadBlobNotFound(t *testing.T) {
 	require.Equal(http.StatusNotFound, err.(httputil.StatusError).Status)
 }
 
+func TestDownloadBlobRange(t *testing.T) {
+	require := require.New(t)
+
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), cp)
+	defer s.cleanup()
+
+	client := cp.Provide(s.host)
+	blob := core.SizedBlobFixture(256, 8)
+	namespace := core.TagFixture()
+
+	require.NoError(client.TransferBlob(blob.Digest, bytes.NewReader(blob.Content)))
+
+	var b bytes.Buffer
+	require.NoError(client.DownloadBlobRange(namespace, blob.Digest, 16, 24, &b))
+	require.Equal(blob.Content[16:24], b.Bytes())
+}
+
+func blobURL(s *testServer, namespace string, d core.Digest) string {
+	return fmt.Sprintf("http://%s/namespace/%s/blobs/%s", s.addr, url.PathEscape(namespace), d)
+}
+
+func TestDownloadBlobAdvertisesRangeSupport(t *testing.T) {
+	require := require.New(t)
+
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), cp)
+	defer s.cleanup()
+
+	blob := core.SizedBlobFixture(256, 8)
+	namespace := core.TagFixture()
+
+	require.NoError(cp.Provide(s.host).TransferBlob(blob.Digest, bytes.NewReader(blob.Content)))
+
+	for _, send := range []func(string, ...httputil.SendOption) (*http.Response, error){
+		httputil.Get, httputil.Head,
+	} {
+		resp, err := send(blobURL(s, namespace, blob.Digest))
+		require.NoError(err)
+		resp.Body.Close()
+		require.Equal("bytes", resp.Header.Get("Accept-Ranges"))
+		require.Equal(fmt.Sprintf("%q", blob.Digest.Hex()), resp.Header.Get("ETag"))
+		require.Equal("256", resp.Header.Get("Content-Length"))
+	}
+}
+
+func TestDownloadBlobSuffixRange(t *testing.T) {
+	require := require.New(t)
+
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), cp)
+	defer s.cleanup()
+
+	blob := core.SizedBlobFixture(256, 8)
+	namespace := core.TagFixture()
+
+	require.NoError(cp.Provide(s.host).TransferBlob(blob.Digest, bytes.NewReader(blob.Content)))
+
+	resp, err := httputil.Get(
+		blobURL(s, namespace, blob.Digest),
+		httputil.SendHeaders(map[string]string{"Range": "bytes=-10"}),
+		httputil.SendAcceptedCodes(http.StatusPartialContent))
+	require.NoError(err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(err)
+	require.Equal(blob.Content[246:], b)
+	require.Equal("bytes 246-255/256", resp.Header.Get("Content-Range"))
+}
+
+func TestDownloadBlobUnsatisfiableRange(t *testing.T) {
+	require := require.New(t)
+
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), cp)
+	defer s.cleanup()
+
+	blob := core.SizedBlobFixture(256, 8)
+	namespace := core.TagFixture()
+
+	client := cp.Provide(s.host)
+	require.NoError(client.TransferBlob(blob.Digest, bytes.NewReader(blob.Content)))
+
+	err := client.DownloadBlobRange(namespace, blob.Digest, 300, 400, ioutil.Discard)
+	require.True(httputil.IsStatus(err, http.StatusRequestedRangeNotSatisfiable))
+}
+
+func TestDownloadBlobIfRangeMismatchReturnsWholeBlob(t *testing.T) {
+	require := require.New(t)
+
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), cp)
+	defer s.cleanup()
+
+	blob := core.SizedBlobFixture(256, 8)
+	namespace := core.TagFixture()
+
+	require.NoError(cp.Provide(s.host).TransferBlob(blob.Digest, bytes.NewReader(blob.Content)))
+
+	resp, err := httputil.Get(
+		blobURL(s, namespace, blob.Digest),
+		httputil.SendHeaders(map[string]string{
+			"Range":    "bytes=16-23",
+			"If-Range": `"some-other-etag"`,
+		}))
+	require.NoError(err)
+	defer resp.Body.Close()
+	require.Equal(http.StatusOK, resp.StatusCode)
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(err)
+	require.Equal(blob.Content, b)
+}
+
+func TestDownloadBlobRangeInvalidRange(t *testing.T) {
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), cp)
+	defer s.cleanup()
+
+	client := cp.Provide(s.host)
+	d := core.DigestFixture()
+
+	require.Error(t, client.DownloadBlobRange(core.TagFixture(), d, 10, 10, ioutil.Discard))
+	require.Error(t, client.DownloadBlobRange(core.TagFixture(), d, -1, 10, ioutil.Discard))
+}
+
 func TestDeleteBlob(t *testing.T) {
 	require := require.New(t)
 
@@ -801,3 +935,130 @@ func TestForceCleanupWriteBackFailures(t *testing.T) {
 
 	ensureHasBlob(t, client, namespace, blob)
 }
+
+func TestReadOnly(t *testing.T) {
+	require := require.New(t)
+
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), cp)
+	defer s.cleanup()
+
+	client := cp.Provide(master1)
+
+	blob := core.NewBlobFixture()
+	namespace := core.TagFixture()
+	require.NoError(client.TransferBlob(blob.Digest, bytes.NewReader(blob.Content)))
+
+	setReadOnly := func(readOnly bool) {
+		_, err := httputil.Put(
+			fmt.Sprintf("http://%s/x/readonly", s.addr),
+			httputil.SendBody(bytes.NewBufferString(fmt.Sprintf(`{"read_only": %t}`, readOnly))))
+		require.NoError(err)
+	}
+
+	setReadOnly(true)
+
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/x/readonly", s.addr))
+	require.NoError(err)
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(err)
+	require.JSONEq(`{"read_only": true}`, string(b))
+
+	// Reads are still served.
+	ensureHasBlob(t, client, namespace, blob)
+
+	// Writes and deletes are rejected.
+	other := core.NewBlobFixture()
+	err = client.TransferBlob(other.Digest, bytes.NewReader(other.Content))
+	require.Error(err)
+	require.True(httputil.IsStatus(err, http.StatusServiceUnavailable))
+
+	err = client.DeleteBlob(blob.Digest)
+	require.Error(err)
+	require.True(httputil.IsStatus(err, http.StatusServiceUnavailable))
+
+	setReadOnly(false)
+
+	require.NoError(client.DeleteBlob(blob.Digest))
+}
+
+func TestGetUsage(t *testing.T) {
+	require := require.New(t)
+
+	ring := hashRingNoReplica()
+	namespace := core.TagFixture()
+
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, ring, cp)
+	defer s.cleanup()
+
+	uploaded := computeBlobForHosts(ring, s.host)
+	s.writeBackManager.EXPECT().Add(
+		writeback.MatchTask(writeback.NewTask(namespace, uploaded.Digest.Hex(), 0))).Return(nil)
+	require.NoError(cp.Provide(s.host).UploadBlob(
+		namespace, uploaded.Digest, bytes.NewReader(uploaded.Content)))
+
+	transferred := computeBlobForHosts(ring, s.host)
+	require.NoError(cp.Provide(s.host).TransferBlob(
+		transferred.Digest, bytes.NewReader(transferred.Content)))
+
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/x/usage", s.addr))
+	require.NoError(err)
+
+	var result store.Usage
+	require.NoError(json.NewDecoder(resp.Body).Decode(&result))
+	require.Equal(2, result.States["cache"].Files)
+	require.Equal(
+		store.UsageStat{Files: 1, Bytes: int64(len(uploaded.Content))},
+		result.Namespaces[namespace])
+	require.Equal(
+		store.UsageStat{Files: 1, Bytes: int64(len(transferred.Content))},
+		result.Namespaces[""])
+}
+
+func TestResumeTransfer(t *testing.T) {
+	require := require.New(t)
+
+	cp := newTestClientProvider()
+	s := newTestServer(t, master1, hashRingMaxReplica(), cp)
+	defer s.cleanup()
+
+	blob := core.NewBlobFixture()
+	half := int64(len(blob.Content) / 2)
+
+	resp, err := httputil.Post(
+		fmt.Sprintf("http://%s/internal/blobs/%s/uploads", s.addr, blob.Digest))
+	require.NoError(err)
+	uid := resp.Header.Get("Location")
+	url := fmt.Sprintf("http://%s/internal/blobs/%s/uploads/%s", s.addr, blob.Digest, uid)
+
+	patch := func(start, end int64) error {
+		_, err := httputil.Patch(
+			url,
+			httputil.SendBody(bytes.NewReader(blob.Content[start:end])),
+			httputil.SendHeaders(map[string]string{
+				"Content-Range": fmt.Sprintf("%d-%d", start, end),
+			}))
+		return err
+	}
+	require.NoError(patch(0, half))
+
+	// Chunks may not leave a gap.
+	err = patch(half+1, int64(len(blob.Content)))
+	require.Error(err)
+	require.True(httputil.IsStatus(err, http.StatusRequestedRangeNotSatisfiable))
+
+	resp, err = httputil.Get(url)
+	require.NoError(err)
+	var status uploadStatus
+	require.NoError(json.NewDecoder(resp.Body).Decode(&status))
+	require.Equal(half, status.Offset)
+
+	require.NoError(patch(status.Offset, int64(len(blob.Content))))
+	_, err = httputil.Put(url)
+	require.NoError(err)
+
+	ensureHasBlob(t, cp.Provide(master1), core.TagFixture(), blob)
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/uber/kraken/origin/blobclient"
+	"github.com/uber/kraken/utils/errutil"
+	"github.com/uber/kraken/utils/handler"
+)
+
+// _statBatchWorkers limits the number of concurrent stats per batch, which may
+// fall back to the storage backend.
+const _statBatchWorkers = 16
+
+// statBatchHandler checks the existence of many blobs in a single request,
+// such that clients checking e.g. every layer of an image need not send a
+// request per blob.
+func (s *Server) statBatchHandler(w http.ResponseWriter, r *http.Request) error {
+	var req blobclient.StatBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return handler.Errorf("json decode: %s", err).Status(http.StatusBadRequest)
+	}
+	if req.Namespace == "" {
+		return handler.Errorf("namespace required").Status(http.StatusBadRequest)
+	}
+	if len(req.Digests) > blobclient.MaxStatBatchSize {
+		return handler.Errorf(
+			"batch of %d digests exceeds limit of %d",
+			len(req.Digests), blobclient.MaxStatBatchSize).Status(http.StatusBadRequest)
+	}
+
+	results := make([]blobclient.StatBatchResult, len(req.Digests))
+	var mu sync.Mutex
+	var errs []error
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < _statBatchWorkers && i < len(req.Digests); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				d := req.Digests[i]
+				results[i].Digest = d
+				bi, err := s.stat(req.Namespace, d, req.Local)
+				if os.IsNotExist(err) {
+					continue
+				} else if err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("stat %s: %s", d, err))
+					mu.Unlock()
+					continue
+				}
+				results[i].Exists = true
+				results[i].Size = bi.Size
+			}
+		}()
+	}
+	for i := range req.Digests {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	if err := errutil.Join(errs); err != nil {
+		return handler.Errorf("%s", err)
+	}
+	s.stats.Counter("stat_batch_digests").Inc(int64(len(req.Digests)))
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/backend/backenderrors"
+	"github.com/uber/kraken/origin/blobclient"
+	"github.com/uber/kraken/utils/httputil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatBatch(t *testing.T) {
+	require := require.New(t)
+
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), cp)
+	defer s.cleanup()
+
+	namespace := core.TagFixture()
+	backendClient := s.backendClient(namespace)
+
+	local := core.SizedBlobFixture(256, 8)
+	addBlob(t, s, namespace, local)
+
+	remote := core.DigestFixture()
+	missing := core.DigestFixture()
+
+	backendClient.EXPECT().Stat(namespace, remote.Hex()).Return(core.NewBlobInfo(128), nil)
+	backendClient.EXPECT().Stat(namespace, missing.Hex()).Return(nil, backenderrors.ErrBlobNotFound)
+
+	result, err := cp.Provide(s.host).StatBatch(
+		namespace, []core.Digest{local.Digest, remote, missing})
+	require.NoError(err)
+	require.Equal(map[core.Digest]*core.BlobInfo{
+		local.Digest: core.NewBlobInfo(256),
+		remote:       core.NewBlobInfo(128),
+	}, result)
+}
+
+func TestStatBatchLocal(t *testing.T) {
+	require := require.New(t)
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), newTestClientProvider())
+	defer s.cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(256, 8)
+	addBlob(t, s, namespace, blob)
+	missing := core.DigestFixture()
+
+	b, err := json.Marshal(blobclient.StatBatchRequest{
+		Namespace: namespace,
+		Digests:   []core.Digest{blob.Digest, missing},
+		Local:     true,
+	})
+	require.NoError(err)
+
+	resp, err := httputil.Post(
+		fmt.Sprintf("http://%s/blobs/exists", s.addr), httputil.SendBody(bytes.NewReader(b)))
+	require.NoError(err)
+	defer resp.Body.Close()
+
+	var results []blobclient.StatBatchResult
+	require.NoError(json.NewDecoder(resp.Body).Decode(&results))
+	require.Equal([]blobclient.StatBatchResult{
+		{Digest: blob.Digest, Exists: true, Size: 256},
+		{Digest: missing},
+	}, results)
+}
+
+func TestStatBatchBackendError(t *testing.T) {
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), cp)
+	defer s.cleanup()
+
+	namespace := core.TagFixture()
+	backendClient := s.backendClient(namespace)
+
+	d := core.DigestFixture()
+
+	backendClient.EXPECT().Stat(namespace, d.Hex()).Return(nil, fmt.Errorf("some error"))
+
+	_, err := cp.Provide(s.host).StatBatch(namespace, []core.Digest{d})
+	require.True(t, httputil.IsStatus(err, http.StatusInternalServerError))
+}
+
+func TestStatBatchInvalidRequests(t *testing.T) {
+	s := newTestServer(t, master1, hashRingMaxReplica(), newTestClientProvider())
+	defer s.cleanup()
+
+	tooMany := make([]core.Digest, blobclient.MaxStatBatchSize+1)
+	for i := range tooMany {
+		tooMany[i] = core.DigestFixture()
+	}
+	tooManyBody, err := json.Marshal(blobclient.StatBatchRequest{Namespace: "foo", Digests: tooMany})
+	require.NoError(t, err)
+
+	noNamespaceBody, err := json.Marshal(
+		blobclient.StatBatchRequest{Digests: []core.Digest{core.DigestFixture()}})
+	require.NoError(t, err)
+
+	for _, body := range [][]byte{[]byte("invalid"), tooManyBody, noNamespaceBody} {
+		_, err := httputil.Post(
+			fmt.Sprintf("http://%s/blobs/exists", s.addr), httputil.SendBody(bytes.NewReader(body)))
+		require.True(t, httputil.IsStatus(err, http.StatusBadRequest))
+	}
+}
@@ -32,6 +32,7 @@ import (
 	"github.com/uber/kraken/lib/healthcheck"
 	"github.com/uber/kraken/lib/hostlist"
 	"github.com/uber/kraken/lib/metainfogen"
+	"github.com/uber/kraken/lib/persistedretry/blobreplication"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/mocks/lib/backend"
 	"github.com/uber/kraken/mocks/lib/persistedretry"
@@ -102,11 +103,25 @@ type testServer struct {
 	writeBackManager *mockpersistedretry.MockManager
 	clk              *clock.Mock
 	cleanup          func()
+
+	blobReplicationManager *mockpersistedretry.MockManager
+	server                 *Server
 }
 
 func newTestServer(
 	t *testing.T, host string, ring hashring.Ring, cp *testClientProvider) *testServer {
 
+	return newTestServerWithConfig(t, Config{}, nil, host, ring, cp)
+}
+
+func newTestServerWithConfig(
+	t *testing.T,
+	config Config,
+	blobRemotes blobreplication.Remotes,
+	host string,
+	ring hashring.Ring,
+	cp *testClientProvider) *testServer {
+
 	var cleanup testutil.Cleanup
 	defer cleanup.Recover()
 
@@ -124,6 +139,8 @@ func newTestServer(
 
 	writeBackManager := mockpersistedretry.NewMockManager(ctrl)
 
+	blobReplicationManager := mockpersistedretry.NewMockManager(ctrl)
+
 	mg := metainfogen.Fixture(cas, 4)
 
 	br := blobrefresh.New(blobrefresh.Config{}, tally.NoopScope, cas, bm, mg)
@@ -132,8 +149,8 @@ func newTestServer(
 	clk.Set(time.Now())
 
 	s, err := New(
-		Config{}, tally.NoopScope, clk, host, ring, cas, cp, clusterProvider, pctx,
-		bm, br, mg, writeBackManager)
+		config, tally.NoopScope, clk, host, ring, cas, cp, clusterProvider, pctx,
+		bm, br, mg, writeBackManager, blobRemotes, blobReplicationManager)
 	if err != nil {
 		panic(err)
 	}
@@ -155,6 +172,9 @@ func newTestServer(
 		writeBackManager: writeBackManager,
 		clk:              clk,
 		cleanup:          cleanup.Run,
+
+		blobReplicationManager: blobReplicationManager,
+		server:                 s,
 	}
 }
 
@@ -184,6 +204,19 @@ func computeBlobForHosts(ring hashring.Ring, hosts ...string) *core.BlobFixture
 	}
 }
 
+// computeBlobForReplicas generates a random digest / content whose replica set
+// of size replicas is hosts.
+func computeBlobForReplicas(ring hashring.Ring, replicas int, hosts ...string) *core.BlobFixture {
+	want := stringset.New(hosts...)
+	for {
+		blob := core.SizedBlobFixture(32, 4)
+		got := stringset.New(ring.ReplicaLocations(blob.Digest, replicas)...)
+		if stringset.Equal(want, got) {
+			return blob
+		}
+	}
+}
+
 func ensureHasBlob(t *testing.T, c blobclient.Client, namespace string, blob *core.BlobFixture) {
 	var buf bytes.Buffer
 	require.NoError(t, c.DownloadBlob(namespace, blob.Digest, &buf))
@@ -54,23 +54,35 @@ func (u *uploader) patch(
 	} else if ok {
 		return handler.ErrorStatus(http.StatusConflict)
 	}
-	f, err := u.cas.GetUploadFileReadWriter(uid)
+	n, err := u.cas.AppendUploadFile(uid, start, io.LimitReader(chunk, end-start))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return handler.ErrorStatus(http.StatusNotFound)
 		}
-		return handler.Errorf("get upload file: %s", err)
-	}
-	defer f.Close()
-	if _, err := f.Seek(start, 0); err != nil {
-		return handler.Errorf("seek offset %d: %s", start, err).Status(http.StatusBadRequest)
+		if _, ok := err.(store.UploadOffsetError); ok {
+			return handler.Errorf("%s", err).Status(http.StatusRequestedRangeNotSatisfiable)
+		}
+		return handler.Errorf("append upload file: %s", err)
 	}
-	if _, err := io.CopyN(f, chunk, end-start); err != nil {
-		return handler.Errorf("copy: %s", err)
+	if n != end {
+		return handler.Errorf("chunk ended at %d, expected %d", n, end).Status(http.StatusBadRequest)
 	}
 	return nil
 }
 
+// offset returns the number of bytes written to upload uid, from which the
+// upload may be resumed.
+func (u *uploader) offset(uid string) (int64, error) {
+	n, err := u.cas.UploadFileOffset(uid)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, handler.ErrorStatus(http.StatusNotFound)
+		}
+		return 0, handler.Errorf("upload file offset: %s", err)
+	}
+	return n, nil
+}
+
 func (u *uploader) commit(d core.Digest, uid string) error {
 	if err := u.cas.MoveUploadFileToCache(uid, d.Hex()); err != nil {
 		if os.IsNotExist(err) {
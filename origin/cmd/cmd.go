@@ -16,9 +16,10 @@ package cmd
 import (
 	"encoding/json"
 	"flag"
-	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/backend"
@@ -28,6 +29,7 @@ import (
 	"github.com/uber/kraken/lib/hostlist"
 	"github.com/uber/kraken/lib/metainfogen"
 	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/blobreplication"
 	"github.com/uber/kraken/lib/persistedretry/writeback"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/torrent/networkevent"
@@ -218,7 +220,14 @@ func Run(flags *Flags, opts ...Option) {
 	}
 
 	sched, err := scheduler.NewOriginScheduler(
-		config.Scheduler, stats, pctx, cas, netevents, blobRefresher)
+		config.Scheduler,
+		config.TorrentArchiveBackend,
+		config.TorrentArchive,
+		stats,
+		pctx,
+		cas,
+		netevents,
+		blobRefresher)
 	if err != nil {
 		log.Fatalf("Error creating scheduler: %s", err)
 	}
@@ -235,14 +244,19 @@ func Run(flags *Flags, opts ...Option) {
 
 	healthCheckFilter := healthcheck.NewFilter(config.HealthCheck, healthcheck.Default(tls))
 
-	hashRing := hashring.New(
-		config.HashRing,
-		cluster,
-		healthCheckFilter,
-		hashring.WithWatcher(backend.NewBandwidthWatcher(backendManager)))
+	ringOpts := []hashring.Option{
+		hashring.WithWatcher(backend.NewBandwidthWatcher(backendManager)),
+	}
+	var repairController *blobserver.RepairController
+	if config.BlobServer.Repair.Enabled {
+		repairController = blobserver.NewRepairController(config.BlobServer.Repair, stats, clock.New())
+		ringOpts = append(ringOpts, hashring.WithWatcher(repairController))
+	}
+
+	hashRing := hashring.New(config.HashRing, cluster, healthCheckFilter, ringOpts...)
 	go hashRing.Monitor(nil)
 
-	addr := fmt.Sprintf("%s:%d", hostname, flags.BlobServerPort)
+	addr := net.JoinHostPort(hostname, strconv.Itoa(flags.BlobServerPort))
 	if !hashRing.Contains(addr) {
 		// When DNS is used for hash ring membership, the members will be IP
 		// addresses instead of hostnames.
@@ -250,7 +264,7 @@ func Run(flags *Flags, opts ...Option) {
 		if err != nil {
 			log.Fatalf("Error getting local ip: %s", err)
 		}
-		addr = fmt.Sprintf("%s:%d", ip, flags.BlobServerPort)
+		addr = net.JoinHostPort(ip, strconv.Itoa(flags.BlobServerPort))
 		if !hashRing.Contains(addr) {
 			log.Fatalf(
 				"Neither %s nor %s (port %d) found in hash ring",
@@ -258,6 +272,24 @@ func Run(flags *Flags, opts ...Option) {
 		}
 	}
 
+	blobRemotes, err := config.BlobRemotes.Build()
+	if err != nil {
+		log.Fatalf("Error building blob remotes from configuration: %s", err)
+	}
+	blobReplicationStore, err := blobreplication.NewStore(localDB, blobRemotes)
+	if err != nil {
+		log.Fatalf("Error creating blob replication store: %s", err)
+	}
+	blobReplicationManager, err := persistedretry.NewManager(
+		config.BlobReplication,
+		stats,
+		blobReplicationStore,
+		blobreplication.NewExecutor(
+			stats, cas, blobclient.NewClusterProvider(blobclient.WithTLS(tls))))
+	if err != nil {
+		log.Fatalf("Error creating blob replication manager: %s", err)
+	}
+
 	server, err := blobserver.New(
 		config.BlobServer,
 		stats,
@@ -271,10 +303,15 @@ func Run(flags *Flags, opts ...Option) {
 		backendManager,
 		blobRefresher,
 		metaInfoGenerator,
-		writeBackManager)
+		writeBackManager,
+		blobRemotes,
+		blobReplicationManager)
 	if err != nil {
 		log.Fatalf("Error initializing blob server: %s", err)
 	}
+	if repairController != nil {
+		server.StartRepairController(repairController)
+	}
 
 	h := addTorrentDebugEndpoints(server.Handler(), sched)
 
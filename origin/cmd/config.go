@@ -22,6 +22,7 @@ import (
 	"github.com/uber/kraken/lib/hostlist"
 	"github.com/uber/kraken/lib/metainfogen"
 	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/blobreplication"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler"
@@ -37,23 +38,30 @@ import (
 // Config defines origin server configuration.
 // TODO(evelynl94): consolidate cluster and hashring.
 type Config struct {
-	Verbose       bool
-	ZapLogging    zap.Config               `yaml:"zap"`
-	Cluster       hostlist.Config          `yaml:"cluster"`
-	HashRing      hashring.Config          `yaml:"hashring"`
-	HealthCheck   healthcheck.FilterConfig `yaml:"healthcheck"`
-	BlobServer    blobserver.Config        `yaml:"blobserver"`
-	CAStore       store.CAStoreConfig      `yaml:"castore"`
-	Scheduler     scheduler.Config         `yaml:"scheduler"`
-	NetworkEvent  networkevent.Config      `yaml:"network_event"`
-	PeerIDFactory core.PeerIDFactory       `yaml:"peer_id_factory"`
-	Metrics       metrics.Config           `yaml:"metrics"`
-	MetaInfoGen   metainfogen.Config       `yaml:"metainfogen"`
-	Backends      []backend.Config         `yaml:"backends"`
-	Auth          backend.AuthConfig       `yaml:"auth"`
-	BlobRefresh   blobrefresh.Config       `yaml:"blobrefresh"`
-	LocalDB       localdb.Config           `yaml:"localdb"`
-	WriteBack     persistedretry.Config    `yaml:"writeback"`
-	Nginx         nginx.Config             `yaml:"nginx"`
-	TLS           httputil.TLSConfig       `yaml:"tls"`
+	Verbose               bool
+	ZapLogging            zap.Config               `yaml:"zap"`
+	Cluster               hostlist.Config          `yaml:"cluster"`
+	HashRing              hashring.Config          `yaml:"hashring"`
+	HealthCheck           healthcheck.FilterConfig `yaml:"healthcheck"`
+	BlobServer            blobserver.Config        `yaml:"blobserver"`
+	CAStore               store.CAStoreConfig      `yaml:"castore"`
+	Scheduler             scheduler.Config         `yaml:"scheduler"`
+	TorrentArchiveBackend string                   `yaml:"torrent_archive_backend"`
+	TorrentArchive        interface{}              `yaml:"torrent_archive"`
+	NetworkEvent          networkevent.Config      `yaml:"network_event"`
+	PeerIDFactory         core.PeerIDFactory       `yaml:"peer_id_factory"`
+	Metrics               metrics.Config           `yaml:"metrics"`
+	MetaInfoGen           metainfogen.Config       `yaml:"metainfogen"`
+	Backends              []backend.Config         `yaml:"backends"`
+	Auth                  backend.AuthConfig       `yaml:"auth"`
+	BlobRefresh           blobrefresh.Config       `yaml:"blobrefresh"`
+	LocalDB               localdb.Config           `yaml:"localdb"`
+	WriteBack             persistedretry.Config    `yaml:"writeback"`
+	Nginx                 nginx.Config             `yaml:"nginx"`
+	TLS                   httputil.TLSConfig       `yaml:"tls"`
+
+	// BlobRemotes maps remote origin clusters to the namespaces whose uploaded
+	// blobs are asynchronously replicated to them.
+	BlobRemotes     blobreplication.RemotesConfig `yaml:"blob_remotes"`
+	BlobReplication persistedretry.Config         `yaml:"blob_replication"`
 }
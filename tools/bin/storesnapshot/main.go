@@ -0,0 +1,109 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/uber/kraken/lib/store"
+	_ "github.com/uber/kraken/lib/torrent/storage/agentstorage" // Registers torrent metadata.
+	"github.com/uber/kraken/utils/configutil"
+
+	"github.com/uber-go/tally"
+)
+
+type appConfig struct {
+	Store store.CADownloadStoreConfig `yaml:"store"`
+}
+
+func export(s *store.CADownloadStore, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	n, err := s.Export(gw)
+	if err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return fmt.Errorf("close gzip: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("exported %d files to %s\n", n, path)
+	return nil
+}
+
+func importSnapshot(s *store.CADownloadStore, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("new gzip reader: %s", err)
+	}
+	n, err := s.Import(gr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d files from %s\n", n, path)
+	return nil
+}
+
+// storesnapshot exports an agent's store to a gzipped tarball, or imports one
+// exported from another agent. The agent owning the store should be stopped
+// while importing.
+func main() {
+	configFile := flag.String("config", "", "agent config file")
+	exportPath := flag.String("export", "", "path to write snapshot to")
+	importPath := flag.String("import", "", "path to read snapshot from")
+	flag.Parse()
+
+	if *configFile == "" {
+		panic("-config required")
+	}
+	if (*exportPath != "" && *importPath != "") || (*exportPath == "" && *importPath == "") {
+		panic("must set either -export or -import")
+	}
+
+	var config appConfig
+	if err := configutil.Load(*configFile, &config); err != nil {
+		panic(err)
+	}
+
+	s, err := store.NewCADownloadStore(config.Store, tally.NoopScope)
+	if err != nil {
+		panic(err)
+	}
+	defer s.Close()
+
+	if *exportPath != "" {
+		err = export(s, *exportPath)
+	} else {
+		err = importSnapshot(s, *importPath)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		s.Close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package announceclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/httputil"
+)
+
+// BatchItem defines a single torrent of a batch announce.
+type BatchItem struct {
+	Namespace string        `json:"namespace,omitempty"`
+	Digest    core.Digest   `json:"digest"`
+	InfoHash  core.InfoHash `json:"info_hash"`
+	Complete  bool          `json:"complete"`
+}
+
+// BatchRequest defines a batch announce request. The completeness of Peer is
+// ignored in favor of the completeness of each item.
+type BatchRequest struct {
+	Peer  *core.PeerInfo `json:"peer"`
+	Items []BatchItem    `json:"items"`
+}
+
+// BatchResult defines the announce response of a single torrent of a batch
+// announce. Error is set if the torrent failed to announce.
+type BatchResult struct {
+	InfoHash core.InfoHash    `json:"info_hash"`
+	Peers    []*core.PeerInfo `json:"peers"`
+	Interval time.Duration    `json:"interval"`
+	Error    string           `json:"error,omitempty"`
+
+	// Misrouted is set if the tracker does not own the item, in which case
+	// it should be announced to the next responsible tracker.
+	Misrouted bool `json:"misrouted,omitempty"`
+}
+
+// Err returns the announce error of r, if any.
+func (r BatchResult) Err() error {
+	if r.Error == "" {
+		return nil
+	}
+	return errors.New(r.Error)
+}
+
+// BatchResponse defines a batch announce response. Results are in the same
+// order as the items of the request.
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// BatchAnnounce announces items in batches, one per tracker responsible for
+// the items. Items whose tracker is unavailable, or which the tracker does not
+// own, are retried against their next responsible tracker.
+func (c *client) BatchAnnounce(items []BatchItem) ([]BatchResult, error) {
+	results := make([]BatchResult, len(items))
+	tried := make([]map[string]bool, len(items))
+	errs := make([]error, len(items))
+	pending := make([]int, len(items))
+	for i, item := range items {
+		results[i].InfoHash = item.InfoHash
+		tried[i] = make(map[string]bool)
+		pending[i] = i
+	}
+	peer := core.PeerInfoFromContext(c.pctx, false)
+
+	for len(pending) > 0 {
+		// Group pending items by the first responsible tracker they have not
+		// been sent to yet.
+		groups := make(map[string][]int)
+		var addrs []string
+		for _, i := range pending {
+			var addr string
+			for _, a := range c.ring.Locations(items[i].Digest) {
+				if !tried[i][a] {
+					addr = a
+					break
+				}
+			}
+			if addr == "" {
+				if errs[i] == nil {
+					errs[i] = errors.New("no trackers available")
+				}
+				continue
+			}
+			tried[i][addr] = true
+			if _, ok := groups[addr]; !ok {
+				addrs = append(addrs, addr)
+			}
+			groups[addr] = append(groups[addr], i)
+		}
+		pending = nil
+		for _, addr := range addrs {
+			idxs := groups[addr]
+			batch := make([]BatchItem, len(idxs))
+			for j, i := range idxs {
+				batch[j] = items[i]
+			}
+			resp, err := c.sendBatch(addr, &BatchRequest{peer, batch})
+			if err != nil {
+				for _, i := range idxs {
+					errs[i] = err
+				}
+				if httputil.IsNetworkError(err) {
+					c.ring.Failed(addr)
+					pending = append(pending, idxs...)
+				}
+				continue
+			}
+			for j, i := range idxs {
+				results[i] = resp.Results[j]
+				errs[i] = nil
+				if results[i].Misrouted {
+					pending = append(pending, i)
+				}
+			}
+		}
+	}
+	for i, err := range errs {
+		if err != nil {
+			results[i].Error = err.Error()
+		}
+	}
+	return results, nil
+}
+
+func (c *client) sendBatch(addr string, req *BatchRequest) (*BatchResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %s", err)
+	}
+	httpResp, err := httputil.Post(
+		fmt.Sprintf("http://%s/announce/batch", addr),
+		httputil.SendBody(bytes.NewReader(body)),
+		httputil.SendTimeout(30*time.Second),
+		httputil.SendHeaders(c.headers()),
+		httputil.SendTLS(c.tls))
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	var resp BatchResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("decode response: %s", err)
+	}
+	if len(resp.Results) != len(req.Items) {
+		return nil, fmt.Errorf(
+			"expected %d results, got %d", len(req.Items), len(resp.Results))
+	}
+	return &resp, nil
+}
@@ -32,10 +32,11 @@ var ErrDisabled = errors.New("announcing disabled")
 
 // Request defines an announce request.
 type Request struct {
-	Name     string         `json:"name"`
-	Digest   *core.Digest   `json:"digest"` // Optional (for now).
-	InfoHash core.InfoHash  `json:"info_hash"`
-	Peer     *core.PeerInfo `json:"peer"`
+	Name      string         `json:"name"`
+	Digest    *core.Digest   `json:"digest"` // Optional (for now).
+	InfoHash  core.InfoHash  `json:"info_hash"`
+	Peer      *core.PeerInfo `json:"peer"`
+	Namespace string         `json:"namespace,omitempty"` // Optional.
 }
 
 // GetDigest is a backwards compatible accessor of the request digest.
@@ -59,21 +60,46 @@ type Response struct {
 // Client defines a client for announcing and getting peers.
 type Client interface {
 	Announce(
+		namespace string,
 		d core.Digest,
 		h core.InfoHash,
 		complete bool,
 		version int) ([]*core.PeerInfo, time.Duration, error)
+
+	// BatchAnnounce announces many torrents with as few requests as possible.
+	// Results are in the same order as items, and carry per-torrent errors.
+	BatchAnnounce(items []BatchItem) ([]BatchResult, error)
 }
 
 type client struct {
-	pctx core.PeerContext
-	ring hashring.PassiveRing
-	tls  *tls.Config
+	pctx  core.PeerContext
+	ring  hashring.PassiveRing
+	tls   *tls.Config
+	token string
+}
+
+// Option allows setting optional client parameters.
+type Option func(*client)
+
+// WithToken authenticates announces with a bearer token.
+func WithToken(token string) Option {
+	return func(c *client) { c.token = token }
 }
 
 // New creates a new client.
-func New(pctx core.PeerContext, ring hashring.PassiveRing, tls *tls.Config) Client {
-	return &client{pctx, ring, tls}
+func New(pctx core.PeerContext, ring hashring.PassiveRing, tls *tls.Config, opts ...Option) Client {
+	c := &client{pctx: pctx, ring: ring, tls: tls}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *client) headers() map[string]string {
+	if c.token == "" {
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + c.token}
 }
 
 // Announce versionss.
@@ -89,20 +115,22 @@ func getEndpoint(version int, addr string, h core.InfoHash) (method, url string)
 	return "POST", fmt.Sprintf("http://%s/announce/%s", addr, h.String())
 }
 
-// Announce announces the torrent identified by (d, h) with the number of
-// downloaded bytes. Returns a list of all other peers announcing for said torrent,
+// Announce announces the torrent identified by (d, h) under namespace with the
+// number of downloaded bytes. Returns a list of all other peers announcing for said torrent,
 // sorted by priority, and the interval for the next announce.
 func (c *client) Announce(
+	namespace string,
 	d core.Digest,
 	h core.InfoHash,
 	complete bool,
 	version int) (peers []*core.PeerInfo, interval time.Duration, err error) {
 
 	body, err := json.Marshal(&Request{
-		Name:     d.Hex(), // For backwards compatability. TODO(codyg): Remove.
-		Digest:   &d,
-		InfoHash: h,
-		Peer:     core.PeerInfoFromContext(c.pctx, complete),
+		Name:      d.Hex(), // For backwards compatability. TODO(codyg): Remove.
+		Digest:    &d,
+		InfoHash:  h,
+		Peer:      core.PeerInfoFromContext(c.pctx, complete),
+		Namespace: namespace,
 	})
 	if err != nil {
 		return nil, 0, fmt.Errorf("marshal request: %s", err)
@@ -115,12 +143,18 @@ func (c *client) Announce(
 			url,
 			httputil.SendBody(bytes.NewReader(body)),
 			httputil.SendTimeout(10*time.Second),
+			httputil.SendHeaders(c.headers()),
 			httputil.SendTLS(c.tls))
 		if err != nil {
 			if httputil.IsNetworkError(err) {
 				c.ring.Failed(addr)
 				continue
 			}
+			if httputil.IsStatus(err, http.StatusMisdirectedRequest) {
+				// The tracker does not own d in its view of the ring, which
+				// may differ from ours during membership changes.
+				continue
+			}
 			return nil, 0, err
 		}
 		defer httpResp.Body.Close()
@@ -144,7 +178,13 @@ func Disabled() Client {
 
 // Announce always returns error.
 func (c DisabledClient) Announce(
-	d core.Digest, h core.InfoHash, complete bool, version int) ([]*core.PeerInfo, time.Duration, error) {
+	namespace string, d core.Digest, h core.InfoHash, complete bool,
+	version int) ([]*core.PeerInfo, time.Duration, error) {
 
 	return nil, 0, ErrDisabled
 }
+
+// BatchAnnounce always returns error.
+func (c DisabledClient) BatchAnnounce(items []BatchItem) ([]BatchResult, error) {
+	return nil, ErrDisabled
+}
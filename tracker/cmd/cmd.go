@@ -15,8 +15,14 @@ package cmd
 
 import (
 	"flag"
+	"net"
+	"os"
+	"strconv"
 
+	"github.com/uber/kraken/lib/backend"
+	"github.com/uber/kraken/lib/hashring"
 	"github.com/uber/kraken/lib/healthcheck"
+	"github.com/uber/kraken/lib/hostlist"
 	"github.com/uber/kraken/lib/upstream"
 	"github.com/uber/kraken/metrics"
 	"github.com/uber/kraken/nginx"
@@ -27,6 +33,7 @@ import (
 	"github.com/uber/kraken/tracker/trackerserver"
 	"github.com/uber/kraken/utils/configutil"
 	"github.com/uber/kraken/utils/log"
+	"github.com/uber/kraken/utils/netutil"
 
 	"github.com/andres-erbsen/clock"
 	"github.com/uber-go/tally"
@@ -121,7 +128,7 @@ func Run(flags *Flags, opts ...Option) {
 
 	go metrics.EmitVersion(stats)
 
-	peerStore, err := peerstore.NewRedisStore(config.PeerStore.Redis, clock.New())
+	peerStore, err := peerstore.New(config.PeerStore, clock.New(), stats)
 	if err != nil {
 		log.Fatalf("Could not create PeerStore: %s", err)
 	}
@@ -147,8 +154,35 @@ func Run(flags *Flags, opts ...Option) {
 	r := blobclient.NewClientResolver(blobclient.NewProvider(blobclient.WithTLS(tls)), origins)
 	originCluster := blobclient.NewClusterClient(r)
 
-	server := trackerserver.New(
-		config.TrackerServer, stats, policy, peerStore, originStore, originCluster)
+	var serverOpts []trackerserver.Option
+	if config.shardingEnabled() {
+		cluster, err := hostlist.New(config.Cluster)
+		if err != nil {
+			log.Fatalf("Error creating cluster host list: %s", err)
+		}
+		ring := hashring.New(
+			config.HashRing,
+			cluster,
+			healthcheck.NewFilter(config.HealthCheck, healthcheck.Default(tls)))
+		go ring.Monitor(nil)
+
+		addr := selfAddr(ring, flags.Port)
+		log.Infof("Sharding swarms across tracker cluster as %s", addr)
+		serverOpts = append(serverOpts, trackerserver.WithShards(addr, ring))
+	}
+	if len(config.Backends) > 0 {
+		backends, err := backend.NewManager(config.Backends, config.Auth)
+		if err != nil {
+			log.Fatalf("Error creating backend manager: %s", err)
+		}
+		serverOpts = append(serverOpts, trackerserver.WithBackends(backends))
+	}
+
+	server, err := trackerserver.New(
+		config.TrackerServer, stats, policy, peerStore, originStore, originCluster, serverOpts...)
+	if err != nil {
+		log.Fatalf("Error creating tracker server: %s", err)
+	}
 	go func() {
 		log.Fatal(server.ListenAndServe())
 	}()
@@ -160,3 +194,26 @@ func Run(flags *Flags, opts ...Option) {
 			config.TrackerServer.Listener.Net, config.TrackerServer.Listener.Addr)},
 		nginx.WithTLS(config.TLS)))
 }
+
+// selfAddr returns the address of the tracker within ring.
+func selfAddr(ring hashring.Ring, port int) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("Error getting hostname: %s", err)
+	}
+	addr := net.JoinHostPort(hostname, strconv.Itoa(port))
+	if ring.Contains(addr) {
+		return addr
+	}
+	// When DNS is used for hash ring membership, the members will be IP
+	// addresses instead of hostnames.
+	ip, err := netutil.GetLocalIP()
+	if err != nil {
+		log.Fatalf("Error getting local ip: %s", err)
+	}
+	addr = net.JoinHostPort(ip, strconv.Itoa(port))
+	if !ring.Contains(addr) {
+		log.Fatalf("Neither %s nor %s (port %d) found in hash ring", hostname, ip, port)
+	}
+	return addr
+}
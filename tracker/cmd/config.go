@@ -16,6 +16,10 @@ package cmd
 import (
 	"go.uber.org/zap"
 
+	"github.com/uber/kraken/lib/backend"
+	"github.com/uber/kraken/lib/hashring"
+	"github.com/uber/kraken/lib/healthcheck"
+	"github.com/uber/kraken/lib/hostlist"
 	"github.com/uber/kraken/lib/upstream"
 	"github.com/uber/kraken/metrics"
 	"github.com/uber/kraken/nginx"
@@ -37,4 +41,19 @@ type Config struct {
 	Metrics           metrics.Config           `yaml:"metrics"`
 	Nginx             nginx.Config             `yaml:"nginx"`
 	TLS               httputil.TLSConfig       `yaml:"tls"`
+
+	// Cluster lists every tracker. If set, swarms are sharded across the
+	// trackers of the cluster hash ring.
+	Cluster     hostlist.Config          `yaml:"cluster"`
+	HashRing    hashring.Config          `yaml:"hashring"`
+	HealthCheck healthcheck.FilterConfig `yaml:"healthcheck"`
+
+	// Backends, if set, are used to generate metainfo while the origin
+	// cluster is degraded. Should match the origin backends.
+	Backends []backend.Config   `yaml:"backends"`
+	Auth     backend.AuthConfig `yaml:"auth"`
+}
+
+func (c Config) shardingEnabled() bool {
+	return c.Cluster.DNS != "" || len(c.Cluster.Static) > 0
 }
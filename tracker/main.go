@@ -13,7 +13,17 @@
 // limitations under the License.
 package main
 
-import "github.com/uber/kraken/tracker/cmd"
+import (
+	"github.com/uber/kraken/tracker/cmd"
+
+	// Import all backend client packages to register them with backend manager.
+	_ "github.com/uber/kraken/lib/backend/hdfsbackend"
+	_ "github.com/uber/kraken/lib/backend/httpbackend"
+	_ "github.com/uber/kraken/lib/backend/registrybackend"
+	_ "github.com/uber/kraken/lib/backend/s3backend"
+	_ "github.com/uber/kraken/lib/backend/gcsbackend"
+	_ "github.com/uber/kraken/lib/backend/testfs"
+)
 
 func main() {
 	cmd.Run(cmd.ParseFlags())
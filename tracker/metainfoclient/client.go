@@ -14,6 +14,7 @@
 package metainfoclient
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -23,6 +24,7 @@ import (
 	"time"
 
 	"github.com/cenkalti/backoff"
+	"github.com/uber-go/tally"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/hashring"
@@ -36,39 +38,63 @@ var (
 
 // Client defines operations on torrent metainfo.
 type Client interface {
-	Download(namespace string, d core.Digest) (*core.MetaInfo, error)
+	Download(ctx context.Context, namespace string, d core.Digest) (*core.MetaInfo, error)
 }
 
 type client struct {
-	ring hashring.PassiveRing
-	tls  *tls.Config
+	config Config
+	stats  tally.Scope
+	ring   hashring.PassiveRing
+	tls    *tls.Config
+	token  string
+}
+
+// Option allows setting optional client parameters.
+type Option func(*client)
+
+// WithToken authenticates metainfo requests with a bearer token.
+func WithToken(token string) Option {
+	return func(c *client) { c.token = token }
 }
 
 // New returns a new Client.
-func New(ring hashring.PassiveRing, tls *tls.Config) Client {
-	return &client{ring, tls}
+func New(
+	config Config, stats tally.Scope, ring hashring.PassiveRing, tls *tls.Config, opts ...Option) Client {
+
+	config = config.applyDefaults()
+
+	stats = stats.Tagged(map[string]string{
+		"module": "metainfoclient",
+	})
+
+	c := &client{config: config, stats: stats, ring: ring, tls: tls}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *client) headers() map[string]string {
+	if c.token == "" {
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + c.token}
 }
 
 // Download returns the MetaInfo associated with name. Returns ErrNotFound if
-// no torrent exists under name.
-func (c *client) Download(namespace string, d core.Digest) (*core.MetaInfo, error) {
+// no torrent exists under name. Polling for metainfo stops once ctx is done.
+func (c *client) Download(
+	ctx context.Context, namespace string, d core.Digest) (*core.MetaInfo, error) {
+
 	var resp *http.Response
 	var err error
 	for _, addr := range c.ring.Locations(d) {
-		resp, err = httputil.PollAccepted(
-			fmt.Sprintf(
-				"http://%s/namespace/%s/blobs/%s/metainfo",
-				addr, url.PathEscape(namespace), d),
-			&backoff.ExponentialBackOff{
-				InitialInterval:     time.Second,
-				RandomizationFactor: 0.05,
-				Multiplier:          1.3,
-				MaxInterval:         5 * time.Second,
-				MaxElapsedTime:      15 * time.Minute,
-				Clock:               backoff.SystemClock,
-			},
-			httputil.SendTimeout(10*time.Second),
-			httputil.SendTLS(c.tls))
+		resp, err = c.poll(ctx, fmt.Sprintf(
+			"http://%s/namespace/%s/blobs/%s/metainfo",
+			addr, url.PathEscape(namespace), d))
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		if err != nil {
 			if httputil.IsNetworkError(err) {
 				c.ring.Failed(addr)
@@ -92,3 +118,46 @@ func (c *client) Download(namespace string, d core.Digest) (*core.MetaInfo, erro
 	}
 	return nil, err
 }
+
+// poll polls u with exponential backoff while the tracker responds with 202,
+// until the configured deadline or ctx is done.
+func (c *client) poll(ctx context.Context, u string) (*http.Response, error) {
+	b := &backoff.ExponentialBackOff{
+		InitialInterval:     c.config.InitialInterval,
+		RandomizationFactor: c.config.RandomizationFactor,
+		Multiplier:          c.config.Multiplier,
+		MaxInterval:         c.config.MaxInterval,
+		MaxElapsedTime:      c.config.Deadline,
+		Clock:               backoff.SystemClock,
+	}
+	b.Reset()
+	for {
+		c.stats.Counter("poll_attempts").Inc(1)
+		timer := c.stats.Timer("poll_attempt_latency").Start()
+		resp, err := httputil.Get(
+			u,
+			httputil.SendContext(ctx),
+			httputil.SendTimeout(10*time.Second),
+			httputil.SendHeaders(c.headers()),
+			httputil.SendTLS(c.tls))
+		timer.Stop()
+		if err == nil {
+			return resp, nil
+		}
+		if !httputil.IsAccepted(err) {
+			c.stats.Counter("poll_errors").Inc(1)
+			return nil, err
+		}
+		c.stats.Counter("poll_pending").Inc(1)
+		wait := b.NextBackOff()
+		if wait == backoff.Stop {
+			c.stats.Counter("poll_deadline_exceeded").Inc(1)
+			return nil, errors.New("backoff timed out on 202 responses")
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metainfoclient
+
+import "time"
+
+// Config defines Client configuration.
+type Config struct {
+	// The following control exponential backoff while polling for metainfo
+	// which origins are still generating. Randomization spreads out the polls
+	// of many agents downloading the same blob, such that they do not poll the
+	// tracker in lockstep.
+	InitialInterval     time.Duration `yaml:"initial_interval"`
+	RandomizationFactor float64       `yaml:"randomization_factor"`
+	Multiplier          float64       `yaml:"multiplier"`
+	MaxInterval         time.Duration `yaml:"max_interval"`
+
+	// Deadline bounds the total duration spent polling a single tracker.
+	Deadline time.Duration `yaml:"deadline"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.InitialInterval == 0 {
+		c.InitialInterval = time.Second
+	}
+	if c.RandomizationFactor == 0 {
+		c.RandomizationFactor = 0.5
+	}
+	if c.Multiplier == 0 {
+		c.Multiplier = 1.3
+	}
+	if c.MaxInterval == 0 {
+		c.MaxInterval = 5 * time.Second
+	}
+	if c.Deadline == 0 {
+		c.Deadline = 15 * time.Minute
+	}
+	return c
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import "github.com/uber-go/tally"
+
+// churnStats counts peers joining, leaving, and expiring from swarms. Leaves
+// are evictions, since peers do not announce when they stop serving a torrent.
+type churnStats struct {
+	joins    tally.Counter
+	leaves   tally.Counter
+	expiries tally.Counter
+}
+
+func newChurnStats(stats tally.Scope) churnStats {
+	return churnStats{
+		joins:    stats.Counter("peer_joins"),
+		leaves:   stats.Counter("peer_leaves"),
+		expiries: stats.Counter("peer_expiries"),
+	}
+}
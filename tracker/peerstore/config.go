@@ -19,24 +19,77 @@ import (
 
 // Config defines Store configuration.
 type Config struct {
-	Redis RedisConfig `yaml:"redis"`
+	// Backend selects the Store implementation: "redis" (default), "etcd",
+	// "gossip", or the name of a registered plugin.
+	Backend string `yaml:"backend"`
+
+	Redis  RedisConfig  `yaml:"redis"`
+	Etcd   EtcdConfig   `yaml:"etcd"`
+	Gossip GossipConfig `yaml:"gossip"`
+
+	// Plugins holds the configuration of backends registered outside of this
+	// package, keyed by backend name.
+	Plugins map[string]interface{} `yaml:"plugins"`
+}
+
+func (c *Config) applyDefaults() {
+	if c.Backend == "" {
+		c.Backend = _redis
+	}
 }
 
+// Redis topologies.
+const (
+	RedisStandalone = "standalone"
+	RedisCluster    = "cluster"
+	RedisSentinel   = "sentinel"
+)
+
 // RedisConfig defines RedisStore configuration.
 // TODO(evelynl94): rename
 type RedisConfig struct {
-	Addr              string        `yaml:"addr"`
-	DialTimeout       time.Duration `yaml:"dial_timeout"`
-	ReadTimeout       time.Duration `yaml:"read_timeout"`
-	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	// Mode is the topology of the Redis deployment: "standalone" (default)
+	// connects to Addr, "cluster" discovers the nodes of a Redis Cluster from
+	// the seed nodes in Addrs, and "sentinel" connects to the master named
+	// MasterName as reported by the sentinels in Addrs.
+	Mode       string   `yaml:"mode"`
+	Addrs      []string `yaml:"addrs"`
+	MasterName string   `yaml:"master_name"`
+
+	// MaxRedirects bounds the number of MOVED / ASK redirects followed per
+	// command in cluster mode.
+	MaxRedirects int `yaml:"max_redirects"`
+
+	Addr            string        `yaml:"addr"`
+	DialTimeout     time.Duration `yaml:"dial_timeout"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	MaxActiveConns  int           `yaml:"max_active_conns"`
+	IdleConnTimeout time.Duration `yaml:"idle_conn_timeout"`
+
+	// PeerTTL is how long announced peers are handed out without announcing
+	// again.
+	PeerTTL time.Duration `yaml:"peer_ttl"`
+
+	// CompactionInterval is how often expired peers are removed from swarms
+	// which are not read. Swarms which are read are pruned on every read.
+	CompactionInterval time.Duration `yaml:"compaction_interval"`
+
+	// Deprecated: peers used to be stored in windows, and expired
+	// PeerSetWindowSize * MaxPeerSetWindows after they announced. Used as the
+	// PeerTTL if unset.
 	PeerSetWindowSize time.Duration `yaml:"peer_set_window_size"`
 	MaxPeerSetWindows int           `yaml:"max_peer_set_windows"`
-	MaxIdleConns      int           `yaml:"max_idle_conns"`
-	MaxActiveConns    int           `yaml:"max_active_conns"`
-	IdleConnTimeout   time.Duration `yaml:"idle_conn_timeout"`
 }
 
 func (c *RedisConfig) applyDefaults() {
+	if c.Mode == "" {
+		c.Mode = RedisStandalone
+	}
+	if c.MaxRedirects == 0 {
+		c.MaxRedirects = 5
+	}
 	if c.DialTimeout == 0 {
 		c.DialTimeout = 5 * time.Second
 	}
@@ -52,6 +105,12 @@ func (c *RedisConfig) applyDefaults() {
 	if c.MaxPeerSetWindows == 0 {
 		c.MaxPeerSetWindows = 5
 	}
+	if c.PeerTTL == 0 {
+		c.PeerTTL = c.PeerSetWindowSize * time.Duration(c.MaxPeerSetWindows)
+	}
+	if c.CompactionInterval == 0 {
+		c.CompactionInterval = 10 * time.Minute
+	}
 	if c.MaxIdleConns == 0 {
 		c.MaxIdleConns = 10
 	}
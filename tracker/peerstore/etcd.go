@@ -0,0 +1,364 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+)
+
+const _etcd = "etcd"
+
+func init() {
+	Register(_etcd, etcdFactory{})
+}
+
+type etcdFactory struct{}
+
+func (etcdFactory) Create(config Config, clk clock.Clock, stats tally.Scope) (Store, error) {
+	return NewEtcdStore(config.Etcd, clk)
+}
+
+// EtcdConfig defines EtcdStore configuration.
+type EtcdConfig struct {
+	// Endpoints are the client URLs of the etcd cluster, e.g.
+	// "http://etcd-1:2379".
+	Endpoints []string `yaml:"endpoints"`
+
+	// APIPrefix is the path of etcd's v3 JSON gateway, which is "/v3" in
+	// etcd 3.4 and later.
+	APIPrefix string `yaml:"api_prefix"`
+
+	// Prefix is prepended to all keys.
+	Prefix string `yaml:"prefix"`
+
+	// LeaseWindow is how often a new lease is granted for announced peers.
+	// Leases outlive their window by PeerTTL, such that peers expire at least
+	// PeerTTL after they last announced.
+	LeaseWindow time.Duration `yaml:"lease_window"`
+	PeerTTL     time.Duration `yaml:"peer_ttl"`
+
+	// MaxRange limits the number of peers read per torrent, from which peers
+	// are sampled.
+	MaxRange int `yaml:"max_range"`
+
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c *EtcdConfig) applyDefaults() {
+	if c.APIPrefix == "" {
+		c.APIPrefix = "/v3"
+	}
+	if c.Prefix == "" {
+		c.Prefix = "/kraken/peerstore/"
+	}
+	if c.LeaseWindow == 0 {
+		c.LeaseWindow = time.Hour
+	}
+	if c.PeerTTL == 0 {
+		c.PeerTTL = 5 * time.Hour
+	}
+	if c.MaxRange == 0 {
+		c.MaxRange = 1000
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+}
+
+type etcdLeaseGrantRequest struct {
+	TTL int64 `json:"TTL"`
+}
+
+type etcdLeaseGrantResponse struct {
+	ID int64 `json:"ID,string"`
+}
+
+type etcdPutRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	Lease int64  `json:"lease,string"`
+}
+
+type etcdRangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end"`
+	Limit    int64  `json:"limit,string"`
+}
+
+type etcdDeleteRangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+}
+
+type etcdKeyValue struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type etcdRangeResponse struct {
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+// prefixEnd returns the end of the range of keys starting with prefix.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// All keys.
+	return []byte{0}
+}
+
+// EtcdStore is a Store backed by etcd, accessed through its v3 JSON gateway.
+// Peers are stored under per-torrent prefixes and expire with leases.
+type EtcdStore struct {
+	config EtcdConfig
+	clk    clock.Clock
+
+	mu             sync.Mutex
+	endpoints      []string // Ordered by preference.
+	lease          int64
+	leaseGrantedAt time.Time
+}
+
+// NewEtcdStore creates a new EtcdStore.
+func NewEtcdStore(config EtcdConfig, clk clock.Clock) (*EtcdStore, error) {
+	config.applyDefaults()
+
+	if len(config.Endpoints) == 0 {
+		return nil, errors.New("invalid config: missing endpoints")
+	}
+	s := &EtcdStore{
+		config:    config,
+		clk:       clk,
+		endpoints: append([]string(nil), config.Endpoints...),
+	}
+
+	// Ensure we can connect to etcd.
+	if _, err := s.currentLease(); err != nil {
+		return nil, fmt.Errorf("grant lease: %s", err)
+	}
+	return s, nil
+}
+
+// call posts req to the JSON gateway and decodes the reply into resp. Tries
+// each endpoint until one is available.
+func (s *EtcdStore) call(path string, req, resp interface{}) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("json: %s", err)
+	}
+
+	s.mu.Lock()
+	endpoints := append([]string(nil), s.endpoints...)
+	s.mu.Unlock()
+
+	for i, endpoint := range endpoints {
+		r, err := httputil.Post(
+			strings.TrimRight(endpoint, "/")+s.config.APIPrefix+path,
+			httputil.SendBody(bytes.NewReader(b)),
+			httputil.SendTimeout(s.config.Timeout))
+		if err != nil {
+			if se, ok := err.(httputil.StatusError); ok && se.Status < 500 {
+				return err
+			}
+			log.Infof("Error calling etcd endpoint %s: %s", endpoint, err)
+			if i == len(endpoints)-1 {
+				return err
+			}
+			continue
+		}
+		err = json.NewDecoder(r.Body).Decode(resp)
+		r.Body.Close()
+		if err != nil {
+			return fmt.Errorf("json: %s", err)
+		}
+		if i > 0 {
+			s.mu.Lock()
+			s.endpoints = append([]string{endpoint}, append(endpoints[:i], endpoints[i+1:]...)...)
+			s.mu.Unlock()
+		}
+		return nil
+	}
+	return errors.New("no endpoints")
+}
+
+// currentLease returns the lease for peers announced now, granting a new one
+// once per lease window.
+func (s *EtcdStore) currentLease() (int64, error) {
+	s.mu.Lock()
+	lease := s.lease
+	expired := lease == 0 || s.clk.Now().Sub(s.leaseGrantedAt) >= s.config.LeaseWindow
+	s.mu.Unlock()
+
+	if !expired {
+		return lease, nil
+	}
+	var resp etcdLeaseGrantResponse
+	req := etcdLeaseGrantRequest{TTL: int64((s.config.LeaseWindow + s.config.PeerTTL).Seconds())}
+	if err := s.call("/lease/grant", req, &resp); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lease = resp.ID
+	s.leaseGrantedAt = s.clk.Now()
+	return resp.ID, nil
+}
+
+// resetLease forces a new lease to be granted, e.g. if the current lease was
+// revoked.
+func (s *EtcdStore) resetLease() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lease = 0
+}
+
+func (s *EtcdStore) torrentPrefix(h core.InfoHash) string {
+	return s.config.Prefix + h.String() + "/"
+}
+
+// UpdatePeer writes p to etcd under the current lease.
+func (s *EtcdStore) UpdatePeer(h core.InfoHash, p *core.PeerInfo) error {
+	put := func() error {
+		lease, err := s.currentLease()
+		if err != nil {
+			return fmt.Errorf("grant lease: %s", err)
+		}
+		req := etcdPutRequest{
+			Key:   []byte(s.torrentPrefix(h) + p.PeerID.String()),
+			Value: []byte(serializePeer(p)),
+			Lease: lease,
+		}
+		var resp struct{}
+		return s.call("/kv/put", req, &resp)
+	}
+	err := put()
+	if httputil.IsNotFound(err) {
+		// Lease was revoked or expired, e.g. while etcd was restored.
+		s.resetLease()
+		err = put()
+	}
+	if err != nil {
+		return fmt.Errorf("put: %s", err)
+	}
+	return nil
+}
+
+// GetPeers returns at most n random peers announcing for h.
+func (s *EtcdStore) GetPeers(h core.InfoHash, n int) ([]*core.PeerInfo, error) {
+	prefix := s.torrentPrefix(h)
+	req := etcdRangeRequest{
+		Key:      []byte(prefix),
+		RangeEnd: prefixEnd(prefix),
+		Limit:    int64(s.config.MaxRange),
+	}
+	var resp etcdRangeResponse
+	if err := s.call("/kv/range", req, &resp); err != nil {
+		return nil, fmt.Errorf("range: %s", err)
+	}
+
+	var peers []*core.PeerInfo
+	for _, kv := range resp.Kvs {
+		id, complete, err := deserializePeer(string(kv.Value))
+		if err != nil {
+			log.Errorf("Error deserializing peer %q: %s", kv.Value, err)
+			continue
+		}
+		p := core.NewPeerInfo(id.peerID, id.ip, id.port, false, complete)
+		p.Zone = id.zone
+		p.Rack = id.rack
+		peers = append(peers, p)
+	}
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > n {
+		peers = peers[:n]
+	}
+	return peers, nil
+}
+
+// Swarms returns the stats of every swarm in etcd. Reads all keys, so should
+// not be called on the announce path.
+func (s *EtcdStore) Swarms() ([]SwarmStats, error) {
+	req := etcdRangeRequest{
+		Key:      []byte(s.config.Prefix),
+		RangeEnd: prefixEnd(s.config.Prefix),
+	}
+	var resp etcdRangeResponse
+	if err := s.call("/kv/range", req, &resp); err != nil {
+		return nil, fmt.Errorf("range: %s", err)
+	}
+	counter := make(swarmCounter)
+	for _, kv := range resp.Kvs {
+		parts := strings.SplitN(strings.TrimPrefix(string(kv.Key), s.config.Prefix), "/", 2)
+		h, err := core.NewInfoHashFromHex(parts[0])
+		if err != nil {
+			log.Errorf("Error parsing info hash of key %q: %s", kv.Key, err)
+			continue
+		}
+		_, complete, err := deserializePeer(string(kv.Value))
+		if err != nil {
+			log.Errorf("Error deserializing peer %q: %s", kv.Value, err)
+			continue
+		}
+		counter.add(h, complete)
+	}
+	return counter.stats(), nil
+}
+
+// EvictPeer deletes the peer with peerID from h.
+func (s *EtcdStore) EvictPeer(h core.InfoHash, peerID core.PeerID) error {
+	req := etcdDeleteRangeRequest{
+		Key: []byte(s.torrentPrefix(h) + peerID.String()),
+	}
+	var resp struct{}
+	if err := s.call("/kv/deleterange", req, &resp); err != nil {
+		return fmt.Errorf("delete range: %s", err)
+	}
+	return nil
+}
+
+// EvictSwarm deletes all peers of h.
+func (s *EtcdStore) EvictSwarm(h core.InfoHash) error {
+	prefix := s.torrentPrefix(h)
+	req := etcdDeleteRangeRequest{
+		Key:      []byte(prefix),
+		RangeEnd: prefixEnd(prefix),
+	}
+	var resp struct{}
+	if err := s.call("/kv/deleterange", req, &resp); err != nil {
+		return fmt.Errorf("delete range: %s", err)
+	}
+	return nil
+}
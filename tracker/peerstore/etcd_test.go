@@ -0,0 +1,266 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEtcd implements the subset of etcd's v3 JSON gateway used by EtcdStore.
+type fakeEtcd struct {
+	sync.Mutex
+	nextLease int64
+	leases    map[int64]bool
+	kvs       map[string]string
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{
+		leases: make(map[int64]bool),
+		kvs:    make(map[string]string),
+	}
+}
+
+func (e *fakeEtcd) revokeAll() {
+	e.Lock()
+	defer e.Unlock()
+	e.leases = make(map[int64]bool)
+}
+
+func (e *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.Lock()
+	defer e.Unlock()
+
+	switch r.URL.Path {
+	case "/v3/lease/grant":
+		e.nextLease++
+		e.leases[e.nextLease] = true
+		json.NewEncoder(w).Encode(etcdLeaseGrantResponse{ID: e.nextLease})
+	case "/v3/kv/put":
+		var req etcdPutRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !e.leases[req.Lease] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		e.kvs[string(req.Key)] = string(req.Value)
+		w.Write([]byte("{}"))
+	case "/v3/kv/range":
+		var req etcdRangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var resp etcdRangeResponse
+		for k, v := range e.kvs {
+			if k >= string(req.Key) && k < string(req.RangeEnd) {
+				resp.Kvs = append(resp.Kvs, etcdKeyValue{[]byte(k), []byte(v)})
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	case "/v3/kv/deleterange":
+		var req etcdDeleteRangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for k := range e.kvs {
+			if k == string(req.Key) ||
+				(req.RangeEnd != nil && k >= string(req.Key) && k < string(req.RangeEnd)) {
+				delete(e.kvs, k)
+			}
+		}
+		w.Write([]byte("{}"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal([]byte("/a0"), prefixEnd("/a/"))
+	require.Equal([]byte("b"), prefixEnd("a\xff"))
+	require.Equal([]byte{0}, prefixEnd("\xff"))
+}
+
+func TestEtcdStoreUpdateAndGetPeers(t *testing.T) {
+	require := require.New(t)
+
+	server := httptest.NewServer(newFakeEtcd())
+	defer server.Close()
+
+	s, err := NewEtcdStore(EtcdConfig{Endpoints: []string{server.URL}}, clock.New())
+	require.NoError(err)
+
+	h := core.InfoHashFixture()
+
+	p1 := core.PeerInfoFixture()
+	p1.Zone = "zone1"
+	p2 := core.PeerInfoFixture()
+	p2.Complete = true
+
+	require.NoError(s.UpdatePeer(h, p1))
+	require.NoError(s.UpdatePeer(h, p2))
+	require.NoError(s.UpdatePeer(core.InfoHashFixture(), core.PeerInfoFixture()))
+
+	peers, err := s.GetPeers(h, 10)
+	require.NoError(err)
+	require.ElementsMatch([]*core.PeerInfo{p1, p2}, peers)
+
+	peers, err = s.GetPeers(h, 1)
+	require.NoError(err)
+	require.Len(peers, 1)
+}
+
+func TestEtcdStoreSwarms(t *testing.T) {
+	require := require.New(t)
+
+	server := httptest.NewServer(newFakeEtcd())
+	defer server.Close()
+
+	s, err := NewEtcdStore(EtcdConfig{Endpoints: []string{server.URL}}, clock.New())
+	require.NoError(err)
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+
+	seeder := core.PeerInfoFixture()
+	seeder.Complete = true
+	require.NoError(s.UpdatePeer(h1, seeder))
+	require.NoError(s.UpdatePeer(h1, core.PeerInfoFixture()))
+	require.NoError(s.UpdatePeer(h2, core.PeerInfoFixture()))
+
+	swarms, err := s.Swarms()
+	require.NoError(err)
+	require.ElementsMatch([]SwarmStats{
+		{InfoHash: h1, Seeders: 1, Leechers: 1},
+		{InfoHash: h2, Seeders: 0, Leechers: 1},
+	}, swarms)
+}
+
+func TestEtcdStoreEvict(t *testing.T) {
+	require := require.New(t)
+
+	server := httptest.NewServer(newFakeEtcd())
+	defer server.Close()
+
+	s, err := NewEtcdStore(EtcdConfig{Endpoints: []string{server.URL}}, clock.New())
+	require.NoError(err)
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+
+	bad := core.PeerInfoFixture()
+	good := core.PeerInfoFixture()
+	require.NoError(s.UpdatePeer(h1, bad))
+	require.NoError(s.UpdatePeer(h1, good))
+	require.NoError(s.UpdatePeer(h2, core.PeerInfoFixture()))
+
+	require.NoError(s.EvictPeer(h1, bad.PeerID))
+
+	peers, err := s.GetPeers(h1, 10)
+	require.NoError(err)
+	require.Equal([]*core.PeerInfo{good}, peers)
+
+	require.NoError(s.EvictSwarm(h2))
+
+	peers, err = s.GetPeers(h2, 10)
+	require.NoError(err)
+	require.Empty(peers)
+}
+
+func TestEtcdStoreGrantsNewLeaseEachWindow(t *testing.T) {
+	require := require.New(t)
+
+	etcd := newFakeEtcd()
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+
+	clk := clock.NewMock()
+	s, err := NewEtcdStore(
+		EtcdConfig{Endpoints: []string{server.URL}, LeaseWindow: time.Minute}, clk)
+	require.NoError(err)
+
+	l1, err := s.currentLease()
+	require.NoError(err)
+
+	clk.Add(59 * time.Second)
+	l2, err := s.currentLease()
+	require.NoError(err)
+	require.Equal(l1, l2)
+
+	clk.Add(time.Second)
+	l3, err := s.currentLease()
+	require.NoError(err)
+	require.NotEqual(l1, l3)
+}
+
+func TestEtcdStoreRegrantsRevokedLease(t *testing.T) {
+	require := require.New(t)
+
+	etcd := newFakeEtcd()
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+
+	s, err := NewEtcdStore(EtcdConfig{Endpoints: []string{server.URL}}, clock.New())
+	require.NoError(err)
+
+	etcd.revokeAll()
+
+	h := core.InfoHashFixture()
+	p := core.PeerInfoFixture()
+	require.NoError(s.UpdatePeer(h, p))
+
+	peers, err := s.GetPeers(h, 1)
+	require.NoError(err)
+	require.Equal([]*core.PeerInfo{p}, peers)
+}
+
+func TestEtcdStoreFailsOverToAvailableEndpoint(t *testing.T) {
+	require := require.New(t)
+
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	server := httptest.NewServer(newFakeEtcd())
+	defer server.Close()
+
+	s, err := NewEtcdStore(
+		EtcdConfig{Endpoints: []string{unavailable.URL, server.URL}}, clock.New())
+	require.NoError(err)
+
+	// The available endpoint is preferred from now on.
+	require.True(strings.HasPrefix(s.endpoints[0], server.URL))
+
+	h := core.InfoHashFixture()
+	p := core.PeerInfoFixture()
+	require.NoError(s.UpdatePeer(h, p))
+}
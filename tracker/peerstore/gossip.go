@@ -0,0 +1,285 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/pressly/chi"
+	"github.com/uber-go/tally"
+)
+
+const _gossip = "gossip"
+
+func init() {
+	Register(_gossip, gossipFactory{})
+}
+
+type gossipFactory struct{}
+
+func (gossipFactory) Create(config Config, clk clock.Clock, stats tally.Scope) (Store, error) {
+	return NewGossipStore(config.Gossip, clk, stats)
+}
+
+// GossipConfig defines GossipStore configuration.
+type GossipConfig struct {
+	// ListenAddr is the address on which updates from other trackers are
+	// received.
+	ListenAddr string `yaml:"listen_addr"`
+
+	// Peers are the ListenAddrs of the other trackers in the cluster.
+	Peers []string `yaml:"peers"`
+
+	// PeerTTL is how long announced peers are kept without announcing again.
+	PeerTTL time.Duration `yaml:"peer_ttl"`
+
+	// Interval is how often buffered updates are pushed to other trackers.
+	Interval time.Duration `yaml:"interval"`
+
+	// MaxPending bounds the number of updates buffered between pushes. Updates
+	// beyond the limit are only stored locally until peers announce again.
+	MaxPending int `yaml:"max_pending"`
+
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c *GossipConfig) applyDefaults() {
+	if c.PeerTTL == 0 {
+		c.PeerTTL = 5 * time.Hour
+	}
+	if c.Interval == 0 {
+		c.Interval = time.Second
+	}
+	if c.MaxPending == 0 {
+		c.MaxPending = 100000
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+}
+
+// gossipUpdate is a peer replicated between trackers.
+type gossipUpdate struct {
+	InfoHash  string `json:"info_hash"`
+	Peer      string `json:"peer"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// GossipStore is an in-memory Store which replicates announced peers to the
+// other trackers of a cluster over HTTP, such that trackers do not depend on
+// an external database. Updates are pushed to every peer, and restarted
+// trackers pull a snapshot from the first available peer. Updates lost while a
+// tracker is unavailable are recovered as peers announce again.
+type GossipStore struct {
+	config GossipConfig
+	clk    clock.Clock
+	store  *memoryStore
+	server *http.Server
+
+	mu      sync.Mutex
+	pending []gossipUpdate
+}
+
+// NewGossipStore creates a new GossipStore and starts serving updates on
+// config.ListenAddr.
+func NewGossipStore(config GossipConfig, clk clock.Clock, stats tally.Scope) (*GossipStore, error) {
+	if config.ListenAddr == "" {
+		return nil, fmt.Errorf("invalid config: missing listen addr")
+	}
+	l, err := net.Listen("tcp", config.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %s", err)
+	}
+	return newGossipStore(config, clk, stats, l), nil
+}
+
+func newGossipStore(
+	config GossipConfig, clk clock.Clock, stats tally.Scope, l net.Listener) *GossipStore {
+
+	config.applyDefaults()
+
+	s := &GossipStore{
+		config: config,
+		clk:    clk,
+		store:  newMemoryStore(clk, config.PeerTTL, stats),
+	}
+	s.server = &http.Server{Handler: s.handler()}
+
+	s.pullSnapshot()
+
+	go s.server.Serve(l)
+	go s.pushLoop()
+
+	return s
+}
+
+func (s *GossipStore) handler() http.Handler {
+	r := chi.NewRouter()
+	r.Post("/gossip/updates", handler.Wrap(s.updatesHandler))
+	r.Get("/gossip/snapshot", handler.Wrap(s.snapshotHandler))
+	return r
+}
+
+// UpdatePeer stores p locally and buffers it for replication.
+func (s *GossipStore) UpdatePeer(h core.InfoHash, p *core.PeerInfo) error {
+	expiresAt := s.clk.Now().Add(s.config.PeerTTL)
+	s.store.set(h, identityOf(p), p.Complete, expiresAt)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.config.Peers) == 0 || len(s.pending) >= s.config.MaxPending {
+		return nil
+	}
+	s.pending = append(s.pending, gossipUpdate{
+		InfoHash:  h.String(),
+		Peer:      serializePeer(p),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	return nil
+}
+
+// GetPeers returns at most n random peers announcing for h to any tracker.
+func (s *GossipStore) GetPeers(h core.InfoHash, n int) ([]*core.PeerInfo, error) {
+	return s.store.GetPeers(h, n)
+}
+
+// Swarms returns the stats of every swarm known to the tracker.
+func (s *GossipStore) Swarms() ([]SwarmStats, error) {
+	return s.store.Swarms()
+}
+
+// EvictPeer removes the peer with peerID from h on this tracker only. Evictions
+// are not replicated, so other trackers keep handing out the peer until it
+// expires.
+func (s *GossipStore) EvictPeer(h core.InfoHash, peerID core.PeerID) error {
+	return s.store.EvictPeer(h, peerID)
+}
+
+// EvictSwarm removes all peers of h on this tracker only.
+func (s *GossipStore) EvictSwarm(h core.InfoHash) error {
+	return s.store.EvictSwarm(h)
+}
+
+func (s *GossipStore) apply(updates []gossipUpdate) {
+	for _, u := range updates {
+		h, err := core.NewInfoHashFromHex(u.InfoHash)
+		if err != nil {
+			log.Errorf("Error parsing gossiped info hash %q: %s", u.InfoHash, err)
+			continue
+		}
+		id, complete, err := deserializePeer(u.Peer)
+		if err != nil {
+			log.Errorf("Error deserializing gossiped peer %q: %s", u.Peer, err)
+			continue
+		}
+		s.store.set(h, id, complete, time.Unix(u.ExpiresAt, 0))
+	}
+}
+
+func (s *GossipStore) updatesHandler(w http.ResponseWriter, r *http.Request) error {
+	var updates []gossipUpdate
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		return handler.Errorf("json: %s", err).Status(http.StatusBadRequest)
+	}
+	s.apply(updates)
+	return nil
+}
+
+func (s *GossipStore) snapshotHandler(w http.ResponseWriter, r *http.Request) error {
+	var updates []gossipUpdate
+	s.store.each(func(h core.InfoHash, id peerIdentity, complete bool, expiresAt time.Time) {
+		p := core.NewPeerInfo(id.peerID, id.ip, id.port, false, complete)
+		p.Zone = id.zone
+		p.Rack = id.rack
+		updates = append(updates, gossipUpdate{
+			InfoHash:  h.String(),
+			Peer:      serializePeer(p),
+			ExpiresAt: expiresAt.Unix(),
+		})
+	})
+	if err := json.NewEncoder(w).Encode(updates); err != nil {
+		return handler.Errorf("json: %s", err)
+	}
+	return nil
+}
+
+// pullSnapshot loads the peers of the first available tracker.
+func (s *GossipStore) pullSnapshot() {
+	for _, addr := range s.config.Peers {
+		resp, err := httputil.Get(
+			fmt.Sprintf("http://%s/gossip/snapshot", addr),
+			httputil.SendTimeout(s.config.Timeout))
+		if err != nil {
+			log.Infof("Error pulling peer store snapshot from %s: %s", addr, err)
+			continue
+		}
+		var updates []gossipUpdate
+		err = json.NewDecoder(resp.Body).Decode(&updates)
+		resp.Body.Close()
+		if err != nil {
+			log.Infof("Error decoding peer store snapshot from %s: %s", addr, err)
+			continue
+		}
+		s.apply(updates)
+		return
+	}
+}
+
+func (s *GossipStore) pushLoop() {
+	for range s.clk.Tick(s.config.Interval) {
+		s.push()
+		s.store.cleanup()
+	}
+}
+
+// push sends buffered updates to every peer.
+func (s *GossipStore) push() {
+	s.mu.Lock()
+	updates := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	if len(updates) == 0 {
+		return
+	}
+	b, err := json.Marshal(updates)
+	if err != nil {
+		log.Errorf("Error encoding peer store updates: %s", err)
+		return
+	}
+	for _, addr := range s.config.Peers {
+		resp, err := httputil.Post(
+			fmt.Sprintf("http://%s/gossip/updates", addr),
+			httputil.SendBody(bytes.NewReader(b)),
+			httputil.SendTimeout(s.config.Timeout))
+		if err != nil {
+			log.Infof("Error pushing peer store updates to %s: %s", addr, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
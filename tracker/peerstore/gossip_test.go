@@ -0,0 +1,87 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"net"
+	"testing"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func gossipListenerFixture() net.Listener {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+func TestGossipStoreReplicatesUpdates(t *testing.T) {
+	require := require.New(t)
+
+	l1 := gossipListenerFixture()
+	l2 := gossipListenerFixture()
+
+	s1 := newGossipStore(
+		GossipConfig{Peers: []string{l2.Addr().String()}}, clock.NewMock(), tally.NoopScope, l1)
+	defer s1.server.Close()
+
+	s2 := newGossipStore(
+		GossipConfig{Peers: []string{l1.Addr().String()}}, clock.NewMock(), tally.NoopScope, l2)
+	defer s2.server.Close()
+
+	h := core.InfoHashFixture()
+	p1 := core.PeerInfoFixture()
+	p2 := core.PeerInfoFixture()
+	p2.Complete = true
+
+	require.NoError(s1.UpdatePeer(h, p1))
+	require.NoError(s2.UpdatePeer(h, p2))
+	s1.push()
+	s2.push()
+
+	for _, s := range []*GossipStore{s1, s2} {
+		peers, err := s.GetPeers(h, 10)
+		require.NoError(err)
+		require.ElementsMatch([]*core.PeerInfo{p1, p2}, peers)
+	}
+}
+
+func TestGossipStorePullsSnapshotOnStart(t *testing.T) {
+	require := require.New(t)
+
+	l1 := gossipListenerFixture()
+	s1 := newGossipStore(GossipConfig{}, clock.NewMock(), tally.NoopScope, l1)
+	defer s1.server.Close()
+
+	h := core.InfoHashFixture()
+	p := core.PeerInfoFixture()
+	require.NoError(s1.UpdatePeer(h, p))
+
+	// s1 has no peers, so nothing is buffered.
+	require.Empty(s1.pending)
+
+	s2 := newGossipStore(
+		GossipConfig{Peers: []string{l1.Addr().String()}}, clock.NewMock(), tally.NoopScope, gossipListenerFixture())
+	defer s2.server.Close()
+
+	peers, err := s2.GetPeers(h, 1)
+	require.NoError(err)
+	require.Equal([]*core.PeerInfo{p}, peers)
+}
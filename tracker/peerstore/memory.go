@@ -0,0 +1,179 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+)
+
+// memoryEntry is a peer which expires at a point in time.
+type memoryEntry struct {
+	complete  bool
+	expiresAt time.Time
+}
+
+// memoryStore is a thread-safe, in-memory Store whose peers expire after a TTL.
+type memoryStore struct {
+	clk   clock.Clock
+	ttl   time.Duration
+	churn churnStats
+
+	mu       sync.Mutex
+	torrents map[core.InfoHash]map[peerIdentity]memoryEntry
+}
+
+func newMemoryStore(clk clock.Clock, ttl time.Duration, stats tally.Scope) *memoryStore {
+	return &memoryStore{
+		clk:      clk,
+		ttl:      ttl,
+		churn:    newChurnStats(stats),
+		torrents: make(map[core.InfoHash]map[peerIdentity]memoryEntry),
+	}
+}
+
+func identityOf(p *core.PeerInfo) peerIdentity {
+	return peerIdentity{p.PeerID, p.IP, p.Port, p.Zone, p.Rack}
+}
+
+// UpdatePeer adds p to h, expiring after the TTL.
+func (s *memoryStore) UpdatePeer(h core.InfoHash, p *core.PeerInfo) error {
+	s.set(h, identityOf(p), p.Complete, s.clk.Now().Add(s.ttl))
+	return nil
+}
+
+// set stores id under h until expiresAt. Later expirations win, such that
+// replicated updates may arrive out of order.
+func (s *memoryStore) set(h core.InfoHash, id peerIdentity, complete bool, expiresAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers, ok := s.torrents[h]
+	if !ok {
+		peers = make(map[peerIdentity]memoryEntry)
+		s.torrents[h] = peers
+	}
+	e, ok := peers[id]
+	if ok && e.expiresAt.After(expiresAt) {
+		return
+	}
+	if !ok {
+		s.churn.joins.Inc(1)
+	}
+	peers[id] = memoryEntry{complete, expiresAt}
+}
+
+// GetPeers returns at most n random unexpired peers of h.
+func (s *memoryStore) GetPeers(h core.InfoHash, n int) ([]*core.PeerInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clk.Now()
+	var peers []*core.PeerInfo
+	for id, e := range s.torrents[h] {
+		if !now.Before(e.expiresAt) {
+			delete(s.torrents[h], id)
+			s.churn.expiries.Inc(1)
+			continue
+		}
+		p := core.NewPeerInfo(id.peerID, id.ip, id.port, false, e.complete)
+		p.Zone = id.zone
+		p.Rack = id.rack
+		peers = append(peers, p)
+	}
+	if len(s.torrents[h]) == 0 {
+		delete(s.torrents, h)
+	}
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > n {
+		peers = peers[:n]
+	}
+	return peers, nil
+}
+
+// Swarms returns the stats of every swarm with unexpired peers.
+func (s *memoryStore) Swarms() ([]SwarmStats, error) {
+	counter := make(swarmCounter)
+	s.each(func(h core.InfoHash, _ peerIdentity, complete bool, _ time.Time) {
+		counter.add(h, complete)
+	})
+	return counter.stats(), nil
+}
+
+// EvictPeer removes the peer with peerID from h.
+func (s *memoryStore) EvictPeer(h core.InfoHash, peerID core.PeerID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id := range s.torrents[h] {
+		if id.peerID == peerID {
+			delete(s.torrents[h], id)
+			s.churn.leaves.Inc(1)
+		}
+	}
+	if len(s.torrents[h]) == 0 {
+		delete(s.torrents, h)
+	}
+	return nil
+}
+
+// EvictSwarm removes all peers of h.
+func (s *memoryStore) EvictSwarm(h core.InfoHash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.churn.leaves.Inc(int64(len(s.torrents[h])))
+	delete(s.torrents, h)
+	return nil
+}
+
+// each calls f with every unexpired peer.
+func (s *memoryStore) each(f func(h core.InfoHash, id peerIdentity, complete bool, expiresAt time.Time)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clk.Now()
+	for h, peers := range s.torrents {
+		for id, e := range peers {
+			if now.Before(e.expiresAt) {
+				f(h, id, e.complete, e.expiresAt)
+			}
+		}
+	}
+}
+
+// cleanup removes expired peers.
+func (s *memoryStore) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clk.Now()
+	for h, peers := range s.torrents {
+		for id, e := range peers {
+			if !now.Before(e.expiresAt) {
+				delete(peers, id)
+				s.churn.expiries.Inc(1)
+			}
+		}
+		if len(peers) == 0 {
+			delete(s.torrents, h)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestMemoryStorePeersExpire(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	s := newMemoryStore(clk, time.Minute, tally.NoopScope)
+
+	h := core.InfoHashFixture()
+	p := core.PeerInfoFixture()
+	require.NoError(s.UpdatePeer(h, p))
+
+	clk.Add(59 * time.Second)
+	peers, err := s.GetPeers(h, 1)
+	require.NoError(err)
+	require.Equal([]*core.PeerInfo{p}, peers)
+
+	clk.Add(time.Second)
+	peers, err = s.GetPeers(h, 1)
+	require.NoError(err)
+	require.Empty(peers)
+}
+
+func TestMemoryStoreSamplesPeers(t *testing.T) {
+	require := require.New(t)
+
+	s := newMemoryStore(clock.NewMock(), time.Minute, tally.NoopScope)
+
+	h := core.InfoHashFixture()
+	for i := 0; i < 10; i++ {
+		require.NoError(s.UpdatePeer(h, core.PeerInfoFixture()))
+	}
+
+	peers, err := s.GetPeers(h, 3)
+	require.NoError(err)
+	require.Len(peers, 3)
+}
+
+func TestMemoryStoreKeepsLatestExpiration(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	s := newMemoryStore(clk, time.Minute, tally.NoopScope)
+
+	h := core.InfoHashFixture()
+	p := core.PeerInfoFixture()
+	id := identityOf(p)
+
+	s.set(h, id, true, clk.Now().Add(time.Hour))
+	s.set(h, id, false, clk.Now().Add(time.Minute))
+
+	peers, err := s.GetPeers(h, 1)
+	require.NoError(err)
+	require.Len(peers, 1)
+	require.True(peers[0].Complete)
+}
+
+func TestMemoryStoreSwarms(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	s := newMemoryStore(clk, time.Minute, tally.NoopScope)
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+
+	seeder := core.PeerInfoFixture()
+	seeder.Complete = true
+	require.NoError(s.UpdatePeer(h1, seeder))
+	require.NoError(s.UpdatePeer(h2, core.PeerInfoFixture()))
+
+	clk.Add(30 * time.Second)
+	require.NoError(s.UpdatePeer(h1, core.PeerInfoFixture()))
+
+	swarms, err := s.Swarms()
+	require.NoError(err)
+	require.ElementsMatch([]SwarmStats{
+		{InfoHash: h1, Seeders: 1, Leechers: 1},
+		{InfoHash: h2, Seeders: 0, Leechers: 1},
+	}, swarms)
+
+	// Only the last leecher of h1 remains.
+	clk.Add(30 * time.Second)
+	swarms, err = s.Swarms()
+	require.NoError(err)
+	require.Equal([]SwarmStats{{InfoHash: h1, Seeders: 0, Leechers: 1}}, swarms)
+}
+
+func TestMemoryStoreEvict(t *testing.T) {
+	require := require.New(t)
+
+	s := newMemoryStore(clock.NewMock(), time.Minute, tally.NoopScope)
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+
+	bad := core.PeerInfoFixture()
+	good := core.PeerInfoFixture()
+	require.NoError(s.UpdatePeer(h1, bad))
+	require.NoError(s.UpdatePeer(h1, good))
+	require.NoError(s.UpdatePeer(h2, core.PeerInfoFixture()))
+
+	require.NoError(s.EvictPeer(h1, bad.PeerID))
+
+	peers, err := s.GetPeers(h1, 10)
+	require.NoError(err)
+	require.Equal([]*core.PeerInfo{good}, peers)
+
+	require.NoError(s.EvictSwarm(h2))
+
+	peers, err = s.GetPeers(h2, 10)
+	require.NoError(err)
+	require.Empty(peers)
+}
+
+func TestMemoryStoreChurnStats(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	stats := tally.NewTestScope("", nil)
+	s := newMemoryStore(clk, time.Minute, stats)
+
+	h := core.InfoHashFixture()
+	p1 := core.PeerInfoFixture()
+	p2 := core.PeerInfoFixture()
+
+	require.NoError(s.UpdatePeer(h, p1))
+	require.NoError(s.UpdatePeer(h, p1))
+	require.NoError(s.UpdatePeer(h, p2))
+	require.NoError(s.EvictPeer(h, p2.PeerID))
+
+	clk.Add(time.Minute)
+	_, err := s.GetPeers(h, 10)
+	require.NoError(err)
+
+	counters := stats.Snapshot().Counters()
+	require.Equal(int64(2), counters["peer_joins+"].Value())
+	require.Equal(int64(1), counters["peer_leaves+"].Value())
+	require.Equal(int64(1), counters["peer_expiries+"].Value())
+}
@@ -14,21 +14,44 @@
 package peerstore
 
 import (
-	"errors"
 	"fmt"
+	"math/rand"
 	"strconv"
 	"strings"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/utils/log"
-	"github.com/uber/kraken/utils/randutil"
 
 	"github.com/andres-erbsen/clock"
 	"github.com/garyburd/redigo/redis"
+	"github.com/uber-go/tally"
 )
 
-func peerSetKey(h core.InfoHash, window int64) string {
-	return fmt.Sprintf("peerset:%s:%d", h.String(), window)
+const _redis = "redis"
+
+func init() {
+	Register(_redis, redisFactory{})
+}
+
+type redisFactory struct{}
+
+func (redisFactory) Create(config Config, clk clock.Clock, stats tally.Scope) (Store, error) {
+	return NewRedisStore(config.Redis, clk, stats)
+}
+
+// peerSetKey is the key of the sorted set of peers of h, scored by the unix
+// time at which each peer expires.
+func peerSetKey(h core.InfoHash) string {
+	return fmt.Sprintf("peers:%s", h.String())
+}
+
+// parsePeerSetKey returns the info hash of a key created by peerSetKey.
+func parsePeerSetKey(k string) (core.InfoHash, error) {
+	parts := strings.Split(k, ":")
+	if len(parts) != 2 || parts[0] != "peers" {
+		return core.InfoHash{}, fmt.Errorf("invalid peer set key %q", k)
+	}
+	return core.NewInfoHashFromHex(parts[1])
 }
 
 func serializePeer(p *core.PeerInfo) string {
@@ -36,161 +59,357 @@ func serializePeer(p *core.PeerInfo) string {
 	if p.Complete {
 		completeBit = 1
 	}
-	return fmt.Sprintf("%s:%s:%d:%d", p.PeerID.String(), p.IP, p.Port, completeBit)
+	s := fmt.Sprintf("%s:%s:%d:%d", p.PeerID.String(), p.IP, p.Port, completeBit)
+	if p.Zone != "" || p.Rack != "" {
+		// Topology labels are appended as an optional suffix so peers encoded
+		// without them remain readable.
+		s += fmt.Sprintf("#%s#%s", p.Zone, p.Rack)
+	}
+	return s
 }
 
 type peerIdentity struct {
 	peerID core.PeerID
 	ip     string
 	port   int
+	zone   string
+	rack   string
 }
 
 func deserializePeer(s string) (id peerIdentity, complete bool, err error) {
+	var zone, rack string
+	if labels := strings.Split(s, "#"); len(labels) == 3 {
+		s, zone, rack = labels[0], labels[1], labels[2]
+	}
+
+	// IPv6 addresses contain colons, so the ip is everything between the peer
+	// id and the last two fields.
 	parts := strings.Split(s, ":")
-	if len(parts) != 4 {
+	if len(parts) < 4 {
 		return id, false, fmt.Errorf("invalid peer encoding: expected 'pid:ip:port:complete'")
 	}
+	n := len(parts)
 	peerID, err := core.NewPeerID(parts[0])
 	if err != nil {
 		return id, false, fmt.Errorf("parse peer id: %s", err)
 	}
-	ip := parts[1]
-	port, err := strconv.Atoi(parts[2])
+	ip := strings.Join(parts[1:n-2], ":")
+	port, err := strconv.Atoi(parts[n-2])
 	if err != nil {
 		return id, false, fmt.Errorf("parse port: %s", err)
 	}
-	id = peerIdentity{peerID, ip, port}
-	complete = parts[3] == "1"
+	id = peerIdentity{peerID, ip, port, zone, rack}
+	complete = parts[n-1] == "1"
 	return id, complete, nil
 }
 
-// RedisStore is a Store backed by Redis.
+// RedisStore is a Store backed by Redis. Supports standalone instances, Redis
+// Cluster, and Sentinel-managed deployments.
+//
+// Each swarm is a sorted set of peers scored by their expiration time. Expired
+// peers are pruned whenever their swarm is read, such that they are never
+// handed out, and swarms which are not read are compacted in the background.
 type RedisStore struct {
 	config RedisConfig
-	pool   *redis.Pool
+	client redisClient
 	clk    clock.Clock
+	churn  churnStats
 }
 
 // NewRedisStore creates a new RedisStore.
-func NewRedisStore(config RedisConfig, clk clock.Clock) (*RedisStore, error) {
+func NewRedisStore(config RedisConfig, clk clock.Clock, stats tally.Scope) (*RedisStore, error) {
 	config.applyDefaults()
 
-	if config.Addr == "" {
-		return nil, errors.New("invalid config: missing addr")
+	client, err := newRedisClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %s", err)
 	}
 
 	s := &RedisStore{
 		config: config,
-		pool: &redis.Pool{
-			Dial: func() (redis.Conn, error) {
-				// TODO Add options
-				return redis.Dial(
-					"tcp",
-					config.Addr,
-					redis.DialConnectTimeout(config.DialTimeout),
-					redis.DialReadTimeout(config.ReadTimeout),
-					redis.DialWriteTimeout(config.WriteTimeout))
-			},
-			MaxIdle:     config.MaxIdleConns,
-			MaxActive:   config.MaxActiveConns,
-			IdleTimeout: config.IdleConnTimeout,
-			Wait:        true,
-		},
-		clk: clk,
+		client: client,
+		clk:    clk,
+		churn:  newChurnStats(stats),
 	}
 
 	// Ensure we can connect to Redis.
-	c, err := s.pool.Dial()
-	if err != nil {
+	if err := client.do("", func(c redis.Conn) error {
+		_, err := c.Do("PING")
+		return err
+	}); err != nil {
+		client.close()
 		return nil, fmt.Errorf("dial redis: %s", err)
 	}
-	c.Close()
 
-	return s, nil
-}
+	go s.compactLoop()
 
-func (s *RedisStore) curPeerSetWindow() int64 {
-	t := s.clk.Now().Unix()
-	return t - (t % int64(s.config.PeerSetWindowSize.Seconds()))
-}
-
-func (s *RedisStore) peerSetWindows() []int64 {
-	cur := s.curPeerSetWindow()
-	ws := make([]int64, s.config.MaxPeerSetWindows)
-	for i := range ws {
-		ws[i] = cur - int64(i)*int64(s.config.PeerSetWindowSize.Seconds())
-	}
-	return ws
+	return s, nil
 }
 
-// UpdatePeer writes p to Redis with a TTL.
+// UpdatePeer adds p to h until it expires after the configured TTL.
 func (s *RedisStore) UpdatePeer(h core.InfoHash, p *core.PeerInfo) error {
-	c := s.pool.Get()
-	defer c.Close()
+	expireAt := s.clk.Now().Add(s.config.PeerTTL).Unix()
 
-	w := s.curPeerSetWindow()
-	expireAt := w + int64(s.config.PeerSetWindowSize.Seconds())*int64(s.config.MaxPeerSetWindows)
+	// Replaces p with its previous completion status, if any.
+	flipped := *p
+	flipped.Complete = !p.Complete
 
-	// Add p to the current window.
-	k := peerSetKey(h, w)
+	k := peerSetKey(h)
 
-	if err := c.Send("SADD", k, serializePeer(p)); err != nil {
-		return fmt.Errorf("send SADD: %s", err)
-	}
-	if err := c.Send("EXPIREAT", k, expireAt); err != nil {
-		return fmt.Errorf("send EXPIREAT: %s", err)
-	}
-	if err := c.Flush(); err != nil {
-		return fmt.Errorf("flush: %s", err)
-	}
-	if _, err := c.Receive(); err != nil {
-		return fmt.Errorf("SADD: %s", err)
+	var removed, added int
+	err := s.client.do(k, func(c redis.Conn) error {
+		if err := c.Send("ZREM", k, serializePeer(&flipped)); err != nil {
+			return fmt.Errorf("send ZREM: %s", err)
+		}
+		if err := c.Send("ZADD", k, expireAt, serializePeer(p)); err != nil {
+			return fmt.Errorf("send ZADD: %s", err)
+		}
+		// The swarm expires with its last peer.
+		if err := c.Send("EXPIREAT", k, expireAt); err != nil {
+			return fmt.Errorf("send EXPIREAT: %s", err)
+		}
+		if err := c.Flush(); err != nil {
+			return fmt.Errorf("flush: %s", err)
+		}
+		var err error
+		if removed, err = redis.Int(c.Receive()); err != nil {
+			return fmt.Errorf("ZREM: %s", err)
+		}
+		if added, err = redis.Int(c.Receive()); err != nil {
+			return fmt.Errorf("ZADD: %s", err)
+		}
+		if _, err := c.Receive(); err != nil {
+			return fmt.Errorf("EXPIREAT: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	if _, err := c.Receive(); err != nil {
-		return fmt.Errorf("EXPIREAT: %s", err)
+	if added > removed {
+		s.churn.joins.Inc(int64(added - removed))
 	}
 	return nil
 }
 
-// GetPeers returns at most n PeerInfos associated with h.
+// prune removes the expired peers of the set at k.
+func (s *RedisStore) prune(c redis.Conn, k string) (int, error) {
+	n, err := redis.Int(c.Do("ZREMRANGEBYSCORE", k, "-inf", s.clk.Now().Unix()))
+	if err != nil {
+		return 0, fmt.Errorf("ZREMRANGEBYSCORE: %s", err)
+	}
+	return n, nil
+}
+
+// GetPeers returns at most n PeerInfos associated with h. Expired peers are
+// pruned before sampling.
 func (s *RedisStore) GetPeers(h core.InfoHash, n int) ([]*core.PeerInfo, error) {
-	c := s.pool.Get()
-	defer c.Close()
-
-	// Try to sample n peers from each window in randomized order until we have
-	// collected n distinct peers. This achieves random sampling across multiple
-	// windows.
-	// TODO(codyg): One limitation of random window sampling is we're no longer
-	// guaranteed to include the latest completion bits. A simple way to mitigate
-	// this is to decrease the number of windows.
-	windows := s.peerSetWindows()
-	randutil.ShuffleInt64s(windows)
-
-	// Eliminate duplicates from other windows and collapses complete bits.
-	selected := make(map[peerIdentity]bool)
+	k := peerSetKey(h)
 
-	for i := 0; len(selected) < n && i < len(windows); i++ {
-		k := peerSetKey(h, windows[i])
-		result, err := redis.Strings(c.Do("SRANDMEMBER", k, n-len(selected)))
-		if err == redis.ErrNil {
-			continue
-		} else if err != nil {
-			return nil, err
+	var expired int
+	var members []string
+	err := s.client.do(k, func(c redis.Conn) error {
+		var err error
+		expired, err = s.prune(c, k)
+		if err != nil {
+			return err
+		}
+		size, err := redis.Int(c.Do("ZCARD", k))
+		if err != nil {
+			return fmt.Errorf("ZCARD: %s", err)
 		}
-		for _, s := range result {
-			id, complete, err := deserializePeer(s)
+		if size <= n {
+			members, err = redis.Strings(c.Do("ZRANGE", k, 0, -1))
 			if err != nil {
-				log.Errorf("Error deserializing peer %q: %s", s, err)
-				continue
+				return fmt.Errorf("ZRANGE: %s", err)
+			}
+			return nil
+		}
+		// Sample a contiguous range of n peers from a random offset, wrapping
+		// around the end of the set. Since peers are ordered by expiration, and
+		// thus by when they last announced, this spreads handouts across peers.
+		start := rand.Intn(size)
+		members, err = redis.Strings(c.Do("ZRANGE", k, start, start+n-1))
+		if err != nil {
+			return fmt.Errorf("ZRANGE: %s", err)
+		}
+		if wrap := start + n - size; wrap > 0 {
+			rest, err := redis.Strings(c.Do("ZRANGE", k, 0, wrap-1))
+			if err != nil {
+				return fmt.Errorf("ZRANGE: %s", err)
 			}
-			selected[id] = selected[id] || complete
+			members = append(members, rest...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.churn.expiries.Inc(int64(expired))
+
+	// Collapses complete bits of peers written by trackers which did not
+	// replace previous completion statuses.
+	selected := make(map[peerIdentity]bool)
+	for _, m := range members {
+		id, complete, err := deserializePeer(m)
+		if err != nil {
+			log.Errorf("Error deserializing peer %q: %s", m, err)
+			continue
 		}
+		selected[id] = selected[id] || complete
 	}
 
 	var peers []*core.PeerInfo
 	for id, complete := range selected {
 		p := core.NewPeerInfo(id.peerID, id.ip, id.port, false, complete)
+		p.Zone = id.zone
+		p.Rack = id.rack
 		peers = append(peers, p)
 	}
 	return peers, nil
 }
+
+// eachPeerSetKey calls f with every peer set key on every node.
+func (s *RedisStore) eachPeerSetKey(f func(c redis.Conn, k string) error) error {
+	return s.client.each(func(c redis.Conn) error {
+		cursor := 0
+		for {
+			reply, err := redis.Values(c.Do("SCAN", cursor, "MATCH", "peers:*", "COUNT", 1000))
+			if err != nil {
+				return fmt.Errorf("scan: %s", err)
+			}
+			if len(reply) != 2 {
+				return fmt.Errorf("scan: unexpected reply length %d", len(reply))
+			}
+			cursor, err = redis.Int(reply[0], nil)
+			if err != nil {
+				return fmt.Errorf("scan cursor: %s", err)
+			}
+			keys, err := redis.Strings(reply[1], nil)
+			if err != nil {
+				return fmt.Errorf("scan keys: %s", err)
+			}
+			for _, k := range keys {
+				if err := f(c, k); err != nil {
+					return err
+				}
+			}
+			if cursor == 0 {
+				return nil
+			}
+		}
+	})
+}
+
+// compact prunes the expired peers of every swarm.
+func (s *RedisStore) compact() error {
+	var expired int
+	err := s.eachPeerSetKey(func(c redis.Conn, k string) error {
+		n, err := s.prune(c, k)
+		if err != nil {
+			return err
+		}
+		expired += n
+		return nil
+	})
+	s.churn.expiries.Inc(int64(expired))
+	return err
+}
+
+func (s *RedisStore) compactLoop() {
+	for range s.clk.Tick(s.config.CompactionInterval) {
+		if err := s.compact(); err != nil {
+			log.Errorf("Error compacting peer store: %s", err)
+		}
+	}
+}
+
+// Swarms returns the stats of every swarm in Redis. Scans all keys, so should
+// not be called on the announce path.
+func (s *RedisStore) Swarms() ([]SwarmStats, error) {
+	counter := make(swarmCounter)
+	now := s.clk.Now().Unix()
+	err := s.eachPeerSetKey(func(c redis.Conn, k string) error {
+		h, err := parsePeerSetKey(k)
+		if err != nil {
+			log.Errorf("Error parsing peer set key: %s", err)
+			return nil
+		}
+		members, err := redis.Strings(c.Do("ZRANGEBYSCORE", k, fmt.Sprintf("(%d", now), "+inf"))
+		if err == redis.ErrNil {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("zrangebyscore: %s", err)
+		}
+		// Collapses complete bits of peers which appear more than once.
+		peers := make(map[peerIdentity]bool)
+		for _, m := range members {
+			id, complete, err := deserializePeer(m)
+			if err != nil {
+				log.Errorf("Error deserializing peer %q: %s", m, err)
+				continue
+			}
+			peers[id] = peers[id] || complete
+		}
+		for _, complete := range peers {
+			counter.add(h, complete)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counter.stats(), nil
+}
+
+// EvictPeer removes the peer with peerID from h.
+func (s *RedisStore) EvictPeer(h core.InfoHash, peerID core.PeerID) error {
+	k := peerSetKey(h)
+	var removed int
+	err := s.client.do(k, func(c redis.Conn) error {
+		removed = 0
+		members, err := redis.Strings(c.Do("ZRANGE", k, 0, -1))
+		if err != nil && err != redis.ErrNil {
+			return fmt.Errorf("zrange: %s", err)
+		}
+		for _, m := range members {
+			id, _, err := deserializePeer(m)
+			if err != nil || id.peerID != peerID {
+				continue
+			}
+			n, err := redis.Int(c.Do("ZREM", k, m))
+			if err != nil {
+				return fmt.Errorf("zrem: %s", err)
+			}
+			removed += n
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.churn.leaves.Inc(int64(removed))
+	return nil
+}
+
+// EvictSwarm removes all peers of h.
+func (s *RedisStore) EvictSwarm(h core.InfoHash) error {
+	k := peerSetKey(h)
+	var removed int
+	err := s.client.do(k, func(c redis.Conn) error {
+		var err error
+		removed, err = redis.Int(c.Do("ZCARD", k))
+		if err != nil {
+			return fmt.Errorf("zcard: %s", err)
+		}
+		if _, err := c.Do("DEL", k); err != nil {
+			return fmt.Errorf("del: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.churn.leaves.Inc(int64(removed))
+	return nil
+}
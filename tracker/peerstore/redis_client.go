@@ -0,0 +1,97 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// redisClient executes commands against a Redis topology.
+type redisClient interface {
+	// do runs f with a connection to the node which serves key.
+	do(key string, f func(c redis.Conn) error) error
+
+	// each runs f with a connection to every node which serves keys.
+	each(f func(c redis.Conn) error) error
+
+	close() error
+}
+
+func newRedisClient(config RedisConfig) (redisClient, error) {
+	switch config.Mode {
+	case RedisStandalone:
+		if config.Addr == "" {
+			return nil, fmt.Errorf("missing addr")
+		}
+		return &standaloneClient{newRedisPool(config, config.Addr)}, nil
+	case RedisCluster:
+		if len(config.Addrs) == 0 {
+			return nil, fmt.Errorf("missing addrs")
+		}
+		return newClusterClient(config)
+	case RedisSentinel:
+		if len(config.Addrs) == 0 {
+			return nil, fmt.Errorf("missing addrs")
+		}
+		if config.MasterName == "" {
+			return nil, fmt.Errorf("missing master name")
+		}
+		return newSentinelClient(config)
+	default:
+		return nil, fmt.Errorf("unknown mode %q", config.Mode)
+	}
+}
+
+func dialRedis(config RedisConfig, addr string) (redis.Conn, error) {
+	return redis.Dial(
+		"tcp",
+		addr,
+		redis.DialConnectTimeout(config.DialTimeout),
+		redis.DialReadTimeout(config.ReadTimeout),
+		redis.DialWriteTimeout(config.WriteTimeout))
+}
+
+func newRedisPool(config RedisConfig, addr string) *redis.Pool {
+	return &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return dialRedis(config, addr)
+		},
+		MaxIdle:     config.MaxIdleConns,
+		MaxActive:   config.MaxActiveConns,
+		IdleTimeout: config.IdleConnTimeout,
+		Wait:        true,
+	}
+}
+
+// standaloneClient connects to a single Redis instance.
+type standaloneClient struct {
+	pool *redis.Pool
+}
+
+func (s *standaloneClient) do(key string, f func(c redis.Conn) error) error {
+	c := s.pool.Get()
+	defer c.Close()
+
+	return f(c)
+}
+
+func (s *standaloneClient) each(f func(c redis.Conn) error) error {
+	return s.do("", f)
+}
+
+func (s *standaloneClient) close() error {
+	return s.pool.Close()
+}
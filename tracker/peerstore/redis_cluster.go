@@ -0,0 +1,314 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const numClusterSlots = 16384
+
+// crc16 implements the CRC16-CCITT (XMODEM) checksum which Redis Cluster uses
+// to assign keys to slots.
+func crc16(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// hashSlot returns the cluster slot of key. If key contains a non-empty hash
+// tag, e.g. "{tag}", only the tag is hashed.
+func hashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16([]byte(key))) % numClusterSlots
+}
+
+// slotRange is a range of slots served by the same node.
+type slotRange struct {
+	start, end int
+	addr       string
+}
+
+// parseClusterSlots parses the reply of CLUSTER SLOTS into the masters of
+// each slot range.
+func parseClusterSlots(reply interface{}) ([]slotRange, error) {
+	entries, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	var ranges []slotRange
+	for _, e := range entries {
+		fields, err := redis.Values(e, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid slot range: expected at least 3 fields, got %d", len(fields))
+		}
+		start, err := redis.Int(fields[0], nil)
+		if err != nil {
+			return nil, fmt.Errorf("start: %s", err)
+		}
+		end, err := redis.Int(fields[1], nil)
+		if err != nil {
+			return nil, fmt.Errorf("end: %s", err)
+		}
+		if start < 0 || end >= numClusterSlots || start > end {
+			return nil, fmt.Errorf("invalid slot range %d-%d", start, end)
+		}
+		master, err := redis.Values(fields[2], nil)
+		if err != nil || len(master) < 2 {
+			return nil, errors.New("invalid master")
+		}
+		host, err := redis.String(master[0], nil)
+		if err != nil {
+			return nil, fmt.Errorf("host: %s", err)
+		}
+		port, err := redis.Int(master[1], nil)
+		if err != nil {
+			return nil, fmt.Errorf("port: %s", err)
+		}
+		ranges = append(ranges, slotRange{start, end, net.JoinHostPort(host, strconv.Itoa(port))})
+	}
+	return ranges, nil
+}
+
+// parseRedirect returns the kind ("MOVED" or "ASK") and target address of a
+// cluster redirect error.
+func parseRedirect(err error) (kind string, slot int, addr string, ok bool) {
+	fields := strings.Fields(err.Error())
+	for i := 0; i+2 < len(fields); i++ {
+		if fields[i] != "MOVED" && fields[i] != "ASK" {
+			continue
+		}
+		slot, serr := strconv.Atoi(fields[i+1])
+		if serr != nil {
+			continue
+		}
+		return fields[i], slot, fields[i+2], true
+	}
+	return "", 0, "", false
+}
+
+// clusterClient routes commands to the nodes of a Redis Cluster by key slot,
+// following redirects when slots migrate and refreshing its view of the
+// cluster when nodes fail.
+type clusterClient struct {
+	config RedisConfig
+
+	refreshMu sync.Mutex // Serializes refreshes.
+
+	mu    sync.RWMutex
+	slots [numClusterSlots]string
+	pools map[string]*redis.Pool
+}
+
+func newClusterClient(config RedisConfig) (*clusterClient, error) {
+	c := &clusterClient{
+		config: config,
+		pools:  make(map[string]*redis.Pool),
+	}
+	if err := c.refresh(); err != nil {
+		return nil, fmt.Errorf("cluster slots: %s", err)
+	}
+	return c, nil
+}
+
+func (c *clusterClient) pool(addr string) *redis.Pool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.pools[addr]
+	if !ok {
+		p = newRedisPool(c.config, addr)
+		c.pools[addr] = p
+	}
+	return p
+}
+
+// nodeFor returns the address of the node serving slot, falling back to the
+// first seed node if slot is not known to be served.
+func (c *clusterClient) nodeFor(slot int) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if addr := c.slots[slot]; addr != "" {
+		return addr
+	}
+	return c.config.Addrs[0]
+}
+
+func (c *clusterClient) setNode(slot int, addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.slots[slot] = addr
+}
+
+// candidates returns the addresses to load the slot table from: the known
+// nodes followed by the seed nodes.
+func (c *clusterClient) candidates() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var addrs []string
+	for _, addr := range c.config.Addrs {
+		seen[addr] = true
+	}
+	for addr := range c.pools {
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	return append(addrs, c.config.Addrs...)
+}
+
+// refresh reloads the slot table from the first node which responds.
+func (c *clusterClient) refresh() error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	var lastErr error
+	for _, addr := range c.candidates() {
+		conn := c.pool(addr).Get()
+		reply, err := conn.Do("CLUSTER", "SLOTS")
+		conn.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %s", addr, err)
+			continue
+		}
+		ranges, err := parseClusterSlots(reply)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %s", addr, err)
+			continue
+		}
+		var slots [numClusterSlots]string
+		for _, r := range ranges {
+			for i := r.start; i <= r.end; i++ {
+				slots[i] = r.addr
+			}
+		}
+		c.mu.Lock()
+		c.slots = slots
+		c.mu.Unlock()
+		return nil
+	}
+	return lastErr
+}
+
+func (c *clusterClient) do(key string, f func(c redis.Conn) error) error {
+	slot := hashSlot(key)
+	addr := c.nodeFor(slot)
+	var asking bool
+	for i := 0; ; i++ {
+		conn := c.pool(addr).Get()
+		var err error
+		if asking {
+			_, err = conn.Do("ASKING")
+		}
+		if err == nil {
+			err = f(conn)
+		}
+		broken := conn.Err() != nil
+		conn.Close()
+		if err == nil || i >= c.config.MaxRedirects {
+			return err
+		}
+		if kind, _, target, ok := parseRedirect(err); ok {
+			addr = target
+			asking = kind == "ASK"
+			if kind == "MOVED" {
+				// Slots have been resharded or failed over, so other slots
+				// have likely moved too.
+				c.setNode(slot, target)
+				if rerr := c.refresh(); rerr != nil {
+					log.Errorf("Error refreshing redis cluster slots: %s", rerr)
+				}
+			}
+			continue
+		}
+		if broken {
+			// Node is unreachable, its replica may have been promoted.
+			if rerr := c.refresh(); rerr != nil {
+				return err
+			}
+			addr = c.nodeFor(slot)
+			asking = false
+			continue
+		}
+		return err
+	}
+}
+
+// masters returns the addresses of all nodes serving slots.
+func (c *clusterClient) masters() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var addrs []string
+	for _, addr := range c.slots {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+func (c *clusterClient) each(f func(c redis.Conn) error) error {
+	for _, addr := range c.masters() {
+		conn := c.pool(addr).Get()
+		err := f(conn)
+		conn.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", addr, err)
+		}
+	}
+	return nil
+}
+
+func (c *clusterClient) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, p := range c.pools {
+		p.Close()
+	}
+	return nil
+}
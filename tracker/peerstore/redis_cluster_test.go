@@ -0,0 +1,296 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/alicebob/miniredis"
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+// fakeRedis is a minimal Redis server which replies to commands using handler,
+// for testing topologies which miniredis does not support.
+type fakeRedis struct {
+	l       net.Listener
+	handler func(args []string) interface{}
+}
+
+func newFakeRedis(handler func(args []string) interface{}) *fakeRedis {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		panic(err)
+	}
+	s := &fakeRedis{l, handler}
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(nc)
+		}
+	}()
+	return s
+}
+
+func (s *fakeRedis) Addr() string { return s.l.Addr().String() }
+
+func (s *fakeRedis) Close() { s.l.Close() }
+
+func (s *fakeRedis) serve(nc net.Conn) {
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(nc, encodeReply(s.handler(args))); err != nil {
+			return
+		}
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(line, "*"))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func encodeReply(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "$-1\r\n"
+	case error:
+		return fmt.Sprintf("-%s\r\n", v)
+	case int:
+		return fmt.Sprintf(":%d\r\n", v)
+	case string:
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		s := fmt.Sprintf("*%d\r\n", len(v))
+		for _, e := range v {
+			s += encodeReply(e)
+		}
+		return s
+	default:
+		panic(fmt.Sprintf("unsupported reply type %T", v))
+	}
+}
+
+func slotsReply(ranges ...slotRange) []interface{} {
+	var reply []interface{}
+	for _, r := range ranges {
+		host, portStr, err := net.SplitHostPort(r.addr)
+		if err != nil {
+			panic(err)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			panic(err)
+		}
+		reply = append(reply, []interface{}{r.start, r.end, []interface{}{host, port}})
+	}
+	return reply
+}
+
+func TestHashSlot(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal(12739, hashSlot("123456789"))
+	require.Equal(hashSlot("{user1000}.following"), hashSlot("{user1000}.followers"))
+	require.Equal(hashSlot("bar"), hashSlot("foo{bar}zap"))
+	require.Equal(hashSlot("{bar"), hashSlot("foo{{bar}}zap"))
+	require.NotEqual(hashSlot("bar"), hashSlot("foo{}{bar}"))
+}
+
+func TestParseRedirect(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind string
+		slot int
+		addr string
+		ok   bool
+	}{
+		{errors.New("MOVED 3999 127.0.0.1:6381"), "MOVED", 3999, "127.0.0.1:6381", true},
+		{errors.New("SADD: ASK 3999 127.0.0.1:6381"), "ASK", 3999, "127.0.0.1:6381", true},
+		{errors.New("ERR wrong number of arguments"), "", 0, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.err.Error(), func(t *testing.T) {
+			require := require.New(t)
+
+			kind, slot, addr, ok := parseRedirect(test.err)
+			require.Equal(test.ok, ok)
+			require.Equal(test.kind, kind)
+			require.Equal(test.slot, slot)
+			require.Equal(test.addr, addr)
+		})
+	}
+}
+
+func clusterConfigFixture(addrs ...string) RedisConfig {
+	return RedisConfig{
+		Mode:              RedisCluster,
+		Addrs:             addrs,
+		PeerSetWindowSize: 30 * time.Second,
+		MaxPeerSetWindows: 4,
+	}
+}
+
+func TestRedisStoreClusterRoutesKeysBySlot(t *testing.T) {
+	require := require.New(t)
+
+	n1, err := miniredis.Run()
+	require.NoError(err)
+	defer n1.Close()
+
+	n2, err := miniredis.Run()
+	require.NoError(err)
+	defer n2.Close()
+
+	seed := newFakeRedis(func(args []string) interface{} {
+		return slotsReply(
+			slotRange{0, numClusterSlots/2 - 1, n1.Addr()},
+			slotRange{numClusterSlots / 2, numClusterSlots - 1, n2.Addr()})
+	})
+	defer seed.Close()
+
+	s, err := NewRedisStore(clusterConfigFixture(seed.Addr()), clock.New(), tally.NoopScope)
+	require.NoError(err)
+
+	for i := 0; i < 20; i++ {
+		h := core.InfoHashFixture()
+		p := core.PeerInfoFixture()
+		require.NoError(s.UpdatePeer(h, p))
+
+		peers, err := s.GetPeers(h, 1)
+		require.NoError(err)
+		require.Equal([]*core.PeerInfo{p}, peers)
+
+		k := peerSetKey(h)
+		expected, other := n1, n2
+		if hashSlot(k) >= numClusterSlots/2 {
+			expected, other = n2, n1
+		}
+		require.True(expected.Exists(k))
+		require.False(other.Exists(k))
+	}
+}
+
+func TestRedisStoreClusterFollowsMovedRedirects(t *testing.T) {
+	require := require.New(t)
+
+	target, err := miniredis.Run()
+	require.NoError(err)
+	defer target.Close()
+
+	var mu sync.Mutex
+	var moved bool
+	var source *fakeRedis
+	source = newFakeRedis(func(args []string) interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch strings.ToUpper(args[0]) {
+		case "CLUSTER":
+			if moved {
+				return slotsReply(slotRange{0, numClusterSlots - 1, target.Addr()})
+			}
+			return slotsReply(slotRange{0, numClusterSlots - 1, source.Addr()})
+		case "PING":
+			return "PONG"
+		default:
+			// Slots are migrated once the first key is written.
+			moved = true
+			return fmt.Errorf("MOVED %d %s", hashSlot(args[1]), target.Addr())
+		}
+	})
+	defer source.Close()
+
+	s, err := NewRedisStore(clusterConfigFixture(source.Addr()), clock.New(), tally.NoopScope)
+	require.NoError(err)
+
+	h := core.InfoHashFixture()
+	p := core.PeerInfoFixture()
+	require.NoError(s.UpdatePeer(h, p))
+
+	peers, err := s.GetPeers(h, 1)
+	require.NoError(err)
+	require.Equal([]*core.PeerInfo{p}, peers)
+	require.True(target.Exists(peerSetKey(h)))
+}
+
+func TestNewRedisStoreInvalidConfig(t *testing.T) {
+	tests := []struct {
+		desc   string
+		config RedisConfig
+	}{
+		{"standalone without addr", RedisConfig{}},
+		{"cluster without addrs", RedisConfig{Mode: RedisCluster}},
+		{"sentinel without addrs", RedisConfig{Mode: RedisSentinel, MasterName: "m"}},
+		{"sentinel without master name", RedisConfig{Mode: RedisSentinel, Addrs: []string{"a:1"}}},
+		{"unknown mode", RedisConfig{Mode: "ring", Addr: "a:1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := NewRedisStore(test.config, clock.New(), tally.NoopScope)
+			require.Error(t, err)
+		})
+	}
+}
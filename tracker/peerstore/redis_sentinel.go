@@ -0,0 +1,185 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// sentinelClient connects to the master of a Redis deployment monitored by
+// Sentinel, and reconnects to the new master after failovers.
+type sentinelClient struct {
+	config RedisConfig
+
+	mu        sync.Mutex
+	sentinels []string // Ordered by preference.
+	pool      *redis.Pool
+}
+
+func newSentinelClient(config RedisConfig) (*sentinelClient, error) {
+	s := &sentinelClient{
+		config:    config,
+		sentinels: append([]string(nil), config.Addrs...),
+	}
+	s.pool = s.newPool()
+	return s, nil
+}
+
+// masterAddr asks the sentinels for the address of the current master. The
+// first sentinel which answers is preferred in the future.
+func (s *sentinelClient) masterAddr() (string, error) {
+	s.mu.Lock()
+	sentinels := append([]string(nil), s.sentinels...)
+	s.mu.Unlock()
+
+	var lastErr error
+	for i, addr := range sentinels {
+		master, err := s.queryMaster(addr)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %s", addr, err)
+			continue
+		}
+		if i > 0 {
+			s.mu.Lock()
+			s.sentinels = append([]string{addr}, append(sentinels[:i], sentinels[i+1:]...)...)
+			s.mu.Unlock()
+		}
+		return master, nil
+	}
+	return "", fmt.Errorf("no sentinel available: %s", lastErr)
+}
+
+func (s *sentinelClient) queryMaster(addr string) (string, error) {
+	c, err := dialRedis(s.config, addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	r, err := redis.Strings(c.Do("SENTINEL", "get-master-addr-by-name", s.config.MasterName))
+	if err != nil {
+		return "", err
+	}
+	if len(r) != 2 {
+		return "", fmt.Errorf("unknown master %q", s.config.MasterName)
+	}
+	return net.JoinHostPort(r[0], r[1]), nil
+}
+
+// checkMaster returns an error if c is connected to a node which no longer
+// reports being a master, e.g. a demoted master after a failover.
+func checkMaster(c redis.Conn) error {
+	r, err := redis.Values(c.Do("ROLE"))
+	if err != nil {
+		if c.Err() != nil {
+			return err
+		}
+		// Nodes which do not support ROLE are assumed to be masters.
+		return nil
+	}
+	if len(r) == 0 {
+		return errors.New("empty role")
+	}
+	role, err := redis.String(r[0], nil)
+	if err != nil {
+		return fmt.Errorf("role: %s", err)
+	}
+	if role != "master" {
+		return fmt.Errorf("node is %s, not master", role)
+	}
+	return nil
+}
+
+func (s *sentinelClient) newPool() *redis.Pool {
+	return &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			addr, err := s.masterAddr()
+			if err != nil {
+				return nil, err
+			}
+			c, err := dialRedis(s.config, addr)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkMaster(c); err != nil {
+				c.Close()
+				return nil, fmt.Errorf("%s: %s", addr, err)
+			}
+			return c, nil
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Second {
+				return nil
+			}
+			return checkMaster(c)
+		},
+		MaxIdle:     s.config.MaxIdleConns,
+		MaxActive:   s.config.MaxActiveConns,
+		IdleTimeout: s.config.IdleConnTimeout,
+		Wait:        true,
+	}
+}
+
+func (s *sentinelClient) getPool() *redis.Pool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pool
+}
+
+// reset replaces old with a new pool, such that new connections are made to
+// the current master. No-op if old has already been replaced.
+func (s *sentinelClient) reset(old *redis.Pool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pool == old {
+		s.pool = s.newPool()
+		old.Close()
+	}
+}
+
+func (s *sentinelClient) do(key string, f func(c redis.Conn) error) error {
+	pool := s.getPool()
+	c := pool.Get()
+	err := f(c)
+	broken := c.Err() != nil
+	c.Close()
+	if err == nil {
+		return nil
+	}
+	if !broken && !strings.Contains(err.Error(), "READONLY") {
+		return err
+	}
+	// The master may have failed over: retry once against the current master.
+	s.reset(pool)
+	c = s.getPool().Get()
+	defer c.Close()
+	return f(c)
+}
+
+func (s *sentinelClient) each(f func(c redis.Conn) error) error {
+	return s.do("", f)
+}
+
+func (s *sentinelClient) close() error {
+	return s.getPool().Close()
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/alicebob/miniredis"
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+// fakeSentinel reports a configurable master.
+type fakeSentinel struct {
+	*fakeRedis
+
+	mu     sync.Mutex
+	master string
+}
+
+func newFakeSentinel(master string) *fakeSentinel {
+	s := &fakeSentinel{master: master}
+	s.fakeRedis = newFakeRedis(func(args []string) interface{} {
+		if strings.ToUpper(args[0]) != "SENTINEL" || len(args) != 3 || args[2] != "mymaster" {
+			return errors.New("ERR unsupported")
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		host, port, err := net.SplitHostPort(s.master)
+		if err != nil {
+			panic(err)
+		}
+		return []interface{}{host, port}
+	})
+	return s
+}
+
+func (s *fakeSentinel) failover(master string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.master = master
+}
+
+func sentinelConfigFixture(addrs ...string) RedisConfig {
+	return RedisConfig{
+		Mode:              RedisSentinel,
+		Addrs:             addrs,
+		MasterName:        "mymaster",
+		PeerSetWindowSize: 30 * time.Second,
+		MaxPeerSetWindows: 4,
+	}
+}
+
+func TestRedisStoreSentinelReconnectsAfterFailover(t *testing.T) {
+	require := require.New(t)
+
+	m1, err := miniredis.Run()
+	require.NoError(err)
+
+	m2, err := miniredis.Run()
+	require.NoError(err)
+	defer m2.Close()
+
+	sentinel := newFakeSentinel(m1.Addr())
+	defer sentinel.Close()
+
+	s, err := NewRedisStore(sentinelConfigFixture(sentinel.Addr()), clock.New(), tally.NoopScope)
+	require.NoError(err)
+
+	h := core.InfoHashFixture()
+	k := peerSetKey(h)
+
+	require.NoError(s.UpdatePeer(h, core.PeerInfoFixture()))
+	require.True(m1.Exists(k))
+
+	sentinel.failover(m2.Addr())
+	m1.Close()
+
+	p := core.PeerInfoFixture()
+	require.NoError(s.UpdatePeer(h, p))
+	require.True(m2.Exists(k))
+
+	peers, err := s.GetPeers(h, 1)
+	require.NoError(err)
+	require.Equal([]*core.PeerInfo{p}, peers)
+}
+
+func TestRedisStoreSentinelSkipsUnavailableSentinels(t *testing.T) {
+	require := require.New(t)
+
+	m, err := miniredis.Run()
+	require.NoError(err)
+	defer m.Close()
+
+	// Reserve an address which nothing listens on.
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(err)
+	unavailable := l.Addr().String()
+	l.Close()
+
+	sentinel := newFakeSentinel(m.Addr())
+	defer sentinel.Close()
+
+	s, err := NewRedisStore(sentinelConfigFixture(unavailable, sentinel.Addr()), clock.New(), tally.NoopScope)
+	require.NoError(err)
+
+	h := core.InfoHashFixture()
+	p := core.PeerInfoFixture()
+	require.NoError(s.UpdatePeer(h, p))
+
+	peers, err := s.GetPeers(h, 1)
+	require.NoError(err)
+	require.Equal([]*core.PeerInfo{p}, peers)
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/alicebob/miniredis"
 	"github.com/andres-erbsen/clock"
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 )
 
 func redisConfigFixture() RedisConfig {
@@ -41,7 +42,7 @@ func TestRedisStoreGetPeersPopulatesPeerInfoFields(t *testing.T) {
 
 	config := redisConfigFixture()
 
-	s, err := NewRedisStore(config, clock.New())
+	s, err := NewRedisStore(config, clock.New(), tally.NoopScope)
 	require.NoError(err)
 
 	h := core.InfoHashFixture()
@@ -56,7 +57,47 @@ func TestRedisStoreGetPeersPopulatesPeerInfoFields(t *testing.T) {
 	require.Equal(peers, []*core.PeerInfo{p})
 }
 
-func TestRedisStoreGetPeersFromMultipleWindows(t *testing.T) {
+func TestRedisStoreIPv6Peers(t *testing.T) {
+	require := require.New(t)
+
+	config := redisConfigFixture()
+
+	s, err := NewRedisStore(config, clock.New(), tally.NoopScope)
+	require.NoError(err)
+
+	h := core.InfoHashFixture()
+
+	p := core.NewPeerInfo(core.PeerIDFixture(), "2001:db8::1", 8080, false, true)
+
+	require.NoError(s.UpdatePeer(h, p))
+
+	peers, err := s.GetPeers(h, 1)
+	require.NoError(err)
+	require.Equal(peers, []*core.PeerInfo{p})
+}
+
+func TestRedisStoreTopologyLabels(t *testing.T) {
+	require := require.New(t)
+
+	config := redisConfigFixture()
+
+	s, err := NewRedisStore(config, clock.New(), tally.NoopScope)
+	require.NoError(err)
+
+	h := core.InfoHashFixture()
+
+	p := core.PeerInfoFixture()
+	p.Zone = "zone1"
+	p.Rack = "rack1"
+
+	require.NoError(s.UpdatePeer(h, p))
+
+	peers, err := s.GetPeers(h, 1)
+	require.NoError(err)
+	require.Equal(peers, []*core.PeerInfo{p})
+}
+
+func TestRedisStoreGetPeersReturnsAllUnexpiredPeers(t *testing.T) {
 	require := require.New(t)
 
 	config := redisConfigFixture()
@@ -66,16 +107,13 @@ func TestRedisStoreGetPeersFromMultipleWindows(t *testing.T) {
 	clk := clock.NewMock()
 	clk.Set(time.Now())
 
-	s, err := NewRedisStore(config, clk)
+	s, err := NewRedisStore(config, clk, tally.NoopScope)
 	require.NoError(err)
 
-	// Reset time to the beginning of a window.
-	clk.Set(time.Unix(s.curPeerSetWindow(), 0))
-
 	h := core.InfoHashFixture()
 
-	// Each peer will be added on a different second to distribute them across
-	// multiple windows.
+	// Each peer will be added on a different second, such that the first peer
+	// is about to expire.
 	var peers []*core.PeerInfo
 	for i := 0; i < int(config.PeerSetWindowSize.Seconds())*config.MaxPeerSetWindows; i++ {
 		if i > 0 {
@@ -101,16 +139,13 @@ func TestRedisStoreGetPeersLimit(t *testing.T) {
 	clk := clock.NewMock()
 	clk.Set(time.Now())
 
-	s, err := NewRedisStore(config, clk)
+	s, err := NewRedisStore(config, clk, tally.NoopScope)
 	require.NoError(err)
 
-	// Reset time to the beginning of a window.
-	clk.Set(time.Unix(s.curPeerSetWindow(), 0))
-
 	h := core.InfoHashFixture()
 
-	// Each peer will be added on a different second to distribute them across
-	// multiple windows.
+	// Each peer will be added on a different second, such that peers have
+	// different expirations.
 	for i := 0; i < 30; i++ {
 		if i > 0 {
 			clk.Add(time.Second)
@@ -118,8 +153,7 @@ func TestRedisStoreGetPeersLimit(t *testing.T) {
 		require.NoError(s.UpdatePeer(h, core.PeerInfoFixture()))
 	}
 
-	// Request more peers than were added on a single window to ensure we obey the limit
-	// across multiple windows.
+	// Random samples must wrap around the end of the set and obey the limit.
 	for i := 0; i < 100; i++ {
 		result, err := s.GetPeers(h, 15)
 		require.NoError(err)
@@ -132,7 +166,7 @@ func TestRedisStoreGetPeersCollapsesCompleteBits(t *testing.T) {
 
 	config := redisConfigFixture()
 
-	s, err := NewRedisStore(config, clock.New())
+	s, err := NewRedisStore(config, clock.New(), tally.NoopScope)
 	require.NoError(err)
 
 	h := core.InfoHashFixture()
@@ -161,7 +195,7 @@ func TestRedisStorePeerExpiration(t *testing.T) {
 	config.PeerSetWindowSize = time.Second
 	config.MaxPeerSetWindows = 2
 
-	s, err := NewRedisStore(config, clock.New())
+	s, err := NewRedisStore(config, clock.New(), tally.NoopScope)
 	require.NoError(err)
 
 	h := core.InfoHashFixture()
@@ -179,3 +213,149 @@ func TestRedisStorePeerExpiration(t *testing.T) {
 	require.NoError(err)
 	require.Empty(result)
 }
+
+func TestRedisStoreSwarms(t *testing.T) {
+	require := require.New(t)
+
+	config := redisConfigFixture()
+	config.PeerSetWindowSize = 10 * time.Second
+
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+
+	s, err := NewRedisStore(config, clk, tally.NoopScope)
+	require.NoError(err)
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+
+	leecher := core.PeerInfoFixture()
+	require.NoError(s.UpdatePeer(h1, leecher))
+	require.NoError(s.UpdatePeer(h1, core.PeerInfoFixture()))
+	require.NoError(s.UpdatePeer(h2, core.PeerInfoFixture()))
+
+	// The leecher completes in the next window.
+	clk.Add(config.PeerSetWindowSize)
+	leecher.Complete = true
+	require.NoError(s.UpdatePeer(h1, leecher))
+
+	swarms, err := s.Swarms()
+	require.NoError(err)
+	require.ElementsMatch([]SwarmStats{
+		{InfoHash: h1, Seeders: 1, Leechers: 1},
+		{InfoHash: h2, Seeders: 0, Leechers: 1},
+	}, swarms)
+}
+
+func TestRedisStoreEvict(t *testing.T) {
+	require := require.New(t)
+
+	config := redisConfigFixture()
+	config.PeerSetWindowSize = 10 * time.Second
+
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+
+	s, err := NewRedisStore(config, clk, tally.NoopScope)
+	require.NoError(err)
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+
+	bad := core.PeerInfoFixture()
+	good := core.PeerInfoFixture()
+	require.NoError(s.UpdatePeer(h1, bad))
+	require.NoError(s.UpdatePeer(h1, good))
+	require.NoError(s.UpdatePeer(h2, core.PeerInfoFixture()))
+
+	// The bad peer is also in a later window.
+	clk.Add(config.PeerSetWindowSize)
+	bad.Complete = true
+	require.NoError(s.UpdatePeer(h1, bad))
+
+	require.NoError(s.EvictPeer(h1, bad.PeerID))
+
+	peers, err := s.GetPeers(h1, 10)
+	require.NoError(err)
+	require.Equal([]*core.PeerInfo{good}, peers)
+
+	require.NoError(s.EvictSwarm(h2))
+
+	peers, err = s.GetPeers(h2, 10)
+	require.NoError(err)
+	require.Empty(peers)
+}
+
+func TestRedisStoreChurnStats(t *testing.T) {
+	require := require.New(t)
+
+	config := redisConfigFixture()
+
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+
+	stats := tally.NewTestScope("", nil)
+
+	s, err := NewRedisStore(config, clk, stats)
+	require.NoError(err)
+
+	h := core.InfoHashFixture()
+	p1 := core.PeerInfoFixture()
+	p2 := core.PeerInfoFixture()
+	p3 := core.PeerInfoFixture()
+
+	require.NoError(s.UpdatePeer(h, p1))
+	require.NoError(s.UpdatePeer(h, p2))
+
+	// Announcing again, or completing, is not a join.
+	p1.Complete = true
+	require.NoError(s.UpdatePeer(h, p1))
+	require.NoError(s.UpdatePeer(h, p1))
+
+	require.NoError(s.EvictPeer(h, p2.PeerID))
+
+	clk.Add(config.PeerSetWindowSize * time.Duration(config.MaxPeerSetWindows))
+	require.NoError(s.UpdatePeer(h, p3))
+
+	peers, err := s.GetPeers(h, 10)
+	require.NoError(err)
+	require.Equal([]*core.PeerInfo{p3}, peers)
+
+	counters := stats.Snapshot().Counters()
+	require.Equal(int64(3), counters["peer_joins+"].Value())
+	require.Equal(int64(1), counters["peer_leaves+"].Value())
+	require.Equal(int64(1), counters["peer_expiries+"].Value())
+}
+
+func TestRedisStoreCompactRemovesExpiredPeers(t *testing.T) {
+	require := require.New(t)
+
+	config := redisConfigFixture()
+
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+
+	stats := tally.NewTestScope("", nil)
+
+	s, err := NewRedisStore(config, clk, stats)
+	require.NoError(err)
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+	require.NoError(s.UpdatePeer(h1, core.PeerInfoFixture()))
+	require.NoError(s.UpdatePeer(h2, core.PeerInfoFixture()))
+
+	clk.Add(config.PeerSetWindowSize * time.Duration(config.MaxPeerSetWindows))
+
+	live := core.PeerInfoFixture()
+	require.NoError(s.UpdatePeer(h2, live))
+
+	require.NoError(s.compact())
+
+	counters := stats.Snapshot().Counters()
+	require.Equal(int64(2), counters["peer_expiries+"].Value())
+
+	swarms, err := s.Swarms()
+	require.NoError(err)
+	require.Equal([]SwarmStats{{InfoHash: h2, Seeders: 0, Leechers: 1}}, swarms)
+}
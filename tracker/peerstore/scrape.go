@@ -0,0 +1,68 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"sort"
+
+	"github.com/uber/kraken/core"
+)
+
+// SwarmStats summarizes the peers announcing for a torrent.
+type SwarmStats struct {
+	InfoHash core.InfoHash `json:"info_hash"`
+	Seeders  int           `json:"seeders"`
+	Leechers int           `json:"leechers"`
+}
+
+// Peers returns the number of peers in the swarm.
+func (s SwarmStats) Peers() int {
+	return s.Seeders + s.Leechers
+}
+
+// Scraper is an optional interface of Stores which can enumerate all swarms
+// they hold. It is expensive, and intended for operator tooling rather than
+// the announce path.
+type Scraper interface {
+	// Swarms returns the stats of every swarm with announcing peers.
+	Swarms() ([]SwarmStats, error)
+}
+
+// swarmCounter accumulates SwarmStats from individual peers.
+type swarmCounter map[core.InfoHash]*SwarmStats
+
+func (c swarmCounter) add(h core.InfoHash, complete bool) {
+	s, ok := c[h]
+	if !ok {
+		s = &SwarmStats{InfoHash: h}
+		c[h] = s
+	}
+	if complete {
+		s.Seeders++
+	} else {
+		s.Leechers++
+	}
+}
+
+// stats returns the accumulated stats, sorted by info hash.
+func (c swarmCounter) stats() []SwarmStats {
+	stats := make([]SwarmStats, 0, len(c))
+	for _, s := range c {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].InfoHash.String() < stats[j].InfoHash.String()
+	})
+	return stats
+}
@@ -14,9 +14,43 @@
 package peerstore
 
 import (
+	"fmt"
+
 	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
 )
 
+var _factories = make(map[string]StoreFactory)
+
+// StoreFactory creates a Store from config.
+type StoreFactory interface {
+	Create(config Config, clk clock.Clock, stats tally.Scope) (Store, error)
+}
+
+// Register registers factory as the Store backend with the given name.
+// Backends outside of this package read their configuration from
+// Config.Plugins[name].
+func Register(name string, factory StoreFactory) {
+	_factories[name] = factory
+}
+
+// New creates the Store backend selected by config.
+func New(config Config, clk clock.Clock, stats tally.Scope) (Store, error) {
+	config.applyDefaults()
+
+	stats = stats.Tagged(map[string]string{
+		"module": "peerstore",
+	})
+
+	factory, ok := _factories[config.Backend]
+	if !ok {
+		return nil, fmt.Errorf("no peer store backend defined with name %s", config.Backend)
+	}
+	return factory.Create(config, clk, stats)
+}
+
 // Store provides storage for announcing peers.
 type Store interface {
 
@@ -26,3 +60,13 @@ type Store interface {
 	// UpdatePeer updates peer fields.
 	UpdatePeer(h core.InfoHash, peer *core.PeerInfo) error
 }
+
+// Evictor is an optional interface of Stores which can forcibly remove peers.
+// Evicted peers reappear if they announce again.
+type Evictor interface {
+	// EvictPeer removes the peer with peerID from h.
+	EvictPeer(h core.InfoHash, peerID core.PeerID) error
+
+	// EvictSwarm removes all peers of h.
+	EvictSwarm(h core.InfoHash) error
+}
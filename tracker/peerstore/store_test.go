@@ -0,0 +1,58 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"testing"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+type testStoreFactory struct {
+	config interface{}
+}
+
+func (f *testStoreFactory) Create(config Config, clk clock.Clock, stats tally.Scope) (Store, error) {
+	f.config = config.Plugins["test"]
+	return NewTestStore(), nil
+}
+
+func TestNewRegisteredPlugin(t *testing.T) {
+	require := require.New(t)
+
+	f := &testStoreFactory{}
+	Register("test", f)
+
+	_, err := New(Config{
+		Backend: "test",
+		Plugins: map[string]interface{}{"test": "some config"},
+	}, clock.New(), tally.NoopScope)
+	require.NoError(err)
+	require.Equal("some config", f.config)
+}
+
+func TestNewUnknownBackend(t *testing.T) {
+	_, err := New(Config{Backend: "unknown"}, clock.New(), tally.NoopScope)
+	require.Error(t, err)
+}
+
+func TestNewDefaultsToRedis(t *testing.T) {
+	require := require.New(t)
+
+	s, err := New(Config{Redis: redisConfigFixture()}, clock.New(), tally.NoopScope)
+	require.NoError(err)
+	require.IsType(&RedisStore{}, s)
+}
@@ -66,3 +66,42 @@ func (s *testStore) GetPeers(h core.InfoHash, n int) ([]*core.PeerInfo, error) {
 	}
 	return copies, nil
 }
+
+func (s *testStore) Swarms() ([]SwarmStats, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	counter := make(swarmCounter)
+	for h, peers := range s.torrents {
+		for _, p := range peers {
+			counter.add(h, p.Complete)
+		}
+	}
+	return counter.stats(), nil
+}
+
+func (s *testStore) EvictPeer(h core.InfoHash, peerID core.PeerID) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var peers []core.PeerInfo
+	for _, p := range s.torrents[h] {
+		if p.PeerID != peerID {
+			peers = append(peers, p)
+		}
+	}
+	if len(peers) == 0 {
+		delete(s.torrents, h)
+	} else {
+		s.torrents[h] = peers
+	}
+	return nil
+}
+
+func (s *testStore) EvictSwarm(h core.InfoHash) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.torrents, h)
+	return nil
+}
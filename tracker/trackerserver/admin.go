@@ -0,0 +1,210 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pressly/chi"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/tracker/peerstore"
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+)
+
+// AdminConfig defines the admin API, which is used for incident response.
+type AdminConfig struct {
+	// Token is the bearer token which admin requests must present in the
+	// Authorization header. The admin API is disabled if empty.
+	Token string `yaml:"token"`
+}
+
+// adminRouter returns the routes of the admin API.
+func (s *Server) adminRouter() http.Handler {
+	r := chi.NewRouter()
+
+	r.Use(s.authenticateAdmin)
+
+	r.Get("/swarms", handler.Wrap(s.adminListSwarmsHandler))
+	r.Get("/swarms/{infohash}/peers", handler.Wrap(s.adminGetPeersHandler))
+	r.Get("/namespace/{namespace}/blobs/{digest}/peers", handler.Wrap(s.adminGetBlobPeersHandler))
+	r.Delete("/swarms/{infohash}", handler.Wrap(s.adminEvictSwarmHandler))
+	r.Delete("/swarms/{infohash}/peers/{peerid}", handler.Wrap(s.adminEvictPeerHandler))
+
+	return r
+}
+
+// authenticateAdmin rejects requests which do not present the admin token.
+func (s *Server) authenticateAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Admin.Token)) != 1 {
+			s.stats.Counter("admin_unauthorized").Inc(1)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func parseInfoHashParam(r *http.Request) (core.InfoHash, error) {
+	infohash, err := httputil.ParseParam(r, "infohash")
+	if err != nil {
+		return core.InfoHash{}, err
+	}
+	h, err := core.NewInfoHashFromHex(infohash)
+	if err != nil {
+		return core.InfoHash{}, handler.Errorf("parse infohash: %s", err).Status(http.StatusBadRequest)
+	}
+	return h, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return handler.Errorf("json encode response: %s", err)
+	}
+	return nil
+}
+
+func (s *Server) adminListSwarmsHandler(w http.ResponseWriter, r *http.Request) error {
+	scraper, ok := s.peerStore.(peerstore.Scraper)
+	if !ok {
+		return handler.Errorf("peer store does not support listing swarms").Status(http.StatusNotImplemented)
+	}
+	swarms, err := scraper.Swarms()
+	if err != nil {
+		return fmt.Errorf("peer store: %s", err)
+	}
+	return writeJSON(w, swarms)
+}
+
+func (s *Server) getAllPeers(h core.InfoHash) ([]*core.PeerInfo, error) {
+	peers, err := s.peerStore.GetPeers(h, s.config.ScrapePeerLimit)
+	if err != nil {
+		return nil, fmt.Errorf("peer store: %s", err)
+	}
+	if peers == nil {
+		peers = []*core.PeerInfo{}
+	}
+	return peers, nil
+}
+
+func (s *Server) adminGetPeersHandler(w http.ResponseWriter, r *http.Request) error {
+	h, err := parseInfoHashParam(r)
+	if err != nil {
+		return err
+	}
+	peers, err := s.getAllPeers(h)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, peers)
+}
+
+// adminGetBlobPeersHandler returns the peers of a blob, resolving its info
+// hash through its metainfo.
+func (s *Server) adminGetBlobPeersHandler(w http.ResponseWriter, r *http.Request) error {
+	namespace, err := httputil.ParseParam(r, "namespace")
+	if err != nil {
+		return err
+	}
+	d, err := httputil.ParseDigest(r, "digest")
+	if err != nil {
+		return handler.Errorf("parse digest: %s", err).Status(http.StatusBadRequest)
+	}
+	mi, err := s.originCluster.GetMetaInfo(namespace, d)
+	if err != nil {
+		if serr, ok := err.(httputil.StatusError); ok {
+			return handler.Errorf("origin: %s", serr.ResponseDump).Status(serr.Status)
+		}
+		return fmt.Errorf("get metainfo: %s", err)
+	}
+	peers, err := s.getAllPeers(mi.InfoHash())
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, peers)
+}
+
+func (s *Server) evictor() (peerstore.Evictor, error) {
+	e, ok := s.peerStore.(peerstore.Evictor)
+	if !ok {
+		return nil, handler.Errorf("peer store does not support eviction").Status(http.StatusNotImplemented)
+	}
+	return e, nil
+}
+
+func (s *Server) adminEvictSwarmHandler(w http.ResponseWriter, r *http.Request) error {
+	h, err := parseInfoHashParam(r)
+	if err != nil {
+		return err
+	}
+	e, err := s.evictor()
+	if err != nil {
+		return err
+	}
+	if err := e.EvictSwarm(h); err != nil {
+		return fmt.Errorf("evict swarm: %s", err)
+	}
+	log.With("hash", h).Info("Evicted swarm")
+	s.stats.Counter("admin_evictions").Inc(1)
+	return nil
+}
+
+// adminEvictPeerHandler evicts a peer from a swarm. Since evicted peers
+// reappear when they announce again, the "deny" query argument optionally adds
+// the peer to the deny list for the given duration.
+func (s *Server) adminEvictPeerHandler(w http.ResponseWriter, r *http.Request) error {
+	h, err := parseInfoHashParam(r)
+	if err != nil {
+		return err
+	}
+	peerIDParam, err := httputil.ParseParam(r, "peerid")
+	if err != nil {
+		return err
+	}
+	peerID, err := core.NewPeerID(peerIDParam)
+	if err != nil {
+		return handler.Errorf("parse peer id: %s", err).Status(http.StatusBadRequest)
+	}
+	var deny time.Duration
+	if v := httputil.GetQueryArg(r, "deny", ""); v != "" {
+		deny, err = time.ParseDuration(v)
+		if err != nil || deny <= 0 {
+			return handler.Errorf("invalid deny %q", v).Status(http.StatusBadRequest)
+		}
+	}
+	e, err := s.evictor()
+	if err != nil {
+		return err
+	}
+	if deny > 0 {
+		// Deny before evicting, such that the peer cannot re-announce in between.
+		s.limiter.addDeny(denyKey{DenyPeerID, peerID.String()}, "evicted by operator", deny)
+	}
+	if err := e.EvictPeer(h, peerID); err != nil {
+		return fmt.Errorf("evict peer: %s", err)
+	}
+	log.With("hash", h, "peer_id", peerID).Info("Evicted peer")
+	s.stats.Counter("admin_evictions").Inc(1)
+	return nil
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/mocks/origin/blobclient"
+	"github.com/uber/kraken/tracker/originstore"
+	"github.com/uber/kraken/tracker/peerstore"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+const _testAdminToken = "secret"
+
+type adminFixture struct {
+	addr          string
+	store         peerstore.Store
+	originCluster *mockblobclient.MockClusterClient
+	server        *Server
+}
+
+func newAdminFixture(t *testing.T) (*adminFixture, func()) {
+	ctrl := gomock.NewController(t)
+	store := peerstore.NewTestStore()
+	originCluster := mockblobclient.NewMockClusterClient(ctrl)
+	s, err := New(
+		Config{Admin: AdminConfig{Token: _testAdminToken}},
+		tally.NoopScope, nil, store, originstore.NewNoopStore(), originCluster)
+	require.NoError(t, err)
+	addr, stop := testutil.StartServer(s.Handler())
+	return &adminFixture{addr, store, originCluster, s}, func() {
+		stop()
+		ctrl.Finish()
+	}
+}
+
+func (f *adminFixture) url(path string) string {
+	return fmt.Sprintf("http://%s/admin%s", f.addr, path)
+}
+
+func adminAuth() httputil.SendOption {
+	return httputil.SendHeaders(map[string]string{"Authorization": "Bearer " + _testAdminToken})
+}
+
+func (f *adminFixture) getPeers(t *testing.T, path string) []*core.PeerInfo {
+	resp, err := httputil.Get(f.url(path), adminAuth())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var peers []*core.PeerInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&peers))
+	return peers
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	f, cleanup := newAdminFixture(t)
+	defer cleanup()
+
+	_, err := httputil.Get(f.url("/swarms"))
+	require.True(t, httputil.IsStatus(err, http.StatusUnauthorized))
+
+	_, err = httputil.Get(f.url("/swarms"), httputil.SendHeaders(
+		map[string]string{"Authorization": "Bearer wrong"}))
+	require.True(t, httputil.IsStatus(err, http.StatusUnauthorized))
+}
+
+func TestAdminDisabledWithoutToken(t *testing.T) {
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	_, err := httputil.Get(fmt.Sprintf("http://%s/admin/swarms", addr), adminAuth())
+	require.True(t, httputil.IsNotFound(err))
+}
+
+func TestAdminListSwarmsAndPeers(t *testing.T) {
+	require := require.New(t)
+
+	f, cleanup := newAdminFixture(t)
+	defer cleanup()
+
+	blob := core.NewBlobFixture()
+	h := blob.MetaInfo.InfoHash()
+	p := core.PeerInfoFixture()
+	require.NoError(f.store.UpdatePeer(h, p))
+
+	resp, err := httputil.Get(f.url("/swarms"), adminAuth())
+	require.NoError(err)
+	defer resp.Body.Close()
+	var swarms []peerstore.SwarmStats
+	require.NoError(json.NewDecoder(resp.Body).Decode(&swarms))
+	require.Equal([]peerstore.SwarmStats{{InfoHash: h, Leechers: 1}}, swarms)
+
+	require.Equal([]*core.PeerInfo{p}, f.getPeers(t, fmt.Sprintf("/swarms/%s/peers", h)))
+
+	namespace := core.TagFixture()
+	f.originCluster.EXPECT().GetMetaInfo(namespace, blob.Digest).Return(blob.MetaInfo, nil)
+
+	require.Equal([]*core.PeerInfo{p}, f.getPeers(t, fmt.Sprintf(
+		"/namespace/%s/blobs/%s/peers", namespace, blob.Digest)))
+}
+
+func TestAdminEvictPeer(t *testing.T) {
+	require := require.New(t)
+
+	f, cleanup := newAdminFixture(t)
+	defer cleanup()
+
+	h := core.InfoHashFixture()
+	bad := core.PeerInfoFixture()
+	good := core.PeerInfoFixture()
+	require.NoError(f.store.UpdatePeer(h, bad))
+	require.NoError(f.store.UpdatePeer(h, good))
+
+	_, err := httputil.Delete(
+		f.url(fmt.Sprintf("/swarms/%s/peers/%s?deny=1h", h, bad.PeerID)), adminAuth())
+	require.NoError(err)
+
+	require.Equal([]*core.PeerInfo{good}, f.getPeers(t, fmt.Sprintf("/swarms/%s/peers", h)))
+
+	// The evicted peer is denied from announcing again.
+	require.Error(f.server.limiter.admit(bad.PeerID, bad.IP))
+}
+
+func TestAdminEvictSwarm(t *testing.T) {
+	require := require.New(t)
+
+	f, cleanup := newAdminFixture(t)
+	defer cleanup()
+
+	h := core.InfoHashFixture()
+	require.NoError(f.store.UpdatePeer(h, core.PeerInfoFixture()))
+
+	_, err := httputil.Delete(f.url(fmt.Sprintf("/swarms/%s", h)), adminAuth())
+	require.NoError(err)
+
+	swarms, err := f.store.(peerstore.Scraper).Swarms()
+	require.NoError(err)
+	require.Empty(swarms)
+}
@@ -16,7 +16,9 @@ package trackerserver
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"time"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/tracker/announceclient"
@@ -31,11 +33,20 @@ func (s *Server) announceHandlerV1(w http.ResponseWriter, r *http.Request) error
 	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
 		return handler.Errorf("json decode request: %s", err)
 	}
+	if err := s.authenticateNamespace(r, req.Namespace); err != nil {
+		return err
+	}
+	if err := s.admitAnnounce(r, req.Peer); err != nil {
+		return err
+	}
 	d, err := req.GetDigest()
 	if err != nil {
 		return handler.Errorf("get request digest: %s", err)
 	}
-	resp, err := s.announce(d, req.InfoHash, req.Peer)
+	if err := s.checkShard(d); err != nil {
+		return err
+	}
+	resp, err := s.announce(req.Namespace, d, req.InfoHash, req.Peer)
 	if err != nil {
 		return err
 	}
@@ -58,11 +69,20 @@ func (s *Server) announceHandlerV2(w http.ResponseWriter, r *http.Request) error
 	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
 		return handler.Errorf("json decode request: %s", err)
 	}
+	if err := s.authenticateNamespace(r, req.Namespace); err != nil {
+		return err
+	}
+	if err := s.admitAnnounce(r, req.Peer); err != nil {
+		return err
+	}
 	d, err := req.GetDigest()
 	if err != nil {
 		return handler.Errorf("get request digest: %s", err)
 	}
-	resp, err := s.announce(d, h, req.Peer)
+	if err := s.checkShard(d); err != nil {
+		return err
+	}
+	resp, err := s.announce(req.Namespace, d, h, req.Peer)
 	if err != nil {
 		return err
 	}
@@ -72,26 +92,120 @@ func (s *Server) announceHandlerV2(w http.ResponseWriter, r *http.Request) error
 	return nil
 }
 
+// announceBatchHandler announces many torrents of a single peer at once. Rate
+// limits count each batch as a single announce.
+func (s *Server) announceBatchHandler(w http.ResponseWriter, r *http.Request) error {
+	req := new(announceclient.BatchRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return handler.Errorf("json decode request: %s", err).Status(http.StatusBadRequest)
+	}
+	if len(req.Items) > s.config.MaxBatchSize {
+		return handler.Errorf(
+			"batch of %d exceeds max size %d", len(req.Items), s.config.MaxBatchSize).
+			Status(http.StatusBadRequest)
+	}
+	client, err := s.authenticate(r)
+	if err != nil {
+		return err
+	}
+	if err := s.admitAnnounce(r, req.Peer); err != nil {
+		return err
+	}
+	resp := &announceclient.BatchResponse{
+		Results: make([]announceclient.BatchResult, len(req.Items)),
+	}
+	for i, item := range req.Items {
+		peer := *req.Peer
+		peer.Complete = item.Complete
+		result := announceclient.BatchResult{InfoHash: item.InfoHash}
+		if err := s.authorize(client, item.Namespace); err != nil {
+			result.Error = err.Error()
+			resp.Results[i] = result
+			continue
+		}
+		if err := s.checkShard(item.Digest); err != nil {
+			result.Error = err.Error()
+			result.Misrouted = true
+			resp.Results[i] = result
+			continue
+		}
+		ar, err := s.announce(item.Namespace, item.Digest, item.InfoHash, &peer)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Peers = ar.Peers
+			result.Interval = ar.Interval
+		}
+		resp.Results[i] = result
+	}
+	s.stats.Counter("batch_announces").Inc(1)
+	s.stats.Counter("batch_announce_items").Inc(int64(len(req.Items)))
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return handler.Errorf("json encode response: %s", err)
+	}
+	return nil
+}
+
 func (s *Server) announce(
-	d core.Digest, h core.InfoHash, peer *core.PeerInfo) (*announceclient.Response, error) {
+	namespace string, d core.Digest, h core.InfoHash, peer *core.PeerInfo) (
+	*announceclient.Response, error) {
 
 	if err := s.peerStore.UpdatePeer(h, peer); err != nil {
 		log.With(
 			"hash", h,
 			"peer_id", peer.PeerID).Errorf("Error updating peer: %s", err)
 	}
-	peers, err := s.getPeerHandout(d, h, peer)
+	peers, err := s.getPeerHandout(namespace, d, h, peer)
 	if err != nil {
 		return nil, err
 	}
 	return &announceclient.Response{
 		Peers:    peers,
-		Interval: s.config.AnnounceInterval,
+		Interval: s.announceInterval(peer, peers),
 	}, nil
 }
 
+// announceInterval suggests when peer should announce next, given the peers
+// handed out to it.
+func (s *Server) announceInterval(
+	peer *core.PeerInfo, peers []*core.PeerInfo) time.Duration {
+
+	config := s.config.AdaptiveAnnounceInterval
+	if !config.Enabled {
+		return s.config.AnnounceInterval
+	}
+	if peer.Complete {
+		// Seeders have nothing to discover, and only announce to remain in the
+		// peer store.
+		return config.MaxInterval
+	}
+	// Origins are handed out to every swarm, so they say nothing of its health.
+	var n, complete int
+	for _, p := range peers {
+		if p.Origin {
+			continue
+		}
+		n++
+		if p.Complete {
+			complete++
+		}
+	}
+	size := math.Min(float64(n)/float64(config.LargeSwarmSize), 1)
+	var completion float64
+	if n > 0 {
+		completion = float64(complete) / float64(n)
+	}
+	// Swarms which are either large or mostly complete announce less often.
+	weight := math.Max(size, completion)
+	span := config.MaxInterval - config.MinInterval
+	interval := config.MinInterval + time.Duration(weight*float64(span))
+	s.stats.Timer("suggested_announce_interval").Record(interval)
+	return interval
+}
+
 func (s *Server) getPeerHandout(
-	d core.Digest, h core.InfoHash, peer *core.PeerInfo) ([]*core.PeerInfo, error) {
+	namespace string, d core.Digest, h core.InfoHash, peer *core.PeerInfo) (
+	[]*core.PeerInfo, error) {
 
 	if peer.Complete {
 		// If the peer is announcing as complete, don't return a peer handout since
@@ -99,11 +213,11 @@ func (s *Server) getPeerHandout(
 		return nil, nil
 	}
 	var errs []error
-	peers, err := s.peerStore.GetPeers(h, s.config.PeerHandoutLimit)
+	peers, err := s.getPeers(namespace, h, peer)
 	if err != nil {
 		errs = append(errs, fmt.Errorf("peer store: %s", err))
 	}
-	origins, err := s.originStore.GetOrigins(d)
+	origins, err := s.getOrigins(namespace, d, peers)
 	if err != nil {
 		errs = append(errs, fmt.Errorf("origin store: %s", err))
 	}
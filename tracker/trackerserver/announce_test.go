@@ -27,6 +27,7 @@ import (
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 )
 
 func newAnnounceClient(pctx core.PeerContext, addr string) announceclient.Client {
@@ -60,7 +61,7 @@ func TestAnnounceSinglePeerResponse(t *testing.T) {
 				blob.MetaInfo.InfoHash(), core.PeerInfoFromContext(pctx, false)).Return(nil)
 
 			result, interval, err := client.Announce(
-				blob.Digest, blob.MetaInfo.InfoHash(), false, version)
+				core.TagFixture(), blob.Digest, blob.MetaInfo.InfoHash(), false, version)
 			require.NoError(err)
 			require.Equal(peers, result)
 			require.Equal(config.AnnounceInterval, interval)
@@ -92,7 +93,7 @@ func TestAnnounceUnavailablePeerStoreCanStillProvideOrigins(t *testing.T) {
 	mocks.originStore.EXPECT().GetOrigins(blob.Digest).Return(origins, nil)
 
 	result, _, err := client.Announce(
-		blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V2)
+		core.TagFixture(), blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V2)
 	require.NoError(err)
 	require.Equal(origins, result)
 }
@@ -120,7 +121,7 @@ func TestAnnouceUnavailableOriginClusterCanStillProvidePeers(t *testing.T) {
 	mocks.originStore.EXPECT().GetOrigins(blob.Digest).Return(nil, errors.New("some error"))
 
 	result, _, err := client.Announce(
-		blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V2)
+		core.TagFixture(), blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V2)
 	require.NoError(err)
 	require.Equal(peers, result)
 }
@@ -168,3 +169,164 @@ func TestAnnounceRequestGetDigestBackwardsCompatibility(t *testing.T) {
 		})
 	}
 }
+
+func TestAnnounceAdaptiveInterval(t *testing.T) {
+	config := Config{
+		AdaptiveAnnounceInterval: AdaptiveAnnounceIntervalConfig{
+			Enabled:        true,
+			MinInterval:    time.Second,
+			MaxInterval:    11 * time.Second,
+			LargeSwarmSize: 10,
+		},
+	}
+
+	peers := func(n, complete int) []*core.PeerInfo {
+		var ps []*core.PeerInfo
+		for i := 0; i < n; i++ {
+			p := core.PeerInfoFixture()
+			p.Complete = i < complete
+			ps = append(ps, p)
+		}
+		return ps
+	}
+
+	tests := []struct {
+		desc     string
+		complete bool
+		peers    []*core.PeerInfo
+		expected time.Duration
+	}{
+		{"no peers", false, nil, time.Second},
+		{"origins ignored", false, []*core.PeerInfo{core.OriginPeerInfoFixture()}, time.Second},
+		{"small swarm", false, peers(2, 0), 3 * time.Second},
+		{"large swarm", false, peers(20, 0), 11 * time.Second},
+		{"mostly complete swarm", false, peers(4, 3), 8500 * time.Millisecond},
+		{"seeder", true, nil, 11 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			s, err := New(config, tally.NoopScope, nil, nil, nil, nil)
+			require.NoError(t, err)
+			peer := core.PeerInfoFixture()
+			peer.Complete = test.complete
+			require.Equal(t, test.expected, s.announceInterval(peer, test.peers))
+		})
+	}
+}
+
+func TestAnnounceAdaptiveIntervalDisabled(t *testing.T) {
+	s, err := New(Config{AnnounceInterval: 5 * time.Second}, tally.NoopScope, nil, nil, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Second, s.announceInterval(core.PeerInfoFixture(), nil))
+}
+
+func TestAnnounceNamespaceHandoutLimit(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{
+		PeerHandoutLimit: 5,
+		HandoutLimits: []HandoutLimitConfig{{
+			Namespace: "^hot/.*",
+			Limit:     2,
+			SwarmSizes: []SwarmSizeLimitConfig{
+				{MinPeers: 10, Limit: 8},
+			},
+		}},
+	}
+
+	mocks, cleanup := newServerMocks(t, config)
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	blob := core.NewBlobFixture()
+	pctx := core.PeerContextFixture()
+
+	client := newAnnounceClient(pctx, addr)
+
+	var peers []*core.PeerInfo
+	for i := 0; i < 4; i++ {
+		peers = append(peers, core.PeerInfoFixture())
+	}
+
+	mocks.peerStore.EXPECT().UpdatePeer(
+		blob.MetaInfo.InfoHash(), core.PeerInfoFromContext(pctx, false)).Return(nil)
+	mocks.peerStore.EXPECT().GetPeers(blob.MetaInfo.InfoHash(), 10).Return(peers, nil)
+	mocks.originStore.EXPECT().GetOrigins(blob.Digest).Return(nil, nil)
+
+	result, _, err := client.Announce(
+		"hot/repo", blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V2)
+	require.NoError(err)
+	require.Len(result, 2)
+}
+
+func TestBatchAnnounce(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{AnnounceInterval: 5 * time.Second}
+
+	mocks, cleanup := newServerMocks(t, config)
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	pctx := core.PeerContextFixture()
+
+	client := newAnnounceClient(pctx, addr)
+
+	var items []announceclient.BatchItem
+	var expected [][]*core.PeerInfo
+	for i, complete := range []bool{false, true} {
+		blob := core.NewBlobFixture()
+		items = append(items, announceclient.BatchItem{
+			Namespace: core.TagFixture(),
+			Digest:    blob.Digest,
+			InfoHash:  blob.MetaInfo.InfoHash(),
+			Complete:  complete,
+		})
+		peers := []*core.PeerInfo{core.PeerInfoFixture()}
+		expected = append(expected, peers)
+
+		mocks.peerStore.EXPECT().UpdatePeer(
+			items[i].InfoHash, core.PeerInfoFromContext(pctx, complete)).Return(nil)
+		mocks.peerStore.EXPECT().GetPeers(items[i].InfoHash, gomock.Any()).Return(peers, nil)
+		mocks.originStore.EXPECT().GetOrigins(items[i].Digest).Return(nil, nil)
+	}
+
+	results, err := client.BatchAnnounce(items)
+	require.NoError(err)
+	require.Len(results, len(items))
+	for i, r := range results {
+		require.NoError(r.Err())
+		require.Equal(items[i].InfoHash, r.InfoHash)
+		require.Equal(expected[i], r.Peers)
+		require.Equal(config.AnnounceInterval, r.Interval)
+	}
+}
+
+func TestBatchAnnounceRejectsOversizedBatch(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{MaxBatchSize: 1})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	client := newAnnounceClient(core.PeerContextFixture(), addr)
+
+	var items []announceclient.BatchItem
+	for i := 0; i < 2; i++ {
+		blob := core.NewBlobFixture()
+		items = append(items, announceclient.BatchItem{
+			Namespace: core.TagFixture(),
+			Digest:    blob.Digest,
+			InfoHash:  blob.MetaInfo.InfoHash(),
+		})
+	}
+
+	_, err := client.BatchAnnounce(items)
+	require.Error(err)
+}
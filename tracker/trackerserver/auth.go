@@ -0,0 +1,158 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/uber/kraken/utils/handler"
+)
+
+// Headers set by nginx describing the client certificate of a request.
+const (
+	_clientVerifyHeader  = "X-SSL-Client-Verify"
+	_clientSubjectHeader = "X-SSL-Client-S-DN"
+)
+
+// AuthConfig defines authentication and per-namespace authorization of
+// announce and metainfo requests.
+type AuthConfig struct {
+	// Clients lists the identities allowed to announce and download metainfo.
+	// Authentication is disabled if empty.
+	Clients []ClientAuthConfig `yaml:"clients"`
+}
+
+// ClientAuthConfig defines a client identity. A request matches the client if
+// it presents every credential the client defines.
+type ClientAuthConfig struct {
+	// Name identifies the client in logs and metrics.
+	Name string `yaml:"name"`
+
+	// Token must be presented as a bearer token in the Authorization header.
+	Token string `yaml:"token"`
+
+	// Subject must equal the distinguished name of the client certificate,
+	// as verified and forwarded by nginx.
+	Subject string `yaml:"subject"`
+
+	// Namespaces are regular expressions of the namespaces the client may
+	// access. Announces from older agents have an empty namespace.
+	Namespaces []string `yaml:"namespaces"`
+}
+
+type authClient struct {
+	name       string
+	token      string
+	subject    string
+	namespaces []*regexp.Regexp
+}
+
+func (c *authClient) allows(namespace string) bool {
+	for _, re := range c.namespaces {
+		if re.MatchString(namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+type authenticator struct {
+	clients []*authClient
+}
+
+// newAuthenticator returns nil if authentication is disabled.
+func newAuthenticator(config AuthConfig) (*authenticator, error) {
+	if len(config.Clients) == 0 {
+		return nil, nil
+	}
+	a := &authenticator{}
+	for _, cc := range config.Clients {
+		if cc.Name == "" {
+			return nil, errors.New("client name required")
+		}
+		if cc.Token == "" && cc.Subject == "" {
+			return nil, fmt.Errorf("client %s: token or subject required", cc.Name)
+		}
+		c := &authClient{name: cc.Name, token: cc.Token, subject: cc.Subject}
+		for _, ns := range cc.Namespaces {
+			re, err := regexp.Compile(ns)
+			if err != nil {
+				return nil, fmt.Errorf("client %s: invalid namespace regexp %q: %s", cc.Name, ns, err)
+			}
+			c.namespaces = append(c.namespaces, re)
+		}
+		a.clients = append(a.clients, c)
+	}
+	return a, nil
+}
+
+// match returns the first client whose credentials r presents, or nil.
+func (a *authenticator) match(r *http.Request) *authClient {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	var subject string
+	if r.Header.Get(_clientVerifyHeader) == "SUCCESS" {
+		subject = r.Header.Get(_clientSubjectHeader)
+	}
+	for _, c := range a.clients {
+		if c.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) != 1 {
+			continue
+		}
+		if c.subject != "" && c.subject != subject {
+			continue
+		}
+		return c
+	}
+	return nil
+}
+
+// authenticate returns the client making r, or nil if authentication is
+// disabled.
+func (s *Server) authenticate(r *http.Request) (*authClient, error) {
+	if s.auth == nil {
+		return nil, nil
+	}
+	c := s.auth.match(r)
+	if c == nil {
+		s.stats.Counter("unauthenticated_requests").Inc(1)
+		return nil, handler.Errorf("unauthenticated").Status(http.StatusUnauthorized)
+	}
+	return c, nil
+}
+
+// authorize returns an error if c may not access namespace. A nil c means
+// authentication is disabled.
+func (s *Server) authorize(c *authClient, namespace string) error {
+	if c == nil || c.allows(namespace) {
+		return nil
+	}
+	s.stats.Tagged(map[string]string{
+		"client": c.name,
+	}).Counter("unauthorized_requests").Inc(1)
+	return handler.Errorf(
+		"client %s may not access namespace %q", c.name, namespace).Status(http.StatusForbidden)
+}
+
+// authenticateNamespace authenticates r and authorizes it to access namespace.
+func (s *Server) authenticateNamespace(r *http.Request, namespace string) error {
+	c, err := s.authenticate(r)
+	if err != nil {
+		return err
+	}
+	return s.authorize(c, namespace)
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/hashring"
+	"github.com/uber/kraken/lib/hostlist"
+	"github.com/uber/kraken/tracker/announceclient"
+	"github.com/uber/kraken/tracker/metainfoclient"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+const _testAuthToken = "secret"
+
+func authConfigFixture() AuthConfig {
+	return AuthConfig{
+		Clients: []ClientAuthConfig{{
+			Name:       "agent",
+			Token:      _testAuthToken,
+			Namespaces: []string{"^allowed/.*"},
+		}},
+	}
+}
+
+func TestNewAuthenticatorErrors(t *testing.T) {
+	tests := []struct {
+		desc   string
+		client ClientAuthConfig
+	}{
+		{"missing name", ClientAuthConfig{Token: "x"}},
+		{"missing credentials", ClientAuthConfig{Name: "x"}},
+		{"invalid namespace", ClientAuthConfig{Name: "x", Token: "x", Namespaces: []string{"("}}},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := newAuthenticator(AuthConfig{Clients: []ClientAuthConfig{test.client}})
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestNewAuthenticatorDisabledWithoutClients(t *testing.T) {
+	a, err := newAuthenticator(AuthConfig{})
+	require.NoError(t, err)
+	require.Nil(t, a)
+}
+
+func TestAuthenticatorMatch(t *testing.T) {
+	a, err := newAuthenticator(AuthConfig{
+		Clients: []ClientAuthConfig{
+			{Name: "token", Token: "t1"},
+			{Name: "cert", Subject: "CN=agent"},
+			{Name: "both", Token: "t2", Subject: "CN=build"},
+		},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc     string
+		headers  map[string]string
+		expected string
+	}{
+		{"token", map[string]string{"Authorization": "Bearer t1"}, "token"},
+		{"wrong token", map[string]string{"Authorization": "Bearer t3"}, ""},
+		{"verified cert", map[string]string{
+			_clientVerifyHeader:  "SUCCESS",
+			_clientSubjectHeader: "CN=agent",
+		}, "cert"},
+		{"unverified cert", map[string]string{
+			_clientVerifyHeader:  "NONE",
+			_clientSubjectHeader: "CN=agent",
+		}, ""},
+		{"token and cert", map[string]string{
+			"Authorization":      "Bearer t2",
+			_clientVerifyHeader:  "SUCCESS",
+			_clientSubjectHeader: "CN=build",
+		}, "both"},
+		{"token without required cert", map[string]string{"Authorization": "Bearer t2"}, ""},
+		{"no credentials", nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			r, err := http.NewRequest("GET", "/", nil)
+			require.NoError(t, err)
+			for k, v := range test.headers {
+				r.Header.Set(k, v)
+			}
+			c := a.match(r)
+			if test.expected == "" {
+				require.Nil(t, c)
+			} else {
+				require.NotNil(t, c)
+				require.Equal(t, test.expected, c.name)
+			}
+		})
+	}
+}
+
+func TestGetMetaInfoHandlerAuth(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{Auth: authConfigFixture()})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	ring := hashring.NoopPassiveRing(hostlist.Fixture(addr))
+	mi := core.MetaInfoFixture()
+
+	client := metainfoclient.New(metainfoclient.Config{}, tally.NoopScope, ring, nil)
+	_, err := client.Download(context.Background(), "allowed/repo", mi.Digest())
+	require.True(httputil.IsStatus(err, http.StatusUnauthorized))
+
+	client = metainfoclient.New(
+		metainfoclient.Config{}, tally.NoopScope, ring, nil, metainfoclient.WithToken(_testAuthToken))
+
+	_, err = client.Download(context.Background(), "denied/repo", mi.Digest())
+	require.True(httputil.IsStatus(err, http.StatusForbidden))
+
+	mocks.originCluster.EXPECT().GetMetaInfo("allowed/repo", mi.Digest()).Return(mi, nil)
+
+	result, err := client.Download(context.Background(), "allowed/repo", mi.Digest())
+	require.NoError(err)
+	require.Equal(mi, result)
+}
+
+func TestAnnounceAuth(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{Auth: authConfigFixture()})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	ring := hashring.NoopPassiveRing(hostlist.Fixture(addr))
+	pctx := core.PeerContextFixture()
+	blob := core.NewBlobFixture()
+
+	client := announceclient.New(pctx, ring, nil)
+	_, _, err := client.Announce(
+		"allowed/repo", blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V2)
+	require.True(httputil.IsStatus(err, http.StatusUnauthorized))
+
+	client = announceclient.New(pctx, ring, nil, announceclient.WithToken(_testAuthToken))
+
+	_, _, err = client.Announce(
+		"denied/repo", blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V2)
+	require.True(httputil.IsStatus(err, http.StatusForbidden))
+
+	peers := []*core.PeerInfo{core.PeerInfoFixture()}
+
+	mocks.peerStore.EXPECT().UpdatePeer(
+		blob.MetaInfo.InfoHash(), core.PeerInfoFromContext(pctx, false)).Return(nil)
+	mocks.peerStore.EXPECT().GetPeers(blob.MetaInfo.InfoHash(), gomock.Any()).Return(peers, nil)
+	mocks.originStore.EXPECT().GetOrigins(blob.Digest).Return(nil, nil)
+
+	result, _, err := client.Announce(
+		"allowed/repo", blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V2)
+	require.NoError(err)
+	require.Equal(peers, result)
+}
+
+func TestBatchAnnounceAuthorizesEachItem(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{Auth: authConfigFixture()})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	pctx := core.PeerContextFixture()
+	client := announceclient.New(
+		pctx, hashring.NoopPassiveRing(hostlist.Fixture(addr)), nil,
+		announceclient.WithToken(_testAuthToken))
+
+	allowed := core.NewBlobFixture()
+	denied := core.NewBlobFixture()
+	items := []announceclient.BatchItem{{
+		Namespace: "allowed/repo",
+		Digest:    allowed.Digest,
+		InfoHash:  allowed.MetaInfo.InfoHash(),
+	}, {
+		Namespace: "denied/repo",
+		Digest:    denied.Digest,
+		InfoHash:  denied.MetaInfo.InfoHash(),
+	}}
+
+	mocks.peerStore.EXPECT().UpdatePeer(
+		allowed.MetaInfo.InfoHash(), core.PeerInfoFromContext(pctx, false)).Return(nil)
+	mocks.peerStore.EXPECT().GetPeers(allowed.MetaInfo.InfoHash(), gomock.Any()).Return(nil, nil)
+	mocks.originStore.EXPECT().GetOrigins(allowed.Digest).Return(nil, nil)
+
+	results, err := client.BatchAnnounce(items)
+	require.NoError(err)
+	require.Len(results, 2)
+	require.NoError(results[0].Err())
+	require.Error(results[1].Err())
+}
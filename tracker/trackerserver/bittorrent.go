@@ -0,0 +1,195 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/jackpal/bencode-go"
+)
+
+// BitTorrentConfig defines the standard BitTorrent HTTP announce endpoint, which
+// allows off-the-shelf BitTorrent clients to discover each other through the
+// tracker. Such clients cannot exchange pieces with Kraken agents, since Kraken
+// uses its own peer protocol.
+type BitTorrentConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// bitTorrentAnnounce defines the parameters of a standard announce request.
+type bitTorrentAnnounce struct {
+	infoHash core.InfoHash
+	peer     *core.PeerInfo
+	compact  bool
+	numWant  int
+}
+
+func parseBitTorrentAnnounce(r *http.Request) (*bitTorrentAnnounce, error) {
+	q := r.URL.Query()
+
+	var h core.InfoHash
+	if n := len(q.Get("info_hash")); n != len(h) {
+		return nil, fmt.Errorf("invalid info_hash: expected %d bytes, got %d", len(h), n)
+	}
+	copy(h[:], q.Get("info_hash"))
+
+	var peerID core.PeerID
+	if n := len(q.Get("peer_id")); n != len(peerID) {
+		return nil, fmt.Errorf("invalid peer_id: expected %d bytes, got %d", len(peerID), n)
+	}
+	copy(peerID[:], q.Get("peer_id"))
+
+	port, err := strconv.Atoi(q.Get("port"))
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port %q", q.Get("port"))
+	}
+	ip := q.Get("ip")
+	if ip == "" {
+		ip, _, err = net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return nil, fmt.Errorf("parse remote addr: %s", err)
+		}
+	}
+	if net.ParseIP(ip) == nil {
+		return nil, fmt.Errorf("invalid ip %q", ip)
+	}
+	complete := q.Get("left") == "0" || q.Get("event") == "completed"
+
+	var numWant int
+	if s := q.Get("numwant"); s != "" {
+		numWant, err = strconv.Atoi(s)
+		if err != nil || numWant < 0 {
+			return nil, fmt.Errorf("invalid numwant %q", s)
+		}
+	}
+	return &bitTorrentAnnounce{
+		infoHash: h,
+		peer:     core.NewPeerInfo(peerID, ip, port, false, complete),
+		compact:  q.Get("compact") == "1",
+		numWant:  numWant,
+	}, nil
+}
+
+// compactPeers encodes peers in the compact format of BEP 23 and BEP 7,
+// returning IPv4 and IPv6 peers separately.
+func compactPeers(peers []*core.PeerInfo) (v4, v6 []byte) {
+	for _, p := range peers {
+		ip := net.ParseIP(p.IP)
+		if ip == nil {
+			continue
+		}
+		var port [2]byte
+		binary.BigEndian.PutUint16(port[:], uint16(p.Port))
+		if ip4 := ip.To4(); ip4 != nil {
+			v4 = append(v4, ip4...)
+			v4 = append(v4, port[:]...)
+		} else {
+			v6 = append(v6, ip.To16()...)
+			v6 = append(v6, port[:]...)
+		}
+	}
+	return v4, v6
+}
+
+func writeBencode(w http.ResponseWriter, resp map[string]interface{}) error {
+	var b bytes.Buffer
+	if err := bencode.Marshal(&b, resp); err != nil {
+		return fmt.Errorf("bencode: %s", err)
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(b.Bytes())
+	return nil
+}
+
+// bitTorrentAnnounceHandler implements the standard BitTorrent HTTP announce
+// protocol. Per the protocol, errors are returned as bencoded failure reasons
+// with a 200 status.
+func (s *Server) bitTorrentAnnounceHandler(w http.ResponseWriter, r *http.Request) error {
+	req, err := parseBitTorrentAnnounce(r)
+	if err != nil {
+		return writeBencode(w, map[string]interface{}{"failure reason": err.Error()})
+	}
+	// BitTorrent announces carry no namespace.
+	if err := s.authenticateNamespace(r, ""); err != nil {
+		return writeBencode(w, map[string]interface{}{"failure reason": err.Error()})
+	}
+	if err := s.admitAnnounce(r, req.peer); err != nil {
+		return writeBencode(w, map[string]interface{}{"failure reason": err.Error()})
+	}
+	h := req.infoHash
+	if err := s.peerStore.UpdatePeer(h, req.peer); err != nil {
+		log.With(
+			"hash", h,
+			"peer_id", req.peer.PeerID).Errorf("Error updating peer: %s", err)
+	}
+	var peers []*core.PeerInfo
+	if !req.peer.Complete {
+		// Standard clients only know info hashes, so there are no namespaces or
+		// origins to hand out.
+		peers, err = s.getPeers("", h, req.peer)
+		if err != nil {
+			return writeBencode(w, map[string]interface{}{
+				"failure reason": fmt.Sprintf("peer store: %s", err),
+			})
+		}
+		peers = s.policy.SortPeers(req.peer, peers)
+	}
+	// Do not hand out the announcing peer to itself.
+	filtered := peers[:0]
+	for _, p := range peers {
+		if p.PeerID != req.peer.PeerID {
+			filtered = append(filtered, p)
+		}
+	}
+	peers = filtered
+	if req.numWant > 0 && len(peers) > req.numWant {
+		peers = peers[:req.numWant]
+	}
+	s.stats.Counter("bittorrent_announces").Inc(1)
+
+	// Intervals are in whole seconds.
+	interval := int64(s.announceInterval(req.peer, peers).Seconds())
+	if interval < 1 {
+		interval = 1
+	}
+	resp := map[string]interface{}{
+		"interval": interval,
+	}
+	if req.compact {
+		v4, v6 := compactPeers(peers)
+		resp["peers"] = string(v4)
+		if len(v6) > 0 {
+			resp["peers6"] = string(v6)
+		}
+	} else {
+		list := make([]interface{}, 0, len(peers))
+		for _, p := range peers {
+			list = append(list, map[string]interface{}{
+				"peer id": string(p.PeerID[:]),
+				"ip":      p.IP,
+				"port":    int64(p.Port),
+			})
+		}
+		resp["peers"] = list
+	}
+	return writeBencode(w, resp)
+}
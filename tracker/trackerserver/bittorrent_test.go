@@ -0,0 +1,145 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/golang/mock/gomock"
+	"github.com/jackpal/bencode-go"
+	"github.com/stretchr/testify/require"
+)
+
+func bitTorrentAnnounce(t *testing.T, addr string, params url.Values) map[string]interface{} {
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/bittorrent/announce?%s", addr, params.Encode()))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	result, err := bencode.Decode(resp.Body)
+	require.NoError(t, err)
+	return result.(map[string]interface{})
+}
+
+func bitTorrentParams(h core.InfoHash, peerID core.PeerID) url.Values {
+	return url.Values{
+		"info_hash": {string(h[:])},
+		"peer_id":   {string(peerID[:])},
+		"ip":        {"10.0.0.1"},
+		"port":      {"6881"},
+		"left":      {"100"},
+	}
+}
+
+func TestBitTorrentAnnounce(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{BitTorrent: BitTorrentConfig{Enabled: true}})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	h := core.InfoHashFixture()
+	peerID := core.PeerIDFixture()
+	self := core.NewPeerInfo(peerID, "10.0.0.1", 6881, false, false)
+
+	p4 := core.NewPeerInfo(core.PeerIDFixture(), "10.0.0.2", 6882, false, true)
+	p6 := core.NewPeerInfo(core.PeerIDFixture(), "::1", 6883, false, false)
+
+	mocks.peerStore.EXPECT().UpdatePeer(h, self).Return(nil).Times(2)
+	mocks.peerStore.EXPECT().GetPeers(h, gomock.Any()).Return(
+		[]*core.PeerInfo{self, p4, p6}, nil).Times(2)
+
+	params := bitTorrentParams(h, peerID)
+	params.Set("compact", "1")
+	result := bitTorrentAnnounce(t, addr, params)
+	require.Equal(int64(3), result["interval"])
+	require.Equal("\x0a\x00\x00\x02\x1a\xe2", result["peers"])
+	require.Equal(
+		"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1a\xe3", result["peers6"])
+
+	params.Set("compact", "0")
+	result = bitTorrentAnnounce(t, addr, params)
+	require.ElementsMatch([]interface{}{
+		map[string]interface{}{"peer id": string(p4.PeerID[:]), "ip": "10.0.0.2", "port": int64(6882)},
+		map[string]interface{}{"peer id": string(p6.PeerID[:]), "ip": "::1", "port": int64(6883)},
+	}, result["peers"])
+}
+
+func TestBitTorrentAnnounceSeederReceivesNoPeers(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{BitTorrent: BitTorrentConfig{Enabled: true}})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	h := core.InfoHashFixture()
+	peerID := core.PeerIDFixture()
+
+	mocks.peerStore.EXPECT().UpdatePeer(
+		h, core.NewPeerInfo(peerID, "10.0.0.1", 6881, false, true)).Return(nil)
+
+	params := bitTorrentParams(h, peerID)
+	params.Set("left", "0")
+	params.Set("compact", "1")
+	result := bitTorrentAnnounce(t, addr, params)
+	require.Equal("", result["peers"])
+}
+
+func TestBitTorrentAnnounceInvalidRequest(t *testing.T) {
+	mocks, cleanup := newServerMocks(t, Config{BitTorrent: BitTorrentConfig{Enabled: true}})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	tests := []struct {
+		desc   string
+		modify func(url.Values)
+	}{
+		{"short info hash", func(v url.Values) { v.Set("info_hash", "abc") }},
+		{"short peer id", func(v url.Values) { v.Set("peer_id", "abc") }},
+		{"missing port", func(v url.Values) { v.Del("port") }},
+		{"invalid ip", func(v url.Values) { v.Set("ip", "foo") }},
+		{"invalid numwant", func(v url.Values) { v.Set("numwant", "-1") }},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			params := bitTorrentParams(core.InfoHashFixture(), core.PeerIDFixture())
+			test.modify(params)
+			result := bitTorrentAnnounce(t, addr, params)
+			require.Contains(t, result, "failure reason")
+		})
+	}
+}
+
+func TestBitTorrentAnnounceDisabled(t *testing.T) {
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	params := bitTorrentParams(core.InfoHashFixture(), core.PeerIDFixture())
+	_, err := httputil.Get(fmt.Sprintf("http://%s/bittorrent/announce?%s", addr, params.Encode()))
+	require.True(t, httputil.IsNotFound(err))
+}
@@ -27,8 +27,47 @@ type Config struct {
 	// Limits the number of peers returned on each announce.
 	PeerHandoutLimit int `yaml:"announce_limit"`
 
+	// HandoutLimits overrides PeerHandoutLimit for announces under matching
+	// namespaces. The first matching limit is used.
+	HandoutLimits []HandoutLimitConfig `yaml:"namespace_announce_limits"`
+
 	AnnounceInterval time.Duration `yaml:"announce_interval"`
 
+	// Limits the number of torrents in each batch announce.
+	MaxBatchSize int `yaml:"max_batch_size"`
+
+	// Limits the number of peers counted when scraping a single swarm.
+	ScrapePeerLimit int `yaml:"scrape_peer_limit"`
+
+	// AdaptiveAnnounceInterval suggests announce intervals based on swarm
+	// health instead of always returning AnnounceInterval.
+	AdaptiveAnnounceInterval AdaptiveAnnounceIntervalConfig `yaml:"adaptive_announce_interval"`
+
+	// OriginHandouts controls how often origins are included in announce
+	// responses under matching namespaces. The first matching config is used,
+	// and all origins are included under namespaces which match none.
+	OriginHandouts []OriginHandoutConfig `yaml:"origin_handouts"`
+
+	// ZoneAwareHandout biases peer handouts towards peers in the same zone as
+	// the announcing peer.
+	ZoneAwareHandout ZoneAwareHandoutConfig `yaml:"zone_aware_handout"`
+
+	// RateLimit limits the rate of announces from each peer ID and IP.
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+
+	// Admin configures the authenticated admin API.
+	Admin AdminConfig `yaml:"admin"`
+
+	// Auth configures authentication of announce and metainfo requests.
+	Auth AuthConfig `yaml:"auth"`
+
+	// MetaInfoFallback configures generating metainfo from backends while the
+	// origin cluster is degraded. Only enabled if backends are configured.
+	MetaInfoFallback MetaInfoFallbackConfig `yaml:"metainfo_fallback"`
+
+	// BitTorrent serves the standard BitTorrent HTTP announce protocol.
+	BitTorrent BitTorrentConfig `yaml:"bittorrent"`
+
 	Listener listener.Config `yaml:"listener"`
 }
 
@@ -42,5 +81,96 @@ func (c Config) applyDefaults() Config {
 	if c.AnnounceInterval == 0 {
 		c.AnnounceInterval = 3 * time.Second
 	}
+	if c.MaxBatchSize == 0 {
+		c.MaxBatchSize = 1000
+	}
+	if c.ScrapePeerLimit == 0 {
+		c.ScrapePeerLimit = 10000
+	}
+	c.AdaptiveAnnounceInterval = c.AdaptiveAnnounceInterval.applyDefaults(c)
+	c.ZoneAwareHandout = c.ZoneAwareHandout.applyDefaults()
+	return c
+}
+
+// HandoutLimitConfig defines the number of peers returned on announces under
+// matching namespaces.
+type HandoutLimitConfig struct {
+	// Namespace is a regular expression matching the namespaces which the
+	// limit applies to.
+	Namespace string `yaml:"namespace"`
+
+	// Limit is the number of peers returned on each announce. Defaults to
+	// the global announce limit.
+	Limit int `yaml:"limit"`
+
+	// SwarmSizes overrides Limit by swarm size, such that popular blobs can
+	// receive larger handouts than cold ones.
+	SwarmSizes []SwarmSizeLimitConfig `yaml:"swarm_sizes"`
+}
+
+// SwarmSizeLimitConfig defines the number of peers returned on announces for
+// swarms of at least MinPeers peers. The tracker can only observe swarm sizes
+// up to the largest MinPeers or Limit it is configured with.
+type SwarmSizeLimitConfig struct {
+	MinPeers int `yaml:"min_peers"`
+	Limit    int `yaml:"limit"`
+}
+
+// ZoneAwareHandoutConfig defines how peer handouts are biased towards the zone
+// of the announcing peer. Peers which announce without a zone receive
+// unbiased handouts.
+type ZoneAwareHandoutConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// RemoteFraction is the fraction of each handout reserved for peers in
+	// other zones, such that zones do not become isolated. Remote peers also
+	// fill any slots which same-zone peers cannot.
+	RemoteFraction float64 `yaml:"remote_fraction"`
+
+	// Oversample is the factor by which more peers than the handout limit are
+	// read from the peer store to find same-zone peers.
+	Oversample int `yaml:"oversample"`
+}
+
+func (c ZoneAwareHandoutConfig) applyDefaults() ZoneAwareHandoutConfig {
+	if c.RemoteFraction == 0 {
+		c.RemoteFraction = 0.2
+	}
+	if c.Oversample == 0 {
+		c.Oversample = 4
+	}
+	return c
+}
+
+// AdaptiveAnnounceIntervalConfig defines how announce intervals are derived
+// from swarm health. Small swarms which are still downloading announce at
+// MinInterval so peers discover each other quickly, while large or mostly
+// complete swarms announce up to MaxInterval to limit tracker load.
+type AdaptiveAnnounceIntervalConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	MinInterval time.Duration `yaml:"min_interval"`
+	MaxInterval time.Duration `yaml:"max_interval"`
+
+	// LargeSwarmSize is the number of peers at which swarms announce at
+	// MaxInterval.
+	LargeSwarmSize int `yaml:"large_swarm_size"`
+}
+
+func (c AdaptiveAnnounceIntervalConfig) applyDefaults(
+	parent Config) AdaptiveAnnounceIntervalConfig {
+
+	if c.MinInterval == 0 {
+		c.MinInterval = parent.AnnounceInterval
+	}
+	if c.MaxInterval == 0 {
+		c.MaxInterval = 30 * time.Second
+	}
+	if c.MaxInterval < c.MinInterval {
+		c.MaxInterval = c.MinInterval
+	}
+	if c.LargeSwarmSize == 0 {
+		c.LargeSwarmSize = parent.PeerHandoutLimit
+	}
 	return c
 }
@@ -29,7 +29,11 @@ func Fixture() *Server {
 	config := Config{
 		AnnounceInterval: 250 * time.Millisecond,
 	}
-	return New(
+	s, err := New(
 		config, tally.NoopScope, policy,
 		peerstore.NewTestStore(), originstore.NewNoopStore(), nil)
+	if err != nil {
+		panic(err)
+	}
+	return s
 }
@@ -0,0 +1,136 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/uber/kraken/core"
+)
+
+// handoutLimit defines the number of peers returned on announces under
+// matching namespaces.
+type handoutLimit struct {
+	regexp *regexp.Regexp
+	limit  int
+
+	// Sorted by descending MinPeers.
+	swarmSizes []SwarmSizeLimitConfig
+}
+
+// sampleSize returns the number of peers to read from the peer store, which
+// is enough to both fill any handout and observe every swarm size threshold.
+func (l *handoutLimit) sampleSize() int {
+	n := l.limit
+	for _, s := range l.swarmSizes {
+		if s.Limit > n {
+			n = s.Limit
+		}
+		if s.MinPeers > n {
+			n = s.MinPeers
+		}
+	}
+	return n
+}
+
+// forSwarm returns the number of peers to hand out for a swarm of size peers.
+func (l *handoutLimit) forSwarm(size int) int {
+	for _, s := range l.swarmSizes {
+		if size >= s.MinPeers {
+			return s.Limit
+		}
+	}
+	return l.limit
+}
+
+// handoutLimits matches namespaces to their handout limit. The first matching
+// limit is used, else the global announce limit.
+type handoutLimits struct {
+	limits       []*handoutLimit
+	defaultLimit *handoutLimit
+}
+
+func newHandoutLimits(config Config) (*handoutLimits, error) {
+	var limits []*handoutLimit
+	for _, c := range config.HandoutLimits {
+		re, err := regexp.Compile(c.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace regexp %q: %s", c.Namespace, err)
+		}
+		l := &handoutLimit{
+			regexp: re,
+			limit:  c.Limit,
+		}
+		if l.limit == 0 {
+			l.limit = config.PeerHandoutLimit
+		}
+		for _, s := range c.SwarmSizes {
+			if s.Limit <= 0 {
+				return nil, fmt.Errorf(
+					"namespace %q: swarm size %d: limit must be positive", c.Namespace, s.MinPeers)
+			}
+			l.swarmSizes = append(l.swarmSizes, s)
+		}
+		sort.Slice(l.swarmSizes, func(i, j int) bool {
+			return l.swarmSizes[i].MinPeers > l.swarmSizes[j].MinPeers
+		})
+		limits = append(limits, l)
+	}
+	return &handoutLimits{
+		limits:       limits,
+		defaultLimit: &handoutLimit{limit: config.PeerHandoutLimit},
+	}, nil
+}
+
+func (l *handoutLimits) match(namespace string) *handoutLimit {
+	for _, limit := range l.limits {
+		if limit.regexp.MatchString(namespace) {
+			return limit
+		}
+	}
+	return l.defaultLimit
+}
+
+// getPeers returns the peers of h to hand out to peer, limited according to
+// namespace and the size of the swarm, and biased towards the zone of peer if
+// zone-aware handouts are enabled.
+func (s *Server) getPeers(
+	namespace string, h core.InfoHash, peer *core.PeerInfo) ([]*core.PeerInfo, error) {
+
+	limit := s.handoutLimits.match(namespace)
+	n := limit.sampleSize()
+
+	zoneConfig := s.config.ZoneAwareHandout
+	zoneAware := zoneConfig.Enabled && peer.Zone != ""
+	if zoneAware {
+		n *= zoneConfig.Oversample
+	}
+	peers, err := s.peerStore.GetPeers(h, n)
+	if err != nil {
+		return nil, err
+	}
+	max := limit.forSwarm(len(peers))
+	if !zoneAware {
+		if len(peers) > max {
+			peers = peers[:max]
+		}
+		return peers, nil
+	}
+	selected, local := selectZonePeers(peer.Zone, peers, max, zoneConfig.RemoteFraction)
+	s.stats.Counter("handout_same_zone_peers").Inc(int64(local))
+	s.stats.Counter("handout_remote_zone_peers").Inc(int64(len(selected) - local))
+	return selected, nil
+}
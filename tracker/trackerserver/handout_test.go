@@ -0,0 +1,80 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandoutLimits(t *testing.T) {
+	limits, err := newHandoutLimits(Config{
+		PeerHandoutLimit: 50,
+		HandoutLimits: []HandoutLimitConfig{{
+			Namespace: "^cold/.*",
+			Limit:     5,
+		}, {
+			Namespace: "^hot/.*",
+			SwarmSizes: []SwarmSizeLimitConfig{
+				{MinPeers: 100, Limit: 100},
+				{MinPeers: 500, Limit: 200},
+			},
+		}},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc       string
+		namespace  string
+		swarmSize  int
+		sampleSize int
+		expected   int
+	}{
+		{"no match", "other/repo", 1000, 50, 50},
+		{"empty namespace", "", 1000, 50, 50},
+		{"namespace limit", "cold/repo", 1000, 5, 5},
+		{"small swarm", "hot/repo", 20, 500, 50},
+		{"medium swarm", "hot/repo", 100, 500, 100},
+		{"large swarm", "hot/repo", 500, 500, 200},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			l := limits.match(test.namespace)
+			require.Equal(test.sampleSize, l.sampleSize())
+			require.Equal(test.expected, l.forSwarm(test.swarmSize))
+		})
+	}
+}
+
+func TestHandoutLimitsErrors(t *testing.T) {
+	tests := []struct {
+		desc   string
+		config HandoutLimitConfig
+	}{
+		{"invalid regexp", HandoutLimitConfig{Namespace: "("}},
+		{"non-positive swarm size limit", HandoutLimitConfig{
+			Namespace:  ".*",
+			SwarmSizes: []SwarmSizeLimitConfig{{MinPeers: 10}},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := newHandoutLimits(Config{HandoutLimits: []HandoutLimitConfig{test.config}})
+			require.Error(t, err)
+		})
+	}
+}
@@ -19,6 +19,7 @@ import (
 
 	"github.com/uber/kraken/utils/handler"
 	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
 )
 
 func (s *Server) getMetaInfoHandler(w http.ResponseWriter, r *http.Request) error {
@@ -26,6 +27,9 @@ func (s *Server) getMetaInfoHandler(w http.ResponseWriter, r *http.Request) erro
 	if err != nil {
 		return err
 	}
+	if err := s.authenticateNamespace(r, namespace); err != nil {
+		return err
+	}
 	d, err := httputil.ParseDigest(r, "digest")
 	if err != nil {
 		return handler.Errorf("parse digest: %s", err).Status(http.StatusBadRequest)
@@ -33,6 +37,15 @@ func (s *Server) getMetaInfoHandler(w http.ResponseWriter, r *http.Request) erro
 
 	timer := s.stats.Timer("get_metainfo").Start()
 	mi, err := s.originCluster.GetMetaInfo(namespace, d)
+	if err != nil && s.metaInfoFallback != nil && originDegraded(err) {
+		s.stats.Counter("metainfo_fallbacks").Inc(1)
+		log.With("namespace", namespace, "digest", d).Warnf(
+			"Origin cluster degraded, falling back to backend: %s", err)
+		mi, err = s.metaInfoFallback.get(namespace, d)
+		if err != nil {
+			return err
+		}
+	}
 	if err != nil {
 		if serr, ok := err.(httputil.StatusError); ok {
 			// Propagate errors received from origin.
@@ -14,20 +14,31 @@
 package trackerserver
 
 import (
+	"context"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/backend"
 	"github.com/uber/kraken/lib/hashring"
 	"github.com/uber/kraken/lib/hostlist"
+	"github.com/uber/kraken/lib/metainfogen"
+	"github.com/uber/kraken/mocks/lib/backend"
 	"github.com/uber/kraken/tracker/metainfoclient"
 	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/mockutil"
 	"github.com/uber/kraken/utils/testutil"
 
+	"github.com/c2h5oh/datasize"
+	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 )
 
 func newMetaInfoClient(addr string) metainfoclient.Client {
-	return metainfoclient.New(hashring.NoopPassiveRing(hostlist.Fixture(addr)), nil)
+	return metainfoclient.New(
+		metainfoclient.Config{}, tally.NoopScope, hashring.NoopPassiveRing(hostlist.Fixture(addr)), nil)
 }
 
 func TestGetMetaInfoHandlerFetchesFromOrigin(t *testing.T) {
@@ -46,7 +57,7 @@ func TestGetMetaInfoHandlerFetchesFromOrigin(t *testing.T) {
 
 	client := newMetaInfoClient(addr)
 
-	result, err := client.Download(namespace, mi.Digest())
+	result, err := client.Download(context.Background(), namespace, mi.Digest())
 	require.NoError(err)
 	require.Equal(mi, result)
 }
@@ -68,7 +79,166 @@ func TestGetMetaInfoHandlerPropagatesOriginError(t *testing.T) {
 
 	client := newMetaInfoClient(addr)
 
-	_, err := client.Download(namespace, mi.Digest())
+	_, err := client.Download(context.Background(), namespace, mi.Digest())
 	require.Error(err)
 	require.True(httputil.IsStatus(err, 599))
 }
+
+func TestGetMetaInfoHandlerPendingCancelledByContext(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	namespace := core.TagFixture()
+	mi := core.MetaInfoFixture()
+
+	mocks.originCluster.EXPECT().GetMetaInfo(
+		namespace, mi.Digest()).Return(nil, httputil.StatusError{Status: http.StatusAccepted}).MinTimes(1)
+
+	client := newMetaInfoClient(addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.Download(ctx, namespace, mi.Digest())
+	require.Equal(context.DeadlineExceeded, err)
+	require.True(time.Since(start) < 5*time.Second)
+}
+
+func TestGetMetaInfoHandlerPendingPollsWithBackoff(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	namespace := core.TagFixture()
+	mi := core.MetaInfoFixture()
+
+	gomock.InOrder(
+		mocks.originCluster.EXPECT().GetMetaInfo(namespace, mi.Digest()).Return(
+			nil, httputil.StatusError{Status: http.StatusAccepted}).Times(2),
+		mocks.originCluster.EXPECT().GetMetaInfo(namespace, mi.Digest()).Return(mi, nil),
+	)
+
+	client := metainfoclient.New(
+		metainfoclient.Config{InitialInterval: 10 * time.Millisecond},
+		tally.NoopScope,
+		hashring.NoopPassiveRing(hostlist.Fixture(addr)),
+		nil)
+
+	result, err := client.Download(context.Background(), namespace, mi.Digest())
+	require.NoError(err)
+	require.Equal(mi, result)
+}
+
+func TestGetMetaInfoHandlerPendingDeadline(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	namespace := core.TagFixture()
+	mi := core.MetaInfoFixture()
+
+	mocks.originCluster.EXPECT().GetMetaInfo(namespace, mi.Digest()).Return(
+		nil, httputil.StatusError{Status: http.StatusAccepted}).MinTimes(1)
+
+	client := metainfoclient.New(
+		metainfoclient.Config{
+			InitialInterval: 10 * time.Millisecond,
+			Deadline:        100 * time.Millisecond,
+		},
+		tally.NoopScope,
+		hashring.NoopPassiveRing(hostlist.Fixture(addr)),
+		nil)
+
+	_, err := client.Download(context.Background(), namespace, mi.Digest())
+	require.Error(err)
+}
+
+func metaInfoFallbackConfigFixture(pieceLength int) MetaInfoFallbackConfig {
+	return MetaInfoFallbackConfig{
+		MetaInfoGen: metainfogen.Config{
+			PieceLengths: map[datasize.ByteSize]datasize.ByteSize{
+				0: datasize.ByteSize(pieceLength),
+			},
+		},
+	}
+}
+
+func TestGetMetaInfoHandlerFallsBackToBackendWhenOriginDegraded(t *testing.T) {
+	require := require.New(t)
+
+	pieceLength := 10
+
+	mocks, cleanup := newServerMocks(t, Config{
+		MetaInfoFallback: metaInfoFallbackConfigFixture(pieceLength),
+	})
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(100, uint64(pieceLength))
+
+	backendClient := mockbackend.NewMockClient(mocks.ctrl)
+	backends := backend.ManagerFixture()
+	require.NoError(backends.Register(namespace, backendClient))
+
+	addr, stop := testutil.StartServer(mocks.handler(WithBackends(backends)))
+	defer stop()
+
+	mocks.originCluster.EXPECT().GetMetaInfo(
+		namespace, blob.Digest).Return(nil, httputil.StatusError{Status: 503}).MinTimes(1)
+	backendClient.EXPECT().Stat(
+		namespace, blob.Digest.Hex()).Return(core.NewBlobInfo(int64(len(blob.Content))), nil).MinTimes(1)
+	backendClient.EXPECT().Download(
+		namespace, blob.Digest.Hex(), mockutil.MatchWriter(blob.Content)).Return(nil)
+
+	client := newMetaInfoClient(addr)
+
+	result, err := client.Download(context.Background(), namespace, blob.Digest)
+	require.NoError(err)
+	require.Equal(blob.MetaInfo, result)
+
+	// Generated metainfo is cached.
+	result, err = client.Download(context.Background(), namespace, blob.Digest)
+	require.NoError(err)
+	require.Equal(blob.MetaInfo, result)
+}
+
+func TestGetMetaInfoHandlerDoesNotFallBackWhenOriginHealthy(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{
+		MetaInfoFallback: metaInfoFallbackConfigFixture(10),
+	})
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	mi := core.MetaInfoFixture()
+
+	backendClient := mockbackend.NewMockClient(mocks.ctrl)
+	backends := backend.ManagerFixture()
+	require.NoError(backends.Register(namespace, backendClient))
+
+	addr, stop := testutil.StartServer(mocks.handler(WithBackends(backends)))
+	defer stop()
+
+	mocks.originCluster.EXPECT().GetMetaInfo(
+		namespace, mi.Digest()).Return(nil, httputil.StatusError{Status: http.StatusNotFound})
+
+	client := newMetaInfoClient(addr)
+
+	_, err := client.Download(context.Background(), namespace, mi.Digest())
+	require.Equal(metainfoclient.ErrNotFound, err)
+}
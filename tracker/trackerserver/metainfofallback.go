@@ -0,0 +1,225 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/backend"
+	"github.com/uber/kraken/lib/backend/backenderrors"
+	"github.com/uber/kraken/lib/metainfogen"
+	"github.com/uber/kraken/utils/dedup"
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/c2h5oh/datasize"
+	"github.com/uber-go/tally"
+)
+
+// MetaInfoFallbackConfig defines how metainfo is generated from the storage
+// backend while the origin cluster is degraded.
+type MetaInfoFallbackConfig struct {
+	// MetaInfoGen must match the origin configuration, else generated metainfo
+	// will not share info hashes with metainfo served by origins.
+	MetaInfoGen metainfogen.Config `yaml:"metainfogen"`
+
+	// SizeLimit rejects blobs larger than the limit, since every byte of the
+	// blob is downloaded by the tracker.
+	SizeLimit datasize.ByteSize `yaml:"size_limit"`
+
+	// CacheTTL is how long generated metainfo is served before it is
+	// generated again.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+
+	// NumWorkers limits the number of concurrent generations.
+	NumWorkers int `yaml:"num_workers"`
+}
+
+func (c MetaInfoFallbackConfig) applyDefaults() MetaInfoFallbackConfig {
+	if c.SizeLimit == 0 {
+		c.SizeLimit = 10 * datasize.GB
+	}
+	if c.CacheTTL == 0 {
+		c.CacheTTL = 10 * time.Minute
+	}
+	if c.NumWorkers == 0 {
+		c.NumWorkers = 16
+	}
+	return c
+}
+
+// WithBackends enables generating metainfo from backends when the origin
+// cluster is degraded, such that pulls may proceed without origins.
+func WithBackends(backends *backend.Manager) Option {
+	return func(s *Server) {
+		s.backends = backends
+	}
+}
+
+type cachedMetaInfo struct {
+	mi        *core.MetaInfo
+	expiresAt time.Time
+}
+
+// metaInfoFallback generates metainfo by streaming blobs from backends, without
+// storing the blobs themselves.
+type metaInfoFallback struct {
+	config    MetaInfoFallbackConfig
+	stats     tally.Scope
+	clk       clock.Clock
+	backends  *backend.Manager
+	generator *metainfogen.Generator
+	requests  *dedup.RequestCache
+
+	mu    sync.Mutex
+	cache map[string]cachedMetaInfo
+}
+
+func newMetaInfoFallback(
+	config MetaInfoFallbackConfig,
+	stats tally.Scope,
+	clk clock.Clock,
+	backends *backend.Manager) (*metaInfoFallback, error) {
+
+	config = config.applyDefaults()
+
+	generator, err := metainfogen.New(config.MetaInfoGen, nil)
+	if err != nil {
+		return nil, fmt.Errorf("metainfogen: %s", err)
+	}
+
+	requests := dedup.NewRequestCache(dedup.RequestCacheConfig{
+		NumWorkers: config.NumWorkers,
+	}, clk)
+	requests.SetNotFound(func(err error) bool { return err == backenderrors.ErrBlobNotFound })
+
+	return &metaInfoFallback{
+		config:    config,
+		stats:     stats,
+		clk:       clk,
+		backends:  backends,
+		generator: generator,
+		requests:  requests,
+		cache:     make(map[string]cachedMetaInfo),
+	}, nil
+}
+
+// originDegraded returns true if err indicates that origins are unable to serve
+// metainfo, as opposed to the blob not existing or metainfo being pending.
+func originDegraded(err error) bool {
+	if serr, ok := err.(httputil.StatusError); ok {
+		return serr.Status >= 500
+	}
+	return true
+}
+
+// get returns metainfo for d, generated from the backend of namespace. Returns
+// a 202 error while generation is pending.
+func (f *metaInfoFallback) get(namespace string, d core.Digest) (*core.MetaInfo, error) {
+	id := namespace + ":" + d.Hex()
+	if mi := f.load(id); mi != nil {
+		return mi, nil
+	}
+
+	client, err := f.backends.GetClient(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("backend manager: %s", err)
+	}
+	info, err := client.Stat(namespace, d.Hex())
+	if err != nil {
+		if err == backenderrors.ErrBlobNotFound {
+			return nil, handler.ErrorStatus(http.StatusNotFound)
+		}
+		return nil, fmt.Errorf("stat: %s", err)
+	}
+	size := datasize.ByteSize(info.Size)
+	if size > f.config.SizeLimit {
+		return nil, handler.Errorf(
+			"%s blob exceeds fallback size limit of %s", size, f.config.SizeLimit).
+			Status(http.StatusServiceUnavailable)
+	}
+
+	err = f.requests.Start(id, func() error {
+		mi, err := f.generate(client, namespace, d, info.Size)
+		if err != nil {
+			return err
+		}
+		f.store(id, mi)
+		f.stats.Counter("metainfo_fallback_generations").Inc(1)
+		log.With("namespace", namespace, "digest", d).Info("Generated metainfo from backend")
+		return nil
+	})
+	switch err {
+	case nil, dedup.ErrRequestPending:
+		return nil, handler.ErrorStatus(http.StatusAccepted)
+	case backenderrors.ErrBlobNotFound:
+		return nil, handler.ErrorStatus(http.StatusNotFound)
+	case dedup.ErrWorkersBusy:
+		return nil, handler.ErrorStatus(http.StatusServiceUnavailable)
+	default:
+		return nil, err
+	}
+}
+
+func (f *metaInfoFallback) generate(
+	client backend.Client, namespace string, d core.Digest, size int64) (*core.MetaInfo, error) {
+
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(client.Download(namespace, d.Hex(), w))
+	}()
+	// Closing r unblocks the download if generation fails early.
+	defer r.Close()
+
+	mi, err := f.generator.FromReader(d, r, size)
+	if err != nil {
+		return nil, fmt.Errorf("generate metainfo: %s", err)
+	}
+	return mi, nil
+}
+
+func (f *metaInfoFallback) load(id string) *core.MetaInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.cache[id]
+	if !ok {
+		return nil
+	}
+	if f.clk.Now().After(c.expiresAt) {
+		delete(f.cache, id)
+		return nil
+	}
+	return c.mi
+}
+
+func (f *metaInfoFallback) store(id string, mi *core.MetaInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.clk.Now()
+	for k, c := range f.cache {
+		if now.After(c.expiresAt) {
+			delete(f.cache, k)
+		}
+	}
+	f.cache[id] = cachedMetaInfo{mi, now.Add(f.config.CacheTTL)}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+
+	"github.com/uber/kraken/core"
+)
+
+// OriginHandoutConfig defines how often origins are included in announce
+// responses under matching namespaces. Origins are always included in small
+// swarms, and with decreasing probability as swarms grow, down to
+// MinProbability in large swarms. Origins are always included if none of the
+// handed out peers are seeders.
+type OriginHandoutConfig struct {
+	// Namespace is a regular expression matching the namespaces which the
+	// config applies to.
+	Namespace string `yaml:"namespace"`
+
+	// MaxOrigins limits the number of origins included in each announce
+	// response. Defaults to all origins.
+	MaxOrigins int `yaml:"max_origins"`
+
+	// SmallSwarmSize and LargeSwarmSize are numbers of handed out peers.
+	// Since handouts are limited, LargeSwarmSize should not exceed the
+	// announce limit.
+	SmallSwarmSize int `yaml:"small_swarm_size"`
+	LargeSwarmSize int `yaml:"large_swarm_size"`
+
+	// MinProbability is the probability of including origins in swarms of
+	// at least LargeSwarmSize peers.
+	MinProbability float64 `yaml:"min_probability"`
+}
+
+func (c OriginHandoutConfig) applyDefaults() OriginHandoutConfig {
+	if c.SmallSwarmSize == 0 {
+		c.SmallSwarmSize = 5
+	}
+	if c.LargeSwarmSize < c.SmallSwarmSize {
+		c.LargeSwarmSize = c.SmallSwarmSize
+	}
+	return c
+}
+
+type originHandout struct {
+	regexp *regexp.Regexp
+	config OriginHandoutConfig
+}
+
+// probability returns the probability of including origins in handouts of
+// size peers.
+func (o *originHandout) probability(size int) float64 {
+	c := o.config
+	if size < c.SmallSwarmSize {
+		return 1
+	}
+	if size >= c.LargeSwarmSize {
+		return c.MinProbability
+	}
+	frac := float64(size-c.SmallSwarmSize) / float64(c.LargeSwarmSize-c.SmallSwarmSize)
+	return 1 - frac*(1-c.MinProbability)
+}
+
+// originHandouts matches namespaces to their origin handout config. The first
+// matching config is used. Namespaces which match none always include all
+// origins.
+type originHandouts struct {
+	handouts []*originHandout
+}
+
+func newOriginHandouts(configs []OriginHandoutConfig) (*originHandouts, error) {
+	var handouts []*originHandout
+	for _, c := range configs {
+		re, err := regexp.Compile(c.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace regexp %q: %s", c.Namespace, err)
+		}
+		if c.MinProbability < 0 || c.MinProbability > 1 {
+			return nil, fmt.Errorf(
+				"namespace %q: min probability must be within [0, 1]", c.Namespace)
+		}
+		handouts = append(handouts, &originHandout{re, c.applyDefaults()})
+	}
+	return &originHandouts{handouts}, nil
+}
+
+func (o *originHandouts) match(namespace string) *originHandout {
+	for _, h := range o.handouts {
+		if h.regexp.MatchString(namespace) {
+			return h
+		}
+	}
+	return nil
+}
+
+func hasSeeder(peers []*core.PeerInfo) bool {
+	for _, p := range peers {
+		if p.Complete {
+			return true
+		}
+	}
+	return false
+}
+
+// getOrigins returns the origins of d to include in a handout of peers under
+// namespace.
+func (s *Server) getOrigins(
+	namespace string, d core.Digest, peers []*core.PeerInfo) ([]*core.PeerInfo, error) {
+
+	o := s.originHandouts.match(namespace)
+	if o == nil {
+		return s.originStore.GetOrigins(d)
+	}
+	if hasSeeder(peers) && rand.Float64() >= o.probability(len(peers)) {
+		s.stats.Counter("omitted_origin_handouts").Inc(1)
+		return nil, nil
+	}
+	origins, err := s.originStore.GetOrigins(d)
+	if err != nil {
+		return nil, err
+	}
+	if o.config.MaxOrigins > 0 && len(origins) > o.config.MaxOrigins {
+		// Spread load across origins.
+		shuffled := make([]*core.PeerInfo, len(origins))
+		copy(shuffled, origins)
+		rand.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		origins = shuffled[:o.config.MaxOrigins]
+	}
+	return origins, nil
+}
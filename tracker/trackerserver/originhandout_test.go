@@ -0,0 +1,129 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/mocks/tracker/originstore"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestOriginHandoutProbability(t *testing.T) {
+	handouts, err := newOriginHandouts([]OriginHandoutConfig{{
+		Namespace:      ".*",
+		SmallSwarmSize: 10,
+		LargeSwarmSize: 30,
+		MinProbability: 0.2,
+	}})
+	require.NoError(t, err)
+	o := handouts.match("foo")
+
+	tests := []struct {
+		size     int
+		expected float64
+	}{
+		{0, 1},
+		{9, 1},
+		{10, 1},
+		{20, 0.6},
+		{30, 0.2},
+		{100, 0.2},
+	}
+	for _, test := range tests {
+		require.InDelta(t, test.expected, o.probability(test.size), 0.0001, "size %d", test.size)
+	}
+}
+
+func TestNewOriginHandoutsErrors(t *testing.T) {
+	tests := []struct {
+		desc   string
+		config OriginHandoutConfig
+	}{
+		{"invalid regexp", OriginHandoutConfig{Namespace: "("}},
+		{"invalid probability", OriginHandoutConfig{Namespace: ".*", MinProbability: 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := newOriginHandouts([]OriginHandoutConfig{test.config})
+			require.Error(t, err)
+		})
+	}
+}
+
+func peersFixture(n int, complete bool) []*core.PeerInfo {
+	var peers []*core.PeerInfo
+	for i := 0; i < n; i++ {
+		p := core.PeerInfoFixture()
+		p.Complete = complete
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+func TestGetOrigins(t *testing.T) {
+	origins := []*core.PeerInfo{
+		core.OriginPeerInfoFixture(),
+		core.OriginPeerInfoFixture(),
+		core.OriginPeerInfoFixture(),
+	}
+
+	config := Config{
+		OriginHandouts: []OriginHandoutConfig{{
+			Namespace:      "^large/.*",
+			MaxOrigins:     1,
+			SmallSwarmSize: 2,
+			LargeSwarmSize: 4,
+			MinProbability: 0,
+		}},
+	}
+
+	tests := []struct {
+		desc      string
+		namespace string
+		peers     []*core.PeerInfo
+		expected  int
+	}{
+		{"unmatched namespace", "other/repo", peersFixture(10, true), 3},
+		{"small swarm", "large/repo", peersFixture(1, true), 1},
+		{"large swarm", "large/repo", peersFixture(10, true), 0},
+		{"large swarm without seeders", "large/repo", peersFixture(10, false), 1},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			originStore := mockoriginstore.NewMockStore(ctrl)
+
+			s, err := New(config, tally.NoopScope, nil, nil, originStore, nil)
+			require.NoError(err)
+
+			d := core.DigestFixture()
+			if test.expected > 0 {
+				originStore.EXPECT().GetOrigins(d).Return(origins, nil)
+			}
+
+			result, err := s.getOrigins(test.namespace, d, test.peers)
+			require.NoError(err)
+			require.Len(result, test.expected)
+		})
+	}
+}
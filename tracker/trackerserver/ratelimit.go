@@ -0,0 +1,377 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/httputil"
+
+	"github.com/andres-erbsen/clock"
+	"golang.org/x/time/rate"
+)
+
+// Deny list entry kinds.
+const (
+	DenyPeerID = "peer_id"
+	DenyIP     = "ip"
+)
+
+// RateLimitConfig defines per-peer-ID and per-IP limits on announce rates.
+// Peers which repeatedly exceed their limit are denied for a penalty period.
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// PeerRate and PeerBurst define the announces per second allowed from a
+	// single peer ID.
+	PeerRate  float64 `yaml:"peer_rate"`
+	PeerBurst int     `yaml:"peer_burst"`
+
+	// IPRate and IPBurst define the announces per second allowed from a single
+	// IP, which may host many peers.
+	IPRate  float64 `yaml:"ip_rate"`
+	IPBurst int     `yaml:"ip_burst"`
+
+	// MaxViolations is the number of rate limited announces after which a peer
+	// ID or IP is denied for Penalty.
+	MaxViolations int           `yaml:"max_violations"`
+	Penalty       time.Duration `yaml:"penalty"`
+
+	// IdleTTL is how long limits of peers which stopped announcing are kept.
+	IdleTTL time.Duration `yaml:"idle_ttl"`
+}
+
+func (c RateLimitConfig) applyDefaults() RateLimitConfig {
+	if c.PeerRate == 0 {
+		c.PeerRate = 1
+	}
+	if c.PeerBurst == 0 {
+		c.PeerBurst = 10
+	}
+	if c.IPRate == 0 {
+		c.IPRate = 50
+	}
+	if c.IPBurst == 0 {
+		c.IPBurst = 200
+	}
+	if c.MaxViolations == 0 {
+		c.MaxViolations = 50
+	}
+	if c.Penalty == 0 {
+		c.Penalty = 5 * time.Minute
+	}
+	if c.IdleTTL == 0 {
+		c.IdleTTL = 10 * time.Minute
+	}
+	return c
+}
+
+// DenyEntry defines a peer ID or IP whose announces are rejected.
+type DenyEntry struct {
+	Kind   string `json:"kind"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+
+	// ExpiresAt is nil for entries which never expire.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (e DenyEntry) expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !now.Before(*e.ExpiresAt)
+}
+
+type denyKey struct {
+	kind  string
+	value string
+}
+
+// parseDenyKey validates and normalizes a deny list key.
+func parseDenyKey(kind, value string) (denyKey, error) {
+	switch kind {
+	case DenyPeerID:
+		peerID, err := core.NewPeerID(value)
+		if err != nil {
+			return denyKey{}, fmt.Errorf("invalid peer id: %s", err)
+		}
+		return denyKey{kind, peerID.String()}, nil
+	case DenyIP:
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return denyKey{}, fmt.Errorf("invalid ip %q", value)
+		}
+		return denyKey{kind, ip.String()}, nil
+	default:
+		return denyKey{}, fmt.Errorf("invalid kind %q", kind)
+	}
+}
+
+type limitEntry struct {
+	limiter    *rate.Limiter
+	violations int
+	lastSeen   time.Time
+}
+
+// announceLimiter rate limits announces and rejects denied peer IDs and IPs.
+// The deny list applies even if rate limiting is disabled.
+type announceLimiter struct {
+	config RateLimitConfig
+	clk    clock.Clock
+
+	mu     sync.Mutex
+	limits map[denyKey]*limitEntry
+	deny   map[denyKey]DenyEntry
+	lastGC time.Time
+}
+
+func newAnnounceLimiter(config RateLimitConfig, clk clock.Clock) *announceLimiter {
+	return &announceLimiter{
+		config: config.applyDefaults(),
+		clk:    clk,
+		limits: make(map[denyKey]*limitEntry),
+		deny:   make(map[denyKey]DenyEntry),
+		lastGC: clk.Now(),
+	}
+}
+
+// admit returns an error if an announce from peerID and ip should be
+// rejected.
+func (l *announceLimiter) admit(peerID core.PeerID, ip string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clk.Now()
+	l.gc(now)
+
+	keys := []denyKey{{DenyPeerID, peerID.String()}}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		keys = append(keys, denyKey{DenyIP, parsed.String()})
+	}
+	for _, k := range keys {
+		if e, ok := l.deny[k]; ok && !e.expired(now) {
+			err := handler.Errorf("%s %s denied: %s", k.kind, k.value, e.Reason).Status(http.StatusForbidden)
+			if e.ExpiresAt != nil {
+				err.Header("Retry-After", retryAfter(e.ExpiresAt.Sub(now)))
+			}
+			return err
+		}
+	}
+	if !l.config.Enabled {
+		return nil
+	}
+	for _, k := range keys {
+		e := l.entry(k, now)
+		r := e.limiter.ReserveN(now, 1)
+		delay := r.DelayFrom(now)
+		if delay == 0 {
+			continue
+		}
+		// Rejected announces do not consume tokens.
+		r.CancelAt(now)
+		e.violations++
+		if e.violations >= l.config.MaxViolations {
+			e.violations = 0
+			expiresAt := now.Add(l.config.Penalty)
+			l.deny[k] = DenyEntry{
+				Kind:      k.kind,
+				Value:     k.value,
+				Reason:    "announce rate limit repeatedly exceeded",
+				ExpiresAt: &expiresAt,
+			}
+			delay = l.config.Penalty
+		}
+		return handler.Errorf("%s %s announce rate limited", k.kind, k.value).
+			Status(http.StatusTooManyRequests).
+			Header("Retry-After", retryAfter(delay))
+	}
+	return nil
+}
+
+func (l *announceLimiter) entry(k denyKey, now time.Time) *limitEntry {
+	e, ok := l.limits[k]
+	if !ok {
+		var r rate.Limit
+		var burst int
+		if k.kind == DenyPeerID {
+			r, burst = rate.Limit(l.config.PeerRate), l.config.PeerBurst
+		} else {
+			r, burst = rate.Limit(l.config.IPRate), l.config.IPBurst
+		}
+		e = &limitEntry{limiter: rate.NewLimiter(r, burst)}
+		l.limits[k] = e
+	}
+	e.lastSeen = now
+	return e
+}
+
+// gc removes idle limits and expired deny list entries.
+func (l *announceLimiter) gc(now time.Time) {
+	if now.Sub(l.lastGC) < l.config.IdleTTL {
+		return
+	}
+	l.lastGC = now
+	for k, e := range l.limits {
+		if now.Sub(e.lastSeen) >= l.config.IdleTTL {
+			delete(l.limits, k)
+		}
+	}
+	for k, e := range l.deny {
+		if e.expired(now) {
+			delete(l.deny, k)
+		}
+	}
+}
+
+// denyList returns all unexpired deny list entries.
+func (l *announceLimiter) denyList() []DenyEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clk.Now()
+	entries := []DenyEntry{}
+	for _, e := range l.deny {
+		if !e.expired(now) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Kind != entries[j].Kind {
+			return entries[i].Kind < entries[j].Kind
+		}
+		return entries[i].Value < entries[j].Value
+	})
+	return entries
+}
+
+// addDeny denies k for ttl, or indefinitely if ttl is 0.
+func (l *announceLimiter) addDeny(k denyKey, reason string, ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := DenyEntry{Kind: k.kind, Value: k.value, Reason: reason}
+	if ttl > 0 {
+		expiresAt := l.clk.Now().Add(ttl)
+		e.ExpiresAt = &expiresAt
+	}
+	l.deny[k] = e
+}
+
+// removeDeny removes k from the deny list, returning whether it was present.
+func (l *announceLimiter) removeDeny(k denyKey) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.deny[k]
+	delete(l.deny, k)
+	if e, ok := l.limits[k]; ok {
+		e.violations = 0
+	}
+	return ok
+}
+
+// retryAfter formats d as a Retry-After header value in whole seconds.
+func retryAfter(d time.Duration) string {
+	secs := int64((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.FormatInt(secs, 10)
+}
+
+// clientIP returns the IP of the client of r. Trackers run behind nginx, which
+// sets X-Real-IP to the address of the client.
+func clientIP(r *http.Request) string {
+	if ip := r.Header.Get("X-Real-IP"); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// admitAnnounce rejects announces from peer if it is denied or exceeds its
+// announce rate.
+func (s *Server) admitAnnounce(r *http.Request, peer *core.PeerInfo) error {
+	if peer == nil {
+		return handler.Errorf("missing peer").Status(http.StatusBadRequest)
+	}
+	if err := s.limiter.admit(peer.PeerID, clientIP(r)); err != nil {
+		s.stats.Counter("rejected_announces").Inc(1)
+		return err
+	}
+	return nil
+}
+
+func (s *Server) getDenyListHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := json.NewEncoder(w).Encode(s.limiter.denyList()); err != nil {
+		return handler.Errorf("json encode response: %s", err)
+	}
+	return nil
+}
+
+func parseDenyParams(r *http.Request) (denyKey, error) {
+	kind, err := httputil.ParseParam(r, "kind")
+	if err != nil {
+		return denyKey{}, err
+	}
+	value, err := httputil.ParseParam(r, "value")
+	if err != nil {
+		return denyKey{}, err
+	}
+	k, err := parseDenyKey(kind, value)
+	if err != nil {
+		return denyKey{}, handler.Errorf("%s", err).Status(http.StatusBadRequest)
+	}
+	return k, nil
+}
+
+// addDenyHandler denies a peer ID or IP, optionally for the duration given by
+// the "ttl" query argument.
+func (s *Server) addDenyHandler(w http.ResponseWriter, r *http.Request) error {
+	k, err := parseDenyParams(r)
+	if err != nil {
+		return err
+	}
+	var ttl time.Duration
+	if v := httputil.GetQueryArg(r, "ttl", ""); v != "" {
+		ttl, err = time.ParseDuration(v)
+		if err != nil || ttl < 0 {
+			return handler.Errorf("invalid ttl %q", v).Status(http.StatusBadRequest)
+		}
+	}
+	reason := httputil.GetQueryArg(r, "reason", "denied by operator")
+	s.limiter.addDeny(k, reason, ttl)
+	return nil
+}
+
+func (s *Server) removeDenyHandler(w http.ResponseWriter, r *http.Request) error {
+	k, err := parseDenyParams(r)
+	if err != nil {
+		return err
+	}
+	if !s.limiter.removeDeny(k) {
+		return handler.ErrorStatus(http.StatusNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/tracker/announceclient"
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func requireStatus(t *testing.T, status int, err error) {
+	require.Error(t, err)
+	herr, ok := err.(*handler.Error)
+	require.True(t, ok, "expected handler error, got %T", err)
+	require.Equal(t, status, herr.GetStatus())
+}
+
+func TestAnnounceLimiterPeerRate(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	l := newAnnounceLimiter(RateLimitConfig{
+		Enabled:   true,
+		PeerRate:  1,
+		PeerBurst: 2,
+	}, clk)
+
+	peerID := core.PeerIDFixture()
+
+	require.NoError(l.admit(peerID, "10.0.0.1"))
+	require.NoError(l.admit(peerID, "10.0.0.1"))
+	requireStatus(t, http.StatusTooManyRequests, l.admit(peerID, "10.0.0.1"))
+
+	// Other peers on the same IP are unaffected.
+	require.NoError(l.admit(core.PeerIDFixture(), "10.0.0.1"))
+
+	clk.Add(time.Second)
+	require.NoError(l.admit(peerID, "10.0.0.1"))
+}
+
+func TestAnnounceLimiterIPRate(t *testing.T) {
+	require := require.New(t)
+
+	l := newAnnounceLimiter(RateLimitConfig{
+		Enabled: true,
+		IPRate:  1,
+		IPBurst: 2,
+	}, clock.NewMock())
+
+	require.NoError(l.admit(core.PeerIDFixture(), "10.0.0.1"))
+	require.NoError(l.admit(core.PeerIDFixture(), "10.0.0.1"))
+	requireStatus(t, http.StatusTooManyRequests, l.admit(core.PeerIDFixture(), "10.0.0.1"))
+	require.NoError(l.admit(core.PeerIDFixture(), "10.0.0.2"))
+}
+
+func TestAnnounceLimiterPenalizesRepeatedViolations(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	l := newAnnounceLimiter(RateLimitConfig{
+		Enabled:       true,
+		PeerRate:      1,
+		PeerBurst:     1,
+		MaxViolations: 3,
+		Penalty:       time.Minute,
+	}, clk)
+
+	peerID := core.PeerIDFixture()
+
+	require.NoError(l.admit(peerID, "10.0.0.1"))
+	for i := 0; i < 3; i++ {
+		requireStatus(t, http.StatusTooManyRequests, l.admit(peerID, "10.0.0.1"))
+	}
+	require.Len(l.denyList(), 1)
+
+	// Denied despite tokens being available.
+	clk.Add(30 * time.Second)
+	requireStatus(t, http.StatusForbidden, l.admit(peerID, "10.0.0.1"))
+
+	clk.Add(30 * time.Second)
+	require.NoError(l.admit(peerID, "10.0.0.1"))
+	require.Empty(l.denyList())
+}
+
+func TestAnnounceLimiterDenyList(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+
+	// The deny list applies even if rate limiting is disabled.
+	l := newAnnounceLimiter(RateLimitConfig{}, clk)
+
+	peerID := core.PeerIDFixture()
+
+	k, err := parseDenyKey(DenyIP, "10.0.0.1")
+	require.NoError(err)
+	l.addDeny(k, "bad", time.Minute)
+
+	requireStatus(t, http.StatusForbidden, l.admit(peerID, "10.0.0.1"))
+	require.NoError(l.admit(peerID, "10.0.0.2"))
+
+	require.True(l.removeDeny(k))
+	require.False(l.removeDeny(k))
+	require.NoError(l.admit(peerID, "10.0.0.1"))
+
+	k, err = parseDenyKey(DenyPeerID, peerID.String())
+	require.NoError(err)
+	l.addDeny(k, "bad", 0)
+
+	clk.Add(time.Hour)
+	requireStatus(t, http.StatusForbidden, l.admit(peerID, "10.0.0.2"))
+}
+
+func TestParseDenyKeyErrors(t *testing.T) {
+	tests := []struct {
+		kind  string
+		value string
+	}{
+		{DenyPeerID, "foo"},
+		{DenyIP, "foo"},
+		{"host", "foo"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s %s", test.kind, test.value), func(t *testing.T) {
+			_, err := parseDenyKey(test.kind, test.value)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestDenyListEndpoints(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	pctx := core.PeerContextFixture()
+	blob := core.NewBlobFixture()
+
+	_, err := httputil.Put(fmt.Sprintf(
+		"http://%s/denylist/peer_id/%s?ttl=1h&reason=looping", addr, pctx.PeerID))
+	require.NoError(err)
+
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/denylist", addr))
+	require.NoError(err)
+	defer resp.Body.Close()
+	var entries []DenyEntry
+	require.NoError(json.NewDecoder(resp.Body).Decode(&entries))
+	require.Len(entries, 1)
+	require.Equal(DenyPeerID, entries[0].Kind)
+	require.Equal(pctx.PeerID.String(), entries[0].Value)
+	require.Equal("looping", entries[0].Reason)
+	require.NotNil(entries[0].ExpiresAt)
+
+	client := newAnnounceClient(pctx, addr)
+	_, _, err = client.Announce(
+		core.TagFixture(), blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V2)
+	require.True(httputil.IsStatus(err, http.StatusForbidden))
+
+	_, err = httputil.Delete(fmt.Sprintf("http://%s/denylist/peer_id/%s", addr, pctx.PeerID))
+	require.NoError(err)
+
+	_, err = httputil.Delete(fmt.Sprintf("http://%s/denylist/peer_id/%s", addr, pctx.PeerID))
+	require.True(httputil.IsNotFound(err))
+
+	_, err = httputil.Put(fmt.Sprintf("http://%s/denylist/host/foo", addr))
+	require.True(httputil.IsStatus(err, http.StatusBadRequest))
+}
+
+func TestClientIP(t *testing.T) {
+	require := require.New(t)
+
+	r, err := http.NewRequest("GET", "/announce", nil)
+	require.NoError(err)
+	r.RemoteAddr = "127.0.0.1:5000"
+	require.Equal("127.0.0.1", clientIP(r))
+
+	r.Header.Set("X-Real-IP", "10.0.0.1")
+	require.Equal("10.0.0.1", clientIP(r))
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/tracker/peerstore"
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/httputil"
+)
+
+// _completionBuckets is the number of buckets in the completion histogram,
+// each covering an equal range of seeder ratios.
+const _completionBuckets = 10
+
+// SwarmScrape defines the stats of a single swarm.
+type SwarmScrape struct {
+	InfoHash core.InfoHash `json:"info_hash"`
+	Seeders  int           `json:"seeders"`
+	Leechers int           `json:"leechers"`
+
+	// Zones maps zones to the number of peers in them. Peers which announce
+	// without a zone are counted under the empty zone.
+	Zones map[string]int `json:"zones"`
+}
+
+// ClusterScrape defines the aggregate stats of all swarms in the peer store.
+type ClusterScrape struct {
+	Swarms   int `json:"swarms"`
+	Peers    int `json:"peers"`
+	Seeders  int `json:"seeders"`
+	Leechers int `json:"leechers"`
+
+	// CompletionHistogram counts swarms by their ratio of seeders to peers,
+	// in buckets of 10%. The last bucket includes fully seeded swarms.
+	CompletionHistogram []int `json:"completion_histogram"`
+
+	// Largest lists the largest swarms, by number of peers.
+	Largest []peerstore.SwarmStats `json:"largest"`
+}
+
+// completionBucket returns the histogram bucket of s.
+func completionBucket(s peerstore.SwarmStats) int {
+	if s.Peers() == 0 {
+		return 0
+	}
+	b := s.Seeders * _completionBuckets / s.Peers()
+	if b >= _completionBuckets {
+		b = _completionBuckets - 1
+	}
+	return b
+}
+
+// aggregateSwarms summarizes swarms, listing at most limit of the largest.
+func aggregateSwarms(swarms []peerstore.SwarmStats, limit int) *ClusterScrape {
+	c := &ClusterScrape{
+		Swarms:              len(swarms),
+		CompletionHistogram: make([]int, _completionBuckets),
+	}
+	for _, s := range swarms {
+		c.Peers += s.Peers()
+		c.Seeders += s.Seeders
+		c.Leechers += s.Leechers
+		c.CompletionHistogram[completionBucket(s)]++
+	}
+	largest := make([]peerstore.SwarmStats, len(swarms))
+	copy(largest, swarms)
+	sort.SliceStable(largest, func(i, j int) bool {
+		return largest[i].Peers() > largest[j].Peers()
+	})
+	if len(largest) > limit {
+		largest = largest[:limit]
+	}
+	c.Largest = largest
+	return c
+}
+
+// scrapeSwarmHandler returns the stats of the swarm of an info hash.
+func (s *Server) scrapeSwarmHandler(w http.ResponseWriter, r *http.Request) error {
+	infohash, err := httputil.ParseParam(r, "infohash")
+	if err != nil {
+		return err
+	}
+	h, err := core.NewInfoHashFromHex(infohash)
+	if err != nil {
+		return handler.Errorf("parse infohash: %s", err).Status(http.StatusBadRequest)
+	}
+	peers, err := s.peerStore.GetPeers(h, s.config.ScrapePeerLimit)
+	if err != nil {
+		return handler.Errorf("peer store: %s", err)
+	}
+	resp := &SwarmScrape{
+		InfoHash: h,
+		Zones:    make(map[string]int),
+	}
+	for _, p := range peers {
+		if p.Complete {
+			resp.Seeders++
+		} else {
+			resp.Leechers++
+		}
+		resp.Zones[p.Zone]++
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return handler.Errorf("json encode response: %s", err)
+	}
+	return nil
+}
+
+// scrapeClusterHandler returns the aggregate stats of all swarms. Requires a
+// peer store which can enumerate its swarms.
+func (s *Server) scrapeClusterHandler(w http.ResponseWriter, r *http.Request) error {
+	limit, err := strconv.Atoi(httputil.GetQueryArg(r, "limit", "100"))
+	if err != nil || limit < 0 {
+		return handler.Errorf("invalid limit").Status(http.StatusBadRequest)
+	}
+	scraper, ok := s.peerStore.(peerstore.Scraper)
+	if !ok {
+		return handler.Errorf("peer store does not support scraping").Status(http.StatusNotImplemented)
+	}
+	swarms, err := scraper.Swarms()
+	if err != nil {
+		return fmt.Errorf("peer store: %s", err)
+	}
+	if err := json.NewEncoder(w).Encode(aggregateSwarms(swarms, limit)); err != nil {
+		return handler.Errorf("json encode response: %s", err)
+	}
+	return nil
+}
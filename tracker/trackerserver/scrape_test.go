@@ -0,0 +1,109 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/tracker/originstore"
+	"github.com/uber/kraken/tracker/peerstore"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestAggregateSwarms(t *testing.T) {
+	require := require.New(t)
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+	h3 := core.InfoHashFixture()
+
+	swarms := []peerstore.SwarmStats{
+		{InfoHash: h1, Seeders: 0, Leechers: 4},
+		{InfoHash: h2, Seeders: 5, Leechers: 5},
+		{InfoHash: h3, Seeders: 2, Leechers: 0},
+	}
+
+	c := aggregateSwarms(swarms, 2)
+	require.Equal(3, c.Swarms)
+	require.Equal(16, c.Peers)
+	require.Equal(7, c.Seeders)
+	require.Equal(9, c.Leechers)
+	require.Equal([]int{1, 0, 0, 0, 0, 1, 0, 0, 0, 1}, c.CompletionHistogram)
+	require.Equal([]peerstore.SwarmStats{swarms[1], swarms[0]}, c.Largest)
+}
+
+func TestScrape(t *testing.T) {
+	require := require.New(t)
+
+	store := peerstore.NewTestStore()
+	s, err := New(Config{}, tally.NoopScope, nil, store, originstore.NewNoopStore(), nil)
+	require.NoError(err)
+
+	addr, stop := testutil.StartServer(s.Handler())
+	defer stop()
+
+	h := core.InfoHashFixture()
+
+	seeder := core.PeerInfoFixture()
+	seeder.Complete = true
+	seeder.Zone = "zone1"
+	leecher := core.PeerInfoFixture()
+	leecher.Zone = "zone2"
+	require.NoError(store.UpdatePeer(h, seeder))
+	require.NoError(store.UpdatePeer(h, leecher))
+	require.NoError(store.UpdatePeer(core.InfoHashFixture(), core.PeerInfoFixture()))
+
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/scrape/%s", addr, h.String()))
+	require.NoError(err)
+	defer resp.Body.Close()
+	var swarm SwarmScrape
+	require.NoError(json.NewDecoder(resp.Body).Decode(&swarm))
+	require.Equal(SwarmScrape{
+		InfoHash: h,
+		Seeders:  1,
+		Leechers: 1,
+		Zones:    map[string]int{"zone1": 1, "zone2": 1},
+	}, swarm)
+
+	resp, err = httputil.Get(fmt.Sprintf("http://%s/scrape?limit=1", addr))
+	require.NoError(err)
+	defer resp.Body.Close()
+	var cluster ClusterScrape
+	require.NoError(json.NewDecoder(resp.Body).Decode(&cluster))
+	require.Equal(2, cluster.Swarms)
+	require.Equal(3, cluster.Peers)
+	require.Equal(1, cluster.Seeders)
+	require.Equal([]peerstore.SwarmStats{{InfoHash: h, Seeders: 1, Leechers: 1}}, cluster.Largest)
+}
+
+func TestScrapeClusterUnsupportedPeerStore(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	_, err := httputil.Get(fmt.Sprintf("http://%s/scrape", addr))
+	require.True(httputil.IsStatus(err, http.StatusNotImplemented))
+}
@@ -18,10 +18,13 @@ import (
 	"net/http"
 	_ "net/http/pprof" // Registers /debug/pprof endpoints in http.DefaultServeMux.
 
+	"github.com/andres-erbsen/clock"
 	"github.com/pressly/chi"
 	chimiddleware "github.com/pressly/chi/middleware"
 	"github.com/uber-go/tally"
 
+	"github.com/uber/kraken/lib/backend"
+	"github.com/uber/kraken/lib/hashring"
 	"github.com/uber/kraken/lib/middleware"
 	"github.com/uber/kraken/origin/blobclient"
 	"github.com/uber/kraken/tracker/originstore"
@@ -41,6 +44,19 @@ type Server struct {
 	originStore originstore.Store
 	policy      *peerhandoutpolicy.PriorityPolicy
 
+	handoutLimits  *handoutLimits
+	originHandouts *originHandouts
+	limiter        *announceLimiter
+	auth           *authenticator
+
+	// Set if sharding is enabled.
+	addr   string
+	shards hashring.Ring
+
+	// Set if metainfo fallback is enabled.
+	backends         *backend.Manager
+	metaInfoFallback *metaInfoFallback
+
 	originCluster blobclient.ClusterClient
 }
 
@@ -51,22 +67,51 @@ func New(
 	policy *peerhandoutpolicy.PriorityPolicy,
 	peerStore peerstore.Store,
 	originStore originstore.Store,
-	originCluster blobclient.ClusterClient) *Server {
+	originCluster blobclient.ClusterClient,
+	opts ...Option) (*Server, error) {
 
 	config = config.applyDefaults()
 
+	handoutLimits, err := newHandoutLimits(config)
+	if err != nil {
+		return nil, fmt.Errorf("handout limits: %s", err)
+	}
+	originHandouts, err := newOriginHandouts(config.OriginHandouts)
+	if err != nil {
+		return nil, fmt.Errorf("origin handouts: %s", err)
+	}
+	auth, err := newAuthenticator(config.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("auth: %s", err)
+	}
+
 	stats = stats.Tagged(map[string]string{
 		"module": "trackerserver",
 	})
 
-	return &Server{
-		config:        config,
-		stats:         stats,
-		peerStore:     peerStore,
-		originStore:   originStore,
-		policy:        policy,
-		originCluster: originCluster,
+	s := &Server{
+		config:         config,
+		stats:          stats,
+		peerStore:      peerStore,
+		originStore:    originStore,
+		policy:         policy,
+		handoutLimits:  handoutLimits,
+		originHandouts: originHandouts,
+		limiter:        newAnnounceLimiter(config.RateLimit, clock.New()),
+		auth:           auth,
+		originCluster:  originCluster,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.backends != nil {
+		s.metaInfoFallback, err = newMetaInfoFallback(
+			config.MetaInfoFallback, stats, clock.New(), s.backends)
+		if err != nil {
+			return nil, fmt.Errorf("metainfo fallback: %s", err)
+		}
 	}
+	return s, nil
 }
 
 // Handler an http handler for s.
@@ -78,8 +123,20 @@ func (s *Server) Handler() http.Handler {
 
 	r.Get("/health", handler.Wrap(s.healthHandler))
 	r.Get("/announce", handler.Wrap(s.announceHandlerV1))
+	r.Post("/announce/batch", handler.Wrap(s.announceBatchHandler))
 	r.Post("/announce/{infohash}", handler.Wrap(s.announceHandlerV2))
 	r.Get("/namespace/{namespace}/blobs/{digest}/metainfo", handler.Wrap(s.getMetaInfoHandler))
+	r.Get("/scrape", handler.Wrap(s.scrapeClusterHandler))
+	r.Get("/scrape/{infohash}", handler.Wrap(s.scrapeSwarmHandler))
+	r.Get("/denylist", handler.Wrap(s.getDenyListHandler))
+	r.Put("/denylist/{kind}/{value}", handler.Wrap(s.addDenyHandler))
+	r.Delete("/denylist/{kind}/{value}", handler.Wrap(s.removeDenyHandler))
+	if s.config.Admin.Token != "" {
+		r.Mount("/admin", s.adminRouter())
+	}
+	if s.config.BitTorrent.Enabled {
+		r.Get("/bittorrent/announce", handler.Wrap(s.bitTorrentAnnounceHandler))
+	}
 
 	r.Mount("/debug", chimiddleware.Profiler())
 
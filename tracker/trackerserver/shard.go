@@ -0,0 +1,49 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"net/http"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/hashring"
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/stringset"
+)
+
+// Option allows setting optional Server parameters.
+type Option func(*Server)
+
+// WithShards shards swarms across the trackers of ring, where addr is the
+// address of s within ring. s then only serves announces for blobs which addr
+// owns, such that each swarm is tracked by a single tracker and trackers need
+// not share a peer store.
+func WithShards(addr string, ring hashring.Ring) Option {
+	return func(s *Server) {
+		s.addr = addr
+		s.shards = ring
+	}
+}
+
+// checkShard returns an error if s does not own d.
+func (s *Server) checkShard(d core.Digest) error {
+	if s.shards == nil {
+		return nil
+	}
+	if stringset.FromSlice(s.shards.Locations(d)).Has(s.addr) {
+		return nil
+	}
+	s.stats.Counter("misrouted_announces").Inc(1)
+	return handler.Errorf("%s does not own %s", s.addr, d).Status(http.StatusMisdirectedRequest)
+}
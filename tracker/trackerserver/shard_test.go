@@ -0,0 +1,140 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/hashring"
+	"github.com/uber/kraken/lib/hostlist"
+	"github.com/uber/kraken/mocks/lib/hashring"
+	"github.com/uber/kraken/tracker/announceclient"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+// staticPassiveRing is a PassiveRing whose locations are fixed by a mock.
+type staticPassiveRing struct {
+	hashring.Ring
+}
+
+func (r staticPassiveRing) Failed(addr string) {}
+
+type shardFixture struct {
+	ownerMocks   *serverMocks
+	ownerAddr    string
+	nonOwnerAddr string
+	clientRing   *mockhashring.MockRing
+}
+
+// newShardFixture starts a tracker which owns every blob and a tracker which
+// owns none, and routes clients to the non-owner first.
+func newShardFixture(t *testing.T) (*shardFixture, func()) {
+	ctrl := gomock.NewController(t)
+
+	ownerMocks, ownerCleanup := newServerMocks(t, Config{})
+	ownerAddr, ownerStop := testutil.StartServer(ownerMocks.handler())
+
+	nonOwnerMocks, nonOwnerCleanup := newServerMocks(t, Config{})
+	nonOwnerShard := mockhashring.NewMockRing(ctrl)
+	nonOwnerShard.EXPECT().Locations(gomock.Any()).Return([]string{ownerAddr}).AnyTimes()
+	nonOwnerAddr, nonOwnerStop := testutil.StartServer(
+		nonOwnerMocks.handler(WithShards("non-owner", nonOwnerShard)))
+
+	clientRing := mockhashring.NewMockRing(ctrl)
+	clientRing.EXPECT().Locations(gomock.Any()).Return(
+		[]string{nonOwnerAddr, ownerAddr}).AnyTimes()
+
+	return &shardFixture{ownerMocks, ownerAddr, nonOwnerAddr, clientRing}, func() {
+		nonOwnerStop()
+		ownerStop()
+		nonOwnerCleanup()
+		ownerCleanup()
+		ctrl.Finish()
+	}
+}
+
+func (f *shardFixture) client(pctx core.PeerContext) announceclient.Client {
+	return announceclient.New(pctx, staticPassiveRing{f.clientRing}, nil)
+}
+
+func (f *shardFixture) expectAnnounce(pctx core.PeerContext, blob *core.BlobFixture) {
+	f.ownerMocks.peerStore.EXPECT().UpdatePeer(
+		blob.MetaInfo.InfoHash(), core.PeerInfoFromContext(pctx, false)).Return(nil)
+	f.ownerMocks.peerStore.EXPECT().GetPeers(
+		blob.MetaInfo.InfoHash(), gomock.Any()).Return(nil, nil)
+	f.ownerMocks.originStore.EXPECT().GetOrigins(blob.Digest).Return(nil, nil)
+}
+
+func TestAnnounceRejectsUnownedBlobs(t *testing.T) {
+	f, cleanup := newShardFixture(t)
+	defer cleanup()
+
+	pctx := core.PeerContextFixture()
+	blob := core.NewBlobFixture()
+
+	client := announceclient.New(
+		pctx, hashring.NoopPassiveRing(hostlist.Fixture(f.nonOwnerAddr)), nil)
+
+	_, _, err := client.Announce(
+		core.TagFixture(), blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V2)
+	require.True(t, httputil.IsStatus(err, http.StatusMisdirectedRequest))
+}
+
+func TestAnnounceRetriesMisroutedAnnounceOnNextTracker(t *testing.T) {
+	f, cleanup := newShardFixture(t)
+	defer cleanup()
+
+	pctx := core.PeerContextFixture()
+	blob := core.NewBlobFixture()
+
+	f.expectAnnounce(pctx, blob)
+
+	_, _, err := f.client(pctx).Announce(
+		core.TagFixture(), blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V2)
+	require.NoError(t, err)
+}
+
+func TestBatchAnnounceRetriesMisroutedItemsOnNextTracker(t *testing.T) {
+	require := require.New(t)
+
+	f, cleanup := newShardFixture(t)
+	defer cleanup()
+
+	pctx := core.PeerContextFixture()
+
+	var items []announceclient.BatchItem
+	for i := 0; i < 2; i++ {
+		blob := core.NewBlobFixture()
+		f.expectAnnounce(pctx, blob)
+		items = append(items, announceclient.BatchItem{
+			Namespace: core.TagFixture(),
+			Digest:    blob.Digest,
+			InfoHash:  blob.MetaInfo.InfoHash(),
+		})
+	}
+
+	results, err := f.client(pctx).BatchAnnounce(items)
+	require.NoError(err)
+	require.Len(results, 2)
+	for _, r := range results {
+		require.NoError(r.Err())
+		require.False(r.Misrouted)
+	}
+}
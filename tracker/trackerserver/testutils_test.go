@@ -48,12 +48,17 @@ func newServerMocks(t *testing.T, config Config) (*serverMocks, func()) {
 	}, ctrl.Finish
 }
 
-func (m *serverMocks) handler() http.Handler {
-	return New(
+func (m *serverMocks) handler(opts ...Option) http.Handler {
+	s, err := New(
 		m.config,
 		m.stats,
 		m.policy,
 		m.peerStore,
 		m.originStore,
-		m.originCluster).Handler()
+		m.originCluster,
+		opts...)
+	if err != nil {
+		panic(err)
+	}
+	return s.Handler()
 }
@@ -0,0 +1,54 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"math"
+
+	"github.com/uber/kraken/core"
+)
+
+// selectZonePeers selects at most limit of peers, of which a remoteFraction
+// of limit are in zones other than zone and the rest in zone. Either group
+// fills the slots which the other cannot. Also returns the number of selected
+// peers in zone.
+func selectZonePeers(
+	zone string, peers []*core.PeerInfo, limit int, remoteFraction float64) ([]*core.PeerInfo, int) {
+
+	var local, remote []*core.PeerInfo
+	for _, p := range peers {
+		if p.Zone == zone {
+			local = append(local, p)
+		} else {
+			remote = append(remote, p)
+		}
+	}
+	numRemote := int(math.Ceil(float64(limit) * remoteFraction))
+	if numRemote > len(remote) {
+		numRemote = len(remote)
+	}
+	numLocal := limit - numRemote
+	if numLocal > len(local) {
+		numLocal = len(local)
+	}
+	// Fill remaining slots with remote peers.
+	numRemote = limit - numLocal
+	if numRemote > len(remote) {
+		numRemote = len(remote)
+	}
+	selected := make([]*core.PeerInfo, 0, numLocal+numRemote)
+	selected = append(selected, local[:numLocal]...)
+	selected = append(selected, remote[:numRemote]...)
+	return selected, numLocal
+}
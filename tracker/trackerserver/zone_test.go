@@ -0,0 +1,85 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"testing"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/stretchr/testify/require"
+)
+
+func zonePeersFixture(zone string, n int) []*core.PeerInfo {
+	var peers []*core.PeerInfo
+	for i := 0; i < n; i++ {
+		p := core.PeerInfoFixture()
+		p.Zone = zone
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+func countZone(peers []*core.PeerInfo, zone string) int {
+	var n int
+	for _, p := range peers {
+		if p.Zone == zone {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSelectZonePeers(t *testing.T) {
+	tests := []struct {
+		desc           string
+		numLocal       int
+		numRemote      int
+		limit          int
+		remoteFraction float64
+		expectedLocal  int
+		expectedRemote int
+	}{
+		{"mix", 20, 20, 10, 0.2, 8, 2},
+		{"remote fraction rounds up", 20, 20, 10, 0.15, 8, 2},
+		{"few local peers", 3, 20, 10, 0.2, 3, 7},
+		{"few remote peers", 20, 1, 10, 0.2, 9, 1},
+		{"fewer peers than limit", 2, 2, 10, 0.2, 2, 2},
+		{"no remote peers", 20, 0, 10, 0.2, 10, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			peers := append(
+				zonePeersFixture("zone1", test.numLocal),
+				zonePeersFixture("zone2", test.numRemote)...)
+
+			selected, local := selectZonePeers("zone1", peers, test.limit, test.remoteFraction)
+			require.Equal(test.expectedLocal, local)
+			require.Equal(test.expectedLocal, countZone(selected, "zone1"))
+			require.Equal(test.expectedRemote, countZone(selected, "zone2"))
+		})
+	}
+}
+
+func TestSelectZonePeersTreatsUnlabeledPeersAsRemote(t *testing.T) {
+	require := require.New(t)
+
+	peers := append(zonePeersFixture("zone1", 5), zonePeersFixture("", 5)...)
+
+	selected, local := selectZonePeers("zone1", peers, 4, 0.5)
+	require.Equal(2, local)
+	require.Equal(2, countZone(selected, ""))
+}
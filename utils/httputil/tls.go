@@ -98,6 +98,43 @@ func (c *TLSConfig) BuildClient() (*tls.Config, error) {
 	return c.tls, nil
 }
 
+// BuildServer builds tls.Config for servers. If CAs are configured, clients are
+// required to present a certificate signed by one of them.
+func (c *TLSConfig) BuildServer() (*tls.Config, error) {
+	if c.Server.Disabled {
+		log.Infof("Server TLS is disabled")
+		return nil, nil
+	}
+	if c.Server.Cert.Path == "" {
+		return nil, errors.New("server cert not configured")
+	}
+	certPEM, err := parseCert(c.Server.Cert.Path)
+	if err != nil {
+		return nil, fmt.Errorf("parse server cert: %s", err)
+	}
+	keyPEM, err := parseKey(c.Server.Key.Path, c.Server.Passphrase.Path)
+	if err != nil {
+		return nil, fmt.Errorf("parse server key: %s", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("load server x509 key pair: %s", err)
+	}
+	config := &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		PreferServerCipherSuites: true,
+	}
+	if len(c.CAs) > 0 {
+		caPool, err := createCertPool(c.CAs)
+		if err != nil {
+			return nil, fmt.Errorf("create cert pool: %s", err)
+		}
+		config.ClientCAs = caPool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
 // WriteCABundle writes a list of CA to a writer.
 func (c *TLSConfig) WriteCABundle(w io.Writer) error {
 	pems, err := concatSecrets(c.CAs)
@@ -253,3 +253,18 @@ func TestTLSClientFallbackError(t *testing.T) {
 	_, err = Get("https://some-non-existent-addr/", SendTLS(tls))
 	require.Error(err)
 }
+
+func TestTLSServerDisabled(t *testing.T) {
+	require := require.New(t)
+	c := TLSConfig{}
+	c.Server.Disabled = true
+	tls, err := c.BuildServer()
+	require.NoError(err)
+	require.Nil(tls)
+}
+
+func TestTLSServerRequiresCert(t *testing.T) {
+	c := TLSConfig{}
+	_, err := c.BuildServer()
+	require.Error(t, err)
+}
@@ -64,13 +64,15 @@ func GetIP(host string) (net.IP, error) {
 	return nil, errors.New("no ips found")
 }
 
-// GetLocalIP returns the ip address of the local machine.
+// GetLocalIP returns the ip address of the local machine. IPv4 addresses are
+// preferred, falling back to global unicast IPv6 addresses on IPv6-only hosts.
 func GetLocalIP() (string, error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		return "", fmt.Errorf("interfaces: %s", err)
 	}
 	ips := map[string]string{}
+	ipv6s := map[string]string{}
 	for _, i := range ifaces {
 		addrs, err := i.Addrs()
 		if err != nil {
@@ -87,11 +89,13 @@ func GetLocalIP() (string, error) {
 			if ip == nil || ip.IsLoopback() {
 				continue
 			}
-			ip = ip.To4()
-			if ip == nil {
+			if ip.To4() == nil {
+				if _, ok := ipv6s[i.Name]; !ok && ip.IsGlobalUnicast() {
+					ipv6s[i.Name] = ip.String()
+				}
 				continue
 			}
-			ips[i.Name] = ip.String()
+			ips[i.Name] = ip.To4().String()
 			break
 		}
 	}
@@ -100,5 +104,10 @@ func GetLocalIP() (string, error) {
 			return ip, nil
 		}
 	}
+	for _, i := range _supportedInterfaces {
+		if ip, ok := ipv6s[i]; ok {
+			return ip, nil
+		}
+	}
 	return "", errors.New("no ip found")
 }